@@ -24,6 +24,7 @@ type ClusterAdmin interface {
 	ListTopics() (map[string]TopicDetail, error)
 
 	// Describe some topics in the cluster.
+	// From Kafka 3.8.0.0 the partitions are described by pages with DescribeTopicPartitions.
 	DescribeTopics(topics []string) (metadata []*TopicMetadata, err error)
 
 	// Delete a topic. It may take several seconds after the DeleteTopic to returns success
@@ -63,6 +64,15 @@ type ClusterAdmin interface {
 	// This operation is supported by brokers with version 0.11.0.0 or higher.
 	DescribeConfig(resource ConfigResource) ([]ConfigEntry, error)
 
+	// DescribeConfigWithOptions gets the configuration for the specified
+	// resource like DescribeConfig, with the synonyms and the documentation of
+	// the config entries if requested by options. The Source of the entries
+	// tells where their value comes from, as a topic override or a static
+	// broker config, and their Synonyms list the configs which may set it, in
+	// order of precedence. The synonyms require brokers with version 1.1.0.0
+	// or higher, and the types and documentation 2.6.0.0 or higher.
+	DescribeConfigWithOptions(resource ConfigResource, options DescribeConfigOptions) ([]ConfigEntry, error)
+
 	// Update the configuration for the specified resources with the default options.
 	// This operation is supported by brokers with version 0.11.0.0 or higher.
 	// The resources with their configs (topic is the only resource type with configs
@@ -108,6 +118,11 @@ type ClusterAdmin interface {
 	// List the consumer group offsets available in the cluster.
 	ListConsumerGroupOffsets(group string, topicPartitions map[string][]int32) (*OffsetFetchResponse, error)
 
+	// ListConsumerGroupPartitionOffsets is ListConsumerGroupOffsets for the
+	// given partitions, or all the partitions of the group if none, returning
+	// the offsets by partition. The error of the group is returned.
+	ListConsumerGroupPartitionOffsets(group string, partitions []TopicPartitionID) (map[TopicPartitionID]*OffsetFetchResponseBlock, error)
+
 	// Deletes a consumer group offset
 	DeleteConsumerGroupOffset(group string, topic string, partition int32) error
 
@@ -213,6 +228,10 @@ func (ca *clusterAdmin) retryOnError(retryable func(error) bool, fn func() error
 		if err == nil || attemptsRemaining <= 0 || !retryable(err) {
 			return err
 		}
+		if !retryBudgetOf(ca.client).allow(anyBrokerID) {
+			Logger.Println("admin/request not retrying because the retry budget is exhausted")
+			return err
+		}
 		Logger.Printf(
 			"admin/request retrying after %dms... (%d attempts remaining)\n",
 			ca.conf.Admin.Retry.Backoff/time.Millisecond, attemptsRemaining)
@@ -220,7 +239,9 @@ func (ca *clusterAdmin) retryOnError(retryable func(error) bool, fn func() error
 	}
 }
 
-func (ca *clusterAdmin) CreateTopic(topic string, detail *TopicDetail, validateOnly bool) error {
+func (ca *clusterAdmin) CreateTopic(topic string, detail *TopicDetail, validateOnly bool) (err error) {
+	defer func() { ca.auditAdmin("CreateTopic", validateOnly, err, topic) }()
+
 	if topic == "" {
 		return ErrInvalidTopic
 	}
@@ -277,6 +298,10 @@ func (ca *clusterAdmin) CreateTopic(topic string, detail *TopicDetail, validateO
 }
 
 func (ca *clusterAdmin) DescribeTopics(topics []string) (metadata []*TopicMetadata, err error) {
+	if ca.conf.Version.IsAtLeast(V3_8_0_0) {
+		return ca.describeTopicPartitions(topics)
+	}
+
 	var response *MetadataResponse
 	err = ca.retryOnError(isErrNoController, func() error {
 		controller, err := ca.Controller()
@@ -296,6 +321,53 @@ func (ca *clusterAdmin) DescribeTopics(topics []string) (metadata []*TopicMetada
 	return response.Topics, nil
 }
 
+// describeTopicPartitionsLimit is the maximum number of partitions described
+// by each DescribeTopicPartitionsRequest.
+const describeTopicPartitionsLimit = 2000
+
+// describeTopicPartitions describes the topics with DescribeTopicPartitions,
+// requesting the pages of partitions until the cursor is exhausted.
+func (ca *clusterAdmin) describeTopicPartitions(topics []string) (metadata []*TopicMetadata, err error) {
+	byName := make(map[string]*TopicMetadata)
+	request := &DescribeTopicPartitionsRequest{
+		Topics:                 topics,
+		ResponsePartitionLimit: describeTopicPartitionsLimit,
+	}
+	for {
+		var response *DescribeTopicPartitionsResponse
+		err = ca.retryOnError(isErrNoController, func() error {
+			controller, err := ca.Controller()
+			if err != nil {
+				return err
+			}
+			response, err = controller.DescribeTopicPartitions(request)
+			if isErrNoController(err) {
+				_, _ = ca.refreshController()
+			}
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, topic := range response.Topics {
+			topicMetadata := topic.topicMetadata()
+			// The partitions of a topic may be split across pages
+			if previous, ok := byName[topicMetadata.Name]; ok {
+				previous.Partitions = append(previous.Partitions, topicMetadata.Partitions...)
+				continue
+			}
+			byName[topicMetadata.Name] = topicMetadata
+			metadata = append(metadata, topicMetadata)
+		}
+
+		if response.NextCursor == nil {
+			return metadata, nil
+		}
+		request.Cursor = response.NextCursor
+	}
+}
+
 func (ca *clusterAdmin) DescribeCluster() (brokers []*Broker, controllerID int32, err error) {
 	var response *MetadataResponse
 	err = ca.retryOnError(isErrNoController, func() error {
@@ -315,6 +387,9 @@ func (ca *clusterAdmin) DescribeCluster() (brokers []*Broker, controllerID int32
 		return nil, int32(0), err
 	}
 
+	for _, broker := range response.Brokers {
+		selectBrokerAddress(ca.conf, broker)
+	}
 	return response.Brokers, response.ControllerID, nil
 }
 
@@ -418,7 +493,9 @@ func (ca *clusterAdmin) ListTopics() (map[string]TopicDetail, error) {
 	return topicsDetailsMap, nil
 }
 
-func (ca *clusterAdmin) DeleteTopic(topic string) error {
+func (ca *clusterAdmin) DeleteTopic(topic string) (err error) {
+	defer func() { ca.auditAdmin("DeleteTopic", false, err, topic) }()
+
 	if topic == "" {
 		return ErrInvalidTopic
 	}
@@ -464,7 +541,9 @@ func (ca *clusterAdmin) DeleteTopic(topic string) error {
 	})
 }
 
-func (ca *clusterAdmin) CreatePartitions(topic string, count int32, assignment [][]int32, validateOnly bool) error {
+func (ca *clusterAdmin) CreatePartitions(topic string, count int32, assignment [][]int32, validateOnly bool) (err error) {
+	defer func() { ca.auditAdmin("CreatePartitions", validateOnly, err, topic) }()
+
 	if topic == "" {
 		return ErrInvalidTopic
 	}
@@ -508,7 +587,9 @@ func (ca *clusterAdmin) CreatePartitions(topic string, count int32, assignment [
 	})
 }
 
-func (ca *clusterAdmin) AlterPartitionReassignments(topic string, assignment [][]int32) error {
+func (ca *clusterAdmin) AlterPartitionReassignments(topic string, assignment [][]int32) (err error) {
+	defer func() { ca.auditAdmin("AlterPartitionReassignments", false, err, topic) }()
+
 	if topic == "" {
 		return ErrInvalidTopic
 	}
@@ -590,7 +671,9 @@ func (ca *clusterAdmin) ListPartitionReassignments(topic string, partitions []in
 	}
 }
 
-func (ca *clusterAdmin) DeleteRecords(topic string, partitionOffsets map[int32]int64) error {
+func (ca *clusterAdmin) DeleteRecords(topic string, partitionOffsets map[int32]int64) (err error) {
+	defer func() { ca.auditAdmin("DeleteRecords", false, err, topic) }()
+
 	if topic == "" {
 		return ErrInvalidTopic
 	}
@@ -654,13 +737,28 @@ func dependsOnSpecificNode(resource ConfigResource) bool {
 		resource.Type == BrokerLoggerResource
 }
 
+// DescribeConfigOptions are the options of
+// ClusterAdmin.DescribeConfigWithOptions.
+type DescribeConfigOptions struct {
+	// IncludeSynonyms returns the synonyms of the config entries.
+	IncludeSynonyms bool
+	// IncludeDocumentation returns the documentation of the config entries.
+	IncludeDocumentation bool
+}
+
 func (ca *clusterAdmin) DescribeConfig(resource ConfigResource) ([]ConfigEntry, error) {
+	return ca.DescribeConfigWithOptions(resource, DescribeConfigOptions{})
+}
+
+func (ca *clusterAdmin) DescribeConfigWithOptions(resource ConfigResource, options DescribeConfigOptions) ([]ConfigEntry, error) {
 	var entries []ConfigEntry
 	var resources []*ConfigResource
 	resources = append(resources, &resource)
 
 	request := &DescribeConfigsRequest{
-		Resources: resources,
+		Resources:            resources,
+		IncludeSynonyms:      options.IncludeSynonyms,
+		IncludeDocumentation: options.IncludeDocumentation,
 	}
 
 	if ca.conf.Version.IsAtLeast(V1_1_0_0) {
@@ -671,6 +769,10 @@ func (ca *clusterAdmin) DescribeConfig(resource ConfigResource) ([]ConfigEntry,
 		request.Version = 2
 	}
 
+	if ca.conf.Version.IsAtLeast(V2_6_0_0) {
+		request.Version = 3
+	}
+
 	var (
 		b   *Broker
 		err error
@@ -713,7 +815,9 @@ func (ca *clusterAdmin) DescribeConfig(resource ConfigResource) ([]ConfigEntry,
 	return entries, nil
 }
 
-func (ca *clusterAdmin) AlterConfig(resourceType ConfigResourceType, name string, entries map[string]*string, validateOnly bool) error {
+func (ca *clusterAdmin) AlterConfig(resourceType ConfigResourceType, name string, entries map[string]*string, validateOnly bool) (err error) {
+	defer func() { ca.auditAdmin("AlterConfig", validateOnly, err, name) }()
+
 	var resources []*AlterConfigsResource
 	resources = append(resources, &AlterConfigsResource{
 		Type:          resourceType,
@@ -729,10 +833,7 @@ func (ca *clusterAdmin) AlterConfig(resourceType ConfigResourceType, name string
 		request.Version = 1
 	}
 
-	var (
-		b   *Broker
-		err error
-	)
+	var b *Broker
 
 	// AlterConfig of broker/broker logger must be sent to the broker in question
 	if dependsOnSpecificNode(ConfigResource{Name: name, Type: resourceType}) {
@@ -768,7 +869,9 @@ func (ca *clusterAdmin) AlterConfig(resourceType ConfigResourceType, name string
 	return nil
 }
 
-func (ca *clusterAdmin) IncrementalAlterConfig(resourceType ConfigResourceType, name string, entries map[string]IncrementalAlterConfigsEntry, validateOnly bool) error {
+func (ca *clusterAdmin) IncrementalAlterConfig(resourceType ConfigResourceType, name string, entries map[string]IncrementalAlterConfigsEntry, validateOnly bool) (err error) {
+	defer func() { ca.auditAdmin("IncrementalAlterConfig", validateOnly, err, name) }()
+
 	var resources []*IncrementalAlterConfigsResource
 	resources = append(resources, &IncrementalAlterConfigsResource{
 		Type:          resourceType,
@@ -781,10 +884,7 @@ func (ca *clusterAdmin) IncrementalAlterConfig(resourceType ConfigResourceType,
 		ValidateOnly: validateOnly,
 	}
 
-	var (
-		b   *Broker
-		err error
-	)
+	var b *Broker
 
 	// AlterConfig of broker/broker logger must be sent to the broker in question
 	if dependsOnSpecificNode(ConfigResource{Name: name, Type: resourceType}) {
@@ -820,7 +920,9 @@ func (ca *clusterAdmin) IncrementalAlterConfig(resourceType ConfigResourceType,
 	return nil
 }
 
-func (ca *clusterAdmin) CreateACL(resource Resource, acl Acl) error {
+func (ca *clusterAdmin) CreateACL(resource Resource, acl Acl) (err error) {
+	defer func() { ca.auditAdmin("CreateACL", false, err, resource.ResourceName) }()
+
 	var acls []*AclCreation
 	acls = append(acls, &AclCreation{resource, acl})
 	request := &CreateAclsRequest{AclCreations: acls}
@@ -838,7 +940,9 @@ func (ca *clusterAdmin) CreateACL(resource Resource, acl Acl) error {
 	return err
 }
 
-func (ca *clusterAdmin) CreateACLs(resourceACLs []*ResourceAcls) error {
+func (ca *clusterAdmin) CreateACLs(resourceACLs []*ResourceAcls) (err error) {
+	defer func() { ca.auditAdmin("CreateACLs", false, err, aclResourceNames(resourceACLs)...) }()
+
 	var acls []*AclCreation
 	for _, resourceACL := range resourceACLs {
 		for _, acl := range resourceACL.Acls {
@@ -884,7 +988,15 @@ func (ca *clusterAdmin) ListAcls(filter AclFilter) ([]ResourceAcls, error) {
 	return lAcls, nil
 }
 
-func (ca *clusterAdmin) DeleteACL(filter AclFilter, validateOnly bool) ([]MatchingAcl, error) {
+func (ca *clusterAdmin) DeleteACL(filter AclFilter, validateOnly bool) (_ []MatchingAcl, err error) {
+	defer func() {
+		var name string
+		if filter.ResourceName != nil {
+			name = *filter.ResourceName
+		}
+		ca.auditAdmin("DeleteACL", validateOnly, err, name)
+	}()
+
 	var filters []*AclFilter
 	filters = append(filters, &filter)
 	request := &DeleteAclsRequest{Filters: filters}
@@ -924,6 +1036,21 @@ func (ca *clusterAdmin) DescribeConsumerGroups(groups []string) (result []*Group
 	}
 
 	for broker, brokerGroups := range groupsPerBroker {
+		if ca.conf.Version.IsAtLeast(V4_0_0_0) {
+			// Groups using the consumer group protocol of KIP-848 are only
+			// described by ConsumerGroupDescribe, the classic ones are left
+			// to DescribeGroups.
+			var consumerGroups []*GroupDescription
+			consumerGroups, brokerGroups, err = ca.describeConsumerProtocolGroups(broker, brokerGroups)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, consumerGroups...)
+			if len(brokerGroups) == 0 {
+				continue
+			}
+		}
+
 		describeReq := &DescribeGroupsRequest{
 			Groups: brokerGroups,
 		}
@@ -951,6 +1078,35 @@ func (ca *clusterAdmin) DescribeConsumerGroups(groups []string) (result []*Group
 	return result, nil
 }
 
+// describeConsumerProtocolGroups describes the groups using the consumer group
+// protocol with ConsumerGroupDescribe, and returns the remaining classic groups.
+func (ca *clusterAdmin) describeConsumerProtocolGroups(broker *Broker, groups []string) ([]*GroupDescription, []string, error) {
+	response, err := broker.ConsumerGroupDescribe(&ConsumerGroupDescribeRequest{
+		GroupIDs: groups,
+	})
+	if errors.Is(err, ErrUnsupportedVersion) {
+		return nil, groups, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var result []*GroupDescription
+	var classicGroups []string
+	for _, group := range response.Groups {
+		if group.Err == ErrGroupIDNotFound {
+			classicGroups = append(classicGroups, group.GroupID)
+			continue
+		}
+		description, err := group.groupDescription()
+		if err != nil {
+			return nil, nil, err
+		}
+		result = append(result, description)
+	}
+	return result, classicGroups, nil
+}
+
 func (ca *clusterAdmin) ListConsumerGroups() (allGroups map[string]string, err error) {
 	allGroups = make(map[string]string)
 
@@ -967,7 +1123,10 @@ func (ca *clusterAdmin) ListConsumerGroups() (allGroups map[string]string, err e
 			_ = b.Open(conf) // Ensure that broker is opened
 
 			request := &ListGroupsRequest{}
-			if ca.conf.Version.IsAtLeast(V2_6_0_0) {
+			if ca.conf.Version.IsAtLeast(V3_8_0_0) {
+				// Version 5 adds the TypesFilter field (KIP-848).
+				request.Version = 5
+			} else if ca.conf.Version.IsAtLeast(V2_6_0_0) {
 				// Version 4 adds the StatesFilter field (KIP-518).
 				request.Version = 4
 			} else if ca.conf.Version.IsAtLeast(V2_4_0_0) {
@@ -1050,7 +1209,31 @@ func (ca *clusterAdmin) ListConsumerGroupOffsets(group string, topicPartitions m
 	return coordinator.FetchOffset(request)
 }
 
-func (ca *clusterAdmin) DeleteConsumerGroupOffset(group string, topic string, partition int32) error {
+func (ca *clusterAdmin) ListConsumerGroupPartitionOffsets(group string, partitions []TopicPartitionID) (map[TopicPartitionID]*OffsetFetchResponseBlock, error) {
+	var topicPartitions map[string][]int32
+	if len(partitions) > 0 {
+		topicPartitions = TopicPartitionMap(partitions)
+	}
+	response, err := ca.ListConsumerGroupOffsets(group, topicPartitions)
+	if err != nil {
+		return nil, err
+	}
+	if !errors.Is(response.Err, ErrNoError) {
+		return nil, response.Err
+	}
+
+	offsets := make(map[TopicPartitionID]*OffsetFetchResponseBlock)
+	for topic, blocks := range response.Blocks {
+		for partition, block := range blocks {
+			offsets[TopicPartitionID{Topic: topic, Partition: partition}] = block
+		}
+	}
+	return offsets, nil
+}
+
+func (ca *clusterAdmin) DeleteConsumerGroupOffset(group string, topic string, partition int32) (err error) {
+	defer func() { ca.auditAdmin("DeleteConsumerGroupOffset", false, err, group) }()
+
 	coordinator, err := ca.client.Coordinator(group)
 	if err != nil {
 		return err
@@ -1078,7 +1261,9 @@ func (ca *clusterAdmin) DeleteConsumerGroupOffset(group string, topic string, pa
 	return nil
 }
 
-func (ca *clusterAdmin) DeleteConsumerGroup(group string) error {
+func (ca *clusterAdmin) DeleteConsumerGroup(group string) (err error) {
+	defer func() { ca.auditAdmin("DeleteConsumerGroup", false, err, group) }()
+
 	coordinator, err := ca.client.Coordinator(group)
 	if err != nil {
 		return err
@@ -1196,7 +1381,9 @@ func (ca *clusterAdmin) DeleteUserScramCredentials(delete []AlterUserScramCreden
 	return res, nil
 }
 
-func (ca *clusterAdmin) AlterUserScramCredentials(u []AlterUserScramCredentialsUpsert, d []AlterUserScramCredentialsDelete) ([]*AlterUserScramCredentialsResult, error) {
+func (ca *clusterAdmin) AlterUserScramCredentials(u []AlterUserScramCredentialsUpsert, d []AlterUserScramCredentialsDelete) (_ []*AlterUserScramCredentialsResult, err error) {
+	defer func() { ca.auditAdmin("AlterUserScramCredentials", false, err, scramUserNames(u, d)...) }()
+
 	req := &AlterUserScramCredentialsRequest{
 		Deletions:  d,
 		Upsertions: u,
@@ -1244,7 +1431,9 @@ func (ca *clusterAdmin) DescribeClientQuotas(components []QuotaFilterComponent,
 	return rsp.Entries, nil
 }
 
-func (ca *clusterAdmin) AlterClientQuotas(entity []QuotaEntityComponent, op ClientQuotasOp, validateOnly bool) error {
+func (ca *clusterAdmin) AlterClientQuotas(entity []QuotaEntityComponent, op ClientQuotasOp, validateOnly bool) (err error) {
+	defer func() { ca.auditAdmin("AlterClientQuotas", validateOnly, err, quotaEntityName(entity)) }()
+
 	entry := AlterClientQuotasEntry{
 		Entity: entity,
 		Ops:    []ClientQuotasOp{op},
@@ -1277,7 +1466,9 @@ func (ca *clusterAdmin) AlterClientQuotas(entity []QuotaEntityComponent, op Clie
 	return nil
 }
 
-func (ca *clusterAdmin) RemoveMemberFromConsumerGroup(groupId string, groupInstanceIds []string) (*LeaveGroupResponse, error) {
+func (ca *clusterAdmin) RemoveMemberFromConsumerGroup(groupId string, groupInstanceIds []string) (_ *LeaveGroupResponse, err error) {
+	defer func() { ca.auditAdmin("RemoveMemberFromConsumerGroup", false, err, groupId) }()
+
 	if !ca.conf.Version.IsAtLeast(V2_4_0_0) {
 		return nil, ConfigurationError("Removing members from a consumer group headers requires Kafka version of at least v2.4.0")
 	}
@@ -0,0 +1,267 @@
+package sarama
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// BulkOptions controls how the operations of a BulkClusterAdmin are split
+// into requests. The requests sent to a broker which throttled the client wait
+// for the throttle time it returned, as all the requests of the client do.
+type BulkOptions struct {
+	// BatchSize is the maximum number of topics or resources of each request,
+	// so that the requests stay within the request size limits of the
+	// brokers (default 100).
+	BatchSize int
+	// Parallelism is the maximum number of requests sent at the same time
+	// (default 1). The requests to the same broker are still sent one at a
+	// time on its connection.
+	Parallelism int
+	// ValidateOnly validates the operations without applying them.
+	ValidateOnly bool
+	// Progress, if set, is called after each request with the number of
+	// topics or resources handled so far and their total number. The calls
+	// are never concurrent.
+	Progress func(done, total int)
+}
+
+const defaultBulkBatchSize = 100
+
+// BulkError is the error returned by the operations of a BulkClusterAdmin
+// when some of the topics or resources failed. It maps their names to their
+// errors; the others succeeded.
+type BulkError map[string]error
+
+func (e BulkError) Error() string {
+	names := make([]string, 0, len(e))
+	for name := range e {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	failures := make([]string, 0, len(names))
+	for _, name := range names {
+		failures = append(failures, fmt.Sprintf("%s: %v", name, e[name]))
+	}
+	return fmt.Sprintf("kafka: %d operations failed: %s", len(e), strings.Join(failures, "; "))
+}
+
+// BulkClusterAdmin provisions many topics or configs at once. It is
+// implemented by the ClusterAdmin returned by NewClusterAdmin and
+// NewClusterAdminFromClient:
+//
+//	bulk, ok := admin.(sarama.BulkClusterAdmin)
+type BulkClusterAdmin interface {
+	// CreateTopics creates the topics, by name, in requests of at most
+	// options.BatchSize topics sent to the controller. It returns a BulkError
+	// holding the topics which could not be created.
+	CreateTopics(topics map[string]*TopicDetail, options *BulkOptions) error
+
+	// AlterConfigs replaces the config entries of the resources of the given
+	// type, by name, in requests of at most options.BatchSize resources. The
+	// broker and broker logger resources are sent one at a time to their
+	// broker. It returns a BulkError holding the resources which could not be
+	// altered.
+	AlterConfigs(resourceType ConfigResourceType, configs map[string]map[string]*string, options *BulkOptions) error
+}
+
+// bulk runs do on the names in batches, with the parallelism of options, and
+// returns the errors do returned as a BulkError.
+func bulk(names []string, options *BulkOptions, batchSize int, do func(batch []string) map[string]error) error {
+	var opts BulkOptions
+	if options != nil {
+		opts = *options
+	}
+	if batchSize <= 0 {
+		batchSize = opts.BatchSize
+	}
+	if batchSize <= 0 {
+		batchSize = defaultBulkBatchSize
+	}
+	if opts.Parallelism <= 0 {
+		opts.Parallelism = 1
+	}
+	sort.Strings(names)
+
+	var (
+		lock     sync.Mutex
+		wg       sync.WaitGroup
+		done     int
+		failures = make(BulkError)
+		slots    = make(chan none, opts.Parallelism)
+	)
+	for start := 0; start < len(names); start += batchSize {
+		end := start + batchSize
+		if end > len(names) {
+			end = len(names)
+		}
+		batch := names[start:end]
+
+		slots <- none{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs := do(batch)
+			lock.Lock()
+			defer lock.Unlock()
+			for name, err := range errs {
+				failures[name] = err
+			}
+			done += len(batch)
+			if opts.Progress != nil {
+				opts.Progress(done, len(names))
+			}
+			<-slots
+		}()
+	}
+	wg.Wait()
+
+	if len(failures) > 0 {
+		return failures
+	}
+	return nil
+}
+
+// failAll returns err as the error of every name of batch.
+func failAll(batch []string, err error) map[string]error {
+	errs := make(map[string]error, len(batch))
+	for _, name := range batch {
+		errs[name] = err
+	}
+	return errs
+}
+
+func (ca *clusterAdmin) CreateTopics(topics map[string]*TopicDetail, options *BulkOptions) (err error) {
+	names := make([]string, 0, len(topics))
+	for name, detail := range topics {
+		if name == "" {
+			return ErrInvalidTopic
+		}
+		if detail == nil {
+			return fmt.Errorf("you must specify topic details for %s", name)
+		}
+		names = append(names, name)
+	}
+	defer func() { ca.auditAdmin("CreateTopics", options != nil && options.ValidateOnly, err, names...) }()
+
+	return bulk(names, options, 0, func(batch []string) map[string]error {
+		request := &CreateTopicsRequest{
+			TopicDetails: make(map[string]*TopicDetail, len(batch)),
+			ValidateOnly: options != nil && options.ValidateOnly,
+			Timeout:      ca.conf.Admin.Timeout,
+		}
+		for _, name := range batch {
+			request.TopicDetails[name] = topics[name]
+		}
+		if ca.conf.Version.IsAtLeast(V2_0_0_0) {
+			request.Version = 3
+		} else if ca.conf.Version.IsAtLeast(V0_11_0_0) {
+			request.Version = 2
+		} else if ca.conf.Version.IsAtLeast(V0_10_2_0) {
+			request.Version = 1
+		}
+
+		var errs map[string]error
+		err := ca.retryOnError(isErrNoController, func() error {
+			b, err := ca.Controller()
+			if err != nil {
+				return err
+			}
+			rsp, err := b.CreateTopics(request)
+			if err != nil {
+				return err
+			}
+
+			errs = make(map[string]error)
+			for _, name := range batch {
+				topicErr, ok := rsp.TopicErrors[name]
+				if !ok {
+					errs[name] = ErrIncompleteResponse
+					continue
+				}
+				if errors.Is(topicErr.Err, ErrNotController) {
+					// the whole batch is retried on the new controller
+					_, _ = ca.refreshController()
+					return ErrNotController
+				}
+				if !errors.Is(topicErr.Err, ErrNoError) {
+					errs[name] = topicErr
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return failAll(batch, err)
+		}
+		return errs
+	})
+}
+
+func (ca *clusterAdmin) AlterConfigs(resourceType ConfigResourceType, configs map[string]map[string]*string, options *BulkOptions) (err error) {
+	names := make([]string, 0, len(configs))
+	for name := range configs {
+		names = append(names, name)
+	}
+	defer func() { ca.auditAdmin("AlterConfigs", options != nil && options.ValidateOnly, err, names...) }()
+
+	batchSize := 0
+	if dependsOnSpecificNode(ConfigResource{Type: resourceType, Name: "any"}) {
+		// each resource goes to its own broker
+		batchSize = 1
+	}
+	return bulk(names, options, batchSize, func(batch []string) map[string]error {
+		request := &AlterConfigsRequest{
+			ValidateOnly: options != nil && options.ValidateOnly,
+		}
+		for _, name := range batch {
+			request.Resources = append(request.Resources, &AlterConfigsResource{
+				Type:          resourceType,
+				Name:          name,
+				ConfigEntries: configs[name],
+			})
+		}
+		if ca.conf.Version.IsAtLeast(V2_0_0_0) {
+			request.Version = 1
+		}
+
+		var b *Broker
+		var err error
+		if batchSize == 1 {
+			var id int64
+			if id, err = strconv.ParseInt(batch[0], 10, 32); err != nil {
+				return failAll(batch, err)
+			}
+			b, err = ca.findBroker(int32(id))
+		} else {
+			b, err = ca.findAnyBroker()
+		}
+		if err != nil {
+			return failAll(batch, err)
+		}
+
+		_ = b.Open(ca.client.Config())
+		rsp, err := b.AlterConfigs(request)
+		if err != nil {
+			return failAll(batch, err)
+		}
+
+		errs := failAll(batch, ErrIncompleteResponse)
+		for _, resource := range rsp.Resources {
+			if _, ok := errs[resource.Name]; !ok {
+				continue
+			}
+			switch {
+			case resource.ErrorMsg != "":
+				errs[resource.Name] = errors.New(resource.ErrorMsg)
+			case resource.ErrorCode != 0:
+				errs[resource.Name] = KError(resource.ErrorCode)
+			default:
+				delete(errs, resource.Name)
+			}
+		}
+		return errs
+	})
+}
@@ -2,6 +2,7 @@ package sarama
 
 import (
 	"errors"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
@@ -82,6 +83,107 @@ func TestClusterAdminCreateTopic(t *testing.T) {
 	}
 }
 
+func TestClusterAdminBulkCreateTopics(t *testing.T) {
+	seedBroker := NewMockBroker(t, 1)
+	defer seedBroker.Close()
+
+	seedBroker.SetHandlerByMap(map[string]MockResponse{
+		"MetadataRequest": NewMockMetadataResponse(t).
+			SetController(seedBroker.BrokerID()).
+			SetBroker(seedBroker.Addr(), seedBroker.BrokerID()),
+		"CreateTopicsRequest": NewMockCreateTopicsResponse(t),
+	})
+
+	config := NewTestConfig()
+	config.Version = V0_10_2_0
+	admin, err := NewClusterAdmin([]string{seedBroker.Addr()}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer safeClose(t, admin)
+
+	topics := make(map[string]*TopicDetail)
+	for _, name := range []string{"a", "b", "c", "d", "_reserved"} {
+		topics[name] = &TopicDetail{NumPartitions: 1, ReplicationFactor: 1}
+	}
+	var progress []int
+	err = admin.(BulkClusterAdmin).CreateTopics(topics, &BulkOptions{
+		BatchSize:   2,
+		Parallelism: 2,
+		Progress: func(done, total int) {
+			if total != len(topics) {
+				t.Error("expected a total of", len(topics), "got", total)
+			}
+			progress = append(progress, done)
+		},
+	})
+
+	var bulkErr BulkError
+	if !errors.As(err, &bulkErr) || len(bulkErr) != 1 || !errors.Is(bulkErr["_reserved"], ErrTopicAuthorizationFailed) {
+		t.Fatal("expected the reserved topic only to fail, got", err)
+	}
+	if len(progress) != 3 || progress[2] != len(topics) {
+		t.Error("expected 3 progress calls up to all the topics, got", progress)
+	}
+	requests := 0
+	for _, rr := range seedBroker.History() {
+		if request, ok := rr.Request.(*CreateTopicsRequest); ok {
+			requests++
+			if len(request.TopicDetails) > 2 {
+				t.Error("expected at most 2 topics per request, got", len(request.TopicDetails))
+			}
+		}
+	}
+	if requests != 3 {
+		t.Error("expected 3 requests, got", requests)
+	}
+}
+
+func TestClusterAdminBulkAlterConfigs(t *testing.T) {
+	seedBroker := NewMockBroker(t, 1)
+	defer seedBroker.Close()
+
+	seedBroker.SetHandlerByMap(map[string]MockResponse{
+		"MetadataRequest": NewMockMetadataResponse(t).
+			SetController(seedBroker.BrokerID()).
+			SetBroker(seedBroker.Addr(), seedBroker.BrokerID()),
+		"AlterConfigsRequest": NewMockAlterConfigsResponse(t),
+	})
+
+	config := NewTestConfig()
+	config.Version = V1_0_0_0
+	admin, err := NewClusterAdmin([]string{seedBroker.Addr()}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer safeClose(t, admin)
+
+	value := "1000"
+	entries := map[string]*string{"retention.ms": &value}
+	err = admin.(BulkClusterAdmin).AlterConfigs(TopicResource, map[string]map[string]*string{
+		"a": entries, "b": entries, "c": entries,
+	}, &BulkOptions{BatchSize: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = admin.(BulkClusterAdmin).AlterConfigs(BrokerResource, map[string]map[string]*string{
+		"1": entries,
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var resources []int
+	for _, rr := range seedBroker.History() {
+		if request, ok := rr.Request.(*AlterConfigsRequest); ok {
+			resources = append(resources, len(request.Resources))
+		}
+	}
+	if len(resources) != 3 || resources[0] != 2 || resources[1] != 1 || resources[2] != 1 {
+		t.Error("expected requests of 2 and 1 topics, then of the broker, got", resources)
+	}
+}
+
 func TestClusterAdminCreateTopicWithInvalidTopicDetail(t *testing.T) {
 	seedBroker := NewMockBroker(t, 1)
 	defer seedBroker.Close()
@@ -741,6 +843,7 @@ func TestClusterAdminDescribeConfig(t *testing.T) {
 		{V1_1_0_0, 1, true},
 		{V1_1_1_0, 1, true},
 		{V2_0_0_0, 2, true},
+		{V2_6_0_0, 3, true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.saramaVersion.String(), func(t *testing.T) {
@@ -765,9 +868,15 @@ func TestClusterAdminDescribeConfig(t *testing.T) {
 				t.Fatal(err)
 			}
 
-			history := seedBroker.History()
-			describeReq, ok := history[len(history)-1].Request.(*DescribeConfigsRequest)
-			if !ok {
+			// the connections opened from 2.4 send an ApiVersionsRequest
+			// which may come last
+			var describeReq *DescribeConfigsRequest
+			for _, h := range seedBroker.History() {
+				if req, ok := h.Request.(*DescribeConfigsRequest); ok {
+					describeReq = req
+				}
+			}
+			if describeReq == nil {
 				t.Fatal("failed to find DescribeConfigsRequest in mockBroker history")
 			}
 
@@ -789,6 +898,53 @@ func TestClusterAdminDescribeConfig(t *testing.T) {
 	}
 }
 
+func TestClusterAdminDescribeConfigWithOptions(t *testing.T) {
+	seedBroker := NewMockBroker(t, 1)
+	defer seedBroker.Close()
+
+	seedBroker.SetHandlerByMap(map[string]MockResponse{
+		"MetadataRequest": NewMockMetadataResponse(t).
+			SetController(seedBroker.BrokerID()).
+			SetBroker(seedBroker.Addr(), seedBroker.BrokerID()),
+		"DescribeConfigsRequest": NewMockDescribeConfigsResponse(t),
+	})
+
+	config := NewTestConfig()
+	config.Version = V2_6_0_0
+	admin, err := NewClusterAdmin([]string{seedBroker.Addr()}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer safeClose(t, admin)
+
+	entries, err := admin.DescribeConfigWithOptions(ConfigResource{Name: "r1", Type: TopicResource},
+		DescribeConfigOptions{IncludeSynonyms: true, IncludeDocumentation: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var request *DescribeConfigsRequest
+	for _, h := range seedBroker.History() {
+		if req, ok := h.Request.(*DescribeConfigsRequest); ok {
+			request = req
+		}
+	}
+	if request == nil {
+		t.Fatal("failed to find DescribeConfigsRequest in mockBroker history")
+	}
+	if request.Version != 3 || !request.IncludeSynonyms || !request.IncludeDocumentation {
+		t.Errorf("expected a version 3 request with the synonyms and documentation, got %+v", request)
+	}
+
+	if len(entries) == 0 {
+		t.Fatal("no config entries returned")
+	}
+	if entry := entries[0]; entry.Name != "max.message.bytes" || entry.Type != ConfigTypeInt ||
+		entry.Documentation == "" || entry.Source != SourceDefault || len(entry.Synonyms) == 0 {
+		t.Errorf("expected the type, documentation, source and synonyms of max.message.bytes, got %+v", entry)
+	}
+}
+
 func TestClusterAdminDescribeConfigWithErrorCode(t *testing.T) {
 	seedBroker := NewMockBroker(t, 1)
 	defer seedBroker.Close()
@@ -1363,6 +1519,58 @@ func TestDescribeTopic(t *testing.T) {
 	}
 }
 
+func TestDescribeTopicPartitionsPages(t *testing.T) {
+	seedBroker := NewMockBroker(t, 1)
+	defer seedBroker.Close()
+
+	topic := "my_topic"
+	seedBroker.SetHandlerByMap(map[string]MockResponse{
+		"ApiVersionsRequest": NewMockApiVersionsResponse(t),
+		"MetadataRequest": NewMockMetadataResponse(t).
+			SetController(seedBroker.BrokerID()).
+			SetBroker(seedBroker.Addr(), seedBroker.BrokerID()),
+		"DescribeTopicPartitionsRequest": NewMockSequence(
+			&DescribeTopicPartitionsResponse{
+				Topics: []*DescribeTopicPartitionsTopic{{
+					Name:       &topic,
+					Partitions: []*DescribeTopicPartitionsPartition{{PartitionIndex: 0, ReplicaNodes: []int32{1}, IsrNodes: []int32{1}, OfflineReplicas: []int32{}}},
+				}},
+				NextCursor: &DescribeTopicPartitionsCursor{TopicName: topic, PartitionIndex: 1},
+			},
+			&DescribeTopicPartitionsResponse{
+				Topics: []*DescribeTopicPartitionsTopic{{
+					Name:       &topic,
+					Partitions: []*DescribeTopicPartitionsPartition{{PartitionIndex: 1, ReplicaNodes: []int32{1}, IsrNodes: []int32{1}, OfflineReplicas: []int32{}}},
+				}},
+			},
+		),
+	})
+
+	config := NewTestConfig()
+	config.Version = V3_8_0_0
+
+	admin, err := NewClusterAdmin([]string{seedBroker.Addr()}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer safeClose(t, admin)
+
+	topics, err := admin.DescribeTopics([]string{topic})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(topics) != 1 {
+		t.Fatalf("Expected 1 result, got %v", len(topics))
+	}
+	if topics[0].Name != topic {
+		t.Fatalf("Incorrect topic name: %v", topics[0].Name)
+	}
+	if len(topics[0].Partitions) != 2 || topics[0].Partitions[1].ID != 1 {
+		t.Fatalf("Expected the partitions of both pages, got %v", topics[0].Partitions)
+	}
+}
+
 func TestDescribeTopicWithVersion0_11(t *testing.T) {
 	seedBroker := NewMockBroker(t, 1)
 	defer seedBroker.Close()
@@ -1444,6 +1652,68 @@ func TestDescribeConsumerGroup(t *testing.T) {
 	}
 }
 
+func TestDescribeConsumerGroupMixedProtocols(t *testing.T) {
+	seedBroker := NewMockBroker(t, 1)
+	defer seedBroker.Close()
+
+	seedBroker.SetHandlerByMap(map[string]MockResponse{
+		"ApiVersionsRequest": NewMockApiVersionsResponse(t),
+		"ConsumerGroupDescribeRequest": NewMockConsumerGroupDescribeResponse(t).AddGroupDescription("new-group", &ConsumerGroupDescription{
+			GroupID:      "new-group",
+			GroupState:   "Stable",
+			AssignorName: "uniform",
+			Members: []*ConsumerGroupMemberDescription{{
+				MemberID: "member",
+				Assignment: &ConsumerGroupAssignment{
+					TopicPartitions: []*ConsumerGroupTopicPartitions{{TopicName: "topic", Partitions: []int32{0}}},
+				},
+			}},
+		}),
+		"DescribeGroupsRequest": NewMockDescribeGroupsResponse(t).AddGroupDescription("classic-group", &GroupDescription{
+			GroupId:      "classic-group",
+			State:        "Stable",
+			ProtocolType: "consumer",
+		}),
+		"MetadataRequest": NewMockMetadataResponse(t).
+			SetController(seedBroker.BrokerID()).
+			SetBroker(seedBroker.Addr(), seedBroker.BrokerID()),
+		"FindCoordinatorRequest": NewMockFindCoordinatorResponse(t).
+			SetCoordinator(CoordinatorGroup, "new-group", seedBroker).
+			SetCoordinator(CoordinatorGroup, "classic-group", seedBroker),
+	})
+
+	config := NewTestConfig()
+	config.Version = V4_0_0_0
+
+	admin, err := NewClusterAdmin([]string{seedBroker.Addr()}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer safeClose(t, admin)
+
+	result, err := admin.DescribeConsumerGroups([]string{"new-group", "classic-group"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result) != 2 {
+		t.Fatalf("Expected 2 results, got %v", len(result))
+	}
+	if result[0].GroupId != "new-group" || result[0].Protocol != "uniform" {
+		t.Fatalf("Expected new-group assigned by uniform, got %v assigned by %v", result[0].GroupId, result[0].Protocol)
+	}
+	assignment, err := result[0].Members["member"].GetMemberAssignment()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(assignment.Topics, map[string][]int32{"topic": {0}}) {
+		t.Fatalf("Unexpected assignment %v", assignment.Topics)
+	}
+	if result[1].GroupId != "classic-group" || result[1].State != "Stable" {
+		t.Fatalf("Expected stable classic-group, got %v in state %v", result[1].GroupId, result[1].State)
+	}
+}
+
 func TestListConsumerGroups(t *testing.T) {
 	seedBroker := NewMockBroker(t, 1)
 	defer seedBroker.Close()
@@ -1600,6 +1870,45 @@ func TestListConsumerGroupOffsets(t *testing.T) {
 	}
 }
 
+func TestListConsumerGroupPartitionOffsets(t *testing.T) {
+	seedBroker := NewMockBroker(t, 1)
+	defer seedBroker.Close()
+
+	group := "my-group"
+	seedBroker.SetHandlerByMap(map[string]MockResponse{
+		"OffsetFetchRequest": NewMockOffsetFetchResponse(t).
+			SetOffset(group, "my-topic", 0, 42, "meta", ErrNoError).
+			SetOffset(group, "my-topic", 1, 7, "", ErrNoError).
+			SetError(ErrNoError),
+		"MetadataRequest": NewMockMetadataResponse(t).
+			SetController(seedBroker.BrokerID()).
+			SetBroker(seedBroker.Addr(), seedBroker.BrokerID()),
+		"FindCoordinatorRequest": NewMockFindCoordinatorResponse(t).SetCoordinator(CoordinatorGroup, group, seedBroker),
+	})
+
+	config := NewTestConfig()
+	config.Version = V1_0_0_0
+	admin, err := NewClusterAdmin([]string{seedBroker.Addr()}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer safeClose(t, admin)
+
+	offsets, err := admin.ListConsumerGroupPartitionOffsets(group, []TopicPartitionID{{"my-topic", 0}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	block := offsets[TopicPartitionID{"my-topic", 0}]
+	if block == nil || block.Offset != 42 || block.Metadata != "meta" {
+		t.Errorf("unexpected offset %+v of my-topic/0", block)
+	}
+
+	request := seedBroker.History()[len(seedBroker.History())-1].Request.(*OffsetFetchRequest)
+	if expected := map[string][]int32{"my-topic": {0}}; !reflect.DeepEqual(request.partitions, expected) {
+		t.Errorf("expected the offsets of %v to be fetched, got %v", expected, request.partitions)
+	}
+}
+
 func TestDeleteConsumerGroup(t *testing.T) {
 	seedBroker := NewMockBroker(t, 1)
 	defer seedBroker.Close()
@@ -1,11 +1,13 @@
 package sarama
 
 import (
+	"context"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"math"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/eapache/go-resiliency/breaker"
@@ -38,6 +40,19 @@ type AsyncProducer interface {
 	// wish to send.
 	Input() chan<- *ProducerMessage
 
+	// TrySend writes msg to Input if the producer is ready to accept it
+	// without blocking, and returns ErrProducerQueueFull otherwise, so that
+	// the callers can shed the load the producer can not keep up with. It
+	// returns ErrShuttingDown once the producer is closing. The delivery of
+	// msg is still reported on Successes and Errors.
+	TrySend(msg *ProducerMessage) error
+
+	// SendWithContext writes msg to Input, waiting for the producer to catch
+	// up until ctx is done, when it returns the error of ctx. It returns
+	// ErrShuttingDown once the producer is closing. The delivery of msg is
+	// still reported on Successes and Errors.
+	SendWithContext(ctx context.Context, msg *ProducerMessage) error
+
 	// Successes is the success output channel back to the user when Return.Successes is
 	// enabled. If Return.Successes is true, you MUST read from this channel or the
 	// Producer will deadlock. It is suggested that you send and read messages
@@ -79,6 +94,9 @@ type asyncProducer struct {
 	errors                    chan *ProducerError
 	input, successes, retries chan *ProducerMessage
 	inFlight                  sync.WaitGroup
+	inputLock                 sync.RWMutex // held by TrySend and SendWithContext while writing to input
+	inputClosed               bool         // set once shutting down, so they no longer write to input
+	undelivered               int32        // messages accepted and not yet returned, accessed atomically
 
 	brokers    map[*Broker]*brokerProducer
 	brokerRefs map[*brokerProducer]int
@@ -87,7 +105,14 @@ type asyncProducer struct {
 	txnmgr *transactionManager
 	txLock sync.Mutex
 
+	txnEvents       chan *TxnEvent
+	txnEventsLock   sync.Mutex
+	txnEventsClosed bool
+
 	metricsRegistry metrics.Registry
+
+	// verificationID is the producer ID of the sequence headers, if enabled
+	verificationID string
 }
 
 // NewAsyncProducer creates a new AsyncProducer using the given broker addresses and configuration.
@@ -131,6 +156,16 @@ func newAsyncProducer(client Client) (AsyncProducer, error) {
 		txnmgr:          txnmgr,
 		metricsRegistry: newCleanupRegistry(client.Config().MetricRegistry),
 	}
+	if p.conf.Producer.Transaction.Events {
+		p.txnEvents = make(chan *TxnEvent, p.conf.ChannelBufferSize)
+	}
+	if p.conf.Producer.Verification.Enable {
+		p.verificationID = p.conf.Producer.Verification.ID
+		if p.verificationID == "" {
+			p.verificationID = newVerificationID()
+		}
+	}
+	p.observeTxn(txnmgr)
 
 	// launch our singleton dispatchers
 	go withRecover(p.dispatcher)
@@ -142,12 +177,13 @@ func newAsyncProducer(client Client) (AsyncProducer, error) {
 type flagSet int8
 
 const (
-	syn       flagSet = 1 << iota // first message from partitionProducer to brokerProducer
-	fin                           // final message from partitionProducer to brokerProducer and back
-	shutdown                      // start the shutdown process
-	endtxn                        // endtxn
-	committxn                     // endtxn
-	aborttxn                      // endtxn
+	syn        flagSet = 1 << iota // first message from partitionProducer to brokerProducer
+	fin                            // final message from partitionProducer to brokerProducer and back
+	shutdown                       // start the shutdown process
+	endtxn                         // endtxn
+	committxn                      // endtxn
+	aborttxn                       // endtxn
+	preparetxn                     // preparetxn
 )
 
 // ProducerMessage is the collection of elements passed to the Producer in order to send a message.
@@ -195,9 +231,18 @@ type ProducerMessage struct {
 	retries        int
 	flags          flagSet
 	expectation    chan *ProducerError
+	acked          func(err error) // called once the message is delivered or failed
 	sequenceNumber int32
+	producerID     int64
 	producerEpoch  int16
 	hasSequence    bool
+	enqueued       time.Time // when the message reached its broker producer
+	attempts       []ProduceAttempt
+	// batchLimit is the maximum number of messages of the batch of the
+	// message once it was split because of ErrMessageSizeTooLarge, and splits
+	// the number of its retries which were splits
+	batchLimit int
+	splits     int
 }
 
 const producerMessageOverhead = 26 // the metadata overhead of CRC, flags, etc.
@@ -221,12 +266,25 @@ func (m *ProducerMessage) ByteSize(version int) int {
 	return size
 }
 
+// ack reports the delivery of the message, or its failure with err, to its
+// acked hook, if any.
+func (m *ProducerMessage) ack(err error) {
+	if acked := m.acked; acked != nil {
+		m.acked = nil
+		acked(err)
+	}
+}
+
 func (m *ProducerMessage) clear() {
 	m.flags = 0
 	m.retries = 0
 	m.sequenceNumber = 0
+	m.producerID = 0
 	m.producerEpoch = 0
 	m.hasSequence = false
+	m.attempts = nil
+	m.batchLimit = 0
+	m.splits = 0
 }
 
 // ProducerError is the type of error generated when the producer fails to deliver a message.
@@ -234,6 +292,46 @@ func (m *ProducerMessage) clear() {
 type ProducerError struct {
 	Msg *ProducerMessage
 	Err error
+	// Attempts are the attempts to produce Msg which failed and were retried,
+	// in order, before the last one failed with Err.
+	Attempts []ProduceAttempt
+}
+
+// ProduceAttempt is an attempt to produce a message which failed and was
+// retried.
+type ProduceAttempt struct {
+	Broker    int32 // the ID of the broker the message was sent to
+	Partition int32
+	Err       error
+}
+
+// ProduceRecordError is the Err of the ProducerError of a message whose batch
+// the broker rejected with an error message or with the errors of some of its
+// records (KIP-467, Kafka 2.4 and later). It wraps the error of the batch.
+type ProduceRecordError struct {
+	Err KError
+	// BatchIndex is the index of the message in its batch.
+	BatchIndex int32
+	// Invalid is true if the message is one of the records rejected by the
+	// broker, false if it was only rejected along with them.
+	Invalid bool
+	// Message is the error message of the record, or else of the batch.
+	Message string
+}
+
+func (e *ProduceRecordError) Error() string {
+	msg := e.Err.Error()
+	if e.Invalid {
+		msg = fmt.Sprintf("%s (invalid record %d of the batch)", msg, e.BatchIndex)
+	}
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	return msg
+}
+
+func (e *ProduceRecordError) Unwrap() error {
+	return e.Err
 }
 
 func (pe ProducerError) Error() string {
@@ -333,6 +431,50 @@ func (p *asyncProducer) AbortTxn() error {
 	return nil
 }
 
+func (p *asyncProducer) PrepareTxn() (PreparedTxnState, error) {
+	p.txLock.Lock()
+	defer p.txLock.Unlock()
+
+	if !p.IsTransactional() {
+		DebugLogger.Printf("producer/txnmgr [%s] attempt to call PrepareTxn on a non-transactional producer\n", p.txnmgr.transactionalID)
+		return PreparedTxnState{}, ErrNonTransactedProducer
+	}
+	if !p.conf.Producer.Transaction.TwoPhaseCommit {
+		return PreparedTxnState{}, ErrTwoPhaseCommitDisabled
+	}
+
+	DebugLogger.Printf("producer/txnmgr [%s] preparing transaction\n", p.txnmgr.transactionalID)
+	p.inFlight.Add(1)
+	p.input <- &ProducerMessage{flags: preparetxn}
+	p.inFlight.Wait()
+	state, err := p.txnmgr.prepareTransaction()
+	if err != nil {
+		return state, err
+	}
+	DebugLogger.Printf("producer/txnmgr [%s] transaction prepared %+v\n", p.txnmgr.transactionalID, state)
+	return state, nil
+}
+
+func (p *asyncProducer) CompleteTxn(state PreparedTxnState) error {
+	p.txLock.Lock()
+	defer p.txLock.Unlock()
+
+	if !p.IsTransactional() {
+		DebugLogger.Printf("producer/txnmgr [%s] attempt to call CompleteTxn on a non-transactional producer\n", p.txnmgr.transactionalID)
+		return ErrNonTransactedProducer
+	}
+	if !p.conf.Producer.Transaction.TwoPhaseCommit {
+		return ErrTwoPhaseCommitDisabled
+	}
+	if p.txnmgr.currentTxnStatus()&ProducerTxnFlagPreparedTransaction == 0 {
+		return ErrTransactionNotReady
+	}
+
+	commit := p.txnmgr.isPreparedTxn(state)
+	DebugLogger.Printf("producer/txnmgr [%s] completing transaction %+v, commit: %t\n", p.txnmgr.transactionalID, state, commit)
+	return p.finishTransaction(commit)
+}
+
 func (p *asyncProducer) finishTransaction(commit bool) error {
 	p.inFlight.Add(1)
 	if commit {
@@ -356,6 +498,36 @@ func (p *asyncProducer) Input() chan<- *ProducerMessage {
 	return p.input
 }
 
+func (p *asyncProducer) TrySend(msg *ProducerMessage) error {
+	p.inputLock.RLock()
+	defer p.inputLock.RUnlock()
+
+	if p.inputClosed {
+		return ErrShuttingDown
+	}
+	select {
+	case p.input <- msg:
+		return nil
+	default:
+		return ErrProducerQueueFull
+	}
+}
+
+func (p *asyncProducer) SendWithContext(ctx context.Context, msg *ProducerMessage) error {
+	p.inputLock.RLock()
+	defer p.inputLock.RUnlock()
+
+	if p.inputClosed {
+		return ErrShuttingDown
+	}
+	select {
+	case p.input <- msg:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 func (p *asyncProducer) Close() error {
 	p.AsyncClose()
 
@@ -391,7 +563,43 @@ func (p *asyncProducer) dispatcher() {
 	handlers := make(map[string]chan<- *ProducerMessage)
 	shuttingDown := false
 
-	for msg := range p.input {
+	// refresh the producer ID once idle for Producer.KeepAlive
+	var keepAlive *time.Timer
+	var keepAliveC <-chan time.Time
+	if p.conf.Producer.Idempotent && p.conf.Producer.KeepAlive > 0 {
+		keepAlive = time.NewTimer(p.conf.Producer.KeepAlive)
+		defer keepAlive.Stop()
+		keepAliveC = keepAlive.C
+	}
+
+dispatch:
+	for {
+		var msg *ProducerMessage
+		select {
+		case m, ok := <-p.input:
+			if !ok {
+				break dispatch
+			}
+			msg = m
+			if keepAlive != nil {
+				if !keepAlive.Stop() {
+					select {
+					case <-keepAlive.C:
+					default:
+					}
+				}
+				keepAlive.Reset(p.conf.Producer.KeepAlive)
+			}
+		case <-keepAliveC:
+			// messages still in flight may need the dispatcher to be retried,
+			// so the refresh waits for the next tick rather than for them
+			if !shuttingDown && atomic.LoadInt32(&p.undelivered) == 0 {
+				p.refreshProducerID()
+			}
+			keepAlive.Reset(p.conf.Producer.KeepAlive)
+			continue
+		}
+
 		if msg == nil {
 			Logger.Println("Something tried to send a nil message, it was ignored.")
 			continue
@@ -411,6 +619,14 @@ func (p *asyncProducer) dispatcher() {
 			continue
 		}
 
+		if msg.flags&preparetxn != 0 {
+			if err := p.txnmgr.transitionTo(ProducerTxnFlagPreparedTransaction, nil); err != nil {
+				Logger.Printf("producer/txnmgr unable to prepare transaction %s", err)
+			}
+			p.inFlight.Done()
+			continue
+		}
+
 		if msg.flags&shutdown != 0 {
 			shuttingDown = true
 			p.inFlight.Done()
@@ -422,6 +638,7 @@ func (p *asyncProducer) dispatcher() {
 				// we can't just call returnError here because that decrements the wait group,
 				// which hasn't been incremented yet for this message, and shouldn't be
 				pErr := &ProducerError{Msg: msg, Err: ErrShuttingDown}
+				msg.ack(ErrShuttingDown)
 				if p.conf.Producer.Return.Errors {
 					p.errors <- pErr
 				} else {
@@ -430,6 +647,7 @@ func (p *asyncProducer) dispatcher() {
 				continue
 			}
 			p.inFlight.Add(1)
+			atomic.AddInt32(&p.undelivered, 1)
 			// Ignore retried msg, there are already in txn.
 			// Can't produce new record when transaction is not started.
 			if p.IsTransactional() && p.txnmgr.currentTxnStatus()&ProducerTxnFlagInTransaction == 0 {
@@ -443,6 +661,14 @@ func (p *asyncProducer) dispatcher() {
 			msg.safelyApplyInterceptor(interceptor)
 		}
 
+		// Retried messages have been transformed already.
+		if msg.retries == 0 {
+			if err := msg.applyTransforms(p.conf.Transforms); err != nil {
+				p.returnError(msg, err)
+				continue
+			}
+		}
+
 		version := 1
 		if p.conf.Version.IsAtLeast(V0_11_0_0) {
 			version = 2
@@ -479,6 +705,12 @@ type topicProducer struct {
 	breaker     *breaker.Breaker
 	handlers    map[int32]chan<- *ProducerMessage
 	partitioner Partitioner
+
+	// partitionCount is the number of partitions the messages are
+	// partitioned among, and expandAt when the new ones are used, following
+	// Producer.PartitionExpansion
+	partitionCount int32
+	expandAt       time.Time
 }
 
 func (p *asyncProducer) newTopicProducer(topic string) chan<- *ProducerMessage {
@@ -497,7 +729,13 @@ func (p *asyncProducer) newTopicProducer(topic string) chan<- *ProducerMessage {
 
 func (tp *topicProducer) dispatch() {
 	for msg := range tp.input {
-		if msg.retries == 0 {
+		// the retried messages keep their partition unless it disappeared,
+		// and keep it anyway once they have the sequence number of the
+		// idempotent producer for it
+		if msg.retries == 0 || (msg.flags == 0 && !msg.hasSequence && tp.parent.partitionDisappeared(tp.topic, msg.Partition)) {
+			if msg.retries > 0 {
+				Logger.Printf("producer/topic/%s partition %d disappeared, partitioning the message again\n", tp.topic, msg.Partition)
+			}
 			if err := tp.partitionMessage(msg); err != nil {
 				tp.parent.returnError(msg, err)
 				continue
@@ -518,6 +756,22 @@ func (tp *topicProducer) dispatch() {
 	}
 }
 
+// partitionDisappeared returns whether the partition is missing from the
+// metadata of the topic, as when the topic was deleted and created again with
+// fewer partitions.
+func (p *asyncProducer) partitionDisappeared(topic string, partition int32) bool {
+	partitions, err := p.client.Partitions(topic)
+	if err != nil {
+		return false
+	}
+	for _, id := range partitions {
+		if id == partition {
+			return false
+		}
+	}
+	return true
+}
+
 func (tp *topicProducer) partitionMessage(msg *ProducerMessage) error {
 	var partitions []int32
 
@@ -534,6 +788,24 @@ func (tp *topicProducer) partitionMessage(msg *ProducerMessage) error {
 		} else {
 			partitions, err = tp.parent.client.WritablePartitions(msg.Topic)
 		}
+		if err != nil || tp.parent.conf.Producer.PartitionExpansion.Policy == PartitionExpansionImmediate {
+			return
+		}
+
+		all := partitions
+		if !requiresConsistency {
+			if all, err = tp.parent.client.Partitions(msg.Topic); err != nil {
+				return
+			}
+		}
+		// the partition IDs are sorted, from 0 to the total minus one
+		limit := tp.partitionLimit(int32(len(all)))
+		for i, id := range partitions {
+			if id >= limit {
+				partitions = partitions[:i]
+				break
+			}
+		}
 		return
 	})
 	if err != nil {
@@ -578,6 +850,10 @@ type partitionProducer struct {
 	// therefore whether our buffer is complete and safe to flush)
 	highWatermark int
 	retryState    []partitionRetryState
+
+	// verificationSequence is the sequence number of the next message, with
+	// Producer.Verification enabled
+	verificationSequence int64
 }
 
 type partitionRetryState struct {
@@ -616,6 +892,11 @@ func (pp *partitionProducer) backoff(retries int) {
 func (pp *partitionProducer) updateLeaderIfBrokerProducerIsNil(msg *ProducerMessage) error {
 	if pp.brokerProducer == nil {
 		if err := pp.updateLeader(); err != nil {
+			if msg.retries > 0 && msg.flags == 0 && !msg.hasSequence && pp.parent.partitionDisappeared(pp.topic, pp.partition) {
+				// the topic producer partitions the retried message again
+				pp.parent.retries <- msg
+				return err
+			}
 			pp.parent.returnError(msg, err)
 			pp.backoff(msg.retries)
 			return err
@@ -694,9 +975,13 @@ func (pp *partitionProducer) dispatch() {
 		// All messages being retried (sent or not) have already had their retry count updated
 		// Also, ignore "special" syn/fin messages used to sync the brokerProducer and the topicProducer.
 		if pp.parent.conf.Producer.Idempotent && msg.retries == 0 && msg.flags == 0 {
-			msg.sequenceNumber, msg.producerEpoch = pp.parent.txnmgr.getAndIncrementSequenceNumber(msg.Topic, msg.Partition)
+			msg.sequenceNumber, msg.producerID, msg.producerEpoch = pp.parent.txnmgr.getAndIncrementSequenceNumber(msg.Topic, msg.Partition)
 			msg.hasSequence = true
 		}
+		if pp.parent.verificationID != "" && msg.retries == 0 && msg.flags == 0 {
+			stampSequence(msg, pp.parent.verificationID, pp.verificationSequence)
+			pp.verificationSequence++
+		}
 
 		if pp.parent.IsTransactional() {
 			pp.parent.txnmgr.maybeAddPartitionToCurrentTxn(pp.topic, pp.partition)
@@ -709,6 +994,10 @@ func (pp *partitionProducer) dispatch() {
 func (pp *partitionProducer) newHighWatermark(hwm int) {
 	Logger.Printf("producer/leader/%s/%d state change to [retrying-%d]\n", pp.topic, pp.partition, hwm)
 	pp.highWatermark = hwm
+	// the splits of the batches too large retry past Producer.Retry.Max
+	for len(pp.retryState) <= hwm {
+		pp.retryState = append(pp.retryState, partitionRetryState{})
+	}
 
 	// send off a fin so that we know when everything "in between" has made it
 	// back to us and we can safely flush the backlog (otherwise we risk re-ordering messages)
@@ -785,6 +1074,7 @@ func (p *asyncProducer) newBrokerProducer(broker *Broker) *brokerProducer {
 		output:         bridge,
 		responses:      responses,
 		buffer:         newProduceSet(p),
+		pending:        newPendingSet(p),
 		currentRetries: make(map[string]map[int32]error),
 	}
 	go withRecover(bp.run)
@@ -795,6 +1085,7 @@ func (p *asyncProducer) newBrokerProducer(broker *Broker) *brokerProducer {
 		var wg sync.WaitGroup
 
 		for set := range bridge {
+			set.updateQueueWaitTime()
 			request := set.buildRequest()
 
 			// Count the in flight requests to know when we can close the pending channel safely
@@ -900,6 +1191,7 @@ type brokerProducer struct {
 	abandoned chan struct{}
 
 	buffer     *produceSet
+	pending    *pendingSet
 	timer      *time.Timer
 	timerFired bool
 
@@ -937,7 +1229,7 @@ func (bp *brokerProducer) run() {
 			}
 
 			if reason := bp.needsRetry(msg); reason != nil {
-				bp.parent.retryMessage(msg, reason)
+				bp.parent.retryMessage(msg, bp.broker.ID(), reason)
 
 				if bp.closing == nil && msg.flags&fin == fin {
 					// we were retrying this partition but we can start processing again
@@ -951,25 +1243,35 @@ func (bp *brokerProducer) run() {
 
 			if msg.flags&fin == fin {
 				// New broker producer that was caught up by the retry loop
-				bp.parent.retryMessage(msg, ErrShuttingDown)
+				bp.parent.retryMessage(msg, bp.broker.ID(), ErrShuttingDown)
 				DebugLogger.Printf("producer/broker/%d state change to [dying-%d] on %s/%d\n",
 					bp.broker.ID(), msg.retries, msg.Topic, msg.Partition)
 				continue
 			}
 
-			if bp.buffer.wouldOverflow(msg) {
-				Logger.Printf("producer/broker/%d maximum request accumulated, waiting for space\n", bp.broker.ID())
-				if err := bp.waitForSpace(msg, false); err != nil {
-					bp.parent.retryMessage(msg, err)
-					continue
+			msg.enqueued = time.Now()
+			if bp.pending.holds(msg) || bp.buffer.wouldOverflow(msg) {
+				if bp.pending.full(msg) {
+					Logger.Printf("producer/broker/%d maximum request accumulated, waiting for space\n", bp.broker.ID())
+					if err := bp.waitForSpace(msg, true); err != nil {
+						bp.parent.retryMessage(msg, bp.broker.ID(), err)
+						continue
+					}
+				}
+				if bp.pending.holds(msg) || bp.buffer.wouldOverflow(msg) {
+					// Queue the message until the buffer is flushed rather
+					// than holding up the other partitions, and break out of
+					// the select to flush it without waiting for the thresholds
+					bp.pending.add(msg)
+					break
 				}
 			}
 
-			if bp.parent.txnmgr.producerID != noProducerID && bp.buffer.producerEpoch != msg.producerEpoch {
-				// The epoch was reset, need to roll the buffer over
+			if !bp.buffer.empty() && !bp.buffer.sameProducer(msg) {
+				// The producer ID or epoch was reset, need to roll the buffer over
 				Logger.Printf("producer/broker/%d detected epoch rollover, waiting for new buffer\n", bp.broker.ID())
 				if err := bp.waitForSpace(msg, true); err != nil {
-					bp.parent.retryMessage(msg, err)
+					bp.parent.retryMessage(msg, bp.broker.ID(), err)
 					continue
 				}
 			}
@@ -980,29 +1282,44 @@ func (bp *brokerProducer) run() {
 
 			if bp.parent.conf.Producer.Flush.Frequency > 0 && bp.timer == nil {
 				bp.timer = time.NewTimer(bp.parent.conf.Producer.Flush.Frequency)
-				timerChan = bp.timer.C
 			}
 		case <-timerChan:
 			bp.timerFired = true
 		case output <- bp.buffer:
 			bp.rollOver()
-			timerChan = nil
 		case response, ok := <-bp.responses:
 			if ok {
 				bp.handleResponse(response)
 			}
 		}
 
-		if bp.timerFired || bp.buffer.readyToFlush() {
+		if bp.buffer.empty() && !bp.pending.empty() {
+			// a retry emptied the buffer, refill it from the pending messages
+			bp.drainPending()
+		}
+
+		// pending messages did not fit in the buffer, so there is no point
+		// waiting for the flush thresholds
+		if bp.timerFired || bp.buffer.readyToFlush() || (!bp.buffer.empty() && !bp.pending.empty()) {
 			output = bp.output
 		} else {
 			output = nil
 		}
+
+		// the buffer may have been rolled over with pending messages
+		if bp.timer != nil {
+			timerChan = bp.timer.C
+		} else {
+			timerChan = nil
+		}
 	}
 }
 
 func (bp *brokerProducer) shutdown() {
-	for !bp.buffer.empty() {
+	for !bp.buffer.empty() || !bp.pending.empty() {
+		if bp.buffer.empty() {
+			bp.drainPending()
+		}
 		select {
 		case response := <-bp.responses:
 			bp.handleResponse(response)
@@ -1052,6 +1369,29 @@ func (bp *brokerProducer) rollOver() {
 	bp.timer = nil
 	bp.timerFired = false
 	bp.buffer = newProduceSet(bp.parent)
+	bp.drainPending()
+}
+
+// drainPending moves the pending messages to the new buffer, in turn by
+// partition.
+func (bp *brokerProducer) drainPending() {
+	bp.pending.drain(func(msg *ProducerMessage) bool {
+		if bp.buffer.wouldOverflow(msg) {
+			return false
+		}
+		if !bp.buffer.empty() && !bp.buffer.sameProducer(msg) {
+			// The producer ID or epoch was reset, the message waits for the next buffer
+			return false
+		}
+		if err := bp.buffer.add(msg); err != nil {
+			bp.parent.returnError(msg, err)
+		}
+		return true
+	})
+
+	if !bp.buffer.empty() && bp.parent.conf.Producer.Flush.Frequency > 0 {
+		bp.timer = time.NewTimer(bp.parent.conf.Producer.Flush.Frequency)
+	}
 }
 
 func (bp *brokerProducer) handleResponse(response *brokerProducerResponse) {
@@ -1098,6 +1438,13 @@ func (bp *brokerProducer) handleSuccess(sent *produceSet, response *ProduceRespo
 		// Duplicate
 		case ErrDuplicateSequenceNumber:
 			bp.parent.returnSuccesses(pSet.msgs)
+		// Batch too large, split unless it is a single message
+		case ErrMessageSizeTooLarge, ErrMessageSetSizeTooLarge:
+			if len(pSet.msgs) > 1 {
+				retryTopics = append(retryTopics, topic)
+			} else {
+				bp.parent.returnErrors(pSet.msgs, block.Err)
+			}
 		// Retriable errors
 		case ErrInvalidMessage, ErrUnknownTopicOrPartition, ErrLeaderNotAvailable, ErrNotLeaderForPartition,
 			ErrRequestTimedOut, ErrNotEnoughReplicas, ErrNotEnoughReplicasAfterAppend:
@@ -1112,7 +1459,11 @@ func (bp *brokerProducer) handleSuccess(sent *produceSet, response *ProduceRespo
 			if bp.parent.conf.Producer.Retry.Max <= 0 {
 				bp.parent.abandonBrokerConnection(bp.broker)
 			}
-			bp.parent.returnErrors(pSet.msgs, block.Err)
+			if block.ErrorMessage != nil || len(block.RecordErrors) > 0 {
+				bp.parent.returnRecordErrors(pSet.msgs, block)
+			} else {
+				bp.parent.returnErrors(pSet.msgs, block.Err)
+			}
 		}
 	})
 
@@ -1132,8 +1483,35 @@ func (bp *brokerProducer) handleSuccess(sent *produceSet, response *ProduceRespo
 			}
 
 			switch block.Err {
+			case ErrMessageSizeTooLarge, ErrMessageSetSizeTooLarge:
+				if len(pSet.msgs) <= 1 {
+					// handled in the previous "eachPartition" loop
+					return
+				}
+				limit := (len(pSet.msgs) + 1) / 2
+				Logger.Printf("producer/broker/%d state change to [retrying] on %s/%d because %v, splitting its batch of %d messages\n",
+					bp.broker.ID(), topic, partition, block.Err, len(pSet.msgs))
+				if bp.currentRetries[topic] == nil {
+					bp.currentRetries[topic] = make(map[int32]error)
+				}
+				bp.currentRetries[topic][partition] = block.Err
+				for _, msg := range pSet.msgs {
+					msg.batchLimit = limit
+				}
+				bp.parent.splitMessages(pSet.msgs, bp.broker.ID(), block.Err)
+				bp.parent.splitMessages(bp.buffer.dropPartition(topic, partition), bp.broker.ID(), block.Err)
+				bp.parent.splitMessages(bp.pending.drop(topic, partition), bp.broker.ID(), block.Err)
 			case ErrInvalidMessage, ErrUnknownTopicOrPartition, ErrLeaderNotAvailable, ErrNotLeaderForPartition,
 				ErrRequestTimedOut, ErrNotEnoughReplicas, ErrNotEnoughReplicasAfterAppend:
+				if !bp.allowRetry() {
+					Logger.Printf("producer/broker/%d not retrying %s/%d because the retry budget is exhausted\n",
+						bp.broker.ID(), topic, partition)
+					// the following messages fail too, to keep the partition in order
+					bp.parent.returnErrors(pSet.msgs, block.Err)
+					bp.parent.returnErrors(bp.buffer.dropPartition(topic, partition), block.Err)
+					bp.parent.returnErrors(bp.pending.drop(topic, partition), block.Err)
+					return
+				}
 				Logger.Printf("producer/broker/%d state change to [retrying] on %s/%d because %v\n",
 					bp.broker.ID(), topic, partition, block.Err)
 				if bp.currentRetries[topic] == nil {
@@ -1141,18 +1519,19 @@ func (bp *brokerProducer) handleSuccess(sent *produceSet, response *ProduceRespo
 				}
 				bp.currentRetries[topic][partition] = block.Err
 				if bp.parent.conf.Producer.Idempotent {
-					go bp.parent.retryBatch(topic, partition, pSet, block.Err)
+					go bp.parent.retryBatch(topic, partition, bp.broker.ID(), pSet, block.Err)
 				} else {
-					bp.parent.retryMessages(pSet.msgs, block.Err)
+					bp.parent.retryMessages(pSet.msgs, bp.broker.ID(), block.Err)
 				}
 				// dropping the following messages has the side effect of incrementing their retry count
-				bp.parent.retryMessages(bp.buffer.dropPartition(topic, partition), block.Err)
+				bp.parent.retryMessages(bp.buffer.dropPartition(topic, partition), bp.broker.ID(), block.Err)
+				bp.parent.retryMessages(bp.pending.drop(topic, partition), bp.broker.ID(), block.Err)
 			}
 		})
 	}
 }
 
-func (p *asyncProducer) retryBatch(topic string, partition int32, pSet *partitionSet, kerr KError) {
+func (p *asyncProducer) retryBatch(topic string, partition int32, broker int32, pSet *partitionSet, kerr KError) {
 	Logger.Printf("Retrying batch for %v-%d because of %s\n", topic, partition, kerr)
 	produceSet := newProduceSet(p)
 	produceSet.msgs[topic] = make(map[int32]*partitionSet)
@@ -1160,11 +1539,12 @@ func (p *asyncProducer) retryBatch(topic string, partition int32, pSet *partitio
 	produceSet.bufferBytes += pSet.bufferBytes
 	produceSet.bufferCount += len(pSet.msgs)
 	for _, msg := range pSet.msgs {
-		if msg.retries >= p.conf.Producer.Retry.Max {
+		if msg.retries-msg.splits >= p.conf.Producer.Retry.Max {
 			p.returnErrors(pSet.msgs, kerr)
 			return
 		}
 		msg.retries++
+		msg.attempts = append(msg.attempts, ProduceAttempt{Broker: broker, Partition: partition, Err: kerr})
 	}
 
 	// it's expected that a metadata refresh has been requested prior to calling retryBatch
@@ -1192,16 +1572,54 @@ func (bp *brokerProducer) handleError(sent *produceSet, err error) {
 		bp.parent.abandonBrokerConnection(bp.broker)
 		_ = bp.broker.Close()
 		bp.closing = err
+
+		// each partition consumes a retry of the budget, and its messages
+		// are either all retried or all failed to keep them in order
+		retries := make(map[topicPartition]bool)
+		retryOrFail := func(msg *ProducerMessage) {
+			tp := topicPartition{msg.Topic, msg.Partition}
+			retry, ok := retries[tp]
+			if !ok {
+				retry = bp.allowRetry()
+				retries[tp] = retry
+				if !retry {
+					Logger.Printf("producer/broker/%d not retrying %s/%d because the retry budget is exhausted\n",
+						bp.broker.ID(), msg.Topic, msg.Partition)
+				}
+			}
+			if retry {
+				bp.parent.retryMessage(msg, bp.broker.ID(), err)
+			} else {
+				bp.parent.returnError(msg, err)
+			}
+		}
 		sent.eachPartition(func(topic string, partition int32, pSet *partitionSet) {
-			bp.parent.retryMessages(pSet.msgs, err)
+			for _, msg := range pSet.msgs {
+				retryOrFail(msg)
+			}
 		})
 		bp.buffer.eachPartition(func(topic string, partition int32, pSet *partitionSet) {
-			bp.parent.retryMessages(pSet.msgs, err)
+			for _, msg := range pSet.msgs {
+				retryOrFail(msg)
+			}
 		})
+		for _, msg := range bp.pending.dropAll() {
+			retryOrFail(msg)
+		}
 		bp.rollOver()
 	}
 }
 
+// allowRetry consumes a retry of the retry budget of the broker. The
+// idempotent producer is not limited by the budget, as the batches it would
+// fail would leave gaps in the sequence numbers of their partitions.
+func (bp *brokerProducer) allowRetry() bool {
+	if bp.parent.conf.Producer.Idempotent {
+		return true
+	}
+	return retryBudgetOf(bp.parent.client).allow(bp.broker.ID())
+}
+
 // singleton
 // effectively a "bridge" between the flushers and the dispatcher in order to avoid deadlock
 // based on https://godoc.org/github.com/eapache/channels#InfiniteChannel
@@ -1233,6 +1651,13 @@ func (p *asyncProducer) retryHandler() {
 
 func (p *asyncProducer) shutdown() {
 	Logger.Println("Producer shutting down.")
+
+	// the dispatcher keeps reading input until it is closed, so the messages
+	// being sent are accepted before the input is closed
+	p.inputLock.Lock()
+	p.inputClosed = true
+	p.inputLock.Unlock()
+
 	p.inFlight.Add(1)
 	p.input <- &ProducerMessage{flags: shutdown}
 
@@ -1247,10 +1672,32 @@ func (p *asyncProducer) shutdown() {
 	close(p.retries)
 	close(p.errors)
 	close(p.successes)
+	if p.txnEvents != nil {
+		p.txnEventsLock.Lock()
+		p.txnEventsClosed = true
+		close(p.txnEvents)
+		p.txnEventsLock.Unlock()
+	}
 
 	p.metricsRegistry.UnregisterAll()
 }
 
+// refreshProducerID refreshes the producer ID of an idle idempotent producer,
+// which has no message in flight, see Config.Producer.KeepAlive.
+func (p *asyncProducer) refreshProducerID() {
+	if p.txnmgr.currentTxnStatus() != ProducerTxnFlagReady {
+		// in a transaction, or failed
+		return
+	}
+
+	if err := p.txnmgr.refreshProducerID(); err != nil {
+		Logger.Printf("producer/txnmanager [%s] unable to refresh the producer ID of the idle producer: %s\n", p.txnmgr.transactionalID, err)
+		return
+	}
+	pid, epoch := p.txnmgr.getProducerID()
+	Logger.Printf("producer/txnmanager [%s] refreshed the producer ID of the idle producer: ProducerId %d, ProducerEpoch %d\n", p.txnmgr.transactionalID, pid, epoch)
+}
+
 func (p *asyncProducer) bumpIdempotentProducerEpoch() {
 	_, epoch := p.txnmgr.getProducerID()
 	if epoch == math.MaxInt16 {
@@ -1261,6 +1708,7 @@ func (p *asyncProducer) bumpIdempotentProducerEpoch() {
 			return
 		}
 
+		p.observeTxn(txnmgr)
 		p.txnmgr = txnmgr
 	} else {
 		p.txnmgr.bumpEpoch()
@@ -1291,8 +1739,11 @@ func (p *asyncProducer) returnError(msg *ProducerMessage, err error) {
 		p.bumpIdempotentProducerEpoch()
 	}
 
+	attempts := msg.attempts
 	msg.clear()
-	pErr := &ProducerError{Msg: msg, Err: err}
+	atomic.AddInt32(&p.undelivered, -1)
+	pErr := &ProducerError{Msg: msg, Err: err, Attempts: attempts}
+	msg.ack(err)
 	if p.conf.Producer.Return.Errors {
 		p.errors <- pErr
 	} else {
@@ -1307,28 +1758,68 @@ func (p *asyncProducer) returnErrors(batch []*ProducerMessage, err error) {
 	}
 }
 
+// returnRecordErrors returns the messages of a batch rejected with block, each
+// with the error messages of block which concern it.
+func (p *asyncProducer) returnRecordErrors(batch []*ProducerMessage, block *ProduceResponseBlock) {
+	var message string
+	if block.ErrorMessage != nil {
+		message = *block.ErrorMessage
+	}
+	recordErrors := make(map[int32]*string, len(block.RecordErrors))
+	for _, recordError := range block.RecordErrors {
+		recordErrors[recordError.BatchIndex] = recordError.BatchIndexErrorMessage
+	}
+	for i, msg := range batch {
+		err := &ProduceRecordError{Err: block.Err, BatchIndex: int32(i), Message: message}
+		if recordMessage, ok := recordErrors[int32(i)]; ok {
+			err.Invalid = true
+			if recordMessage != nil {
+				err.Message = *recordMessage
+			}
+		}
+		p.returnError(msg, err)
+	}
+}
+
 func (p *asyncProducer) returnSuccesses(batch []*ProducerMessage) {
 	for _, msg := range batch {
+		msg.ack(nil)
 		if p.conf.Producer.Return.Successes {
 			msg.clear()
 			p.successes <- msg
 		}
+		atomic.AddInt32(&p.undelivered, -1)
 		p.inFlight.Done()
 	}
 }
 
-func (p *asyncProducer) retryMessage(msg *ProducerMessage, err error) {
-	if msg.retries >= p.conf.Producer.Retry.Max {
+func (p *asyncProducer) retryMessage(msg *ProducerMessage, broker int32, err error) {
+	// the splits do not count, and the fins of their retry levels may be past
+	// Producer.Retry.Max
+	if msg.flags&fin == 0 && msg.retries-msg.splits >= p.conf.Producer.Retry.Max {
 		p.returnError(msg, err)
 	} else {
 		msg.retries++
+		msg.attempts = append(msg.attempts, ProduceAttempt{Broker: broker, Partition: msg.Partition, Err: err})
 		p.retries <- msg
 	}
 }
 
-func (p *asyncProducer) retryMessages(batch []*ProducerMessage, err error) {
+func (p *asyncProducer) retryMessages(batch []*ProducerMessage, broker int32, err error) {
 	for _, msg := range batch {
-		p.retryMessage(msg, err)
+		p.retryMessage(msg, broker, err)
+	}
+}
+
+// splitMessages retries the messages of a batch rejected as too large, in
+// smaller batches as limited by their batchLimit, without consuming their
+// Producer.Retry.Max.
+func (p *asyncProducer) splitMessages(batch []*ProducerMessage, broker int32, err error) {
+	for _, msg := range batch {
+		msg.splits++
+		msg.retries++
+		msg.attempts = append(msg.attempts, ProduceAttempt{Broker: broker, Partition: msg.Partition, Err: err})
+		p.retries <- msg
 	}
 }
 
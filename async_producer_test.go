@@ -1,6 +1,7 @@
 package sarama
 
 import (
+	"context"
 	"errors"
 	"log"
 	"math"
@@ -217,6 +218,10 @@ func TestAsyncProducerMultipleFlushes(t *testing.T) {
 		expectResults(t, producer, 5, 0)
 	}
 
+	if count := getOrRegisterHistogram("queue-wait-time-in-ms-for-partition-my_topic-0", config.MetricRegistry).Count(); count != 3 {
+		t.Error("Expected the queue wait time of 3 batches, got", count)
+	}
+
 	closeProducer(t, producer)
 	leader.Close()
 	seedBroker.Close()
@@ -616,6 +621,96 @@ func TestAsyncProducerMultipleRetries(t *testing.T) {
 	closeProducer(t, producer)
 }
 
+func TestAsyncProducerRetryBudgetExhausted(t *testing.T) {
+	seedBroker := NewMockBroker(t, 1)
+	leader := NewMockBroker(t, 2)
+
+	metadataLeader := new(MetadataResponse)
+	metadataLeader.AddBroker(leader.Addr(), leader.BrokerID())
+	metadataLeader.AddTopicPartition("my_topic", 0, leader.BrokerID(), nil, nil, nil, ErrNoError)
+	seedBroker.Returns(metadataLeader)
+
+	config := NewTestConfig()
+	config.Producer.Flush.Messages = 10
+	config.Producer.Return.Successes = true
+	config.Producer.Retry.Max = 4
+	config.Producer.Retry.Backoff = 0
+	config.RetryBudget.Max = 1
+	config.RetryBudget.Interval = time.Hour
+	producer, err := NewAsyncProducer([]string{seedBroker.Addr()}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 10; i++ {
+		producer.Input() <- &ProducerMessage{Topic: "my_topic", Key: nil, Value: StringEncoder(TestMessage)}
+	}
+	prodNotLeader := new(ProduceResponse)
+	prodNotLeader.AddTopicPartition("my_topic", 0, ErrNotLeaderForPartition)
+	leader.Returns(prodNotLeader)
+	seedBroker.Returns(metadataLeader)
+	leader.Returns(prodNotLeader)
+
+	// the first retry consumes the budget of the leader, so the messages fail
+	// without using the remaining Producer.Retry.Max retries
+	expectResults(t, producer, 0, 10)
+
+	if exhausted := metrics.GetOrRegisterMeter("retry-budget-exhausted-rate-for-broker-2", config.MetricRegistry).Count(); exhausted != 1 {
+		t.Error("expected the retry budget of the leader to be exhausted once, got", exhausted)
+	}
+
+	seedBroker.Close()
+	leader.Close()
+	closeProducer(t, producer)
+}
+
+func TestAsyncProducerRetryBudgetExhaustedOnBrokerError(t *testing.T) {
+	seedBroker := NewMockBroker(t, 1)
+	leader1 := NewMockBroker(t, 2)
+	leader2 := NewMockBroker(t, 3)
+
+	metadataLeader1 := new(MetadataResponse)
+	metadataLeader1.AddBroker(leader1.Addr(), leader1.BrokerID())
+	metadataLeader1.AddTopicPartition("my_topic", 0, leader1.BrokerID(), nil, nil, nil, ErrNoError)
+	metadataLeader1.AddTopicPartition("my_topic", 1, leader1.BrokerID(), nil, nil, nil, ErrNoError)
+	seedBroker.Returns(metadataLeader1)
+
+	config := NewTestConfig()
+	config.Producer.Flush.Frequency = 50 * time.Millisecond
+	config.Producer.Return.Successes = true
+	config.Producer.Retry.Max = 4
+	config.Producer.Retry.Backoff = 0
+	config.Producer.Partitioner = NewManualPartitioner
+	config.RetryBudget.Max = 1
+	config.RetryBudget.Interval = time.Hour
+	producer, err := NewAsyncProducer([]string{seedBroker.Addr()}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	leader1.Close()
+	for i := 0; i < 10; i++ {
+		producer.Input() <- &ProducerMessage{Topic: "my_topic", Partition: int32(i % 2), Value: StringEncoder(TestMessage)}
+	}
+
+	metadataLeader2 := new(MetadataResponse)
+	metadataLeader2.AddBroker(leader2.Addr(), leader2.BrokerID())
+	metadataLeader2.AddTopicPartition("my_topic", 0, leader2.BrokerID(), nil, nil, nil, ErrNoError)
+	metadataLeader2.AddTopicPartition("my_topic", 1, leader2.BrokerID(), nil, nil, nil, ErrNoError)
+	seedBroker.Returns(metadataLeader2)
+	prodSuccess := new(ProduceResponse)
+	prodSuccess.AddTopicPartition("my_topic", 0, ErrNoError)
+	prodSuccess.AddTopicPartition("my_topic", 1, ErrNoError)
+	leader2.Returns(prodSuccess)
+
+	// the budget of the first leader allows a single partition to be retried
+	expectResults(t, producer, 5, 5)
+
+	seedBroker.Close()
+	leader2.Close()
+	closeProducer(t, producer)
+}
+
 func TestAsyncProducerMultipleRetriesWithBackoffFunc(t *testing.T) {
 	seedBroker := NewMockBroker(t, 1)
 	leader1 := NewMockBroker(t, 2)
@@ -1489,6 +1584,127 @@ func TestAsyncProducerIdempotentEpochRollover(t *testing.T) {
 	}
 }
 
+// TestAsyncProducerIdempotentKeepAlive ensures that an idle producer
+// refreshes its producerID, and produces with it from sequence zero
+func TestAsyncProducerIdempotentKeepAlive(t *testing.T) {
+	broker := NewMockBroker(t, 1)
+	defer broker.Close()
+
+	metadataResponse := &MetadataResponse{
+		Version:      4,
+		ControllerID: 1,
+	}
+	metadataResponse.AddBroker(broker.Addr(), broker.BrokerID())
+	metadataResponse.AddTopicPartition("my_topic", 0, broker.BrokerID(), nil, nil, nil, ErrNoError)
+	broker.Returns(metadataResponse)
+	broker.Returns(&InitProducerIDResponse{ProducerID: 1000})
+
+	config := NewTestConfig()
+	config.Producer.Flush.Frequency = 10 * time.Millisecond
+	config.Producer.Return.Successes = true
+	config.Producer.RequiredAcks = WaitForAll
+	config.Producer.Idempotent = true
+	config.Producer.KeepAlive = 50 * time.Millisecond
+	config.Net.MaxOpenRequests = 1
+	config.Version = V0_11_0_0
+
+	producer, err := NewAsyncProducer([]string{broker.Addr()}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeProducer(t, producer)
+
+	lastProduceBatch := func() *RecordBatch {
+		history := broker.History()
+		return history[len(history)-1].Request.(*ProduceRequest).records["my_topic"][0].RecordBatch
+	}
+	prodSuccess := &ProduceResponse{Version: 3}
+	prodSuccess.AddTopicPartition("my_topic", 0, ErrNoError)
+
+	broker.Returns(prodSuccess)
+	producer.Input() <- &ProducerMessage{Topic: "my_topic", Value: StringEncoder("hello")}
+	<-producer.Successes()
+	producer.Input() <- &ProducerMessage{Topic: "my_topic", Value: StringEncoder("hello")}
+	broker.Returns(prodSuccess)
+	<-producer.Successes()
+	if batch := lastProduceBatch(); batch.ProducerID != 1000 || batch.FirstSequence != 1 {
+		t.Fatalf("unexpected producer ID %d and sequence %d", batch.ProducerID, batch.FirstSequence)
+	}
+
+	broker.Returns(&InitProducerIDResponse{ProducerID: 1001})
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		inits := 0
+		for _, rr := range broker.History() {
+			if _, ok := rr.Request.(*InitProducerIDRequest); ok {
+				inits++
+			}
+		}
+		if inits == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the idle producer did not refresh its producer ID")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	broker.Returns(prodSuccess)
+	producer.Input() <- &ProducerMessage{Topic: "my_topic", Value: StringEncoder("hello")}
+	<-producer.Successes()
+	if batch := lastProduceBatch(); batch.ProducerID != 1001 || batch.FirstSequence != 0 {
+		t.Errorf("expected the refreshed producer ID 1001 from sequence 0, got %d and %d", batch.ProducerID, batch.FirstSequence)
+	}
+}
+
+// TestAsyncProducerIdempotentKeepAliveRetry ensures that the producer ID
+// refresh of an idle producer does not block a retry in flight
+func TestAsyncProducerIdempotentKeepAliveRetry(t *testing.T) {
+	broker := NewMockBroker(t, 1)
+	defer broker.Close()
+
+	metadataResponse := &MetadataResponse{
+		Version:      4,
+		ControllerID: 1,
+	}
+	metadataResponse.AddBroker(broker.Addr(), broker.BrokerID())
+	metadataResponse.AddTopicPartition("my_topic", 0, broker.BrokerID(), nil, nil, nil, ErrNoError)
+	broker.SetHandlerByMap(map[string]MockResponse{
+		"MetadataRequest":       NewMockWrapper(metadataResponse),
+		"InitProducerIDRequest": NewMockWrapper(&InitProducerIDResponse{ProducerID: 1000}),
+		"ProduceRequest": NewMockSequence(
+			NewMockProduceResponse(t).SetVersion(3).SetError("my_topic", 0, ErrNotEnoughReplicas),
+			NewMockProduceResponse(t).SetVersion(3),
+		),
+	})
+	// the producer is idle for KeepAlive while waiting for the first response
+	broker.SetLatency(100 * time.Millisecond)
+
+	config := NewTestConfig()
+	config.Producer.Return.Successes = true
+	config.Producer.RequiredAcks = WaitForAll
+	config.Producer.Idempotent = true
+	config.Producer.KeepAlive = 20 * time.Millisecond
+	config.Producer.Retry.Backoff = 0
+	config.Net.MaxOpenRequests = 1
+	config.Version = V0_11_0_0
+
+	producer, err := NewAsyncProducer([]string{broker.Addr()}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	producer.Input() <- &ProducerMessage{Topic: "my_topic", Value: StringEncoder("hello")}
+	select {
+	case <-producer.Successes():
+	case err := <-producer.Errors():
+		t.Fatal(err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the retried message was not delivered")
+	}
+	closeProducer(t, producer)
+}
+
 // TestAsyncProducerIdempotentEpochExhaustion ensures that producer requests
 // a new producerID when producerEpoch is exhausted
 func TestAsyncProducerIdempotentEpochExhaustion(t *testing.T) {
@@ -1825,7 +2041,7 @@ func TestTxnProduceBumpEpoch(t *testing.T) {
 	broker.Returns(addPartitionsToTxnResponse)
 
 	produceResponse := new(ProduceResponse)
-	produceResponse.Version = 7
+	produceResponse.Version = 8
 	produceResponse.AddTopicPartition("test-topic", 0, ErrOutOfOrderSequenceNumber)
 	broker.Returns(produceResponse)
 
@@ -1942,6 +2158,83 @@ func TestTxnProduceRecordWithCommit(t *testing.T) {
 	require.Equal(t, ProducerTxnFlagReady, producer.txnmgr.status)
 }
 
+func TestTxnStateAndEvents(t *testing.T) {
+	broker := NewMockBroker(t, 1)
+	defer broker.Close()
+
+	config := NewTestConfig()
+	config.Producer.Idempotent = true
+	config.Producer.Transaction.ID = "test"
+	config.Producer.Transaction.Events = true
+	config.Producer.Transaction.Retry.Backoff = 0
+	config.Producer.Return.Successes = true
+	config.Version = V0_11_0_0
+	config.Producer.RequiredAcks = WaitForAll
+	config.Net.MaxOpenRequests = 1
+
+	metadataLeader := new(MetadataResponse)
+	metadataLeader.Version = 4
+	metadataLeader.ControllerID = broker.brokerID
+	metadataLeader.AddBroker(broker.Addr(), broker.BrokerID())
+	metadataLeader.AddTopic("test-topic", ErrNoError)
+	metadataLeader.AddTopicPartition("test-topic", 0, broker.BrokerID(), nil, nil, nil, ErrNoError)
+	broker.Returns(metadataLeader)
+
+	client, err := NewClient([]string{broker.Addr()}, config)
+	require.NoError(t, err)
+	defer client.Close()
+
+	broker.Returns(&FindCoordinatorResponse{
+		Coordinator: client.Brokers()[0],
+		Err:         ErrNoError,
+		Version:     1,
+	})
+	broker.Returns(&InitProducerIDResponse{ProducerID: 1})
+
+	ap, err := NewAsyncProducerFromClient(client)
+	require.NoError(t, err)
+	defer ap.Close()
+	reporter, ok := ap.(TxnStateReporter)
+	require.True(t, ok, "expected the producer to be a TxnStateReporter")
+
+	broker.Returns(&AddPartitionsToTxnResponse{
+		Errors: map[string][]*PartitionError{"test-topic": {{Partition: 0}}},
+	})
+	produceResponse := new(ProduceResponse)
+	produceResponse.Version = 3
+	produceResponse.AddTopicPartition("test-topic", 0, ErrNoError)
+	broker.Returns(produceResponse)
+
+	require.NoError(t, ap.BeginTxn())
+	ap.Input() <- &ProducerMessage{Topic: "test-topic", Value: StringEncoder(TestMessage)}
+	<-ap.Successes()
+
+	state := reporter.TxnState()
+	require.Equal(t, "test", state.TransactionalID)
+	require.Equal(t, ProducerTxnFlagInTransaction, state.Status)
+	require.Equal(t, map[string][]int32{"test-topic": {0}}, state.Partitions)
+	require.Empty(t, state.PendingPartitions)
+	require.NoError(t, state.LastCoordinatorError)
+
+	broker.Returns(&EndTxnResponse{Err: ErrConcurrentTransactions})
+	broker.Returns(&EndTxnResponse{Err: ErrNoError})
+	require.NoError(t, ap.CommitTxn())
+
+	state = reporter.TxnState()
+	require.Equal(t, ProducerTxnFlagReady, state.Status)
+	require.Empty(t, state.Partitions)
+	require.ErrorIs(t, state.LastCoordinatorError, ErrConcurrentTransactions)
+
+	var types []TxnEventType
+	for len(types) < 3 {
+		types = append(types, (<-reporter.TxnEvents()).Type)
+	}
+	require.Equal(t, []TxnEventType{TxnEventBegin, TxnEventTransition, TxnEventCommit}, types)
+
+	require.Equal(t, int64(1), config.MetricRegistry.Get("txn-commit-rate").(metrics.Meter).Count())
+	require.Equal(t, int64(1), config.MetricRegistry.Get("txn-coordinator-error-rate").(metrics.Meter).Count())
+}
+
 func TestTxnProduceBatchAddPartition(t *testing.T) {
 	broker := NewMockBroker(t, 1)
 	defer broker.Close()
@@ -2130,6 +2423,126 @@ func TestTxnProduceRecordWithAbort(t *testing.T) {
 	require.Equal(t, ProducerTxnFlagReady, producer.txnmgr.status)
 }
 
+// mockResponseFunc is a MockResponse built by a function of the request.
+type mockResponseFunc func(req versionedDecoder) encoderWithHeader
+
+func (f mockResponseFunc) For(reqBody versionedDecoder) encoderWithHeader {
+	return f(reqBody)
+}
+
+func newTwoPhaseCommitBroker(t *testing.T, initProducerID *InitProducerIDResponse) *MockBroker {
+	broker := NewMockBroker(t, 1)
+	broker.SetHandlerByMap(map[string]MockResponse{
+		"MetadataRequest": NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetController(broker.BrokerID()).
+			SetLeader("test-topic", 0, broker.BrokerID()),
+		"FindCoordinatorRequest": NewMockFindCoordinatorResponse(t).
+			SetCoordinator(CoordinatorTransaction, "test", broker),
+		"InitProducerIDRequest": mockResponseFunc(func(req versionedDecoder) encoderWithHeader {
+			res := *initProducerID
+			res.Version = req.(*InitProducerIDRequest).version()
+			return &res
+		}),
+		"AddPartitionsToTxnRequest": mockResponseFunc(func(req versionedDecoder) encoderWithHeader {
+			return &AddPartitionsToTxnResponse{
+				Version: req.(*AddPartitionsToTxnRequest).version(),
+				Errors:  map[string][]*PartitionError{"test-topic": {{Partition: 0}}},
+			}
+		}),
+		"ProduceRequest": NewMockProduceResponse(t),
+		"EndTxnRequest": mockResponseFunc(func(req versionedDecoder) encoderWithHeader {
+			return &EndTxnResponse{Version: req.(*EndTxnRequest).version()}
+		}),
+	})
+	return broker
+}
+
+func newTwoPhaseCommitConfig() *Config {
+	config := NewTestConfig()
+	config.Producer.Idempotent = true
+	config.Producer.Transaction.ID = "test"
+	config.Producer.Transaction.TwoPhaseCommit = true
+	config.Producer.Return.Successes = true
+	config.Version = V4_1_0_0
+	config.ApiVersionsRequest = false
+	config.Producer.RequiredAcks = WaitForAll
+	config.Net.MaxOpenRequests = 1
+	return config
+}
+
+func lastEndTxnRequest(t *testing.T, broker *MockBroker) *EndTxnRequest {
+	t.Helper()
+	var endTxn *EndTxnRequest
+	for _, rr := range broker.History() {
+		if req, ok := rr.Request.(*EndTxnRequest); ok {
+			endTxn = req
+		}
+	}
+	require.NotNil(t, endTxn)
+	return endTxn
+}
+
+func TestTxnTwoPhaseCommit(t *testing.T) {
+	broker := newTwoPhaseCommitBroker(t, &InitProducerIDResponse{
+		ProducerID:           1,
+		OngoingTxnProducerID: -1,
+	})
+	defer broker.Close()
+
+	producer, err := NewAsyncProducer([]string{broker.Addr()}, newTwoPhaseCommitConfig())
+	require.NoError(t, err)
+	defer closeProducer(t, producer)
+	committer, ok := producer.(TwoPhaseCommitter)
+	require.True(t, ok, "expected the producer to be a TwoPhaseCommitter")
+
+	initProducerID := broker.History()[len(broker.History())-1].Request.(*InitProducerIDRequest)
+	require.Equal(t, int16(6), initProducerID.Version)
+	require.True(t, initProducerID.Enable2Pc)
+	require.False(t, initProducerID.KeepPreparedTxn)
+
+	require.NoError(t, producer.BeginTxn())
+	producer.Input() <- &ProducerMessage{Topic: "test-topic", Value: StringEncoder(TestMessage)}
+	<-producer.Successes()
+
+	state, err := committer.PrepareTxn()
+	require.NoError(t, err)
+	require.Equal(t, PreparedTxnState{ProducerID: 1}, state)
+	require.Equal(t, ProducerTxnFlagPreparedTransaction, producer.TxnStatus())
+	require.ErrorIs(t, producer.BeginTxn(), ErrTransitionNotAllowed)
+
+	require.NoError(t, committer.CompleteTxn(state))
+	require.Equal(t, ProducerTxnFlagReady, producer.TxnStatus())
+	require.True(t, lastEndTxnRequest(t, broker).TransactionResult)
+}
+
+func TestTxnTwoPhaseCommitKeepPreparedTxn(t *testing.T) {
+	broker := newTwoPhaseCommitBroker(t, &InitProducerIDResponse{
+		ProducerID:              1,
+		ProducerEpoch:           3,
+		OngoingTxnProducerID:    1,
+		OngoingTxnProducerEpoch: 2,
+	})
+	defer broker.Close()
+
+	config := newTwoPhaseCommitConfig()
+	config.Producer.Transaction.KeepPreparedTxn = true
+	producer, err := NewAsyncProducer([]string{broker.Addr()}, config)
+	require.NoError(t, err)
+	defer closeProducer(t, producer)
+	committer, ok := producer.(TwoPhaseCommitter)
+	require.True(t, ok, "expected the producer to be a TwoPhaseCommitter")
+
+	require.Equal(t, ProducerTxnFlagPreparedTransaction, producer.TxnStatus())
+
+	// the external coordinator did not record the prepared transaction
+	require.NoError(t, committer.CompleteTxn(PreparedTxnState{ProducerID: 1, ProducerEpoch: 1}))
+	require.Equal(t, ProducerTxnFlagReady, producer.TxnStatus())
+	endTxn := lastEndTxnRequest(t, broker)
+	require.False(t, endTxn.TransactionResult)
+	require.Equal(t, int16(3), endTxn.ProducerEpoch)
+}
+
 func TestTxnCanAbort(t *testing.T) {
 	broker := NewMockBroker(t, 1)
 	defer broker.Close()
@@ -2323,3 +2736,200 @@ ProducerLoop:
 
 	log.Printf("Successfully produced: %d; errors: %d\n", successes, producerErrors)
 }
+
+func TestAsyncProducerTrySend(t *testing.T) {
+	seedBroker := NewMockBroker(t, 1)
+	defer seedBroker.Close()
+	seedBroker.SetHandlerByMap(map[string]MockResponse{
+		"MetadataRequest": NewMockMetadataResponse(t).
+			SetBroker(seedBroker.Addr(), seedBroker.BrokerID()),
+	})
+
+	config := NewTestConfig()
+	config.ChannelBufferSize = 1
+	config.Metadata.Retry.Max = 0
+	producer, err := NewAsyncProducer([]string{seedBroker.Addr()}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the errors of the unknown topic are not read, stalling the producer
+	sent := 0
+	for ; sent < 100; sent++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		err := producer.SendWithContext(ctx, &ProducerMessage{Topic: "unknown", Value: StringEncoder(TestMessage)})
+		cancel()
+		if errors.Is(err, context.DeadlineExceeded) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if sent == 100 {
+		t.Fatal("expected the stalled producer to stop accepting messages")
+	}
+
+	if err := producer.TrySend(&ProducerMessage{Topic: "unknown", Value: StringEncoder(TestMessage)}); !errors.Is(err, ErrProducerQueueFull) {
+		t.Errorf("expected %v, got %v", ErrProducerQueueFull, err)
+	}
+
+	producer.AsyncClose()
+	failed := 0
+	for range producer.Errors() {
+		failed++
+	}
+	if failed != sent {
+		t.Errorf("expected the %d messages sent to fail, got %d errors", sent, failed)
+	}
+
+	// the input is closed once shut down
+	if err := producer.TrySend(&ProducerMessage{Topic: "unknown"}); !errors.Is(err, ErrShuttingDown) {
+		t.Errorf("expected %v once closed, got %v", ErrShuttingDown, err)
+	}
+	if err := producer.SendWithContext(context.Background(), &ProducerMessage{Topic: "unknown"}); !errors.Is(err, ErrShuttingDown) {
+		t.Errorf("expected %v once closed, got %v", ErrShuttingDown, err)
+	}
+}
+
+// tooLargeProduceResponse rejects the batches of more than maxBatch messages,
+// and the messages with a "huge" value, with ErrMessageSizeTooLarge.
+type tooLargeProduceResponse struct {
+	maxBatch int
+
+	lock    sync.Mutex
+	offset  int64
+	batches []int
+}
+
+func (r *tooLargeProduceResponse) For(reqBody versionedDecoder) encoderWithHeader {
+	req := reqBody.(*ProduceRequest)
+	res := &ProduceResponse{Version: req.Version}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	batch := req.records["my_topic"][0].RecordBatch
+	r.batches = append(r.batches, len(batch.Records))
+	tooLarge := len(batch.Records) > r.maxBatch
+	for _, record := range batch.Records {
+		tooLarge = tooLarge || string(record.Value) == "huge"
+	}
+	if tooLarge {
+		res.AddTopicPartition("my_topic", 0, ErrMessageSizeTooLarge)
+		return res
+	}
+	res.AddTopicPartition("my_topic", 0, ErrNoError)
+	res.GetBlock("my_topic", 0).Offset = r.offset
+	r.offset += int64(len(batch.Records))
+	return res
+}
+
+func TestAsyncProducerSplitsTooLargeBatches(t *testing.T) {
+	seedBroker := NewMockBroker(t, 1)
+	defer seedBroker.Close()
+	leader := NewMockBroker(t, 2)
+	defer leader.Close()
+
+	metadataResponse := NewMockMetadataResponse(t).
+		SetBroker(leader.Addr(), leader.BrokerID()).
+		SetLeader("my_topic", 0, leader.BrokerID())
+	seedBroker.SetHandlerByMap(map[string]MockResponse{"MetadataRequest": metadataResponse})
+	produceResponse := &tooLargeProduceResponse{maxBatch: 2}
+	leader.SetHandlerByMap(map[string]MockResponse{
+		"MetadataRequest": metadataResponse,
+		"ProduceRequest":  produceResponse,
+	})
+
+	config := NewTestConfig()
+	config.Version = V0_11_0_0
+	config.Producer.Flush.Messages = 6
+	config.Producer.Return.Successes = true
+	config.Producer.Retry.Max = 1
+	producer, err := NewAsyncProducer([]string{seedBroker.Addr()}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values := []string{"v0", "v1", "v2", "huge", "v4", "v5"}
+	for _, value := range values {
+		producer.Input() <- &ProducerMessage{Topic: "my_topic", Value: StringEncoder(value)}
+	}
+
+	var offsets []int64
+	var tooLarge []string
+	for range values {
+		select {
+		case msg := <-producer.Successes():
+			offsets = append(offsets, msg.Offset)
+		case perr := <-producer.Errors():
+			if !errors.Is(perr.Err, ErrMessageSizeTooLarge) {
+				t.Errorf("expected ErrMessageSizeTooLarge, got %v", perr.Err)
+			}
+			value, _ := perr.Msg.Value.Encode()
+			tooLarge = append(tooLarge, string(value))
+		}
+	}
+	closeProducer(t, producer)
+
+	// the splits do not consume Producer.Retry.Max
+	require.Equal(t, []int64{0, 1, 2, 3, 4}, offsets, "the messages should be delivered in order")
+	require.Equal(t, []string{"huge"}, tooLarge, "only the huge message should fail")
+	produceResponse.lock.Lock()
+	defer produceResponse.lock.Unlock()
+	for _, size := range produceResponse.batches[1:] {
+		if size > 3 {
+			t.Errorf("expected the retried batches to be split, got batches of %v messages", produceResponse.batches)
+			break
+		}
+	}
+}
+
+func TestAsyncProducerRecordErrors(t *testing.T) {
+	seedBroker := NewMockBroker(t, 1)
+	defer seedBroker.Close()
+	leader := NewMockBroker(t, 2)
+	defer leader.Close()
+
+	metadataResponse := NewMockMetadataResponse(t).
+		SetBroker(leader.Addr(), leader.BrokerID()).
+		SetLeader("my_topic", 0, leader.BrokerID())
+	seedBroker.SetHandlerByMap(map[string]MockResponse{"MetadataRequest": metadataResponse})
+	badKey := "record 1 has no key"
+	produceResponse := &ProduceResponse{Version: 8}
+	produceResponse.AddTopicPartition("my_topic", 0, ErrInvalidRecord)
+	produceResponse.GetBlock("my_topic", 0).RecordErrors = []*ProduceResponseRecordError{
+		{BatchIndex: 1, BatchIndexErrorMessage: &badKey},
+	}
+	leader.SetHandlerByMap(map[string]MockResponse{
+		"MetadataRequest": metadataResponse,
+		"ProduceRequest":  NewMockWrapper(produceResponse),
+	})
+
+	config := NewTestConfig()
+	config.Version = V2_4_0_0
+	config.ApiVersionsRequest = false
+	config.Producer.Flush.Messages = 3
+	config.Producer.Retry.Max = 0
+	producer, err := NewAsyncProducer([]string{seedBroker.Addr()}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		producer.Input() <- &ProducerMessage{Topic: "my_topic", Value: StringEncoder(TestMessage), Metadata: i}
+	}
+	for i := 0; i < 3; i++ {
+		perr := <-producer.Errors()
+		var recordErr *ProduceRecordError
+		require.True(t, errors.As(perr.Err, &recordErr), "expected a ProduceRecordError, got %v", perr.Err)
+		require.ErrorIs(t, perr, ErrInvalidRecord)
+		require.Equal(t, int32(perr.Msg.Metadata.(int)), recordErr.BatchIndex)
+		if recordErr.BatchIndex == 1 {
+			require.True(t, recordErr.Invalid, "expected the record 1 to be invalid")
+			require.Equal(t, badKey, recordErr.Message)
+		} else {
+			require.False(t, recordErr.Invalid, "expected the record %d to be rejected with its batch only", recordErr.BatchIndex)
+		}
+	}
+	closeProducer(t, producer)
+}
@@ -0,0 +1,246 @@
+package sarama
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// AuditEventType is the kind of an AuditEvent.
+type AuditEventType int
+
+const (
+	// AuditAuthentication is the SASL authentication, or re-authentication,
+	// of a connection to a broker.
+	AuditAuthentication AuditEventType = iota
+	// AuditAuthorizationDenied is an error of a broker response denying an
+	// operation to the client because of the ACLs of the cluster.
+	AuditAuthorizationDenied
+	// AuditAdminOperation is an operation of a ClusterAdmin changing the
+	// cluster, such as the creation of a topic or of an ACL.
+	AuditAdminOperation
+)
+
+func (t AuditEventType) String() string {
+	switch t {
+	case AuditAuthentication:
+		return "Authentication"
+	case AuditAuthorizationDenied:
+		return "AuthorizationDenied"
+	case AuditAdminOperation:
+		return "AdminOperation"
+	}
+	return fmt.Sprintf("AuditEventType(%d)", int(t))
+}
+
+// AuditEvent is a security sensitive operation of a client, passed to
+// Config.AuditHook.
+type AuditEvent struct {
+	Type AuditEventType
+	Time time.Time
+
+	// ClientID is the Config.ClientID of the client, and User the SASL user
+	// it authenticates as, if known, with Mechanism.
+	ClientID  string
+	User      string
+	Mechanism SASLMechanism
+
+	// BrokerID and Broker are the ID and the address of the broker, or -1 and
+	// an empty string for the admin operations, which may involve several.
+	BrokerID int32
+	Broker   string
+
+	// Operation is the SASL mechanism of the authentications, the request
+	// whose response denied the operation, such as "Produce" or
+	// "FindCoordinator", or the name of the ClusterAdmin method.
+	Operation string
+	// Resource is the topic, group, config resource, ACL resource, user or
+	// quota entity the operation applied to, if known.
+	Resource string
+	// ValidateOnly is set for the admin operations which were only validated
+	// by the cluster.
+	ValidateOnly bool
+	// Err is the error of the operation, nil if it succeeded. It is always
+	// set for the AuditAuthorizationDenied events.
+	Err error
+}
+
+// audit passes event to the audit hook of conf, if any, filling in the
+// identity of the client.
+func audit(conf *Config, event *AuditEvent) {
+	if conf == nil || conf.AuditHook == nil {
+		return
+	}
+	event.Time = time.Now()
+	event.ClientID = conf.ClientID
+	if conf.Net.SASL.Enable {
+		event.Mechanism = conf.Net.SASL.Mechanism
+		switch event.Mechanism {
+		case SASLTypeGSSAPI:
+			event.User = conf.Net.SASL.GSSAPI.Username
+		case SASLTypeOAuth:
+			// the principal is in the token
+		default:
+			event.User = conf.Net.SASL.User
+		}
+	}
+	conf.AuditHook(event)
+}
+
+// auditAuthentication reports the SASL authentication of the broker
+// connection, which failed with err if not nil.
+func (b *Broker) auditAuthentication(err error) {
+	audit(b.conf, &AuditEvent{
+		Type:      AuditAuthentication,
+		BrokerID:  b.id,
+		Broker:    b.addr,
+		Operation: string(b.conf.Net.SASL.Mechanism),
+		Err:       err,
+	})
+}
+
+// auditResponse reports the authorization errors of the response to req.
+func (b *Broker) auditResponse(req, res protocolBody) {
+	if b.conf == nil || b.conf.AuditHook == nil || res == nil {
+		return
+	}
+	operation := strings.TrimSuffix(reflect.TypeOf(req).Elem().Name(), "Request")
+	findAuthorizationErrors(reflect.ValueOf(res), "", func(kerr KError, resource string) {
+		audit(b.conf, &AuditEvent{
+			Type:      AuditAuthorizationDenied,
+			BrokerID:  b.id,
+			Broker:    b.addr,
+			Operation: operation,
+			Resource:  resource,
+			Err:       kerr,
+		})
+	})
+}
+
+// auditAdmin reports the admin operation on each of the resources, or on none
+// when there are none.
+func (ca *clusterAdmin) auditAdmin(operation string, validateOnly bool, err error, resources ...string) {
+	if len(resources) == 0 {
+		resources = []string{""}
+	}
+	for _, resource := range resources {
+		resourceErr := err
+		if bulkErr, ok := err.(BulkError); ok {
+			resourceErr = bulkErr[resource]
+		}
+		audit(ca.conf, &AuditEvent{
+			Type:         AuditAdminOperation,
+			BrokerID:     -1,
+			Operation:    operation,
+			Resource:     resource,
+			ValidateOnly: validateOnly,
+			Err:          resourceErr,
+		})
+	}
+}
+
+func isAuthorizationError(kerr KError) bool {
+	switch kerr {
+	case ErrTopicAuthorizationFailed, ErrGroupAuthorizationFailed, ErrClusterAuthorizationFailed,
+		ErrTransactionalIDAuthorizationFailed, ErrDelegationTokenAuthorizationFailed:
+		return true
+	}
+	return false
+}
+
+var (
+	kerrorType  = reflect.TypeOf(ErrNoError)
+	recordsType = reflect.TypeOf(Records{})
+	brokerType  = reflect.TypeOf(Broker{})
+)
+
+// resourceFields are the fields naming the resource of the errors of a
+// response struct.
+var resourceFields = []string{"Name", "Topic", "ResourceName", "GroupId", "GroupID", "TransactionalID"}
+
+// findAuthorizationErrors calls found with the authorization errors of the
+// exported fields of v, and the name of their resource: the nearest string
+// map key or resource field.
+func findAuthorizationErrors(v reflect.Value, resource string, found func(kerr KError, resource string)) {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if !v.IsNil() {
+			findAuthorizationErrors(v.Elem(), resource, found)
+		}
+	case reflect.Struct:
+		if v.Type() == recordsType || v.Type() == brokerType {
+			return
+		}
+		for _, name := range resourceFields {
+			if f := v.FieldByName(name); f.IsValid() && f.Kind() == reflect.String && f.String() != "" {
+				resource = f.String()
+				break
+			}
+		}
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if field.PkgPath != "" {
+				continue
+			}
+			if field.Name == "ErrorCode" && field.Type.Kind() == reflect.Int16 {
+				if kerr := KError(v.Field(i).Int()); isAuthorizationError(kerr) {
+					found(kerr, resource)
+				}
+				continue
+			}
+			findAuthorizationErrors(v.Field(i), resource, found)
+		}
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return
+		}
+		for i := 0; i < v.Len(); i++ {
+			findAuthorizationErrors(v.Index(i), resource, found)
+		}
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			key := resource
+			if iter.Key().Kind() == reflect.String {
+				key = iter.Key().String()
+			}
+			findAuthorizationErrors(iter.Value(), key, found)
+		}
+	case reflect.Int16:
+		if v.Type() == kerrorType {
+			if kerr := KError(v.Int()); isAuthorizationError(kerr) {
+				found(kerr, resource)
+			}
+		}
+	}
+}
+
+func aclResourceNames(resourceACLs []*ResourceAcls) []string {
+	names := make([]string, 0, len(resourceACLs))
+	for _, resourceACL := range resourceACLs {
+		names = append(names, resourceACL.ResourceName)
+	}
+	return names
+}
+
+func scramUserNames(upsertions []AlterUserScramCredentialsUpsert, deletions []AlterUserScramCredentialsDelete) []string {
+	names := make([]string, 0, len(upsertions)+len(deletions))
+	for _, u := range upsertions {
+		names = append(names, u.Name)
+	}
+	for _, d := range deletions {
+		names = append(names, d.Name)
+	}
+	return names
+}
+
+// quotaEntityName returns the components of a quota entity as a
+// comma-separated list of type=name pairs, such as "user=alice,client-id=app".
+func quotaEntityName(entity []QuotaEntityComponent) string {
+	components := make([]string, 0, len(entity))
+	for _, component := range entity {
+		components = append(components, fmt.Sprintf("%s=%s", component.EntityType, component.Name))
+	}
+	return strings.Join(components, ",")
+}
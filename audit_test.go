@@ -0,0 +1,148 @@
+package sarama
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+type auditRecorder struct {
+	lock   sync.Mutex
+	events []AuditEvent
+}
+
+func (r *auditRecorder) hook(event *AuditEvent) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.events = append(r.events, *event)
+}
+
+func (r *auditRecorder) ofType(eventType AuditEventType) []AuditEvent {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	var events []AuditEvent
+	for _, event := range r.events {
+		if event.Type == eventType {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+func TestAuditHookAdminOperations(t *testing.T) {
+	seedBroker := NewMockBroker(t, 1)
+	defer seedBroker.Close()
+
+	seedBroker.SetHandlerByMap(map[string]MockResponse{
+		"MetadataRequest": NewMockMetadataResponse(t).
+			SetController(seedBroker.BrokerID()).
+			SetBroker(seedBroker.Addr(), seedBroker.BrokerID()),
+		"CreateTopicsRequest": NewMockCreateTopicsResponse(t),
+	})
+
+	var recorder auditRecorder
+	config := NewTestConfig()
+	config.Version = V0_10_2_0
+	config.ClientID = "audited"
+	config.AuditHook = recorder.hook
+	admin, err := NewClusterAdmin([]string{seedBroker.Addr()}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer safeClose(t, admin)
+
+	if err := admin.CreateTopic("my_topic", &TopicDetail{NumPartitions: 1, ReplicationFactor: 1}, true); err != nil {
+		t.Fatal(err)
+	}
+	// the mock denies the topics with a reserved prefix
+	if err := admin.CreateTopic("_reserved", &TopicDetail{NumPartitions: 1, ReplicationFactor: 1}, false); err == nil {
+		t.Fatal("expected the creation of _reserved to fail")
+	}
+
+	operations := recorder.ofType(AuditAdminOperation)
+	if len(operations) != 2 {
+		t.Fatalf("expected 2 admin operations, got %+v", operations)
+	}
+	if op := operations[0]; op.Operation != "CreateTopic" || op.Resource != "my_topic" || !op.ValidateOnly || op.Err != nil ||
+		op.ClientID != "audited" || op.BrokerID != -1 || op.Time.IsZero() {
+		t.Errorf("unexpected admin operation %+v", op)
+	}
+	if op := operations[1]; op.Resource != "_reserved" || op.ValidateOnly || !errors.Is(op.Err, ErrTopicAuthorizationFailed) {
+		t.Errorf("unexpected admin operation %+v", op)
+	}
+
+	denials := recorder.ofType(AuditAuthorizationDenied)
+	if len(denials) != 1 {
+		t.Fatalf("expected 1 authorization denial, got %+v", denials)
+	}
+	if denial := denials[0]; denial.Operation != "CreateTopics" || denial.Resource != "_reserved" ||
+		!errors.Is(denial.Err, ErrTopicAuthorizationFailed) || denial.BrokerID != seedBroker.BrokerID() || denial.Broker != seedBroker.Addr() {
+		t.Errorf("unexpected authorization denial %+v", denial)
+	}
+}
+
+func TestAuditHookAuthentication(t *testing.T) {
+	for name, authErr := range map[string]KError{"succeeded": ErrNoError, "failed": ErrSASLAuthenticationFailed} {
+		authErr := authErr
+		t.Run(name, func(t *testing.T) {
+			mockBroker := NewMockBroker(t, 0)
+			defer mockBroker.Close()
+
+			mockSASLAuthResponse := NewMockSaslAuthenticateResponse(t)
+			if !errors.Is(authErr, ErrNoError) {
+				mockSASLAuthResponse = mockSASLAuthResponse.SetError(authErr)
+			}
+			mockBroker.SetHandlerByMap(map[string]MockResponse{
+				"SaslAuthenticateRequest": mockSASLAuthResponse,
+				"SaslHandshakeRequest": NewMockSaslHandshakeResponse(t).
+					SetEnabledMechanisms([]string{SASLTypePlaintext}),
+			})
+
+			var recorder auditRecorder
+			conf := NewTestConfig()
+			conf.Net.SASL.Enable = true
+			conf.Net.SASL.Mechanism = SASLTypePlaintext
+			conf.Net.SASL.User = "alice"
+			conf.Net.SASL.Password = "password"
+			conf.Version = V1_0_0_0
+			conf.AuditHook = recorder.hook
+
+			broker := NewBroker(mockBroker.Addr())
+			if err := broker.Open(conf); err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { _ = broker.Close() })
+			_, _ = broker.Connected()
+
+			events := recorder.ofType(AuditAuthentication)
+			if len(events) != 1 {
+				t.Fatalf("expected 1 authentication, got %+v", events)
+			}
+			event := events[0]
+			if event.User != "alice" || event.Mechanism != SASLTypePlaintext || event.Operation != SASLTypePlaintext ||
+				event.Broker != mockBroker.Addr() {
+				t.Errorf("unexpected authentication %+v", event)
+			}
+			if errors.Is(authErr, ErrNoError) != (event.Err == nil) || (event.Err != nil && !errors.Is(event.Err, authErr)) {
+				t.Errorf("expected the authentication error %v, got %v", authErr, event.Err)
+			}
+		})
+	}
+}
+
+func TestFindAuthorizationErrors(t *testing.T) {
+	res := &MetadataResponse{
+		Topics: []*TopicMetadata{
+			{Name: "allowed", Err: ErrNoError},
+			{Name: "denied", Err: ErrTopicAuthorizationFailed},
+		},
+	}
+	var found []string
+	findAuthorizationErrors(reflect.ValueOf(res), "", func(kerr KError, resource string) {
+		found = append(found, resource+": "+kerr.Error())
+	})
+	if len(found) != 1 || found[0] != "denied: "+ErrTopicAuthorizationFailed.Error() {
+		t.Errorf("unexpected authorization errors %v", found)
+	}
+}
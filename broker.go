@@ -1,17 +1,19 @@
 package sarama
 
 import (
+	"crypto/sha256"
 	"crypto/tls"
+	"encoding/base64"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
 	"math/rand"
 	"net"
+	"reflect"
 	"sort"
 	"strconv"
 	"strings"
-	"sync"
 	"sync/atomic"
 	"time"
 
@@ -28,10 +30,15 @@ type Broker struct {
 	correlationID int32
 	conn          net.Conn
 	connErr       error
-	lock          sync.Mutex
+	lock          priorityLock
 	opened        int32
+	inFlight      int32 // accessed atomically
+	queued        int32 // accessed atomically
 	responses     chan *responsePromise
 	done          chan bool
+	connectedAt   time.Time
+	lastActive    int64        // unix nanoseconds, accessed atomically
+	apiVersions   atomic.Value // map[int16]ApiVersionsResponseKey, see recordApiVersions
 
 	metricRegistry             metrics.Registry
 	incomingByteRate           metrics.Meter
@@ -132,6 +139,10 @@ type responsePromise struct {
 	handler       func([]byte, error)
 	packets       chan []byte
 	errors        chan error
+	// pooled is set for the fetch requests of the consumers with
+	// Config.Consumer.PooledBuffers, whose response is read into buffer
+	pooled bool
+	buffer *fetchBuffer
 }
 
 func (p *responsePromise) handle(packets []byte, err error) {
@@ -199,88 +210,110 @@ func (b *Broker) Open(conf *Config) error {
 				}
 			}
 		}()
-		dialer := conf.getDialer()
-		b.conn, b.connErr = dialer.Dial("tcp", b.addr)
-		if b.connErr != nil {
-			Logger.Printf("Failed to connect to broker %s: %s\n", b.addr, b.connErr)
-			b.conn = nil
-			atomic.StoreInt32(&b.opened, 0)
-			return
-		}
-		if conf.Net.TLS.Enable {
-			b.conn = tls.Client(b.conn, validServerNameTLS(b.addr, conf.Net.TLS.Config))
-		}
-
-		b.conn = newBufConn(b.conn)
-		b.conf = conf
-
-		// Create or reuse the global metrics shared between brokers
-		b.incomingByteRate = metrics.GetOrRegisterMeter("incoming-byte-rate", b.metricRegistry)
-		b.requestRate = metrics.GetOrRegisterMeter("request-rate", b.metricRegistry)
-		b.fetchRate = metrics.GetOrRegisterMeter("consumer-fetch-rate", b.metricRegistry)
-		b.requestSize = getOrRegisterHistogram("request-size", b.metricRegistry)
-		b.requestLatency = getOrRegisterHistogram("request-latency-in-ms", b.metricRegistry)
-		b.outgoingByteRate = metrics.GetOrRegisterMeter("outgoing-byte-rate", b.metricRegistry)
-		b.responseRate = metrics.GetOrRegisterMeter("response-rate", b.metricRegistry)
-		b.responseSize = getOrRegisterHistogram("response-size", b.metricRegistry)
-		b.requestsInFlight = metrics.GetOrRegisterCounter("requests-in-flight", b.metricRegistry)
-		b.protocolRequestsRate = map[int16]metrics.Meter{}
-		// Do not gather metrics for seeded broker (only used during bootstrap) because they share
-		// the same id (-1) and are already exposed through the global metrics above
-		if b.id >= 0 && !metrics.UseNilMetrics {
-			b.registerMetrics()
-		}
-
-		if conf.Net.SASL.Mechanism == SASLTypeOAuth && conf.Net.SASL.Version == SASLHandshakeV0 {
-			conf.Net.SASL.Version = SASLHandshakeV1
-		}
+		b.connect(conf)
+	})
 
-		useSaslV0 := conf.Net.SASL.Version == SASLHandshakeV0 || conf.Net.SASL.Mechanism == SASLTypeGSSAPI
-		if conf.Net.SASL.Enable && useSaslV0 {
-			b.connErr = b.authenticateViaSASLv0()
+	return nil
+}
 
-			if b.connErr != nil {
-				err = b.conn.Close()
-				if err == nil {
-					DebugLogger.Printf("Closed connection to broker %s\n", b.addr)
-				} else {
-					Logger.Printf("Error while closing connection to broker %s: %s\n", b.addr, err)
-				}
+// connect dials the broker and authenticates, setting b.connErr on failure.
+// b.lock must be held by caller.
+func (b *Broker) connect(conf *Config) {
+	dialer := conf.getDialer()
+	b.conn, b.connErr = dialer.Dial("tcp", b.addr)
+	if b.connErr != nil {
+		Logger.Printf("Failed to connect to broker %s: %s\n", b.addr, b.connErr)
+		b.conn = nil
+		atomic.StoreInt32(&b.opened, 0)
+		return
+	}
+	if conf.Net.TLS.Enable {
+		cfg := b.tlsConfig(conf)
+		tlsConn := tls.Client(b.conn, cfg)
+		b.conn = tlsConn
+		if conf.Net.TLS.Debug {
+			if b.connErr = b.handshakeTLS(tlsConn, cfg, conf.Net.DialTimeout); b.connErr != nil {
+				_ = b.conn.Close()
 				b.conn = nil
 				atomic.StoreInt32(&b.opened, 0)
 				return
 			}
 		}
+	}
 
-		b.done = make(chan bool)
-		b.responses = make(chan *responsePromise, b.conf.Net.MaxOpenRequests-1)
-
-		go withRecover(b.responseReceiver)
-		if conf.Net.SASL.Enable && !useSaslV0 {
-			b.connErr = b.authenticateViaSASLv1()
-			if b.connErr != nil {
-				close(b.responses)
-				err = b.conn.Close()
-				if err == nil {
-					DebugLogger.Printf("Closed connection to broker %s\n", b.addr)
-				} else {
-					Logger.Printf("Error while closing connection to broker %s: %s\n", b.addr, err)
-				}
-				b.conn = nil
-				atomic.StoreInt32(&b.opened, 0)
-				return
+	b.conn = newBufConn(b.conn)
+	b.conf = conf
+	b.connectedAt = time.Now()
+	atomic.StoreInt64(&b.lastActive, b.connectedAt.UnixNano())
+
+	// Create or reuse the global metrics shared between brokers
+	b.incomingByteRate = metrics.GetOrRegisterMeter("incoming-byte-rate", b.metricRegistry)
+	b.requestRate = metrics.GetOrRegisterMeter("request-rate", b.metricRegistry)
+	b.fetchRate = metrics.GetOrRegisterMeter("consumer-fetch-rate", b.metricRegistry)
+	b.requestSize = getOrRegisterHistogram("request-size", b.metricRegistry)
+	b.requestLatency = getOrRegisterHistogram("request-latency-in-ms", b.metricRegistry)
+	b.outgoingByteRate = metrics.GetOrRegisterMeter("outgoing-byte-rate", b.metricRegistry)
+	b.responseRate = metrics.GetOrRegisterMeter("response-rate", b.metricRegistry)
+	b.responseSize = getOrRegisterHistogram("response-size", b.metricRegistry)
+	b.requestsInFlight = metrics.GetOrRegisterCounter("requests-in-flight", b.metricRegistry)
+	b.protocolRequestsRate = map[int16]metrics.Meter{}
+	// Do not gather metrics for seeded broker (only used during bootstrap) because they share
+	// the same id (-1) and are already exposed through the global metrics above
+	if b.id >= 0 && !metricsDisabled(b.metricRegistry) {
+		b.registerMetrics()
+	}
+
+	if conf.Net.SASL.Mechanism == SASLTypeOAuth && conf.Net.SASL.Version == SASLHandshakeV0 {
+		conf.Net.SASL.Version = SASLHandshakeV1
+	}
+
+	useSaslV0 := conf.Net.SASL.Version == SASLHandshakeV0 || conf.Net.SASL.Mechanism == SASLTypeGSSAPI
+	if conf.Net.SASL.Enable && useSaslV0 {
+		b.connErr = b.authenticateViaSASLv0()
+		b.auditAuthentication(b.connErr)
+
+		if b.connErr != nil {
+			err := b.conn.Close()
+			if err == nil {
+				DebugLogger.Printf("Closed connection to broker %s\n", b.addr)
+			} else {
+				Logger.Printf("Error while closing connection to broker %s: %s\n", b.addr, err)
 			}
+			b.conn = nil
+			atomic.StoreInt32(&b.opened, 0)
+			return
 		}
-		if b.id >= 0 {
-			DebugLogger.Printf("Connected to broker at %s (registered as #%d)\n", b.addr, b.id)
-		} else {
-			DebugLogger.Printf("Connected to broker at %s (unregistered)\n", b.addr)
-		}
-	})
+	}
 
-	return nil
+	b.done = make(chan bool)
+	b.responses = make(chan *responsePromise, b.conf.Net.MaxOpenRequests-1)
+
+	go withRecover(b.responseReceiver)
+	if conf.Net.SASL.Enable && !useSaslV0 {
+		b.connErr = b.authenticateViaSASLv1()
+		b.auditAuthentication(b.connErr)
+		if b.connErr != nil {
+			close(b.responses)
+			err := b.conn.Close()
+			if err == nil {
+				DebugLogger.Printf("Closed connection to broker %s\n", b.addr)
+			} else {
+				Logger.Printf("Error while closing connection to broker %s: %s\n", b.addr, err)
+			}
+			b.conn = nil
+			atomic.StoreInt32(&b.opened, 0)
+			return
+		}
+	}
+	if b.id >= 0 {
+		DebugLogger.Printf("Connected to broker at %s (registered as #%d)\n", b.addr, b.id)
+	} else {
+		DebugLogger.Printf("Connected to broker at %s (unregistered)\n", b.addr)
+	}
 }
 
+// ResponseSize returns the number of responses of the requests sent
+// asynchronously by AsyncProduce waiting to be received from the broker.
 func (b *Broker) ResponseSize() int {
 	b.lock.Lock()
 	defer b.lock.Unlock()
@@ -288,6 +321,44 @@ func (b *Broker) ResponseSize() int {
 	return len(b.responses)
 }
 
+// InFlight returns the number of requests sent to the broker which are
+// waiting for their response, whether they were sent synchronously or
+// asynchronously. Unlike ResponseSize, it never blocks on the requests in
+// progress, so it can be used to spread the requests across brokers.
+func (b *Broker) InFlight() int {
+	return int(atomic.LoadInt32(&b.inFlight))
+}
+
+// Queued returns the number of requests waiting to be sent to the broker
+// behind the requests in progress. Like InFlight, it never blocks.
+func (b *Broker) Queued() int {
+	return int(atomic.LoadInt32(&b.queued))
+}
+
+// BrokerStats is a snapshot of the load of a broker, as returned by
+// Broker.Stats and BrokerStatsProvider.BrokerStats.
+type BrokerStats struct {
+	ID   int32
+	Addr string
+	// Opened reports whether the broker was opened, and not closed since.
+	Opened bool
+	// InFlight and Queued are the numbers of requests returned by
+	// Broker.InFlight and Broker.Queued.
+	InFlight, Queued int
+}
+
+// Stats returns the load of the broker. It never blocks, so it can be used to
+// route the requests of an application across brokers.
+func (b *Broker) Stats() BrokerStats {
+	return BrokerStats{
+		ID:       b.ID(),
+		Addr:     b.addr,
+		Opened:   atomic.LoadInt32(&b.opened) == 1,
+		InFlight: b.InFlight(),
+		Queued:   b.Queued(),
+	}
+}
+
 // Connected returns true if the broker is connected and false otherwise. If the broker is not
 // connected but it had tried to connect, the error from that connection attempt is also returned.
 func (b *Broker) Connected() (bool, error) {
@@ -324,6 +395,24 @@ func (b *Broker) Close() error {
 		return ErrNotConnected
 	}
 
+	err := b.disconnect()
+
+	b.metricRegistry.UnregisterAll()
+
+	if err == nil {
+		DebugLogger.Printf("Closed connection to broker %s\n", b.addr)
+	} else {
+		Logger.Printf("Error while closing connection to broker %s: %s\n", b.addr, err)
+	}
+
+	atomic.StoreInt32(&b.opened, 0)
+
+	return err
+}
+
+// disconnect closes the connection once the responses in flight are received.
+// b.lock must be held by caller.
+func (b *Broker) disconnect() error {
 	close(b.responses)
 	<-b.done
 
@@ -334,17 +423,65 @@ func (b *Broker) Close() error {
 	b.done = nil
 	b.responses = nil
 
-	b.metricRegistry.UnregisterAll()
+	return err
+}
 
-	if err == nil {
-		DebugLogger.Printf("Closed connection to broker %s\n", b.addr)
-	} else {
-		Logger.Printf("Error while closing connection to broker %s: %s\n", b.addr, err)
+// connectionExpired returns whether the connection was idle for longer than
+// Net.MaxIdleTime, or is older than Net.MaxConnectionAge, with no request in
+// flight. b.lock must be held by caller.
+func (b *Broker) connectionExpired() bool {
+	if atomic.LoadInt32(&b.inFlight) > 0 {
+		return false
+	}
+	now := time.Now()
+	if maxIdle := b.conf.Net.MaxIdleTime; maxIdle > 0 && now.Sub(time.Unix(0, atomic.LoadInt64(&b.lastActive))) > maxIdle {
+		return true
+	}
+	if maxAge := b.conf.Net.MaxConnectionAge; maxAge > 0 && now.Sub(b.connectedAt) > maxAge {
+		return true
 	}
+	return false
+}
 
-	atomic.StoreInt32(&b.opened, 0)
+// reconnect replaces the connection to the broker by a new one, which is
+// authenticated again. b.lock must be held by caller.
+func (b *Broker) reconnect() error {
+	DebugLogger.Printf("Reconnecting to broker %s as the connection expired\n", b.addr)
+	if err := b.disconnect(); err != nil {
+		Logger.Printf("Error while closing connection to broker %s: %s\n", b.addr, err)
+	}
 
-	return err
+	b.connect(b.conf)
+	if b.conn == nil {
+		// the broker is closed until opened again, clean up as Close does
+		if b.done != nil {
+			<-b.done
+		}
+		b.done = nil
+		b.responses = nil
+		b.metricRegistry.UnregisterAll()
+		return b.connErr
+	}
+
+	// identify the client again (KIP-511)
+	if b.conf.Version.IsAtLeast(V2_4_0_0) && b.conf.ApiVersionsRequest {
+		req := &ApiVersionsRequest{
+			Version:               3,
+			ClientSoftwareName:    defaultClientSoftwareName,
+			ClientSoftwareVersion: version(),
+		}
+		res := &ApiVersionsResponse{Version: req.Version}
+		promise, err := b.send(req, true, res.headerVersion())
+		if err == nil {
+			err = handleResponsePromise(req, res, promise, b.metricRegistry)
+		}
+		if err != nil {
+			Logger.Printf("Error while sending ApiVersionsRequest to broker %s: %s\n", b.addr, err)
+		} else {
+			b.recordApiVersions(res)
+		}
+	}
+	return nil
 }
 
 // ID returns the broker ID retrieved from Kafka's metadata, or -1 if that is not known.
@@ -460,12 +597,14 @@ func (b *Broker) AsyncProduce(request *ProduceRequest, cb ProduceCallback) error
 
 				// Well-formed response
 				b.handleThrottledResponse(res)
+				b.auditResponse(request, res)
+				injectFault(b.conf, b.id, res)
 				cb(res, nil)
 			},
 		}
 	}
 
-	b.lock.Lock()
+	b.lockForRequest(request)
 	defer b.lock.Unlock()
 	return b.sendWithPromise(request, promise)
 }
@@ -487,6 +626,9 @@ func (b *Broker) Produce(request *ProduceRequest) (*ProduceResponse, error) {
 	if err != nil {
 		return nil, err
 	}
+	if response != nil {
+		injectFault(b.conf, b.id, response)
+	}
 
 	return response, nil
 }
@@ -502,12 +644,13 @@ func (b *Broker) Fetch(request *FetchRequest) (*FetchResponse, error) {
 		}
 	}()
 
-	response := new(FetchResponse)
+	response := &FetchResponse{keepCorruptBatches: request.keepCorruptBatches}
 
 	err := b.sendAndReceive(request, response)
 	if err != nil {
 		return nil, err
 	}
+	injectFault(b.conf, b.id, response)
 
 	return response, nil
 }
@@ -520,6 +663,7 @@ func (b *Broker) CommitOffset(request *OffsetCommitRequest) (*OffsetCommitRespon
 	if err != nil {
 		return nil, err
 	}
+	injectFault(b.conf, b.id, response)
 
 	return response, nil
 }
@@ -610,6 +754,32 @@ func (b *Broker) DescribeGroups(request *DescribeGroupsRequest) (*DescribeGroups
 	return response, nil
 }
 
+// ConsumerGroupDescribe returns the description of the consumer groups using
+// the consumer group protocol of KIP-848.
+func (b *Broker) ConsumerGroupDescribe(request *ConsumerGroupDescribeRequest) (*ConsumerGroupDescribeResponse, error) {
+	response := new(ConsumerGroupDescribeResponse)
+
+	err := b.sendAndReceive(request, response)
+	if err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// DescribeTopicPartitions returns a page of the description of the partitions
+// of the topics.
+func (b *Broker) DescribeTopicPartitions(request *DescribeTopicPartitionsRequest) (*DescribeTopicPartitionsResponse, error) {
+	response := new(DescribeTopicPartitionsResponse)
+
+	err := b.sendAndReceive(request, response)
+	if err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
 // ApiVersions return api version response or error
 func (b *Broker) ApiVersions(request *ApiVersionsRequest) (*ApiVersionsResponse, error) {
 	response := new(ApiVersionsResponse)
@@ -618,10 +788,63 @@ func (b *Broker) ApiVersions(request *ApiVersionsRequest) (*ApiVersionsResponse,
 	if err != nil {
 		return nil, err
 	}
+	b.recordApiVersions(response)
 
 	return response, nil
 }
 
+// recordApiVersions records the versions of the requests supported by the
+// broker as per its ApiVersions response, checked by checkVersion.
+func (b *Broker) recordApiVersions(response *ApiVersionsResponse) {
+	if !errors.Is(KError(response.ErrorCode), ErrNoError) {
+		return
+	}
+	versions := make(map[int16]ApiVersionsResponseKey, len(response.ApiKeys))
+	for _, key := range response.ApiKeys {
+		versions[key.ApiKey] = key
+	}
+	b.apiVersions.Store(versions)
+}
+
+// apiFeatures are the configurations enabling the requests of an API key, named
+// by the UnsupportedVersionErrors.
+var apiFeatures = map[int16]string{
+	22:             "Producer.Idempotent or Producer.Transaction.ID", // InitProducerID
+	24:             "Producer.Transaction.ID",                        // AddPartitionsToTxn
+	25:             "Producer.Transaction.ID",                        // AddOffsetsToTxn
+	26:             "Producer.Transaction.ID",                        // EndTxn
+	28:             "Producer.Transaction.ID",                        // TxnOffsetCommit
+	APIKeySASLAuth: "Net.SASL.Version",
+}
+
+// checkVersion returns an UnsupportedVersionError if the version of rb is not
+// supported by Config.Version, or by the broker as per its ApiVersions
+// response if it was received.
+func (b *Broker) checkVersion(rb protocolBody) error {
+	required := rb.requiredVersion()
+	versions, _ := b.apiVersions.Load().(map[int16]ApiVersionsResponseKey)
+	supported, known := versions[rb.key()]
+	configured := b.conf.Version.IsAtLeast(required)
+	if configured && (!known || (rb.version() >= supported.MinVersion && rb.version() <= supported.MaxVersion)) {
+		return nil
+	}
+
+	err := UnsupportedVersionError{
+		API:                  strings.TrimSuffix(reflect.TypeOf(rb).Elem().Name(), "Request"),
+		Feature:              apiFeatures[rb.key()],
+		Version:              rb.version(),
+		RequiredKafkaVersion: required,
+		ConfigVersion:        b.conf.Version,
+		Broker:               b.addr,
+		BrokerMinVersion:     -1,
+		BrokerMaxVersion:     -1,
+	}
+	if configured {
+		err.BrokerMinVersion, err.BrokerMaxVersion = supported.MinVersion, supported.MaxVersion
+	}
+	return err
+}
+
 // CreateTopics send a create topic request and returns create topic response
 func (b *Broker) CreateTopics(request *CreateTopicsRequest) (*CreateTopicsResponse, error) {
 	response := new(CreateTopicsResponse)
@@ -954,6 +1177,9 @@ func (b *Broker) send(rb protocolBody, promiseResponse bool, responseHeaderVersi
 		// Packets or error will be sent to the following channels
 		// once the response is received
 		promise = makeResponsePromise(responseHeaderVersion)
+		if fetch, ok := rb.(*FetchRequest); ok {
+			promise.pooled = fetch.pooled
+		}
 	}
 
 	if err := b.sendWithPromise(rb, promise); err != nil {
@@ -972,6 +1198,14 @@ func makeResponsePromise(responseHeaderVersion int16) *responsePromise {
 	return promise
 }
 
+// lockForRequest locks the broker to send req, counting it as queued while it
+// waits for the requests in progress.
+func (b *Broker) lockForRequest(req protocolBody) {
+	atomic.AddInt32(&b.queued, 1)
+	b.lock.lockWithPriority(priorityOf(req))
+	atomic.AddInt32(&b.queued, -1)
+}
+
 // b.lock must be held by caller
 func (b *Broker) sendWithPromise(rb protocolBody, promise *responsePromise) error {
 	if b.conn == nil {
@@ -981,8 +1215,15 @@ func (b *Broker) sendWithPromise(rb protocolBody, promise *responsePromise) erro
 		return ErrNotConnected
 	}
 
+	if b.connectionExpired() {
+		if err := b.reconnect(); err != nil {
+			return err
+		}
+	}
+
 	if b.clientSessionReauthenticationTimeMs > 0 && currentUnixMilli() > b.clientSessionReauthenticationTimeMs {
 		err := b.authenticateViaSASLv1()
+		b.auditAuthentication(err)
 		if err != nil {
 			return err
 		}
@@ -993,8 +1234,8 @@ func (b *Broker) sendWithPromise(rb protocolBody, promise *responsePromise) erro
 
 // b.lock must be held by caller
 func (b *Broker) sendInternal(rb protocolBody, promise *responsePromise) error {
-	if !b.conf.Version.IsAtLeast(rb.requiredVersion()) {
-		return ErrUnsupportedVersion
+	if err := b.checkVersion(rb); err != nil {
+		return err
 	}
 
 	req := &request{correlationID: b.correlationID, clientID: b.conf.ClientID, body: rb}
@@ -1010,6 +1251,7 @@ func (b *Broker) sendInternal(rb protocolBody, promise *responsePromise) error {
 	// Will be decremented in responseReceiver (except error or request with NoResponse)
 	b.addRequestInFlightMetrics(1)
 	bytes, err := b.write(buf)
+	atomic.StoreInt64(&b.lastActive, time.Now().UnixNano())
 	b.updateOutgoingCommunicationMetrics(bytes)
 	b.updateProtocolMetrics(rb)
 	if err != nil {
@@ -1032,7 +1274,7 @@ func (b *Broker) sendInternal(rb protocolBody, promise *responsePromise) error {
 }
 
 func (b *Broker) sendAndReceive(req protocolBody, res protocolBody) error {
-	b.lock.Lock()
+	b.lockForRequest(req)
 	defer b.lock.Unlock()
 	responseHeaderVersion := int16(-1)
 	if res != nil {
@@ -1050,10 +1292,15 @@ func (b *Broker) sendAndReceive(req protocolBody, res protocolBody) error {
 
 	err = handleResponsePromise(req, res, promise, b.metricRegistry)
 	if err != nil {
+		promise.buffer.release()
 		return err
 	}
+	if fetch, ok := res.(*FetchResponse); ok {
+		fetch.buffer = promise.buffer
+	}
 	if res != nil {
 		b.handleThrottledResponse(res)
+		b.auditResponse(req, res)
 	}
 	return nil
 }
@@ -1195,8 +1442,15 @@ func (b *Broker) responseReceiver() {
 			continue
 		}
 
-		buf := make([]byte, decodedHeader.length-int32(headerLength)+4)
+		var buf []byte
+		if response.pooled {
+			response.buffer = getFetchBuffer(int(decodedHeader.length - int32(headerLength) + 4))
+			buf = response.buffer.buf
+		} else {
+			buf = make([]byte, decodedHeader.length-int32(headerLength)+4)
+		}
 		bytesReadBody, err := b.readFull(buf)
+		atomic.StoreInt64(&b.lastActive, time.Now().UnixNano())
 		b.updateIncomingCommunicationMetrics(bytesReadHeader+bytesReadBody, requestLatency)
 		if err != nil {
 			dead = err
@@ -1632,6 +1886,7 @@ func (b *Broker) updateRequestLatencyAndInFlightMetrics(requestLatency time.Dura
 }
 
 func (b *Broker) addRequestInFlightMetrics(i int64) {
+	atomic.AddInt32(&b.inFlight, int32(i))
 	b.requestsInFlight.Inc(i)
 	if b.brokerRequestsInFlight != nil {
 		b.brokerRequestsInFlight.Inc(i)
@@ -1747,6 +2002,80 @@ func (b *Broker) registerCounter(name string) metrics.Counter {
 	return metrics.GetOrRegisterCounter(nameForBroker, b.metricRegistry)
 }
 
+// tlsConfig returns the TLS configuration of the connections to the broker,
+// with the server name and the pinned keys of conf applied.
+func (b *Broker) tlsConfig(conf *Config) *tls.Config {
+	cfg := validServerNameTLS(b.addr, conf.Net.TLS.Config)
+	if conf.Net.TLS.ServerName != nil {
+		if sn := conf.Net.TLS.ServerName(b); sn != "" {
+			cfg = cfg.Clone()
+			cfg.ServerName = sn
+		}
+	}
+	if conf.Net.TLS.KeyLogWriter != nil {
+		cfg = cfg.Clone()
+		cfg.KeyLogWriter = conf.Net.TLS.KeyLogWriter
+	}
+	if len(conf.Net.TLS.PinnedKeys) == 0 {
+		return cfg
+	}
+
+	pins := make(map[string]none, len(conf.Net.TLS.PinnedKeys))
+	for _, pin := range conf.Net.TLS.PinnedKeys {
+		pins[pin] = none{}
+	}
+	cfg = cfg.Clone()
+	verify := cfg.VerifyConnection
+	cfg.VerifyConnection = func(state tls.ConnectionState) error {
+		if verify != nil {
+			if err := verify(state); err != nil {
+				return err
+			}
+		}
+		certs := state.PeerCertificates
+		if len(certs) > 0 && !cfg.InsecureSkipVerify {
+			certs = nil
+			for _, chain := range state.VerifiedChains {
+				certs = append(certs, chain...)
+			}
+		} else if len(certs) > 1 {
+			// only the certificate of the broker is known to be its own
+			certs = certs[:1]
+		}
+		for _, cert := range certs {
+			sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+			if _, ok := pins[base64.StdEncoding.EncodeToString(sum[:])]; ok {
+				return nil
+			}
+		}
+		return fmt.Errorf("kafka: the certificates of broker %d (%s) do not match any of Net.TLS.PinnedKeys", b.id, b.addr)
+	}
+	return cfg
+}
+
+// ServerNameTemplate returns a Net.TLS.ServerName function replacing {id},
+// {host}, {port} and {rack} in template with the ID, the host and port of the
+// address, and the rack of each broker, such as "broker-{id}.kafka.internal".
+// It returns an empty string, leaving the server name unchanged, for the seed
+// brokers when template refers to their unknown ID.
+func ServerNameTemplate(template string) func(broker *Broker) string {
+	return func(broker *Broker) string {
+		if broker.ID() < 0 && strings.Contains(template, "{id}") {
+			return ""
+		}
+		host, port, err := net.SplitHostPort(broker.Addr())
+		if err != nil {
+			host = broker.Addr()
+		}
+		return strings.NewReplacer(
+			"{id}", strconv.Itoa(int(broker.ID())),
+			"{host}", host,
+			"{port}", port,
+			"{rack}", broker.Rack(),
+		).Replace(template)
+	}
+}
+
 func validServerNameTLS(addr string, cfg *tls.Config) *tls.Config {
 	if cfg == nil {
 		cfg = &tls.Config{
@@ -0,0 +1,48 @@
+package sarama
+
+import (
+	"net"
+	"strconv"
+	"strings"
+)
+
+// BrokerAddressSelector returns the address the client connects to for the
+// broker of the given ID, advertised at addr by the metadata and coordinator
+// responses, as set by Net.AddressSelector.
+//
+// The brokers only advertise the listener the client connected to, so a
+// client reaching the bootstrap brokers through a listener, as an internal
+// one, learns the addresses of that listener only. The selector picks the
+// address of another listener of the broker instead, as an external one,
+// without a different bootstrap address for each network path.
+type BrokerAddressSelector func(id int32, addr string) string
+
+// NewTemplateAddressSelector returns a BrokerAddressSelector building the
+// addresses of the brokers from template, in which {id} is replaced with the
+// ID of the broker, and {host} and {port} with the host and port it
+// advertised. For example, "{host}.external.example.com:9094" or
+// "kafka-{id}.example.com:{port}".
+func NewTemplateAddressSelector(template string) BrokerAddressSelector {
+	return func(id int32, addr string) string {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			host = addr
+		}
+		return strings.NewReplacer(
+			"{id}", strconv.Itoa(int(id)),
+			"{host}", host,
+			"{port}", port,
+		).Replace(template)
+	}
+}
+
+// selectBrokerAddress sets the address of broker, as advertised by a
+// response, to the one returned by Net.AddressSelector, if any.
+func selectBrokerAddress(conf *Config, broker *Broker) {
+	if conf.Net.AddressSelector == nil || broker == nil || broker.id < 0 {
+		return
+	}
+	if addr := conf.Net.AddressSelector(broker.id, broker.addr); addr != "" {
+		broker.addr = addr
+	}
+}
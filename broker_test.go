@@ -154,6 +154,142 @@ func TestSimpleBrokerCommunication(t *testing.T) {
 	}
 }
 
+func TestBrokerReplacesExpiredConnections(t *testing.T) {
+	for name, configure := range map[string]func(*Config){
+		"MaxIdleTime":      func(conf *Config) { conf.Net.MaxIdleTime = 100 * time.Millisecond },
+		"MaxConnectionAge": func(conf *Config) { conf.Net.MaxConnectionAge = 100 * time.Millisecond },
+	} {
+		configure := configure
+		t.Run(name, func(t *testing.T) {
+			mb := NewMockBroker(t, 0)
+			defer mb.Close()
+			mb.SetHandlerByMap(map[string]MockResponse{
+				"ApiVersionsRequest": NewMockApiVersionsResponse(t),
+				"MetadataRequest":    NewMockMetadataResponse(t),
+			})
+
+			conf := NewTestConfig()
+			conf.Version = V2_4_0_0
+			configure(conf)
+			broker := NewBroker(mb.Addr())
+			if err := broker.Open(conf); err != nil {
+				t.Fatal(err)
+			}
+			defer safeClose(t, broker)
+
+			conn := func() net.Conn {
+				if _, err := broker.GetMetadata(&MetadataRequest{Version: 1}); err != nil {
+					t.Fatal(err)
+				}
+				broker.lock.Lock()
+				defer broker.lock.Unlock()
+				return broker.conn
+			}
+
+			first := conn()
+			if conn() != first {
+				t.Error("expected the connection to be reused before it expires")
+			}
+			time.Sleep(150 * time.Millisecond)
+			if conn() == first {
+				t.Error("expected the expired connection to be replaced")
+			}
+
+			identified := 0
+			for _, rr := range mb.History() {
+				if _, ok := rr.Request.(*ApiVersionsRequest); ok {
+					identified++
+				}
+			}
+			if identified != 2 {
+				t.Error("expected the client to identify itself on both connections, got", identified)
+			}
+		})
+	}
+}
+
+func TestBrokerUnsupportedVersion(t *testing.T) {
+	mb := NewMockBroker(t, 0)
+	defer mb.Close()
+	mb.SetHandlerByMap(map[string]MockResponse{
+		"ApiVersionsRequest": NewMockApiVersionsResponse(t).SetApiKeys([]ApiVersionsResponseKey{
+			{ApiKey: 3, MinVersion: 0, MaxVersion: 1},
+			{ApiKey: 18, MinVersion: 0, MaxVersion: 3},
+		}),
+		"MetadataRequest": NewMockMetadataResponse(t),
+	})
+
+	conf := NewTestConfig()
+	conf.Version = V2_4_0_0
+	broker := NewBroker(mb.Addr())
+	if err := broker.Open(conf); err != nil {
+		t.Fatal(err)
+	}
+	defer safeClose(t, broker)
+	if _, err := broker.ApiVersions(&ApiVersionsRequest{Version: 3}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := broker.GetMetadata(&MetadataRequest{Version: 1}); err != nil {
+		t.Fatal(err)
+	}
+	_, err := broker.GetMetadata(&MetadataRequest{Version: 4})
+	var versionErr UnsupportedVersionError
+	if !errors.As(err, &versionErr) || !errors.Is(err, ErrUnsupportedVersion) {
+		t.Fatalf("expected an UnsupportedVersionError, got %v", err)
+	}
+	if versionErr.API != "Metadata" || versionErr.Version != 4 || versionErr.BrokerMaxVersion != 1 || versionErr.Broker != mb.Addr() {
+		t.Errorf("unexpected error %+v", versionErr)
+	}
+
+	_, err = broker.InitProducerID(&InitProducerIDRequest{Version: 4})
+	if !errors.As(err, &versionErr) {
+		t.Fatalf("expected an UnsupportedVersionError, got %v", err)
+	}
+	if versionErr.API != "InitProducerID" || versionErr.BrokerMaxVersion != -1 ||
+		versionErr.RequiredKafkaVersion != V2_7_0_0 || versionErr.Feature == "" {
+		t.Errorf("unexpected error %+v", versionErr)
+	}
+	expected := "kafka: InitProducerID v4 (used by Producer.Idempotent or Producer.Transaction.ID) requires Kafka 2.7.0 or later, but Config.Version is 2.4.0"
+	if err.Error() != expected {
+		t.Errorf("expected %q, got %q", expected, err.Error())
+	}
+}
+
+func TestBrokerFailedReconnect(t *testing.T) {
+	mb := NewMockBroker(t, 0)
+	mb.SetHandlerByMap(map[string]MockResponse{
+		"MetadataRequest": NewMockMetadataResponse(t),
+	})
+
+	conf := NewTestConfig()
+	conf.ApiVersionsRequest = false
+	conf.Net.MaxIdleTime = 50 * time.Millisecond
+	broker := NewBroker(mb.Addr())
+	broker.id = 1
+	if err := broker.Open(conf); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := broker.GetMetadata(&MetadataRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	if conf.MetricRegistry.Get("request-rate-for-broker-1") == nil {
+		t.Fatal("expected the metrics of the broker to be registered")
+	}
+
+	mb.Close()
+	time.Sleep(100 * time.Millisecond)
+	if _, err := broker.GetMetadata(&MetadataRequest{}); err == nil {
+		t.Fatal("expected the reconnection to fail")
+	}
+	if connected, _ := broker.Connected(); connected {
+		t.Error("expected the broker to be closed by the failed reconnection")
+	}
+	if conf.MetricRegistry.Get("request-rate-for-broker-1") != nil {
+		t.Error("expected the failed reconnection to unregister the metrics of the broker")
+	}
+}
+
 func TestBrokerFailedRequest(t *testing.T) {
 	for _, tt := range brokerFailedReqTestTable {
 		tt := tt
@@ -0,0 +1,309 @@
+/*
+Package checkpoint stores the offsets of the partitions consumed without a
+consumer group in a local file, so that single-process consumers resume where
+they stopped when restarted.
+
+A Store records the next offset to consume of each partition, and
+ResumeFromCheckpoint starts consuming a partition from it:
+
+	store, err := checkpoint.Open("/var/lib/app/offsets.json", nil)
+	if err != nil {
+		...
+	}
+	defer store.Close()
+
+	pc, err := checkpoint.ResumeFromCheckpoint(consumer, store, "orders", 0, sarama.OffsetOldest)
+	if err != nil {
+		...
+	}
+	for msg := range pc.Messages() {
+		process(msg)
+		if err := store.MarkMessage(msg); err != nil {
+			...
+		}
+	}
+
+The checkpoints are written to a temporary file renamed over the store, so
+that the store is never left partially written. How often they are written and
+synced to disk is set by Options.Sync: the messages processed since the last
+checkpoint synced to disk are consumed again after a crash, so the messages
+are processed at least once.
+
+NOTE: this package currently does not fall under the API stability
+guarantee of Sarama as it is still considered experimental.
+*/
+package checkpoint
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// SyncPolicy is how often a Store writes its checkpoints and syncs them to
+// disk.
+type SyncPolicy int
+
+const (
+	// SyncAlways writes and syncs the checkpoints to disk on every
+	// checkpoint, so that no checkpoint is lost on a crash of the process or
+	// of the machine. It is the slowest policy.
+	SyncAlways SyncPolicy = iota
+	// SyncInterval writes and syncs the checkpoints to disk every
+	// Options.SyncInterval, and when the store is flushed or closed. The
+	// checkpoints of the last interval are lost on a crash.
+	SyncInterval
+	// SyncNever writes the checkpoints on every checkpoint, but leaves syncing
+	// them to disk to the operating system. No checkpoint is lost on a crash
+	// of the process, but the last ones may be on a crash of the machine.
+	SyncNever
+)
+
+// Options configures a Store.
+type Options struct {
+	// Sync is how often the checkpoints are written and synced to disk
+	// (default SyncAlways).
+	Sync SyncPolicy
+	// SyncInterval is how often the checkpoints are synced to disk with the
+	// SyncInterval policy (default 1s).
+	SyncInterval time.Duration
+}
+
+// file is the content of the file of a Store.
+type file struct {
+	Offsets map[string]map[int32]int64 `json:"offsets"`
+}
+
+// Store is a file holding the next offset to consume of each partition. It is
+// safe for concurrent use.
+type Store struct {
+	path string
+	opts Options
+
+	lock     sync.Mutex
+	offsets  map[string]map[int32]int64
+	dirty    bool // the checkpoints were not written since the last save
+	unsynced bool // the checkpoints written were not synced to disk
+	closed   bool
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// Open opens the store of the file at path, which is created on the first
+// checkpoint if it does not exist. opts may be nil for the default options.
+func Open(path string, opts *Options) (*Store, error) {
+	s := &Store{
+		path:    path,
+		offsets: make(map[string]map[int32]int64),
+		done:    make(chan struct{}),
+	}
+	if opts != nil {
+		s.opts = *opts
+	}
+	switch s.opts.Sync {
+	case SyncAlways, SyncNever:
+	case SyncInterval:
+		if s.opts.SyncInterval == 0 {
+			s.opts.SyncInterval = time.Second
+		}
+		if s.opts.SyncInterval < 0 {
+			return nil, errors.New("checkpoint: SyncInterval must be > 0")
+		}
+	default:
+		return nil, fmt.Errorf("checkpoint: unknown sync policy %d", s.opts.Sync)
+	}
+
+	data, err := os.ReadFile(path)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return nil, err
+	default:
+		var f file
+		if err := json.Unmarshal(data, &f); err != nil {
+			return nil, fmt.Errorf("checkpoint: invalid store %s: %w", path, err)
+		}
+		for topic, partitions := range f.Offsets {
+			s.offsets[topic] = partitions
+		}
+	}
+
+	if s.opts.Sync == SyncInterval {
+		s.wg.Add(1)
+		go s.syncLoop()
+	}
+	return s, nil
+}
+
+// Offset returns the next offset to consume of a partition, and false if the
+// partition has no checkpoint.
+func (s *Store) Offset(topic string, partition int32) (int64, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	offset, ok := s.offsets[topic][partition]
+	return offset, ok
+}
+
+// Save records offset as the next offset to consume of a partition.
+func (s *Store) Save(topic string, partition int32, offset int64) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed {
+		return errors.New("checkpoint: store closed")
+	}
+
+	if s.offsets[topic] == nil {
+		s.offsets[topic] = make(map[int32]int64)
+	}
+	s.offsets[topic][partition] = offset
+	s.dirty = true
+
+	switch s.opts.Sync {
+	case SyncAlways:
+		return s.write(true)
+	case SyncNever:
+		return s.write(false)
+	}
+	return nil
+}
+
+// MarkMessage records that msg was processed, so that the partition of msg is
+// resumed from the message following it.
+func (s *Store) MarkMessage(msg *sarama.ConsumerMessage) error {
+	return s.Save(msg.Topic, msg.Partition, msg.Offset+1)
+}
+
+// Flush writes the checkpoints not written yet and syncs them to disk.
+func (s *Store) Flush() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !s.dirty && !s.unsynced {
+		return nil
+	}
+	return s.write(true)
+}
+
+// Close flushes the store. The checkpoints can not be saved anymore once
+// closed.
+func (s *Store) Close() error {
+	s.lock.Lock()
+	if s.closed {
+		s.lock.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.done)
+	s.lock.Unlock()
+
+	s.wg.Wait()
+	return s.Flush()
+}
+
+func (s *Store) syncLoop() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(s.opts.SyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.Flush(); err != nil {
+				sarama.Logger.Printf("checkpoint: failed to sync %s: %v\n", s.path, err)
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// write replaces the file of the store by the checkpoints, syncing them to
+// disk when durable is true. The lock must be held.
+func (s *Store) write(durable bool) error {
+	data, err := json.Marshal(file{Offsets: s.offsets})
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(s.path)
+	tmp, err := os.CreateTemp(dir, filepath.Base(s.path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if durable {
+		if err := tmp.Sync(); err != nil {
+			_ = tmp.Close()
+			return err
+		}
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return err
+	}
+	if durable {
+		// sync the directory for the rename to be durable
+		if d, err := os.Open(dir); err == nil {
+			_ = d.Sync()
+			_ = d.Close()
+		}
+	}
+
+	s.dirty = false
+	s.unsynced = !durable
+	return nil
+}
+
+// ResumeFromCheckpoint starts consuming a partition from its checkpoint in
+// store, or from initial, which may be sarama.OffsetOldest or
+// sarama.OffsetNewest, if it has none. A checkpoint out of the range of the
+// offsets of the partition, as when the messages following it were deleted by
+// the retention of the topic, is ignored for initial.
+func ResumeFromCheckpoint(consumer sarama.Consumer, store *Store, topic string, partition int32, initial int64) (sarama.PartitionConsumer, error) {
+	offset, ok := store.Offset(topic, partition)
+	if !ok {
+		return consumer.ConsumePartition(topic, partition, initial)
+	}
+
+	pc, err := consumer.ConsumePartition(topic, partition, offset)
+	if errors.Is(err, sarama.ErrOffsetOutOfRange) {
+		sarama.Logger.Printf("checkpoint: offset %d of %s/%d is out of range, consuming from offset %d\n", offset, topic, partition, initial)
+		return consumer.ConsumePartition(topic, partition, initial)
+	}
+	return pc, err
+}
+
+// ResumeTopicFromCheckpoint starts consuming every partition of a topic as
+// ResumeFromCheckpoint. The partitions already started are closed if one of
+// them fails to start.
+func ResumeTopicFromCheckpoint(consumer sarama.Consumer, store *Store, topic string, initial int64) ([]sarama.PartitionConsumer, error) {
+	partitions, err := consumer.Partitions(topic)
+	if err != nil {
+		return nil, err
+	}
+
+	pcs := make([]sarama.PartitionConsumer, 0, len(partitions))
+	for _, partition := range partitions {
+		pc, err := ResumeFromCheckpoint(consumer, store, topic, partition, initial)
+		if err != nil {
+			for _, pc := range pcs {
+				pc.AsyncClose()
+			}
+			return nil, err
+		}
+		pcs = append(pcs, pc)
+	}
+	return pcs, nil
+}
@@ -0,0 +1,151 @@
+package checkpoint
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+)
+
+func checkOffset(t *testing.T, s *Store, topic string, partition int32, expected int64) {
+	t.Helper()
+	offset, ok := s.Offset(topic, partition)
+	if !ok || offset != expected {
+		t.Errorf("expected offset %d for %s/%d, got %d (%v)", expected, topic, partition, offset, ok)
+	}
+}
+
+func TestStoreSurvivesRestarts(t *testing.T) {
+	for name, opts := range map[string]*Options{
+		"always":   nil,
+		"interval": {Sync: SyncInterval, SyncInterval: time.Hour},
+		"never":    {Sync: SyncNever},
+	} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "offsets.json")
+			s, err := Open(path, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := s.Offset("my_topic", 0); ok {
+				t.Error("expected no checkpoint in a new store")
+			}
+			if err := s.Save("my_topic", 0, 10); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.MarkMessage(&sarama.ConsumerMessage{Topic: "my_topic", Partition: 1, Offset: 41}); err != nil {
+				t.Fatal(err)
+			}
+			checkOffset(t, s, "my_topic", 1, 42)
+			if err := s.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Save("my_topic", 0, 11); err == nil {
+				t.Error("expected saving to a closed store to fail")
+			}
+
+			s, err = Open(path, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+			checkOffset(t, s, "my_topic", 0, 10)
+			checkOffset(t, s, "my_topic", 1, 42)
+		})
+	}
+}
+
+func TestStoreSyncPolicies(t *testing.T) {
+	written := func(path string) bool {
+		_, err := os.Stat(path)
+		return err == nil
+	}
+
+	dir := t.TempDir()
+	always, err := Open(filepath.Join(dir, "always.json"), &Options{Sync: SyncAlways})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer always.Close()
+	interval, err := Open(filepath.Join(dir, "interval.json"), &Options{Sync: SyncInterval, SyncInterval: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer interval.Close()
+
+	for _, s := range []*Store{always, interval} {
+		if err := s.Save("my_topic", 0, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !written(always.path) {
+		t.Error("expected SyncAlways to write the checkpoint at once")
+	}
+	if written(interval.path) {
+		t.Error("expected SyncInterval not to write the checkpoint before the interval")
+	}
+	time.Sleep(150 * time.Millisecond)
+	if !written(interval.path) {
+		t.Error("expected SyncInterval to write the checkpoint after the interval")
+	}
+
+	if _, err := Open(filepath.Join(dir, "invalid.json"), &Options{Sync: SyncPolicy(42)}); err == nil {
+		t.Error("expected an unknown sync policy to be rejected")
+	}
+	if err := os.WriteFile(filepath.Join(dir, "corrupt.json"), []byte("{"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(filepath.Join(dir, "corrupt.json"), nil); err == nil {
+		t.Error("expected a corrupt store to be rejected")
+	}
+}
+
+// outOfRangeConsumer fails to consume from the offsets lower than oldest.
+type outOfRangeConsumer struct {
+	*mocks.Consumer
+	oldest int64
+}
+
+func (c *outOfRangeConsumer) ConsumePartition(topic string, partition int32, offset int64) (sarama.PartitionConsumer, error) {
+	if offset >= 0 && offset < c.oldest {
+		return nil, sarama.ErrOffsetOutOfRange
+	}
+	return c.Consumer.ConsumePartition(topic, partition, offset)
+}
+
+func TestResumeFromCheckpoint(t *testing.T) {
+	s, err := Open(filepath.Join(t.TempDir(), "offsets.json"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err := s.Save("my_topic", 0, 42); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Save("my_topic", 1, 5); err != nil {
+		t.Fatal(err)
+	}
+
+	consumer := &outOfRangeConsumer{Consumer: mocks.NewConsumer(t, nil), oldest: 10}
+	consumer.SetTopicMetadata(map[string][]int32{"my_topic": {0, 1, 2}})
+	// partition 0 resumes from its checkpoint
+	consumer.ExpectConsumePartition("my_topic", 0, 42)
+	// the checkpoint of partition 1 was deleted by the retention of the topic
+	consumer.ExpectConsumePartition("my_topic", 1, sarama.OffsetOldest)
+	// partition 2 has no checkpoint
+	consumer.ExpectConsumePartition("my_topic", 2, sarama.OffsetOldest)
+
+	pcs, err := ResumeTopicFromCheckpoint(consumer, s, "my_topic", sarama.OffsetOldest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pcs) != 3 {
+		t.Fatal("expected 3 partition consumers, got", len(pcs))
+	}
+	if err := consumer.Close(); err != nil {
+		t.Error(err)
+	}
+}
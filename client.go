@@ -3,6 +3,7 @@ package sarama
 import (
 	"context"
 	"errors"
+	"fmt"
 	"math"
 	"math/rand"
 	"net"
@@ -12,6 +13,7 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/rcrowley/go-metrics"
 	"golang.org/x/net/proxy"
 )
 
@@ -27,7 +29,10 @@ type Client interface {
 
 	// Controller returns the cluster controller broker. It will return a
 	// locally cached value if it's available. You can call RefreshController
-	// to update the cached value. Requires Kafka 0.10 or higher.
+	// to update the cached value. While the metadata names no known controller
+	// it is refreshed again as set by Config.Metadata.Controller, which also
+	// allows returning a broker forwarding the requests to a controller
+	// without a client listener. Requires Kafka 0.10 or higher.
 	Controller() (*Broker, error)
 
 	// RefreshController retrieves the cluster controller from fresh metadata
@@ -64,11 +69,13 @@ type Client interface {
 
 	// InSyncReplicas returns the set of all in-sync replica IDs for the given
 	// partition. In-sync replicas are replicas which are fully caught up with
-	// the partition leader.
+	// the partition leader. It returns ErrReplicaDetailsDropped if
+	// Metadata.DropReplicaDetails is set.
 	InSyncReplicas(topic string, partitionID int32) ([]int32, error)
 
 	// OfflineReplicas returns the set of all offline replica IDs for the given
-	// partition. Offline replicas are replicas which are offline
+	// partition. Offline replicas are replicas which are offline. It returns
+	// ErrReplicaDetailsDropped if Metadata.DropReplicaDetails is set.
 	OfflineReplicas(topic string, partitionID int32) ([]int32, error)
 
 	// RefreshBrokers takes a list of addresses to be used as seed brokers.
@@ -110,7 +117,10 @@ type Client interface {
 	// InitProducerID retrieves information required for Idempotent Producer
 	InitProducerID() (*InitProducerIDResponse, error)
 
-	// LeastLoadedBroker retrieves broker that has the least responses pending
+	// LeastLoadedBroker returns the first seed broker while it is available,
+	// or else the broker of the cluster with the fewest requests in flight, as
+	// returned by Broker.InFlight, preferring the brokers already connected.
+	// The broker is opened if needed. It returns nil if no broker is available.
 	LeastLoadedBroker() *Broker
 
 	// Close shuts down all broker connections managed by this client. It is required
@@ -136,6 +146,64 @@ const (
 	OffsetOldest int64 = -2
 )
 
+// BrokerStatsProvider is implemented by the clients able to report the load
+// of the brokers, as the Client returned by NewClient does. It is separate
+// from Client so that the existing implementations of Client keep compiling.
+type BrokerStatsProvider interface {
+	// BrokerStats returns the load of the brokers of the cluster, as
+	// returned by Broker.Stats, in the order of their IDs.
+	BrokerStats() []BrokerStats
+}
+
+// HealthChecker is implemented by the clients able to check that they can
+// reach the brokers, as the Client returned by NewClient does. It is separate
+// from Client so that the existing implementations of Client keep compiling.
+type HealthChecker interface {
+	// Ping checks that the broker with the given ID accepts connections and
+	// authenticates the client, on a connection of its own closed afterwards,
+	// without any side effect on the client. It returns once the broker
+	// answered a request, or ctx is done, bounding the timeouts of the
+	// connection by the deadline of ctx.
+	Ping(ctx context.Context, brokerID int32) error
+
+	// Healthy pings the brokers of the cluster, or the seed brokers before
+	// the first metadata response, and returns nil once a majority of them is
+	// healthy, or else an error wrapping ErrClientUnhealthy and those of the
+	// brokers. It is meant for the readiness probes of applications, instead
+	// of RefreshMetadata, which changes the metadata of the client.
+	Healthy(ctx context.Context) error
+}
+
+// OffsetsForTimesGetter is implemented by the clients able to look up the
+// offsets of many partitions at once, as the Client returned by NewClient
+// does. It is separate from Client so that the existing implementations of
+// Client keep compiling.
+type OffsetsForTimesGetter interface {
+	// GetOffsetsForTimes queries the cluster to get the offset of the first
+	// message whose timestamp is at or after the given time, for each of the
+	// given partitions. It sends a single request to the leader of each
+	// partition, and the requests to the different leaders in parallel. The
+	// errors of the partitions are returned in their OffsetForTime, after the
+	// requests are retried once with fresh metadata. Requires Kafka 0.10.1 or
+	// higher, and Kafka 2.1 or higher for the leader epochs.
+	GetOffsetsForTimes(times map[TopicPartitionID]time.Time) (map[TopicPartitionID]*OffsetForTime, error)
+}
+
+// OffsetForTime is the result of OffsetsForTimesGetter.GetOffsetsForTimes for
+// a partition.
+type OffsetForTime struct {
+	// Offset is the offset of the first message whose timestamp is at or after
+	// the requested time, or -1 if there is none, which is OffsetNewest.
+	Offset int64
+	// Timestamp is the timestamp of that message in milliseconds, or -1.
+	Timestamp int64
+	// LeaderEpoch is the epoch of the leader which returned the offset, or -1
+	// if unknown.
+	LeaderEpoch int32
+	// Err is the error which prevented getting the offset, if any.
+	Err error
+}
+
 type client struct {
 	// updateMetadataMs stores the time at which metadata was lasted updated.
 	// Note: this accessed atomically so must be the first word in the struct
@@ -151,17 +219,23 @@ type client struct {
 	seedBrokers []*Broker
 	deadSeeds   []*Broker
 
-	controllerID            int32                                   // cluster controller broker id
-	brokers                 map[int32]*Broker                       // maps broker ids to brokers
-	metadata                map[string]map[int32]*PartitionMetadata // maps topics to partition ids to metadata
-	metadataTopics          map[string]none                         // topics that need to collect metadata
-	coordinators            map[string]int32                        // Maps consumer group names to coordinating broker IDs
-	transactionCoordinators map[string]int32                        // Maps transaction ids to coordinating broker IDs
+	controllerID            int32                                // cluster controller broker id
+	brokers                 map[int32]*Broker                    // maps broker ids to brokers
+	metadata                map[string]map[int32]cachedPartition // maps topics to partition ids to metadata
+	metadataTopics          map[string]none                      // topics that need to collect metadata
+	topicIDs                map[string]Uuid                      // maps topics to their IDs, if returned by the metadata
+	topicNames              map[string]string                    // interns the names of the topics of the metadata
+	coordinators            map[string]int32                     // Maps consumer group names to coordinating broker IDs
+	transactionCoordinators map[string]int32                     // Maps transaction ids to coordinating broker IDs
 
 	// If the number of partitions is large, we can get some churn calling cachedPartitions,
 	// so the result is cached.  It is important to update this value whenever metadata is changed
 	cachedPartitionsResults map[string][maxPartitionIndex][]int32
 
+	watchers map[string][]*topicWatch // maps topics to the watchers of their metadata
+
+	budget *retryBudget // shared by the producers, consumers and admin clients using the client
+
 	lock sync.RWMutex // protects access to the maps that hold cluster state.
 }
 
@@ -188,11 +262,15 @@ func NewClient(addrs []string, conf *Config) (Client, error) {
 		closer:                  make(chan none),
 		closed:                  make(chan none),
 		brokers:                 make(map[int32]*Broker),
-		metadata:                make(map[string]map[int32]*PartitionMetadata),
+		metadata:                make(map[string]map[int32]cachedPartition),
 		metadataTopics:          make(map[string]none),
+		topicIDs:                make(map[string]Uuid),
+		topicNames:              make(map[string]string),
 		cachedPartitionsResults: make(map[string][maxPartitionIndex][]int32),
 		coordinators:            make(map[string]int32),
 		transactionCoordinators: make(map[string]int32),
+		watchers:                make(map[string][]*topicWatch),
+		budget:                  newRetryBudget(conf),
 	}
 
 	if conf.Net.ResolveCanonicalBootstrapServers {
@@ -229,6 +307,10 @@ func (client *client) Config() *Config {
 	return client.conf
 }
 
+func (client *client) retryBudget() *retryBudget {
+	return client.budget
+}
+
 func (client *client) Brokers() []*Broker {
 	client.lock.RLock()
 	defer client.lock.RUnlock()
@@ -309,9 +391,17 @@ func (client *client) Close() error {
 		safeAsyncClose(broker)
 	}
 
+	for _, watchers := range client.watchers {
+		for _, w := range watchers {
+			close(w.done)
+		}
+	}
+
 	client.brokers = nil
 	client.metadata = nil
 	client.metadataTopics = nil
+	client.topicNames = nil
+	client.watchers = nil
 
 	return nil
 }
@@ -411,24 +501,24 @@ func (client *client) Replicas(topic string, partitionID int32) ([]int32, error)
 		return nil, ErrClosedClient
 	}
 
-	metadata := client.cachedMetadata(topic, partitionID)
+	metadata, ok := client.cachedMetadata(topic, partitionID)
 
-	if metadata == nil {
+	if !ok {
 		err := client.RefreshMetadata(topic)
 		if err != nil {
 			return nil, err
 		}
-		metadata = client.cachedMetadata(topic, partitionID)
+		metadata, ok = client.cachedMetadata(topic, partitionID)
 	}
 
-	if metadata == nil {
+	if !ok {
 		return nil, ErrUnknownTopicOrPartition
 	}
 
-	if errors.Is(metadata.Err, ErrReplicaNotAvailable) {
-		return dupInt32Slice(metadata.Replicas), metadata.Err
+	if errors.Is(metadata.err, ErrReplicaNotAvailable) {
+		return dupInt32Slice(metadata.replicaIDs()), metadata.err
 	}
-	return dupInt32Slice(metadata.Replicas), nil
+	return dupInt32Slice(metadata.replicaIDs()), nil
 }
 
 func (client *client) InSyncReplicas(topic string, partitionID int32) ([]int32, error) {
@@ -436,24 +526,28 @@ func (client *client) InSyncReplicas(topic string, partitionID int32) ([]int32,
 		return nil, ErrClosedClient
 	}
 
-	metadata := client.cachedMetadata(topic, partitionID)
+	metadata, ok := client.cachedMetadata(topic, partitionID)
 
-	if metadata == nil {
+	if !ok {
 		err := client.RefreshMetadata(topic)
 		if err != nil {
 			return nil, err
 		}
-		metadata = client.cachedMetadata(topic, partitionID)
+		metadata, ok = client.cachedMetadata(topic, partitionID)
 	}
 
-	if metadata == nil {
+	if !ok {
 		return nil, ErrUnknownTopicOrPartition
 	}
 
-	if errors.Is(metadata.Err, ErrReplicaNotAvailable) {
-		return dupInt32Slice(metadata.Isr), metadata.Err
+	if metadata.dropped {
+		return nil, ErrReplicaDetailsDropped
+	}
+
+	if errors.Is(metadata.err, ErrReplicaNotAvailable) {
+		return dupInt32Slice(metadata.isr()), metadata.err
 	}
-	return dupInt32Slice(metadata.Isr), nil
+	return dupInt32Slice(metadata.isr()), nil
 }
 
 func (client *client) OfflineReplicas(topic string, partitionID int32) ([]int32, error) {
@@ -461,24 +555,28 @@ func (client *client) OfflineReplicas(topic string, partitionID int32) ([]int32,
 		return nil, ErrClosedClient
 	}
 
-	metadata := client.cachedMetadata(topic, partitionID)
+	metadata, ok := client.cachedMetadata(topic, partitionID)
 
-	if metadata == nil {
+	if !ok {
 		err := client.RefreshMetadata(topic)
 		if err != nil {
 			return nil, err
 		}
-		metadata = client.cachedMetadata(topic, partitionID)
+		metadata, ok = client.cachedMetadata(topic, partitionID)
 	}
 
-	if metadata == nil {
+	if !ok {
 		return nil, ErrUnknownTopicOrPartition
 	}
 
-	if errors.Is(metadata.Err, ErrReplicaNotAvailable) {
-		return dupInt32Slice(metadata.OfflineReplicas), metadata.Err
+	if metadata.dropped {
+		return nil, ErrReplicaDetailsDropped
 	}
-	return dupInt32Slice(metadata.OfflineReplicas), nil
+
+	if errors.Is(metadata.err, ErrReplicaNotAvailable) {
+		return dupInt32Slice(metadata.offlineReplicas()), metadata.err
+	}
+	return dupInt32Slice(metadata.offlineReplicas()), nil
 }
 
 func (client *client) Leader(topic string, partitionID int32) (*Broker, error) {
@@ -553,6 +651,48 @@ func (client *client) RefreshMetadata(topics ...string) error {
 	return client.tryRefreshMetadata(topics, client.conf.Metadata.Retry.Max, deadline)
 }
 
+func (client *client) WatchTopic(ctx context.Context, topic string) (<-chan *TopicEvent, error) {
+	if topic == "" {
+		return nil, ErrInvalidTopic
+	}
+
+	client.lock.Lock()
+	defer client.lock.Unlock()
+	if client.brokers == nil {
+		return nil, ErrClosedClient
+	}
+
+	w := newTopicWatch(topic)
+	client.watchers[topic] = append(client.watchers[topic], w)
+	if _, ok := client.metadataTopics[topic]; !ok {
+		client.metadataTopics[topic] = none{}
+	}
+
+	go withRecover(func() {
+		w.run(ctx)
+		client.removeWatcher(w)
+	})
+	return w.events, nil
+}
+
+func (client *client) removeWatcher(w *topicWatch) {
+	client.lock.Lock()
+	defer client.lock.Unlock()
+
+	watchers := client.watchers[w.topic]
+	for i, other := range watchers {
+		if other == w {
+			watchers = append(watchers[:i:i], watchers[i+1:]...)
+			break
+		}
+	}
+	if len(watchers) == 0 {
+		delete(client.watchers, w.topic)
+	} else {
+		client.watchers[w.topic] = watchers
+	}
+}
+
 func (client *client) GetOffset(topic string, partitionID int32, time int64) (int64, error) {
 	if client.Closed() {
 		return -1, ErrClosedClient
@@ -569,6 +709,35 @@ func (client *client) GetOffset(topic string, partitionID int32, time int64) (in
 	return offset, err
 }
 
+func (client *client) GetOffsetsForTimes(times map[TopicPartitionID]time.Time) (map[TopicPartitionID]*OffsetForTime, error) {
+	if client.Closed() {
+		return nil, ErrClosedClient
+	}
+
+	results := make(map[TopicPartitionID]*OffsetForTime, len(times))
+	retry := client.getOffsetsForTimes(times, results)
+	if len(retry) == 0 {
+		return results, nil
+	}
+
+	topics := make([]string, 0, len(retry))
+	seen := make(map[string]none, len(retry))
+	for tp := range retry {
+		if _, ok := seen[tp.Topic]; !ok {
+			seen[tp.Topic] = none{}
+			topics = append(topics, tp.Topic)
+		}
+	}
+	if err := client.RefreshMetadata(topics...); err != nil {
+		for tp := range retry {
+			results[tp] = &OffsetForTime{Offset: -1, Timestamp: -1, LeaderEpoch: -1, Err: err}
+		}
+		return results, nil
+	}
+	client.getOffsetsForTimes(retry, results)
+	return results, nil
+}
+
 func (client *client) Controller() (*Broker, error) {
 	if client.Closed() {
 		return nil, ErrClosedClient
@@ -580,20 +749,40 @@ func (client *client) Controller() (*Broker, error) {
 
 	controller := client.cachedController()
 	if controller == nil {
-		if err := client.refreshMetadata(); err != nil {
+		var err error
+		if controller, err = client.discoverController(); err != nil {
 			return nil, err
 		}
-		controller = client.cachedController()
-	}
-
-	if controller == nil {
-		return nil, ErrControllerNotAvailable
 	}
 
 	_ = controller.Open(client.conf)
 	return controller, nil
 }
 
+// discoverController refreshes the metadata until it names a known
+// controller, up to Metadata.Controller.Retry.Max more times.
+func (client *client) discoverController() (*Broker, error) {
+	retry := client.conf.Metadata.Controller.Retry
+	for attemptsRemaining := retry.Max; ; attemptsRemaining-- {
+		if err := client.refreshMetadata(); err != nil {
+			return nil, err
+		}
+		if controller := client.cachedController(); controller != nil {
+			return controller, nil
+		}
+		if attemptsRemaining <= 0 || client.Closed() {
+			return nil, ErrControllerNotAvailable
+		}
+		if !client.retryBudget().allow(anyBrokerID) {
+			Logger.Println("client/controller not retrying because the retry budget is exhausted")
+			return nil, ErrControllerNotAvailable
+		}
+		Logger.Printf("client/controller no controller available, retrying after %dms... (%d attempts remaining)\n",
+			retry.Backoff/time.Millisecond, attemptsRemaining)
+		time.Sleep(retry.Backoff)
+	}
+}
+
 // deregisterController removes the cached controllerID
 func (client *client) deregisterController() {
 	client.lock.Lock()
@@ -613,15 +802,11 @@ func (client *client) RefreshController() (*Broker, error) {
 
 	client.deregisterController()
 
-	if err := client.refreshMetadata(); err != nil {
+	controller, err := client.discoverController()
+	if err != nil {
 		return nil, err
 	}
 
-	controller := client.cachedController()
-	if controller == nil {
-		return nil, ErrControllerNotAvailable
-	}
-
 	_ = controller.Open(client.conf)
 	return controller, nil
 }
@@ -665,6 +850,47 @@ func (client *client) RefreshCoordinator(consumerGroup string) error {
 	return nil
 }
 
+// CoordinatorInvalidator is implemented by the clients able to forget the
+// coordinators they cached, as the Client returned by NewClient does. It is
+// separate from Client so that the existing implementations of Client keep
+// compiling; the coordinators of the other clients are only looked up again
+// when refreshed.
+type CoordinatorInvalidator interface {
+	// InvalidateCoordinator removes the coordinator of a consumer group from
+	// the local cache, so that the next call to Coordinator looks it up
+	// again. It is called when the coordinator is unreachable or replies that
+	// it is no longer the coordinator of the group, as when the coordinator
+	// moved to another broker.
+	InvalidateCoordinator(consumerGroup string)
+
+	// InvalidateTransactionCoordinator removes the coordinator of a
+	// transaction id from the local cache, so that the next call to
+	// TransactionCoordinator looks it up again.
+	InvalidateTransactionCoordinator(transactionID string)
+}
+
+// invalidateCoordinator calls InvalidateCoordinator if client is a
+// CoordinatorInvalidator.
+func invalidateCoordinator(client Client, consumerGroup string) {
+	if c, ok := client.(CoordinatorInvalidator); ok {
+		c.InvalidateCoordinator(consumerGroup)
+	}
+}
+
+// invalidateTransactionCoordinator calls InvalidateTransactionCoordinator if
+// client is a CoordinatorInvalidator.
+func invalidateTransactionCoordinator(client Client, transactionID string) {
+	if c, ok := client.(CoordinatorInvalidator); ok {
+		c.InvalidateTransactionCoordinator(transactionID)
+	}
+}
+
+func (client *client) InvalidateCoordinator(consumerGroup string) {
+	client.lock.Lock()
+	defer client.lock.Unlock()
+	delete(client.coordinators, consumerGroup)
+}
+
 func (client *client) TransactionCoordinator(transactionID string) (*Broker, error) {
 	if client.Closed() {
 		return nil, ErrClosedClient
@@ -704,6 +930,12 @@ func (client *client) RefreshTransactionCoordinator(transactionID string) error
 	return nil
 }
 
+func (client *client) InvalidateTransactionCoordinator(transactionID string) {
+	client.lock.Lock()
+	defer client.lock.Unlock()
+	delete(client.transactionCoordinators, transactionID)
+}
+
 // private broker management helpers
 
 func (client *client) randomizeSeedBrokers(addrs []string) {
@@ -717,6 +949,7 @@ func (client *client) updateBroker(brokers []*Broker) {
 	currentBroker := make(map[int32]*Broker, len(brokers))
 
 	for _, broker := range brokers {
+		selectBrokerAddress(client.conf, broker)
 		currentBroker[broker.ID()] = broker
 		if client.brokers[broker.ID()] == nil { // add new broker
 			client.brokers[broker.ID()] = broker
@@ -745,6 +978,7 @@ func (client *client) registerBroker(broker *Broker) {
 		Logger.Printf("cannot register broker #%d at %s, client already closed", broker.ID(), broker.Addr())
 		return
 	}
+	selectBrokerAddress(client.conf, broker)
 
 	if client.brokers[broker.ID()] == nil {
 		client.brokers[broker.ID()] = broker
@@ -812,11 +1046,17 @@ func (client *client) LeastLoadedBroker() *Broker {
 	}
 
 	var leastLoadedBroker *Broker
+	var leastLoadedConnected bool
 	pendingRequests := math.MaxInt
 	for _, broker := range client.brokers {
-		if pendingRequests > broker.ResponseSize() {
-			pendingRequests = broker.ResponseSize()
+		inFlight := broker.InFlight()
+		// prefer the brokers already opened to opening a new connection, without
+		// waiting for the lock held by the requests in progress as Connected does
+		connected := atomic.LoadInt32(&broker.opened) == 1
+		if inFlight < pendingRequests || (inFlight == pendingRequests && connected && !leastLoadedConnected) {
+			pendingRequests = inFlight
 			leastLoadedBroker = broker
+			leastLoadedConnected = connected
 		}
 	}
 
@@ -826,6 +1066,149 @@ func (client *client) LeastLoadedBroker() *Broker {
 	return leastLoadedBroker
 }
 
+func (client *client) BrokerStats() []BrokerStats {
+	client.lock.RLock()
+	stats := make([]BrokerStats, 0, len(client.brokers))
+	for _, broker := range client.brokers {
+		stats = append(stats, broker.Stats())
+	}
+	client.lock.RUnlock()
+
+	sort.Slice(stats, func(i, j int) bool { return stats[i].ID < stats[j].ID })
+	return stats
+}
+
+func (client *client) Ping(ctx context.Context, brokerID int32) error {
+	if client.Closed() {
+		return ErrClosedClient
+	}
+
+	client.lock.RLock()
+	broker, ok := client.brokers[brokerID]
+	client.lock.RUnlock()
+	if !ok {
+		return ErrBrokerNotFound
+	}
+	return client.ping(ctx, broker.Addr())
+}
+
+func (client *client) Healthy(ctx context.Context) error {
+	if client.Closed() {
+		return ErrClosedClient
+	}
+
+	client.lock.RLock()
+	addrs := make([]string, 0, len(client.brokers))
+	for _, broker := range client.brokers {
+		addrs = append(addrs, broker.Addr())
+	}
+	if len(addrs) == 0 {
+		for _, broker := range client.seedBrokers {
+			addrs = append(addrs, broker.Addr())
+		}
+	}
+	client.lock.RUnlock()
+	if len(addrs) == 0 {
+		return ErrOutOfBrokers
+	}
+
+	results := make(chan error, len(addrs))
+	for _, addr := range addrs {
+		addr := addr
+		go withRecover(func() { results <- client.ping(ctx, addr) })
+	}
+
+	quorum := len(addrs)/2 + 1
+	var healthy int
+	var failures []error
+	for range addrs {
+		err := <-results
+		if err == nil {
+			healthy++
+		} else {
+			failures = append(failures, err)
+		}
+		switch {
+		case healthy >= quorum:
+			return nil
+		case len(failures) > len(addrs)-quorum:
+			return Wrap(ErrClientUnhealthy, failures...)
+		}
+	}
+	return nil
+}
+
+// ping opens a connection of its own to the broker at addr, authenticated as
+// the client, and sends it a request without side effects, within the deadline
+// of ctx and the timeouts of the client. The connection stays out of the
+// metrics of the client.
+func (client *client) ping(ctx context.Context, addr string) error {
+	conf := *client.conf
+	conf.MetricRegistry = metrics.NewRegistry()
+	conf.ApiVersionsRequest = false
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout := time.Until(deadline)
+		if timeout <= 0 {
+			return context.DeadlineExceeded
+		}
+		if conf.Net.DialTimeout > timeout {
+			conf.Net.DialTimeout = timeout
+		}
+		if conf.Net.ReadTimeout > timeout {
+			conf.Net.ReadTimeout = timeout
+		}
+		if conf.Net.WriteTimeout > timeout {
+			conf.Net.WriteTimeout = timeout
+		}
+	}
+
+	broker := NewBroker(addr)
+	done := make(chan error, 1)
+	go withRecover(func() {
+		done <- func() error {
+			if err := broker.Open(&conf); err != nil {
+				return err
+			}
+			if _, err := broker.Connected(); err != nil {
+				return err
+			}
+			if !conf.Version.IsAtLeast(V0_10_0_0) {
+				_, err := broker.GetMetadata(NewMetadataRequest(conf.Version, nil))
+				return err
+			}
+			response, err := broker.ApiVersions(&ApiVersionsRequest{})
+			if err != nil {
+				return err
+			}
+			if kerr := KError(response.ErrorCode); kerr != ErrNoError {
+				return kerr
+			}
+			return nil
+		}()
+	})
+
+	select {
+	case err := <-done:
+		_ = broker.Close()
+		if err != nil {
+			// the timeouts may have been those of ctx, which expires soon after
+			if deadline, ok := ctx.Deadline(); ctx.Err() != nil {
+				err = ctx.Err()
+			} else if ok && !time.Now().Before(deadline) {
+				err = context.DeadlineExceeded
+			}
+			return fmt.Errorf("kafka: broker %s is unhealthy: %w", addr, err)
+		}
+		return nil
+	case <-ctx.Done():
+		go withRecover(func() {
+			<-done
+			_ = broker.Close()
+		})
+		return fmt.Errorf("kafka: broker %s is unhealthy: %w", addr, ctx.Err())
+	}
+}
+
 // private caching/lazy metadata helpers
 
 type partitionType int
@@ -839,16 +1222,12 @@ const (
 	maxPartitionIndex
 )
 
-func (client *client) cachedMetadata(topic string, partitionID int32) *PartitionMetadata {
+func (client *client) cachedMetadata(topic string, partitionID int32) (cachedPartition, bool) {
 	client.lock.RLock()
 	defer client.lock.RUnlock()
 
-	partitions := client.metadata[topic]
-	if partitions != nil {
-		return partitions[partitionID]
-	}
-
-	return nil
+	metadata, ok := client.metadata[topic][partitionID]
+	return metadata, ok
 }
 
 func (client *client) cachedPartitions(topic string, partitionSet partitionType) []int32 {
@@ -871,11 +1250,11 @@ func (client *client) setPartitionCache(topic string, partitionSet partitionType
 	}
 
 	ret := make([]int32, 0, len(partitions))
-	for _, partition := range partitions {
-		if partitionSet == writablePartitions && errors.Is(partition.Err, ErrLeaderNotAvailable) {
+	for id, partition := range partitions {
+		if partitionSet == writablePartitions && errors.Is(partition.err, ErrLeaderNotAvailable) {
 			continue
 		}
-		ret = append(ret, partition.ID)
+		ret = append(ret, id)
 	}
 
 	sort.Sort(int32Slice(ret))
@@ -890,15 +1269,15 @@ func (client *client) cachedLeader(topic string, partitionID int32) (*Broker, in
 	if partitions != nil {
 		metadata, ok := partitions[partitionID]
 		if ok {
-			if errors.Is(metadata.Err, ErrLeaderNotAvailable) {
+			if errors.Is(metadata.err, ErrLeaderNotAvailable) {
 				return nil, -1, ErrLeaderNotAvailable
 			}
-			b := client.brokers[metadata.Leader]
+			b := client.brokers[metadata.leader]
 			if b == nil {
 				return nil, -1, ErrLeaderNotAvailable
 			}
 			_ = b.Open(client.conf)
-			return b, metadata.LeaderEpoch, nil
+			return b, metadata.leaderEpoch, nil
 		}
 	}
 
@@ -911,22 +1290,7 @@ func (client *client) getOffset(topic string, partitionID int32, time int64) (in
 		return -1, err
 	}
 
-	request := &OffsetRequest{}
-	if client.conf.Version.IsAtLeast(V2_1_0_0) {
-		// Version 4 adds the current leader epoch, which is used for fencing.
-		request.Version = 4
-	} else if client.conf.Version.IsAtLeast(V2_0_0_0) {
-		// Version 3 is the same as version 2.
-		request.Version = 3
-	} else if client.conf.Version.IsAtLeast(V0_11_0_0) {
-		// Version 2 adds the isolation level, which is used for transactional reads.
-		request.Version = 2
-	} else if client.conf.Version.IsAtLeast(V0_10_1_0) {
-		// Version 1 removes MaxNumOffsets.  From this version forward, only a single
-		// offset can be returned.
-		request.Version = 1
-	}
-
+	request := client.newOffsetRequest()
 	request.AddBlock(topic, partitionID, time, 1)
 
 	response, err := broker.GetAvailableOffsets(request)
@@ -950,6 +1314,112 @@ func (client *client) getOffset(topic string, partitionID int32, time int64) (in
 	return block.Offsets[0], nil
 }
 
+// getOffsetsForTimes stores the offsets of the given partitions in results,
+// sending a request to the cached leader of each partition, and returns the
+// partitions worth retrying with fresh metadata.
+func (client *client) getOffsetsForTimes(times map[TopicPartitionID]time.Time, results map[TopicPartitionID]*OffsetForTime) map[TopicPartitionID]time.Time {
+	retry := make(map[TopicPartitionID]time.Time)
+	failed := func(tp TopicPartitionID, err error) {
+		results[tp] = &OffsetForTime{Offset: -1, Timestamp: -1, LeaderEpoch: -1, Err: err}
+		var kerror KError
+		if !errors.As(err, &kerror) {
+			retry[tp] = times[tp]
+			return
+		}
+		switch kerror {
+		case ErrUnknownTopicOrPartition, ErrLeaderNotAvailable, ErrNotLeaderForPartition,
+			ErrFencedLeaderEpoch, ErrUnknownLeaderEpoch, ErrOffsetNotAvailable:
+			retry[tp] = times[tp]
+		}
+	}
+
+	requests := make(map[*Broker]*OffsetRequest)
+	for tp, t := range times {
+		broker, _, err := client.cachedLeader(tp.Topic, tp.Partition)
+		if err != nil {
+			failed(tp, err)
+			continue
+		}
+		request := requests[broker]
+		if request == nil {
+			request = client.newOffsetRequest()
+			requests[broker] = request
+		}
+		request.AddBlock(tp.Topic, tp.Partition, t.UnixNano()/int64(time.Millisecond), 1)
+	}
+
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	for broker, request := range requests {
+		wg.Add(1)
+		go withRecover(func(broker *Broker, request *OffsetRequest) func() {
+			return func() {
+				defer wg.Done()
+				response, err := broker.GetAvailableOffsets(request)
+				if err != nil {
+					_ = broker.Close()
+				}
+
+				lock.Lock()
+				defer lock.Unlock()
+				for topic, partitions := range request.blocks {
+					for partition := range partitions {
+						tp := TopicPartitionID{Topic: topic, Partition: partition}
+						if err != nil {
+							failed(tp, err)
+							continue
+						}
+						block := response.GetBlock(topic, partition)
+						if block == nil {
+							failed(tp, ErrIncompleteResponse)
+							continue
+						}
+						if !errors.Is(block.Err, ErrNoError) {
+							failed(tp, block.Err)
+							continue
+						}
+						result := &OffsetForTime{Offset: block.Offset, Timestamp: block.Timestamp, LeaderEpoch: -1}
+						if request.Version == 0 {
+							result.Offset, result.Timestamp = -1, -1
+							if len(block.Offsets) > 0 {
+								result.Offset = block.Offsets[0]
+							}
+						}
+						if request.Version >= 4 {
+							result.LeaderEpoch = block.LeaderEpoch
+						}
+						results[tp] = result
+					}
+				}
+			}
+		}(broker, request))
+	}
+	wg.Wait()
+
+	return retry
+}
+
+// newOffsetRequest returns an empty ListOffsets request of the version
+// supported by the configured Kafka version.
+func (client *client) newOffsetRequest() *OffsetRequest {
+	request := &OffsetRequest{}
+	if client.conf.Version.IsAtLeast(V2_1_0_0) {
+		// Version 4 adds the current leader epoch, which is used for fencing.
+		request.Version = 4
+	} else if client.conf.Version.IsAtLeast(V2_0_0_0) {
+		// Version 3 is the same as version 2.
+		request.Version = 3
+	} else if client.conf.Version.IsAtLeast(V0_11_0_0) {
+		// Version 2 adds the isolation level, which is used for transactional reads.
+		request.Version = 2
+	} else if client.conf.Version.IsAtLeast(V0_10_1_0) {
+		// Version 1 removes MaxNumOffsets.  From this version forward, only a single
+		// offset can be returned.
+		request.Version = 1
+	}
+	return request
+}
+
 // core metadata update logic
 
 func (client *client) backgroundMetadataUpdater() {
@@ -1009,6 +1479,10 @@ func (client *client) tryRefreshMetadata(topics []string, attemptsRemaining int,
 				Logger.Println("client/metadata skipping last retries as we would go past the metadata timeout")
 				return err
 			}
+			if !client.budget.allow(anyBrokerID) {
+				Logger.Println("client/metadata skipping last retries as the retry budget is exhausted")
+				return err
+			}
 			if backoff > 0 {
 				time.Sleep(backoff)
 			}
@@ -1108,18 +1582,26 @@ func (client *client) updateMetadata(data *MetadataResponse, allKnownMetaData bo
 
 	client.controllerID = data.ControllerID
 
+	previousMetadata, previousTopicIDs, previousTopics, previousNames := client.metadata, client.topicIDs, client.metadataTopics, client.topicNames
 	if allKnownMetaData {
-		client.metadata = make(map[string]map[int32]*PartitionMetadata)
+		client.metadata = make(map[string]map[int32]cachedPartition)
+		client.topicIDs = make(map[string]Uuid)
+		client.topicNames = make(map[string]string)
 		client.metadataTopics = make(map[string]none)
 		client.cachedPartitionsResults = make(map[string][maxPartitionIndex][]int32)
 	}
 	for _, topic := range data.Topics {
+		if _, used := previousTopics[topic.Name]; allKnownMetaData && !used && !client.keepsTopic(topic.Name) {
+			continue
+		}
+		name := client.internTopic(previousNames, topic.Name)
 		// topics must be added firstly to `metadataTopics` to guarantee that all
 		// requested topics must be recorded to keep them trackable for periodically
 		// metadata refresh.
 		if _, exists := client.metadataTopics[topic.Name]; !exists {
-			client.metadataTopics[topic.Name] = none{}
+			client.metadataTopics[name] = none{}
 		}
+		previous := previousMetadata[topic.Name]
 		delete(client.metadata, topic.Name)
 		delete(client.cachedPartitionsResults, topic.Name)
 
@@ -1141,18 +1623,42 @@ func (client *client) updateMetadata(data *MetadataResponse, allKnownMetaData bo
 			continue
 		}
 
-		client.metadata[topic.Name] = make(map[int32]*PartitionMetadata, len(topic.Partitions))
+		// the leader epochs start again from 0 when a topic is recreated
+		previousID := previousTopicIDs[topic.Name]
+		recreated := previousID != (Uuid{}) && topic.Uuid != (Uuid{}) && previousID != topic.Uuid
+		if topic.Uuid != (Uuid{}) {
+			client.topicIDs[name] = topic.Uuid
+		}
+
+		partitions := make(map[int32]cachedPartition, len(topic.Partitions))
 		for _, partition := range topic.Partitions {
-			client.metadata[topic.Name][partition.ID] = partition
-			if errors.Is(partition.Err, ErrLeaderNotAvailable) {
+			metadata := newCachedPartition(partition, client.conf.Metadata.DropReplicaDetails)
+			if cached, ok := previous[partition.ID]; ok && !recreated && partition.LeaderEpoch >= 0 && partition.LeaderEpoch < cached.leaderEpoch {
+				// the broker which returned the metadata has not caught up with
+				// the leader election we already know of, keep the newer leader
+				DebugLogger.Printf("client/metadata ignoring stale metadata for %s/%d with leader epoch %d older than %d\n",
+					topic.Name, partition.ID, partition.LeaderEpoch, cached.leaderEpoch)
+				metadata = cached
+			}
+			partitions[partition.ID] = metadata
+			if errors.Is(metadata.err, ErrLeaderNotAvailable) {
 				retry = true
 			}
 		}
+		client.metadata[name] = partitions
 
 		var partitionCache [maxPartitionIndex][]int32
 		partitionCache[allPartitions] = client.setPartitionCache(topic.Name, allPartitions)
 		partitionCache[writablePartitions] = client.setPartitionCache(topic.Name, writablePartitions)
-		client.cachedPartitionsResults[topic.Name] = partitionCache
+		client.cachedPartitionsResults[name] = partitionCache
+
+		if watchers := client.watchers[topic.Name]; len(watchers) > 0 {
+			if events := topicEvents(name, previous, partitions); len(events) > 0 {
+				for _, w := range watchers {
+					w.notify(events)
+				}
+			}
+		}
 	}
 
 	return
@@ -1176,11 +1682,37 @@ func (client *client) cachedTransactionCoordinator(transactionID string) *Broker
 	return nil
 }
 
+// keepsTopic returns whether the topic passes Metadata.AllowList and
+// Metadata.DenyList.
+func (client *client) keepsTopic(topic string) bool {
+	allowed := len(client.conf.Metadata.AllowList) == 0
+	for _, pattern := range client.conf.Metadata.AllowList {
+		if pattern.MatchString(topic) {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return false
+	}
+	for _, pattern := range client.conf.Metadata.DenyList {
+		if pattern.MatchString(topic) {
+			return false
+		}
+	}
+	return true
+}
+
 func (client *client) cachedController() *Broker {
 	client.lock.RLock()
-	defer client.lock.RUnlock()
+	controller := client.brokers[client.controllerID]
+	client.lock.RUnlock()
 
-	return client.brokers[client.controllerID]
+	if controller == nil && client.conf.Metadata.Controller.Forward {
+		// the brokers forward the requests to the controller
+		return client.LeastLoadedBroker()
+	}
+	return controller
 }
 
 func (client *client) computeBackoff(attemptsRemaining int) time.Duration {
@@ -1331,3 +1863,15 @@ type nopCloserClient struct {
 func (ncc *nopCloserClient) Close() error {
 	return nil
 }
+
+func (ncc *nopCloserClient) retryBudget() *retryBudget {
+	return retryBudgetOf(ncc.Client)
+}
+
+func (ncc *nopCloserClient) InvalidateCoordinator(consumerGroup string) {
+	invalidateCoordinator(ncc.Client, consumerGroup)
+}
+
+func (ncc *nopCloserClient) InvalidateTransactionCoordinator(transactionID string) {
+	invalidateTransactionCoordinator(ncc.Client, transactionID)
+}
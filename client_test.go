@@ -1,8 +1,14 @@
 package sarama
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"io"
+	"reflect"
+	"regexp"
+	"runtime"
+	"sort"
 	"sync"
 	"sync/atomic"
 	"syscall"
@@ -218,6 +224,56 @@ func TestClientMetadata(t *testing.T) {
 	safeClose(t, client)
 }
 
+func TestClientMetadataTopicFilter(t *testing.T) {
+	seedBroker := NewMockBroker(t, 1)
+	defer seedBroker.Close()
+
+	seedBroker.SetHandlerByMap(map[string]MockResponse{
+		"MetadataRequest": NewMockMetadataResponse(t).
+			SetBroker(seedBroker.Addr(), seedBroker.BrokerID()).
+			SetLeader("orders.a", 0, seedBroker.BrokerID()).
+			SetLeader("orders.b", 0, seedBroker.BrokerID()).
+			SetLeader("logs.x", 0, seedBroker.BrokerID()),
+	})
+
+	config := NewTestConfig()
+	config.Metadata.AllowList = []*regexp.Regexp{regexp.MustCompile(`^orders\.`)}
+	config.Metadata.DenyList = []*regexp.Regexp{regexp.MustCompile(`\.b$`)}
+	client, err := NewClient([]string{seedBroker.Addr()}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer safeClose(t, client)
+
+	topics, err := client.Topics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(topics, []string{"orders.a"}) {
+		t.Errorf("expected the metadata of orders.a only, got %v", topics)
+	}
+
+	// a topic used by the client is requested and kept whatever the lists
+	if _, err := client.Partitions("logs.x"); err != nil {
+		t.Fatal(err)
+	}
+	history := seedBroker.History()
+	if req, ok := history[len(history)-1].Request.(*MetadataRequest); !ok || !reflect.DeepEqual(req.Topics, []string{"logs.x"}) {
+		t.Errorf("expected the metadata of logs.x to be requested, got %+v", history[len(history)-1].Request)
+	}
+	if err := client.RefreshMetadata(); err != nil {
+		t.Fatal(err)
+	}
+	topics, err = client.Topics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(topics)
+	if !reflect.DeepEqual(topics, []string{"logs.x", "orders.a"}) {
+		t.Errorf("expected the metadata of logs.x and orders.a, got %v", topics)
+	}
+}
+
 func TestClientMetadataWithOfflineReplicas(t *testing.T) {
 	seedBroker := NewMockBroker(t, 1)
 	leader := NewMockBroker(t, 5)
@@ -306,6 +362,115 @@ func TestClientMetadataWithOfflineReplicas(t *testing.T) {
 	safeClose(t, client)
 }
 
+func TestClientDropReplicaDetails(t *testing.T) {
+	seedBroker := NewMockBroker(t, 1)
+	defer seedBroker.Close()
+
+	metadataResponse := new(MetadataResponse)
+	metadataResponse.AddBroker(seedBroker.Addr(), seedBroker.BrokerID())
+	metadataResponse.AddTopicPartition("my_topic", 0, seedBroker.BrokerID(), []int32{1, 2, 3}, []int32{1, 2}, []int32{3}, ErrNoError)
+	metadataResponse.Version = 5
+	seedBroker.Returns(metadataResponse)
+
+	config := NewTestConfig()
+	config.Version = V1_0_0_0
+	config.Metadata.Retry.Max = 0
+	config.Metadata.DropReplicaDetails = true
+	c, err := NewClient([]string{seedBroker.Addr()}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer safeClose(t, c)
+
+	if leader, err := c.Leader("my_topic", 0); err != nil || leader.ID() != 1 {
+		t.Errorf("expected the leader 1, got %v (%v)", leader, err)
+	}
+	if replicas, err := c.Replicas("my_topic", 0); err != nil || !reflect.DeepEqual(replicas, []int32{1, 2, 3}) {
+		t.Errorf("expected the replicas [1 2 3], got %v (%v)", replicas, err)
+	}
+	if _, err := c.InSyncReplicas("my_topic", 0); !errors.Is(err, ErrReplicaDetailsDropped) {
+		t.Errorf("expected ErrReplicaDetailsDropped for the in-sync replicas, got %v", err)
+	}
+	if _, err := c.OfflineReplicas("my_topic", 0); !errors.Is(err, ErrReplicaDetailsDropped) {
+		t.Errorf("expected ErrReplicaDetailsDropped for the offline replicas, got %v", err)
+	}
+	if partition, _ := c.(*client).cachedMetadata("my_topic", 0); cap(partition.replicas) != 3 {
+		t.Errorf("expected only the 3 replicas to be kept, got %v", partition.replicas[:cap(partition.replicas)])
+	}
+}
+
+func TestCachedPartition(t *testing.T) {
+	expected := &PartitionMetadata{
+		Err:             ErrReplicaNotAvailable,
+		ID:              7,
+		Leader:          2,
+		LeaderEpoch:     4,
+		Replicas:        []int32{1, 2, 3},
+		Isr:             []int32{2},
+		OfflineReplicas: []int32{1, 3},
+	}
+	if metadata := newCachedPartition(expected, false).metadata(7); !reflect.DeepEqual(metadata, expected) {
+		t.Errorf("expected %+v, got %+v", expected, metadata)
+	}
+
+	empty := newCachedPartition(&PartitionMetadata{Replicas: []int32{1}}, false)
+	if len(empty.isr()) != 0 || len(empty.offlineReplicas()) != 0 || !reflect.DeepEqual(empty.replicaIDs(), []int32{1}) {
+		t.Errorf("expected only the replica 1, got %v %v %v", empty.replicaIDs(), empty.isr(), empty.offlineReplicas())
+	}
+}
+
+func TestClientAddressSelector(t *testing.T) {
+	seedBroker := NewMockBroker(t, 1)
+	defer seedBroker.Close()
+	leader := NewMockBroker(t, 5)
+	defer leader.Close()
+
+	// the brokers advertise their internal listener only
+	metadataResponse := new(MetadataResponse)
+	metadataResponse.AddBroker("kafka-5.internal:9092", leader.BrokerID())
+	metadataResponse.AddTopicPartition("my_topic", 0, leader.BrokerID(), nil, nil, nil, ErrNoError)
+	seedBroker.Returns(metadataResponse)
+
+	config := NewTestConfig()
+	config.Metadata.Retry.Max = 0
+	config.Net.AddressSelector = func(id int32, addr string) string {
+		if id == leader.BrokerID() && addr == "kafka-5.internal:9092" {
+			return leader.Addr()
+		}
+		return ""
+	}
+	c, err := NewClient([]string{seedBroker.Addr()}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer safeClose(t, c)
+
+	b, err := c.Leader("my_topic", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Addr() != leader.Addr() {
+		t.Errorf("expected the leader at %s, got %s", leader.Addr(), b.Addr())
+	}
+	if connected, err := b.Connected(); err != nil || !connected {
+		t.Errorf("expected the leader to be connected, got %v (%v)", connected, err)
+	}
+}
+
+func TestTemplateAddressSelector(t *testing.T) {
+	for _, tc := range []struct {
+		template, addr, expected string
+	}{
+		{"kafka-{id}.example.com:{port}", "10.0.0.3:9092", "kafka-3.example.com:9092"},
+		{"{host}.external:9094", "b3.internal:9092", "b3.internal.external:9094"},
+		{"[{host}]:9094", "[::1]:9092", "[::1]:9094"},
+	} {
+		if addr := NewTemplateAddressSelector(tc.template)(3, tc.addr); addr != tc.expected {
+			t.Errorf("expected %s for %s with %s, got %s", tc.expected, tc.addr, tc.template, addr)
+		}
+	}
+}
+
 func TestClientGetOffset(t *testing.T) {
 	seedBroker := NewMockBroker(t, 1)
 	leader := NewMockBroker(t, 2)
@@ -731,6 +896,142 @@ func TestClientController(t *testing.T) {
 	})
 }
 
+func TestClientControllerRediscovery(t *testing.T) {
+	seedBroker := NewMockBroker(t, 1)
+	defer seedBroker.Close()
+
+	noController := NewMockMetadataResponse(t).
+		SetBroker(seedBroker.Addr(), seedBroker.BrokerID())
+	seedBroker.SetHandlerByMap(map[string]MockResponse{
+		"MetadataRequest": NewMockSequence(noController, noController,
+			NewMockMetadataResponse(t).
+				SetController(seedBroker.BrokerID()).
+				SetBroker(seedBroker.Addr(), seedBroker.BrokerID())),
+	})
+
+	config := NewTestConfig()
+	config.Version = V1_0_0_0
+	config.Metadata.Controller.Retry.Backoff = 0
+	client, err := NewClient([]string{seedBroker.Addr()}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer safeClose(t, client)
+
+	controller, err := client.Controller()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if controller.ID() != seedBroker.BrokerID() {
+		t.Errorf("Expected controller %d, found %d", seedBroker.BrokerID(), controller.ID())
+	}
+}
+
+func TestClientControllerForward(t *testing.T) {
+	seedBroker := NewMockBroker(t, 1)
+	defer seedBroker.Close()
+
+	// the controller of a KRaft cluster is not one of the brokers
+	seedBroker.SetHandlerByMap(map[string]MockResponse{
+		"ApiVersionsRequest": NewMockApiVersionsResponse(t),
+		"MetadataRequest": NewMockMetadataResponse(t).
+			SetController(3000).
+			SetBroker(seedBroker.Addr(), seedBroker.BrokerID()),
+	})
+
+	config := NewTestConfig()
+	config.Version = V2_8_0_0
+	config.Metadata.Controller.Retry.Max = 0
+	client, err := NewClient([]string{seedBroker.Addr()}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer safeClose(t, client)
+
+	if _, err := client.Controller(); !errors.Is(err, ErrControllerNotAvailable) {
+		t.Errorf("Expected %s without forwarding, found %v", ErrControllerNotAvailable, err)
+	}
+
+	config.Metadata.Controller.Forward = true
+	forwarding, err := NewClient([]string{seedBroker.Addr()}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer safeClose(t, forwarding)
+
+	controller, err := forwarding.Controller()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if controller.Addr() != seedBroker.Addr() {
+		t.Errorf("Expected the controller requests to go to %s, found %s", seedBroker.Addr(), controller.Addr())
+	}
+}
+
+func TestClientPingAndHealthy(t *testing.T) {
+	seedBroker := NewMockBroker(t, 1)
+	defer seedBroker.Close()
+	brokers := []*MockBroker{NewMockBroker(t, 2), NewMockBroker(t, 3), NewMockBroker(t, 4)}
+
+	defer brokers[0].Close()
+
+	metadata := NewMockMetadataResponse(t)
+	for _, broker := range brokers {
+		broker.SetHandlerByMap(map[string]MockResponse{
+			"ApiVersionsRequest": NewMockApiVersionsResponse(t),
+		})
+		metadata.SetBroker(broker.Addr(), broker.BrokerID())
+	}
+	seedBroker.SetHandlerByMap(map[string]MockResponse{
+		"MetadataRequest": metadata,
+	})
+
+	config := NewTestConfig()
+	config.Version = V1_0_0_0
+	client, err := NewClient([]string{seedBroker.Addr()}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer safeClose(t, client)
+	checker, ok := client.(HealthChecker)
+	if !ok {
+		t.Fatal("expected the client to be a HealthChecker")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := checker.Healthy(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := checker.Ping(ctx, 2); err != nil {
+		t.Error(err)
+	}
+	if err := checker.Ping(ctx, 1); !errors.Is(err, ErrBrokerNotFound) {
+		t.Errorf("Expected %s, found %v", ErrBrokerNotFound, err)
+	}
+	if requests := len(seedBroker.History()); requests != 1 {
+		t.Errorf("Expected no metadata refresh, found %d metadata requests", requests)
+	}
+
+	// the deadline of ctx bounds the pings of slow brokers
+	brokers[0].SetLatency(time.Second)
+	short, cancelShort := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancelShort()
+	if err := checker.Ping(short, 2); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected %s, found %v", context.DeadlineExceeded, err)
+	}
+
+	brokers[1].Close()
+	if err := checker.Healthy(ctx); err != nil {
+		t.Errorf("Expected a majority of the brokers to be healthy, found %v", err)
+	}
+	brokers[2].Close()
+	if err := checker.Healthy(ctx); !errors.Is(err, ErrClientUnhealthy) {
+		t.Errorf("Expected %s, found %v", ErrClientUnhealthy, err)
+	}
+}
+
 func TestClientMetadataTimeout(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -1122,3 +1423,546 @@ func TestMetricsCleanup(t *testing.T) {
 		t.Errorf("excepted 1 metric, found: %v", all)
 	}
 }
+
+func TestClientWatchTopic(t *testing.T) {
+	seedBroker := NewMockBroker(t, 1)
+	defer seedBroker.Close()
+
+	metadata := func(partitions int, leader int32, isr []int32) *MetadataResponse {
+		metadataResponse := new(MetadataResponse)
+		metadataResponse.AddBroker(seedBroker.Addr(), seedBroker.BrokerID())
+		for i := 0; i < partitions; i++ {
+			metadataResponse.AddTopicPartition("my_topic", int32(i), leader, []int32{1, 2}, isr, []int32{}, ErrNoError)
+		}
+		return metadataResponse
+	}
+	seedBroker.Returns(metadata(1, 1, []int32{1, 2}))
+
+	config := NewTestConfig()
+	config.Metadata.Retry.Max = 0
+	config.Metadata.RefreshFrequency = 0
+	client, err := NewClient([]string{seedBroker.Addr()}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer safeClose(t, client)
+
+	watcher, ok := client.(TopicWatcher)
+	if !ok {
+		t.Fatal("expected the client to be a TopicWatcher")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	events, err := watcher.WatchTopic(ctx, "my_topic")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Unchanged metadata emits no event.
+	seedBroker.Returns(metadata(1, 1, []int32{2, 1}))
+	if err := client.RefreshMetadata("my_topic"); err != nil {
+		t.Fatal(err)
+	}
+	// A partition is added, and the leader and the ISR of partition 0 change.
+	seedBroker.Returns(metadata(2, 2, []int32{2}))
+	if err := client.RefreshMetadata("my_topic"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []TopicEvent{
+		{Type: TopicPartitionCountChanged, Topic: "my_topic", PreviousPartitionCount: 1, PartitionCount: 2, Partition: -1},
+		{Type: TopicLeaderChanged, Topic: "my_topic", PreviousPartitionCount: 1, PartitionCount: 2, Partition: 0},
+		{Type: TopicISRChanged, Topic: "my_topic", PreviousPartitionCount: 1, PartitionCount: 2, Partition: 0},
+	} {
+		select {
+		case event := <-events:
+			if event.Type != expected.Type || event.Topic != expected.Topic || event.Partition != expected.Partition ||
+				event.PreviousPartitionCount != expected.PreviousPartitionCount || event.PartitionCount != expected.PartitionCount {
+				t.Errorf("expected %s event %+v, got %+v", expected.Type, expected, event)
+			}
+			if event.Type == TopicLeaderChanged && (event.Previous.Leader != 1 || event.Current.Leader != 2) {
+				t.Error("expected the leader to change from 1 to 2, got", event.Previous.Leader, event.Current.Leader)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for the %s event", expected.Type)
+		}
+	}
+
+	cancel()
+	if _, ok := <-events; ok {
+		t.Error("expected no more events")
+	}
+}
+
+func TestTopicWatcherMergesEvents(t *testing.T) {
+	w := newTopicWatch("my_topic")
+	partitions := func(leader int32) map[int32]cachedPartition {
+		return map[int32]cachedPartition{
+			0: newCachedPartition(&PartitionMetadata{ID: 0, Leader: leader, Isr: []int32{1, 2}}, false),
+			1: newCachedPartition(&PartitionMetadata{ID: 1, Leader: 1, Isr: []int32{1, 2}}, false),
+		}
+	}
+
+	// The leader of partition 0 changes many times while nothing is read.
+	previous := partitions(1)
+	for leader := int32(2); leader <= 100; leader++ {
+		current := partitions(leader)
+		w.notify(topicEvents("my_topic", previous, current))
+		previous = current
+	}
+	w.notify(topicEvents("my_topic", previous, nil))
+
+	if len(w.pending) != 2 {
+		t.Fatalf("expected 2 pending events, got %d", len(w.pending))
+	}
+	if event := w.pending[0]; event.Type != TopicLeaderChanged || event.Partition != 0 ||
+		event.Previous.Leader != 1 || event.Current.Leader != 100 {
+		t.Errorf("expected the leader changes to merge into one from 1 to 100, got %+v", event)
+	}
+	if event := w.pending[1]; event.Type != TopicPartitionCountChanged ||
+		event.PreviousPartitionCount != 2 || event.PartitionCount != 0 {
+		t.Errorf("expected the partition count to change from 2 to 0, got %+v", event)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go w.run(ctx)
+	for _, expected := range []TopicEventType{TopicLeaderChanged, TopicPartitionCountChanged} {
+		select {
+		case event := <-w.events:
+			if event.Type != expected {
+				t.Errorf("expected a %s event, got %s", expected, event.Type)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for the %s event", expected)
+		}
+	}
+	cancel()
+	if _, ok := <-w.events; ok {
+		t.Error("expected no more events")
+	}
+}
+
+func TestClientGetOffsetsForTimes(t *testing.T) {
+	seedBroker := NewMockBroker(t, 1)
+	defer seedBroker.Close()
+	leader1 := NewMockBroker(t, 2)
+	defer leader1.Close()
+	leader2 := NewMockBroker(t, 3)
+	defer leader2.Close()
+
+	seedBroker.SetHandlerByMap(map[string]MockResponse{
+		"MetadataRequest": NewMockMetadataResponse(t).
+			SetBroker(leader1.Addr(), leader1.BrokerID()).
+			SetBroker(leader2.Addr(), leader2.BrokerID()).
+			SetLeader("foo", 0, leader1.BrokerID()).
+			SetLeader("foo", 1, leader1.BrokerID()).
+			SetLeader("bar", 0, leader2.BrokerID()),
+	})
+	start := time.Unix(1700000000, 0)
+	ms := start.UnixNano() / int64(time.Millisecond)
+	leader1.SetHandlerByMap(map[string]MockResponse{
+		"OffsetRequest": NewMockOffsetResponse(t).
+			SetOffset("foo", 0, ms, 10).
+			SetOffset("foo", 1, ms+1000, 20),
+	})
+	leader2.SetHandlerByMap(map[string]MockResponse{
+		"OffsetRequest": NewMockOffsetResponse(t).
+			SetOffset("bar", 0, ms, 30),
+	})
+
+	config := NewTestConfig()
+	config.Version = V2_1_0_0
+	config.Metadata.Retry.Max = 0
+	client, err := NewClient([]string{seedBroker.Addr()}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer safeClose(t, client)
+
+	getter, ok := client.(OffsetsForTimesGetter)
+	if !ok {
+		t.Fatal("expected the client to be an OffsetsForTimesGetter")
+	}
+	results, err := getter.GetOffsetsForTimes(map[TopicPartitionID]time.Time{
+		{Topic: "foo", Partition: 0}: start,
+		{Topic: "foo", Partition: 1}: start.Add(time.Second),
+		{Topic: "bar", Partition: 0}: start,
+		{Topic: "foo", Partition: 5}: start,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for tp, expected := range map[TopicPartitionID]int64{
+		{Topic: "foo", Partition: 0}: 10,
+		{Topic: "foo", Partition: 1}: 20,
+		{Topic: "bar", Partition: 0}: 30,
+	} {
+		if result := results[tp]; result == nil || result.Err != nil || result.Offset != expected {
+			t.Errorf("expected offset %d for %s, got %+v", expected, tp, result)
+		}
+	}
+	if result := results[TopicPartitionID{Topic: "foo", Partition: 5}]; result == nil || !errors.Is(result.Err, ErrUnknownTopicOrPartition) {
+		t.Error("expected ErrUnknownTopicOrPartition for an unknown partition, got", result)
+	}
+
+	// A single request is sent to each leader.
+	for _, leader := range []*MockBroker{leader1, leader2} {
+		requests := 0
+		for _, rr := range leader.History() {
+			if _, ok := rr.Request.(*OffsetRequest); ok {
+				requests++
+			}
+		}
+		if requests != 1 {
+			t.Errorf("expected a single request to broker %d, got %d", leader.BrokerID(), requests)
+		}
+	}
+}
+
+func TestClientLeastLoadedBroker(t *testing.T) {
+	seedBroker := NewMockBroker(t, 1)
+	defer seedBroker.Close()
+	busy := NewMockBroker(t, 2)
+	defer busy.Close()
+	idle := NewMockBroker(t, 3)
+	defer idle.Close()
+
+	seedBroker.SetHandlerByMap(map[string]MockResponse{
+		"MetadataRequest": NewMockMetadataResponse(t).
+			SetBroker(busy.Addr(), busy.BrokerID()).
+			SetBroker(idle.Addr(), idle.BrokerID()).
+			SetLeader("foo", 0, busy.BrokerID()),
+	})
+	busy.SetLatency(200 * time.Millisecond)
+	busy.SetHandlerByMap(map[string]MockResponse{
+		"OffsetRequest": NewMockOffsetResponse(t).SetOffset("foo", 0, OffsetNewest, 10),
+	})
+
+	c, err := NewClient([]string{seedBroker.Addr()}, NewTestConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer safeClose(t, c)
+	client := c.(*client)
+
+	if leastLoaded := client.LeastLoadedBroker(); leastLoaded == nil || leastLoaded.Addr() != seedBroker.Addr() {
+		t.Error("expected the seed broker to be preferred, got", leastLoaded)
+	}
+
+	client.lock.Lock()
+	safeClose(t, client.seedBrokers[0])
+	client.seedBrokers = nil
+	client.lock.Unlock()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := client.GetOffset("foo", 0, OffsetNewest)
+		done <- err
+	}()
+
+	broker, _ := client.Broker(busy.BrokerID())
+	deadline := time.Now().Add(time.Second)
+	for broker.InFlight() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the request to be sent")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if leastLoaded := client.LeastLoadedBroker(); leastLoaded == nil || leastLoaded.ID() != idle.BrokerID() {
+		t.Error("expected the idle broker to be the least loaded, got", leastLoaded)
+	}
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if broker.InFlight() != 0 {
+		t.Error("expected no request in flight, got", broker.InFlight())
+	}
+}
+
+func TestClientBrokerStats(t *testing.T) {
+	seedBroker := NewMockBroker(t, 1)
+	defer seedBroker.Close()
+	busy := NewMockBroker(t, 2)
+	defer busy.Close()
+	idle := NewMockBroker(t, 3)
+	defer idle.Close()
+
+	seedBroker.SetHandlerByMap(map[string]MockResponse{
+		"MetadataRequest": NewMockMetadataResponse(t).
+			SetBroker(busy.Addr(), busy.BrokerID()).
+			SetBroker(idle.Addr(), idle.BrokerID()).
+			SetLeader("foo", 0, busy.BrokerID()),
+	})
+	busy.SetLatency(200 * time.Millisecond)
+	busy.SetHandlerByMap(map[string]MockResponse{
+		"OffsetRequest": NewMockOffsetResponse(t).SetOffset("foo", 0, OffsetNewest, 10),
+	})
+
+	client, err := NewClient([]string{seedBroker.Addr()}, NewTestConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer safeClose(t, client)
+	provider, ok := client.(BrokerStatsProvider)
+	if !ok {
+		t.Fatal("expected the client to be a BrokerStatsProvider")
+	}
+
+	// the requests are sent one at a time by GetOffset
+	done := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := client.GetOffset("foo", 0, OffsetNewest)
+			done <- err
+		}()
+	}
+
+	var stats []BrokerStats
+	deadline := time.Now().Add(time.Second)
+	for {
+		stats = provider.BrokerStats()
+		if len(stats) == 2 && stats[0].InFlight == 1 && stats[0].Queued == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for a request in flight and a queued one, got %+v", stats)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if stats[0].ID != busy.BrokerID() || stats[0].Addr != busy.Addr() || !stats[0].Opened {
+		t.Errorf("unexpected stats of the busy broker %+v", stats[0])
+	}
+	if expected := (BrokerStats{ID: idle.BrokerID(), Addr: idle.Addr()}); stats[1] != expected {
+		t.Errorf("expected the stats of the idle broker %+v, got %+v", expected, stats[1])
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+	}
+	broker, _ := client.Broker(busy.BrokerID())
+	if stats := broker.Stats(); stats.InFlight != 0 || stats.Queued != 0 {
+		t.Errorf("expected no request in flight or queued, got %+v", stats)
+	}
+}
+
+func TestClientLeastLoadedBrokerPrefersConnected(t *testing.T) {
+	seedBroker := NewMockBroker(t, 1)
+	defer seedBroker.Close()
+	leaders := []*MockBroker{NewMockBroker(t, 2), NewMockBroker(t, 3), NewMockBroker(t, 4)}
+	metadataResponse := NewMockMetadataResponse(t)
+	for _, leader := range leaders {
+		defer leader.Close()
+		metadataResponse.SetBroker(leader.Addr(), leader.BrokerID())
+	}
+	seedBroker.SetHandlerByMap(map[string]MockResponse{
+		"MetadataRequest": metadataResponse,
+	})
+
+	c, err := NewClient([]string{seedBroker.Addr()}, NewTestConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer safeClose(t, c)
+	client := c.(*client)
+
+	client.lock.Lock()
+	safeClose(t, client.seedBrokers[0])
+	client.seedBrokers = nil
+	client.lock.Unlock()
+
+	// no request is in flight on any broker, so the tie is broken by the only
+	// broker already connected
+	connected := leaders[len(leaders)-1]
+	if _, err := client.Broker(connected.BrokerID()); err != nil {
+		t.Fatal(err)
+	}
+	if leastLoaded := client.LeastLoadedBroker(); leastLoaded == nil || leastLoaded.ID() != connected.BrokerID() {
+		t.Error("expected the connected broker to be the least loaded, got", leastLoaded)
+	}
+}
+
+func TestClientInvalidateCoordinator(t *testing.T) {
+	seedBroker := NewMockBroker(t, 1)
+	defer seedBroker.Close()
+	staleCoordinator := NewMockBroker(t, 2)
+	defer staleCoordinator.Close()
+	freshCoordinator := NewMockBroker(t, 3)
+	defer freshCoordinator.Close()
+
+	seedBroker.SetHandlerByMap(map[string]MockResponse{
+		"MetadataRequest": NewMockMetadataResponse(t).
+			SetBroker(staleCoordinator.Addr(), staleCoordinator.BrokerID()).
+			SetBroker(freshCoordinator.Addr(), freshCoordinator.BrokerID()),
+		"FindCoordinatorRequest": NewMockSequence(
+			NewMockFindCoordinatorResponse(t).
+				SetCoordinator(CoordinatorGroup, "my_group", staleCoordinator).
+				SetCoordinator(CoordinatorTransaction, "my_txn", staleCoordinator),
+			NewMockFindCoordinatorResponse(t).
+				SetCoordinator(CoordinatorGroup, "my_group", freshCoordinator).
+				SetCoordinator(CoordinatorTransaction, "my_txn", freshCoordinator),
+		),
+	})
+
+	config := NewTestConfig()
+	config.Version = V0_11_0_0
+	client, err := NewClient([]string{seedBroker.Addr()}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer safeClose(t, client)
+
+	invalidator, ok := client.(CoordinatorInvalidator)
+	if !ok {
+		t.Fatal("expected the client to be a CoordinatorInvalidator")
+	}
+	if broker, err := client.Coordinator("my_group"); err != nil || broker.ID() != staleCoordinator.BrokerID() {
+		t.Fatal("expected the stale coordinator, got", broker, err)
+	}
+	invalidator.InvalidateCoordinator("my_group")
+	if broker, err := client.Coordinator("my_group"); err != nil || broker.ID() != freshCoordinator.BrokerID() {
+		t.Error("expected the coordinator to be looked up again, got", broker, err)
+	}
+
+	if broker, err := client.TransactionCoordinator("my_txn"); err != nil || broker.ID() != freshCoordinator.BrokerID() {
+		t.Fatal("expected the fresh transaction coordinator, got", broker, err)
+	}
+	invalidator.InvalidateTransactionCoordinator("my_txn")
+	if broker, err := client.TransactionCoordinator("my_txn"); err != nil || broker.ID() != freshCoordinator.BrokerID() {
+		t.Error("expected the transaction coordinator to be looked up again, got", broker, err)
+	}
+	lookups := 0
+	for _, rr := range seedBroker.History() {
+		if _, ok := rr.Request.(*FindCoordinatorRequest); ok {
+			lookups++
+		}
+	}
+	if lookups != 4 {
+		t.Errorf("expected 4 coordinator lookups, got %d", lookups)
+	}
+}
+
+func TestClientIgnoresStaleLeaderEpochs(t *testing.T) {
+	seedBroker := NewMockBroker(t, 1)
+	defer seedBroker.Close()
+
+	metadata := func(leader, epoch int32, topicID byte) *MetadataResponse {
+		metadataResponse := &MetadataResponse{Version: 10}
+		metadataResponse.AddBroker(seedBroker.Addr(), seedBroker.BrokerID())
+		metadataResponse.AddBroker("127.0.0.1:0", 2)
+		metadataResponse.AddBroker("127.0.0.1:0", 3)
+		metadataResponse.AddTopicPartition("my_topic", 0, leader, []int32{2, 3}, []int32{2, 3}, []int32{}, ErrNoError)
+		metadataResponse.Topics[0].Uuid[0] = topicID
+		metadataResponse.Topics[0].Partitions[0].LeaderEpoch = epoch
+		return metadataResponse
+	}
+	seedBroker.SetHandlerByMap(map[string]MockResponse{
+		"ApiVersionsRequest": NewMockApiVersionsResponse(t),
+		"MetadataRequest": NewMockSequence(
+			metadata(2, 5, 1),
+			// returned by a broker which missed the last election
+			metadata(3, 4, 1),
+			metadata(3, 6, 1),
+			// the topic was recreated, its epochs start again
+			metadata(2, 0, 2),
+		),
+	})
+
+	config := NewTestConfig()
+	config.Version = V2_8_0_0
+	config.Metadata.Retry.Max = 0
+	c, err := NewClient([]string{seedBroker.Addr()}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer safeClose(t, c)
+
+	for i, expected := range []struct{ leader, epoch int32 }{
+		{2, 5},
+		{2, 5}, // the stale metadata is ignored
+		{3, 6},
+		{2, 0},
+	} {
+		if i > 0 {
+			if err := c.RefreshMetadata("my_topic"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		partition, _ := c.(*client).cachedMetadata("my_topic", 0)
+		if partition.leader != expected.leader || partition.leaderEpoch != expected.epoch {
+			t.Errorf("update %d: expected leader %d at epoch %d, got %d at epoch %d",
+				i, expected.leader, expected.epoch, partition.leader, partition.leaderEpoch)
+		}
+	}
+}
+
+// BenchmarkClientUpdateMetadata decodes and caches the metadata of a
+// synthetic cluster of 1000 topics of 50 partitions, and reports the memory
+// retained by the metadata of the client.
+func BenchmarkClientUpdateMetadata(b *testing.B) {
+	response := &MetadataResponse{Version: 9}
+	for id := int32(0); id < 60; id++ {
+		response.AddBroker(fmt.Sprintf("broker-%d.kafka.example.com:9092", id), id)
+	}
+	for i := 0; i < 1000; i++ {
+		topic := &TopicMetadata{Version: 9, Name: fmt.Sprintf("service-%04d.events.v1", i)}
+		for id := int32(0); id < 50; id++ {
+			leader := (int32(i) + id) % 60
+			replicas := []int32{leader, (leader + 1) % 60, (leader + 2) % 60}
+			topic.Partitions = append(topic.Partitions, &PartitionMetadata{
+				Version:         9,
+				ID:              id,
+				Leader:          leader,
+				Replicas:        replicas,
+				Isr:             replicas,
+				OfflineReplicas: []int32{},
+			})
+		}
+		response.Topics = append(response.Topics, topic)
+	}
+	buf, err := encode(response, nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	for _, drop := range []bool{false, true} {
+		b.Run(fmt.Sprintf("DropReplicaDetails=%t", drop), func(b *testing.B) {
+			config := NewTestConfig()
+			config.Metadata.DropReplicaDetails = drop
+			var retained int64
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				var before, after runtime.MemStats
+				runtime.GC()
+				runtime.ReadMemStats(&before)
+
+				c := &client{
+					conf:                    config,
+					brokers:                 make(map[int32]*Broker),
+					metadata:                make(map[string]map[int32]cachedPartition),
+					metadataTopics:          make(map[string]none),
+					topicIDs:                make(map[string]Uuid),
+					topicNames:              make(map[string]string),
+					cachedPartitionsResults: make(map[string][maxPartitionIndex][]int32),
+					watchers:                make(map[string][]*topicWatch),
+				}
+				decoded := new(MetadataResponse)
+				if err := versionedDecode(buf, decoded, 9, nil); err != nil {
+					b.Fatal(err)
+				}
+				if _, err := c.updateMetadata(decoded, true); err != nil {
+					b.Fatal(err)
+				}
+
+				runtime.GC()
+				runtime.ReadMemStats(&after)
+				retained += int64(after.HeapAlloc) - int64(before.HeapAlloc)
+				runtime.KeepAlive(c)
+			}
+			b.ReportMetric(float64(retained)/float64(b.N), "retained-B/op")
+		})
+	}
+}
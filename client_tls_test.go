@@ -1,13 +1,20 @@
 package sarama
 
 import (
+	"bytes"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha256"
 	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/base64"
+	"errors"
+	"io"
 	"math/big"
 	"net"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -182,6 +189,13 @@ func TestTLS(t *testing.T) {
 }
 
 func doListenerTLSTest(t *testing.T, expectSuccess bool, serverConfig, clientConfig *tls.Config) {
+	config := NewTestConfig()
+	config.Net.TLS.Enable = true
+	config.Net.TLS.Config = clientConfig
+	doListenerTLSConfigTest(t, expectSuccess, serverConfig, config)
+}
+
+func doListenerTLSConfigTest(t *testing.T, expectSuccess bool, serverConfig *tls.Config, config *Config) {
 	seedListener, err := tls.Listen("tcp", "127.0.0.1:0", serverConfig)
 	if err != nil {
 		t.Fatal("cannot open listener", err)
@@ -199,10 +213,6 @@ func doListenerTLSTest(t *testing.T, expectSuccess bool, serverConfig, clientCon
 
 	seedBroker.Returns(new(MetadataResponse))
 
-	config := NewTestConfig()
-	config.Net.TLS.Enable = true
-	config.Net.TLS.Config = clientConfig
-
 	client, err := NewClient([]string{seedBroker.Addr()}, config)
 	if err == nil {
 		safeClose(t, client)
@@ -219,6 +229,157 @@ func doListenerTLSTest(t *testing.T, expectSuccess bool, serverConfig, clientCon
 	}
 }
 
+func TestTLSServerNameAndPinnedKeys(t *testing.T) {
+	cakey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hostkey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nvb := time.Now().Add(-1 * time.Hour)
+	nva := time.Now().Add(1 * time.Hour)
+
+	caTemplate := &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "ca"},
+		Issuer:                pkix.Name{CommonName: "ca"},
+		SerialNumber:          big.NewInt(0),
+		NotAfter:              nva,
+		NotBefore:             nvb,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDer, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &cakey.PublicKey, cakey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caFinalCert, err := x509.ParseCertificate(caDer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the certificate of the broker does not match its address
+	hostDer, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		Subject:      pkix.Name{CommonName: "host"},
+		Issuer:       pkix.Name{CommonName: "ca"},
+		DNSNames:     []string{"kafka.internal"},
+		SerialNumber: big.NewInt(0),
+		NotAfter:     nva,
+		NotBefore:    nvb,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, caFinalCert, &hostkey.PublicKey, cakey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostFinalCert, err := x509.ParseCertificate(hostDer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pin := func(cert *x509.Certificate) string {
+		sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+		return base64.StdEncoding.EncodeToString(sum[:])
+	}
+	otherPin := base64.StdEncoding.EncodeToString(make([]byte, sha256.Size))
+
+	pool := x509.NewCertPool()
+	pool.AddCert(caFinalCert)
+
+	serverTLSConfig := &tls.Config{
+		Certificates: []tls.Certificate{{
+			Certificate: [][]byte{hostDer},
+			PrivateKey:  hostkey,
+		}},
+		MinVersion: tls.VersionTLS12,
+	}
+
+	for _, tc := range []struct {
+		name               string
+		Succeed            bool
+		ServerName         func(*Broker) string
+		InsecureSkipVerify bool
+		PinnedKeys         []string
+	}{
+		{
+			name:    "Verify client fails if the address does not match the certificate",
+			Succeed: false,
+		},
+		{
+			name:       "Verify client fails if the server name returned is not that of the certificate",
+			Succeed:    false,
+			ServerName: ServerNameTemplate("{host}.kafka.internal"),
+		},
+		{
+			name:       "Verify client succeeds with the server name of the certificate",
+			Succeed:    true,
+			ServerName: func(*Broker) string { return "kafka.internal" },
+		},
+		{
+			name:       "Verify client succeeds if the key of the CA is pinned",
+			Succeed:    true,
+			ServerName: func(*Broker) string { return "kafka.internal" },
+			PinnedKeys: []string{otherPin, pin(caFinalCert)},
+		},
+		{
+			name:       "Verify client succeeds if the key of the broker is pinned",
+			Succeed:    true,
+			ServerName: func(*Broker) string { return "kafka.internal" },
+			PinnedKeys: []string{pin(hostFinalCert)},
+		},
+		{
+			name:       "Verify client fails if no key is pinned",
+			Succeed:    false,
+			ServerName: func(*Broker) string { return "kafka.internal" },
+			PinnedKeys: []string{otherPin},
+		},
+		{
+			name:               "Verify client succeeds without verification if the key of the broker is pinned",
+			Succeed:            true,
+			InsecureSkipVerify: true,
+			PinnedKeys:         []string{pin(hostFinalCert)},
+		},
+		{
+			name:               "Verify client fails without verification if only the key of the CA is pinned",
+			Succeed:            false,
+			InsecureSkipVerify: true,
+			PinnedKeys:         []string{pin(caFinalCert)},
+		},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			config := NewTestConfig()
+			config.Net.TLS.Enable = true
+			config.Net.TLS.Config = &tls.Config{
+				RootCAs:            pool,
+				InsecureSkipVerify: tc.InsecureSkipVerify, //nolint:gosec
+				MinVersion:         tls.VersionTLS12,
+			}
+			config.Net.TLS.ServerName = tc.ServerName
+			config.Net.TLS.PinnedKeys = tc.PinnedKeys
+			doListenerTLSConfigTest(t, tc.Succeed, serverTLSConfig, config)
+		})
+	}
+}
+
+func TestServerNameTemplate(t *testing.T) {
+	rack := "rack-a"
+	broker := &Broker{id: 3, addr: "10.0.0.3:9093", rack: &rack}
+	if sn := ServerNameTemplate("b{id}-{rack}.kafka:{port}")(broker); sn != "b3-rack-a.kafka:9093" {
+		t.Errorf("unexpected server name %q", sn)
+	}
+	if sn := ServerNameTemplate("{host}.nip.io")(broker); sn != "10.0.0.3.nip.io" {
+		t.Errorf("unexpected server name %q", sn)
+	}
+	if sn := ServerNameTemplate("b{id}.kafka")(NewBroker("seed:9093")); sn != "" {
+		t.Errorf("expected no server name for a seed broker, got %q", sn)
+	}
+}
+
 func TestSetServerName(t *testing.T) {
 	if validServerNameTLS("kafka-server.domain.com:9093", nil).ServerName != "kafka-server.domain.com" {
 		t.Fatal("Expected kafka-server.domain.com as tls.ServerName when tls config is nil")
@@ -237,3 +398,125 @@ func TestSetServerName(t *testing.T) {
 		t.Fatal("Expected empty ServerName as the broker addr is missing the port")
 	}
 }
+
+type syncBuffer struct {
+	lock sync.Mutex
+	buf  bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.String()
+}
+
+func TestTLSKeyLogWriterAndDebug(t *testing.T) {
+	hostkey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostTemplate := &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "host"},
+		Issuer:                pkix.Name{CommonName: "host"},
+		DNSNames:              []string{"kafka.internal"},
+		SerialNumber:          big.NewInt(0),
+		NotAfter:              time.Now().Add(time.Hour),
+		NotBefore:             time.Now().Add(-time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+	hostDer, err := x509.CreateCertificate(rand.Reader, hostTemplate, hostTemplate, &hostkey.PublicKey, hostkey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostCert, err := x509.ParseCertificate(hostDer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(hostCert)
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{hostDer}, PrivateKey: hostkey}},
+		MinVersion:   tls.VersionTLS12,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mb := NewMockBrokerListener(t, 1, listener)
+	defer mb.Close()
+
+	open := func(serverName string, debug bool, keyLog io.Writer) *Broker {
+		t.Helper()
+		conf := NewTestConfig()
+		conf.Net.TLS.Enable = true
+		conf.Net.TLS.Config = &tls.Config{RootCAs: pool, ServerName: serverName, MinVersion: tls.VersionTLS12}
+		conf.Net.TLS.Debug = debug
+		conf.Net.TLS.KeyLogWriter = keyLog
+		broker := NewBroker(mb.Addr())
+		if err := broker.Open(conf); err != nil {
+			t.Fatal(err)
+		}
+		return broker
+	}
+
+	// the secrets of the handshake performed at once are logged
+	keyLog := new(syncBuffer)
+	broker := open("kafka.internal", true, keyLog)
+	if connected, err := broker.Connected(); !connected || err != nil {
+		t.Fatal("expected the handshake to succeed, got", err)
+	}
+	if !strings.Contains(keyLog.String(), "SECRET") && !strings.Contains(keyLog.String(), "CLIENT_RANDOM") {
+		t.Errorf("expected the TLS secrets to be logged, got %q", keyLog.String())
+	}
+	safeClose(t, broker)
+
+	// a failed handshake fails the connection only in debug mode, which
+	// performs it before the first request
+	broker = open("other.internal", false, nil)
+	if connected, err := broker.Connected(); !connected || err != nil {
+		t.Error("expected the handshake to be deferred, got", err)
+	}
+	safeClose(t, broker)
+	broker = open("other.internal", true, nil)
+	connected, err := broker.Connected()
+	var hostnameErr x509.HostnameError
+	if connected || !errors.As(err, &hostnameErr) {
+		t.Error("expected the handshake to fail with a hostname error, got", err)
+	}
+	if hint := tlsHandshakeHint(err); !strings.Contains(hint, "Net.TLS.ServerName") {
+		t.Error("expected a hint about Net.TLS.ServerName, got", hint)
+	}
+	if summary := tlsChainSummary(tlsFailedChain(err, tls.ConnectionState{})); !strings.Contains(summary, `subject="CN=host"`) {
+		t.Error("expected the certificate of the broker to be summarized, got", summary)
+	}
+}
+
+func TestTLSHandshakeHint(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		hint string
+	}{
+		{tls.RecordHeaderError{Msg: "first record does not look like a TLS handshake"}, "does not use TLS"},
+		{x509.UnknownAuthorityError{}, "RootCAs"},
+		{x509.CertificateInvalidError{Reason: x509.Expired}, "expired"},
+		{errors.New("remote error: tls: handshake failure"), "rejected the offered"},
+		{errors.New("remote error: tls: protocol version not supported"), "TLS versions"},
+		{errors.New("remote error: tls: bad certificate"), "client certificate"},
+	} {
+		if hint := tlsHandshakeHint(tc.err); !strings.Contains(hint, tc.hint) {
+			t.Errorf("expected a hint containing %q for %v, got %q", tc.hint, tc.err, hint)
+		}
+	}
+	if hint := tlsHandshakeHint(errors.New("EOF")); hint != "" {
+		t.Error("expected no hint for an unknown error, got", hint)
+	}
+}
@@ -0,0 +1,441 @@
+/*
+Package cloudevents implements the Kafka protocol binding of CloudEvents 1.0
+(https://github.com/cloudevents/spec/blob/v1.0.2/cloudevents/bindings/kafka-protocol-binding.md)
+on top of Sarama's messages.
+
+Events are written to a ProducerMessage in either of the two content modes of
+the binding. In binary mode, the attributes of the event are carried by ce_*
+headers, its content type by the content-type header, and its data is the value
+of the message. In structured mode, the value of the message is the whole event
+in the JSON event format:
+
+	msg, err := cloudevents.NewProducerMessage("orders", &cloudevents.Event{
+		ID:              "1",
+		Source:          "/orders",
+		Type:            "com.example.order.created",
+		DataContentType: "application/json",
+		Data:            []byte(`{"amount":42}`),
+	}, cloudevents.Binary)
+
+FromConsumerMessage reads the event of a ConsumerMessage, whichever its mode.
+Headers require Kafka 0.11 or later, so Config.Version must be at least
+V0_11_0_0 for the binary mode.
+
+NOTE: this package currently does not fall under the API stability
+guarantee of Sarama as it is still considered experimental.
+*/
+package cloudevents
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// SpecVersion is the version of the CloudEvents specification implemented.
+const SpecVersion = "1.0"
+
+// PartitionKeyExtension is the extension mapped to the key of the messages.
+const PartitionKeyExtension = "partitionkey"
+
+const (
+	headerPrefix      = "ce_"
+	contentTypeHeader = "content-type"
+
+	structuredContentType     = "application/cloudevents+json; charset=UTF-8"
+	structuredContentTypeBase = "application/cloudevents"
+	batchedContentTypeBase    = "application/cloudevents-batch"
+	jsonContentType           = "application/json"
+	jsonContentTypeSuffix     = "+json"
+
+	specVersionAttribute     = "specversion"
+	dataContentTypeAttribute = "datacontenttype"
+	timeAttribute            = "time"
+	dataAttribute            = "data"
+	dataBase64Attribute      = "data_base64"
+
+	maxExtensionNameLength = 20
+)
+
+// Mode is the content mode of a message carrying an event.
+type Mode int
+
+const (
+	// Binary mode carries the attributes of the event in headers, and its data
+	// in the value of the message.
+	Binary Mode = iota
+	// Structured mode carries the whole event, in the JSON event format, in the
+	// value of the message.
+	Structured
+)
+
+func (m Mode) String() string {
+	switch m {
+	case Binary:
+		return "binary"
+	case Structured:
+		return "structured"
+	}
+	return fmt.Sprintf("Mode(%d)", int(m))
+}
+
+var (
+	// ErrNotCloudEvent is returned by FromConsumerMessage when a message does
+	// not carry an event.
+	ErrNotCloudEvent = errors.New("cloudevents: the message does not carry a CloudEvent")
+
+	// ErrBatchedMode is returned by FromConsumerMessage for the messages in
+	// batched mode, which the Kafka binding does not support.
+	ErrBatchedMode = errors.New("cloudevents: batched mode is not supported by the Kafka binding")
+)
+
+// Event is a CloudEvent. Its attributes, other than Time, are strings, the
+// extensions included.
+type Event struct {
+	// SpecVersion defaults to SpecVersion when writing the event.
+	SpecVersion string
+	// ID, Source and Type are required.
+	ID     string
+	Source string
+	Type   string
+
+	Subject         string
+	DataContentType string
+	DataSchema      string
+	Time            time.Time
+
+	// Extensions holds the extension attributes, whose names are lower-case
+	// letters and digits. The PartitionKeyExtension is mapped to the key of
+	// the messages.
+	Extensions map[string]string
+
+	Data []byte
+}
+
+// Validate checks that the required attributes of the event are set, and that
+// the names of its extensions are valid.
+func (e *Event) Validate() error {
+	if e.SpecVersion != "" && e.SpecVersion != SpecVersion {
+		return fmt.Errorf("cloudevents: unsupported specversion %q", e.SpecVersion)
+	}
+	switch {
+	case e.ID == "":
+		return errors.New("cloudevents: id is required")
+	case e.Source == "":
+		return errors.New("cloudevents: source is required")
+	case e.Type == "":
+		return errors.New("cloudevents: type is required")
+	}
+	for name := range e.Extensions {
+		if !validExtensionName(name) {
+			return fmt.Errorf("cloudevents: invalid extension name %q", name)
+		}
+		if isContextAttribute(name) {
+			return fmt.Errorf("cloudevents: extension %q conflicts with a context attribute", name)
+		}
+	}
+	return nil
+}
+
+// NewProducerMessage returns a message to topic carrying the event in the
+// given mode.
+func NewProducerMessage(topic string, e *Event, mode Mode) (*sarama.ProducerMessage, error) {
+	msg := &sarama.ProducerMessage{Topic: topic}
+	if err := WriteProducerMessage(msg, e, mode); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// WriteProducerMessage writes the event to msg in the given mode, setting its
+// value and, if the event has a PartitionKeyExtension, its key. The headers of
+// msg which are not part of the binding are kept.
+func WriteProducerMessage(msg *sarama.ProducerMessage, e *Event, mode Mode) error {
+	if err := e.Validate(); err != nil {
+		return err
+	}
+
+	headers := msg.Headers[:0:0]
+	for _, h := range msg.Headers {
+		if !isBindingHeader(string(h.Key)) {
+			headers = append(headers, h)
+		}
+	}
+
+	switch mode {
+	case Binary:
+		for _, attr := range e.attributes() {
+			if attr.name == dataContentTypeAttribute {
+				headers = append(headers, header(contentTypeHeader, attr.value))
+				continue
+			}
+			headers = append(headers, header(headerPrefix+attr.name, attr.value))
+		}
+		msg.Value = sarama.ByteEncoder(e.Data)
+	case Structured:
+		value, err := e.MarshalJSON()
+		if err != nil {
+			return err
+		}
+		headers = append(headers, header(contentTypeHeader, structuredContentType))
+		msg.Value = sarama.ByteEncoder(value)
+	default:
+		return fmt.Errorf("cloudevents: unknown mode %v", mode)
+	}
+
+	msg.Headers = headers
+	if key, ok := e.Extensions[PartitionKeyExtension]; ok {
+		msg.Key = sarama.StringEncoder(key)
+	}
+	return nil
+}
+
+// FromConsumerMessage returns the event carried by msg, and its mode. The key
+// of the message is mapped to the PartitionKeyExtension, unless the event
+// holds one already.
+func FromConsumerMessage(msg *sarama.ConsumerMessage) (*Event, Mode, error) {
+	contentType := ""
+	binary := false
+	for _, h := range msg.Headers {
+		key := strings.ToLower(string(h.Key))
+		switch {
+		case key == contentTypeHeader:
+			contentType = string(h.Value)
+		case key == headerPrefix+specVersionAttribute:
+			binary = true
+		}
+	}
+
+	var (
+		e    *Event
+		mode Mode
+		err  error
+	)
+	switch base := mediaType(contentType); {
+	case strings.HasPrefix(base, batchedContentTypeBase):
+		return nil, Structured, ErrBatchedMode
+	case strings.HasPrefix(base, structuredContentTypeBase):
+		if base != structuredContentTypeBase+jsonContentTypeSuffix {
+			return nil, Structured, fmt.Errorf("cloudevents: unsupported event format %q", contentType)
+		}
+		e, mode = &Event{}, Structured
+		err = e.UnmarshalJSON(msg.Value)
+	case binary:
+		e, mode = &Event{}, Binary
+		err = e.readHeaders(msg.Headers)
+		e.Data = msg.Value
+	default:
+		return nil, Binary, ErrNotCloudEvent
+	}
+	if err != nil {
+		return nil, mode, err
+	}
+	if err := e.Validate(); err != nil {
+		return nil, mode, err
+	}
+
+	if _, ok := e.Extensions[PartitionKeyExtension]; !ok && msg.Key != nil {
+		if e.Extensions == nil {
+			e.Extensions = make(map[string]string)
+		}
+		e.Extensions[PartitionKeyExtension] = string(msg.Key)
+	}
+	return e, mode, nil
+}
+
+func (e *Event) readHeaders(headers []*sarama.RecordHeader) error {
+	for _, h := range headers {
+		key := strings.ToLower(string(h.Key))
+		switch {
+		case key == contentTypeHeader:
+			e.DataContentType = string(h.Value)
+		case strings.HasPrefix(key, headerPrefix):
+			if err := e.setAttribute(strings.TrimPrefix(key, headerPrefix), string(h.Value)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+type attribute struct {
+	name, value string
+}
+
+// attributes returns the attributes of the event which are set, the
+// extensions included, as strings.
+func (e *Event) attributes() []attribute {
+	specVersion := e.SpecVersion
+	if specVersion == "" {
+		specVersion = SpecVersion
+	}
+	attrs := []attribute{
+		{specVersionAttribute, specVersion},
+		{"id", e.ID},
+		{"source", e.Source},
+		{"type", e.Type},
+	}
+	for _, attr := range []attribute{
+		{"subject", e.Subject},
+		{dataContentTypeAttribute, e.DataContentType},
+		{"dataschema", e.DataSchema},
+	} {
+		if attr.value != "" {
+			attrs = append(attrs, attr)
+		}
+	}
+	if !e.Time.IsZero() {
+		attrs = append(attrs, attribute{timeAttribute, e.Time.Format(time.RFC3339Nano)})
+	}
+	names := make([]string, 0, len(e.Extensions))
+	for name := range e.Extensions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		attrs = append(attrs, attribute{name, e.Extensions[name]})
+	}
+	return attrs
+}
+
+func (e *Event) setAttribute(name, value string) error {
+	switch name {
+	case specVersionAttribute:
+		e.SpecVersion = value
+	case "id":
+		e.ID = value
+	case "source":
+		e.Source = value
+	case "type":
+		e.Type = value
+	case "subject":
+		e.Subject = value
+	case dataContentTypeAttribute:
+		e.DataContentType = value
+	case "dataschema":
+		e.DataSchema = value
+	case timeAttribute:
+		t, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			return fmt.Errorf("cloudevents: invalid time %q: %w", value, err)
+		}
+		e.Time = t
+	default:
+		if e.Extensions == nil {
+			e.Extensions = make(map[string]string)
+		}
+		e.Extensions[name] = value
+	}
+	return nil
+}
+
+// MarshalJSON encodes the event in the JSON event format. Data is encoded as
+// JSON when the content type of the event is JSON, and in base64 otherwise.
+func (e *Event) MarshalJSON() ([]byte, error) {
+	out := make(map[string]interface{})
+	for _, attr := range e.attributes() {
+		out[attr.name] = attr.value
+	}
+	if e.Data != nil {
+		if isJSON(e.DataContentType) && json.Valid(e.Data) {
+			out[dataAttribute] = json.RawMessage(e.Data)
+		} else {
+			out[dataBase64Attribute] = e.Data
+		}
+	}
+	return json.Marshal(out)
+}
+
+// UnmarshalJSON decodes an event in the JSON event format. Data encoded as a
+// JSON string is decoded to the string itself, unless the content type of the
+// event is JSON.
+func (e *Event) UnmarshalJSON(b []byte) error {
+	var in map[string]json.RawMessage
+	if err := json.Unmarshal(b, &in); err != nil {
+		return fmt.Errorf("cloudevents: invalid structured event: %w", err)
+	}
+	*e = Event{}
+	for name, raw := range in {
+		switch name {
+		case dataAttribute, dataBase64Attribute:
+			continue
+		}
+		if string(raw) == "null" {
+			continue
+		}
+		var value string
+		if err := json.Unmarshal(raw, &value); err != nil {
+			// Extensions may be numbers or booleans, kept as their JSON text.
+			if isContextAttribute(name) {
+				return fmt.Errorf("cloudevents: invalid %s attribute: %w", name, err)
+			}
+			value = string(raw)
+		}
+		if err := e.setAttribute(name, value); err != nil {
+			return err
+		}
+	}
+
+	if raw, ok := in[dataBase64Attribute]; ok && string(raw) != "null" {
+		if err := json.Unmarshal(raw, &e.Data); err != nil {
+			return fmt.Errorf("cloudevents: invalid data_base64: %w", err)
+		}
+	} else if raw, ok := in[dataAttribute]; ok && string(raw) != "null" {
+		var s string
+		if !isJSON(e.DataContentType) && json.Unmarshal(raw, &s) == nil {
+			e.Data = []byte(s)
+		} else {
+			e.Data = []byte(raw)
+		}
+	}
+	return nil
+}
+
+func header(key, value string) sarama.RecordHeader {
+	return sarama.RecordHeader{Key: []byte(key), Value: []byte(value)}
+}
+
+func isBindingHeader(key string) bool {
+	key = strings.ToLower(key)
+	return key == contentTypeHeader || strings.HasPrefix(key, headerPrefix)
+}
+
+func isContextAttribute(name string) bool {
+	switch name {
+	case specVersionAttribute, "id", "source", "type", "subject", dataContentTypeAttribute, "dataschema", timeAttribute,
+		dataAttribute, dataBase64Attribute:
+		return true
+	}
+	return false
+}
+
+func validExtensionName(name string) bool {
+	if name == "" || len(name) > maxExtensionNameLength {
+		return false
+	}
+	for _, c := range name {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// mediaType returns the lower-case media type of a content type, without its
+// parameters.
+func mediaType(contentType string) string {
+	if i := strings.IndexByte(contentType, ';'); i >= 0 {
+		contentType = contentType[:i]
+	}
+	return strings.ToLower(strings.TrimSpace(contentType))
+}
+
+func isJSON(contentType string) bool {
+	base := mediaType(contentType)
+	return base == "" || base == jsonContentType || strings.HasSuffix(base, jsonContentTypeSuffix)
+}
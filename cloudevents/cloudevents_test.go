@@ -0,0 +1,198 @@
+package cloudevents
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// consumed returns the message a consumer would receive for msg.
+func consumed(t *testing.T, msg *sarama.ProducerMessage) *sarama.ConsumerMessage {
+	t.Helper()
+	c := &sarama.ConsumerMessage{Topic: msg.Topic}
+	var err error
+	if msg.Key != nil {
+		if c.Key, err = msg.Key.Encode(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if msg.Value != nil {
+		if c.Value, err = msg.Value.Encode(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := range msg.Headers {
+		c.Headers = append(c.Headers, &msg.Headers[i])
+	}
+	return c
+}
+
+func testEvent() *Event {
+	return &Event{
+		SpecVersion:     SpecVersion,
+		ID:              "1",
+		Source:          "/orders",
+		Type:            "com.example.order.created",
+		Subject:         "order-1",
+		DataContentType: "application/json",
+		DataSchema:      "https://example.com/order.json",
+		Time:            time.Date(2024, 5, 1, 12, 0, 0, 500, time.UTC),
+		Extensions:      map[string]string{"traceparent": "00-abc-def-01", PartitionKeyExtension: "customer-1"},
+		Data:            []byte(`{"amount":42}`),
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, mode := range []Mode{Binary, Structured} {
+		msg, err := NewProducerMessage("orders", testEvent(), mode)
+		if err != nil {
+			t.Fatal(mode, err)
+		}
+		if key, _ := msg.Key.Encode(); string(key) != "customer-1" {
+			t.Errorf("%v: expected the partition key to be the key of the message, got %q", mode, key)
+		}
+
+		e, decodedMode, err := FromConsumerMessage(consumed(t, msg))
+		if err != nil {
+			t.Fatal(mode, err)
+		}
+		if decodedMode != mode {
+			t.Errorf("expected %v mode, got %v", mode, decodedMode)
+		}
+		if !reflect.DeepEqual(e, testEvent()) {
+			t.Errorf("%v: expected %+v, got %+v", mode, testEvent(), e)
+		}
+	}
+}
+
+func TestBinaryMode(t *testing.T) {
+	msg := &sarama.ProducerMessage{
+		Topic:   "orders",
+		Headers: []sarama.RecordHeader{{Key: []byte("app"), Value: []byte("shop")}, {Key: []byte("ce_id"), Value: []byte("stale")}},
+	}
+	e := &Event{ID: "1", Source: "/orders", Type: "created", DataContentType: "text/plain", Data: []byte("hello")}
+	if err := WriteProducerMessage(msg, e, Binary); err != nil {
+		t.Fatal(err)
+	}
+
+	headers := make(map[string]string)
+	for _, h := range msg.Headers {
+		headers[string(h.Key)] = string(h.Value)
+	}
+	expected := map[string]string{
+		"app":            "shop",
+		"ce_specversion": "1.0",
+		"ce_id":          "1",
+		"ce_source":      "/orders",
+		"ce_type":        "created",
+		"content-type":   "text/plain",
+	}
+	if !reflect.DeepEqual(headers, expected) || len(msg.Headers) != len(expected) {
+		t.Errorf("expected headers %v, got %v", expected, msg.Headers)
+	}
+	if value, _ := msg.Value.Encode(); string(value) != "hello" {
+		t.Errorf("expected the data to be the value, got %q", value)
+	}
+	if msg.Key != nil {
+		t.Error("expected no key without a partition key")
+	}
+}
+
+func TestStructuredMode(t *testing.T) {
+	e := &Event{ID: "1", Source: "/orders", Type: "created", DataContentType: "application/octet-stream", Data: []byte{0, 1, 2}}
+	msg, err := NewProducerMessage("orders", e, Structured)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msg.Headers) != 1 || string(msg.Headers[0].Key) != "content-type" || string(msg.Headers[0].Value) != "application/cloudevents+json; charset=UTF-8" {
+		t.Error("expected the structured content type, got", msg.Headers)
+	}
+	value, _ := msg.Value.Encode()
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(value, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded["data_base64"] != "AAEC" || decoded["data"] != nil {
+		t.Error("expected binary data to be encoded in base64, got", string(value))
+	}
+}
+
+func TestFromConsumerMessageStructured(t *testing.T) {
+	msg := &sarama.ConsumerMessage{
+		Key: []byte("k"),
+		Headers: []*sarama.RecordHeader{
+			{Key: []byte("Content-Type"), Value: []byte("application/cloudevents+json")},
+		},
+		Value: []byte(`{"specversion":"1.0","id":"1","source":"/s","type":"t","datacontenttype":"text/plain",` +
+			`"data":"hello","count":3,"time":"2024-05-01T12:00:00Z"}`),
+	}
+	e, mode, err := FromConsumerMessage(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &Event{
+		SpecVersion:     "1.0",
+		ID:              "1",
+		Source:          "/s",
+		Type:            "t",
+		DataContentType: "text/plain",
+		Time:            time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+		Extensions:      map[string]string{"count": "3", PartitionKeyExtension: "k"},
+		Data:            []byte("hello"),
+	}
+	if mode != Structured || !reflect.DeepEqual(e, expected) {
+		t.Errorf("expected %+v, got %v %+v", expected, mode, e)
+	}
+}
+
+func TestFromConsumerMessageErrors(t *testing.T) {
+	for name, tc := range map[string]struct {
+		msg *sarama.ConsumerMessage
+		err error
+	}{
+		"plain message": {
+			msg: &sarama.ConsumerMessage{Value: []byte("hello")},
+			err: ErrNotCloudEvent,
+		},
+		"batched": {
+			msg: &sarama.ConsumerMessage{Headers: []*sarama.RecordHeader{
+				{Key: []byte("content-type"), Value: []byte("application/cloudevents-batch+json")},
+			}},
+			err: ErrBatchedMode,
+		},
+		"missing id": {
+			msg: &sarama.ConsumerMessage{Headers: []*sarama.RecordHeader{
+				{Key: []byte("ce_specversion"), Value: []byte("1.0")},
+				{Key: []byte("ce_source"), Value: []byte("/s")},
+				{Key: []byte("ce_type"), Value: []byte("t")},
+			}},
+		},
+		"unsupported spec version": {
+			msg: &sarama.ConsumerMessage{
+				Headers: []*sarama.RecordHeader{{Key: []byte("content-type"), Value: []byte("application/cloudevents+json")}},
+				Value:   []byte(`{"specversion":"0.3","id":"1","source":"/s","type":"t"}`),
+			},
+		},
+	} {
+		_, _, err := FromConsumerMessage(tc.msg)
+		if err == nil || (tc.err != nil && !errors.Is(err, tc.err)) {
+			t.Errorf("%s: expected an error matching %v, got %v", name, tc.err, err)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, name := range []string{"", "UPPER", "with-dash", "waytoolongextensionname", "id", "data"} {
+		e := &Event{ID: "1", Source: "/s", Type: "t", Extensions: map[string]string{name: "v"}}
+		if err := e.Validate(); err == nil {
+			t.Errorf("expected the extension %q to be rejected", name)
+		}
+	}
+	if _, err := NewProducerMessage("orders", &Event{ID: "1", Source: "/s", Type: "t"}, Mode(42)); err == nil {
+		t.Error("expected an unknown mode to be rejected")
+	}
+}
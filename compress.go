@@ -189,6 +189,9 @@ func compress(cc CompressionCodec, level int, data []byte) ([]byte, error) {
 	case CompressionZSTD:
 		return zstdCompress(ZstdEncoderParams{level}, nil, data)
 	default:
+		if codec := registeredCompressionCodec(cc); codec != nil {
+			return codec.enc(level, data)
+		}
 		return nil, PacketEncodingError{fmt.Sprintf("unsupported compression codec (%d)", cc)}
 	}
 }
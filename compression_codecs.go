@@ -0,0 +1,70 @@
+package sarama
+
+import (
+	"fmt"
+	"sync"
+)
+
+// CompressionEncoder compresses data at the given compression level, which is
+// CompressionLevelDefault unless set by Config.Producer.CompressionLevel.
+type CompressionEncoder func(level int, data []byte) ([]byte, error)
+
+// CompressionDecoder decompresses data.
+type CompressionDecoder func(data []byte) ([]byte, error)
+
+type compressionCodec struct {
+	name string
+	enc  CompressionEncoder
+	dec  CompressionDecoder
+}
+
+var (
+	compressionCodecsLock sync.RWMutex
+	compressionCodecs     = make(map[CompressionCodec]*compressionCodec)
+)
+
+// RegisterCompressionCodec registers a custom compression codec, for private
+// codecs or the ones not supported yet by Sarama, which can then be set as
+// Config.Producer.Compression and is decoded by the consumers. Its id must fit
+// in the 3 bits of the codec of the message attributes, and not be one of the
+// built-in codecs: it is between CompressionZSTD+1 and 7. Its name is used by
+// CompressionCodec.String and UnmarshalText.
+//
+// The brokers must accept the codec, which is usually not the case of the
+// Apache Kafka brokers. It is meant to be called once, from an init function.
+func RegisterCompressionCodec(id CompressionCodec, name string, enc CompressionEncoder, dec CompressionDecoder) error {
+	if id <= CompressionZSTD || int8(id) > compressionCodecMask {
+		return fmt.Errorf("kafka: compression codec id %d must be between %d and %d", id, CompressionZSTD+1, compressionCodecMask)
+	}
+	if name == "" || enc == nil || dec == nil {
+		return fmt.Errorf("kafka: compression codec %d requires a name, an encoder and a decoder", id)
+	}
+
+	compressionCodecsLock.Lock()
+	defer compressionCodecsLock.Unlock()
+
+	if _, ok := compressionCodecs[id]; ok {
+		return fmt.Errorf("kafka: compression codec %d is already registered", id)
+	}
+	for cc := CompressionNone; cc <= CompressionZSTD; cc++ {
+		if cc.String() == name {
+			return fmt.Errorf("kafka: compression codec name %q is already used", name)
+		}
+	}
+	for _, codec := range compressionCodecs {
+		if codec.name == name {
+			return fmt.Errorf("kafka: compression codec name %q is already used", name)
+		}
+	}
+
+	compressionCodecs[id] = &compressionCodec{name: name, enc: enc, dec: dec}
+	return nil
+}
+
+// registeredCompressionCodec returns the custom codec registered for id, if
+// any.
+func registeredCompressionCodec(id CompressionCodec) *compressionCodec {
+	compressionCodecsLock.RLock()
+	defer compressionCodecsLock.RUnlock()
+	return compressionCodecs[id]
+}
@@ -0,0 +1,97 @@
+package sarama
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// registerReverseCodec registers, for the duration of a test, a codec
+// reversing the bytes of the data.
+func registerReverseCodec(t *testing.T, id CompressionCodec) {
+	t.Helper()
+	reverse := func(data []byte) []byte {
+		res := make([]byte, len(data))
+		for i, b := range data {
+			res[len(data)-1-i] = b
+		}
+		return res
+	}
+	err := RegisterCompressionCodec(id, "reverse",
+		func(level int, data []byte) ([]byte, error) { return reverse(data), nil },
+		func(data []byte) ([]byte, error) { return reverse(data), nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		compressionCodecsLock.Lock()
+		delete(compressionCodecs, id)
+		compressionCodecsLock.Unlock()
+	})
+}
+
+func TestRegisterCompressionCodec(t *testing.T) {
+	registerReverseCodec(t, 5)
+
+	dec := func(data []byte) ([]byte, error) { return data, nil }
+	enc := func(level int, data []byte) ([]byte, error) { return data, nil }
+	for name, err := range map[string]error{
+		"built-in id":      RegisterCompressionCodec(CompressionZSTD, "custom", enc, dec),
+		"id out of range":  RegisterCompressionCodec(8, "custom", enc, dec),
+		"registered id":    RegisterCompressionCodec(5, "custom", enc, dec),
+		"built-in name":    RegisterCompressionCodec(6, "gzip", enc, dec),
+		"registered name":  RegisterCompressionCodec(6, "reverse", enc, dec),
+		"missing decoder":  RegisterCompressionCodec(6, "custom", enc, nil),
+		"missing encoder":  RegisterCompressionCodec(6, "custom", nil, dec),
+		"missing the name": RegisterCompressionCodec(6, "", enc, dec),
+	} {
+		if err == nil {
+			t.Error("expected the registration to fail with a", name)
+		}
+	}
+
+	if CompressionCodec(5).String() != "reverse" || CompressionCodec(6).String() != "CompressionCodec(6)" {
+		t.Error("unexpected codec names", CompressionCodec(5), CompressionCodec(6))
+	}
+	var cc CompressionCodec
+	if err := cc.UnmarshalText([]byte("reverse")); err != nil || cc != 5 {
+		t.Error("expected the registered codec to be parsed from its name, got", cc, err)
+	}
+
+	config := NewTestConfig()
+	config.Version = V2_1_0_0
+	config.Producer.Compression = 5
+	if err := config.Validate(); err != nil {
+		t.Error("expected the registered codec to be valid, got", err)
+	}
+	config.Producer.Compression = 6
+	var target ConfigurationError
+	if err := config.Validate(); !errors.As(err, &target) {
+		t.Error("expected an unregistered codec to be invalid, got", err)
+	}
+}
+
+func TestRegisteredCompressionCodecRecordBatch(t *testing.T) {
+	registerReverseCodec(t, 5)
+
+	batch := &RecordBatch{
+		Version: 2,
+		Codec:   5,
+		Records: []*Record{{Key: []byte("key"), Value: []byte("value")}},
+	}
+	raw, err := encode(batch, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded := new(RecordBatch)
+	if err := decode(raw, decoded, nil); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Codec != 5 || len(decoded.Records) != 1 || !bytes.Equal(decoded.Records[0].Value, []byte("value")) {
+		t.Errorf("unexpected decoded batch %+v", decoded)
+	}
+	if !bytes.Contains(batch.compressedRecords, []byte("eulav")) {
+		t.Error("expected the records to be compressed by the registered codec")
+	}
+}
@@ -1,11 +1,14 @@
 package sarama
 
 import (
+	"crypto/sha256"
 	"crypto/tls"
+	"encoding/base64"
 	"fmt"
 	"io"
 	"net"
 	"regexp"
+	"strings"
 	"time"
 
 	"github.com/klauspost/compress/gzip"
@@ -59,6 +62,13 @@ type Config struct {
 		// hostnames. Defaults to false.
 		ResolveCanonicalBootstrapServers bool
 
+		// AddressSelector, if set, returns the address to connect to for each
+		// broker advertised by the cluster, to use another listener of the
+		// brokers than the one of the bootstrap brokers, see
+		// NewTemplateAddressSelector. An empty address keeps the advertised
+		// one. The bootstrap broker addresses are used as they are.
+		AddressSelector BrokerAddressSelector
+
 		TLS struct {
 			// Whether or not to use TLS when connecting to the broker
 			// (defaults to false).
@@ -66,6 +76,32 @@ type Config struct {
 			// The TLS configuration to use for secure connections if
 			// enabled (defaults to nil).
 			Config *tls.Config
+			// ServerName, if set, returns the server name to verify the
+			// certificate of a broker against, and to send in the SNI
+			// extension, for the brokers whose certificates do not match
+			// their advertised hostnames, such as behind a service mesh or a
+			// tunnel. It overrides Config.ServerName and the hostname of the
+			// broker address, unless it returns an empty string. The seed
+			// brokers have an ID of -1. See ServerNameTemplate.
+			ServerName func(broker *Broker) string
+			// PinnedKeys, if set, are the base64 encoded SHA-256 hashes of
+			// the DER encoded subject public key infos of the certificates
+			// trusted to identify the brokers. The connections are refused
+			// unless the verified chain of the broker, or its certificate when
+			// Config.InsecureSkipVerify is set, holds one of them.
+			PinnedKeys []string
+			// KeyLogWriter, if set, receives the TLS secrets of the connections
+			// in the NSS key log format, for tools such as Wireshark to decrypt
+			// them. It overrides Config.KeyLogWriter. It compromises the
+			// security of the connections and must only be used for debugging.
+			KeyLogWriter io.Writer
+			// If enabled, the TLS handshake is performed as soon as the
+			// connection is opened, and the negotiated version, cipher suite
+			// and ALPN protocol, and the certificate chain of the broker are
+			// logged to DebugLogger, or the parameters offered and the
+			// certificates received to Logger when it fails (defaults to
+			// false).
+			Debug bool
 		}
 
 		// SASL based authentication with broker. While there are multiple SASL authentication methods
@@ -113,6 +149,20 @@ type Config struct {
 		// If negative, keep-alives are disabled.
 		KeepAlive time.Duration
 
+		// MaxIdleTime is how long a connection to a broker may stay idle, with
+		// no request in flight, before being replaced by a new one (defaults
+		// to 0, disabled). Set it below the idle timeout of the load balancers
+		// or NAT gateways between the client and the brokers which silently
+		// drop the idle flows. The connection is replaced when the next request
+		// is sent to the broker.
+		MaxIdleTime time.Duration
+		// MaxConnectionAge is how long a connection to a broker may be used
+		// before being replaced by a new one, authenticated again (defaults to
+		// 0, disabled). It forces the connections to pick up rotated
+		// certificates and credentials. The connection is replaced when the
+		// next request is sent to the broker with no other request in flight.
+		MaxConnectionAge time.Duration
+
 		// LocalAddr is the local address to use when dialing an
 		// address. The address must be of a compatible type for the
 		// network being dialed.
@@ -154,6 +204,27 @@ type Config struct {
 		// memory if you have many topics and partitions. Defaults to true.
 		Full bool
 
+		// AllowList and DenyList filter the topics whose metadata is kept when
+		// Full is set, bounding the memory used by the metadata of clusters with
+		// many topics. The topics matching none of the patterns of AllowList,
+		// unless it is empty, or any of the patterns of DenyList are dropped
+		// from the metadata listing all the topics.
+		//
+		// They only filter the metadata retained, not the metadata requested:
+		// the brokers can not filter the topics of the metadata responses,
+		// which still list all of them, and the topics used by the client
+		// anyway, as the topics produced to or refreshed with RefreshMetadata,
+		// are requested and kept whether or not the lists deny them.
+		AllowList, DenyList []*regexp.Regexp
+
+		// DropReplicaDetails drops the in-sync and offline replicas of the
+		// partitions from the metadata kept by the client, which then only
+		// knows of their leaders and replicas. It reduces the memory used by
+		// the metadata of clusters with many partitions, for the applications
+		// which never call Client.InSyncReplicas or Client.OfflineReplicas, and
+		// watch no TopicISRChanged events. Defaults to false.
+		DropReplicaDetails bool
+
 		// How long to wait for a successful metadata response.
 		// Disabled by default which means a metadata request against an unreachable
 		// cluster (all brokers are unreachable or unresponsive) can take up to
@@ -165,6 +236,43 @@ type Config struct {
 		// the broker may auto-create topics that we requested which do not already exist,
 		// if it is configured to do so (`auto.create.topics.enable` is true). Defaults to true.
 		AllowAutoTopicCreation bool
+
+		// Controller configures how Client.Controller finds the controller of
+		// the cluster. Requires Kafka 0.10 or later.
+		Controller struct {
+			Retry struct {
+				// The number of times to refresh the metadata again when it
+				// names no known controller, as during a controller election
+				// (default 3).
+				Max int
+				// How long to wait between the refreshes (default 250ms).
+				Backoff time.Duration
+			}
+			// Whether to return any broker as the controller when the controller
+			// is not one of the brokers of the metadata, as in the KRaft clusters
+			// whose controllers have no client listener. The brokers forward the
+			// requests meant for the controller to it, wrapped in envelopes
+			// (KIP-590), so the ClusterAdmin operations still reach it. Requires
+			// Kafka 2.8 or later. Defaults to false.
+			Forward bool
+		}
+	}
+
+	// RetryBudget limits the retries made against each broker by the metadata
+	// refreshes, the Producer, the Consumer and the ClusterAdmin sharing a
+	// Client, so that the retries of a failing subsystem cannot amplify the
+	// load on a struggling cluster. The retries beyond the budget fail at once,
+	// except for the Consumer, which retries forever and waits for the budget to
+	// be replenished instead, and for the idempotent Producer, whose retries
+	// keep the sequence numbers of its partitions without gaps. The retries
+	// which are not bound to a broker, as the metadata and admin retries,
+	// share a budget of their own.
+	RetryBudget struct {
+		// The number of retries allowed against each broker per Interval
+		// (default 0, unlimited).
+		Max int
+		// How often the budget of each broker is replenished (default 1s).
+		Interval time.Duration
 	}
 
 	// Producer is the namespace for configuration related to producing messages,
@@ -184,7 +292,8 @@ type Config struct {
 		// the JVM producer's `request.timeout.ms` setting.
 		Timeout time.Duration
 		// The type of compression to use on messages (defaults to no compression).
-		// Similar to `compression.codec` setting of the JVM producer.
+		// Similar to `compression.codec` setting of the JVM producer. Custom
+		// codecs can be registered with RegisterCompressionCodec.
 		Compression CompressionCodec
 		// The level of compression to use on messages. The meaning depends
 		// on the actual compression type used and defaults to default compression
@@ -194,9 +303,28 @@ type Config struct {
 		// (defaults to hashing the message key). Similar to the `partitioner.class`
 		// setting for the JVM producer.
 		Partitioner PartitionerConstructor
+		// PartitionExpansion is how the producer reacts to new partitions of
+		// the topics it produces to, which change the partition of most keys
+		// with the hash partitioners.
+		PartitionExpansion struct {
+			// When the new partitions are used (defaults to
+			// PartitionExpansionImmediate).
+			Policy PartitionExpansionPolicy
+			// How long the producer keeps using the previous partitions
+			// after noticing new ones, with PartitionExpansionDelay
+			// (defaults to 5 minutes).
+			Delay time.Duration
+		}
 		// If enabled, the producer will ensure that exactly one copy of each message is
 		// written.
 		Idempotent bool
+		// If set with Idempotent, how long the producer may stay idle before
+		// refreshing its producer ID and epoch with an InitProducerId request,
+		// so that the brokers do not expire its state (`producer.id.expiration.ms`
+		// and `transactional.id.expiration.ms`, 1 day and 7 days by default)
+		// while it sends nothing. It must be set below these expirations
+		// (defaults to 0, disabled).
+		KeepAlive time.Duration
 		// Transaction specify
 		Transaction struct {
 			// Used in transactions to identify an instance of a producer through restarts
@@ -204,6 +332,22 @@ type Config struct {
 			// Amount of time a transaction can remain unresolved (neither committed nor aborted)
 			// default is 1 min
 			Timeout time.Duration
+			// If enabled, the transitions of the transactional producer between
+			// the transaction states are sent to the TxnEvents channel, buffered
+			// by ChannelBufferSize. The events are dropped while it is full
+			// (default disabled).
+			Events bool
+			// If enabled, the transactions take part in a two-phase commit
+			// coordinated outside of Kafka (KIP-939): the PrepareTxn method of
+			// the TwoPhaseCommitter producers delivers the messages and offsets
+			// of the transaction, which CompleteTxn then commits or aborts. The
+			// transactions do not time out. Requires Version >= V4_1_0_0 and
+			// brokers allowing the two-phase commit (default disabled).
+			TwoPhaseCommit bool
+			// If enabled with TwoPhaseCommit, the producer keeps the transaction
+			// it prepared before restarting, for CompleteTxn to commit or
+			// abort it, instead of aborting it when starting (default disabled).
+			KeepPreparedTxn bool
 
 			Retry struct {
 				// The total number of times to retry sending a message (default 50).
@@ -276,6 +420,23 @@ type Config struct {
 		// OnSend() is passed to the second interceptor OnSend(), and so on in
 		// the interceptor chain.
 		Interceptors []ProducerInterceptor
+
+		// Verification stamps the messages with sequence numbers, for a
+		// SequenceChecker to detect their loss, duplication or reordering
+		// when they are consumed, such as in correctness canaries.
+		Verification struct {
+			// If enabled, the producer adds a SequenceHeader to each message
+			// when it is first sent to its partition, holding the ID of the
+			// producer and the sequence number of the message, increasing by
+			// one for each message of the partition (default disabled).
+			// Requires Version >= V0_11_0_0.
+			Enable bool
+			// ID identifies the producer in the sequence headers, so that the
+			// sequences of the producers of the same partition are checked
+			// separately. It must not contain a colon (defaults to a random
+			// ID for each producer).
+			ID string
+		}
 	}
 
 	// Consumer is the namespace for configuration related to consuming messages,
@@ -310,6 +471,10 @@ type Config struct {
 				// GroupStrategies is the priority-ordered list of client-side consumer group
 				// balancing strategies that will be offered to the coordinator. The first
 				// strategy that all group members support will be chosen by the leader.
+				// Listing both the current and the new strategy, in order of preference,
+				// lets a group be migrated from one to the other by restarting its
+				// members one at a time: the group keeps using the strategy they all
+				// support until the last member offers the new one.
 				// default: [ NewBalanceStrategyRange() ]
 				GroupStrategies []BalanceStrategy
 
@@ -379,6 +544,21 @@ type Config struct {
 			// (no limit). Similar to the JVM's `fetch.message.max.bytes`. The
 			// global `sarama.MaxResponseSize` still applies.
 			Max int32
+			// If enabled, the number of bytes fetched for each partition is tuned
+			// from the size of the previous fetches, starting from Default: it
+			// doubles, up to Max, while the fetches come back nearly full and the
+			// Messages channel keeps up, and halves while they come back mostly
+			// empty, though never below 4KiB nor Min. A record batch larger than
+			// the fetch size still doubles it, as without this option. Defaults
+			// to false, which fetches Default bytes every time.
+			Adaptive bool
+			// If enabled, the next fetch request is sent to a broker as soon as
+			// the partition consumers have decoded the previous response, while
+			// they are still delivering its messages, hiding the round trip to
+			// high-latency brokers. Up to one more response per broker is then
+			// held in memory while the previous one is delivered, so disable it
+			// in memory-constrained environments (default enabled).
+			Pipeline bool
 		}
 		// The maximum amount of time the broker will wait for Consumer.Fetch.Min
 		// bytes to become available before it returns fewer than that anyways. The
@@ -413,6 +593,13 @@ type Config struct {
 			// If enabled, any errors that occurred while consuming are returned on
 			// the Errors channel (default disabled).
 			Errors bool
+			// If enabled, the advances of the high watermark and of the last
+			// stable offset of the partitions are sent to the Watermarks channel
+			// of their PartitionConsumer and ConsumerGroupClaim, see
+			// WatermarkReporter, buffered by ChannelBufferSize, even when no
+			// records are fetched. The events are dropped while it is full
+			// (default disabled).
+			Watermarks bool
 		}
 
 		// Offsets specifies configuration for how and when to commit consumed
@@ -432,6 +619,15 @@ type Config struct {
 				// How frequently to commit updated offsets. Ineffective unless
 				// auto-commit is enabled (default 1s)
 				Interval time.Duration
+
+				// If > 0, the updated offsets are also committed as soon as the
+				// offsets of this many partitions were marked since the last
+				// commit, rather than waiting for the Interval, which then
+				// starts over. The offsets of all the partitions are still
+				// committed by a single request, so that groups consuming
+				// thousands of partitions send fewer, larger commits under load
+				// (default 0, disabled).
+				MaxPending int
 			}
 
 			// The initial offset to use if no offset was previously committed.
@@ -465,6 +661,30 @@ type Config struct {
 		// passed to the second interceptor OnConsume(), and so on in the
 		// interceptor chain.
 		Interceptors []ConsumerInterceptor
+
+		// If enabled, the fetch responses are read into pooled buffers, which
+		// the keys, values and headers of the consumed messages point into,
+		// instead of buffers left to the garbage collector. This cuts the
+		// allocations of high-throughput consumers processing their messages
+		// synchronously, but each message must be released with
+		// ReleaseConsumerMessage once processed, and none of its byte slices
+		// used after: copy them to
+		// keep them. A buffer is reused once all its messages are released,
+		// and garbage collected as usual otherwise (default false).
+		PooledBuffers bool
+
+		// CRC configures how the record batches whose CRC does not match their
+		// content, as when a log segment of a broker is corrupt, are consumed.
+		// Only the record batches of Kafka 0.11 and later are covered: a
+		// corrupt message of the legacy formats fails the whole fetch.
+		CRC struct {
+			// What to do with the corrupt batches (default CRCFailPartition).
+			Policy CRCPolicy
+			// If set, called with each corrupt batch consumed, whatever the
+			// Policy, from the goroutine of its partition consumer: it must
+			// not block.
+			OnCorruptBatch func(*CorruptBatchError)
+		}
 	}
 
 	// A user-provided string sent with every request to the brokers for logging,
@@ -494,10 +714,34 @@ type Config struct {
 	Version KafkaVersion
 	// The registry to define metrics into.
 	// Defaults to a local registry.
-	// If you want to disable metrics gathering, set "metrics.UseNilMetrics" to "true"
-	// prior to starting Sarama.
+	// If you want to disable metrics gathering, set it to NewNilRegistry(), or
+	// set "metrics.UseNilMetrics" to "true" prior to starting Sarama. Under a
+	// high throughput, NewShardedRegistry() reduces the contention on its lock.
 	// See Examples on how to use the metrics registry
 	MetricRegistry metrics.Registry
+	// Transforms is the chain of transforms applied to the payload of the
+	// messages, in order by the producer, after its interceptors, and in
+	// reverse order by the consumer, before its interceptors. See
+	// MessageTransform.
+	Transforms []MessageTransform
+
+	// AuditHook, if set, is called with the SASL authentications of the
+	// connections to the brokers, the errors of the responses denying an
+	// operation because of the ACLs of the cluster, and the operations of the
+	// ClusterAdmin changing the cluster, so that they can be recorded in an
+	// audit trail. It is called synchronously by the goroutines of the client
+	// and must not block.
+	AuditHook func(event *AuditEvent)
+
+	// Test is the namespace for the seams which let the tests of an
+	// application rehearse conditions that are hard to trigger on a real
+	// cluster. They should not be set in production.
+	Test struct {
+		// FaultInjector, if set, may force an error on the partitions of the
+		// produce, fetch and offset commit responses received by the client.
+		// See FaultSchedule for an injector failing the Nth request of a kind.
+		FaultInjector FaultInjector
+	}
 }
 
 // NewConfig returns a new configuration instance with sane defaults.
@@ -520,11 +764,16 @@ func NewConfig() *Config {
 	c.Metadata.RefreshFrequency = 10 * time.Minute
 	c.Metadata.Full = true
 	c.Metadata.AllowAutoTopicCreation = true
+	c.Metadata.Controller.Retry.Max = 3
+	c.Metadata.Controller.Retry.Backoff = 250 * time.Millisecond
+
+	c.RetryBudget.Interval = time.Second
 
 	c.Producer.MaxMessageBytes = 1000000
 	c.Producer.RequiredAcks = WaitForLocal
 	c.Producer.Timeout = 10 * time.Second
 	c.Producer.Partitioner = NewHashPartitioner
+	c.Producer.PartitionExpansion.Delay = 5 * time.Minute
 	c.Producer.Retry.Max = 3
 	c.Producer.Retry.Backoff = 100 * time.Millisecond
 	c.Producer.Return.Errors = true
@@ -536,6 +785,7 @@ func NewConfig() *Config {
 
 	c.Consumer.Fetch.Min = 1
 	c.Consumer.Fetch.Default = 1024 * 1024
+	c.Consumer.Fetch.Pipeline = true
 	c.Consumer.Retry.Backoff = 2 * time.Second
 	c.Consumer.MaxWaitTime = 500 * time.Millisecond
 	c.Consumer.MaxProcessingTime = 100 * time.Millisecond
@@ -571,6 +821,9 @@ func (c *Config) Validate() error {
 	if !c.Net.TLS.Enable && c.Net.TLS.Config != nil {
 		Logger.Println("Net.TLS is disabled but a non-nil configuration was provided.")
 	}
+	if c.Net.TLS.Enable && c.Net.TLS.KeyLogWriter != nil {
+		Logger.Println("Net.TLS.KeyLogWriter is set; the TLS secrets of the connections will be written to it.")
+	}
 	if !c.Net.SASL.Enable {
 		if c.Net.SASL.User != "" {
 			Logger.Println("Net.SASL is disabled but a non-empty username was provided.")
@@ -617,6 +870,11 @@ func (c *Config) Validate() error {
 	}
 
 	// validate Net values
+	for _, pin := range c.Net.TLS.PinnedKeys {
+		if sum, err := base64.StdEncoding.DecodeString(pin); err != nil || len(sum) != sha256.Size {
+			return ConfigurationError(fmt.Sprintf("Net.TLS.PinnedKeys must be base64 encoded SHA-256 hashes, got %q", pin))
+		}
+	}
 	switch {
 	case c.Net.MaxOpenRequests <= 0:
 		return ConfigurationError("Net.MaxOpenRequests must be > 0")
@@ -624,6 +882,10 @@ func (c *Config) Validate() error {
 		return ConfigurationError("Net.DialTimeout must be > 0")
 	case c.Net.ReadTimeout <= 0:
 		return ConfigurationError("Net.ReadTimeout must be > 0")
+	case c.Net.MaxIdleTime < 0:
+		return ConfigurationError("Net.MaxIdleTime must be >= 0")
+	case c.Net.MaxConnectionAge < 0:
+		return ConfigurationError("Net.MaxConnectionAge must be >= 0")
 	case c.Net.WriteTimeout <= 0:
 		return ConfigurationError("Net.WriteTimeout must be > 0")
 	case c.Net.SASL.Enable:
@@ -708,6 +970,16 @@ func (c *Config) Validate() error {
 		return ConfigurationError("Metadata.Retry.Backoff must be >= 0")
 	case c.Metadata.RefreshFrequency < 0:
 		return ConfigurationError("Metadata.RefreshFrequency must be >= 0")
+	case c.Metadata.Controller.Retry.Max < 0:
+		return ConfigurationError("Metadata.Controller.Retry.Max must be >= 0")
+	case c.Metadata.Controller.Retry.Backoff < 0:
+		return ConfigurationError("Metadata.Controller.Retry.Backoff must be >= 0")
+	case c.Metadata.Controller.Forward && !c.Version.IsAtLeast(V2_8_0_0):
+		return ConfigurationError("Metadata.Controller.Forward requires Version >= V2_8_0_0")
+	case c.RetryBudget.Max < 0:
+		return ConfigurationError("RetryBudget.Max must be >= 0")
+	case c.RetryBudget.Max > 0 && c.RetryBudget.Interval <= 0:
+		return ConfigurationError("RetryBudget.Interval must be > 0")
 	}
 
 	// validate the Producer values
@@ -720,6 +992,11 @@ func (c *Config) Validate() error {
 		return ConfigurationError("Producer.Timeout must be > 0")
 	case c.Producer.Partitioner == nil:
 		return ConfigurationError("Producer.Partitioner must not be nil")
+	case c.Producer.PartitionExpansion.Policy != PartitionExpansionImmediate && c.Producer.PartitionExpansion.Policy != PartitionExpansionDelay &&
+		c.Producer.PartitionExpansion.Policy != PartitionExpansionPin:
+		return ConfigurationError("Producer.PartitionExpansion.Policy must be PartitionExpansionImmediate, PartitionExpansionDelay or PartitionExpansionPin")
+	case c.Producer.PartitionExpansion.Policy == PartitionExpansionDelay && c.Producer.PartitionExpansion.Delay <= 0:
+		return ConfigurationError("Producer.PartitionExpansion.Delay must be > 0 with PartitionExpansionDelay")
 	case c.Producer.Flush.Bytes < 0:
 		return ConfigurationError("Producer.Flush.Bytes must be >= 0")
 	case c.Producer.Flush.Messages < 0:
@@ -734,6 +1011,8 @@ func (c *Config) Validate() error {
 		return ConfigurationError("Producer.Retry.Max must be >= 0")
 	case c.Producer.Retry.Backoff < 0:
 		return ConfigurationError("Producer.Retry.Backoff must be >= 0")
+	case c.Producer.KeepAlive < 0:
+		return ConfigurationError("Producer.KeepAlive must be >= 0")
 	}
 
 	if c.Producer.Compression == CompressionLZ4 && !c.Version.IsAtLeast(V0_10_0_0) {
@@ -752,6 +1031,10 @@ func (c *Config) Validate() error {
 		return ConfigurationError("zstd compression requires Version >= V2_1_0_0")
 	}
 
+	if c.Producer.Compression > CompressionZSTD && registeredCompressionCodec(c.Producer.Compression) == nil {
+		return ConfigurationError(fmt.Sprintf("compression codec %d is not registered", c.Producer.Compression))
+	}
+
 	if c.Producer.Idempotent {
 		if !c.Version.IsAtLeast(V0_11_0_0) {
 			return ConfigurationError("Idempotent producer requires Version >= V0_11_0_0")
@@ -767,10 +1050,30 @@ func (c *Config) Validate() error {
 		}
 	}
 
+	if c.Producer.Verification.Enable && !c.Version.IsAtLeast(V0_11_0_0) {
+		return ConfigurationError("Producer.Verification requires Version >= V0_11_0_0")
+	}
+	if strings.Contains(c.Producer.Verification.ID, ":") {
+		return ConfigurationError("Producer.Verification.ID must not contain a colon")
+	}
+
 	if c.Producer.Transaction.ID != "" && !c.Producer.Idempotent {
 		return ConfigurationError("Transactional producer requires Idempotent to be true")
 	}
 
+	if c.Producer.Transaction.TwoPhaseCommit {
+		if c.Producer.Transaction.ID == "" {
+			return ConfigurationError("Two-phase commit requires Producer.Transaction.ID")
+		}
+		if !c.Version.IsAtLeast(V4_1_0_0) {
+			return ConfigurationError("Two-phase commit requires Version >= V4_1_0_0")
+		}
+	}
+
+	if c.Producer.Transaction.KeepPreparedTxn && !c.Producer.Transaction.TwoPhaseCommit {
+		return ConfigurationError("Producer.Transaction.KeepPreparedTxn requires Producer.Transaction.TwoPhaseCommit")
+	}
+
 	// validate the Consumer values
 	switch {
 	case c.Consumer.Fetch.Min <= 0:
@@ -787,12 +1090,16 @@ func (c *Config) Validate() error {
 		return ConfigurationError("Consumer.Retry.Backoff must be >= 0")
 	case c.Consumer.Offsets.AutoCommit.Interval <= 0:
 		return ConfigurationError("Consumer.Offsets.AutoCommit.Interval must be > 0")
+	case c.Consumer.Offsets.AutoCommit.MaxPending < 0:
+		return ConfigurationError("Consumer.Offsets.AutoCommit.MaxPending must be >= 0")
 	case c.Consumer.Offsets.Initial != OffsetOldest && c.Consumer.Offsets.Initial != OffsetNewest:
 		return ConfigurationError("Consumer.Offsets.Initial must be OffsetOldest or OffsetNewest")
 	case c.Consumer.Offsets.Retry.Max < 0:
 		return ConfigurationError("Consumer.Offsets.Retry.Max must be >= 0")
 	case c.Consumer.IsolationLevel != ReadUncommitted && c.Consumer.IsolationLevel != ReadCommitted:
 		return ConfigurationError("Consumer.IsolationLevel must be ReadUncommitted or ReadCommitted")
+	case c.Consumer.CRC.Policy != CRCFailPartition && c.Consumer.CRC.Policy != CRCSkipBatch && c.Consumer.CRC.Policy != CRCDeliver:
+		return ConfigurationError("Consumer.CRC.Policy must be CRCFailPartition, CRCSkipBatch or CRCDeliver")
 	}
 
 	if c.Consumer.Offsets.CommitInterval != 0 {
@@ -827,10 +1134,15 @@ func (c *Config) Validate() error {
 		return ConfigurationError("Consumer.Group.Rebalance.Retry.Backoff must be >= 0")
 	}
 
+	strategyNames := make(map[string]bool, len(c.Consumer.Group.Rebalance.GroupStrategies))
 	for _, strategy := range c.Consumer.Group.Rebalance.GroupStrategies {
 		if strategy == nil {
 			return ConfigurationError("elements in Consumer.Group.Rebalance.Strategies must not be empty")
 		}
+		if strategyNames[strategy.Name()] {
+			return ConfigurationError(fmt.Sprintf("Consumer.Group.Rebalance.GroupStrategies must not hold two strategies named %s", strategy.Name()))
+		}
+		strategyNames[strategy.Name()] = true
 	}
 
 	if c.Consumer.Group.InstanceId != "" {
@@ -69,6 +69,13 @@ func TestNetConfigValidates(t *testing.T) {
 			},
 			"Net.MaxOpenRequests must be > 0",
 		},
+		{
+			"PinnedKeys",
+			func(cfg *Config) {
+				cfg.Net.TLS.PinnedKeys = []string{"c2hvcnQ="}
+			},
+			`Net.TLS.PinnedKeys must be base64 encoded SHA-256 hashes, got "c2hvcnQ="`,
+		},
 		{
 			"DialTimeout",
 			func(cfg *Config) {
@@ -83,6 +90,20 @@ func TestNetConfigValidates(t *testing.T) {
 			},
 			"Net.ReadTimeout must be > 0",
 		},
+		{
+			"MaxIdleTime",
+			func(cfg *Config) {
+				cfg.Net.MaxIdleTime = -1
+			},
+			"Net.MaxIdleTime must be >= 0",
+		},
+		{
+			"MaxConnectionAge",
+			func(cfg *Config) {
+				cfg.Net.MaxConnectionAge = -1
+			},
+			"Net.MaxConnectionAge must be >= 0",
+		},
 		{
 			"WriteTimeout",
 			func(cfg *Config) {
@@ -292,6 +313,21 @@ func TestMetadataConfigValidates(t *testing.T) {
 			},
 			"Metadata.RefreshFrequency must be >= 0",
 		},
+		{
+			"Controller.Retry.Max",
+			func(cfg *Config) {
+				cfg.Metadata.Controller.Retry.Max = -1
+			},
+			"Metadata.Controller.Retry.Max must be >= 0",
+		},
+		{
+			"Controller.Forward",
+			func(cfg *Config) {
+				cfg.Version = V2_0_0_0
+				cfg.Metadata.Controller.Forward = true
+			},
+			"Metadata.Controller.Forward requires Version >= V2_8_0_0",
+		},
 	}
 
 	for i, test := range tests {
@@ -331,6 +367,40 @@ func TestAdminConfigValidates(t *testing.T) {
 	}
 }
 
+func TestRetryBudgetConfigValidates(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  func(*Config) // resorting to using a function as a param because of internal composite structs
+		err  string
+	}{
+		{
+			"Max",
+			func(cfg *Config) {
+				cfg.RetryBudget.Max = -1
+			},
+			"RetryBudget.Max must be >= 0",
+		},
+		{
+			"Interval",
+			func(cfg *Config) {
+				cfg.RetryBudget.Max = 10
+				cfg.RetryBudget.Interval = 0
+			},
+			"RetryBudget.Interval must be > 0",
+		},
+	}
+
+	for i, test := range tests {
+		c := NewTestConfig()
+		test.cfg(c)
+		err := c.Validate()
+		var target ConfigurationError
+		if !errors.As(err, &target) || string(target) != test.err {
+			t.Errorf("[%d]:[%s] Expected %s, Got %s\n", i, test.name, test.err, err)
+		}
+	}
+}
+
 func TestProducerConfigValidates(t *testing.T) {
 	tests := []struct {
 		name string
@@ -415,6 +485,13 @@ func TestProducerConfigValidates(t *testing.T) {
 			},
 			"Producer.Retry.Backoff must be >= 0",
 		},
+		{
+			"KeepAlive",
+			func(cfg *Config) {
+				cfg.Producer.KeepAlive = -1
+			},
+			"Producer.KeepAlive must be >= 0",
+		},
 		{
 			"Idempotent Version",
 			func(cfg *Config) {
@@ -449,6 +526,48 @@ func TestProducerConfigValidates(t *testing.T) {
 			},
 			"Idempotent producer requires Net.MaxOpenRequests to be 1",
 		},
+		{
+			"TwoPhaseCommit Version",
+			func(cfg *Config) {
+				cfg.Version = V3_5_0_0
+				cfg.Producer.Idempotent = true
+				cfg.Producer.RequiredAcks = WaitForAll
+				cfg.Net.MaxOpenRequests = 1
+				cfg.Producer.Transaction.ID = "txn"
+				cfg.Producer.Transaction.TwoPhaseCommit = true
+			},
+			"Two-phase commit requires Version >= V4_1_0_0",
+		},
+		{
+			"KeepPreparedTxn without TwoPhaseCommit",
+			func(cfg *Config) {
+				cfg.Producer.Transaction.KeepPreparedTxn = true
+			},
+			"Producer.Transaction.KeepPreparedTxn requires Producer.Transaction.TwoPhaseCommit",
+		},
+		{
+			"PartitionExpansion Policy",
+			func(cfg *Config) {
+				cfg.Producer.PartitionExpansion.Policy = PartitionExpansionPolicy(42)
+			},
+			"Producer.PartitionExpansion.Policy must be PartitionExpansionImmediate, PartitionExpansionDelay or PartitionExpansionPin",
+		},
+		{
+			"PartitionExpansion Delay",
+			func(cfg *Config) {
+				cfg.Producer.PartitionExpansion.Policy = PartitionExpansionDelay
+				cfg.Producer.PartitionExpansion.Delay = 0
+			},
+			"Producer.PartitionExpansion.Delay must be > 0 with PartitionExpansionDelay",
+		},
+		{
+			"Verification Version",
+			func(cfg *Config) {
+				cfg.Version = V0_10_2_0
+				cfg.Producer.Verification.Enable = true
+			},
+			"Producer.Verification requires Version >= V0_11_0_0",
+		},
 	}
 
 	for i, test := range tests {
@@ -484,6 +603,20 @@ func TestConsumerConfigValidates(t *testing.T) {
 			},
 			"Consumer.IsolationLevel must be ReadUncommitted or ReadCommitted",
 		},
+		{
+			"Incorrect CRC policy",
+			func(cfg *Config) {
+				cfg.Consumer.CRC.Policy = CRCPolicy(42)
+			},
+			"Consumer.CRC.Policy must be CRCFailPartition, CRCSkipBatch or CRCDeliver",
+		},
+		{
+			"AutoCommit MaxPending",
+			func(cfg *Config) {
+				cfg.Consumer.Offsets.AutoCommit.MaxPending = -1
+			},
+			"Consumer.Offsets.AutoCommit.MaxPending must be >= 0",
+		},
 	}
 
 	for i, test := range tests {
@@ -577,6 +710,19 @@ func TestConsumerGroupStrategyCompatibility(t *testing.T) {
 	}
 }
 
+func TestConsumerGroupStrategiesUniqueNames(t *testing.T) {
+	config := NewTestConfig()
+	config.Consumer.Group.Rebalance.GroupStrategies = []BalanceStrategy{NewBalanceStrategySticky(), NewBalanceStrategyRange()}
+	if err := config.Validate(); err != nil {
+		t.Error("Expected passing config validation, got ", err)
+	}
+	config.Consumer.Group.Rebalance.GroupStrategies = append(config.Consumer.Group.Rebalance.GroupStrategies, NewBalanceStrategySticky())
+	var target ConfigurationError
+	if err := config.Validate(); !errors.As(err, &target) || !strings.Contains(err.Error(), "two strategies named sticky") {
+		t.Error("Expected a duplicate strategy error, got ", err)
+	}
+}
+
 // This example shows how to integrate with an existing registry as well as publishing metrics
 // on the standard output
 func ExampleConfig_metrics() {
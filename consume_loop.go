@@ -0,0 +1,120 @@
+package sarama
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ConsumeReason is the reason why ConsumerGroup.Consume returned, as classified
+// by ConsumeReasonOf.
+type ConsumeReason int
+
+const (
+	// ConsumeRebalance is the reason of a session ended by a rebalance: Consume
+	// must be called again to rejoin the group and get the new claims.
+	ConsumeRebalance ConsumeReason = iota
+	// ConsumeCanceled is the reason of a Consume whose context is done.
+	ConsumeCanceled
+	// ConsumeClosed is the reason of a Consume on a closed or drained
+	// ConsumerGroup.
+	ConsumeClosed
+	// ConsumeTopicsMissing is the reason of a Consume failing because some of
+	// its topics do not exist, or do not exist yet.
+	ConsumeTopicsMissing
+	// ConsumeRetriable is the reason of a Consume failing with a transient
+	// error, such as the coordinator of the group not being available.
+	ConsumeRetriable
+	// ConsumeFatal is the reason of a Consume failing with an error which
+	// retrying will not solve, such as an authorization failure.
+	ConsumeFatal
+)
+
+func (r ConsumeReason) String() string {
+	switch r {
+	case ConsumeRebalance:
+		return "rebalance"
+	case ConsumeCanceled:
+		return "canceled"
+	case ConsumeClosed:
+		return "closed"
+	case ConsumeTopicsMissing:
+		return "topics missing"
+	case ConsumeRetriable:
+		return "retriable"
+	case ConsumeFatal:
+		return "fatal"
+	}
+	return fmt.Sprintf("ConsumeReason(%d)", int(r))
+}
+
+// ConsumeReasonOf returns the reason why ConsumerGroup.Consume returned err
+// when called with ctx.
+func ConsumeReasonOf(ctx context.Context, err error) ConsumeReason {
+	var confErr ConfigurationError
+	switch {
+	case errors.Is(err, ErrClosedConsumerGroup):
+		return ConsumeClosed
+	case ctx.Err() != nil, errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return ConsumeCanceled
+	case err == nil:
+		return ConsumeRebalance
+	case errors.Is(err, ErrUnknownTopicOrPartition):
+		return ConsumeTopicsMissing
+	case errors.As(err, &confErr), errors.Is(err, ErrNoTopicsToConsume), errors.Is(err, ErrUnsupportedVersion),
+		errors.Is(err, ErrTopicAuthorizationFailed), errors.Is(err, ErrGroupAuthorizationFailed),
+		errors.Is(err, ErrClusterAuthorizationFailed), errors.Is(err, ErrSASLAuthenticationFailed),
+		errors.Is(err, ErrInvalidTopic), errors.Is(err, ErrInvalidGroupId), errors.Is(err, ErrFencedInstancedId):
+		return ConsumeFatal
+	}
+	return ConsumeRetriable
+}
+
+// ConsumerGroupLooper is implemented by the ConsumerGroup returned by
+// NewConsumerGroup. It is separate from ConsumerGroup so that the existing
+// implementations of ConsumerGroup keep compiling.
+type ConsumerGroupLooper interface {
+	// ConsumeLoop calls Consume in a loop, rejoining the group after each
+	// rebalance, until ctx is done or the ConsumerGroup is closed, and then
+	// returns nil. As classified by ConsumeReasonOf, the fatal errors are
+	// returned, and the other errors are handled as those of Errors() and
+	// retried after Consumer.Group.Rebalance.Retry.Backoff, doubled for each
+	// consecutive failure up to 16 times.
+	ConsumeLoop(ctx context.Context, topics []string, handler ConsumerGroupHandler) error
+}
+
+// maxConsumeLoopBackoffShift caps the backoff of ConsumeLoop to 16 times
+// Consumer.Group.Rebalance.Retry.Backoff.
+const maxConsumeLoopBackoffShift = 4
+
+// ConsumeLoop implements ConsumerGroupLooper.
+func (c *consumerGroup) ConsumeLoop(ctx context.Context, topics []string, handler ConsumerGroupHandler) error {
+	failures := 0
+	for {
+		err := c.Consume(ctx, topics, handler)
+		switch reason := ConsumeReasonOf(ctx, err); reason {
+		case ConsumeRebalance:
+			failures = 0
+			continue
+		case ConsumeCanceled, ConsumeClosed:
+			return nil
+		case ConsumeFatal:
+			return err
+		default:
+			c.handleError(fmt.Errorf("kafka: consumer group %s failed to consume (%s): %w", c.groupID, reason, err), "", -1)
+		}
+
+		backoff := c.config.Consumer.Group.Rebalance.Retry.Backoff << failures
+		if failures < maxConsumeLoopBackoffShift {
+			failures++
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-c.closed:
+			return nil
+		case <-time.After(backoff):
+		}
+	}
+}
@@ -21,6 +21,18 @@ type ConsumerMessage struct {
 	Topic      string
 	Partition  int32
 	Offset     int64
+	// Corrupt is set for the messages of a record batch whose CRC does not
+	// match, delivered with the CRCDeliver policy.
+	Corrupt bool
+
+	// buffer is the pooled fetch buffer the message points into, with
+	// Config.Consumer.PooledBuffers, until released by ReleaseConsumerMessage.
+	buffer *fetchBuffer
+}
+
+// TopicPartitionID returns the partition of the message.
+func (m *ConsumerMessage) TopicPartitionID() TopicPartitionID {
+	return TopicPartitionID{Topic: m.Topic, Partition: m.Partition}
 }
 
 // ConsumerError is what is provided to the user when an error occurs.
@@ -39,6 +51,11 @@ func (ce ConsumerError) Unwrap() error {
 	return ce.Err
 }
 
+// TopicPartitionID returns the partition of the error.
+func (ce ConsumerError) TopicPartitionID() TopicPartitionID {
+	return TopicPartitionID{Topic: ce.Topic, Partition: ce.Partition}
+}
+
 // ConsumerErrors is a type that wraps a batch of errors and implements the Error interface.
 // It can be returned from the PartitionConsumer's Close methods to avoid the need to manually drain errors
 // when stopping.
@@ -48,6 +65,42 @@ func (ce ConsumerErrors) Error() string {
 	return fmt.Sprintf("kafka: %d errors while consuming", len(ce))
 }
 
+// WatermarkReporter is implemented by the PartitionConsumer and the
+// ConsumerGroupClaim of the Consumer and ConsumerGroup of this package, and of
+// the mocks package, able to report the watermarks of their partition. It is
+// separate from PartitionConsumer and ConsumerGroupClaim so that their
+// existing implementations keep compiling.
+type WatermarkReporter interface {
+	// Watermarks returns the read channel of the advances of the high watermark
+	// and of the last stable offset of the partition when
+	// Consumer.Return.Watermarks is enabled, nil otherwise. It is closed with
+	// the Messages channel.
+	Watermarks() <-chan *WatermarkEvent
+}
+
+// WatermarkEvent is an advance of the high watermark or of the last stable
+// offset of a partition, sent to the Watermarks channel of its
+// WatermarkReporter with Consumer.Return.Watermarks whether or not the fetch
+// returned records.
+type WatermarkEvent struct {
+	Topic     string
+	Partition int32
+	// HighWaterMarkOffset is the offset of the next record produced to the
+	// partition.
+	HighWaterMarkOffset int64
+	// LastStableOffset is the offset of the first record of the oldest open
+	// transaction of the partition, or HighWaterMarkOffset if there is none.
+	// It is -1 for the brokers not returning it (before Kafka 0.11).
+	LastStableOffset int64
+	// Records is the number of records the fetch returned.
+	Records int
+	// Blocked is true when the consumer, reading committed records only, has
+	// consumed every record before LastStableOffset: it waits for the open
+	// transaction to complete rather than for new records.
+	Blocked bool
+	Time    time.Time
+}
+
 // Consumer manages PartitionConsumers which process Kafka messages from brokers. You MUST call Close()
 // on a consumer to avoid leaks, it will not be garbage-collected automatically when it passes out of
 // scope.
@@ -156,6 +209,10 @@ func (c *consumer) Partitions(topic string) ([]int32, error) {
 	return c.client.Partitions(topic)
 }
 
+func (c *consumer) ConsumeTopicPartition(tp TopicPartitionID, offset int64) (PartitionConsumer, error) {
+	return c.ConsumePartition(tp.Topic, tp.Partition, offset)
+}
+
 func (c *consumer) ConsumePartition(topic string, partition int32, offset int64) (PartitionConsumer, error) {
 	child := &partitionConsumer{
 		consumer:             c,
@@ -170,6 +227,12 @@ func (c *consumer) ConsumePartition(topic string, partition int32, offset int64)
 		trigger:              make(chan none, 1),
 		dying:                make(chan none),
 		fetchSize:            c.conf.Consumer.Fetch.Default,
+
+		watermarkOffset:           -1,
+		watermarkLastStableOffset: -1,
+	}
+	if c.conf.Consumer.Return.Watermarks {
+		child.watermarks = make(chan *WatermarkEvent, c.conf.ChannelBufferSize)
 	}
 
 	if err := child.chooseStartingOffset(offset); err != nil {
@@ -304,6 +367,16 @@ func (c *consumer) Resume(topicPartitions map[string][]int32) {
 	}
 }
 
+// PausePartitions implements TopicPartitionPauser.
+func (c *consumer) PausePartitions(partitions []TopicPartitionID) {
+	c.Pause(TopicPartitionMap(partitions))
+}
+
+// ResumePartitions implements TopicPartitionPauser.
+func (c *consumer) ResumePartitions(partitions []TopicPartitionID) {
+	c.Resume(TopicPartitionMap(partitions))
+}
+
 // PauseAll implements Consumer.
 func (c *consumer) PauseAll() {
 	c.lock.Lock()
@@ -401,6 +474,11 @@ type partitionConsumer struct {
 	errors   chan *ConsumerError
 	feeder   chan *FetchResponse
 
+	// watermarks receives the WatermarkEvents, if enabled, when the high
+	// watermark or the last stable offset advance past the last ones sent
+	watermarks                                 chan *WatermarkEvent
+	watermarkOffset, watermarkLastStableOffset int64
+
 	leaderEpoch          int32
 	preferredReadReplica int32
 
@@ -442,10 +520,20 @@ func (child *partitionConsumer) computeBackoff() time.Duration {
 
 func (child *partitionConsumer) dispatcher() {
 	for range child.trigger {
+		backoff := child.computeBackoff()
+		brokerID := anyBrokerID
+		if child.broker != nil {
+			brokerID = child.broker.broker.ID()
+		}
+		if budget := retryBudgetOf(child.consumer.client); !budget.allow(brokerID) && backoff < budget.replenishment() {
+			// wait for the retry budget to be replenished
+			backoff = budget.replenishment()
+		}
+
 		select {
 		case <-child.dying:
 			close(child.trigger)
-		case <-time.After(child.computeBackoff()):
+		case <-time.After(backoff):
 			if child.broker != nil {
 				child.consumer.unrefBrokerConsumer(child.broker)
 				child.broker = nil
@@ -537,6 +625,10 @@ func (child *partitionConsumer) Errors() <-chan *ConsumerError {
 	return child.errors
 }
 
+func (child *partitionConsumer) Watermarks() <-chan *WatermarkEvent {
+	return child.watermarks
+}
+
 func (child *partitionConsumer) AsyncClose() {
 	// this triggers whatever broker owns this child to abandon it and close its trigger channel, which causes
 	// the dispatcher to exit its loop, which removes it from the consumer then closes its 'messages' and
@@ -573,16 +665,26 @@ func (child *partitionConsumer) responseFeeder() {
 feederLoop:
 	for response := range child.feeder {
 		msgs, child.responseResult = child.parseResponse(response)
+		// the messages are referenced here rather than while parsing, so
+		// that the ones returned with an error are referenced too
+		for _, msg := range msgs {
+			msg.buffer = response.buffer.retain()
+		}
+		msgs = child.transform(msgs)
+		child.sendWatermarks(response, len(msgs))
 
 		if child.responseResult == nil {
 			atomic.StoreInt32(&child.retries, 0)
 		}
+		// the broker consumer may fetch the following offsets from now on
+		child.broker.parsed.Done()
 
 		for i, msg := range msgs {
 			child.interceptors(msg)
 		messageSelect:
 			select {
 			case <-child.dying:
+				releaseConsumerMessages(msgs[i:])
 				child.broker.acks.Done()
 				continue feederLoop
 			case child.messages <- msg:
@@ -592,11 +694,12 @@ feederLoop:
 					child.responseResult = errTimedOut
 					child.broker.acks.Done()
 				remainingLoop:
-					for _, msg = range msgs[i:] {
+					for j, msg := range msgs[i:] {
 						child.interceptors(msg)
 						select {
 						case child.messages <- msg:
 						case <-child.dying:
+							releaseConsumerMessages(msgs[i+j:])
 							break remainingLoop
 						}
 					}
@@ -617,6 +720,47 @@ feederLoop:
 	expiryTicker.Stop()
 	close(child.messages)
 	close(child.errors)
+	if child.watermarks != nil {
+		close(child.watermarks)
+	}
+}
+
+// sendWatermarks sends a WatermarkEvent, if enabled, when the high watermark
+// or the last stable offset of response advanced. The event is dropped if the
+// Watermarks channel is full.
+func (child *partitionConsumer) sendWatermarks(response *FetchResponse, records int) {
+	if child.watermarks == nil {
+		return
+	}
+	block := response.GetBlock(child.topic, child.partition)
+	if block == nil || !errors.Is(block.Err, ErrNoError) {
+		return
+	}
+	lastStableOffset := int64(-1)
+	if response.Version >= 4 {
+		lastStableOffset = block.LastStableOffset
+	}
+	if block.HighWaterMarkOffset <= child.watermarkOffset && lastStableOffset <= child.watermarkLastStableOffset {
+		return
+	}
+	child.watermarkOffset = block.HighWaterMarkOffset
+	child.watermarkLastStableOffset = lastStableOffset
+
+	event := &WatermarkEvent{
+		Topic:               child.topic,
+		Partition:           child.partition,
+		HighWaterMarkOffset: block.HighWaterMarkOffset,
+		LastStableOffset:    lastStableOffset,
+		Records:             records,
+		Blocked: child.conf.Consumer.IsolationLevel == ReadCommitted && lastStableOffset >= 0 &&
+			lastStableOffset < block.HighWaterMarkOffset && child.offset >= lastStableOffset,
+		Time: time.Now(),
+	}
+	select {
+	case child.watermarks <- event:
+	default:
+		Logger.Printf("consumer/%s/%d dropped a watermark event, the Watermarks channel is full\n", child.topic, child.partition)
+	}
 }
 
 func (child *partitionConsumer) parseMessages(msgSet *MessageSet) ([]*ConsumerMessage, error) {
@@ -718,7 +862,7 @@ func (child *partitionConsumer) parseResponse(response *FetchResponse) ([]*Consu
 		child.preferredReadReplica = block.PreferredReadReplica
 	}
 
-	if nRecs == 0 {
+	if nRecs == 0 && block.crcErr() == nil {
 		partialTrailingMessage, err := block.isPartial()
 		if err != nil {
 			return nil, err
@@ -749,8 +893,12 @@ func (child *partitionConsumer) parseResponse(response *FetchResponse) ([]*Consu
 		return nil, nil
 	}
 
-	// we got messages, reset our fetch size in case it was increased for a previous request
-	child.fetchSize = child.conf.Consumer.Fetch.Default
+	if child.conf.Consumer.Fetch.Adaptive {
+		child.adaptFetchSize(block.recordsSize)
+	} else {
+		// we got messages, reset our fetch size in case it was increased for a previous request
+		child.fetchSize = child.conf.Consumer.Fetch.Default
+	}
 	atomic.StoreInt64(&child.highWaterMarkOffset, block.HighWaterMarkOffset)
 
 	// abortedProducerIDs contains producerID which message should be ignored as uncommitted
@@ -770,6 +918,17 @@ func (child *partitionConsumer) parseResponse(response *FetchResponse) ([]*Consu
 
 			messages = append(messages, messageSetMessages...)
 		case defaultRecords:
+			if records.RecordBatch.crcErr != nil {
+				corrupt := child.corruptBatch(records.RecordBatch)
+				if child.conf.Consumer.CRC.Policy == CRCFailPartition {
+					return messages, corrupt
+				}
+				if child.conf.Consumer.CRC.Policy == CRCSkipBatch || records.RecordBatch.Records == nil {
+					child.skipBatch(records.RecordBatch)
+					continue
+				}
+			}
+
 			// Consume remaining abortedTransaction up to last offset of current batch
 			for _, txn := range abortedTransactions {
 				if txn.FirstOffset > records.RecordBatch.LastOffset() {
@@ -784,6 +943,11 @@ func (child *partitionConsumer) parseResponse(response *FetchResponse) ([]*Consu
 			if err != nil {
 				return nil, err
 			}
+			if records.RecordBatch.crcErr != nil {
+				for _, msg := range recordBatchMessages {
+					msg.Corrupt = true
+				}
+			}
 
 			// Parse and commit offset but do not expose messages that are:
 			// - control records
@@ -829,6 +993,103 @@ func (child *partitionConsumer) parseResponse(response *FetchResponse) ([]*Consu
 	return messages, nil
 }
 
+// minAdaptiveFetchSize is the smallest fetch size Consumer.Fetch.Adaptive
+// shrinks a partition to.
+const minAdaptiveFetchSize = 4 << 10
+
+// adaptFetchSize tunes the fetch size of the partition after a fetch which
+// returned size bytes of record data, when Consumer.Fetch.Adaptive is enabled.
+func (child *partitionConsumer) adaptFetchSize(size int32) {
+	fetchSize := child.fetchSize
+	switch {
+	case size >= fetchSize-fetchSize/4:
+		// more data was likely waiting, but there is no point fetching it
+		// sooner if the user is not keeping up with the messages already fetched
+		if len(child.messages) > cap(child.messages)/2 {
+			return
+		}
+		fetchSize *= 2
+		// check int32 overflow
+		if fetchSize < 0 {
+			fetchSize = math.MaxInt32
+		}
+	case size < fetchSize/4:
+		fetchSize /= 2
+		floor := int32(minAdaptiveFetchSize)
+		if child.conf.Consumer.Fetch.Min > floor {
+			floor = child.conf.Consumer.Fetch.Min
+		}
+		if floor > child.fetchSize {
+			// only ever shrink
+			floor = child.fetchSize
+		}
+		if fetchSize < floor {
+			fetchSize = floor
+		}
+	default:
+		return
+	}
+	if child.conf.Consumer.Fetch.Max > 0 && fetchSize > child.conf.Consumer.Fetch.Max {
+		fetchSize = child.conf.Consumer.Fetch.Max
+	}
+	child.fetchSize = fetchSize
+}
+
+// corruptBatch reports a record batch whose CRC does not match.
+func (child *partitionConsumer) corruptBatch(batch *RecordBatch) *CorruptBatchError {
+	corrupt := &CorruptBatchError{
+		Topic:       child.topic,
+		Partition:   child.partition,
+		Broker:      -1,
+		FirstOffset: batch.FirstOffset,
+		LastOffset:  batch.LastOffset(),
+		Err:         batch.crcErr,
+	}
+	// the partition consumer may be between brokers
+	if bc := child.broker; bc != nil {
+		corrupt.Broker = bc.broker.ID()
+	}
+	Logger.Printf("consumer/%s/%d found a corrupt record batch at offsets %d-%d from broker %d, applying policy %s\n",
+		child.topic, child.partition, corrupt.FirstOffset, corrupt.LastOffset, corrupt.Broker, child.conf.Consumer.CRC.Policy)
+	if child.consumer != nil && child.consumer.metricRegistry != nil {
+		metrics.GetOrRegisterMeter("consumer-corrupt-batch-rate", child.consumer.metricRegistry).Mark(1)
+	}
+	if child.conf.Consumer.CRC.OnCorruptBatch != nil {
+		child.conf.Consumer.CRC.OnCorruptBatch(corrupt)
+	}
+	return corrupt
+}
+
+// skipBatch resumes the partition from the offset following a batch.
+func (child *partitionConsumer) skipBatch(batch *RecordBatch) {
+	next := batch.LastOffset() + 1
+	if next <= batch.FirstOffset {
+		// the offset delta is corrupt
+		next = batch.FirstOffset + 1
+	}
+	if next > child.offset {
+		child.offset = next
+	}
+}
+
+// transform applies Config.Transforms to the messages, dropping the ones
+// failing to be transformed.
+func (child *partitionConsumer) transform(msgs []*ConsumerMessage) []*ConsumerMessage {
+	if len(child.conf.Transforms) == 0 {
+		return msgs
+	}
+	transformed := msgs[:0]
+	for _, msg := range msgs {
+		if err := msg.applyTransforms(child.conf.Transforms); err != nil {
+			child.sendError(err)
+			ReleaseConsumerMessage(msg)
+			continue
+		}
+		transformed = append(transformed, msg)
+	}
+	return transformed
+}
+
 func (child *partitionConsumer) interceptors(msg *ConsumerMessage) {
 	for _, interceptor := range child.conf.Consumer.Interceptors {
 		msg.safelyApplyInterceptor(interceptor)
@@ -857,9 +1118,17 @@ type brokerConsumer struct {
 	newSubscriptions chan []*partitionConsumer
 	subscriptions    map[*partitionConsumer]none
 	acks             sync.WaitGroup
+	parsed           sync.WaitGroup
 	refs             int
 }
 
+// fetchResult is the outcome of a fetch request sent ahead by a brokerConsumer
+// with Consumer.Fetch.Pipeline enabled.
+type fetchResult struct {
+	response *FetchResponse
+	err      error
+}
+
 func (c *consumer) newBrokerConsumer(broker *Broker) *brokerConsumer {
 	bc := &brokerConsumer{
 		consumer:         c,
@@ -922,17 +1191,27 @@ func (bc *brokerConsumer) subscriptionManager() {
 // subscriptionConsumer ensures we will get nil right away if no new subscriptions is available
 // this is the main loop that fetches Kafka messages
 func (bc *brokerConsumer) subscriptionConsumer() {
+	// the fetch sent ahead while the previous response was delivered, if any
+	var pending <-chan fetchResult
+
 	for newSubscriptions := range bc.newSubscriptions {
 		bc.updateSubscriptions(newSubscriptions)
 
-		if len(bc.subscriptions) == 0 {
-			// We're about to be shut down or we're about to receive more subscriptions.
-			// Take a small nap to avoid burning the CPU.
-			time.Sleep(partitionConsumersBatchTimeout)
-			continue
+		var response *FetchResponse
+		var err error
+		if pending != nil {
+			result := <-pending
+			pending = nil
+			response, err = result.response, result.err
+		} else {
+			if len(bc.subscriptions) == 0 {
+				// We're about to be shut down or we're about to receive more subscriptions.
+				// Take a small nap to avoid burning the CPU.
+				time.Sleep(partitionConsumersBatchTimeout)
+				continue
+			}
+			response, err = bc.fetchNewMessages()
 		}
-
-		response, err := bc.fetchNewMessages()
 		if err != nil {
 			Logger.Printf("consumer/broker/%d disconnecting due to error processing FetchRequest: %s\n", bc.broker.ID(), err)
 			bc.abort(err)
@@ -947,24 +1226,55 @@ func (bc *brokerConsumer) subscriptionConsumer() {
 		}
 
 		bc.acks.Add(len(bc.subscriptions))
+		bc.parsed.Add(len(bc.subscriptions))
 		for child := range bc.subscriptions {
 			if _, ok := response.Blocks[child.topic]; !ok {
 				bc.acks.Done()
+				bc.parsed.Done()
 				continue
 			}
 
 			if _, ok := response.Blocks[child.topic][child.partition]; !ok {
 				bc.acks.Done()
+				bc.parsed.Done()
 				continue
 			}
 
 			child.feeder <- response
 		}
+		if bc.consumer.conf.Consumer.Fetch.Pipeline {
+			// the offsets to fetch from are known once the response is parsed
+			bc.parsed.Wait()
+			pending = bc.fetchAhead()
+		}
 		bc.acks.Wait()
 		bc.handleResponses()
+		// the messages of the response hold their own references
+		response.buffer.release()
+	}
+
+	if pending != nil {
+		if result := <-pending; result.response != nil {
+			result.response.buffer.release()
+		}
 	}
 }
 
+// fetchAhead sends the next fetch request of the subscriptions in the
+// background, returning nil if there is nothing to fetch.
+func (bc *brokerConsumer) fetchAhead() <-chan fetchResult {
+	request := bc.newFetchRequest()
+	if request == nil {
+		return nil
+	}
+	result := make(chan fetchResult, 1)
+	go withRecover(func() {
+		response, err := bc.broker.Fetch(request)
+		result <- fetchResult{response, err}
+	})
+	return result
+}
+
 func (bc *brokerConsumer) updateSubscriptions(newSubscriptions []*partitionConsumer) {
 	for _, child := range newSubscriptions {
 		bc.subscriptions[child] = none{}
@@ -1010,7 +1320,7 @@ func (bc *brokerConsumer) handleResponses() {
 			Logger.Printf("consumer/broker/%d abandoned subscription to %s/%d because consuming was taking too long\n",
 				bc.broker.ID(), child.topic, child.partition)
 			delete(bc.subscriptions, child)
-		} else if errors.Is(result, ErrOffsetOutOfRange) {
+		} else if errors.Is(result, ErrOffsetOutOfRange) || errors.As(result, new(*CorruptBatchError)) {
 			// there's no point in retrying this it will just fail the same way again
 			// shut it down and force the user to choose what to do
 			child.sendError(result)
@@ -1064,9 +1374,22 @@ func (bc *brokerConsumer) abort(err error) {
 // fetchResponse can be nil if no fetch is made, it can occur when
 // all partitions are paused
 func (bc *brokerConsumer) fetchNewMessages() (*FetchResponse, error) {
+	request := bc.newFetchRequest()
+	if request == nil {
+		return nil, nil
+	}
+	return bc.broker.Fetch(request)
+}
+
+// newFetchRequest returns the fetch request of the subscriptions which are not
+// paused, or nil if there are none.
+func (bc *brokerConsumer) newFetchRequest() *FetchRequest {
 	request := &FetchRequest{
 		MinBytes:    bc.consumer.conf.Consumer.Fetch.Min,
 		MaxWaitTime: int32(bc.consumer.conf.Consumer.MaxWaitTime / time.Millisecond),
+		pooled:      bc.consumer.conf.Consumer.PooledBuffers,
+		// the corrupt batches are handled by Consumer.CRC.Policy
+		keepCorruptBatches: true,
 	}
 	// Version 1 is the same as version 0.
 	if bc.consumer.conf.Version.IsAtLeast(V0_9_0_0) {
@@ -1129,8 +1452,7 @@ func (bc *brokerConsumer) fetchNewMessages() (*FetchResponse, error) {
 
 	// avoid to fetch when there is no block
 	if len(request.blocks) == 0 {
-		return nil, nil
+		return nil
 	}
-
-	return bc.broker.Fetch(request)
+	return request
 }
@@ -76,6 +76,37 @@ type ConsumerGroup interface {
 	ResumeAll()
 }
 
+// ConsumerGroupDrainer is implemented by the ConsumerGroup returned by
+// NewConsumerGroup. It is separate from ConsumerGroup so that the existing
+// implementations of ConsumerGroup keep compiling.
+type ConsumerGroupDrainer interface {
+	// Drain stops the ConsumerGroup gracefully, such as during a deploy. It
+	// ends the running session as a rebalance would: the claims stop fetching
+	// new messages, and once all the ConsumeClaim() functions have returned
+	// and the handler's Cleanup() hook was called, the marked offsets are
+	// committed, even when Consumer.Offsets.AutoCommit is disabled. The group
+	// is then left and the ConsumerGroup closed, and Consume returns
+	// ErrClosedConsumerGroup from then on. If ctx is done first, the
+	// ConsumerGroup is closed right away and ctx.Err() is returned.
+	Drain(ctx context.Context) error
+}
+
+// ConsumerGroupAssigner is implemented by the ConsumerGroup returned by
+// NewConsumerGroup. It is separate from ConsumerGroup so that the existing
+// implementations of ConsumerGroup keep compiling.
+type ConsumerGroupAssigner interface {
+	// Assign runs a session of handler claiming partitions, which are assigned
+	// to this consumer outside of Kafka, without joining the group: there are
+	// no heartbeats and no rebalances, and the offsets of the group are
+	// fetched and committed as those of a simple consumer, with the generation
+	// GroupGenerationUndefined and no member ID. The group must not have
+	// members consuming with Consume meanwhile, as the coordinator rejects the
+	// commits of non-members in their generation.
+	// It returns once ctx is done, the ConsumerGroup is closed, or the first
+	// ConsumeClaim returns, like Consume.
+	Assign(ctx context.Context, partitions []TopicPartitionID, handler ConsumerGroupHandler) error
+}
+
 type consumerGroup struct {
 	client Client
 
@@ -90,6 +121,8 @@ type consumerGroup struct {
 	errorsLock sync.RWMutex
 	closed     chan none
 	closeOnce  sync.Once
+	draining   chan none
+	drainOnce  sync.Once
 
 	userData []byte
 
@@ -138,6 +171,7 @@ func newConsumerGroup(groupID string, client Client) (ConsumerGroup, error) {
 		groupID:        groupID,
 		errors:         make(chan error, config.ChannelBufferSize),
 		closed:         make(chan none),
+		draining:       make(chan none),
 		userData:       config.Consumer.Group.Member.UserData,
 		metricRegistry: newCleanupRegistry(config.MetricRegistry),
 	}
@@ -180,21 +214,50 @@ func (c *consumerGroup) Close() (err error) {
 	return
 }
 
+// Drain implements ConsumerGroupDrainer.
+func (c *consumerGroup) Drain(ctx context.Context) error {
+	c.drainOnce.Do(func() { close(c.draining) })
+
+	// Consume holds the lock until its session is released
+	released := make(chan none)
+	go func() {
+		c.lock.Lock()
+		defer c.lock.Unlock()
+		close(released)
+	}()
+
+	select {
+	case <-released:
+		return c.Close()
+	case <-ctx.Done():
+		_ = c.Close()
+		return ctx.Err()
+	}
+}
+
 // Consume implements ConsumerGroup.
 func (c *consumerGroup) Consume(ctx context.Context, topics []string, handler ConsumerGroupHandler) error {
 	// Ensure group is not closed
 	select {
 	case <-c.closed:
 		return ErrClosedConsumerGroup
+	case <-c.draining:
+		return ErrClosedConsumerGroup
 	default:
 	}
 
 	c.lock.Lock()
 	defer c.lock.Unlock()
 
+	select {
+	case <-c.draining:
+		return ErrClosedConsumerGroup
+	default:
+	}
+
 	// Quick exit when no topics are provided
 	if len(topics) == 0 {
-		return fmt.Errorf("no topics provided")
+		return ErrNoTopicsToConsume
 	}
 
 	// Refresh metadata for requested topics
@@ -210,13 +273,63 @@ func (c *consumerGroup) Consume(ctx context.Context, topics []string, handler Co
 		return err
 	}
 
-	// Wait for session exit signal
-	<-sess.ctx.Done()
+	// Wait for session exit signal, or for the group to be drained
+	select {
+	case <-sess.ctx.Done():
+	case <-c.draining:
+	}
 
 	// Gracefully release session claims
 	return sess.release(true)
 }
 
+// Assign implements ConsumerGroupAssigner.
+func (c *consumerGroup) Assign(ctx context.Context, partitions []TopicPartitionID, handler ConsumerGroupHandler) error {
+	// Ensure group is not closed
+	select {
+	case <-c.closed:
+		return ErrClosedConsumerGroup
+	case <-c.draining:
+		return ErrClosedConsumerGroup
+	default:
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	select {
+	case <-c.draining:
+		return ErrClosedConsumerGroup
+	default:
+	}
+
+	claims := TopicPartitionMap(partitions)
+	if len(claims) == 0 {
+		return ErrNoTopicsToConsume
+	}
+	topics := make([]string, 0, len(claims))
+	for topic := range claims {
+		topics = append(topics, topic)
+	}
+	if err := c.client.RefreshMetadata(topics...); err != nil {
+		return err
+	}
+
+	sess, err := newConsumerGroupSession(ctx, c, claims, "", GroupGenerationUndefined, handler)
+	if errors.Is(err, ErrClosedClient) {
+		return ErrClosedConsumerGroup
+	} else if err != nil {
+		return err
+	}
+
+	select {
+	case <-sess.ctx.Done():
+	case <-c.draining:
+	}
+
+	return sess.release(true)
+}
+
 // Pause implements ConsumerGroup.
 func (c *consumerGroup) Pause(partitions map[string][]int32) {
 	c.consumer.Pause(partitions)
@@ -227,6 +340,16 @@ func (c *consumerGroup) Resume(partitions map[string][]int32) {
 	c.consumer.Resume(partitions)
 }
 
+// PausePartitions implements TopicPartitionPauser.
+func (c *consumerGroup) PausePartitions(partitions []TopicPartitionID) {
+	c.consumer.Pause(TopicPartitionMap(partitions))
+}
+
+// ResumePartitions implements TopicPartitionPauser.
+func (c *consumerGroup) ResumePartitions(partitions []TopicPartitionID) {
+	c.consumer.Resume(TopicPartitionMap(partitions))
+}
+
 // PauseAll implements ConsumerGroup.
 func (c *consumerGroup) PauseAll() {
 	c.consumer.PauseAll()
@@ -289,6 +412,7 @@ func (c *consumerGroup) newSession(ctx context.Context, topics []string, handler
 	}
 	if err != nil {
 		_ = coordinator.Close()
+		invalidateCoordinator(c.client, c.groupID)
 		if consumerGroupJoinFailed != nil {
 			consumerGroupJoinFailed.Inc(1)
 		}
@@ -306,7 +430,7 @@ func (c *consumerGroup) newSession(ctx context.Context, topics []string, handler
 		// reset member ID and retry immediately
 		c.memberID = ""
 		return c.newSession(ctx, topics, handler, retries)
-	case ErrNotCoordinatorForConsumer, ErrRebalanceInProgress, ErrOffsetsLoadInProgress:
+	case ErrNotCoordinatorForConsumer, ErrConsumerCoordinatorNotAvailable, ErrRebalanceInProgress, ErrOffsetsLoadInProgress:
 		// retry after backoff
 		if retries <= 0 {
 			return nil, join.Err
@@ -328,15 +452,20 @@ func (c *consumerGroup) newSession(ctx context.Context, topics []string, handler
 		return nil, join.Err
 	}
 
-	var strategy BalanceStrategy
-	var ok bool
-	if strategy = c.config.Consumer.Group.Rebalance.Strategy; strategy == nil {
-		strategy, ok = c.findStrategy(join.GroupProtocol, c.config.Consumer.Group.Rebalance.GroupStrategies)
-		if !ok {
-			// this case shouldn't happen in practice, since the leader will choose the protocol
-			// that all the members support
-			return nil, fmt.Errorf("unable to find selected strategy: %s", join.GroupProtocol)
-		}
+	strategies := c.config.Consumer.Group.Rebalance.GroupStrategies
+	if c.config.Consumer.Group.Rebalance.Strategy != nil {
+		strategies = []BalanceStrategy{c.config.Consumer.Group.Rebalance.Strategy}
+	}
+	strategy, ok := c.findStrategy(join.GroupProtocol, strategies)
+	if !ok {
+		// this case shouldn't happen in practice, since the coordinator chooses a
+		// protocol that all the members offered
+		return nil, fmt.Errorf("%w: unable to find selected strategy: %s", ErrInconsistentGroupProtocol, join.GroupProtocol)
+	}
+	if strategy != strategies[0] {
+		// as expected while the members are migrated to a new strategy, one at a time
+		Logger.Printf("consumergroup/%s joined with strategy %s instead of %s, which some members do not support\n",
+			c.groupID, strategy.Name(), strategies[0].Name())
 	}
 
 	// Prepare distribution plan if we joined as the leader
@@ -363,6 +492,7 @@ func (c *consumerGroup) newSession(ctx context.Context, topics []string, handler
 	}
 	if err != nil {
 		_ = coordinator.Close()
+		invalidateCoordinator(c.client, c.groupID)
 		if consumerGroupSyncFailed != nil {
 			consumerGroupSyncFailed.Inc(1)
 		}
@@ -380,7 +510,7 @@ func (c *consumerGroup) newSession(ctx context.Context, topics []string, handler
 		// reset member ID and retry immediately
 		c.memberID = ""
 		return c.newSession(ctx, topics, handler, retries)
-	case ErrNotCoordinatorForConsumer, ErrRebalanceInProgress, ErrOffsetsLoadInProgress:
+	case ErrNotCoordinatorForConsumer, ErrConsumerCoordinatorNotAvailable, ErrRebalanceInProgress, ErrOffsetsLoadInProgress:
 		// retry after backoff
 		if retries <= 0 {
 			return nil, syncGroupResponse.Err
@@ -823,8 +953,13 @@ func newConsumerGroupSession(ctx context.Context, parent *consumerGroup, claims
 		hbDead:       make(chan none),
 	}
 
-	// start heartbeat loop
-	go sess.heartbeatLoop()
+	// start heartbeat loop, unless the partitions are assigned outside of
+	// the group
+	if generationID == GroupGenerationUndefined {
+		close(sess.hbDead)
+	} else {
+		go sess.heartbeatLoop()
+	}
 
 	// create a POM for each claim
 	for topic, partitions := range claims {
@@ -874,6 +1009,10 @@ func (s *consumerGroupSession) Claims() map[string][]int32 { return s.claims }
 func (s *consumerGroupSession) MemberID() string           { return s.memberID }
 func (s *consumerGroupSession) GenerationID() int32        { return s.generationID }
 
+func (s *consumerGroupSession) ClaimedPartitions() []TopicPartitionID {
+	return TopicPartitionIDs(s.claims)
+}
+
 func (s *consumerGroupSession) MarkOffset(topic string, partition int32, offset int64, metadata string) {
 	if pom := s.offsets.findPOM(topic, partition); pom != nil {
 		pom.MarkOffset(offset, metadata)
@@ -965,6 +1104,15 @@ func (s *consumerGroupSession) release(withCleanup bool) (err error) {
 			}
 		}
 
+		// a drained group commits the marked offsets even without auto-commit
+		select {
+		case <-s.parent.draining:
+			if !s.parent.config.Consumer.Offsets.AutoCommit.Enable {
+				s.offsets.Commit()
+			}
+		default:
+		}
+
 		if e := s.offsets.Close(); e != nil {
 			err = e
 		}
@@ -1016,6 +1164,7 @@ func (s *consumerGroupSession) heartbeatLoop() {
 		resp, err := s.parent.heartbeatRequest(coordinator, s.memberID, s.generationID)
 		if err != nil {
 			_ = coordinator.Close()
+			invalidateCoordinator(s.parent.client, s.parent.groupID)
 
 			if retries <= 0 {
 				s.parent.handleError(err, "", -1)
@@ -1029,6 +1178,22 @@ func (s *consumerGroupSession) heartbeatLoop() {
 		switch resp.Err {
 		case ErrNoError:
 			retries = s.parent.config.Metadata.Retry.Max
+		case ErrNotCoordinatorForConsumer, ErrConsumerCoordinatorNotAvailable:
+			// the coordinator moved: look it up again after backoff, the session
+			// goes on as long as the new coordinator is found before it expires
+			invalidateCoordinator(s.parent.client, s.parent.groupID)
+			if retries <= 0 {
+				s.parent.handleError(resp.Err, "", -1)
+				return
+			}
+			retryBackoff.Reset(s.parent.config.Metadata.Retry.Backoff)
+			select {
+			case <-s.hbDying:
+				return
+			case <-retryBackoff.C:
+				retries--
+			}
+			continue
 		case ErrRebalanceInProgress:
 			retries = s.parent.config.Metadata.Retry.Max
 			s.cancel()
@@ -1135,6 +1300,13 @@ func (c *consumerGroupClaim) Topic() string        { return c.topic }
 func (c *consumerGroupClaim) Partition() int32     { return c.partition }
 func (c *consumerGroupClaim) InitialOffset() int64 { return c.offset }
 
+func (c *consumerGroupClaim) Watermarks() <-chan *WatermarkEvent {
+	if w, ok := c.PartitionConsumer.(WatermarkReporter); ok {
+		return w.Watermarks()
+	}
+	return nil
+}
+
 // Drains messages and errors, ensures the claim is fully closed.
 func (c *consumerGroupClaim) waitClosed() (errs ConsumerErrors) {
 	go func() {
@@ -0,0 +1,71 @@
+package sarama
+
+// ConsumerGroupDescribeRequest describes the consumer groups using the
+// consumer group protocol of KIP-848, whose members are assigned their
+// partitions by the group coordinator.
+type ConsumerGroupDescribeRequest struct {
+	// Version 0 is currently only supported
+	Version int16
+	// GroupIDs are the IDs of the groups to describe.
+	GroupIDs []string
+	// IncludeAuthorizedOperations requests the operations authorized on the
+	// groups.
+	IncludeAuthorizedOperations bool
+}
+
+func (r *ConsumerGroupDescribeRequest) encode(pe packetEncoder) error {
+	pe.putCompactArrayLength(len(r.GroupIDs))
+	for _, groupID := range r.GroupIDs {
+		if err := pe.putCompactString(groupID); err != nil {
+			return err
+		}
+	}
+	pe.putBool(r.IncludeAuthorizedOperations)
+
+	pe.putEmptyTaggedFieldArray()
+	return nil
+}
+
+func (r *ConsumerGroupDescribeRequest) decode(pd packetDecoder, version int16) (err error) {
+	r.Version = version
+	n, err := pd.getCompactArrayLength()
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		r.GroupIDs = make([]string, n)
+		for i := range r.GroupIDs {
+			if r.GroupIDs[i], err = pd.getCompactString(); err != nil {
+				return err
+			}
+		}
+	}
+	if r.IncludeAuthorizedOperations, err = pd.getBool(); err != nil {
+		return err
+	}
+
+	if _, err = pd.getEmptyTaggedFieldArray(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *ConsumerGroupDescribeRequest) key() int16 {
+	return 69
+}
+
+func (r *ConsumerGroupDescribeRequest) version() int16 {
+	return r.Version
+}
+
+func (r *ConsumerGroupDescribeRequest) headerVersion() int16 {
+	return 2
+}
+
+func (r *ConsumerGroupDescribeRequest) isValidVersion() bool {
+	return r.Version == 0
+}
+
+func (r *ConsumerGroupDescribeRequest) requiredVersion() KafkaVersion {
+	return V4_0_0_0
+}
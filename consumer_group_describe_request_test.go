@@ -0,0 +1,17 @@
+package sarama
+
+import "testing"
+
+var consumerGroupDescribeRequest = []byte{
+	2,                // compact array length (1)
+	4, 'f', 'o', 'o', // group ID (compact string)
+	1, // include authorized operations
+	0, // empty tag buffer
+}
+
+func TestConsumerGroupDescribeRequest(t *testing.T) {
+	testRequest(t, "v0", &ConsumerGroupDescribeRequest{
+		GroupIDs:                    []string{"foo"},
+		IncludeAuthorizedOperations: true,
+	}, consumerGroupDescribeRequest)
+}
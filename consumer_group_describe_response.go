@@ -0,0 +1,393 @@
+package sarama
+
+import "time"
+
+// ConsumerGroupDescribeResponse describes the consumer groups using the
+// consumer group protocol of KIP-848.
+type ConsumerGroupDescribeResponse struct {
+	// Version 0 is currently only supported
+	Version      int16
+	ThrottleTime time.Duration
+	Groups       []*ConsumerGroupDescription
+}
+
+// ConsumerGroupDescription describes a consumer group using the consumer
+// group protocol of KIP-848.
+type ConsumerGroupDescription struct {
+	// Err is ErrGroupIDNotFound for the groups not using the consumer group
+	// protocol, which are described by DescribeGroups.
+	Err          KError
+	ErrorMessage *string
+	GroupID      string
+	GroupState   string
+	// GroupEpoch is the epoch of the group, and AssignmentEpoch the epoch of
+	// its target assignment.
+	GroupEpoch      int32
+	AssignmentEpoch int32
+	// AssignorName is the name of the server side assignor.
+	AssignorName string
+	Members      []*ConsumerGroupMemberDescription
+	// AuthorizedOperations is a 32-bit bitfield of the operations authorized
+	// on the group, when requested.
+	AuthorizedOperations int32
+}
+
+// ConsumerGroupMemberDescription describes a member of a consumer group using
+// the consumer group protocol of KIP-848.
+type ConsumerGroupMemberDescription struct {
+	MemberID             string
+	InstanceID           *string
+	RackID               *string
+	MemberEpoch          int32
+	ClientID             string
+	ClientHost           string
+	SubscribedTopicNames []string
+	SubscribedTopicRegex *string
+	// Assignment is the current assignment of the member, and
+	// TargetAssignment the one it is reconciling towards.
+	Assignment       *ConsumerGroupAssignment
+	TargetAssignment *ConsumerGroupAssignment
+}
+
+// ConsumerGroupAssignment is the assignment of a consumer group member using
+// the consumer group protocol of KIP-848.
+type ConsumerGroupAssignment struct {
+	TopicPartitions []*ConsumerGroupTopicPartitions
+}
+
+// ConsumerGroupTopicPartitions are the partitions of a topic assigned to a
+// consumer group member.
+type ConsumerGroupTopicPartitions struct {
+	TopicID    Uuid
+	TopicName  string
+	Partitions []int32
+}
+
+// Topics returns the assigned partitions by topic name.
+func (a *ConsumerGroupAssignment) Topics() map[string][]int32 {
+	if a == nil {
+		return nil
+	}
+	topics := make(map[string][]int32, len(a.TopicPartitions))
+	for _, tp := range a.TopicPartitions {
+		topics[tp.TopicName] = append(topics[tp.TopicName], tp.Partitions...)
+	}
+	return topics
+}
+
+// groupDescription converts the description to the GroupDescription returned
+// by DescribeGroups, with the member subscriptions and assignments encoded as
+// ConsumerGroupMemberMetadata and ConsumerGroupMemberAssignment.
+func (g *ConsumerGroupDescription) groupDescription() (*GroupDescription, error) {
+	description := &GroupDescription{
+		Err:                  g.Err,
+		ErrorCode:            int16(g.Err),
+		GroupId:              g.GroupID,
+		State:                g.GroupState,
+		ProtocolType:         "consumer",
+		Protocol:             g.AssignorName,
+		Members:              make(map[string]*GroupMemberDescription, len(g.Members)),
+		AuthorizedOperations: g.AuthorizedOperations,
+	}
+	for _, member := range g.Members {
+		metadata, err := encode(&ConsumerGroupMemberMetadata{Topics: member.SubscribedTopicNames}, nil)
+		if err != nil {
+			return nil, err
+		}
+		assignment, err := encode(&ConsumerGroupMemberAssignment{Topics: member.Assignment.Topics()}, nil)
+		if err != nil {
+			return nil, err
+		}
+		description.Members[member.MemberID] = &GroupMemberDescription{
+			MemberId:         member.MemberID,
+			GroupInstanceId:  member.InstanceID,
+			ClientId:         member.ClientID,
+			ClientHost:       member.ClientHost,
+			MemberMetadata:   metadata,
+			MemberAssignment: assignment,
+		}
+	}
+	return description, nil
+}
+
+func (r *ConsumerGroupDescribeResponse) encode(pe packetEncoder) error {
+	pe.putInt32(int32(r.ThrottleTime / time.Millisecond))
+	pe.putCompactArrayLength(len(r.Groups))
+	for _, group := range r.Groups {
+		if err := group.encode(pe); err != nil {
+			return err
+		}
+	}
+
+	pe.putEmptyTaggedFieldArray()
+	return nil
+}
+
+func (g *ConsumerGroupDescription) encode(pe packetEncoder) error {
+	pe.putInt16(int16(g.Err))
+	if err := pe.putNullableCompactString(g.ErrorMessage); err != nil {
+		return err
+	}
+	if err := pe.putCompactString(g.GroupID); err != nil {
+		return err
+	}
+	if err := pe.putCompactString(g.GroupState); err != nil {
+		return err
+	}
+	pe.putInt32(g.GroupEpoch)
+	pe.putInt32(g.AssignmentEpoch)
+	if err := pe.putCompactString(g.AssignorName); err != nil {
+		return err
+	}
+	pe.putCompactArrayLength(len(g.Members))
+	for _, member := range g.Members {
+		if err := member.encode(pe); err != nil {
+			return err
+		}
+	}
+	pe.putInt32(g.AuthorizedOperations)
+
+	pe.putEmptyTaggedFieldArray()
+	return nil
+}
+
+func (m *ConsumerGroupMemberDescription) encode(pe packetEncoder) error {
+	if err := pe.putCompactString(m.MemberID); err != nil {
+		return err
+	}
+	if err := pe.putNullableCompactString(m.InstanceID); err != nil {
+		return err
+	}
+	if err := pe.putNullableCompactString(m.RackID); err != nil {
+		return err
+	}
+	pe.putInt32(m.MemberEpoch)
+	if err := pe.putCompactString(m.ClientID); err != nil {
+		return err
+	}
+	if err := pe.putCompactString(m.ClientHost); err != nil {
+		return err
+	}
+	pe.putCompactArrayLength(len(m.SubscribedTopicNames))
+	for _, topic := range m.SubscribedTopicNames {
+		if err := pe.putCompactString(topic); err != nil {
+			return err
+		}
+	}
+	if err := pe.putNullableCompactString(m.SubscribedTopicRegex); err != nil {
+		return err
+	}
+	if err := m.Assignment.encode(pe); err != nil {
+		return err
+	}
+	if err := m.TargetAssignment.encode(pe); err != nil {
+		return err
+	}
+
+	pe.putEmptyTaggedFieldArray()
+	return nil
+}
+
+func (a *ConsumerGroupAssignment) encode(pe packetEncoder) error {
+	var topicPartitions []*ConsumerGroupTopicPartitions
+	if a != nil {
+		topicPartitions = a.TopicPartitions
+	}
+	pe.putCompactArrayLength(len(topicPartitions))
+	for _, tp := range topicPartitions {
+		if err := pe.putRawBytes(tp.TopicID[:]); err != nil {
+			return err
+		}
+		if err := pe.putCompactString(tp.TopicName); err != nil {
+			return err
+		}
+		if err := pe.putCompactInt32Array(tp.Partitions); err != nil {
+			return err
+		}
+		pe.putEmptyTaggedFieldArray()
+	}
+
+	pe.putEmptyTaggedFieldArray()
+	return nil
+}
+
+func (r *ConsumerGroupDescribeResponse) decode(pd packetDecoder, version int16) (err error) {
+	r.Version = version
+	throttleTime, err := pd.getInt32()
+	if err != nil {
+		return err
+	}
+	r.ThrottleTime = time.Duration(throttleTime) * time.Millisecond
+
+	n, err := pd.getCompactArrayLength()
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		r.Groups = make([]*ConsumerGroupDescription, n)
+		for i := range r.Groups {
+			r.Groups[i] = new(ConsumerGroupDescription)
+			if err := r.Groups[i].decode(pd); err != nil {
+				return err
+			}
+		}
+	}
+
+	if _, err = pd.getEmptyTaggedFieldArray(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (g *ConsumerGroupDescription) decode(pd packetDecoder) (err error) {
+	kerr, err := pd.getInt16()
+	if err != nil {
+		return err
+	}
+	g.Err = KError(kerr)
+	if g.ErrorMessage, err = pd.getCompactNullableString(); err != nil {
+		return err
+	}
+	if g.GroupID, err = pd.getCompactString(); err != nil {
+		return err
+	}
+	if g.GroupState, err = pd.getCompactString(); err != nil {
+		return err
+	}
+	if g.GroupEpoch, err = pd.getInt32(); err != nil {
+		return err
+	}
+	if g.AssignmentEpoch, err = pd.getInt32(); err != nil {
+		return err
+	}
+	if g.AssignorName, err = pd.getCompactString(); err != nil {
+		return err
+	}
+	n, err := pd.getCompactArrayLength()
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		g.Members = make([]*ConsumerGroupMemberDescription, n)
+		for i := range g.Members {
+			g.Members[i] = new(ConsumerGroupMemberDescription)
+			if err := g.Members[i].decode(pd); err != nil {
+				return err
+			}
+		}
+	}
+	if g.AuthorizedOperations, err = pd.getInt32(); err != nil {
+		return err
+	}
+
+	if _, err = pd.getEmptyTaggedFieldArray(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (m *ConsumerGroupMemberDescription) decode(pd packetDecoder) (err error) {
+	if m.MemberID, err = pd.getCompactString(); err != nil {
+		return err
+	}
+	if m.InstanceID, err = pd.getCompactNullableString(); err != nil {
+		return err
+	}
+	if m.RackID, err = pd.getCompactNullableString(); err != nil {
+		return err
+	}
+	if m.MemberEpoch, err = pd.getInt32(); err != nil {
+		return err
+	}
+	if m.ClientID, err = pd.getCompactString(); err != nil {
+		return err
+	}
+	if m.ClientHost, err = pd.getCompactString(); err != nil {
+		return err
+	}
+	n, err := pd.getCompactArrayLength()
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		m.SubscribedTopicNames = make([]string, n)
+		for i := range m.SubscribedTopicNames {
+			if m.SubscribedTopicNames[i], err = pd.getCompactString(); err != nil {
+				return err
+			}
+		}
+	}
+	if m.SubscribedTopicRegex, err = pd.getCompactNullableString(); err != nil {
+		return err
+	}
+	m.Assignment = new(ConsumerGroupAssignment)
+	if err := m.Assignment.decode(pd); err != nil {
+		return err
+	}
+	m.TargetAssignment = new(ConsumerGroupAssignment)
+	if err := m.TargetAssignment.decode(pd); err != nil {
+		return err
+	}
+
+	if _, err = pd.getEmptyTaggedFieldArray(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (a *ConsumerGroupAssignment) decode(pd packetDecoder) (err error) {
+	n, err := pd.getCompactArrayLength()
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		a.TopicPartitions = make([]*ConsumerGroupTopicPartitions, n)
+		for i := range a.TopicPartitions {
+			tp := new(ConsumerGroupTopicPartitions)
+			topicID, err := pd.getRawBytes(16)
+			if err != nil {
+				return err
+			}
+			copy(tp.TopicID[:], topicID)
+			if tp.TopicName, err = pd.getCompactString(); err != nil {
+				return err
+			}
+			if tp.Partitions, err = pd.getCompactInt32Array(); err != nil {
+				return err
+			}
+			if _, err = pd.getEmptyTaggedFieldArray(); err != nil {
+				return err
+			}
+			a.TopicPartitions[i] = tp
+		}
+	}
+
+	if _, err = pd.getEmptyTaggedFieldArray(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *ConsumerGroupDescribeResponse) key() int16 {
+	return 69
+}
+
+func (r *ConsumerGroupDescribeResponse) version() int16 {
+	return r.Version
+}
+
+func (r *ConsumerGroupDescribeResponse) headerVersion() int16 {
+	return 1
+}
+
+func (r *ConsumerGroupDescribeResponse) isValidVersion() bool {
+	return r.Version == 0
+}
+
+func (r *ConsumerGroupDescribeResponse) requiredVersion() KafkaVersion {
+	return V4_0_0_0
+}
+
+func (r *ConsumerGroupDescribeResponse) throttleTime() time.Duration {
+	return r.ThrottleTime
+}
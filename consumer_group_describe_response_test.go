@@ -0,0 +1,95 @@
+package sarama
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+var consumerGroupDescribeResponse = []byte{
+	0, 0, 0, 100, // throttle time
+	2,    // compact array length (1)
+	0, 0, // no error
+	0,                // error message (null)
+	4, 'f', 'o', 'o', // group ID
+	7, 'S', 't', 'a', 'b', 'l', 'e', // group state
+	0, 0, 0, 3, // group epoch
+	0, 0, 0, 3, // assignment epoch
+	8, 'u', 'n', 'i', 'f', 'o', 'r', 'm', // assignor name
+	2,                // compact array length (1)
+	4, 'm', 'e', 'm', // member ID
+	0,          // instance ID (null)
+	0,          // rack ID (null)
+	0, 0, 0, 3, // member epoch
+	4, 'c', 'l', 'i', // client ID
+	5, 'h', 'o', 's', 't', // client host
+	2,                // compact array length (1)
+	4, 'b', 'a', 'r', // subscribed topic name
+	0, // subscribed topic regex (null)
+	// assignment
+	2,                                                    // compact array length (1)
+	0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, // topic ID
+	4, 'b', 'a', 'r', // topic name
+	3, 0, 0, 0, 0, 0, 0, 0, 1, // partitions
+	0, // empty tag buffer
+	0, // empty tag buffer
+	// target assignment
+	1,             // compact array length (0)
+	0,             // empty tag buffer
+	0,             // empty tag buffer
+	0x80, 0, 0, 0, // authorized operations
+	0, // empty tag buffer
+	0, // empty tag buffer
+}
+
+func TestConsumerGroupDescribeResponse(t *testing.T) {
+	response := &ConsumerGroupDescribeResponse{
+		ThrottleTime: 100 * time.Millisecond,
+		Groups: []*ConsumerGroupDescription{{
+			GroupID:         "foo",
+			GroupState:      "Stable",
+			GroupEpoch:      3,
+			AssignmentEpoch: 3,
+			AssignorName:    "uniform",
+			Members: []*ConsumerGroupMemberDescription{{
+				MemberID:             "mem",
+				MemberEpoch:          3,
+				ClientID:             "cli",
+				ClientHost:           "host",
+				SubscribedTopicNames: []string{"bar"},
+				Assignment: &ConsumerGroupAssignment{
+					TopicPartitions: []*ConsumerGroupTopicPartitions{{
+						TopicID:    Uuid{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15},
+						TopicName:  "bar",
+						Partitions: []int32{0, 1},
+					}},
+				},
+				TargetAssignment: &ConsumerGroupAssignment{},
+			}},
+			AuthorizedOperations: -2147483648,
+		}},
+	}
+	testResponse(t, "v0", response, consumerGroupDescribeResponse)
+
+	description, err := response.Groups[0].groupDescription()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if description.ProtocolType != "consumer" || description.Protocol != "uniform" {
+		t.Errorf("Expected a consumer group assigned by uniform, got %q and %q", description.ProtocolType, description.Protocol)
+	}
+	assignment, err := description.Members["mem"].GetMemberAssignment()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := map[string][]int32{"bar": {0, 1}}; !reflect.DeepEqual(assignment.Topics, expected) {
+		t.Errorf("Expected assignment %v, got %v", expected, assignment.Topics)
+	}
+	metadata, err := description.Members["mem"].GetMemberMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"bar"}; !reflect.DeepEqual(metadata.Topics, expected) {
+		t.Errorf("Expected subscription %v, got %v", expected, metadata.Topics)
+	}
+}
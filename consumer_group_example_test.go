@@ -50,3 +50,21 @@ func ExampleConsumerGroup() {
 		}
 	}
 }
+
+func ExampleConsumerGroup_consumeLoop() {
+	config := NewTestConfig()
+	config.Version = V2_0_0_0 // specify appropriate version
+
+	group, err := NewConsumerGroup([]string{"localhost:9092"}, "my-group", config)
+	if err != nil {
+		panic(err)
+	}
+	defer func() { _ = group.Close() }()
+
+	// `ConsumeLoop` rejoins the group after each rebalance, and retries the
+	// transient errors, until ctx is done or the group is closed
+	ctx := context.Background()
+	if err := group.(ConsumerGroupLooper).ConsumeLoop(ctx, []string{"my-topic"}, exampleConsumerGroupHandler{}); err != nil {
+		panic(err)
+	}
+}
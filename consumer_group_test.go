@@ -184,6 +184,86 @@ outerFor:
 // TestConsumerGroupSessionDoesNotRetryForever ensures that an error fetching
 // the coordinator decrements the retry attempts and doesn't end up retrying
 // forever
+// TestConsumerGroupStrategyDowngrade ensures that a member offering several
+// strategies joins with the one chosen by the coordinator, and fails when the
+// coordinator chooses one it did not offer.
+func TestConsumerGroupStrategyDowngrade(t *testing.T) {
+	for _, tc := range []struct {
+		protocol string
+		err      error
+	}{
+		{protocol: RangeBalanceStrategyName},
+		{protocol: RoundRobinBalanceStrategyName, err: ErrInconsistentGroupProtocol},
+	} {
+		t.Run(tc.protocol, func(t *testing.T) {
+			config := NewTestConfig()
+			config.Version = V2_0_0_0
+			config.Consumer.Return.Errors = true
+			config.Consumer.Group.Rebalance.GroupStrategies = []BalanceStrategy{NewBalanceStrategySticky(), NewBalanceStrategyRange()}
+			config.Consumer.Offsets.AutoCommit.Enable = false
+
+			broker0 := NewMockBroker(t, 0)
+			defer broker0.Close()
+
+			broker0.SetHandlerByMap(map[string]MockResponse{
+				"MetadataRequest": NewMockMetadataResponse(t).
+					SetBroker(broker0.Addr(), broker0.BrokerID()).
+					SetLeader("my-topic", 0, broker0.BrokerID()),
+				"OffsetRequest": NewMockOffsetResponse(t).
+					SetOffset("my-topic", 0, OffsetOldest, 0).
+					SetOffset("my-topic", 0, OffsetNewest, 1),
+				"FindCoordinatorRequest": NewMockFindCoordinatorResponse(t).
+					SetCoordinator(CoordinatorGroup, "my-group", broker0),
+				"HeartbeatRequest": NewMockHeartbeatResponse(t),
+				"JoinGroupRequest": NewMockJoinGroupResponse(t).SetGroupProtocol(tc.protocol),
+				"SyncGroupRequest": NewMockSyncGroupResponse(t).SetMemberAssignment(
+					&ConsumerGroupMemberAssignment{
+						Version: 0,
+						Topics: map[string][]int32{
+							"my-topic": {0},
+						},
+					}),
+				"OffsetFetchRequest": NewMockOffsetFetchResponse(t).SetOffset(
+					"my-group", "my-topic", 0, 0, "", ErrNoError,
+				).SetError(ErrNoError),
+				"FetchRequest": NewMockSequence(
+					NewMockFetchResponse(t, 1).
+						SetMessage("my-topic", 0, 0, StringEncoder("foo")),
+					NewMockFetchResponse(t, 1),
+				),
+				"LeaveGroupRequest": NewMockLeaveGroupResponse(t),
+			})
+
+			group, err := NewConsumerGroup([]string{broker0.Addr()}, "my-group", config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() { _ = group.Close() }()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			err = group.Consume(ctx, []string{"my-topic"}, &handler{t, cancel})
+			if tc.err == nil && err != nil {
+				t.Error(err)
+			}
+			if tc.err != nil && !errors.Is(err, tc.err) {
+				t.Errorf("expected %v, got %v", tc.err, err)
+			}
+
+			// both strategies are offered, in order of preference
+			for _, rr := range broker0.History() {
+				if join, ok := rr.Request.(*JoinGroupRequest); ok {
+					if len(join.OrderedGroupProtocols) != 2 ||
+						join.OrderedGroupProtocols[0].Name != StickyBalanceStrategyName ||
+						join.OrderedGroupProtocols[1].Name != RangeBalanceStrategyName {
+						t.Error("expected the sticky then the range strategies to be offered, got", join.OrderedGroupProtocols)
+					}
+				}
+			}
+		})
+	}
+}
+
 func TestConsumerGroupSessionDoesNotRetryForever(t *testing.T) {
 	config := NewTestConfig()
 	config.ClientID = t.Name()
@@ -224,3 +304,435 @@ func TestConsumerGroupSessionDoesNotRetryForever(t *testing.T) {
 
 	wg.Wait()
 }
+
+// TestConsumerGroupHeartbeatCoordinatorMoved ensures that the session survives
+// a heartbeat answered with NOT_COORDINATOR, looking the coordinator up again
+// instead of ending the session.
+func TestConsumerGroupHeartbeatCoordinatorMoved(t *testing.T) {
+	config := NewTestConfig()
+	config.ClientID = t.Name()
+	config.Version = V2_0_0_0
+	config.Consumer.Return.Errors = true
+	config.Consumer.Group.Heartbeat.Interval = 10 * time.Millisecond
+	config.Consumer.Offsets.AutoCommit.Enable = false
+	config.Metadata.Retry.Backoff = 0
+
+	broker0 := NewMockBroker(t, 0)
+	defer broker0.Close()
+
+	broker0.SetHandlerByMap(map[string]MockResponse{
+		"MetadataRequest": NewMockMetadataResponse(t).
+			SetBroker(broker0.Addr(), broker0.BrokerID()).
+			SetLeader("my-topic", 0, broker0.BrokerID()),
+		"OffsetRequest": NewMockOffsetResponse(t).
+			SetOffset("my-topic", 0, OffsetOldest, 0).
+			SetOffset("my-topic", 0, OffsetNewest, 0),
+		"FindCoordinatorRequest": NewMockFindCoordinatorResponse(t).
+			SetCoordinator(CoordinatorGroup, "my-group", broker0),
+		"HeartbeatRequest": NewMockSequence(
+			NewMockHeartbeatResponse(t),
+			NewMockHeartbeatResponse(t).SetError(ErrNotCoordinatorForConsumer),
+			NewMockHeartbeatResponse(t),
+		),
+		"JoinGroupRequest": NewMockJoinGroupResponse(t).SetGroupProtocol(RangeBalanceStrategyName),
+		"SyncGroupRequest": NewMockSyncGroupResponse(t).SetMemberAssignment(
+			&ConsumerGroupMemberAssignment{
+				Version: 0,
+				Topics: map[string][]int32{
+					"my-topic": {0},
+				},
+			}),
+		"OffsetFetchRequest": NewMockOffsetFetchResponse(t).SetOffset(
+			"my-group", "my-topic", 0, 0, "", ErrNoError,
+		).SetError(ErrNoError),
+		"FetchRequest":      NewMockFetchResponse(t, 1),
+		"LeaveGroupRequest": NewMockLeaveGroupResponse(t),
+	})
+
+	group, err := NewConsumerGroup([]string{broker0.Addr()}, "my-group", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = group.Close() }()
+
+	count := func(kind string) int {
+		n := 0
+		for _, rr := range broker0.History() {
+			switch rr.Request.(type) {
+			case *HeartbeatRequest:
+				if kind == "heartbeat" {
+					n++
+				}
+			case *FindCoordinatorRequest:
+				if kind == "coordinator" {
+					n++
+				}
+			case *JoinGroupRequest:
+				if kind == "join" {
+					n++
+				}
+			}
+		}
+		return n
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- group.Consume(ctx, []string{"my-topic"}, &blockingHandler{})
+	}()
+
+	deadline := time.After(5 * time.Second)
+	for count("heartbeat") < 4 {
+		select {
+		case err := <-group.Errors():
+			t.Fatal("unexpected error:", err)
+		case err := <-done:
+			t.Fatal("the session ended:", err)
+		case <-deadline:
+			t.Fatal("timed out waiting for the heartbeats")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+
+	if n := count("join"); n != 1 {
+		t.Errorf("expected the group to be joined once, got %d joins", n)
+	}
+	if n := count("coordinator"); n < 2 {
+		t.Errorf("expected the coordinator to be looked up again, got %d lookups", n)
+	}
+}
+
+type blockingHandler struct{}
+
+func (blockingHandler) Setup(ConsumerGroupSession) error   { return nil }
+func (blockingHandler) Cleanup(ConsumerGroupSession) error { return nil }
+func (blockingHandler) ConsumeClaim(sess ConsumerGroupSession, claim ConsumerGroupClaim) error {
+	<-sess.Context().Done()
+	return nil
+}
+
+type drainHandler struct {
+	consumed chan int64
+	cleanup  chan none
+	finished chan none
+}
+
+func (h *drainHandler) Setup(ConsumerGroupSession) error { return nil }
+func (h *drainHandler) Cleanup(ConsumerGroupSession) error {
+	close(h.cleanup)
+	return nil
+}
+
+func (h *drainHandler) ConsumeClaim(sess ConsumerGroupSession, claim ConsumerGroupClaim) error {
+	for msg := range claim.Messages() {
+		h.consumed <- msg.Offset
+		// the in-flight message is processed to the end
+		time.Sleep(50 * time.Millisecond)
+		sess.MarkMessage(msg, "")
+	}
+	close(h.finished)
+	return nil
+}
+
+func TestConsumerGroupDrain(t *testing.T) {
+	config := NewTestConfig()
+	config.ClientID = t.Name()
+	config.Version = V2_0_0_0
+	config.Consumer.Return.Errors = true
+	config.Consumer.Offsets.AutoCommit.Enable = false
+
+	broker0 := NewMockBroker(t, 0)
+	defer broker0.Close()
+
+	broker0.SetHandlerByMap(map[string]MockResponse{
+		"MetadataRequest": NewMockMetadataResponse(t).
+			SetBroker(broker0.Addr(), broker0.BrokerID()).
+			SetLeader("my-topic", 0, broker0.BrokerID()),
+		"OffsetRequest": NewMockOffsetResponse(t).
+			SetOffset("my-topic", 0, OffsetOldest, 0).
+			SetOffset("my-topic", 0, OffsetNewest, 1),
+		"FindCoordinatorRequest": NewMockFindCoordinatorResponse(t).
+			SetCoordinator(CoordinatorGroup, "my-group", broker0),
+		"HeartbeatRequest": NewMockHeartbeatResponse(t),
+		"JoinGroupRequest": NewMockJoinGroupResponse(t).
+			SetGroupProtocol(RangeBalanceStrategyName).
+			SetMemberId("my-member"),
+		"SyncGroupRequest": NewMockSyncGroupResponse(t).SetMemberAssignment(
+			&ConsumerGroupMemberAssignment{
+				Version: 0,
+				Topics: map[string][]int32{
+					"my-topic": {0},
+				},
+			}),
+		"OffsetFetchRequest": NewMockOffsetFetchResponse(t).SetOffset(
+			"my-group", "my-topic", 0, 0, "", ErrNoError,
+		).SetError(ErrNoError),
+		"FetchRequest": NewMockSequence(
+			NewMockFetchResponse(t, 1).
+				SetMessage("my-topic", 0, 0, StringEncoder("foo")),
+			NewMockFetchResponse(t, 1),
+		),
+		"OffsetCommitRequest": NewMockOffsetCommitResponse(t),
+		"LeaveGroupRequest":   NewMockLeaveGroupResponse(t),
+	})
+
+	group, err := NewConsumerGroup([]string{broker0.Addr()}, "my-group", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = group.Close() }()
+
+	h := &drainHandler{consumed: make(chan int64, 1), cleanup: make(chan none), finished: make(chan none)}
+	done := make(chan error, 1)
+	go func() {
+		done <- group.Consume(context.Background(), []string{"my-topic"}, h)
+	}()
+
+	select {
+	case <-h.consumed:
+	case err := <-group.Errors():
+		t.Fatal("unexpected error:", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the message")
+	}
+
+	drainer, ok := group.(ConsumerGroupDrainer)
+	if !ok {
+		t.Fatal("expected the consumer group to be a ConsumerGroupDrainer")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := drainer.Drain(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-h.finished:
+	default:
+		t.Error("expected the handler to have finished")
+	}
+	select {
+	case <-h.cleanup:
+	default:
+		t.Error("expected the handler to have been cleaned up")
+	}
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+	if err := group.Consume(context.Background(), []string{"my-topic"}, h); !errors.Is(err, ErrClosedConsumerGroup) {
+		t.Errorf("expected %s, got %v", ErrClosedConsumerGroup, err)
+	}
+
+	var committed, left bool
+	for _, rr := range broker0.History() {
+		switch req := rr.Request.(type) {
+		case *OffsetCommitRequest:
+			if block := req.blocks["my-topic"][0]; block != nil && block.offset == 1 {
+				committed = true
+			}
+		case *LeaveGroupRequest:
+			left = committed
+		}
+	}
+	if !committed {
+		t.Error("expected the offset of the in-flight message to be committed")
+	}
+	if !left {
+		t.Error("expected the group to be left after the commit")
+	}
+}
+
+func TestConsumeReasonOf(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, tt := range []struct {
+		ctx    context.Context
+		err    error
+		reason ConsumeReason
+	}{
+		{context.Background(), nil, ConsumeRebalance},
+		{canceled, nil, ConsumeCanceled},
+		{context.Background(), context.DeadlineExceeded, ConsumeCanceled},
+		{canceled, ErrClosedConsumerGroup, ConsumeClosed},
+		{context.Background(), ErrUnknownTopicOrPartition, ConsumeTopicsMissing},
+		{context.Background(), ErrNoTopicsToConsume, ConsumeFatal},
+		{context.Background(), ConfigurationError("invalid"), ConsumeFatal},
+		{context.Background(), ErrGroupAuthorizationFailed, ConsumeFatal},
+		{context.Background(), UnsupportedVersionError{}, ConsumeFatal},
+		{context.Background(), ErrConsumerCoordinatorNotAvailable, ConsumeRetriable},
+		{context.Background(), ErrOutOfBrokers, ConsumeRetriable},
+	} {
+		if reason := ConsumeReasonOf(tt.ctx, tt.err); reason != tt.reason {
+			t.Errorf("expected %v to be %s, got %s", tt.err, tt.reason, reason)
+		}
+	}
+}
+
+func TestConsumerGroupConsumeLoop(t *testing.T) {
+	config := NewTestConfig()
+	config.ClientID = t.Name()
+	config.Version = V2_0_0_0
+	config.Consumer.Return.Errors = true
+	config.Consumer.Group.Rebalance.Retry.Max = 0
+	config.Consumer.Group.Rebalance.Retry.Backoff = time.Millisecond
+	config.Metadata.Retry.Max = 0
+
+	broker0 := NewMockBroker(t, 0)
+	defer broker0.Close()
+
+	broker0.SetHandlerByMap(map[string]MockResponse{
+		"MetadataRequest": NewMockMetadataResponse(t).
+			SetBroker(broker0.Addr(), broker0.BrokerID()).
+			SetLeader("my-topic", 0, broker0.BrokerID()),
+		"FindCoordinatorRequest": NewMockFindCoordinatorResponse(t).
+			SetError(CoordinatorGroup, "my-group", ErrGroupAuthorizationFailed),
+	})
+
+	group, err := NewConsumerGroup([]string{broker0.Addr()}, "my-group", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = group.Close() }()
+	looper, ok := group.(ConsumerGroupLooper)
+	if !ok {
+		t.Fatal("expected the consumer group to be a ConsumerGroupLooper")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	h := &handler{t, cancel}
+
+	// the topics missing are retried until ctx is done
+	done := make(chan error, 1)
+	go func() {
+		done <- looper.ConsumeLoop(ctx, []string{"missing-topic"}, h)
+	}()
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-group.Errors():
+			if !errors.Is(err, ErrUnknownTopicOrPartition) {
+				t.Fatal("unexpected error:", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for the retries")
+		}
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+
+	// the fatal errors are returned
+	err = looper.ConsumeLoop(context.Background(), []string{"my-topic"}, h)
+	if !errors.Is(err, ErrGroupAuthorizationFailed) {
+		t.Errorf("expected %s, got %v", ErrGroupAuthorizationFailed, err)
+	}
+
+	// the loop ends once the group is closed
+	if err := group.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := looper.ConsumeLoop(context.Background(), []string{"my-topic"}, h); err != nil {
+		t.Error(err)
+	}
+}
+
+type commitHandler struct {
+	*testing.T
+	cancel context.CancelFunc
+	offset int64
+}
+
+func (h *commitHandler) Setup(s ConsumerGroupSession) error   { return nil }
+func (h *commitHandler) Cleanup(s ConsumerGroupSession) error { return nil }
+func (h *commitHandler) ConsumeClaim(sess ConsumerGroupSession, claim ConsumerGroupClaim) error {
+	for msg := range claim.Messages() {
+		h.offset = msg.Offset
+		sess.MarkMessage(msg, "")
+		sess.Commit()
+		h.cancel()
+		break
+	}
+	return nil
+}
+
+func TestConsumerGroupAssign(t *testing.T) {
+	config := NewTestConfig()
+	config.ClientID = t.Name()
+	config.Version = V2_0_0_0
+	config.Consumer.Return.Errors = true
+	config.Consumer.Offsets.AutoCommit.Enable = false
+
+	broker0 := NewMockBroker(t, 0)
+	defer broker0.Close()
+
+	broker0.SetHandlerByMap(map[string]MockResponse{
+		"MetadataRequest": NewMockMetadataResponse(t).
+			SetBroker(broker0.Addr(), broker0.BrokerID()).
+			SetLeader("my-topic", 0, broker0.BrokerID()).
+			SetLeader("my-topic", 1, broker0.BrokerID()),
+		"OffsetRequest": NewMockOffsetResponse(t).
+			SetOffset("my-topic", 1, OffsetOldest, 0).
+			SetOffset("my-topic", 1, OffsetNewest, 7),
+		"FindCoordinatorRequest": NewMockFindCoordinatorResponse(t).
+			SetCoordinator(CoordinatorGroup, "my-group", broker0),
+		"OffsetFetchRequest": NewMockOffsetFetchResponse(t).SetOffset(
+			"my-group", "my-topic", 1, 5, "", ErrNoError,
+		).SetError(ErrNoError),
+		"FetchRequest": NewMockSequence(
+			NewMockFetchResponse(t, 1).SetMessage("my-topic", 1, 5, StringEncoder("foo")),
+			NewMockFetchResponse(t, 1),
+		),
+		"OffsetCommitRequest": NewMockOffsetCommitResponse(t),
+	})
+
+	group, err := NewConsumerGroup([]string{broker0.Addr()}, "my-group", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = group.Close() }()
+	assigner, ok := group.(ConsumerGroupAssigner)
+	if !ok {
+		t.Fatal("expected the consumer group to be a ConsumerGroupAssigner")
+	}
+
+	if err := assigner.Assign(context.Background(), nil, &handler{t, nil}); !errors.Is(err, ErrNoTopicsToConsume) {
+		t.Errorf("expected %s, got %v", ErrNoTopicsToConsume, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	h := &commitHandler{T: t, cancel: cancel}
+	if err := assigner.Assign(ctx, []TopicPartitionID{{Topic: "my-topic", Partition: 1}}, h); err != nil {
+		t.Fatal(err)
+	}
+	if h.offset != 5 {
+		t.Error("expected to consume from the committed offset 5, got", h.offset)
+	}
+
+	var commit *OffsetCommitRequest
+	for _, rr := range broker0.History() {
+		switch req := rr.Request.(type) {
+		case *JoinGroupRequest, *SyncGroupRequest, *HeartbeatRequest, *LeaveGroupRequest:
+			t.Errorf("expected the group not to be joined, got a %T", req)
+		case *OffsetCommitRequest:
+			commit = req
+		}
+	}
+	if commit == nil {
+		t.Fatal("expected the offset to be committed")
+	}
+	if commit.ConsumerGroupGeneration != GroupGenerationUndefined || commit.ConsumerID != "" {
+		t.Errorf("expected a commit without membership, got generation %d and member %q",
+			commit.ConsumerGroupGeneration, commit.ConsumerID)
+	}
+	if block := commit.blocks["my-topic"][1]; block == nil || block.offset != 6 {
+		t.Error("expected the offset 6 to be committed, got", block)
+	}
+}
@@ -1865,6 +1865,91 @@ func TestExcludeUncommitted(t *testing.T) {
 	broker0.Close()
 }
 
+// The watermarks of a partition are returned whether or not records are
+// fetched, telling a consumer waiting for an open transaction from one
+// waiting for new records.
+func TestConsumerWatermarks(t *testing.T) {
+	// Given
+	fetchResponse1 := &FetchResponse{Version: 5}
+	fetchResponse1.AddRecord("my_topic", 0, nil, testMsg, 3)
+	fetchResponse1.AddRecord("my_topic", 0, nil, testMsg, 4)
+	fetchResponse1.SetLastOffsetDelta("my_topic", 0, 1)
+	fetchResponse1.SetLastStableOffset("my_topic", 0, 5)
+	fetchResponse1.GetBlock("my_topic", 0).HighWaterMarkOffset = 8
+	// the transaction is still open
+	fetchResponse2 := &FetchResponse{Version: 5}
+	fetchResponse2.AddError("my_topic", 0, ErrNoError)
+	fetchResponse2.SetLastStableOffset("my_topic", 0, 5)
+	fetchResponse2.GetBlock("my_topic", 0).HighWaterMarkOffset = 8
+	// the transaction is aborted
+	fetchResponse3 := &FetchResponse{Version: 5}
+	fetchResponse3.AddError("my_topic", 0, ErrNoError)
+	fetchResponse3.SetLastStableOffset("my_topic", 0, 8)
+	fetchResponse3.GetBlock("my_topic", 0).HighWaterMarkOffset = 8
+
+	broker0 := NewMockBroker(t, 0)
+	defer broker0.Close()
+	broker0.SetHandlerByMap(map[string]MockResponse{
+		"MetadataRequest": NewMockMetadataResponse(t).
+			SetBroker(broker0.Addr(), broker0.BrokerID()).
+			SetLeader("my_topic", 0, broker0.BrokerID()),
+		"OffsetRequest": NewMockOffsetResponse(t).
+			SetOffset("my_topic", 0, OffsetOldest, 0).
+			SetOffset("my_topic", 0, OffsetNewest, 8),
+		"FetchRequest": NewMockSequence(fetchResponse1, fetchResponse2, fetchResponse3),
+	})
+
+	cfg := NewTestConfig()
+	cfg.Version = V0_11_0_0
+	cfg.Consumer.IsolationLevel = ReadCommitted
+	cfg.Consumer.Return.Watermarks = true
+
+	// When
+	master, err := NewConsumer([]string{broker0.Addr()}, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	consumer, err := master.ConsumePartition("my_topic", 0, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reporter, ok := consumer.(WatermarkReporter)
+	if !ok {
+		t.Fatal("expected the partition consumer to be a WatermarkReporter")
+	}
+	assertMessageOffset(t, <-consumer.Messages(), 3)
+	assertMessageOffset(t, <-consumer.Messages(), 4)
+
+	// Then: the consumer is blocked behind the transaction until it is
+	// aborted, and the unchanged watermarks are not returned again
+	expected := []WatermarkEvent{
+		{HighWaterMarkOffset: 8, LastStableOffset: 5, Records: 2, Blocked: true},
+		{HighWaterMarkOffset: 8, LastStableOffset: 8},
+	}
+	for _, want := range expected {
+		select {
+		case event := <-reporter.Watermarks():
+			if event.Topic != "my_topic" || event.Partition != 0 || event.HighWaterMarkOffset != want.HighWaterMarkOffset ||
+				event.LastStableOffset != want.LastStableOffset || event.Records != want.Records || event.Blocked != want.Blocked {
+				t.Errorf("expected watermarks %+v, got %+v", want, event)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for the watermarks")
+		}
+	}
+	select {
+	case event := <-reporter.Watermarks():
+		t.Error("expected no more watermarks, got", event)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	safeClose(t, consumer)
+	safeClose(t, master)
+	if _, ok := <-reporter.Watermarks(); ok {
+		t.Error("expected the watermarks channel to be closed")
+	}
+}
+
 func assertMessageKey(t *testing.T, msg *ConsumerMessage, expectedKey Encoder) {
 	t.Helper()
 
@@ -1883,6 +1968,283 @@ func assertMessageValue(t *testing.T, msg *ConsumerMessage, expectedValue Encode
 	}
 }
 
+func TestConsumerPooledBuffers(t *testing.T) {
+	broker0 := NewMockBroker(t, 0)
+	defer broker0.Close()
+
+	mockFetchResponse := NewMockFetchResponse(t, 10)
+	for i := int64(0); i < 10; i++ {
+		mockFetchResponse.SetMessage("my_topic", 0, i, testMsg)
+	}
+	broker0.SetHandlerByMap(map[string]MockResponse{
+		"MetadataRequest": NewMockMetadataResponse(t).
+			SetBroker(broker0.Addr(), broker0.BrokerID()).
+			SetLeader("my_topic", 0, broker0.BrokerID()),
+		"OffsetRequest": NewMockOffsetResponse(t).
+			SetOffset("my_topic", 0, OffsetOldest, 0).
+			SetOffset("my_topic", 0, OffsetNewest, 10),
+		"FetchRequest": mockFetchResponse,
+	})
+
+	config := NewTestConfig()
+	config.Consumer.PooledBuffers = true
+	master, err := NewConsumer([]string{broker0.Addr()}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer safeClose(t, master)
+	consumer, err := master.ConsumePartition("my_topic", 0, OffsetOldest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer safeClose(t, consumer)
+
+	var buffer *fetchBuffer
+	for i := int64(0); i < 10; i++ {
+		msg := <-consumer.Messages()
+		assertMessageOffset(t, msg, i)
+		if string(msg.Value) != string(testMsg) {
+			t.Errorf("expected value %q, got %q", testMsg, msg.Value)
+		}
+		if msg.buffer == nil {
+			t.Fatal("expected the message to point into a pooled buffer")
+		}
+		if buffer == nil {
+			buffer = msg.buffer
+		} else if msg.buffer != buffer {
+			t.Error("expected the messages of a fetch response to share its buffer")
+		}
+		ReleaseConsumerMessage(msg)
+		if msg.buffer != nil {
+			t.Error("expected ReleaseConsumerMessage to release the buffer of the message")
+		}
+		ReleaseConsumerMessage(msg)
+	}
+}
+
+// corruptFetchResponse returns the values "ok0", "bad1" and "ok2" at the
+// offsets 0 to 2 of my_topic/0, each in its own record batch, corrupting the
+// second batch.
+type corruptFetchResponse struct{}
+
+func (corruptFetchResponse) For(reqBody versionedDecoder) encoderWithHeader {
+	req := reqBody.(*FetchRequest)
+	res := &FetchResponse{Version: req.Version}
+	for i, value := range []string{"ok0", "bad1", "ok2"} {
+		if int64(i) >= req.blocks["my_topic"][0].fetchOffset {
+			res.AddRecordBatch("my_topic", 0, nil, StringEncoder(value), int64(i), 0, false)
+		}
+	}
+	if res.GetBlock("my_topic", 0) == nil {
+		res.AddError("my_topic", 0, ErrNoError)
+	}
+	res.GetBlock("my_topic", 0).HighWaterMarkOffset = 3
+
+	raw, _ := encode(res, nil)
+	if i := bytes.Index(raw, []byte("bad1")); i >= 0 {
+		raw[i] = 'B'
+	}
+	return &rawResponse{raw: raw, version: res.headerVersion()}
+}
+
+type rawResponse struct {
+	raw     []byte
+	version int16
+}
+
+func (r *rawResponse) encode(pe packetEncoder) error {
+	return pe.putRawBytes(r.raw)
+}
+
+func (r *rawResponse) headerVersion() int16 {
+	return r.version
+}
+
+func TestConsumerAdaptiveFetchSize(t *testing.T) {
+	for _, tc := range []struct {
+		name                string
+		fetchSize, max, min int32
+		size                int32
+		queued              int
+		expected            int32
+	}{
+		{name: "full fetch grows", fetchSize: 64 << 10, size: 60 << 10, expected: 128 << 10},
+		{name: "growth capped by max", fetchSize: 64 << 10, max: 100 << 10, size: 64 << 10, expected: 100 << 10},
+		{name: "no growth when the user lags", fetchSize: 64 << 10, size: 64 << 10, queued: 6, expected: 64 << 10},
+		{name: "half full fetch is kept", fetchSize: 64 << 10, size: 32 << 10, expected: 64 << 10},
+		{name: "mostly empty fetch shrinks", fetchSize: 64 << 10, size: 8 << 10, expected: 32 << 10},
+		{name: "shrink bounded by minimum", fetchSize: 6 << 10, size: 1 << 10, expected: minAdaptiveFetchSize},
+		{name: "shrink bounded by fetch min", fetchSize: 64 << 10, min: 40 << 10, size: 1 << 10, expected: 40 << 10},
+		{name: "small size never grows when shrinking", fetchSize: 1 << 10, size: 100, expected: 1 << 10},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			config := NewTestConfig()
+			config.Consumer.Fetch.Adaptive = true
+			config.Consumer.Fetch.Max = tc.max
+			config.Consumer.Fetch.Min = tc.min
+			child := &partitionConsumer{
+				conf:      config,
+				messages:  make(chan *ConsumerMessage, 10),
+				fetchSize: tc.fetchSize,
+			}
+			for i := 0; i < tc.queued; i++ {
+				child.messages <- &ConsumerMessage{}
+			}
+			child.adaptFetchSize(tc.size)
+			if child.fetchSize != tc.expected {
+				t.Errorf("expected a fetch size of %d, got %d", tc.expected, child.fetchSize)
+			}
+		})
+	}
+}
+
+func TestConsumerFetchPipeline(t *testing.T) {
+	for name, pipeline := range map[string]bool{"enabled": true, "disabled": false} {
+		pipeline := pipeline
+		t.Run(name, func(t *testing.T) {
+			fetchResponse1 := &FetchResponse{}
+			for offset := int64(0); offset < 3; offset++ {
+				fetchResponse1.AddMessage("my_topic", 0, nil, testMsg, offset)
+			}
+			fetchResponse2 := &FetchResponse{}
+			fetchResponse2.AddError("my_topic", 0, ErrNoError)
+
+			broker0 := NewMockBroker(t, 0)
+			defer broker0.Close()
+			broker0.SetHandlerByMap(map[string]MockResponse{
+				"MetadataRequest": NewMockMetadataResponse(t).
+					SetBroker(broker0.Addr(), broker0.BrokerID()).
+					SetLeader("my_topic", 0, broker0.BrokerID()),
+				"OffsetRequest": NewMockOffsetResponse(t).
+					SetOffset("my_topic", 0, OffsetOldest, 0).
+					SetOffset("my_topic", 0, OffsetNewest, 3),
+				"FetchRequest": NewMockSequence(fetchResponse1, fetchResponse2),
+			})
+
+			config := NewTestConfig()
+			config.ChannelBufferSize = 0
+			config.Consumer.Fetch.Pipeline = pipeline
+			master, err := NewConsumer([]string{broker0.Addr()}, config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer safeClose(t, master)
+			consumer, err := master.ConsumePartition("my_topic", 0, OffsetOldest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer safeClose(t, consumer)
+
+			fetches := func() []*FetchRequest {
+				var requests []*FetchRequest
+				for _, rr := range broker0.History() {
+					if request, ok := rr.Request.(*FetchRequest); ok {
+						requests = append(requests, request)
+					}
+				}
+				return requests
+			}
+
+			// the messages of the first response are not consumed yet
+			assertMessageOffset(t, <-consumer.Messages(), 0)
+			time.Sleep(100 * time.Millisecond)
+			requests := fetches()
+			if pipeline {
+				if len(requests) != 2 {
+					t.Fatalf("expected the second fetch to be sent ahead, got %d fetches", len(requests))
+				}
+				if offset := requests[1].blocks["my_topic"][0].fetchOffset; offset != 3 {
+					t.Errorf("expected the second fetch from offset 3, got %d", offset)
+				}
+			} else if len(requests) != 1 {
+				t.Fatalf("expected a single fetch while the messages are delivered, got %d", len(requests))
+			}
+
+			assertMessageOffset(t, <-consumer.Messages(), 1)
+			assertMessageOffset(t, <-consumer.Messages(), 2)
+		})
+	}
+}
+
+func TestConsumerCRCPolicies(t *testing.T) {
+	for _, policy := range []CRCPolicy{CRCFailPartition, CRCSkipBatch, CRCDeliver} {
+		t.Run(policy.String(), func(t *testing.T) {
+			broker0 := NewMockBroker(t, 0)
+			defer broker0.Close()
+			broker0.SetHandlerByMap(map[string]MockResponse{
+				"MetadataRequest": NewMockMetadataResponse(t).
+					SetBroker(broker0.Addr(), broker0.BrokerID()).
+					SetLeader("my_topic", 0, broker0.BrokerID()),
+				"OffsetRequest": NewMockOffsetResponse(t).
+					SetOffset("my_topic", 0, OffsetOldest, 0).
+					SetOffset("my_topic", 0, OffsetNewest, 3),
+				"FetchRequest": corruptFetchResponse{},
+			})
+
+			corrupted := make(chan *CorruptBatchError, 10)
+			config := NewTestConfig()
+			config.Version = V2_1_0_0
+			config.Consumer.Return.Errors = true
+			config.Consumer.CRC.Policy = policy
+			config.Consumer.CRC.OnCorruptBatch = func(err *CorruptBatchError) {
+				corrupted <- err
+			}
+			master, err := NewConsumer([]string{broker0.Addr()}, config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer safeClose(t, master)
+			consumer, err := master.ConsumePartition("my_topic", 0, OffsetOldest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer consumer.AsyncClose()
+
+			expected := map[CRCPolicy][]string{
+				CRCFailPartition: {"ok0"},
+				CRCSkipBatch:     {"ok0", "ok2"},
+				CRCDeliver:       {"ok0", "Bad1", "ok2"},
+			}[policy]
+			for _, value := range expected {
+				select {
+				case msg := <-consumer.Messages():
+					if string(msg.Value) != value {
+						t.Fatalf("expected value %q, got %q", value, msg.Value)
+					}
+					if msg.Corrupt != (value == "Bad1") {
+						t.Errorf("unexpected corrupt flag %v for %q", msg.Corrupt, msg.Value)
+					}
+				case err := <-consumer.Errors():
+					t.Fatal(err)
+				case <-time.After(time.Second):
+					t.Fatal("timed out waiting for", value)
+				}
+			}
+
+			select {
+			case err := <-corrupted:
+				if err.Topic != "my_topic" || err.Partition != 0 || err.Broker != 0 || err.FirstOffset != 1 || err.LastOffset != 1 {
+					t.Error("unexpected corrupt batch", err)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("expected the corrupt batch to be reported")
+			}
+
+			if policy == CRCFailPartition {
+				select {
+				case err := <-consumer.Errors():
+					var corrupt *CorruptBatchError
+					if !errors.As(err, &corrupt) || corrupt.FirstOffset != 1 {
+						t.Error("expected the partition to fail with the corrupt batch, got", err)
+					}
+				case <-time.After(time.Second):
+					t.Fatal("expected the partition to fail")
+				}
+			}
+		})
+	}
+}
+
 func assertMessageOffset(t *testing.T, msg *ConsumerMessage, expectedOffset int64) {
 	t.Helper()
 	if msg.Offset != expectedOffset {
@@ -21,6 +21,16 @@ type ControlRecord struct {
 	Type             ControlRecordType
 }
 
+// DecodeControlRecord decodes the control record held by record, a record
+// of a RecordBatch whose Control flag is set.
+func DecodeControlRecord(record *Record) (ControlRecord, error) {
+	var cr ControlRecord
+	if err := cr.decode(&realDecoder{raw: record.Key}, &realDecoder{raw: record.Value}); err != nil {
+		return ControlRecord{}, err
+	}
+	return cr, nil
+}
+
 func (cr *ControlRecord) decode(key, value packetDecoder) error {
 	var err error
 	// There a version for the value part AND the key part. And I have no idea if they are supposed to match or not
@@ -0,0 +1,60 @@
+package sarama
+
+import "fmt"
+
+// CRCPolicy is how the consumers handle the record batches whose CRC does not
+// match their content, as set by Config.Consumer.CRC.Policy.
+type CRCPolicy int
+
+const (
+	// CRCFailPartition delivers the messages preceding a corrupt batch, then
+	// shuts the partition consumer down with a *CorruptBatchError, so that
+	// the user chooses the offset to resume from.
+	CRCFailPartition CRCPolicy = iota
+	// CRCSkipBatch skips the corrupt batches, without any error, and resumes
+	// from the offset following them.
+	CRCSkipBatch
+	// CRCDeliver delivers the messages of the corrupt batches with their
+	// Corrupt flag set. The batches whose records can not be decoded are
+	// skipped.
+	CRCDeliver
+)
+
+func (p CRCPolicy) String() string {
+	switch p {
+	case CRCFailPartition:
+		return "FailPartition"
+	case CRCSkipBatch:
+		return "SkipBatch"
+	case CRCDeliver:
+		return "Deliver"
+	default:
+		return fmt.Sprintf("CRCPolicy(%d)", int(p))
+	}
+}
+
+// CorruptBatchError describes a record batch whose CRC does not match its
+// content. It is passed to Config.Consumer.CRC.OnCorruptBatch, and returned
+// by the partition consumers with the CRCFailPartition policy.
+type CorruptBatchError struct {
+	Topic     string
+	Partition int32
+	// Broker is the ID of the broker the batch was fetched from, or -1 if
+	// the partition consumer was moving to another broker.
+	Broker int32
+	// FirstOffset and LastOffset are the offsets of the first and last
+	// records of the batch. LastOffset is read from the corrupt part of the
+	// batch, so it may be wrong.
+	FirstOffset, LastOffset int64
+	// Err is the CRC mismatch.
+	Err error
+}
+
+func (e *CorruptBatchError) Error() string {
+	return fmt.Sprintf("kafka: corrupt record batch at offsets %d-%d of %s/%d fetched from broker %d: %v",
+		e.FirstOffset, e.LastOffset, e.Topic, e.Partition, e.Broker, e.Err)
+}
+
+func (e *CorruptBatchError) Unwrap() error {
+	return e.Err
+}
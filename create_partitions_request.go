@@ -118,7 +118,7 @@ func (t *TopicPartition) decode(pd packetDecoder, version int16) (err error) {
 		return err
 	}
 
-	n, err := pd.getInt32()
+	n, err := pd.getNullableArrayLength()
 	if err != nil {
 		return err
 	}
@@ -127,7 +127,7 @@ func (t *TopicPartition) decode(pd packetDecoder, version int16) (err error) {
 	}
 	t.Assignment = make([][]int32, n)
 
-	for i := 0; i < int(n); i++ {
+	for i := 0; i < n; i++ {
 		if t.Assignment[i], err = pd.getInt32Array(); err != nil {
 			return err
 		}
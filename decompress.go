@@ -93,6 +93,9 @@ func decompress(cc CompressionCodec, data []byte) ([]byte, error) {
 
 		return res, err
 	default:
+		if codec := registeredCompressionCodec(cc); codec != nil {
+			return codec.dec(data)
+		}
 		return nil, PacketDecodingError{fmt.Sprintf("invalid compression specified (%d)", cc)}
 	}
 }
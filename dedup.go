@@ -0,0 +1,220 @@
+package sarama
+
+import (
+	"container/list"
+	"context"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DedupStore records the IDs of the messages processed by a consumer, so that
+// the messages delivered again, because they were produced twice or consumed
+// again after a rebalance or a restart, are processed only once. Implementations
+// backed by a shared or persistent store, such as Redis or an embedded database,
+// make the processing effectively-once against sinks which are not
+// transactional.
+type DedupStore interface {
+	// Seen reports whether the message of the given ID was processed.
+	Seen(ctx context.Context, id string) (bool, error)
+	// Mark records that the message of the given ID was processed.
+	Mark(ctx context.Context, id string) error
+}
+
+// MessageIDFunc returns the ID of a message for deduplication, or false if the
+// message has none, in which case it is processed without deduplication.
+type MessageIDFunc func(msg *ConsumerMessage) (string, bool)
+
+// OffsetMessageID identifies the messages by topic, partition and offset, which
+// deduplicates the messages consumed again after a rebalance or a restart.
+func OffsetMessageID(msg *ConsumerMessage) (string, bool) {
+	return msg.Topic + "/" + strconv.Itoa(int(msg.Partition)) + "/" + strconv.FormatInt(msg.Offset, 10), true
+}
+
+// HeaderMessageID identifies the messages by the value of the given header, set
+// by their producers, which also deduplicates the messages produced twice.
+func HeaderMessageID(header string) MessageIDFunc {
+	return func(msg *ConsumerMessage) (string, bool) {
+		for _, h := range msg.Headers {
+			if h != nil && string(h.Key) == header && len(h.Value) > 0 {
+				return string(h.Value), true
+			}
+		}
+		return "", false
+	}
+}
+
+// NewDedupConsumerGroupHandler wraps handler so that it only receives the
+// messages whose ID, as returned by id, is not in store yet. The ID of a message
+// is recorded when the handler marks it using ConsumerGroupSession.MarkMessage,
+// so messages must be marked once processed, and not before. The duplicates are
+// marked, without being passed to the handler. A nil id defaults to
+// OffsetMessageID.
+//
+// If the store fails, the message is passed to the handler, falling back to
+// at-least-once processing, and the error is logged.
+func NewDedupConsumerGroupHandler(handler ConsumerGroupHandler, store DedupStore, id MessageIDFunc) ConsumerGroupHandler {
+	if id == nil {
+		id = OffsetMessageID
+	}
+	return &dedupHandler{handler: handler, store: store, id: id}
+}
+
+type dedupHandler struct {
+	handler ConsumerGroupHandler
+	store   DedupStore
+	id      MessageIDFunc
+}
+
+func (h *dedupHandler) Setup(session ConsumerGroupSession) error {
+	return h.handler.Setup(&dedupSession{ConsumerGroupSession: session, handler: h})
+}
+
+func (h *dedupHandler) Cleanup(session ConsumerGroupSession) error {
+	return h.handler.Cleanup(&dedupSession{ConsumerGroupSession: session, handler: h})
+}
+
+func (h *dedupHandler) ConsumeClaim(session ConsumerGroupSession, claim ConsumerGroupClaim) error {
+	filtered := &dedupClaim{
+		ConsumerGroupClaim: claim,
+		messages:           make(chan *ConsumerMessage),
+	}
+	done := make(chan none)
+	defer close(done)
+
+	go withRecover(func() {
+		defer close(filtered.messages)
+		ctx := session.Context()
+		for msg := range claim.Messages() {
+			if h.seen(ctx, msg) {
+				session.MarkMessage(msg, "")
+				continue
+			}
+			select {
+			case filtered.messages <- msg:
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	})
+
+	return h.handler.ConsumeClaim(&dedupSession{ConsumerGroupSession: session, handler: h}, filtered)
+}
+
+func (h *dedupHandler) seen(ctx context.Context, msg *ConsumerMessage) bool {
+	id, ok := h.id(msg)
+	if !ok {
+		return false
+	}
+	seen, err := h.store.Seen(ctx, id)
+	if err != nil {
+		Logger.Printf("consumer/dedup failed to look up message %s: %v\n", id, err)
+		return false
+	}
+	return seen
+}
+
+// dedupSession records the IDs of the messages marked.
+type dedupSession struct {
+	ConsumerGroupSession
+	handler *dedupHandler
+}
+
+func (s *dedupSession) MarkMessage(msg *ConsumerMessage, metadata string) {
+	if id, ok := s.handler.id(msg); ok {
+		if err := s.handler.store.Mark(s.Context(), id); err != nil {
+			Logger.Printf("consumer/dedup failed to record message %s: %v\n", id, err)
+		}
+	}
+	s.ConsumerGroupSession.MarkMessage(msg, metadata)
+}
+
+// dedupClaim returns the messages which are not duplicates.
+type dedupClaim struct {
+	ConsumerGroupClaim
+	messages chan *ConsumerMessage
+}
+
+func (c *dedupClaim) Messages() <-chan *ConsumerMessage {
+	return c.messages
+}
+
+// MemoryDedupStore is a DedupStore keeping the IDs of the last messages
+// processed in memory, evicting the least recently used ones once it holds
+// its capacity, and the ones older than its TTL, if any. It deduplicates the
+// messages consumed again by the same process only.
+type MemoryDedupStore struct {
+	lock     sync.Mutex
+	capacity int
+	ttl      time.Duration
+	ids      map[string]*list.Element
+	lru      *list.List
+}
+
+type memoryDedupEntry struct {
+	id     string
+	marked time.Time
+}
+
+// NewMemoryDedupStore returns a MemoryDedupStore holding up to capacity IDs
+// for up to ttl, or forever if ttl is 0.
+func NewMemoryDedupStore(capacity int, ttl time.Duration) *MemoryDedupStore {
+	return &MemoryDedupStore{
+		capacity: capacity,
+		ttl:      ttl,
+		ids:      make(map[string]*list.Element),
+		lru:      list.New(),
+	}
+}
+
+// Seen implements DedupStore.
+func (s *MemoryDedupStore) Seen(_ context.Context, id string) (bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	e, ok := s.ids[id]
+	if !ok {
+		return false, nil
+	}
+	if s.expired(e) {
+		s.remove(e)
+		return false, nil
+	}
+	s.lru.MoveToFront(e)
+	return true, nil
+}
+
+// Mark implements DedupStore.
+func (s *MemoryDedupStore) Mark(_ context.Context, id string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if e, ok := s.ids[id]; ok {
+		e.Value.(*memoryDedupEntry).marked = time.Now()
+		s.lru.MoveToFront(e)
+		return nil
+	}
+	s.ids[id] = s.lru.PushFront(&memoryDedupEntry{id: id, marked: time.Now()})
+	for s.lru.Len() > s.capacity {
+		s.remove(s.lru.Back())
+	}
+	return nil
+}
+
+// Len returns the number of IDs held by the store.
+func (s *MemoryDedupStore) Len() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.lru.Len()
+}
+
+func (s *MemoryDedupStore) expired(e *list.Element) bool {
+	return s.ttl > 0 && time.Since(e.Value.(*memoryDedupEntry).marked) > s.ttl
+}
+
+func (s *MemoryDedupStore) remove(e *list.Element) {
+	s.lru.Remove(e)
+	delete(s.ids, e.Value.(*memoryDedupEntry).id)
+}
@@ -0,0 +1,166 @@
+package sarama
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testDedupSession struct {
+	ConsumerGroupSession
+	ctx    context.Context
+	lock   sync.Mutex
+	marked []int64
+}
+
+func (s *testDedupSession) Context() context.Context {
+	return s.ctx
+}
+
+func (s *testDedupSession) MarkMessage(msg *ConsumerMessage, _ string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.marked = append(s.marked, msg.Offset)
+}
+
+func (s *testDedupSession) Marked() []int64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]int64(nil), s.marked...)
+}
+
+type testDedupClaim struct {
+	ConsumerGroupClaim
+	messages chan *ConsumerMessage
+}
+
+func (c *testDedupClaim) Messages() <-chan *ConsumerMessage {
+	return c.messages
+}
+
+type testDedupHandler struct {
+	processed []int64
+}
+
+func (h *testDedupHandler) Setup(ConsumerGroupSession) error   { return nil }
+func (h *testDedupHandler) Cleanup(ConsumerGroupSession) error { return nil }
+
+func (h *testDedupHandler) ConsumeClaim(session ConsumerGroupSession, claim ConsumerGroupClaim) error {
+	for msg := range claim.Messages() {
+		h.processed = append(h.processed, msg.Offset)
+		session.MarkMessage(msg, "")
+	}
+	return nil
+}
+
+func consumeDedupClaim(t *testing.T, handler ConsumerGroupHandler, msgs ...*ConsumerMessage) *testDedupSession {
+	t.Helper()
+	session := &testDedupSession{ctx: context.Background()}
+	claim := &testDedupClaim{messages: make(chan *ConsumerMessage, len(msgs))}
+	for _, msg := range msgs {
+		claim.messages <- msg
+	}
+	close(claim.messages)
+	if err := handler.ConsumeClaim(session, claim); err != nil {
+		t.Fatal(err)
+	}
+	return session
+}
+
+func TestDedupConsumerGroupHandler(t *testing.T) {
+	store := NewMemoryDedupStore(100, 0)
+	inner := &testDedupHandler{}
+	handler := NewDedupConsumerGroupHandler(inner, store, HeaderMessageID("id"))
+
+	withID := func(offset int64, id string) *ConsumerMessage {
+		msg := &ConsumerMessage{Topic: "my_topic", Offset: offset}
+		if id != "" {
+			msg.Headers = []*RecordHeader{{Key: []byte("id"), Value: []byte(id)}}
+		}
+		return msg
+	}
+
+	// The duplicates of a batch are skipped, and the messages without ID
+	// are processed.
+	session := consumeDedupClaim(t, handler, withID(0, "a"), withID(1, "b"), withID(2, "a"), withID(3, ""), withID(4, ""))
+	if expected := []int64{0, 1, 3, 4}; !reflect.DeepEqual(inner.processed, expected) {
+		t.Errorf("expected offsets %v to be processed, got %v", expected, inner.processed)
+	}
+	if expected := []int64{0, 1, 2, 3, 4}; !reflect.DeepEqual(session.Marked(), expected) {
+		t.Errorf("expected offsets %v to be marked, got %v", expected, session.Marked())
+	}
+
+	// The messages processed are skipped when consumed again.
+	inner.processed = nil
+	consumeDedupClaim(t, handler, withID(5, "b"), withID(6, "c"))
+	if expected := []int64{6}; !reflect.DeepEqual(inner.processed, expected) {
+		t.Errorf("expected offsets %v to be processed, got %v", expected, inner.processed)
+	}
+	if store.Len() != 3 {
+		t.Error("expected 3 IDs to be stored, got", store.Len())
+	}
+}
+
+type failingDedupStore struct{}
+
+func (failingDedupStore) Seen(context.Context, string) (bool, error) {
+	return false, errors.New("down")
+}
+
+func (failingDedupStore) Mark(context.Context, string) error {
+	return errors.New("down")
+}
+
+func TestDedupConsumerGroupHandlerStoreFailure(t *testing.T) {
+	inner := &testDedupHandler{}
+	handler := NewDedupConsumerGroupHandler(inner, failingDedupStore{}, nil)
+	msg := &ConsumerMessage{Topic: "my_topic", Offset: 1}
+	consumeDedupClaim(t, handler, msg, msg)
+	if expected := []int64{1, 1}; !reflect.DeepEqual(inner.processed, expected) {
+		t.Errorf("expected the messages to be processed at least once, got %v", inner.processed)
+	}
+}
+
+func TestMessageIDs(t *testing.T) {
+	msg := &ConsumerMessage{Topic: "my_topic", Partition: 3, Offset: 42}
+	if id, ok := OffsetMessageID(msg); !ok || id != "my_topic/3/42" {
+		t.Error("unexpected offset ID", id, ok)
+	}
+	if _, ok := HeaderMessageID("id")(msg); ok {
+		t.Error("expected no ID without header")
+	}
+	msg.Headers = []*RecordHeader{{Key: []byte("other"), Value: []byte("x")}, {Key: []byte("id"), Value: []byte("abc")}}
+	if id, ok := HeaderMessageID("id")(msg); !ok || id != "abc" {
+		t.Error("unexpected header ID", id, ok)
+	}
+}
+
+func TestMemoryDedupStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryDedupStore(2, 0)
+	for _, id := range []string{"a", "b"} {
+		if err := store.Mark(ctx, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Looking up "a" makes "b" the least recently used ID.
+	if seen, _ := store.Seen(ctx, "a"); !seen {
+		t.Error("expected a to be seen")
+	}
+	_ = store.Mark(ctx, "c")
+	for id, expected := range map[string]bool{"a": true, "b": false, "c": true} {
+		if seen, _ := store.Seen(ctx, id); seen != expected {
+			t.Errorf("expected %s to be seen: %v", id, expected)
+		}
+	}
+
+	store = NewMemoryDedupStore(10, 10*time.Millisecond)
+	_ = store.Mark(ctx, "a")
+	time.Sleep(20 * time.Millisecond)
+	if seen, _ := store.Seen(ctx, "a"); seen || store.Len() != 0 {
+		t.Error("expected a to have expired")
+	}
+}
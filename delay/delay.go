@@ -0,0 +1,206 @@
+/*
+Package delay implements the delay queue pattern on Kafka: messages to deliver
+later are produced to a delay topic, from which a relay forwards them to their
+destination topic once they are due.
+
+Schedule, or NewMessage for the asynchronous producers, rewrites a message to
+the delay topic, recording its destination topic and the time it is due in its
+headers:
+
+	scheduler := delay.NewScheduler(producer, "delayed")
+	_, _, err := scheduler.Schedule(&sarama.ProducerMessage{
+		Topic: "reminders",
+		Value: sarama.StringEncoder("call back"),
+	}, time.Now().Add(time.Hour))
+
+The Relay is a ConsumerGroupHandler consuming the delay topic, which waits for
+each message to be due before producing it to its destination, without the
+headers of the delay queue:
+
+	err := group.Consume(ctx, []string{"delayed"}, delay.NewRelay(producer))
+
+A message is marked once forwarded, so the messages not forwarded yet when the
+relay stops, or fails to forward them, are forwarded after the relay restarts.
+They are forwarded at least once. The messages of a partition are forwarded in
+order, so a message is not forwarded before the ones preceding it in the delay
+topic: use a delay topic per delay, such as "delayed-1m" and "delayed-1h", for
+the messages to be forwarded on time.
+
+The messages and their headers require Kafka 0.11 or later, so Config.Version
+must be at least V0_11_0_0.
+
+NOTE: this package currently does not fall under the API stability
+guarantee of Sarama as it is still considered experimental.
+*/
+package delay
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+const (
+	// DeliverNotBeforeHeader is the header holding the time a message is due,
+	// in milliseconds since the epoch.
+	DeliverNotBeforeHeader = "deliver-not-before"
+	// DestinationHeader is the header holding the destination topic of a
+	// message.
+	DestinationHeader = "delay-destination"
+)
+
+// NewMessage returns a copy of msg to produce to delayTopic, to be forwarded
+// to the topic of msg at the given time. The partition of msg is not kept: the
+// forwarded message is partitioned by the producer of the relay.
+func NewMessage(msg *sarama.ProducerMessage, delayTopic string, at time.Time) *sarama.ProducerMessage {
+	delayed := &sarama.ProducerMessage{
+		Topic:    delayTopic,
+		Key:      msg.Key,
+		Value:    msg.Value,
+		Metadata: msg.Metadata,
+	}
+	for _, h := range msg.Headers {
+		if !isDelayHeader(h.Key) {
+			delayed.Headers = append(delayed.Headers, h)
+		}
+	}
+	delayed.Headers = append(delayed.Headers,
+		sarama.RecordHeader{Key: []byte(DestinationHeader), Value: []byte(msg.Topic)},
+		sarama.RecordHeader{Key: []byte(DeliverNotBeforeHeader), Value: []byte(strconv.FormatInt(at.UnixNano()/int64(time.Millisecond), 10))},
+	)
+	return delayed
+}
+
+// Scheduler produces the messages to deliver later to a delay topic.
+type Scheduler struct {
+	producer sarama.SyncProducer
+	topic    string
+}
+
+// NewScheduler returns a Scheduler producing to delayTopic using producer.
+func NewScheduler(producer sarama.SyncProducer, delayTopic string) *Scheduler {
+	return &Scheduler{producer: producer, topic: delayTopic}
+}
+
+// Schedule produces msg to the delay topic, to be forwarded to its topic at
+// the given time. It returns the partition and the offset of the message in
+// the delay topic.
+func (s *Scheduler) Schedule(msg *sarama.ProducerMessage, at time.Time) (partition int32, offset int64, err error) {
+	return s.producer.SendMessage(NewMessage(msg, s.topic, at))
+}
+
+// ScheduleAfter produces msg to the delay topic, to be forwarded to its topic
+// after the given delay.
+func (s *Scheduler) ScheduleAfter(msg *sarama.ProducerMessage, d time.Duration) (partition int32, offset int64, err error) {
+	return s.Schedule(msg, time.Now().Add(d))
+}
+
+// errInvalidMessage is wrapped by the errors of the messages of the delay
+// topic missing the headers of the delay queue.
+var errInvalidMessage = errors.New("delay: invalid delayed message")
+
+// parse returns the destination and the time a message is due.
+func parse(msg *sarama.ConsumerMessage) (string, time.Time, error) {
+	var destination, due string
+	for _, h := range msg.Headers {
+		switch string(h.Key) {
+		case DestinationHeader:
+			destination = string(h.Value)
+		case DeliverNotBeforeHeader:
+			due = string(h.Value)
+		}
+	}
+	if destination == "" {
+		return "", time.Time{}, fmt.Errorf("%w: missing %s header", errInvalidMessage, DestinationHeader)
+	}
+	ms, err := strconv.ParseInt(due, 10, 64)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("%w: invalid %s header %q", errInvalidMessage, DeliverNotBeforeHeader, due)
+	}
+	return destination, time.Unix(0, ms*int64(time.Millisecond)), nil
+}
+
+// Relay is a ConsumerGroupHandler forwarding the messages of the delay topics
+// it consumes to their destination once they are due.
+type Relay struct {
+	producer sarama.SyncProducer
+}
+
+// NewRelay returns a Relay forwarding the messages using producer.
+func NewRelay(producer sarama.SyncProducer) *Relay {
+	return &Relay{producer: producer}
+}
+
+// Setup implements sarama.ConsumerGroupHandler.
+func (r *Relay) Setup(sarama.ConsumerGroupSession) error {
+	return nil
+}
+
+// Cleanup implements sarama.ConsumerGroupHandler.
+func (r *Relay) Cleanup(sarama.ConsumerGroupSession) error {
+	return nil
+}
+
+// ConsumeClaim implements sarama.ConsumerGroupHandler. It returns once the
+// session ends, or a message fails to be forwarded. The messages missing the
+// headers of the delay queue are logged and skipped.
+func (r *Relay) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	ctx := session.Context()
+	for {
+		var msg *sarama.ConsumerMessage
+		select {
+		case msg = <-claim.Messages():
+			if msg == nil {
+				return nil
+			}
+		case <-ctx.Done():
+			return nil
+		}
+
+		destination, due, err := parse(msg)
+		if err != nil {
+			sarama.Logger.Printf("delay/relay skipping message %s/%d/%d: %v\n", msg.Topic, msg.Partition, msg.Offset, err)
+			session.MarkMessage(msg, "")
+			continue
+		}
+
+		if wait := time.Until(due); wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return nil
+			}
+		}
+
+		if _, _, err := r.producer.SendMessage(forwarded(msg, destination)); err != nil {
+			return fmt.Errorf("delay: failed to forward message %s/%d/%d to %s: %w", msg.Topic, msg.Partition, msg.Offset, destination, err)
+		}
+		session.MarkMessage(msg, "")
+	}
+}
+
+// forwarded returns the message to produce to the destination of msg.
+func forwarded(msg *sarama.ConsumerMessage, destination string) *sarama.ProducerMessage {
+	out := &sarama.ProducerMessage{Topic: destination}
+	if msg.Key != nil {
+		out.Key = sarama.ByteEncoder(msg.Key)
+	}
+	if msg.Value != nil {
+		out.Value = sarama.ByteEncoder(msg.Value)
+	}
+	for _, h := range msg.Headers {
+		if !isDelayHeader(h.Key) {
+			out.Headers = append(out.Headers, *h)
+		}
+	}
+	return out
+}
+
+func isDelayHeader(key []byte) bool {
+	return string(key) == DestinationHeader || string(key) == DeliverNotBeforeHeader
+}
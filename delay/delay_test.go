@@ -0,0 +1,209 @@
+package delay
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+)
+
+type testSession struct {
+	sarama.ConsumerGroupSession
+	ctx    context.Context
+	lock   sync.Mutex
+	marked []int64
+}
+
+func (s *testSession) Context() context.Context {
+	return s.ctx
+}
+
+func (s *testSession) MarkMessage(msg *sarama.ConsumerMessage, _ string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.marked = append(s.marked, msg.Offset)
+}
+
+func (s *testSession) Marked() []int64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]int64(nil), s.marked...)
+}
+
+type testClaim struct {
+	sarama.ConsumerGroupClaim
+	messages chan *sarama.ConsumerMessage
+}
+
+func (c *testClaim) Messages() <-chan *sarama.ConsumerMessage {
+	return c.messages
+}
+
+// consume runs relay on a claim of msgs until ctx is done, or all the messages
+// are consumed.
+func consume(ctx context.Context, relay *Relay, msgs ...*sarama.ConsumerMessage) (*testSession, error) {
+	session := &testSession{ctx: ctx}
+	claim := &testClaim{messages: make(chan *sarama.ConsumerMessage, len(msgs))}
+	for _, msg := range msgs {
+		claim.messages <- msg
+	}
+	close(claim.messages)
+	return session, relay.ConsumeClaim(session, claim)
+}
+
+// delayed returns the message of the delay topic at offset, produced by a
+// Scheduler to be forwarded to my_topic at the given time.
+func delayed(t *testing.T, offset int64, at time.Time) *sarama.ConsumerMessage {
+	t.Helper()
+	producer := mocks.NewSyncProducer(t, nil)
+	producer.ExpectSendMessageAndSucceed()
+	_, _, err := NewScheduler(producer, "delayed").Schedule(&sarama.ProducerMessage{
+		Topic:   "my_topic",
+		Key:     sarama.StringEncoder("key"),
+		Value:   sarama.StringEncoder("value"),
+		Headers: []sarama.RecordHeader{{Key: []byte("trace"), Value: []byte("abc")}},
+	}, at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := producer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	produced := producer.Messages()[0]
+	if produced.Topic != "delayed" {
+		t.Fatal("expected the message to be produced to the delay topic, got", produced.Topic)
+	}
+	msg := &sarama.ConsumerMessage{Topic: produced.Topic, Offset: offset}
+	msg.Key, _ = produced.Key.Encode()
+	msg.Value, _ = produced.Value.Encode()
+	for i := range produced.Headers {
+		msg.Headers = append(msg.Headers, &produced.Headers[i])
+	}
+	return msg
+}
+
+func checkForwarded(t *testing.T, producer *mocks.SyncProducer, count int) {
+	t.Helper()
+	msgs := producer.Messages()
+	if len(msgs) != count {
+		t.Fatalf("expected %d messages to be forwarded, got %d", count, len(msgs))
+	}
+	for _, msg := range msgs {
+		value, _ := msg.Value.Encode()
+		if msg.Topic != "my_topic" || string(value) != "value" {
+			t.Errorf("expected the message to be forwarded to my_topic, got %s %q", msg.Topic, value)
+		}
+		expected := []sarama.RecordHeader{{Key: []byte("trace"), Value: []byte("abc")}}
+		if !reflect.DeepEqual(msg.Headers, expected) {
+			t.Errorf("expected the delay headers to be removed, got %v", msg.Headers)
+		}
+	}
+}
+
+func TestRelayForwardsWhenDue(t *testing.T) {
+	producer := mocks.NewSyncProducer(t, nil)
+	producer.ExpectSendMessageAndSucceed().ExpectSendMessageAndSucceed()
+	defer producer.Close()
+
+	start := time.Now()
+	session, err := consume(context.Background(), NewRelay(producer),
+		delayed(t, 0, start.Add(-time.Minute)),
+		delayed(t, 1, start.Add(50*time.Millisecond)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Error("expected the second message to be forwarded once due, got", elapsed)
+	}
+	checkForwarded(t, producer, 2)
+	if expected := []int64{0, 1}; !reflect.DeepEqual(session.Marked(), expected) {
+		t.Errorf("expected offsets %v to be marked, got %v", expected, session.Marked())
+	}
+}
+
+func TestRelaySkipsInvalidMessages(t *testing.T) {
+	producer := mocks.NewSyncProducer(t, nil)
+	defer producer.Close()
+
+	invalid := delayed(t, 1, time.Now())
+	invalid.Headers[len(invalid.Headers)-1].Value = []byte("soon")
+	session, err := consume(context.Background(), NewRelay(producer),
+		&sarama.ConsumerMessage{Topic: "delayed", Offset: 0}, invalid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkForwarded(t, producer, 0)
+	if expected := []int64{0, 1}; !reflect.DeepEqual(session.Marked(), expected) {
+		t.Errorf("expected offsets %v to be marked, got %v", expected, session.Marked())
+	}
+}
+
+func TestRelayRedeliversAcrossRestarts(t *testing.T) {
+	msg := delayed(t, 0, time.Now().Add(100*time.Millisecond))
+
+	// The relay stops before the message is due: it is not marked, so it is
+	// consumed again once the relay restarts.
+	producer := mocks.NewSyncProducer(t, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	session, err := consume(ctx, NewRelay(producer), msg)
+	cancel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkForwarded(t, producer, 0)
+	if len(session.Marked()) != 0 {
+		t.Error("expected the message not to be marked, got", session.Marked())
+	}
+	if err := producer.Close(); err != nil {
+		t.Error(err)
+	}
+
+	// The restarted relay fails to forward it: it is not marked either.
+	producer = mocks.NewSyncProducer(t, nil)
+	producer.ExpectSendMessageAndFail(sarama.ErrOutOfBrokers)
+	session, err = consume(context.Background(), NewRelay(producer), msg)
+	if !errors.Is(err, sarama.ErrOutOfBrokers) {
+		t.Error("expected the produce error to be returned, got", err)
+	}
+	if len(session.Marked()) != 0 {
+		t.Error("expected the message not to be marked, got", session.Marked())
+	}
+	if err := producer.Close(); err != nil {
+		t.Error(err)
+	}
+
+	// The next relay forwards it.
+	producer = mocks.NewSyncProducer(t, nil)
+	producer.ExpectSendMessageAndSucceed()
+	defer producer.Close()
+	session, err = consume(context.Background(), NewRelay(producer), msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkForwarded(t, producer, 1)
+	if expected := []int64{0}; !reflect.DeepEqual(session.Marked(), expected) {
+		t.Errorf("expected offsets %v to be marked, got %v", expected, session.Marked())
+	}
+}
+
+func TestNewMessage(t *testing.T) {
+	at := time.Unix(1700000000, 123456789)
+	msg := NewMessage(&sarama.ProducerMessage{
+		Topic:   "my_topic",
+		Headers: []sarama.RecordHeader{{Key: []byte(DeliverNotBeforeHeader), Value: []byte("0")}},
+	}, "delayed", at)
+	expected := []sarama.RecordHeader{
+		{Key: []byte(DestinationHeader), Value: []byte("my_topic")},
+		{Key: []byte(DeliverNotBeforeHeader), Value: []byte(strconv.FormatInt(1700000000123, 10))},
+	}
+	if msg.Topic != "delayed" || !reflect.DeepEqual(msg.Headers, expected) {
+		t.Errorf("unexpected delayed message %s %v", msg.Topic, msg.Headers)
+	}
+}
@@ -39,7 +39,7 @@ func (r *DeleteOffsetsRequest) decode(pd packetDecoder, version int16) (err erro
 	}
 	var partitionCount int
 
-	partitionCount, err = pd.getArrayLength()
+	partitionCount, err = pd.getNullableArrayLength()
 	if err != nil {
 		return err
 	}
@@ -4,6 +4,9 @@ type DescribeConfigsRequest struct {
 	Version         int16
 	Resources       []*ConfigResource
 	IncludeSynonyms bool
+	// IncludeDocumentation requests the documentation of the configs, from
+	// version 3.
+	IncludeDocumentation bool
 }
 
 type ConfigResource struct {
@@ -36,6 +39,10 @@ func (r *DescribeConfigsRequest) encode(pe packetEncoder) error {
 		pe.putBool(r.IncludeSynonyms)
 	}
 
+	if r.Version >= 3 {
+		pe.putBool(r.IncludeDocumentation)
+	}
+
 	return nil
 }
 
@@ -60,7 +67,7 @@ func (r *DescribeConfigsRequest) decode(pd packetDecoder, version int16) (err er
 		}
 		r.Resources[i].Name = name
 
-		confLength, err := pd.getArrayLength()
+		confLength, err := pd.getNullableArrayLength()
 		if err != nil {
 			return err
 		}
@@ -87,6 +94,13 @@ func (r *DescribeConfigsRequest) decode(pd packetDecoder, version int16) (err er
 		}
 		r.IncludeSynonyms = b
 	}
+	if r.Version >= 3 {
+		b, err := pd.getBool()
+		if err != nil {
+			return err
+		}
+		r.IncludeDocumentation = b
+	}
 
 	return nil
 }
@@ -104,11 +118,13 @@ func (r *DescribeConfigsRequest) headerVersion() int16 {
 }
 
 func (r *DescribeConfigsRequest) isValidVersion() bool {
-	return r.Version >= 0 && r.Version <= 2
+	return r.Version >= 0 && r.Version <= 3
 }
 
 func (r *DescribeConfigsRequest) requiredVersion() KafkaVersion {
 	switch r.Version {
+	case 3:
+		return V2_6_0_0
 	case 2:
 		return V2_0_0_0
 	case 1:
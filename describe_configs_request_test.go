@@ -46,6 +46,15 @@ var (
 		255, 255, 255, 255, // no configs
 		1, // synonyms
 	}
+
+	singleDescribeConfigsRequestAllConfigsv3 = []byte{
+		0, 0, 0, 1, // 1 config
+		2,                   // a topic
+		0, 3, 'f', 'o', 'o', // topic name: foo
+		255, 255, 255, 255, // no configs
+		1, // synonyms
+		1, // documentation
+	}
 )
 
 func TestDescribeConfigsRequestv0(t *testing.T) {
@@ -115,3 +124,19 @@ func TestDescribeConfigsRequestv1(t *testing.T) {
 
 	testRequest(t, "one topic, all configs", request, singleDescribeConfigsRequestAllConfigsv1)
 }
+
+func TestDescribeConfigsRequestv3(t *testing.T) {
+	request := &DescribeConfigsRequest{
+		Version: 3,
+		Resources: []*ConfigResource{
+			{
+				Type: TopicResource,
+				Name: "foo",
+			},
+		},
+		IncludeSynonyms:      true,
+		IncludeDocumentation: true,
+	}
+
+	testRequest(t, "one topic, all configs", request, singleDescribeConfigsRequestAllConfigsv3)
+}
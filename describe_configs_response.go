@@ -21,6 +21,8 @@ func (s ConfigSource) String() string {
 		return "StaticBroker"
 	case SourceDefault:
 		return "Default"
+	case SourceDynamicBrokerLogger:
+		return "DynamicBrokerLogger"
 	}
 	return fmt.Sprintf("Source Invalid: %d", int(s))
 }
@@ -32,6 +34,50 @@ const (
 	SourceDynamicDefaultBroker
 	SourceStaticBroker
 	SourceDefault
+	SourceDynamicBrokerLogger
+)
+
+// ConfigType is the type of the value of a config, as returned by
+// DescribeConfigs from version 3.
+type ConfigType int8
+
+func (t ConfigType) String() string {
+	switch t {
+	case ConfigTypeUnknown:
+		return "Unknown"
+	case ConfigTypeBoolean:
+		return "Boolean"
+	case ConfigTypeString:
+		return "String"
+	case ConfigTypeInt:
+		return "Int"
+	case ConfigTypeShort:
+		return "Short"
+	case ConfigTypeLong:
+		return "Long"
+	case ConfigTypeDouble:
+		return "Double"
+	case ConfigTypeList:
+		return "List"
+	case ConfigTypeClass:
+		return "Class"
+	case ConfigTypePassword:
+		return "Password"
+	}
+	return fmt.Sprintf("Type Invalid: %d", int(t))
+}
+
+const (
+	ConfigTypeUnknown ConfigType = iota
+	ConfigTypeBoolean
+	ConfigTypeString
+	ConfigTypeInt
+	ConfigTypeShort
+	ConfigTypeLong
+	ConfigTypeDouble
+	ConfigTypeList
+	ConfigTypeClass
+	ConfigTypePassword
 )
 
 type DescribeConfigsResponse struct {
@@ -56,6 +102,11 @@ type ConfigEntry struct {
 	Source    ConfigSource
 	Sensitive bool
 	Synonyms  []*ConfigSynonym
+	// Type and Documentation are the type and the documentation of the
+	// config, from version 3. The documentation is only returned when
+	// requested with IncludeDocumentation.
+	Type          ConfigType
+	Documentation string
 }
 
 type ConfigSynonym struct {
@@ -117,11 +168,13 @@ func (r *DescribeConfigsResponse) headerVersion() int16 {
 }
 
 func (r *DescribeConfigsResponse) isValidVersion() bool {
-	return r.Version >= 0 && r.Version <= 2
+	return r.Version >= 0 && r.Version <= 3
 }
 
 func (r *DescribeConfigsResponse) requiredVersion() KafkaVersion {
 	switch r.Version {
+	case 3:
+		return V2_6_0_0
 	case 2:
 		return V2_0_0_0
 	case 1:
@@ -231,6 +284,13 @@ func (r *ConfigEntry) encode(pe packetEncoder, version int16) (err error) {
 		}
 	}
 
+	if version >= 3 {
+		pe.putInt8(int8(r.Type))
+		if err = pe.putString(r.Documentation); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -296,6 +356,18 @@ func (r *ConfigEntry) decode(pd packetDecoder, version int16) (err error) {
 			r.Synonyms[i] = s
 		}
 	}
+
+	if version >= 3 {
+		t, err := pd.getInt8()
+		if err != nil {
+			return err
+		}
+		r.Type = ConfigType(t)
+
+		if r.Documentation, err = pd.getString(); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -90,6 +90,31 @@ var (
 		0,          // Sensitive
 		0, 0, 0, 0, // No Synonym
 	}
+
+	describeConfigsResponseWithDocumentationv3 = []byte{
+		0, 0, 0, 0, // throttle
+		0, 0, 0, 1, // response
+		0, 0, // errorcode
+		0, 0, // string
+		2, // topic
+		0, 3, 'f', 'o', 'o',
+		0, 0, 0, 1, // configs
+		0, 10, 's', 'e', 'g', 'm', 'e', 'n', 't', '.', 'm', 's',
+		0, 4, '1', '0', '0', '0',
+		0,          // ReadOnly
+		1,          // Source
+		0,          // Sensitive
+		0, 0, 0, 2, // 2 Synonyms
+		0, 10, 's', 'e', 'g', 'm', 'e', 'n', 't', '.', 'm', 's',
+		0, 4, '1', '0', '0', '0',
+		1, // Source
+		0, 14, 'l', 'o', 'g', '.', 's', 'e', 'g', 'm', 'e', 'n', 't', '.', 'm', 's',
+		0, 4, '5', '0', '0', '0',
+		4,     // Source
+		5,     // Type
+		0, 10, // Documentation
+		'R', 'o', 'l', 'l', 's', ' ', 'l', 'o', 'g', '.',
+	}
 )
 
 func TestDescribeConfigsResponsev0(t *testing.T) {
@@ -273,3 +298,29 @@ func TestDescribeConfigsResponseWithDefaultv1(t *testing.T) {
 	}
 	testResponse(t, "response with error", response, describeConfigsResponseWithDefaultv1)
 }
+
+func TestDescribeConfigsResponseWithDocumentationv3(t *testing.T) {
+	response := &DescribeConfigsResponse{
+		Version: 3,
+		Resources: []*ResourceResponse{
+			{
+				Type: TopicResource,
+				Name: "foo",
+				Configs: []*ConfigEntry{
+					{
+						Name:   "segment.ms",
+						Value:  "1000",
+						Source: SourceTopic,
+						Synonyms: []*ConfigSynonym{
+							{ConfigName: "segment.ms", ConfigValue: "1000", Source: SourceTopic},
+							{ConfigName: "log.segment.ms", ConfigValue: "5000", Source: SourceStaticBroker},
+						},
+						Type:          ConfigTypeLong,
+						Documentation: "Rolls log.",
+					},
+				},
+			},
+		},
+	}
+	testResponse(t, "response with documentation", response, describeConfigsResponseWithDocumentationv3)
+}
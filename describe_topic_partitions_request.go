@@ -0,0 +1,127 @@
+package sarama
+
+// DescribeTopicPartitionsRequest describes the partitions of topics by pages,
+// following KIP-966. It replaces the topic description of MetadataRequest for
+// topics with a large number of partitions.
+type DescribeTopicPartitionsRequest struct {
+	// Version 0 is currently only supported
+	Version int16
+	// Topics are the names of the topics to describe, or all the topics if
+	// empty.
+	Topics []string
+	// ResponsePartitionLimit is the maximum number of partitions included in
+	// the response.
+	ResponsePartitionLimit int32
+	// Cursor is the first topic and partition to describe, or nil to start
+	// from the first one.
+	Cursor *DescribeTopicPartitionsCursor
+}
+
+// DescribeTopicPartitionsCursor is the topic and partition from which the
+// description of the partitions continues.
+type DescribeTopicPartitionsCursor struct {
+	TopicName      string
+	PartitionIndex int32
+}
+
+func (r *DescribeTopicPartitionsRequest) encode(pe packetEncoder) error {
+	pe.putCompactArrayLength(len(r.Topics))
+	for _, topic := range r.Topics {
+		if err := pe.putCompactString(topic); err != nil {
+			return err
+		}
+		pe.putEmptyTaggedFieldArray()
+	}
+	pe.putInt32(r.ResponsePartitionLimit)
+	if err := r.Cursor.encode(pe); err != nil {
+		return err
+	}
+
+	pe.putEmptyTaggedFieldArray()
+	return nil
+}
+
+func (r *DescribeTopicPartitionsRequest) decode(pd packetDecoder, version int16) (err error) {
+	r.Version = version
+	n, err := pd.getCompactArrayLength()
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		r.Topics = make([]string, n)
+		for i := range r.Topics {
+			if r.Topics[i], err = pd.getCompactString(); err != nil {
+				return err
+			}
+			if _, err = pd.getEmptyTaggedFieldArray(); err != nil {
+				return err
+			}
+		}
+	}
+	if r.ResponsePartitionLimit, err = pd.getInt32(); err != nil {
+		return err
+	}
+	if r.Cursor, err = decodeDescribeTopicPartitionsCursor(pd); err != nil {
+		return err
+	}
+
+	if _, err = pd.getEmptyTaggedFieldArray(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// encode writes the cursor as a nullable struct, prefixed by -1 when nil and
+// 1 otherwise.
+func (c *DescribeTopicPartitionsCursor) encode(pe packetEncoder) error {
+	if c == nil {
+		pe.putInt8(-1)
+		return nil
+	}
+	pe.putInt8(1)
+	if err := pe.putCompactString(c.TopicName); err != nil {
+		return err
+	}
+	pe.putInt32(c.PartitionIndex)
+
+	pe.putEmptyTaggedFieldArray()
+	return nil
+}
+
+func decodeDescribeTopicPartitionsCursor(pd packetDecoder) (*DescribeTopicPartitionsCursor, error) {
+	present, err := pd.getInt8()
+	if err != nil || present < 0 {
+		return nil, err
+	}
+	c := new(DescribeTopicPartitionsCursor)
+	if c.TopicName, err = pd.getCompactString(); err != nil {
+		return nil, err
+	}
+	if c.PartitionIndex, err = pd.getInt32(); err != nil {
+		return nil, err
+	}
+	if _, err = pd.getEmptyTaggedFieldArray(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (r *DescribeTopicPartitionsRequest) key() int16 {
+	return 75
+}
+
+func (r *DescribeTopicPartitionsRequest) version() int16 {
+	return r.Version
+}
+
+func (r *DescribeTopicPartitionsRequest) headerVersion() int16 {
+	return 2
+}
+
+func (r *DescribeTopicPartitionsRequest) isValidVersion() bool {
+	return r.Version == 0
+}
+
+func (r *DescribeTopicPartitionsRequest) requiredVersion() KafkaVersion {
+	return V3_8_0_0
+}
@@ -0,0 +1,36 @@
+package sarama
+
+import "testing"
+
+var (
+	describeTopicPartitionsRequest = []byte{
+		2,                // compact array length (1)
+		4, 'f', 'o', 'o', // topic name
+		0,            // empty tag buffer
+		0, 0, 7, 208, // response partition limit
+		0xff, // cursor (null)
+		0,    // empty tag buffer
+	}
+
+	describeTopicPartitionsRequestWithCursor = []byte{
+		1,            // compact array length (0)
+		0, 0, 7, 208, // response partition limit
+		1,                // cursor
+		4, 'f', 'o', 'o', // topic name
+		0, 0, 0, 5, // partition index
+		0, // empty tag buffer
+		0, // empty tag buffer
+	}
+)
+
+func TestDescribeTopicPartitionsRequest(t *testing.T) {
+	testRequest(t, "v0", &DescribeTopicPartitionsRequest{
+		Topics:                 []string{"foo"},
+		ResponsePartitionLimit: 2000,
+	}, describeTopicPartitionsRequest)
+
+	testRequest(t, "v0 with cursor", &DescribeTopicPartitionsRequest{
+		ResponsePartitionLimit: 2000,
+		Cursor:                 &DescribeTopicPartitionsCursor{TopicName: "foo", PartitionIndex: 5},
+	}, describeTopicPartitionsRequestWithCursor)
+}
@@ -0,0 +1,263 @@
+package sarama
+
+import "time"
+
+// DescribeTopicPartitionsResponse is a page of the description of the
+// partitions of topics.
+type DescribeTopicPartitionsResponse struct {
+	// Version 0 is currently only supported
+	Version      int16
+	ThrottleTime time.Duration
+	Topics       []*DescribeTopicPartitionsTopic
+	// NextCursor is the topic and partition from which to continue the
+	// description, or nil if all the partitions were described.
+	NextCursor *DescribeTopicPartitionsCursor
+}
+
+// DescribeTopicPartitionsTopic describes a topic and its partitions.
+type DescribeTopicPartitionsTopic struct {
+	Err        KError
+	Name       *string
+	TopicID    Uuid
+	IsInternal bool
+	Partitions []*DescribeTopicPartitionsPartition
+	// TopicAuthorizedOperations is a 32-bit bitfield of the operations
+	// authorized on the topic.
+	TopicAuthorizedOperations int32
+}
+
+// DescribeTopicPartitionsPartition describes a partition of a topic.
+type DescribeTopicPartitionsPartition struct {
+	Err             KError
+	PartitionIndex  int32
+	LeaderID        int32
+	LeaderEpoch     int32
+	ReplicaNodes    []int32
+	IsrNodes        []int32
+	OfflineReplicas []int32
+	// EligibleLeaderReplicas and LastKnownELR are the replicas eligible to
+	// become leader of KIP-966, or nil if the feature is disabled.
+	EligibleLeaderReplicas []int32
+	LastKnownELR           []int32
+}
+
+// topicMetadata converts the topic description to the TopicMetadata returned
+// by MetadataRequest.
+func (t *DescribeTopicPartitionsTopic) topicMetadata() *TopicMetadata {
+	metadata := &TopicMetadata{
+		Err:                       t.Err,
+		Uuid:                      t.TopicID,
+		IsInternal:                t.IsInternal,
+		Partitions:                make([]*PartitionMetadata, len(t.Partitions)),
+		TopicAuthorizedOperations: t.TopicAuthorizedOperations,
+	}
+	if t.Name != nil {
+		metadata.Name = *t.Name
+	}
+	for i, p := range t.Partitions {
+		metadata.Partitions[i] = &PartitionMetadata{
+			Err:             p.Err,
+			ID:              p.PartitionIndex,
+			Leader:          p.LeaderID,
+			LeaderEpoch:     p.LeaderEpoch,
+			Replicas:        p.ReplicaNodes,
+			Isr:             p.IsrNodes,
+			OfflineReplicas: p.OfflineReplicas,
+		}
+	}
+	return metadata
+}
+
+func (r *DescribeTopicPartitionsResponse) encode(pe packetEncoder) error {
+	pe.putInt32(int32(r.ThrottleTime / time.Millisecond))
+	pe.putCompactArrayLength(len(r.Topics))
+	for _, topic := range r.Topics {
+		if err := topic.encode(pe); err != nil {
+			return err
+		}
+	}
+	if err := r.NextCursor.encode(pe); err != nil {
+		return err
+	}
+
+	pe.putEmptyTaggedFieldArray()
+	return nil
+}
+
+func (t *DescribeTopicPartitionsTopic) encode(pe packetEncoder) error {
+	pe.putInt16(int16(t.Err))
+	if err := pe.putNullableCompactString(t.Name); err != nil {
+		return err
+	}
+	if err := pe.putRawBytes(t.TopicID[:]); err != nil {
+		return err
+	}
+	pe.putBool(t.IsInternal)
+	pe.putCompactArrayLength(len(t.Partitions))
+	for _, partition := range t.Partitions {
+		if err := partition.encode(pe); err != nil {
+			return err
+		}
+	}
+	pe.putInt32(t.TopicAuthorizedOperations)
+
+	pe.putEmptyTaggedFieldArray()
+	return nil
+}
+
+func (p *DescribeTopicPartitionsPartition) encode(pe packetEncoder) error {
+	pe.putInt16(int16(p.Err))
+	pe.putInt32(p.PartitionIndex)
+	pe.putInt32(p.LeaderID)
+	pe.putInt32(p.LeaderEpoch)
+	if err := pe.putCompactInt32Array(p.ReplicaNodes); err != nil {
+		return err
+	}
+	if err := pe.putCompactInt32Array(p.IsrNodes); err != nil {
+		return err
+	}
+	if err := pe.putNullableCompactInt32Array(p.EligibleLeaderReplicas); err != nil {
+		return err
+	}
+	if err := pe.putNullableCompactInt32Array(p.LastKnownELR); err != nil {
+		return err
+	}
+	if err := pe.putCompactInt32Array(p.OfflineReplicas); err != nil {
+		return err
+	}
+
+	pe.putEmptyTaggedFieldArray()
+	return nil
+}
+
+func (r *DescribeTopicPartitionsResponse) decode(pd packetDecoder, version int16) (err error) {
+	r.Version = version
+	throttleTime, err := pd.getInt32()
+	if err != nil {
+		return err
+	}
+	r.ThrottleTime = time.Duration(throttleTime) * time.Millisecond
+
+	n, err := pd.getCompactArrayLength()
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		r.Topics = make([]*DescribeTopicPartitionsTopic, n)
+		for i := range r.Topics {
+			r.Topics[i] = new(DescribeTopicPartitionsTopic)
+			if err := r.Topics[i].decode(pd); err != nil {
+				return err
+			}
+		}
+	}
+	if r.NextCursor, err = decodeDescribeTopicPartitionsCursor(pd); err != nil {
+		return err
+	}
+
+	if _, err = pd.getEmptyTaggedFieldArray(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (t *DescribeTopicPartitionsTopic) decode(pd packetDecoder) (err error) {
+	kerr, err := pd.getInt16()
+	if err != nil {
+		return err
+	}
+	t.Err = KError(kerr)
+	if t.Name, err = pd.getCompactNullableString(); err != nil {
+		return err
+	}
+	topicID, err := pd.getRawBytes(16)
+	if err != nil {
+		return err
+	}
+	copy(t.TopicID[:], topicID)
+	if t.IsInternal, err = pd.getBool(); err != nil {
+		return err
+	}
+	n, err := pd.getCompactArrayLength()
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		t.Partitions = make([]*DescribeTopicPartitionsPartition, n)
+		for i := range t.Partitions {
+			t.Partitions[i] = new(DescribeTopicPartitionsPartition)
+			if err := t.Partitions[i].decode(pd); err != nil {
+				return err
+			}
+		}
+	}
+	if t.TopicAuthorizedOperations, err = pd.getInt32(); err != nil {
+		return err
+	}
+
+	if _, err = pd.getEmptyTaggedFieldArray(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (p *DescribeTopicPartitionsPartition) decode(pd packetDecoder) (err error) {
+	kerr, err := pd.getInt16()
+	if err != nil {
+		return err
+	}
+	p.Err = KError(kerr)
+	if p.PartitionIndex, err = pd.getInt32(); err != nil {
+		return err
+	}
+	if p.LeaderID, err = pd.getInt32(); err != nil {
+		return err
+	}
+	if p.LeaderEpoch, err = pd.getInt32(); err != nil {
+		return err
+	}
+	if p.ReplicaNodes, err = pd.getCompactInt32Array(); err != nil {
+		return err
+	}
+	if p.IsrNodes, err = pd.getCompactInt32Array(); err != nil {
+		return err
+	}
+	if p.EligibleLeaderReplicas, err = pd.getCompactInt32Array(); err != nil {
+		return err
+	}
+	if p.LastKnownELR, err = pd.getCompactInt32Array(); err != nil {
+		return err
+	}
+	if p.OfflineReplicas, err = pd.getCompactInt32Array(); err != nil {
+		return err
+	}
+
+	if _, err = pd.getEmptyTaggedFieldArray(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *DescribeTopicPartitionsResponse) key() int16 {
+	return 75
+}
+
+func (r *DescribeTopicPartitionsResponse) version() int16 {
+	return r.Version
+}
+
+func (r *DescribeTopicPartitionsResponse) headerVersion() int16 {
+	return 1
+}
+
+func (r *DescribeTopicPartitionsResponse) isValidVersion() bool {
+	return r.Version == 0
+}
+
+func (r *DescribeTopicPartitionsResponse) requiredVersion() KafkaVersion {
+	return V3_8_0_0
+}
+
+func (r *DescribeTopicPartitionsResponse) throttleTime() time.Duration {
+	return r.ThrottleTime
+}
@@ -0,0 +1,62 @@
+package sarama
+
+import (
+	"testing"
+	"time"
+)
+
+var describeTopicPartitionsResponse = []byte{
+	0, 0, 0, 100, // throttle time
+	2,    // compact array length (1)
+	0, 0, // no error
+	4, 'f', 'o', 'o', // topic name
+	0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, // topic ID
+	0,    // is internal
+	2,    // compact array length (1)
+	0, 0, // no error
+	0, 0, 0, 0, // partition index
+	0, 0, 0, 1, // leader ID
+	0, 0, 0, 2, // leader epoch
+	3, 0, 0, 0, 1, 0, 0, 0, 2, // replica nodes
+	2, 0, 0, 0, 1, // isr nodes
+	0,             // eligible leader replicas (null)
+	0,             // last known ELR (null)
+	2, 0, 0, 0, 2, // offline replicas
+	0,                // empty tag buffer
+	0, 0, 0x0f, 0xf8, // topic authorized operations
+	0,                // empty tag buffer
+	1,                // next cursor
+	4, 'f', 'o', 'o', // topic name
+	0, 0, 0, 1, // partition index
+	0, // empty tag buffer
+	0, // empty tag buffer
+}
+
+func TestDescribeTopicPartitionsResponse(t *testing.T) {
+	name := "foo"
+	response := &DescribeTopicPartitionsResponse{
+		ThrottleTime: 100 * time.Millisecond,
+		Topics: []*DescribeTopicPartitionsTopic{{
+			Name:    &name,
+			TopicID: Uuid{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15},
+			Partitions: []*DescribeTopicPartitionsPartition{{
+				LeaderID:        1,
+				LeaderEpoch:     2,
+				ReplicaNodes:    []int32{1, 2},
+				IsrNodes:        []int32{1},
+				OfflineReplicas: []int32{2},
+			}},
+			TopicAuthorizedOperations: 0x0ff8,
+		}},
+		NextCursor: &DescribeTopicPartitionsCursor{TopicName: "foo", PartitionIndex: 1},
+	}
+	testResponse(t, "v0", response, describeTopicPartitionsResponse)
+
+	metadata := response.Topics[0].topicMetadata()
+	if metadata.Name != "foo" || len(metadata.Partitions) != 1 {
+		t.Fatalf("Expected topic foo with 1 partition, got %v with %d", metadata.Name, len(metadata.Partitions))
+	}
+	if p := metadata.Partitions[0]; p.Leader != 1 || p.LeaderEpoch != 2 || len(p.Replicas) != 2 || len(p.OfflineReplicas) != 1 {
+		t.Errorf("Unexpected partition metadata %+v", p)
+	}
+}
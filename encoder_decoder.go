@@ -36,7 +36,9 @@ func encode(e encoder, metricRegistry metrics.Registry) ([]byte, error) {
 	}
 
 	realEnc.raw = make([]byte, prepEnc.length)
-	realEnc.registry = metricRegistry
+	if !metricsDisabled(metricRegistry) {
+		realEnc.registry = metricRegistry
+	}
 	err = e.encode(&realEnc)
 	if err != nil {
 		return nil, err
@@ -62,9 +64,9 @@ func decode(buf []byte, in decoder, metricRegistry metrics.Registry) error {
 		return nil
 	}
 
-	helper := realDecoder{
-		raw:      buf,
-		registry: metricRegistry,
+	helper := realDecoder{raw: buf}
+	if !metricsDisabled(metricRegistry) {
+		helper.registry = metricRegistry
 	}
 	err := in.decode(&helper)
 	if err != nil {
@@ -83,9 +85,9 @@ func versionedDecode(buf []byte, in versionedDecoder, version int16, metricRegis
 		return nil
 	}
 
-	helper := realDecoder{
-		raw:      buf,
-		registry: metricRegistry,
+	helper := realDecoder{raw: buf}
+	if !metricsDisabled(metricRegistry) {
+		helper.registry = metricRegistry
 	}
 	err := in.decode(&helper, version)
 	if err != nil {
@@ -18,6 +18,10 @@ var ErrBrokerNotFound = errors.New("kafka: broker for ID is not found")
 // ErrClosedClient is the error returned when a method is called on a client that has been closed.
 var ErrClosedClient = errors.New("kafka: tried to use a client that was closed")
 
+// ErrClientUnhealthy is the error returned by HealthChecker.Healthy when a
+// majority of the brokers could not be pinged.
+var ErrClientUnhealthy = errors.New("kafka: client can not reach a majority of the brokers")
+
 // ErrIncompleteResponse is the error returned when the server returns a syntactically valid response, but it does
 // not contain the expected information.
 var ErrIncompleteResponse = errors.New("kafka: response did not contain all the expected topic/partition blocks")
@@ -40,6 +44,10 @@ var ErrInsufficientData = errors.New("kafka: insufficient data to decode packet,
 // ErrShuttingDown is returned when a producer receives a message during shutdown.
 var ErrShuttingDown = errors.New("kafka: message received by producer in process of shutting down")
 
+// ErrProducerQueueFull is returned by AsyncProducer.TrySend when the producer
+// is not ready to accept the message.
+var ErrProducerQueueFull = errors.New("kafka: producer input queue is full")
+
 // ErrMessageTooLarge is returned when the next message to consume is larger than the configured Consumer.Fetch.Max
 var ErrMessageTooLarge = errors.New("kafka: message is larger than Consumer.Fetch.Max")
 
@@ -55,6 +63,14 @@ var ErrControllerNotAvailable = errors.New("kafka: controller is not available")
 // the metadata.
 var ErrNoTopicsToUpdateMetadata = errors.New("kafka: no specific topics to update metadata")
 
+// ErrReplicaDetailsDropped is returned by Client.InSyncReplicas and
+// Client.OfflineReplicas when Metadata.DropReplicaDetails is set.
+var ErrReplicaDetailsDropped = errors.New("kafka: the in-sync and offline replicas are dropped from the metadata")
+
+// ErrNoTopicsToConsume is the error returned by ConsumerGroup.Consume when no
+// topics are provided.
+var ErrNoTopicsToConsume = errors.New("kafka: no topics provided to consume")
+
 // ErrUnknownScramMechanism is returned when user tries to AlterUserScramCredentials with unknown SCRAM mechanism
 var ErrUnknownScramMechanism = errors.New("kafka: unknown SCRAM mechanism provided")
 
@@ -79,6 +95,9 @@ var ErrTransactionNotReady = errors.New("transaction manager: transaction is not
 // ErrNonTransactedProducer when calling BeginTxn, CommitTxn or AbortTxn on a non transactional producer.
 var ErrNonTransactedProducer = errors.New("transaction manager: you need to add TransactionalID to producer")
 
+// ErrTwoPhaseCommitDisabled when calling PrepareTxn or CompleteTxn without Producer.Transaction.TwoPhaseCommit.
+var ErrTwoPhaseCommitDisabled = errors.New("transaction manager: you need to enable Producer.Transaction.TwoPhaseCommit")
+
 // ErrTransitionNotAllowed when txnmgr state transition is not valid.
 var ErrTransitionNotAllowed = errors.New("transaction manager: invalid transition attempted")
 
@@ -159,6 +178,22 @@ func (err PacketDecodingError) Error() string {
 	return fmt.Sprintf("kafka: error decoding packet: %s", err.Info)
 }
 
+// DecodingLimitError is returned when a length prefix decoded from a Kafka message exceeds one of
+// MaxDecodeArrayLength, MaxDecodeStringLength or MaxDecodeAllocation. Such a prefix is usually the sign of a
+// corrupt or malicious message, which is rejected before allocating anything for it.
+type DecodingLimitError struct {
+	// Limit is the limit which was exceeded: "array length", "string length" or "allocation".
+	Limit string
+	// Length is the length decoded, or the total allocation it would have led to.
+	Length int64
+	// Max is the value of the limit.
+	Max int64
+}
+
+func (err DecodingLimitError) Error() string {
+	return fmt.Sprintf("kafka: error decoding packet: %s of %d exceeds the limit of %d", err.Limit, err.Length, err.Max)
+}
+
 // ConfigurationError is the type of error returned from a constructor (e.g. NewClient, or NewConsumer)
 // when the specified configuration is invalid.
 type ConfigurationError string
@@ -167,6 +202,44 @@ func (err ConfigurationError) Error() string {
 	return "kafka: invalid configuration (" + string(err) + ")"
 }
 
+// UnsupportedVersionError is the error returned for a request whose version
+// is not supported, because Config.Version predates the version of Kafka it
+// requires, or because the broker does not support it as per its ApiVersions
+// response. It wraps ErrUnsupportedVersion.
+type UnsupportedVersionError struct {
+	// API is the name of the request, such as "InitProducerID", and Feature
+	// the configuration enabling it, if known.
+	API, Feature string
+	// Version is the version of the request, and RequiredKafkaVersion the
+	// version of Kafka it requires.
+	Version              int16
+	RequiredKafkaVersion KafkaVersion
+	// ConfigVersion is the Config.Version of the client.
+	ConfigVersion KafkaVersion
+	// Broker is the address of the broker, and BrokerMinVersion and
+	// BrokerMaxVersion the versions of the request it supports, or -1 if it
+	// was Config.Version which did not support the request.
+	Broker                             string
+	BrokerMinVersion, BrokerMaxVersion int16
+}
+
+func (err UnsupportedVersionError) Error() string {
+	request := fmt.Sprintf("%s v%d", err.API, err.Version)
+	if err.Feature != "" {
+		request += " (used by " + err.Feature + ")"
+	}
+	if err.BrokerMaxVersion < 0 {
+		return fmt.Sprintf("kafka: %s requires Kafka %s or later, but Config.Version is %s",
+			request, err.RequiredKafkaVersion, err.ConfigVersion)
+	}
+	return fmt.Sprintf("kafka: %s is not supported by broker %s, which supports v%d to v%d (Config.Version is %s)",
+		request, err.Broker, err.BrokerMinVersion, err.BrokerMaxVersion, err.ConfigVersion)
+}
+
+func (err UnsupportedVersionError) Unwrap() error {
+	return ErrUnsupportedVersion
+}
+
 // KError is the type of error that can be returned directly by the Kafka broker.
 // See https://cwiki.apache.org/confluence/display/KAFKA/A+Guide+To+The+Kafka+Protocol#AGuideToTheKafkaProtocol-ErrorCodes
 type KError int16
@@ -451,6 +524,8 @@ func (err KError) Error() string {
 		return "kafka server: This record has failed the validation on broker and hence will be rejected"
 	case ErrUnstableOffsetCommit:
 		return "kafka server: There are unstable offsets that need to be cleared"
+	case ErrProducerFenced:
+		return "kafka server: There is a newer producer with the same transactionalId which fences the current one"
 	}
 
 	return fmt.Sprintf("Unknown error, how did this happen? Error code = %d", err)
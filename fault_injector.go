@@ -0,0 +1,131 @@
+package sarama
+
+import (
+	"fmt"
+	"sync"
+)
+
+// FaultRequest is the kind of request whose responses a FaultInjector may
+// alter.
+type FaultRequest int
+
+const (
+	// FaultProduce is the kind of the produce requests, except those sent
+	// with NoResponse required acks, which get no response.
+	FaultProduce FaultRequest = iota
+	// FaultFetch is the kind of the fetch requests.
+	FaultFetch
+	// FaultOffsetCommit is the kind of the offset commit requests.
+	FaultOffsetCommit
+)
+
+func (r FaultRequest) String() string {
+	switch r {
+	case FaultProduce:
+		return "Produce"
+	case FaultFetch:
+		return "Fetch"
+	case FaultOffsetCommit:
+		return "OffsetCommit"
+	default:
+		return fmt.Sprintf("FaultRequest(%d)", int(r))
+	}
+}
+
+// FaultInjector forces broker errors into the responses received by a client,
+// as set by Config.Test.FaultInjector, so that the tests of an application can
+// rehearse rare broker errors deterministically, without build tags nor a
+// fake broker.
+type FaultInjector interface {
+	// Fault is called for every response of the given kind received from the
+	// given broker, before the client handles it, and returns the error to set
+	// on all of its partitions, or ErrNoError to leave it untouched. It may be
+	// called concurrently.
+	Fault(request FaultRequest, broker int32) KError
+}
+
+// FaultSchedule is a FaultInjector failing the Nth responses of each kind of
+// request, counted from 1 across all the brokers of the clients using it.
+type FaultSchedule struct {
+	lock   sync.Mutex
+	counts map[FaultRequest]int
+	faults map[FaultRequest]map[int]KError
+}
+
+// NewFaultSchedule returns an empty FaultSchedule, which does not fail any
+// response until FailNth is called.
+func NewFaultSchedule() *FaultSchedule {
+	return &FaultSchedule{
+		counts: make(map[FaultRequest]int),
+		faults: make(map[FaultRequest]map[int]KError),
+	}
+}
+
+// FailNth sets err on all the partitions of the nth response to a request of
+// the given kind. It returns the schedule, so that calls can be chained.
+func (s *FaultSchedule) FailNth(request FaultRequest, n int, err KError) *FaultSchedule {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.faults[request] == nil {
+		s.faults[request] = make(map[int]KError)
+	}
+	s.faults[request][n] = err
+	return s
+}
+
+// Count returns the number of responses to the requests of the given kind
+// seen so far.
+func (s *FaultSchedule) Count(request FaultRequest) int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.counts[request]
+}
+
+// Fault implements FaultInjector.
+func (s *FaultSchedule) Fault(request FaultRequest, broker int32) KError {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.counts[request]++
+	if err, ok := s.faults[request][s.counts[request]]; ok {
+		return err
+	}
+	return ErrNoError
+}
+
+// injectFault sets the error returned by the fault injector of conf, if any,
+// on all the partitions of a produce, fetch or offset commit response.
+func injectFault(conf *Config, broker int32, response protocolBody) {
+	if conf == nil || conf.Test.FaultInjector == nil {
+		return
+	}
+
+	switch res := response.(type) {
+	case *ProduceResponse:
+		if err := conf.Test.FaultInjector.Fault(FaultProduce, broker); err != ErrNoError {
+			for _, blocks := range res.Blocks {
+				for _, block := range blocks {
+					block.Err = err
+				}
+			}
+		}
+	case *FetchResponse:
+		if err := conf.Test.FaultInjector.Fault(FaultFetch, broker); err != ErrNoError {
+			for _, blocks := range res.Blocks {
+				for _, block := range blocks {
+					block.Err = err
+				}
+			}
+		}
+	case *OffsetCommitResponse:
+		if err := conf.Test.FaultInjector.Fault(FaultOffsetCommit, broker); err != ErrNoError {
+			for _, partitions := range res.Errors {
+				for partition := range partitions {
+					partitions[partition] = err
+				}
+			}
+		}
+	}
+}
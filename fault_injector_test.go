@@ -0,0 +1,74 @@
+package sarama
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestFaultScheduleFailsNthResponse(t *testing.T) {
+	seedBroker := NewMockBroker(t, 1)
+	defer seedBroker.Close()
+	leader := NewMockBroker(t, 2)
+	defer leader.Close()
+
+	seedBroker.SetHandlerByMap(map[string]MockResponse{
+		"MetadataRequest": NewMockMetadataResponse(t).
+			SetBroker(leader.Addr(), leader.BrokerID()).
+			SetLeader("my_topic", 0, leader.BrokerID()),
+	})
+	leader.SetHandlerByMap(map[string]MockResponse{
+		"ProduceRequest": NewMockProduceResponse(t),
+	})
+
+	faults := NewFaultSchedule().FailNth(FaultProduce, 2, ErrNotEnoughReplicas)
+	config := NewTestConfig()
+	config.Producer.Return.Successes = true
+	config.Producer.Retry.Max = 0
+	config.Test.FaultInjector = faults
+	producer, err := NewSyncProducer([]string{seedBroker.Addr()}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer safeClose(t, producer)
+
+	for i, expected := range []error{nil, ErrNotEnoughReplicas, nil} {
+		_, _, err := producer.SendMessage(&ProducerMessage{Topic: "my_topic", Value: StringEncoder(TestMessage)})
+		if !errors.Is(err, expected) {
+			t.Errorf("expected message %d to return %v, got %v", i, expected, err)
+		}
+	}
+	if count := faults.Count(FaultProduce); count != 3 {
+		t.Error("expected 3 produce responses, got", count)
+	}
+	if count := faults.Count(FaultFetch); count != 0 {
+		t.Error("expected no fetch response, got", count)
+	}
+}
+
+func TestFaultScheduleOffsetCommit(t *testing.T) {
+	broker := NewMockBroker(t, 1)
+	defer broker.Close()
+	broker.SetHandlerByMap(map[string]MockResponse{
+		"OffsetCommitRequest": NewMockOffsetCommitResponse(t),
+	})
+
+	config := NewTestConfig()
+	config.Test.FaultInjector = NewFaultSchedule().FailNth(FaultOffsetCommit, 1, ErrRebalanceInProgress)
+	client := NewBroker(broker.Addr())
+	if err := client.Open(config); err != nil {
+		t.Fatal(err)
+	}
+	defer safeClose(t, client)
+
+	request := &OffsetCommitRequest{ConsumerGroup: "my_group"}
+	request.AddBlock("my_topic", 0, 10, 0, "")
+	for _, expected := range []KError{ErrRebalanceInProgress, ErrNoError} {
+		response, err := client.CommitOffset(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := response.Errors["my_topic"][0]; got != expected {
+			t.Errorf("expected %v, got %v", expected, got)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package sarama
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// fetchBufferPool holds the fetch buffers released by all their messages,
+// with Config.Consumer.PooledBuffers.
+var fetchBufferPool sync.Pool
+
+// fetchBuffer is a pooled buffer holding a fetch response, which the keys,
+// values and headers of its messages point into. It is returned to the pool
+// once released by the response and all its messages.
+type fetchBuffer struct {
+	buf  []byte
+	refs int32
+}
+
+// getFetchBuffer returns a buffer of size bytes from the pool, referenced
+// once.
+func getFetchBuffer(size int) *fetchBuffer {
+	if b, ok := fetchBufferPool.Get().(*fetchBuffer); ok && cap(b.buf) >= size {
+		b.buf = b.buf[:size]
+		b.refs = 1
+		return b
+	}
+	return &fetchBuffer{buf: make([]byte, size), refs: 1}
+}
+
+// retain adds a reference to the buffer, which may be nil.
+func (b *fetchBuffer) retain() *fetchBuffer {
+	if b != nil {
+		atomic.AddInt32(&b.refs, 1)
+	}
+	return b
+}
+
+// release removes a reference to the buffer, which may be nil, returning it
+// to the pool once no longer referenced.
+func (b *fetchBuffer) release() {
+	if b != nil && atomic.AddInt32(&b.refs, -1) == 0 {
+		fetchBufferPool.Put(b)
+	}
+}
+
+// ReleaseConsumerMessage releases a message consumed with
+// Config.Consumer.PooledBuffers. The fetch buffer its key, value and headers
+// point into is owned by the consumer until the message is delivered, then
+// by the receiver of the message, which releases it with
+// ReleaseConsumerMessage once processed; the buffer is reused once all its
+// messages are released. Neither the message nor its byte slices may be used
+// after. ReleaseConsumerMessage does nothing for the other messages, and when
+// called again for the same message.
+func ReleaseConsumerMessage(msg *ConsumerMessage) {
+	if msg != nil && msg.buffer != nil {
+		msg.buffer.release()
+		msg.buffer = nil
+	}
+}
+
+// releaseConsumerMessages releases the messages the consumer does not deliver.
+func releaseConsumerMessages(msgs []*ConsumerMessage) {
+	for _, msg := range msgs {
+		ReleaseConsumerMessage(msg)
+	}
+}
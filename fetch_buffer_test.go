@@ -0,0 +1,69 @@
+package sarama
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestFetchBufferReferences(t *testing.T) {
+	b := getFetchBuffer(16)
+	if len(b.buf) != 16 || b.refs != 1 {
+		t.Fatalf("expected a buffer of 16 bytes referenced once, got %d bytes referenced %d times", len(b.buf), b.refs)
+	}
+
+	msgs := []*ConsumerMessage{{buffer: b.retain()}, {buffer: b.retain()}}
+	b.release()
+	if b.refs != 2 {
+		t.Error("expected the buffer to be referenced by its messages, got", b.refs)
+	}
+
+	ReleaseConsumerMessage(msgs[0])
+	ReleaseConsumerMessage(msgs[0])
+	if b.refs != 1 {
+		t.Error("expected releasing a message twice to release the buffer once, got", b.refs)
+	}
+	ReleaseConsumerMessage(msgs[1])
+	if b.refs != 0 {
+		t.Error("expected the buffer to be released by all its messages, got", b.refs)
+	}
+
+	// messages without pooled buffers are not affected
+	ReleaseConsumerMessage(&ConsumerMessage{})
+	ReleaseConsumerMessage(nil)
+	var none *fetchBuffer
+	if none.retain() != nil {
+		t.Error("expected retaining no buffer to return none")
+	}
+	none.release()
+}
+
+func TestFetchBufferReleasedByDroppedMessages(t *testing.T) {
+	config := NewTestConfig()
+	config.Transforms = []MessageTransform{MessageTransformFuncs{
+		ConsumeFunc: func(p *MessagePayload) error {
+			if string(p.Value) == "drop" {
+				return errors.New("dropped")
+			}
+			return nil
+		},
+	}}
+	child := &partitionConsumer{conf: config, topic: "my_topic"}
+
+	b := getFetchBuffer(16)
+	msgs := []*ConsumerMessage{
+		{Topic: "my_topic", Value: []byte("keep"), buffer: b.retain()},
+		{Topic: "my_topic", Value: []byte("drop"), buffer: b.retain()},
+	}
+	b.release()
+
+	if msgs = child.transform(msgs); len(msgs) != 1 {
+		t.Fatal("expected a message to be dropped, got", len(msgs))
+	}
+	if b.refs != 1 {
+		t.Error("expected the dropped message to release the buffer, got", b.refs)
+	}
+	releaseConsumerMessages(msgs)
+	if b.refs != 0 {
+		t.Error("expected the buffer to be released by all its messages, got", b.refs)
+	}
+}
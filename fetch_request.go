@@ -85,6 +85,12 @@ type FetchRequest struct {
 	forgotten map[string][]int32
 	// RackID contains a Rack ID of the consumer making this request
 	RackID string
+	// pooled reads the response into a pooled fetch buffer, for
+	// Config.Consumer.PooledBuffers.
+	pooled bool
+	// keepCorruptBatches decodes the response with its corrupt record
+	// batches, for Config.Consumer.CRC.Policy.
+	keepCorruptBatches bool
 }
 
 type IsolationLevel int8
@@ -130,7 +136,9 @@ func (r *FetchRequest) encode(pe packetEncoder) (err error) {
 				return err
 			}
 		}
-		getOrRegisterTopicMeter("consumer-fetch-rate", topic, metricRegistry).Mark(1)
+		if metricRegistry != nil {
+			getOrRegisterTopicMeter("consumer-fetch-rate", topic, metricRegistry).Mark(1)
+		}
 	}
 	if r.Version >= 7 {
 		err = pe.putArrayLength(len(r.forgotten))
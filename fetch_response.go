@@ -62,6 +62,11 @@ type FetchResponseBlock struct {
 
 	Partial bool
 	Records *Records // deprecated: use FetchResponseBlock.RecordsSet
+
+	// keepCorruptBatches is set from FetchResponse.keepCorruptBatches.
+	keepCorruptBatches bool
+	// recordsSize is the number of bytes of record data in the response.
+	recordsSize int32
 }
 
 func (b *FetchResponseBlock) decode(pd packetDecoder, version int16) (err error) {
@@ -129,6 +134,7 @@ func (b *FetchResponseBlock) decode(pd packetDecoder, version int16) (err error)
 	if sizeMetric != nil {
 		sizeMetric.Update(int64(recordsSize))
 	}
+	b.recordsSize = recordsSize
 
 	recordsDecoder, err := pd.getSubset(int(recordsSize))
 	if err != nil {
@@ -139,7 +145,7 @@ func (b *FetchResponseBlock) decode(pd packetDecoder, version int16) (err error)
 
 	for recordsDecoder.remaining() > 0 {
 		records := &Records{}
-		if err := records.decode(recordsDecoder); err != nil {
+		if err := records.decodeRecords(recordsDecoder, b.keepCorruptBatches); err != nil {
 			// If we have at least one decoded records, this is not an error
 			if errors.Is(err, ErrInsufficientData) {
 				if len(b.RecordsSet) == 0 {
@@ -201,6 +207,17 @@ func (b *FetchResponseBlock) numRecords() (int, error) {
 	return sum, nil
 }
 
+// crcErr returns the CRC mismatch of the first corrupt record batch of the
+// block, if any.
+func (b *FetchResponseBlock) crcErr() error {
+	for _, records := range b.RecordsSet {
+		if records.RecordBatch != nil && records.RecordBatch.crcErr != nil {
+			return records.RecordBatch.crcErr
+		}
+	}
+	return nil
+}
+
 func (b *FetchResponseBlock) isPartial() (bool, error) {
 	if b.Partial {
 		return true, nil
@@ -276,6 +293,13 @@ type FetchResponse struct {
 
 	LogAppendTime bool
 	Timestamp     time.Time
+
+	// keepCorruptBatches keeps the record batches whose CRC does not match
+	// instead of failing to decode the response.
+	keepCorruptBatches bool
+
+	// buffer is the pooled buffer the response was decoded from, if any.
+	buffer *fetchBuffer
 }
 
 func (r *FetchResponse) decode(pd packetDecoder, version int16) (err error) {
@@ -325,7 +349,7 @@ func (r *FetchResponse) decode(pd packetDecoder, version int16) (err error) {
 				return err
 			}
 
-			block := new(FetchResponseBlock)
+			block := &FetchResponseBlock{keepCorruptBatches: r.keepCorruptBatches}
 			err = block.decode(pd, version)
 			if err != nil {
 				return err
@@ -595,6 +619,26 @@ func (r *FetchResponse) SetLastOffsetDelta(topic string, partition int32, offset
 	batch.LastOffsetDelta = offset
 }
 
+// AddRecordBatches appends batches to the records of a partition, such as
+// the batches built by MockRecordBatch.
+func (r *FetchResponse) AddRecordBatches(topic string, partition int32, batches ...*RecordBatch) {
+	frb := r.getOrCreateBlock(topic, partition)
+	for _, batch := range batches {
+		records := newDefaultRecords(batch)
+		frb.RecordsSet = append(frb.RecordsSet, &records)
+	}
+}
+
+// AddAbortedTransaction records that the transaction of producerID starting
+// at firstOffset was aborted, so that read_committed consumers skip it.
+func (r *FetchResponse) AddAbortedTransaction(topic string, partition int32, producerID int64, firstOffset int64) {
+	frb := r.getOrCreateBlock(topic, partition)
+	frb.AbortedTransactions = append(frb.AbortedTransactions, &AbortedTransaction{
+		ProducerID:  producerID,
+		FirstOffset: firstOffset,
+	})
+}
+
 func (r *FetchResponse) SetLastStableOffset(topic string, partition int32, offset int64) {
 	frb := r.getOrCreateBlock(topic, partition)
 	frb.LastStableOffset = offset
@@ -376,6 +376,34 @@ func TestOneRecordFetchResponse(t *testing.T) {
 	}
 }
 
+func TestCorruptRecordBatchFetchResponse(t *testing.T) {
+	res := &FetchResponse{Version: 4}
+	res.AddRecordBatch("topic", 0, nil, StringEncoder("ok0"), 0, 0, false)
+	res.AddRecordBatch("topic", 0, nil, StringEncoder("bad1"), 1, 0, false)
+	raw, err := encode(res, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw[bytes.Index(raw, []byte("bad1"))] = 'B'
+
+	if err := versionedDecode(raw, &FetchResponse{}, 4, nil); err == nil {
+		t.Error("expected the CRC mismatch to fail the decoding")
+	}
+
+	response := FetchResponse{keepCorruptBatches: true}
+	testVersionDecodable(t, "corrupt record batch", &response, raw, 4)
+	block := response.GetBlock("topic", 0)
+	if len(block.RecordsSet) != 2 {
+		t.Fatal("expected the batches following the corrupt one to be decoded, got", len(block.RecordsSet))
+	}
+	if block.RecordsSet[0].RecordBatch.crcErr != nil {
+		t.Error("expected the first batch not to be corrupt")
+	}
+	if block.RecordsSet[1].RecordBatch.crcErr == nil || block.crcErr() == nil {
+		t.Error("expected the second batch to be corrupt")
+	}
+}
+
 func TestPartailFetchResponse(t *testing.T) {
 	response := FetchResponse{}
 	testVersionDecodable(t, "partial record", &response, partialFetchResponse, 4)
@@ -0,0 +1,252 @@
+package sarama
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// ProducingSession is the ConsumerGroupSession passed to the handlers wrapped
+// by NewFlushOnRevokeConsumerGroupHandler.
+type ProducingSession interface {
+	ConsumerGroupSession
+
+	// Produce sends msg, produced while processing the source message, with
+	// the producer of the handler, waiting for the producer like
+	// AsyncProducer.SendWithContext with the context of the session. Once
+	// source is marked, its offset is only marked for the group when msg and
+	// the messages produced earlier for its partition are delivered.
+	Produce(source *ConsumerMessage, msg *ProducerMessage) error
+}
+
+// NewFlushOnRevokeConsumerGroupHandler wraps the handler of a
+// consume-transform-produce pipeline, producing with producer without
+// transactions, so that the offsets it marks are only committed once the
+// messages it produced for them are delivered. The handler must produce using
+// the Produce method of the ProducingSession it is passed.
+//
+// When a partition is revoked, its claim waits for the messages produced for
+// it to be delivered, for up to timeout, or without limit if timeout is 0,
+// before the session is released and commits its offsets, so that the new
+// owner of the partition neither processes again the messages whose output
+// was delivered, nor skips the ones whose output was not. Once a message
+// produced for a partition fails, the offsets of the partition are no longer
+// marked until the end of the session.
+//
+// The deliveries are tracked for the producers created by NewAsyncProducer and
+// NewAsyncProducerFromClient. The messages sent with other producers, as the
+// ones of the mocks package, count as delivered once sent.
+func NewFlushOnRevokeConsumerGroupHandler(handler ConsumerGroupHandler, producer AsyncProducer, timeout time.Duration) ConsumerGroupHandler {
+	_, tracked := producer.(*asyncProducer)
+	return &flushOnRevokeHandler{
+		handler:    handler,
+		producer:   producer,
+		tracked:    tracked,
+		timeout:    timeout,
+		partitions: make(map[TopicPartitionID]*flushPartition),
+	}
+}
+
+type flushOnRevokeHandler struct {
+	handler  ConsumerGroupHandler
+	producer AsyncProducer
+	tracked  bool
+	timeout  time.Duration
+
+	lock       sync.Mutex
+	partitions map[TopicPartitionID]*flushPartition // the partitions claimed
+}
+
+func (h *flushOnRevokeHandler) Setup(session ConsumerGroupSession) error {
+	return h.handler.Setup(&flushSession{ConsumerGroupSession: session, handler: h})
+}
+
+func (h *flushOnRevokeHandler) Cleanup(session ConsumerGroupSession) error {
+	return h.handler.Cleanup(&flushSession{ConsumerGroupSession: session, handler: h})
+}
+
+func (h *flushOnRevokeHandler) ConsumeClaim(session ConsumerGroupSession, claim ConsumerGroupClaim) error {
+	id := TopicPartitionID{Topic: claim.Topic(), Partition: claim.Partition()}
+	p := &flushPartition{session: session, topic: id.Topic, partition: id.Partition, failed: math.MaxInt64}
+
+	h.lock.Lock()
+	h.partitions[id] = p
+	h.lock.Unlock()
+
+	err := h.handler.ConsumeClaim(&flushSession{ConsumerGroupSession: session, handler: h}, claim)
+
+	if !p.flush(h.timeout) {
+		Logger.Printf("consumer/flush timed out waiting for the messages produced for %s/%d, not marking their offsets\n",
+			id.Topic, id.Partition)
+	}
+
+	h.lock.Lock()
+	delete(h.partitions, id)
+	h.lock.Unlock()
+
+	return err
+}
+
+func (h *flushOnRevokeHandler) partition(topic string, partition int32) *flushPartition {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.partitions[TopicPartitionID{Topic: topic, Partition: partition}]
+}
+
+// flushSession holds back the offsets marked until the messages produced for
+// them are delivered.
+type flushSession struct {
+	ConsumerGroupSession
+	handler *flushOnRevokeHandler
+}
+
+func (s *flushSession) Produce(source *ConsumerMessage, msg *ProducerMessage) error {
+	var p *flushPartition
+	if s.handler.tracked {
+		p = s.handler.partition(source.Topic, source.Partition)
+	}
+	if p != nil {
+		p.produce(source.Offset, msg)
+	}
+	if err := s.handler.producer.SendWithContext(s.Context(), msg); err != nil {
+		msg.ack(err)
+		return err
+	}
+	return nil
+}
+
+func (s *flushSession) MarkOffset(topic string, partition int32, offset int64, metadata string) {
+	if p := s.handler.partition(topic, partition); p != nil {
+		p.mark(offset, metadata)
+		return
+	}
+	s.ConsumerGroupSession.MarkOffset(topic, partition, offset, metadata)
+}
+
+func (s *flushSession) MarkMessage(msg *ConsumerMessage, metadata string) {
+	s.MarkOffset(msg.Topic, msg.Partition, msg.Offset+1, metadata)
+}
+
+// flushPartition tracks the messages produced for the messages of a claimed
+// partition, and the offsets marked for it which are not marked for the group
+// yet.
+type flushPartition struct {
+	session   ConsumerGroupSession
+	topic     string
+	partition int32
+
+	lock        sync.Mutex
+	outstanding map[int64]int // the number of messages produced and not delivered yet by source offset
+	marks       []flushMark   // the offsets marked and held back, in increasing order
+	failed      int64         // the lowest source offset of a failed message, or math.MaxInt64
+	idle        chan none     // closed once the messages produced are delivered, nil if none is outstanding
+	revoked     bool
+}
+
+type flushMark struct {
+	offset   int64
+	metadata string
+}
+
+func (p *flushPartition) produce(source int64, msg *ProducerMessage) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.outstanding == nil {
+		p.outstanding = make(map[int64]int)
+	}
+	if p.idle == nil {
+		p.idle = make(chan none)
+	}
+	p.outstanding[source]++
+	msg.acked = func(err error) { p.delivered(source, err) }
+}
+
+func (p *flushPartition) delivered(source int64, err error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if err != nil && source < p.failed {
+		Logger.Printf("consumer/flush not marking %s/%d from offset %d as a message produced for it failed: %v\n",
+			p.topic, p.partition, source, err)
+		p.failed = source
+	}
+	if p.outstanding[source]--; p.outstanding[source] <= 0 {
+		delete(p.outstanding, source)
+	}
+	if len(p.outstanding) == 0 && p.idle != nil {
+		close(p.idle)
+		p.idle = nil
+	}
+	p.apply()
+}
+
+func (p *flushPartition) mark(offset int64, metadata string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if n := len(p.marks); n > 0 && p.marks[n-1].offset >= offset {
+		return
+	}
+	p.marks = append(p.marks, flushMark{offset: offset, metadata: metadata})
+	p.apply()
+}
+
+// apply marks for the group the highest offset held back below the source
+// offsets of the messages not delivered yet and of the failed ones. The lock
+// must be held.
+func (p *flushPartition) apply() {
+	if p.revoked {
+		return
+	}
+	limit := p.failed
+	for source := range p.outstanding {
+		if source < limit {
+			limit = source
+		}
+	}
+
+	applied := -1
+	for i, mark := range p.marks {
+		if mark.offset > limit {
+			break
+		}
+		applied = i
+	}
+	if applied < 0 {
+		return
+	}
+	// the offsets marked are the ones of the next messages to process, so
+	// the offset of the source of a message not delivered yet is not marked
+	p.session.MarkOffset(p.topic, p.partition, p.marks[applied].offset, p.marks[applied].metadata)
+	p.marks = p.marks[applied+1:]
+}
+
+// flush waits for the messages produced to be delivered, for up to timeout
+// unless it is 0, and stops marking the offsets of the partition. It returns
+// false if it timed out.
+func (p *flushPartition) flush(timeout time.Duration) bool {
+	p.lock.Lock()
+	idle := p.idle
+	p.lock.Unlock()
+
+	delivered := true
+	if idle != nil {
+		if timeout > 0 {
+			timer := time.NewTimer(timeout)
+			select {
+			case <-idle:
+			case <-timer.C:
+				delivered = false
+			}
+			timer.Stop()
+		} else {
+			<-idle
+		}
+	}
+
+	p.lock.Lock()
+	p.revoked = true
+	p.lock.Unlock()
+	return delivered
+}
@@ -0,0 +1,182 @@
+package sarama
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testFlushSession struct {
+	ConsumerGroupSession
+	ctx    context.Context
+	lock   sync.Mutex
+	marked []int64
+}
+
+func (s *testFlushSession) Context() context.Context {
+	return s.ctx
+}
+
+func (s *testFlushSession) MarkOffset(_ string, _ int32, offset int64, _ string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.marked = append(s.marked, offset)
+}
+
+func (s *testFlushSession) Marked() []int64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]int64(nil), s.marked...)
+}
+
+type testFlushClaim struct {
+	ConsumerGroupClaim
+	messages chan *ConsumerMessage
+}
+
+func (c *testFlushClaim) Topic() string                     { return "in" }
+func (c *testFlushClaim) Partition() int32                  { return 0 }
+func (c *testFlushClaim) Messages() <-chan *ConsumerMessage { return c.messages }
+
+// testFlushHandler produces a message to the out topic for each message it
+// consumes, and marks it.
+type testFlushHandler struct{}
+
+func (testFlushHandler) Setup(ConsumerGroupSession) error   { return nil }
+func (testFlushHandler) Cleanup(ConsumerGroupSession) error { return nil }
+
+func (testFlushHandler) ConsumeClaim(session ConsumerGroupSession, claim ConsumerGroupClaim) error {
+	for msg := range claim.Messages() {
+		out := &ProducerMessage{Topic: "out", Value: StringEncoder(msg.Value)}
+		if err := session.(ProducingSession).Produce(msg, out); err != nil {
+			return err
+		}
+		session.MarkMessage(msg, "")
+	}
+	return nil
+}
+
+func TestFlushPartition(t *testing.T) {
+	session := &testFlushSession{ctx: context.Background()}
+	p := &flushPartition{session: session, topic: "in", failed: 1<<63 - 1}
+
+	first, second := &ProducerMessage{}, &ProducerMessage{}
+	p.produce(0, first)
+	p.produce(1, second)
+	p.mark(1, "")
+	p.mark(2, "")
+	if marked := session.Marked(); len(marked) != 0 {
+		t.Fatalf("expected no offset to be marked before the deliveries, got %v", marked)
+	}
+
+	second.ack(nil)
+	if marked := session.Marked(); len(marked) != 0 {
+		t.Fatalf("expected no offset to be marked before the delivery of offset 0, got %v", marked)
+	}
+	first.ack(nil)
+	if marked := session.Marked(); !reflect.DeepEqual(marked, []int64{2}) {
+		t.Fatalf("expected offset 2 to be marked, got %v", marked)
+	}
+
+	// once the message produced for offset 3 fails, only offset 3 is marked
+	third, fourth := &ProducerMessage{}, &ProducerMessage{}
+	p.produce(2, third)
+	p.produce(3, fourth)
+	p.mark(3, "")
+	p.mark(4, "")
+	fourth.ack(ErrInvalidRecord)
+	third.ack(nil)
+	p.mark(5, "")
+	if marked := session.Marked(); !reflect.DeepEqual(marked, []int64{2, 3}) {
+		t.Fatalf("expected offset 3 to be marked, got %v", marked)
+	}
+
+	if !p.flush(time.Millisecond) {
+		t.Error("expected the messages to be delivered")
+	}
+	p.mark(6, "")
+	if marked := session.Marked(); !reflect.DeepEqual(marked, []int64{2, 3}) {
+		t.Errorf("expected no offset to be marked once revoked, got %v", marked)
+	}
+}
+
+func TestFlushOnRevokeConsumerGroupHandler(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		err      KError
+		expected int64 // the last offset marked, or -1
+	}{
+		{"delivered", ErrNoError, 3},
+		{"failed", ErrInvalidRecord, -1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			seedBroker := NewMockBroker(t, 1)
+			defer seedBroker.Close()
+			leader := NewMockBroker(t, 2)
+			defer leader.Close()
+
+			metadataResponse := NewMockMetadataResponse(t).
+				SetBroker(leader.Addr(), leader.BrokerID()).
+				SetLeader("out", 0, leader.BrokerID())
+			seedBroker.SetHandlerByMap(map[string]MockResponse{"MetadataRequest": metadataResponse})
+			leader.SetHandlerByMap(map[string]MockResponse{
+				"MetadataRequest": metadataResponse,
+				"ProduceRequest":  NewMockProduceResponse(t).SetError("out", 0, tc.err),
+			})
+
+			config := NewTestConfig()
+			config.Producer.Flush.Frequency = 50 * time.Millisecond
+			config.Producer.Retry.Max = 0
+			producer, err := NewAsyncProducer([]string{seedBroker.Addr()}, config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer safeClose(t, producer)
+			go func() {
+				for range producer.Errors() {
+				}
+			}()
+
+			session := &testFlushSession{ctx: context.Background()}
+			claim := &testFlushClaim{messages: make(chan *ConsumerMessage, 3)}
+			for offset := int64(0); offset < 3; offset++ {
+				claim.messages <- &ConsumerMessage{Topic: "in", Offset: offset, Value: []byte(TestMessage)}
+			}
+			close(claim.messages)
+
+			// the messages are only delivered after Flush.Frequency, once the
+			// handler returned, so the claim must wait for them
+			handler := NewFlushOnRevokeConsumerGroupHandler(testFlushHandler{}, producer, time.Second)
+			if err := handler.ConsumeClaim(session, claim); err != nil {
+				t.Fatal(err)
+			}
+			last := int64(-1)
+			if marked := session.Marked(); len(marked) > 0 {
+				last = marked[len(marked)-1]
+			}
+			if last != tc.expected {
+				t.Errorf("expected the last offset marked to be %d, got %d", tc.expected, last)
+			}
+		})
+	}
+}
+
+func TestFlushOnRevokeConsumerGroupHandlerCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	session := &testFlushSession{ctx: ctx}
+	handler := NewFlushOnRevokeConsumerGroupHandler(testFlushHandler{}, &asyncProducer{input: make(chan *ProducerMessage)}, time.Second)
+
+	claim := &testFlushClaim{messages: make(chan *ConsumerMessage, 1)}
+	claim.messages <- &ConsumerMessage{Topic: "in", Offset: 0}
+	close(claim.messages)
+	if err := handler.ConsumeClaim(session, claim); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the produce to be canceled, got %v", err)
+	}
+	if marked := session.Marked(); len(marked) != 0 {
+		t.Errorf("expected no offset to be marked, got %v", marked)
+	}
+}
@@ -0,0 +1,84 @@
+//go:build functional
+// +build functional
+
+package sarama
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+// TestFuncGoldenExchanges records the exchanges of a client producing,
+// consuming and committing offsets against the test cluster, and replays them.
+// With -update-golden, they are saved as the golden file of the Kafka version.
+func TestFuncGoldenExchanges(t *testing.T) {
+	setupFunctionalTest(t)
+	defer teardownFunctionalTest(t)
+
+	recorder := newGoldenRecorder()
+	config := NewFunctionalTestConfig()
+	config.Producer.Return.Successes = true
+	config.Net.Proxy.Enable = true
+	config.Net.Proxy.Dialer = recorder
+
+	client, err := NewClient(FunctionalTestEnv.KafkaBrokerAddrs, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer safeClose(t, client)
+
+	producer, err := NewSyncProducerFromClient(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	partition, offset, err := producer.SendMessage(&ProducerMessage{
+		Topic:   "test.1",
+		Key:     StringEncoder("golden"),
+		Value:   StringEncoder("golden"),
+		Headers: []RecordHeader{{Key: []byte("golden"), Value: []byte("1")}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	safeClose(t, producer)
+
+	consumer, err := NewConsumerFromClient(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pc, err := consumer.ConsumePartition("test.1", partition, offset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-pc.Messages()
+	safeClose(t, pc)
+	safeClose(t, consumer)
+
+	offsetManager, err := NewOffsetManagerFromClient("golden", client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pom, err := offsetManager.ManagePartition("test.1", partition)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pom.MarkOffset(offset+1, "golden")
+	offsetManager.Commit()
+	safeClose(t, pom)
+	safeClose(t, offsetManager)
+
+	exchanges := recorder.Exchanges()
+	for i, e := range exchanges {
+		if err := replayGoldenExchange(e); err != nil {
+			t.Errorf("exchange %d (%s): %v", i, e, err)
+		}
+	}
+
+	if *updateGolden {
+		path := filepath.Join(goldenDir, fmt.Sprintf("kafka-%s.json", FunctionalTestEnv.KafkaVersion))
+		if err := writeGoldenFile(path, exchanges); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
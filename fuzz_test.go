@@ -0,0 +1,98 @@
+//go:build go1.18
+// +build go1.18
+
+package sarama
+
+import (
+	"bytes"
+	"testing"
+)
+
+// The fuzz targets below feed arbitrary bytes to the decoders of the
+// requests and responses, which must return an error rather than panic on
+// corrupted or malicious input. Run them with, for instance:
+//
+//	go test -run '^$' -fuzz FuzzDecodeResponse -fuzztime 1m
+
+// fuzzSeedBodies returns the bodies of all the requests allocateBody knows
+// of, at every version up to maxVersion, to seed the fuzzers.
+func fuzzSeedBodies(maxVersion int16) []protocolBody {
+	var bodies []protocolBody
+	for key := range names {
+		for version := int16(0); version <= maxVersion; version++ {
+			if body := allocateBody(key, version); body != nil && body.isValidVersion() {
+				bodies = append(bodies, body)
+			}
+		}
+	}
+	return bodies
+}
+
+func FuzzDecodeRequest(f *testing.F) {
+	for _, body := range fuzzSeedBodies(15) {
+		if encoded, err := encode(&request{correlationID: 1, clientID: "fuzz", body: body}, nil); err == nil {
+			f.Add(encoded)
+		}
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		req, _, err := decodeRequest(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		// What decodes has to encode again.
+		if _, err := encode(req, nil); err != nil {
+			t.Logf("failed to encode the decoded %T: %v", req.body, err)
+		}
+	})
+}
+
+func FuzzDecodeResponse(f *testing.F) {
+	for _, body := range fuzzSeedBodies(15) {
+		res := allocateResponseBody(body)
+		if res == nil {
+			continue
+		}
+		if encoded, err := encode(res, nil); err == nil {
+			f.Add(body.key(), body.version(), encoded)
+		}
+	}
+
+	f.Fuzz(func(t *testing.T, key, version int16, data []byte) {
+		body := allocateBody(key, version)
+		if body == nil || !body.isValidVersion() {
+			return
+		}
+		res := allocateResponseBody(body)
+		if res == nil {
+			return
+		}
+		if err := versionedDecode(data, res, version, nil); err != nil {
+			return
+		}
+		if _, err := encode(res, nil); err != nil {
+			t.Logf("failed to encode the decoded %T: %v", res, err)
+		}
+	})
+}
+
+func FuzzDecodeRecords(f *testing.F) {
+	batch := NewMockRecordBatch(0).
+		AddRecord(StringEncoder("key"), StringEncoder("value"), RecordHeader{Key: []byte("h"), Value: []byte("v")}).
+		RecordBatch()
+	if encoded, err := encode(batch, nil); err == nil {
+		f.Add(encoded)
+	}
+	set := &MessageSet{}
+	set.addMessage(&Message{Key: []byte("key"), Value: []byte("value")})
+	if encoded, err := encode(set, nil); err == nil {
+		f.Add(encoded)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		// A partial trailing record is expected at the end of the records
+		// of a fetch response, so decode them the way it does.
+		records := &Records{}
+		_ = records.decode(&realDecoder{raw: data})
+	})
+}
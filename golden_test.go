@@ -0,0 +1,332 @@
+package sarama
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+
+	"golang.org/x/net/proxy"
+)
+
+// Golden files hold request/response exchanges recorded from live brokers, so
+// that the decoders and encoders can be checked against real broker output, in
+// particular when bumping the version of a protocol message. They are recorded
+// by the functional tests when run with -update-golden, and replayed by
+// TestGoldenFiles.
+var updateGolden = flag.Bool("update-golden", false, "record the golden files of the functional tests in "+goldenDir)
+
+const goldenDir = "testdata/golden"
+
+// goldenExchange is a request sent to a broker and its response, without their
+// length prefix.
+type goldenExchange struct {
+	APIKey     int16  `json:"api_key"`
+	APIVersion int16  `json:"api_version"`
+	Request    string `json:"request"`
+	Response   string `json:"response"`
+}
+
+func (e goldenExchange) String() string {
+	return fmt.Sprintf("%s v%d", names[e.APIKey], e.APIVersion)
+}
+
+func readGoldenFile(path string) ([]goldenExchange, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var exchanges []goldenExchange
+	err = json.Unmarshal(buf, &exchanges)
+	return exchanges, err
+}
+
+func writeGoldenFile(path string, exchanges []goldenExchange) error {
+	buf, err := json.MarshalIndent(exchanges, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(buf, '\n'), 0o644)
+}
+
+// replayGoldenExchange decodes the request and the response of an exchange,
+// encodes them again and checks that the result decodes to the same messages,
+// of the same length as the ones sent by the broker.
+func replayGoldenExchange(e goldenExchange) error {
+	rawReq, err := hex.DecodeString(e.Request)
+	if err != nil {
+		return err
+	}
+	rawRes, err := hex.DecodeString(e.Response)
+	if err != nil {
+		return err
+	}
+
+	req := &request{}
+	if err := decode(rawReq, req, nil); err != nil {
+		return fmt.Errorf("decoding request: %w", err)
+	}
+	if err := checkGoldenRoundTrip(req.body, len(rawReq)-requestHeaderLength(req)); err != nil {
+		return fmt.Errorf("request: %w", err)
+	}
+
+	res := allocateResponseBody(req.body)
+	if res == nil {
+		return nil
+	}
+	header := &responseHeader{}
+	framed := make([]byte, 4+len(rawRes))
+	binary.BigEndian.PutUint32(framed, uint32(len(rawRes)))
+	copy(framed[4:], rawRes)
+	headerDecoder := &realDecoder{raw: framed}
+	if err := header.decode(headerDecoder, res.headerVersion()); err != nil {
+		return fmt.Errorf("decoding response header: %w", err)
+	}
+	body := framed[headerDecoder.off:]
+	if err := versionedDecode(body, res, e.APIVersion, nil); err != nil {
+		return fmt.Errorf("decoding response: %w", err)
+	}
+	if err := checkGoldenRoundTrip(res, len(body)); err != nil {
+		return fmt.Errorf("response: %w", err)
+	}
+	return nil
+}
+
+// requestHeaderLength returns the length of the header of a request sent by sarama.
+func requestHeaderLength(req *request) int {
+	length := 8 + 2 + len(req.clientID)
+	if req.body.headerVersion() >= 2 {
+		length++
+	}
+	return length
+}
+
+// checkGoldenRoundTrip checks that body encodes to length bytes, decoding to
+// an identical body. The bytes themselves are not compared, as some messages
+// hold maps, whose encoding order varies.
+func checkGoldenRoundTrip(body protocolBody, length int) error {
+	encoded, err := encode(body, nil)
+	if err != nil {
+		return fmt.Errorf("encoding: %w", err)
+	}
+	if len(encoded) != length {
+		return fmt.Errorf("encoded to %d bytes instead of %d", len(encoded), length)
+	}
+	decoded := reflect.New(reflect.TypeOf(body).Elem()).Interface().(protocolBody)
+	if err := versionedDecode(encoded, decoded, body.version(), nil); err != nil {
+		return fmt.Errorf("decoding the encoded message: %w", err)
+	}
+	// Encoding caches state, such as compressed records, so encode the
+	// decoded body too before comparing them.
+	if _, err := encode(decoded, nil); err != nil {
+		return fmt.Errorf("encoding the decoded message: %w", err)
+	}
+	if !reflect.DeepEqual(body, decoded) {
+		return errors.New("decoded to a different message once encoded again")
+	}
+	return nil
+}
+
+// goldenRecorder is a proxy.Dialer recording the exchanges of the connections
+// it dials. Set it as Config.Net.Proxy.Dialer to record the exchanges of a client.
+type goldenRecorder struct {
+	dialer    proxy.Dialer
+	lock      sync.Mutex
+	exchanges []goldenExchange
+}
+
+func newGoldenRecorder() *goldenRecorder {
+	return &goldenRecorder{dialer: &net.Dialer{}}
+}
+
+func (r *goldenRecorder) Dial(network, addr string) (net.Conn, error) {
+	conn, err := r.dialer.Dial(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	return &goldenConn{Conn: conn, recorder: r, pending: make(map[int32]*goldenExchange)}, nil
+}
+
+func (r *goldenRecorder) record(e goldenExchange) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.exchanges = append(r.exchanges, e)
+}
+
+func (r *goldenRecorder) Exchanges() []goldenExchange {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append([]goldenExchange(nil), r.exchanges...)
+}
+
+// goldenConn splits the bytes written and read on a connection into frames,
+// matching the responses to their requests by correlation ID.
+type goldenConn struct {
+	net.Conn
+	recorder *goldenRecorder
+
+	lock     sync.Mutex
+	written  []byte
+	read     []byte
+	pending  map[int32]*goldenExchange
+	disabled bool
+}
+
+func (c *goldenConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.written = append(c.written, b[:n]...)
+	for _, frame := range c.frames(&c.written) {
+		if len(frame) < 8 {
+			c.disabled = true
+			continue
+		}
+		c.pending[int32(binary.BigEndian.Uint32(frame[4:]))] = &goldenExchange{
+			APIKey:     int16(binary.BigEndian.Uint16(frame)),
+			APIVersion: int16(binary.BigEndian.Uint16(frame[2:])),
+			Request:    hex.EncodeToString(frame),
+		}
+	}
+	return n, err
+}
+
+func (c *goldenConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.read = append(c.read, b[:n]...)
+	for _, frame := range c.frames(&c.read) {
+		if len(frame) < 4 {
+			continue
+		}
+		correlationID := int32(binary.BigEndian.Uint32(frame))
+		if e, ok := c.pending[correlationID]; ok {
+			delete(c.pending, correlationID)
+			e.Response = hex.EncodeToString(frame)
+			c.recorder.record(*e)
+		}
+	}
+	return n, err
+}
+
+// frames removes the complete length-prefixed frames from buf. Once a frame
+// that is not a Kafka request, such as a SASL v0 token, has been written, the
+// connection is no longer recorded.
+func (c *goldenConn) frames(buf *[]byte) [][]byte {
+	var frames [][]byte
+	for !c.disabled && len(*buf) >= 4 {
+		length := int(binary.BigEndian.Uint32(*buf))
+		if len(*buf) < 4+length {
+			break
+		}
+		frames = append(frames, (*buf)[4:4+length])
+		*buf = (*buf)[4+length:]
+	}
+	if c.disabled {
+		*buf = nil
+	}
+	return frames
+}
+
+// TestGoldenFiles replays the exchanges of the golden files.
+func TestGoldenFiles(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join(goldenDir, "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) == 0 {
+		t.Skip("no golden files in", goldenDir)
+	}
+	for _, path := range paths {
+		exchanges, err := readGoldenFile(path)
+		if err != nil {
+			t.Fatal(path, err)
+		}
+		for i, e := range exchanges {
+			if err := replayGoldenExchange(e); err != nil {
+				t.Errorf("%s: exchange %d (%s): %v", path, i, e, err)
+			}
+		}
+	}
+}
+
+func TestGoldenRecorder(t *testing.T) {
+	broker := NewMockBroker(t, 1)
+	defer broker.Close()
+	broker.SetHandlerByMap(map[string]MockResponse{
+		"MetadataRequest": NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader("my_topic", 0, broker.BrokerID()),
+		"ProduceRequest": NewMockProduceResponse(t).
+			SetError("my_topic", 0, ErrNoError),
+	})
+
+	recorder := newGoldenRecorder()
+	config := NewTestConfig()
+	config.Version = V1_0_0_0
+	config.Producer.Return.Successes = true
+	config.Net.Proxy.Enable = true
+	config.Net.Proxy.Dialer = recorder
+	producer, err := NewSyncProducer([]string{broker.Addr()}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := producer.SendMessage(&ProducerMessage{Topic: "my_topic", Value: StringEncoder("golden")}); err != nil {
+		t.Fatal(err)
+	}
+	safeClose(t, producer)
+
+	exchanges := recorder.Exchanges()
+	keys := make([]int16, 0, len(exchanges))
+	for _, e := range exchanges {
+		keys = append(keys, e.APIKey)
+		if err := replayGoldenExchange(e); err != nil {
+			t.Errorf("%s: %v", e, err)
+		}
+	}
+	if !reflect.DeepEqual(keys, []int16{3, 0}) {
+		t.Error("expected the metadata and produce exchanges to be recorded, got", keys)
+	}
+
+	path := filepath.Join(t.TempDir(), "golden.json")
+	if err := writeGoldenFile(path, exchanges); err != nil {
+		t.Fatal(err)
+	}
+	read, err := readGoldenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(read, exchanges) {
+		t.Error("expected the golden file to hold the recorded exchanges")
+	}
+}
+
+func TestGoldenReplayDetectsMismatches(t *testing.T) {
+	e := goldenExchange{
+		APIKey:     18,
+		APIVersion: 0,
+		// ApiVersionsRequest v0, correlation ID 1, client ID "c".
+		Request: "00120000000000010001" + "63",
+		// ApiVersionsResponse v0 with a single API, and a trailing byte.
+		Response: "00000001" + "0000" + "00000001" + "0000" + "0000" + "0008" + "ff",
+	}
+	if err := replayGoldenExchange(e); err == nil {
+		t.Error("expected the trailing byte of the response to be detected")
+	}
+	e.Response = e.Response[:len(e.Response)-2]
+	if err := replayGoldenExchange(e); err != nil {
+		t.Error(err)
+	}
+}
@@ -8,6 +8,12 @@ type InitProducerIDRequest struct {
 	TransactionTimeout time.Duration
 	ProducerID         int64
 	ProducerEpoch      int16
+	// Enable2Pc enables the two-phase commit of the transactions, whose
+	// timeout is then ignored (KIP-939).
+	Enable2Pc bool
+	// KeepPreparedTxn keeps the prepared transaction of the producer, to
+	// complete it after a restart (KIP-939).
+	KeepPreparedTxn bool
 }
 
 func (i *InitProducerIDRequest) encode(pe packetEncoder) error {
@@ -25,6 +31,10 @@ func (i *InitProducerIDRequest) encode(pe packetEncoder) error {
 		pe.putInt64(i.ProducerID)
 		pe.putInt16(i.ProducerEpoch)
 	}
+	if i.Version >= 6 {
+		pe.putBool(i.Enable2Pc)
+		pe.putBool(i.KeepPreparedTxn)
+	}
 	if i.Version >= 2 {
 		pe.putEmptyTaggedFieldArray()
 	}
@@ -59,6 +69,16 @@ func (i *InitProducerIDRequest) decode(pd packetDecoder, version int16) (err err
 		}
 	}
 
+	if i.Version >= 6 {
+		if i.Enable2Pc, err = pd.getBool(); err != nil {
+			return err
+		}
+
+		if i.KeepPreparedTxn, err = pd.getBool(); err != nil {
+			return err
+		}
+	}
+
 	if i.Version >= 2 {
 		if _, err := pd.getEmptyTaggedFieldArray(); err != nil {
 			return err
@@ -85,11 +105,15 @@ func (i *InitProducerIDRequest) headerVersion() int16 {
 }
 
 func (i *InitProducerIDRequest) isValidVersion() bool {
-	return i.Version >= 0 && i.Version <= 4
+	return i.Version >= 0 && i.Version <= 6
 }
 
 func (i *InitProducerIDRequest) requiredVersion() KafkaVersion {
 	switch i.Version {
+	case 6:
+		return V4_1_0_0
+	case 5:
+		return V3_8_0_0
 	case 4:
 		return V2_7_0_0
 	case 3:
@@ -101,6 +125,6 @@ func (i *InitProducerIDRequest) requiredVersion() KafkaVersion {
 	case 0:
 		return V0_11_0_0
 	default:
-		return V2_7_0_0
+		return V4_1_0_0
 	}
 }
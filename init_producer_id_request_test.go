@@ -29,6 +29,16 @@ var (
 		1, 65, // ProducerEpoch
 		0, // empty TaggedFields
 	}
+
+	initProducerIDRequestTwoPhaseCommit = []byte{
+		4, 116, 120, 110, // TransactionID in compact string
+		0, 0, 0, 100, // TransactionTimeout
+		0, 0, 0, 0, 0, 0, 0, 123, // ProducerID
+		1, 65, // ProducerEpoch
+		1, // Enable2Pc
+		1, // KeepPreparedTxn
+		0, // empty TaggedFields
+	}
 )
 
 func TestInitProducerIDRequest(t *testing.T) {
@@ -51,4 +61,10 @@ func TestInitProducerIDRequest(t *testing.T) {
 	req.ProducerEpoch = 321
 
 	testRequest(t, "producer id", req, initProducerIDRequestProducerId)
+
+	req.Version = 6
+	req.Enable2Pc = true
+	req.KeepPreparedTxn = true
+
+	testRequest(t, "two-phase commit", req, initProducerIDRequestTwoPhaseCommit)
 }
@@ -8,6 +8,10 @@ type InitProducerIDResponse struct {
 	Version       int16
 	ProducerID    int64
 	ProducerEpoch int16
+	// OngoingTxnProducerID and OngoingTxnProducerEpoch identify the prepared
+	// transaction kept with KeepPreparedTxn, -1 if none (KIP-939).
+	OngoingTxnProducerID    int64
+	OngoingTxnProducerEpoch int16
 }
 
 func (i *InitProducerIDResponse) encode(pe packetEncoder) error {
@@ -16,6 +20,11 @@ func (i *InitProducerIDResponse) encode(pe packetEncoder) error {
 	pe.putInt64(i.ProducerID)
 	pe.putInt16(i.ProducerEpoch)
 
+	if i.Version >= 6 {
+		pe.putInt64(i.OngoingTxnProducerID)
+		pe.putInt16(i.OngoingTxnProducerEpoch)
+	}
+
 	if i.Version >= 2 {
 		pe.putEmptyTaggedFieldArray()
 	}
@@ -45,6 +54,16 @@ func (i *InitProducerIDResponse) decode(pd packetDecoder, version int16) (err er
 		return err
 	}
 
+	if i.Version >= 6 {
+		if i.OngoingTxnProducerID, err = pd.getInt64(); err != nil {
+			return err
+		}
+
+		if i.OngoingTxnProducerEpoch, err = pd.getInt16(); err != nil {
+			return err
+		}
+	}
+
 	if i.Version >= 2 {
 		if _, err := pd.getEmptyTaggedFieldArray(); err != nil {
 			return err
@@ -70,11 +89,15 @@ func (i *InitProducerIDResponse) headerVersion() int16 {
 }
 
 func (i *InitProducerIDResponse) isValidVersion() bool {
-	return i.Version >= 0 && i.Version <= 4
+	return i.Version >= 0 && i.Version <= 6
 }
 
 func (i *InitProducerIDResponse) requiredVersion() KafkaVersion {
 	switch i.Version {
+	case 6:
+		return V4_1_0_0
+	case 5:
+		return V3_8_0_0
 	case 4:
 		return V2_7_0_0
 	case 3:
@@ -27,6 +27,16 @@ var (
 		0, 0,
 		0,
 	}
+
+	initProducerIDResponseOngoingTxn = []byte{
+		0, 0, 0, 100,
+		0, 0,
+		0, 0, 0, 0, 0, 0, 31, 64, // producerID = 8000
+		0, 2, // epoch
+		0, 0, 0, 0, 0, 0, 31, 64, // ongoingTxnProducerID = 8000
+		0, 1, // ongoingTxnEpoch
+		0,
+	}
 )
 
 func TestInitProducerIDResponse(t *testing.T) {
@@ -45,4 +55,14 @@ func TestInitProducerIDResponse(t *testing.T) {
 
 	resp.Version = 2
 	testResponse(t, "with tagged fields", resp, initProducerIdResponseWithTaggedFields)
+
+	resp = &InitProducerIDResponse{
+		Version:                 6,
+		ThrottleTime:            100 * time.Millisecond,
+		ProducerID:              8000,
+		ProducerEpoch:           2,
+		OngoingTxnProducerID:    8000,
+		OngoingTxnProducerEpoch: 1,
+	}
+	testResponse(t, "with ongoing transaction", resp, initProducerIDResponseOngoingTxn)
 }
@@ -0,0 +1,566 @@
+package sarama
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// ConsumerOffsetsTopic is the internal topic holding the offsets committed
+	// by the consumer groups and the metadata of the groups.
+	ConsumerOffsetsTopic = "__consumer_offsets"
+	// TransactionStateTopic is the internal topic holding the state of the
+	// transactions of the transactional producers.
+	TransactionStateTopic = "__transaction_state"
+)
+
+// OffsetCommitKey is the key of the records of the __consumer_offsets topic
+// holding the offset committed by a group for a partition.
+type OffsetCommitKey struct {
+	Group     string
+	Topic     string
+	Partition int32
+}
+
+// OffsetCommitValue is the offset committed by a group for a partition.
+type OffsetCommitValue struct {
+	Version     int16
+	Offset      int64
+	LeaderEpoch int32 // -1 before version 3
+	Metadata    string
+	// CommitTimestamp is when the offset was committed, and ExpireTimestamp
+	// when it expires, which is only set by version 1.
+	CommitTimestamp, ExpireTimestamp time.Time
+}
+
+func (v *OffsetCommitValue) decode(pd packetDecoder) (err error) {
+	if v.Version, err = pd.getInt16(); err != nil {
+		return err
+	}
+	if v.Version < 0 || v.Version > 4 {
+		return PacketDecodingError{fmt.Sprintf("unknown offset commit value version %d", v.Version)}
+	}
+	flexible := v.Version >= 4
+	if v.Offset, err = pd.getInt64(); err != nil {
+		return err
+	}
+	v.LeaderEpoch = -1
+	if v.Version >= 3 {
+		if v.LeaderEpoch, err = pd.getInt32(); err != nil {
+			return err
+		}
+	}
+	if flexible {
+		v.Metadata, err = pd.getCompactString()
+	} else {
+		v.Metadata, err = pd.getString()
+	}
+	if err != nil {
+		return err
+	}
+	commitTimestamp, err := pd.getInt64()
+	if err != nil {
+		return err
+	}
+	v.CommitTimestamp = time.Unix(0, commitTimestamp*int64(time.Millisecond))
+	if v.Version == 1 {
+		expireTimestamp, err := pd.getInt64()
+		if err != nil {
+			return err
+		}
+		v.ExpireTimestamp = time.Unix(0, expireTimestamp*int64(time.Millisecond))
+	}
+	if flexible {
+		_, err = pd.getEmptyTaggedFieldArray()
+	}
+	return err
+}
+
+// GroupMetadataKey is the key of the records of the __consumer_offsets topic
+// holding the metadata of a group.
+type GroupMetadataKey struct {
+	Group string
+}
+
+// GroupMetadataValue is the metadata of a group, as of its last rebalance.
+type GroupMetadataValue struct {
+	Version      int16
+	ProtocolType string
+	Generation   int32
+	// Protocol is the balance strategy chosen for the group, and Leader the
+	// member ID of its leader.
+	Protocol, Leader *string
+	// CurrentStateTimestamp is set from version 2.
+	CurrentStateTimestamp time.Time
+	Members               []*GroupMetadataMember
+}
+
+// GroupMetadataMember is a member of a group in its metadata.
+type GroupMetadataMember struct {
+	MemberID        string
+	GroupInstanceID *string // from version 3
+	ClientID        string
+	ClientHost      string
+	// RebalanceTimeout is set from version 1.
+	RebalanceTimeout, SessionTimeout time.Duration
+	// Subscription and Assignment are encoded according to ProtocolType, as
+	// ConsumerGroupMemberMetadata and ConsumerGroupMemberAssignment for the
+	// consumer groups.
+	Subscription, Assignment []byte
+}
+
+// getString and getNullableString read the strings of the flexible versions
+// in their compact form.
+type internalTopicDecoder struct {
+	packetDecoder
+	flexible bool
+}
+
+func (d internalTopicDecoder) getString() (string, error) {
+	if d.flexible {
+		return d.getCompactString()
+	}
+	return d.packetDecoder.getString()
+}
+
+func (d internalTopicDecoder) getNullableString() (*string, error) {
+	if d.flexible {
+		return d.getCompactNullableString()
+	}
+	return d.packetDecoder.getNullableString()
+}
+
+func (d internalTopicDecoder) getBytes() ([]byte, error) {
+	if d.flexible {
+		return d.getCompactBytes()
+	}
+	return d.packetDecoder.getBytes()
+}
+
+func (d internalTopicDecoder) getArrayLength() (int, error) {
+	if d.flexible {
+		return d.getCompactArrayLength()
+	}
+	return d.packetDecoder.getArrayLength()
+}
+
+func (d internalTopicDecoder) getTaggedFields() error {
+	if d.flexible {
+		_, err := d.getEmptyTaggedFieldArray()
+		return err
+	}
+	return nil
+}
+
+func (v *GroupMetadataValue) decode(pd packetDecoder) (err error) {
+	if v.Version, err = pd.getInt16(); err != nil {
+		return err
+	}
+	if v.Version < 0 || v.Version > 4 {
+		return PacketDecodingError{fmt.Sprintf("unknown group metadata value version %d", v.Version)}
+	}
+	d := internalTopicDecoder{pd, v.Version >= 4}
+	if v.ProtocolType, err = d.getString(); err != nil {
+		return err
+	}
+	if v.Generation, err = d.getInt32(); err != nil {
+		return err
+	}
+	if v.Protocol, err = d.getNullableString(); err != nil {
+		return err
+	}
+	if v.Leader, err = d.getNullableString(); err != nil {
+		return err
+	}
+	if v.Version >= 2 {
+		timestamp, err := d.getInt64()
+		if err != nil {
+			return err
+		}
+		v.CurrentStateTimestamp = time.Unix(0, timestamp*int64(time.Millisecond))
+	}
+	n, err := d.getArrayLength()
+	if err != nil {
+		return err
+	}
+	v.Members = make([]*GroupMetadataMember, n)
+	for i := range v.Members {
+		member := new(GroupMetadataMember)
+		if member.MemberID, err = d.getString(); err != nil {
+			return err
+		}
+		if v.Version >= 3 {
+			if member.GroupInstanceID, err = d.getNullableString(); err != nil {
+				return err
+			}
+		}
+		if member.ClientID, err = d.getString(); err != nil {
+			return err
+		}
+		if member.ClientHost, err = d.getString(); err != nil {
+			return err
+		}
+		if v.Version >= 1 {
+			timeout, err := d.getInt32()
+			if err != nil {
+				return err
+			}
+			member.RebalanceTimeout = time.Duration(timeout) * time.Millisecond
+		}
+		timeout, err := d.getInt32()
+		if err != nil {
+			return err
+		}
+		member.SessionTimeout = time.Duration(timeout) * time.Millisecond
+		if member.Subscription, err = d.getBytes(); err != nil {
+			return err
+		}
+		if member.Assignment, err = d.getBytes(); err != nil {
+			return err
+		}
+		if err := d.getTaggedFields(); err != nil {
+			return err
+		}
+		v.Members[i] = member
+	}
+	return d.getTaggedFields()
+}
+
+// ConsumerOffsetsRecord is a record of the __consumer_offsets topic, holding
+// either the offset committed by a group for a partition, with OffsetKey set,
+// or the metadata of a group, with GroupKey set. OffsetValue and GroupValue
+// are nil for the tombstones, when the offset or the group was deleted.
+type ConsumerOffsetsRecord struct {
+	Partition int32
+	Offset    int64
+	Timestamp time.Time
+
+	OffsetKey   *OffsetCommitKey
+	OffsetValue *OffsetCommitValue
+	GroupKey    *GroupMetadataKey
+	GroupValue  *GroupMetadataValue
+}
+
+// consumerOffsetsKey decodes the key of a __consumer_offsets record.
+type consumerOffsetsKey struct {
+	offset *OffsetCommitKey
+	group  *GroupMetadataKey
+}
+
+func (k *consumerOffsetsKey) decode(pd packetDecoder) error {
+	version, err := pd.getInt16()
+	if err != nil {
+		return err
+	}
+	switch version {
+	case 0, 1:
+		k.offset = new(OffsetCommitKey)
+		if k.offset.Group, err = pd.getString(); err != nil {
+			return err
+		}
+		if k.offset.Topic, err = pd.getString(); err != nil {
+			return err
+		}
+		k.offset.Partition, err = pd.getInt32()
+		return err
+	case 2:
+		k.group = new(GroupMetadataKey)
+		k.group.Group, err = pd.getString()
+		return err
+	default:
+		return PacketDecodingError{fmt.Sprintf("unknown consumer offsets key version %d", version)}
+	}
+}
+
+// DecodeConsumerOffsetsRecord decodes the key and value of a record of the
+// __consumer_offsets topic. The records of newer formats than the ones known
+// to sarama return a PacketDecodingError.
+func DecodeConsumerOffsetsRecord(key, value []byte) (*ConsumerOffsetsRecord, error) {
+	k := new(consumerOffsetsKey)
+	if err := decode(key, k, nil); err != nil {
+		return nil, err
+	}
+	record := &ConsumerOffsetsRecord{OffsetKey: k.offset, GroupKey: k.group}
+	if value == nil {
+		return record, nil
+	}
+	if k.offset != nil {
+		record.OffsetValue = new(OffsetCommitValue)
+		return record, decode(value, record.OffsetValue, nil)
+	}
+	record.GroupValue = new(GroupMetadataValue)
+	return record, decode(value, record.GroupValue, nil)
+}
+
+// TransactionState is the state of a transaction in the __transaction_state
+// topic.
+type TransactionState int8
+
+const (
+	TransactionEmpty TransactionState = iota
+	TransactionOngoing
+	TransactionPrepareCommit
+	TransactionPrepareAbort
+	TransactionCompleteCommit
+	TransactionCompleteAbort
+	TransactionDead
+	TransactionPrepareEpochFence
+)
+
+func (s TransactionState) String() string {
+	switch s {
+	case TransactionEmpty:
+		return "Empty"
+	case TransactionOngoing:
+		return "Ongoing"
+	case TransactionPrepareCommit:
+		return "PrepareCommit"
+	case TransactionPrepareAbort:
+		return "PrepareAbort"
+	case TransactionCompleteCommit:
+		return "CompleteCommit"
+	case TransactionCompleteAbort:
+		return "CompleteAbort"
+	case TransactionDead:
+		return "Dead"
+	case TransactionPrepareEpochFence:
+		return "PrepareEpochFence"
+	default:
+		return fmt.Sprintf("TransactionState(%d)", int8(s))
+	}
+}
+
+// TransactionLogValue is the state of the transaction of a transactional ID.
+type TransactionLogValue struct {
+	Version       int16
+	ProducerID    int64
+	ProducerEpoch int16
+	Timeout       time.Duration
+	State         TransactionState
+	// Partitions are the partitions of the ongoing transaction, by topic.
+	Partitions map[string][]int32
+	// LastUpdate is when the state last changed, and Start when the
+	// transaction started.
+	LastUpdate, Start time.Time
+}
+
+func (v *TransactionLogValue) decode(pd packetDecoder) (err error) {
+	if v.Version, err = pd.getInt16(); err != nil {
+		return err
+	}
+	if v.Version < 0 || v.Version > 1 {
+		return PacketDecodingError{fmt.Sprintf("unknown transaction log value version %d", v.Version)}
+	}
+	d := internalTopicDecoder{pd, v.Version >= 1}
+	if v.ProducerID, err = d.getInt64(); err != nil {
+		return err
+	}
+	if v.ProducerEpoch, err = d.getInt16(); err != nil {
+		return err
+	}
+	timeout, err := d.getInt32()
+	if err != nil {
+		return err
+	}
+	v.Timeout = time.Duration(timeout) * time.Millisecond
+	state, err := d.getInt8()
+	if err != nil {
+		return err
+	}
+	v.State = TransactionState(state)
+
+	var n int
+	if d.flexible {
+		n, err = d.getCompactArrayLength()
+	} else {
+		n, err = d.getNullableArrayLength()
+	}
+	if err != nil {
+		return err
+	}
+	if n >= 0 {
+		v.Partitions = make(map[string][]int32, n)
+	}
+	for i := 0; i < n; i++ {
+		topic, err := d.getString()
+		if err != nil {
+			return err
+		}
+		var partitions []int32
+		if d.flexible {
+			partitions, err = d.getCompactInt32Array()
+		} else {
+			partitions, err = d.getInt32Array()
+		}
+		if err != nil {
+			return err
+		}
+		if err := d.getTaggedFields(); err != nil {
+			return err
+		}
+		v.Partitions[topic] = partitions
+	}
+
+	lastUpdate, err := d.getInt64()
+	if err != nil {
+		return err
+	}
+	v.LastUpdate = time.Unix(0, lastUpdate*int64(time.Millisecond))
+	start, err := d.getInt64()
+	if err != nil {
+		return err
+	}
+	v.Start = time.Unix(0, start*int64(time.Millisecond))
+	return d.getTaggedFields()
+}
+
+// TransactionLogRecord is a record of the __transaction_state topic, holding
+// the state of the transaction of TransactionalID. Value is nil for the
+// tombstones, when the transactional ID expired.
+type TransactionLogRecord struct {
+	Partition int32
+	Offset    int64
+	Timestamp time.Time
+
+	TransactionalID string
+	Value           *TransactionLogValue
+}
+
+type transactionLogKey struct {
+	transactionalID string
+}
+
+func (k *transactionLogKey) decode(pd packetDecoder) error {
+	version, err := pd.getInt16()
+	if err != nil {
+		return err
+	}
+	d := internalTopicDecoder{pd, version >= 1}
+	if version < 0 || version > 1 {
+		return PacketDecodingError{fmt.Sprintf("unknown transaction log key version %d", version)}
+	}
+	if k.transactionalID, err = d.getString(); err != nil {
+		return err
+	}
+	return d.getTaggedFields()
+}
+
+// DecodeTransactionLogRecord decodes the key and value of a record of the
+// __transaction_state topic. The records of newer formats than the ones
+// known to sarama return a PacketDecodingError.
+func DecodeTransactionLogRecord(key, value []byte) (*TransactionLogRecord, error) {
+	k := new(transactionLogKey)
+	if err := decode(key, k, nil); err != nil {
+		return nil, err
+	}
+	record := &TransactionLogRecord{TransactionalID: k.transactionalID}
+	if value == nil {
+		return record, nil
+	}
+	record.Value = new(TransactionLogValue)
+	return record, decode(value, record.Value, nil)
+}
+
+// ConsumeConsumerOffsets streams the decoded records of all the partitions of
+// the __consumer_offsets topic from initialOffset, such as OffsetOldest or
+// OffsetNewest, until ctx is done, when the channel is closed. It lets audit
+// and lag tools follow the offset commits and the groups without polling. The
+// records which can not be decoded are logged and skipped.
+func ConsumeConsumerOffsets(ctx context.Context, consumer Consumer, initialOffset int64) (<-chan *ConsumerOffsetsRecord, error) {
+	records := make(chan *ConsumerOffsetsRecord)
+	err := consumeInternalTopic(ctx, consumer, ConsumerOffsetsTopic, initialOffset, func(msg *ConsumerMessage) bool {
+		record, err := DecodeConsumerOffsetsRecord(msg.Key, msg.Value)
+		if err != nil {
+			Logger.Printf("consumer/%s skipping the record of partition %d at offset %d: %v\n", ConsumerOffsetsTopic, msg.Partition, msg.Offset, err)
+			return true
+		}
+		record.Partition, record.Offset, record.Timestamp = msg.Partition, msg.Offset, msg.Timestamp
+		select {
+		case records <- record:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}, func() { close(records) })
+	if err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// ConsumeTransactionLog streams the decoded records of all the partitions of
+// the __transaction_state topic from initialOffset, such as OffsetOldest or
+// OffsetNewest, until ctx is done, when the channel is closed. The records
+// which can not be decoded are logged and skipped.
+func ConsumeTransactionLog(ctx context.Context, consumer Consumer, initialOffset int64) (<-chan *TransactionLogRecord, error) {
+	records := make(chan *TransactionLogRecord)
+	err := consumeInternalTopic(ctx, consumer, TransactionStateTopic, initialOffset, func(msg *ConsumerMessage) bool {
+		record, err := DecodeTransactionLogRecord(msg.Key, msg.Value)
+		if err != nil {
+			Logger.Printf("consumer/%s skipping the record of partition %d at offset %d: %v\n", TransactionStateTopic, msg.Partition, msg.Offset, err)
+			return true
+		}
+		record.Partition, record.Offset, record.Timestamp = msg.Partition, msg.Offset, msg.Timestamp
+		select {
+		case records <- record:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}, func() { close(records) })
+	if err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// consumeInternalTopic consumes all the partitions of topic from
+// initialOffset, passing their messages to handle until it returns false or
+// ctx is done, then closes the partition consumers and calls done.
+func consumeInternalTopic(ctx context.Context, consumer Consumer, topic string, initialOffset int64, handle func(*ConsumerMessage) bool, done func()) error {
+	partitions, err := consumer.Partitions(topic)
+	if err != nil {
+		return err
+	}
+	pcs := make([]PartitionConsumer, 0, len(partitions))
+	for _, partition := range partitions {
+		pc, err := consumer.ConsumePartition(topic, partition, initialOffset)
+		if err != nil {
+			for _, pc := range pcs {
+				pc.AsyncClose()
+			}
+			return err
+		}
+		pcs = append(pcs, pc)
+	}
+
+	var wg sync.WaitGroup
+	for _, pc := range pcs {
+		wg.Add(1)
+		go func(pc PartitionConsumer) {
+			defer wg.Done()
+			defer pc.AsyncClose()
+			for {
+				select {
+				case msg, ok := <-pc.Messages():
+					if !ok || !handle(msg) {
+						return
+					}
+				case err, ok := <-pc.Errors():
+					if ok {
+						Logger.Printf("consumer/%s %v\n", topic, err)
+					}
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(pc)
+	}
+	go func() {
+		wg.Wait()
+		done()
+	}()
+	return nil
+}
@@ -0,0 +1,234 @@
+package sarama
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var (
+	offsetCommitKeyBytes = []byte{
+		0, 1, // version
+		0, 5, 'g', 'r', 'o', 'u', 'p',
+		0, 5, 't', 'o', 'p', 'i', 'c',
+		0, 0, 0, 3, // partition
+	}
+
+	offsetCommitValueV3Bytes = []byte{
+		0, 3, // version
+		0, 0, 0, 0, 0, 0, 0, 42, // offset
+		0, 0, 0, 7, // leader epoch
+		0, 4, 'm', 'e', 't', 'a',
+		0, 0, 0, 0, 0, 0, 0x03, 0xE8, // commit timestamp
+	}
+
+	offsetCommitValueV4Bytes = []byte{
+		0, 4, // version
+		0, 0, 0, 0, 0, 0, 0, 42, // offset
+		0, 0, 0, 7, // leader epoch
+		5, 'm', 'e', 't', 'a',
+		0, 0, 0, 0, 0, 0, 0x03, 0xE8, // commit timestamp
+		0, // tagged fields
+	}
+
+	groupMetadataKeyBytes = []byte{
+		0, 2, // version
+		0, 5, 'g', 'r', 'o', 'u', 'p',
+	}
+
+	groupMetadataValueV3Bytes = []byte{
+		0, 3, // version
+		0, 8, 'c', 'o', 'n', 's', 'u', 'm', 'e', 'r',
+		0, 0, 0, 2, // generation
+		0, 5, 'r', 'a', 'n', 'g', 'e',
+		0, 2, 'm', '1',
+		0, 0, 0, 0, 0, 0, 0x07, 0xD0, // current state timestamp
+		0, 0, 0, 1, // members
+		0, 2, 'm', '1',
+		0xFF, 0xFF, // group instance ID
+		0, 6, 's', 'a', 'r', 'a', 'm', 'a',
+		0, 4, 'h', 'o', 's', 't',
+		0, 0, 0xEA, 0x60, // rebalance timeout
+		0, 0, 0x27, 0x10, // session timeout
+		0, 0, 0, 1, 0x01,
+		0, 0, 0, 1, 0x02,
+	}
+
+	transactionLogKeyBytes = []byte{
+		0, 0, // version
+		0, 3, 't', 'x', 'n',
+	}
+
+	transactionLogValueV0Bytes = []byte{
+		0, 0, // version
+		0, 0, 0, 0, 0, 0, 0, 9, // producer ID
+		0, 1, // producer epoch
+		0, 0, 0xEA, 0x60, // timeout
+		1,          // ongoing
+		0, 0, 0, 1, // partitions
+		0, 5, 't', 'o', 'p', 'i', 'c',
+		0, 0, 0, 2, 0, 0, 0, 0, 0, 0, 0, 1,
+		0, 0, 0, 0, 0, 0, 0x07, 0xD0, // last update
+		0, 0, 0, 0, 0, 0, 0x03, 0xE8, // start
+	}
+
+	transactionLogValueV1Bytes = []byte{
+		0, 1, // version
+		0, 0, 0, 0, 0, 0, 0, 9, // producer ID
+		0, 1, // producer epoch
+		0, 0, 0xEA, 0x60, // timeout
+		4,                            // complete commit
+		0,                            // no partitions
+		0, 0, 0, 0, 0, 0, 0x07, 0xD0, // last update
+		0, 0, 0, 0, 0, 0, 0x03, 0xE8, // start
+		0, // tagged fields
+	}
+)
+
+func TestDecodeConsumerOffsetsOffsetCommit(t *testing.T) {
+	for name, value := range map[string][]byte{"v3": offsetCommitValueV3Bytes, "v4": offsetCommitValueV4Bytes} {
+		record, err := DecodeConsumerOffsetsRecord(offsetCommitKeyBytes, value)
+		if err != nil {
+			t.Fatal(name, err)
+		}
+		if record.GroupKey != nil || record.GroupValue != nil {
+			t.Error(name, "unexpected group metadata", record)
+		}
+		if want := (OffsetCommitKey{Group: "group", Topic: "topic", Partition: 3}); *record.OffsetKey != want {
+			t.Error(name, "unexpected key", record.OffsetKey)
+		}
+		v := record.OffsetValue
+		if v.Offset != 42 || v.LeaderEpoch != 7 || v.Metadata != "meta" || !v.CommitTimestamp.Equal(time.Unix(1, 0)) {
+			t.Error(name, "unexpected value", v)
+		}
+	}
+
+	record, err := DecodeConsumerOffsetsRecord(offsetCommitKeyBytes, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.OffsetKey == nil || record.OffsetValue != nil {
+		t.Error("expected a tombstone, got", record)
+	}
+}
+
+func TestDecodeConsumerOffsetsGroupMetadata(t *testing.T) {
+	record, err := DecodeConsumerOffsetsRecord(groupMetadataKeyBytes, groupMetadataValueV3Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.OffsetKey != nil || record.GroupKey.Group != "group" {
+		t.Error("unexpected key", record)
+	}
+	protocol, leader := "range", "m1"
+	want := &GroupMetadataValue{
+		Version:               3,
+		ProtocolType:          "consumer",
+		Generation:            2,
+		Protocol:              &protocol,
+		Leader:                &leader,
+		CurrentStateTimestamp: time.Unix(2, 0),
+		Members: []*GroupMetadataMember{{
+			MemberID:         "m1",
+			ClientID:         "sarama",
+			ClientHost:       "host",
+			RebalanceTimeout: time.Minute,
+			SessionTimeout:   10 * time.Second,
+			Subscription:     []byte{1},
+			Assignment:       []byte{2},
+		}},
+	}
+	if !reflect.DeepEqual(record.GroupValue, want) {
+		t.Errorf("expected %+v, got %+v", want, record.GroupValue)
+	}
+}
+
+func TestDecodeConsumerOffsetsUnknownVersion(t *testing.T) {
+	if _, err := DecodeConsumerOffsetsRecord([]byte{0, 9, 0, 0}, nil); err == nil {
+		t.Error("expected an error for an unknown key version")
+	}
+	value := append([]byte{0, 9}, offsetCommitValueV3Bytes[2:]...)
+	if _, err := DecodeConsumerOffsetsRecord(offsetCommitKeyBytes, value); err == nil {
+		t.Error("expected an error for an unknown value version")
+	}
+}
+
+func TestDecodeTransactionLog(t *testing.T) {
+	record, err := DecodeTransactionLogRecord(transactionLogKeyBytes, transactionLogValueV0Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &TransactionLogValue{
+		ProducerID:    9,
+		ProducerEpoch: 1,
+		Timeout:       time.Minute,
+		State:         TransactionOngoing,
+		Partitions:    map[string][]int32{"topic": {0, 1}},
+		LastUpdate:    time.Unix(2, 0),
+		Start:         time.Unix(1, 0),
+	}
+	if record.TransactionalID != "txn" || !reflect.DeepEqual(record.Value, want) {
+		t.Errorf("expected %+v, got %s %+v", want, record.TransactionalID, record.Value)
+	}
+
+	record, err = DecodeTransactionLogRecord(transactionLogKeyBytes, transactionLogValueV1Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.Value.State != TransactionCompleteCommit || len(record.Value.Partitions) != 0 {
+		t.Errorf("unexpected value %+v", record.Value)
+	}
+	if record.Value.State.String() != "CompleteCommit" {
+		t.Error("unexpected state name", record.Value.State)
+	}
+}
+
+func TestConsumeConsumerOffsets(t *testing.T) {
+	broker0 := NewMockBroker(t, 0)
+	defer broker0.Close()
+
+	batch := NewMockRecordBatch(0).
+		AddRecord(ByteEncoder(offsetCommitKeyBytes), ByteEncoder(offsetCommitValueV3Bytes)).
+		AddRecord(ByteEncoder{0, 9}, nil).
+		AddRecord(ByteEncoder(groupMetadataKeyBytes), ByteEncoder(groupMetadataValueV3Bytes))
+	fetch := NewMockFetchResponse(t, 1).
+		SetRecordBatch(ConsumerOffsetsTopic, 0, batch).
+		SetHighWaterMark(ConsumerOffsetsTopic, 0, 3)
+	broker0.SetHandlerByMap(map[string]MockResponse{
+		"MetadataRequest": NewMockMetadataResponse(t).
+			SetBroker(broker0.Addr(), broker0.BrokerID()).
+			SetLeader(ConsumerOffsetsTopic, 0, broker0.BrokerID()),
+		"OffsetRequest": NewMockOffsetResponse(t).
+			SetOffset(ConsumerOffsetsTopic, 0, OffsetOldest, 0).
+			SetOffset(ConsumerOffsetsTopic, 0, OffsetNewest, 3),
+		"FetchRequest": fetch,
+	})
+
+	config := NewTestConfig()
+	config.Version = V0_11_0_0
+	consumer, err := NewConsumer([]string{broker0.Addr()}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer safeClose(t, consumer)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	records, err := ConsumeConsumerOffsets(ctx, consumer, OffsetOldest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the record with an unknown key version is skipped
+	first, second := <-records, <-records
+	if first.OffsetKey == nil || first.OffsetValue.Offset != 42 || first.Offset != 0 {
+		t.Errorf("unexpected first record %+v", first)
+	}
+	if second.GroupKey == nil || second.GroupValue.Generation != 2 || second.Offset != 2 {
+		t.Errorf("unexpected second record %+v", second)
+	}
+
+	cancel()
+	for range records {
+	}
+}
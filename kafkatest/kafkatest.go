@@ -0,0 +1,337 @@
+/*
+Package kafkatest provides helpers to run functional tests of Sarama applications
+against a real, single-node, Kafka cluster.
+
+Start runs a Kafka broker in KRaft mode, or a Redpanda broker, in a Docker container
+and waits for it to accept requests. The Cluster it returns provides the broker
+addresses and a ready-to-use Config, and helpers to create topics and wait for their
+replicas to be in sync:
+
+	func TestOrders(t *testing.T) {
+		cluster := kafkatest.StartT(t, kafkatest.Options{})
+		if err := cluster.CreateTopic(context.Background(), "orders", 4, 1); err != nil {
+			t.Fatal(err)
+		}
+		producer, err := sarama.NewSyncProducer(cluster.Addrs, cluster.Config())
+		...
+	}
+
+Containers are managed using the docker CLI, which must be on the PATH, so that
+this package does not add any dependency to Sarama. Setting the KAFKA_PEERS
+environment variable to a comma separated list of brokers uses an existing
+cluster instead, and KAFKA_VERSION sets the version of the protocol used to
+talk to it.
+
+NOTE: this package currently does not fall under the API stability
+guarantee of Sarama as it is still considered experimental.
+*/
+package kafkatest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// Flavor is the implementation of the Kafka protocol run by Start.
+type Flavor int
+
+const (
+	// Kafka runs the Apache Kafka broker in KRaft mode, without ZooKeeper.
+	Kafka Flavor = iota
+	// Redpanda runs a Redpanda broker in development mode.
+	Redpanda
+)
+
+const (
+	defaultKafkaImage    = "apache/kafka:3.7.0"
+	defaultRedpandaImage = "docker.redpanda.com/redpandadata/redpanda:v23.3.5"
+	defaultStartTimeout  = 2 * time.Minute
+)
+
+// ErrDockerUnavailable is returned by Start when the docker CLI cannot be found.
+var ErrDockerUnavailable = errors.New("kafkatest: docker is not available")
+
+// Options configures the cluster run by Start. The zero value runs the default
+// Kafka image.
+type Options struct {
+	// Flavor is the broker implementation to run (defaults to Kafka).
+	Flavor Flavor
+	// Image is the Docker image of the broker (defaults to apache/kafka:3.7.0
+	// or to a recent Redpanda release, depending on Flavor).
+	Image string
+	// Version is the version of the protocol used by the Config of the
+	// cluster (defaults to V3_5_0_0).
+	Version sarama.KafkaVersion
+	// StartTimeout is the time the broker has to start accepting requests
+	// (defaults to 2 minutes).
+	StartTimeout time.Duration
+	// Env adds environment variables to the broker container, such as
+	// KAFKA_AUTO_CREATE_TOPICS_ENABLE=false for the Kafka flavor.
+	Env []string
+}
+
+// Cluster is a running single-node cluster.
+type Cluster struct {
+	// Addrs are the addresses of the brokers of the cluster.
+	Addrs []string
+	// Version is the version of the protocol used to talk to the cluster.
+	Version sarama.KafkaVersion
+
+	containerID string
+}
+
+// Start runs a single-node cluster in a Docker container as configured by opts, and
+// waits for it to accept requests. If the KAFKA_PEERS environment variable is set,
+// it returns the existing cluster it points to instead. Close removes the container.
+func Start(ctx context.Context, opts Options) (*Cluster, error) {
+	version := opts.Version
+	if version == (sarama.KafkaVersion{}) {
+		version = sarama.V3_5_0_0
+	}
+	if v, ok := os.LookupEnv("KAFKA_VERSION"); ok {
+		parsed, err := sarama.ParseKafkaVersion(v)
+		if err != nil {
+			return nil, fmt.Errorf("kafkatest: invalid KAFKA_VERSION: %w", err)
+		}
+		version = parsed
+	}
+
+	if peers := os.Getenv("KAFKA_PEERS"); peers != "" {
+		c := &Cluster{Addrs: strings.Split(peers, ","), Version: version}
+		return c, c.waitReady(ctx, startTimeout(opts))
+	}
+
+	if _, err := exec.LookPath("docker"); err != nil {
+		return nil, ErrDockerUnavailable
+	}
+
+	port, err := freePort()
+	if err != nil {
+		return nil, fmt.Errorf("kafkatest: failed to find a free port: %w", err)
+	}
+	addr := fmt.Sprintf("127.0.0.1:%d", port)
+
+	args := []string{"run", "-d", "--rm", "-p", fmt.Sprintf("%s:9092", addr)}
+	for _, env := range append(containerEnv(opts.Flavor, addr), opts.Env...) {
+		args = append(args, "-e", env)
+	}
+	args = append(args, image(opts))
+	args = append(args, containerArgs(opts.Flavor, addr)...)
+
+	out, err := docker(ctx, args...)
+	if err != nil {
+		return nil, fmt.Errorf("kafkatest: failed to start the broker container: %w", err)
+	}
+
+	c := &Cluster{Addrs: []string{addr}, Version: version, containerID: strings.TrimSpace(out)}
+	if err := c.waitReady(ctx, startTimeout(opts)); err != nil {
+		logs, _ := docker(context.Background(), "logs", "--tail", "50", c.containerID)
+		_ = c.Close()
+		return nil, fmt.Errorf("%w\n%s", err, logs)
+	}
+	return c, nil
+}
+
+// Config returns a new Config to connect to the cluster.
+func (c *Cluster) Config() *sarama.Config {
+	config := sarama.NewConfig()
+	config.Version = c.Version
+	config.ClientID = "sarama-kafkatest"
+	return config
+}
+
+// Close removes the container of the cluster. It does nothing for the clusters
+// provided using KAFKA_PEERS.
+func (c *Cluster) Close() error {
+	if c.containerID == "" {
+		return nil
+	}
+	_, err := docker(context.Background(), "rm", "-f", c.containerID)
+	c.containerID = ""
+	return err
+}
+
+// CreateTopic creates a topic, unless it already exists, and waits for the
+// replicas of all its partitions to be in sync.
+func (c *Cluster) CreateTopic(ctx context.Context, topic string, partitions int32, replicationFactor int16) error {
+	admin, err := sarama.NewClusterAdmin(c.Addrs, c.Config())
+	if err != nil {
+		return err
+	}
+	defer admin.Close()
+
+	err = admin.CreateTopic(topic, &sarama.TopicDetail{
+		NumPartitions:     partitions,
+		ReplicationFactor: replicationFactor,
+	}, false)
+	if err != nil && !errors.Is(err, sarama.ErrTopicAlreadyExists) {
+		return fmt.Errorf("kafkatest: failed to create topic %s: %w", topic, err)
+	}
+	return c.WaitForISR(ctx, topic)
+}
+
+// WaitForISR waits until every partition of topic has a leader and all its
+// replicas in sync, or ctx is done.
+func (c *Cluster) WaitForISR(ctx context.Context, topic string) error {
+	client, err := sarama.NewClient(c.Addrs, c.Config())
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	for {
+		ready, err := topicInSync(client, topic)
+		if ready {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			if err != nil {
+				return fmt.Errorf("kafkatest: topic %s not in sync: %w", topic, err)
+			}
+			return fmt.Errorf("kafkatest: topic %s not in sync: %w", topic, ctx.Err())
+		case <-time.After(250 * time.Millisecond):
+		}
+	}
+}
+
+func topicInSync(client sarama.Client, topic string) (bool, error) {
+	if err := client.RefreshMetadata(topic); err != nil {
+		return false, err
+	}
+	partitions, err := client.Partitions(topic)
+	if err != nil || len(partitions) == 0 {
+		return false, err
+	}
+	for _, partition := range partitions {
+		if _, err := client.Leader(topic, partition); err != nil {
+			return false, err
+		}
+		replicas, err := client.Replicas(topic, partition)
+		if err != nil {
+			return false, err
+		}
+		isr, err := client.InSyncReplicas(topic, partition)
+		if err != nil {
+			return false, err
+		}
+		if len(isr) < len(replicas) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// waitReady waits until the cluster returns metadata with at least one broker.
+func (c *Cluster) waitReady(ctx context.Context, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	config := c.Config()
+	config.Net.DialTimeout = time.Second
+	config.Metadata.Retry.Max = 0
+
+	var lastErr error
+	for {
+		client, err := sarama.NewClient(c.Addrs, config)
+		if err == nil {
+			if len(client.Brokers()) > 0 {
+				_ = client.Close()
+				return nil
+			}
+			_ = client.Close()
+		}
+		lastErr = err
+
+		select {
+		case <-ctx.Done():
+			if lastErr != nil {
+				return fmt.Errorf("kafkatest: timed out waiting for the broker at %s: %w", strings.Join(c.Addrs, ","), lastErr)
+			}
+			return fmt.Errorf("kafkatest: timed out waiting for the broker at %s", strings.Join(c.Addrs, ","))
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+}
+
+func startTimeout(opts Options) time.Duration {
+	if opts.StartTimeout > 0 {
+		return opts.StartTimeout
+	}
+	return defaultStartTimeout
+}
+
+func image(opts Options) string {
+	switch {
+	case opts.Image != "":
+		return opts.Image
+	case opts.Flavor == Redpanda:
+		return defaultRedpandaImage
+	default:
+		return defaultKafkaImage
+	}
+}
+
+// containerEnv returns the environment of a broker advertising addr.
+func containerEnv(flavor Flavor, addr string) []string {
+	if flavor == Redpanda {
+		return nil
+	}
+	return []string{
+		"KAFKA_NODE_ID=1",
+		"KAFKA_PROCESS_ROLES=broker,controller",
+		"KAFKA_LISTENERS=PLAINTEXT://:9092,CONTROLLER://:9093",
+		"KAFKA_ADVERTISED_LISTENERS=PLAINTEXT://" + addr,
+		"KAFKA_CONTROLLER_LISTENER_NAMES=CONTROLLER",
+		"KAFKA_LISTENER_SECURITY_PROTOCOL_MAP=CONTROLLER:PLAINTEXT,PLAINTEXT:PLAINTEXT",
+		"KAFKA_CONTROLLER_QUORUM_VOTERS=1@localhost:9093",
+		"KAFKA_OFFSETS_TOPIC_REPLICATION_FACTOR=1",
+		"KAFKA_TRANSACTION_STATE_LOG_REPLICATION_FACTOR=1",
+		"KAFKA_TRANSACTION_STATE_LOG_MIN_ISR=1",
+		"KAFKA_GROUP_INITIAL_REBALANCE_DELAY_MS=0",
+	}
+}
+
+// containerArgs returns the command of a broker advertising addr.
+func containerArgs(flavor Flavor, addr string) []string {
+	if flavor != Redpanda {
+		return nil
+	}
+	return []string{
+		"redpanda", "start",
+		"--mode", "dev-container",
+		"--smp", "1",
+		"--kafka-addr", "PLAINTEXT://0.0.0.0:9092",
+		"--advertise-kafka-addr", "PLAINTEXT://" + addr,
+	}
+}
+
+func docker(ctx context.Context, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "docker", args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("docker %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
@@ -0,0 +1,55 @@
+//go:build functional
+// +build functional
+
+package kafkatest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+func TestClusterProducesAndConsumes(t *testing.T) {
+	for _, flavor := range []Flavor{Kafka, Redpanda} {
+		cluster := StartT(t, Options{Flavor: flavor})
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		if err := cluster.CreateTopic(ctx, "kafkatest", 2, 1); err != nil {
+			t.Fatal(err)
+		}
+
+		config := cluster.Config()
+		config.Producer.Return.Successes = true
+		producer, err := sarama.NewSyncProducer(cluster.Addrs, config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		partition, offset, err := producer.SendMessage(&sarama.ProducerMessage{Topic: "kafkatest", Value: sarama.StringEncoder("hello")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = producer.Close()
+
+		consumer, err := sarama.NewConsumer(cluster.Addrs, cluster.Config())
+		if err != nil {
+			t.Fatal(err)
+		}
+		pc, err := consumer.ConsumePartition("kafkatest", partition, offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case msg := <-pc.Messages():
+			if string(msg.Value) != "hello" {
+				t.Error("unexpected message", string(msg.Value))
+			}
+		case <-ctx.Done():
+			t.Fatal("timed out waiting for the message")
+		}
+		_ = pc.Close()
+		_ = consumer.Close()
+	}
+}
@@ -0,0 +1,28 @@
+package kafkatest
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// StartT starts a cluster for the test t using Start, and removes it when the test
+// and its subtests complete. The test is skipped if Docker is not available, and
+// fails if the cluster cannot be started.
+func StartT(t testing.TB, opts Options) *Cluster {
+	t.Helper()
+
+	c, err := Start(context.Background(), opts)
+	if errors.Is(err, ErrDockerUnavailable) {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatalf("failed to start the Kafka cluster: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := c.Close(); err != nil {
+			t.Errorf("failed to remove the Kafka cluster: %v", err)
+		}
+	})
+	return c
+}
@@ -34,4 +34,14 @@ func TestListGroupsRequest(t *testing.T) {
 		6, 'E', 'm', 'p', 't', 'y', // compact string
 		0, // empty tag buffer
 	})
+
+	testRequest(t, "ListGroupsRequest", &ListGroupsRequest{
+		Version:     5,
+		TypesFilter: []string{"consumer"},
+	}, []byte{
+		1,                                         // compact array length (0)
+		2,                                         // compact array length (1)
+		9, 'c', 'o', 'n', 's', 'u', 'm', 'e', 'r', // compact string
+		0, // empty tag buffer
+	})
 }
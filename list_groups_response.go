@@ -10,6 +10,9 @@ type ListGroupsResponse struct {
 
 type GroupData struct {
 	GroupState string // version 4 or later
+	// GroupType is "classic" or "consumer", the group protocol of KIP-848
+	// (version 5 or later).
+	GroupType string
 }
 
 func (r *ListGroupsResponse) encode(pe packetEncoder) error {
@@ -47,7 +50,14 @@ func (r *ListGroupsResponse) encode(pe packetEncoder) error {
 					return err
 				}
 			}
+			if r.Version >= 5 {
+				if err := pe.putCompactString(r.GroupsData[groupId].GroupType); err != nil {
+					return err
+				}
+			}
+			pe.putEmptyTaggedFieldArray()
 		}
+		pe.putEmptyTaggedFieldArray()
 	}
 
 	return nil
@@ -115,9 +125,15 @@ func (r *ListGroupsResponse) decode(pd packetDecoder, version int16) error {
 			if err != nil {
 				return err
 			}
-			r.GroupsData[groupId] = GroupData{
+			groupData := GroupData{
 				GroupState: groupState,
 			}
+			if r.Version >= 5 {
+				if groupData.GroupType, err = pd.getCompactString(); err != nil {
+					return err
+				}
+			}
+			r.GroupsData[groupId] = groupData
 		}
 
 		if r.Version >= 3 {
@@ -152,11 +168,13 @@ func (r *ListGroupsResponse) headerVersion() int16 {
 }
 
 func (r *ListGroupsResponse) isValidVersion() bool {
-	return r.Version >= 0 && r.Version <= 4
+	return r.Version >= 0 && r.Version <= 5
 }
 
 func (r *ListGroupsResponse) requiredVersion() KafkaVersion {
 	switch r.Version {
+	case 5:
+		return V3_8_0_0
 	case 4:
 		return V2_6_0_0
 	case 3:
@@ -168,6 +186,6 @@ func (r *ListGroupsResponse) requiredVersion() KafkaVersion {
 	case 0:
 		return V0_9_0_0
 	default:
-		return V2_6_0_0
+		return V3_8_0_0
 	}
 }
@@ -2,6 +2,7 @@ package sarama
 
 import (
 	"errors"
+	"reflect"
 	"testing"
 )
 
@@ -23,6 +24,16 @@ var (
 		0, 8, 'c', 'o', 'n', 's', 'u', 'm', 'e', 'r', // protocol type
 	}
 
+	listGroupResponseV3 = []byte{
+		0, 0, 0, 0, // no throttle time
+		0, 0, // no error
+		2,                // compact array length (1)
+		4, 'f', 'o', 'o', // group name (compact string)
+		9, 'c', 'o', 'n', 's', 'u', 'm', 'e', 'r', // protocol type (compact string)
+		0, // Empty tag buffer
+		0, // Empty tag buffer
+	}
+
 	listGroupResponseV4 = []byte{
 		0, 0, 0, 0, // no throttle time
 		0, 0, // no error
@@ -33,6 +44,18 @@ var (
 		0, // Empty tag buffer
 		0, // Empty tag buffer
 	}
+
+	listGroupResponseV5 = []byte{
+		0, 0, 0, 0, // no throttle time
+		0, 0, // no error
+		2,                // compact array length (1)
+		4, 'f', 'o', 'o', // group name (compact string)
+		9, 'c', 'o', 'n', 's', 'u', 'm', 'e', 'r', // protocol type (compact string)
+		7, 'S', 't', 'a', 'b', 'l', 'e', // state (compact string)
+		9, 'c', 'o', 'n', 's', 'u', 'm', 'e', 'r', // type (compact string)
+		0, // Empty tag buffer
+		0, // Empty tag buffer
+	}
 )
 
 func TestListGroupsResponse(t *testing.T) {
@@ -68,6 +91,15 @@ func TestListGroupsResponse(t *testing.T) {
 		t.Error("Expected foo group to use consumer protocol")
 	}
 
+	// the groups and the response end with their tagged fields from version 3
+	response = &ListGroupsResponse{Version: 3, Groups: map[string]string{"foo": "consumer"}}
+	testEncodable(t, "V3", response, listGroupResponseV3)
+	decoded := new(ListGroupsResponse)
+	testVersionDecodable(t, "V3", decoded, listGroupResponseV3, 3)
+	if !reflect.DeepEqual(decoded.Groups, response.Groups) {
+		t.Error("Expected the groups to round-trip, got", decoded.Groups)
+	}
+
 	response = new(ListGroupsResponse)
 	testVersionDecodable(t, "no error", response, listGroupResponseV4, 4)
 	if !errors.Is(response.Err, ErrNoError) {
@@ -82,4 +114,12 @@ func TestListGroupsResponse(t *testing.T) {
 	if response.GroupsData["foo"].GroupState != "Empty" {
 		t.Error("Expected foo grup to have empty state")
 	}
+	testEncodable(t, "V4", response, listGroupResponseV4)
+
+	response = new(ListGroupsResponse)
+	testVersionDecodable(t, "no error", response, listGroupResponseV5, 5)
+	if data := response.GroupsData["foo"]; data.GroupState != "Stable" || data.GroupType != "consumer" {
+		t.Error("Expected foo group to be a stable group of type consumer, got", data)
+	}
+	testEncodable(t, "V5", response, listGroupResponseV5)
 }
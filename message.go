@@ -33,6 +33,12 @@ const (
 type CompressionCodec int8
 
 func (cc CompressionCodec) String() string {
+	if cc < CompressionNone || cc > CompressionZSTD {
+		if codec := registeredCompressionCodec(cc); codec != nil {
+			return codec.name
+		}
+		return fmt.Sprintf("CompressionCodec(%d)", int8(cc))
+	}
 	return []string{
 		"none",
 		"gzip",
@@ -52,6 +58,15 @@ func (cc *CompressionCodec) UnmarshalText(text []byte) error {
 		"zstd":   CompressionZSTD,
 	}
 	codec, ok := codecs[string(text)]
+	if !ok {
+		compressionCodecsLock.RLock()
+		for id, registered := range compressionCodecs {
+			if registered.name == string(text) {
+				codec, ok = id, true
+			}
+		}
+		compressionCodecsLock.RUnlock()
+	}
 	if !ok {
 		return fmt.Errorf("cannot parse %q as a compression codec", string(text))
 	}
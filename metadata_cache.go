@@ -0,0 +1,89 @@
+package sarama
+
+// cachedPartition is the compact form of the PartitionMetadata of a partition
+// kept by the client, as the metadata of clusters with many partitions takes
+// up a substantial amount of memory. The ID of the partition is its key in the
+// metadata of its topic, and the replicas, in-sync replicas and offline
+// replicas share one slice.
+type cachedPartition struct {
+	replicas    []int32 // the replicas, then the in-sync and offline replicas
+	leader      int32
+	leaderEpoch int32
+	err         KError
+	// replicaCount and isrCount are the number of replicas and in-sync
+	// replicas at the start of replicas. The replication factor of a topic
+	// being an int16, they always fit.
+	replicaCount, isrCount uint16
+	dropped                bool // whether the in-sync and offline replicas were dropped
+}
+
+// newCachedPartition returns the compact form of p, without its in-sync and
+// offline replicas if dropReplicaDetails is set.
+func newCachedPartition(p *PartitionMetadata, dropReplicaDetails bool) cachedPartition {
+	size := len(p.Replicas)
+	if !dropReplicaDetails {
+		size += len(p.Isr) + len(p.OfflineReplicas)
+	}
+	replicas := make([]int32, 0, size)
+	replicas = append(replicas, p.Replicas...)
+	if !dropReplicaDetails {
+		replicas = append(replicas, p.Isr...)
+		replicas = append(replicas, p.OfflineReplicas...)
+	}
+	return cachedPartition{
+		replicas:     replicas,
+		leader:       p.Leader,
+		leaderEpoch:  p.LeaderEpoch,
+		err:          p.Err,
+		replicaCount: uint16(len(p.Replicas)),
+		isrCount:     uint16(len(p.Isr)),
+		dropped:      dropReplicaDetails,
+	}
+}
+
+func (p cachedPartition) replicaIDs() []int32 {
+	return p.replicas[:p.replicaCount:p.replicaCount]
+}
+
+func (p cachedPartition) isr() []int32 {
+	if p.dropped {
+		return nil
+	}
+	end := p.replicaCount + p.isrCount
+	return p.replicas[p.replicaCount:end:end]
+}
+
+func (p cachedPartition) offlineReplicas() []int32 {
+	if p.dropped {
+		return nil
+	}
+	return p.replicas[p.replicaCount+p.isrCount:]
+}
+
+// metadata returns the PartitionMetadata of the partition id, with copies of
+// its replicas.
+func (p cachedPartition) metadata(id int32) *PartitionMetadata {
+	return &PartitionMetadata{
+		Err:             p.err,
+		ID:              id,
+		Leader:          p.leader,
+		LeaderEpoch:     p.leaderEpoch,
+		Replicas:        dupInt32Slice(p.replicaIDs()),
+		Isr:             dupInt32Slice(p.isr()),
+		OfflineReplicas: dupInt32Slice(p.offlineReplicas()),
+	}
+}
+
+// internTopic returns the copy of the topic name shared by the metadata of the
+// client, instead of the one decoded from each metadata response. The names
+// of the previous metadata are reused after a refresh of all of it.
+func (client *client) internTopic(previous map[string]string, name string) string {
+	if interned, ok := client.topicNames[name]; ok {
+		return interned
+	}
+	if interned, ok := previous[name]; ok {
+		name = interned
+	}
+	client.topicNames[name] = name
+	return name
+}
@@ -108,7 +108,7 @@ func (r *MetadataRequest) encode(pe packetEncoder) (err error) {
 func (r *MetadataRequest) decode(pd packetDecoder, version int16) (err error) {
 	r.Version = version
 	if r.Version < 9 {
-		size, err := pd.getInt32()
+		size, err := pd.getNullableArrayLength()
 		if err != nil {
 			return err
 		}
@@ -43,6 +43,14 @@ func getOrRegisterTopicHistogram(name string, topic string, r metrics.Registry)
 	return getOrRegisterHistogram(getMetricNameForTopic(name, topic), r)
 }
 
+func getMetricNameForPartition(name string, topic string, partition int32) string {
+	return fmt.Sprintf(name+"-for-partition-%s-%d", strings.Replace(topic, ".", "_", -1), partition)
+}
+
+func getOrRegisterPartitionHistogram(name string, topic string, partition int32, r metrics.Registry) metrics.Histogram {
+	return getOrRegisterHistogram(getMetricNameForPartition(name, topic, partition), r)
+}
+
 // cleanupRegistry is an implementation of metrics.Registry that allows
 // to unregister from the parent registry only those metrics
 // that have been registered in cleanupRegistry
@@ -80,6 +88,15 @@ func (r *cleanupRegistry) Get(name string) interface{} {
 }
 
 func (r *cleanupRegistry) GetOrRegister(name string, metric interface{}) interface{} {
+	// the metrics are looked up for every request, so only take the write
+	// lock for those not registered yet
+	r.mutex.RLock()
+	_, ok := r.metrics[name]
+	r.mutex.RUnlock()
+	if ok {
+		return r.parent.GetOrRegister(name, metric)
+	}
+
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
 	r.metrics[name] = struct{}{}
@@ -118,3 +135,137 @@ func (r *cleanupRegistry) UnregisterAll() {
 		r.parent.Unregister(name)
 	}
 }
+
+// NewShardedRegistry returns a metrics.Registry spreading its metrics by name
+// over the given number of shards, each with its own lock, to reduce the
+// contention of the producers and consumers looking up their metrics for
+// every request under a high throughput. Each and GetAll visit the shards in
+// turn, so they are not atomic.
+func NewShardedRegistry(shards int) metrics.Registry {
+	if shards < 1 {
+		shards = 1
+	}
+	r := &shardedRegistry{shards: make([]metrics.Registry, shards)}
+	for i := range r.shards {
+		r.shards[i] = metrics.NewRegistry()
+	}
+	return r
+}
+
+type shardedRegistry struct {
+	shards []metrics.Registry
+}
+
+// shard returns the shard of name, by its FNV-1a hash.
+func (r *shardedRegistry) shard(name string) metrics.Registry {
+	hash := uint32(2166136261)
+	for i := 0; i < len(name); i++ {
+		hash ^= uint32(name[i])
+		hash *= 16777619
+	}
+	return r.shards[hash%uint32(len(r.shards))]
+}
+
+func (r *shardedRegistry) Each(fn func(string, interface{})) {
+	for _, shard := range r.shards {
+		shard.Each(fn)
+	}
+}
+
+func (r *shardedRegistry) Get(name string) interface{} {
+	return r.shard(name).Get(name)
+}
+
+func (r *shardedRegistry) GetOrRegister(name string, metric interface{}) interface{} {
+	return r.shard(name).GetOrRegister(name, metric)
+}
+
+func (r *shardedRegistry) Register(name string, metric interface{}) error {
+	return r.shard(name).Register(name, metric)
+}
+
+func (r *shardedRegistry) RunHealthchecks() {
+	for _, shard := range r.shards {
+		shard.RunHealthchecks()
+	}
+}
+
+func (r *shardedRegistry) GetAll() map[string]map[string]interface{} {
+	all := make(map[string]map[string]interface{})
+	for _, shard := range r.shards {
+		for name, values := range shard.GetAll() {
+			all[name] = values
+		}
+	}
+	return all
+}
+
+func (r *shardedRegistry) Unregister(name string) {
+	r.shard(name).Unregister(name)
+}
+
+func (r *shardedRegistry) UnregisterAll() {
+	for _, shard := range r.shards {
+		shard.UnregisterAll()
+	}
+}
+
+// NewNilRegistry returns a metrics.Registry disabling the metrics of the
+// clients it is the Config.MetricRegistry of: it registers nothing and returns
+// no-op metrics, and the clients skip computing the metrics of their requests
+// and responses. Unlike metrics.UseNilMetrics, it does not affect the other
+// registries of the process.
+func NewNilRegistry() metrics.Registry {
+	return nilRegistry{}
+}
+
+type nilRegistry struct{}
+
+func (nilRegistry) Each(func(string, interface{})) {}
+
+func (nilRegistry) Get(string) interface{} { return nil }
+
+func (nilRegistry) GetOrRegister(_ string, metric interface{}) interface{} {
+	// the constructors are not called, as the metrics they return may start
+	// goroutines, such as those of the meters
+	switch metric.(type) {
+	case metrics.Meter, func() metrics.Meter:
+		return metrics.NilMeter{}
+	case metrics.Histogram, func() metrics.Histogram:
+		return metrics.NilHistogram{}
+	case metrics.Counter, func() metrics.Counter:
+		return metrics.NilCounter{}
+	case metrics.Gauge, func() metrics.Gauge:
+		return metrics.NilGauge{}
+	case metrics.GaugeFloat64, func() metrics.GaugeFloat64:
+		return metrics.NilGaugeFloat64{}
+	case metrics.Timer, func() metrics.Timer:
+		return metrics.NilTimer{}
+	}
+	return metric
+}
+
+func (nilRegistry) Register(string, interface{}) error { return nil }
+
+func (nilRegistry) RunHealthchecks() {}
+
+func (nilRegistry) GetAll() map[string]map[string]interface{} {
+	return map[string]map[string]interface{}{}
+}
+
+func (nilRegistry) Unregister(string) {}
+
+func (nilRegistry) UnregisterAll() {}
+
+// metricsDisabled returns whether the metrics recorded into r are discarded,
+// so that computing them can be skipped.
+func metricsDisabled(r metrics.Registry) bool {
+	if metrics.UseNilMetrics {
+		return true
+	}
+	if cleanup, ok := r.(*cleanupRegistry); ok {
+		r = cleanup.parent
+	}
+	_, ok := r.(nilRegistry)
+	return ok
+}
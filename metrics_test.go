@@ -37,6 +37,62 @@ func TestGetMetricNameForBroker(t *testing.T) {
 	}
 }
 
+func TestShardedRegistry(t *testing.T) {
+	metricRegistry := NewShardedRegistry(4)
+	meters := make(map[string]metrics.Meter)
+	for _, name := range []string{"a", "b", "c", "d", "e", "f"} {
+		meters[name] = metrics.GetOrRegisterMeter(name, metricRegistry)
+		if same := metrics.GetOrRegisterMeter(name, metricRegistry); same != meters[name] {
+			t.Error("Unexpected different meter", same, meters[name])
+		}
+		if found := metricRegistry.Get(name); found != meters[name] {
+			t.Error("Unexpected different meter", found, meters[name])
+		}
+	}
+	if all := metricRegistry.GetAll(); len(all) != len(meters) {
+		t.Errorf("Expected %d metrics, got %v", len(meters), all)
+	}
+
+	metricRegistry.Unregister("a")
+	count := 0
+	metricRegistry.Each(func(name string, _ interface{}) {
+		if name == "a" {
+			t.Error("Unexpected unregistered metric a")
+		}
+		count++
+	})
+	if count != len(meters)-1 {
+		t.Errorf("Expected %d metrics, got %d", len(meters)-1, count)
+	}
+	metricRegistry.UnregisterAll()
+	if all := metricRegistry.GetAll(); len(all) != 0 {
+		t.Errorf("Expected no metrics, got %v", all)
+	}
+}
+
+func TestNilRegistry(t *testing.T) {
+	metricRegistry := NewNilRegistry()
+	if _, ok := getOrRegisterHistogram("name", metricRegistry).(metrics.NilHistogram); !ok {
+		t.Error("Expected a nil histogram")
+	}
+	if _, ok := metrics.GetOrRegisterMeter("name", metricRegistry).(metrics.NilMeter); !ok {
+		t.Error("Expected a nil meter")
+	}
+	if _, ok := metrics.GetOrRegisterCounter("name", metricRegistry).(metrics.NilCounter); !ok {
+		t.Error("Expected a nil counter")
+	}
+	if metricRegistry.Get("name") != nil || len(metricRegistry.GetAll()) != 0 {
+		t.Error("Expected no metrics to be registered")
+	}
+
+	if !metricsDisabled(newCleanupRegistry(metricRegistry)) {
+		t.Error("Expected the metrics of a nil registry to be disabled")
+	}
+	if metricsDisabled(newCleanupRegistry(metrics.NewRegistry())) {
+		t.Error("Expected the metrics of a registry to be enabled")
+	}
+}
+
 // Common type and functions for metric validation
 type metricValidator struct {
 	name      string
@@ -189,3 +245,44 @@ func counterValidator(name string, expectedCount int) *metricValidator {
 		},
 	}
 }
+
+func TestAsyncProducerNilRegistry(t *testing.T) {
+	seedBroker := NewMockBroker(t, 1)
+	defer seedBroker.Close()
+	leader := NewMockBroker(t, 2)
+	defer leader.Close()
+
+	metadataResponse := new(MetadataResponse)
+	metadataResponse.AddBroker(leader.Addr(), leader.BrokerID())
+	metadataResponse.AddTopicPartition("my_topic", 0, leader.BrokerID(), nil, nil, nil, ErrNoError)
+	seedBroker.Returns(metadataResponse)
+
+	prodSuccess := new(ProduceResponse)
+	prodSuccess.AddTopicPartition("my_topic", 0, ErrNoError)
+	leader.Returns(prodSuccess)
+
+	config := NewTestConfig()
+	config.MetricRegistry = NewNilRegistry()
+	config.Producer.Flush.Messages = 10
+	config.Producer.Return.Successes = true
+	producer, err := NewAsyncProducer([]string{seedBroker.Addr()}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 10; i++ {
+		producer.Input() <- &ProducerMessage{Topic: "my_topic", Value: StringEncoder(TestMessage)}
+	}
+	for i := 0; i < 10; i++ {
+		select {
+		case perr := <-producer.Errors():
+			t.Error(perr.Err)
+		case <-producer.Successes():
+		}
+	}
+	closeProducer(t, producer)
+
+	if all := config.MetricRegistry.GetAll(); len(all) != 0 {
+		t.Errorf("Expected no metrics, got %v", all)
+	}
+}
@@ -2,6 +2,7 @@ package sarama
 
 import (
 	"bytes"
+	"crypto/tls"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -65,6 +66,80 @@ type MockBroker struct {
 	history       []RequestResponse
 	lock          sync.Mutex
 	gssApiHandler GSSApiHandlerFunc
+	faults        map[string]*MockFault
+	quota         MockQuota
+}
+
+// MockFault describes how MockBroker misbehaves when replying to a type of
+// request, to test the handling of timeouts, retries and broken connections
+// by the client. The request is always handled, so that a dropped response
+// is a response lost by the client, not by the broker.
+type MockFault struct {
+	// Delay is waited before replying, on top of the latency of the broker.
+	Delay time.Duration
+	// Drop closes the connection after writing the first DropAfter bytes of
+	// the response, counting its length and header.
+	Drop      bool
+	DropAfter int
+	// Truncate, if positive, cuts the body of the response to its first
+	// Truncate bytes, which the client then fails to decode.
+	Truncate int
+	// Corrupt scrambles the bytes of the body of the response, which then
+	// decodes to invalid lengths and values.
+	Corrupt bool
+	// Throttle sets the throttle time of the response, if it has one.
+	Throttle time.Duration
+	// Times is the number of requests the fault applies to, 0 for all of
+	// them.
+	Times int
+}
+
+// MockQuota is an artificial rate limit which MockBroker enforces on each of
+// its connections, the way a broker enforces the quotas of its clients: the
+// responses to the requests exceeding it carry a throttle time, during which
+// the broker does not read the connection.
+type MockQuota struct {
+	// BytesPerSecond limits the bytes of the requests and responses of a
+	// connection, 0 for no limit. A response counts towards the throttling
+	// of the requests which follow it.
+	BytesPerSecond int
+	// RequestsPerSecond limits the requests of a connection, 0 for no limit.
+	RequestsPerSecond int
+	// DelayResponse delays the throttled responses by their throttle time,
+	// as brokers did before Kafka 2.0, rather than replying at once and
+	// muting the connection.
+	DelayResponse bool
+}
+
+// mockQuotaWindow is how long a connection can exceed its quota before being
+// throttled, like the quota window of a broker.
+const mockQuotaWindow = time.Second
+
+// mockQuotaDebt tracks the usage of a connection against its MockQuota.
+type mockQuotaDebt struct {
+	bytes, requests time.Time
+}
+
+// charge records the requests and bytes of a connection at now, and returns
+// how long the connection must be throttled for to get back under quota.
+func (d *mockQuotaDebt) charge(quota MockQuota, now time.Time, requests, bytes int) time.Duration {
+	var throttle time.Duration
+	add := func(until *time.Time, cost time.Duration) {
+		if until.Before(now) {
+			*until = now
+		}
+		*until = until.Add(cost)
+		if t := until.Sub(now) - mockQuotaWindow; t > throttle {
+			throttle = t
+		}
+	}
+	if quota.BytesPerSecond > 0 {
+		add(&d.bytes, time.Duration(bytes)*time.Second/time.Duration(quota.BytesPerSecond))
+	}
+	if quota.RequestsPerSecond > 0 {
+		add(&d.requests, time.Duration(requests)*time.Second/time.Duration(quota.RequestsPerSecond))
+	}
+	return throttle
 }
 
 // RequestResponse represents a Request/Response pair processed by MockBroker.
@@ -79,6 +154,77 @@ func (b *MockBroker) SetLatency(latency time.Duration) {
 	b.latency = latency
 }
 
+// SetFault makes the broker misbehave as described by fault when replying
+// to the requests of type request, such as "FetchRequest". It replaces the
+// previous fault of request.
+func (b *MockBroker) SetFault(request string, fault MockFault) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.faults == nil {
+		b.faults = make(map[string]*MockFault)
+	}
+	b.faults[request] = &fault
+}
+
+// SetQuota makes the broker throttle the connections exceeding quota. It
+// replaces the previous quota, and the zero MockQuota removes it.
+func (b *MockBroker) SetQuota(quota MockQuota) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.quota = quota
+}
+
+// ClearFaults removes the faults set by SetFault.
+func (b *MockBroker) ClearFaults() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.faults = nil
+}
+
+// takeFault returns the fault to apply to the reply to body, nil if there is
+// none.
+func (b *MockBroker) takeFault(body protocolBody) *MockFault {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	name := reflect.TypeOf(body).Elem().Name()
+	f := b.faults[name]
+	if f == nil {
+		return nil
+	}
+	fault := *f
+	if f.Times > 0 {
+		f.Times--
+		if f.Times == 0 {
+			delete(b.faults, name)
+		}
+	}
+	return &fault
+}
+
+// setThrottleTime sets the throttle time of res, whichever the type of its
+// field.
+func setThrottleTime(res encoderWithHeader, throttle time.Duration) bool {
+	v := reflect.ValueOf(res)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return false
+	}
+	for _, name := range []string{"ThrottleTime", "ThrottleTimeMs"} {
+		field := v.Elem().FieldByName(name)
+		if !field.IsValid() || !field.CanSet() {
+			continue
+		}
+		switch {
+		case field.Type() == reflect.TypeOf(time.Duration(0)):
+			field.SetInt(int64(throttle))
+			return true
+		case field.Kind() == reflect.Int32:
+			field.SetInt(int64(throttle / time.Millisecond))
+			return true
+		}
+	}
+	return false
+}
+
 // SetHandlerByMap defines mapping of Request types to MockResponses. When a
 // request is received by the broker, it looks up the request type in the map
 // and uses the found MockResponse instance to generate an appropriate reply.
@@ -242,6 +388,7 @@ func (b *MockBroker) handleRequests(conn io.ReadWriteCloser, idx int, wg *sync.W
 
 	var bytesWritten int
 	var bytesRead int
+	var debt mockQuotaDebt
 	for {
 		buffer, err := b.readToBytes(conn)
 		if err != nil {
@@ -268,15 +415,35 @@ func (b *MockBroker) handleRequests(conn io.ReadWriteCloser, idx int, wg *sync.W
 				time.Sleep(b.latency)
 			}
 
+			fault := b.takeFault(req.body)
+			if fault != nil && fault.Delay > 0 {
+				select {
+				case <-time.After(fault.Delay):
+				case <-b.closing:
+				}
+			}
+
 			b.lock.Lock()
 			res := b.handler(req)
 			b.history = append(b.history, RequestResponse{req.body, res})
+			quota := b.quota
 			b.lock.Unlock()
 
+			var throttle time.Duration
+			if quota != (MockQuota{}) {
+				throttle = debt.charge(quota, time.Now(), 1, bytesRead)
+			}
+
 			if res == nil {
 				Logger.Printf("*** mockbroker/%d/%d: ignored %v", b.brokerID, idx, spew.Sdump(req))
 				continue
 			}
+			if fault != nil && fault.Throttle > 0 {
+				throttle = fault.Throttle
+			}
+			if throttle > 0 && !setThrottleTime(res, throttle) {
+				Logger.Printf("*** mockbroker/%d/%d: %T has no throttle time", b.brokerID, idx, res)
+			}
 			Logger.Printf(
 				"*** mockbroker/%d/%d: replied to %T with %T\n-> %s\n-> %s",
 				b.brokerID, idx, req.body, res,
@@ -298,7 +465,36 @@ func (b *MockBroker) handleRequests(conn io.ReadWriteCloser, idx int, wg *sync.W
 				continue
 			}
 
+			if fault != nil {
+				if fault.Truncate > 0 && fault.Truncate < len(encodedRes) {
+					encodedRes = encodedRes[:fault.Truncate]
+				}
+				if fault.Corrupt {
+					for i := range encodedRes {
+						encodedRes[i] ^= 0x55
+					}
+				}
+			}
+
 			resHeader := b.encodeHeader(res.headerVersion(), req.correlationID, uint32(len(encodedRes)))
+			if fault != nil && fault.Drop {
+				Logger.Printf("*** mockbroker/%d/%d: dropping the connection after %d bytes of %T", b.brokerID, idx, fault.DropAfter, res)
+				if partial := append(resHeader, encodedRes...); fault.DropAfter > 0 {
+					if fault.DropAfter < len(partial) {
+						partial = partial[:fault.DropAfter]
+					}
+					_, _ = conn.Write(partial)
+				}
+				break
+			}
+			quotaThrottle := quota != (MockQuota{}) && throttle > 0
+			if quotaThrottle && quota.DelayResponse {
+				Logger.Printf("*** mockbroker/%d/%d: delaying %T by its throttle time %v", b.brokerID, idx, res, throttle)
+				select {
+				case <-time.After(throttle):
+				case <-b.closing:
+				}
+			}
 			if _, err = conn.Write(resHeader); err != nil {
 				b.serverError(err)
 				break
@@ -308,6 +504,16 @@ func (b *MockBroker) handleRequests(conn io.ReadWriteCloser, idx int, wg *sync.W
 				break
 			}
 			bytesWritten = len(resHeader) + len(encodedRes)
+			if quota != (MockQuota{}) {
+				debt.charge(quota, time.Now(), 0, bytesWritten)
+			}
+			if quotaThrottle && !quota.DelayResponse {
+				Logger.Printf("*** mockbroker/%d/%d: muting the connection for %v", b.brokerID, idx, throttle)
+				select {
+				case <-time.After(throttle):
+				case <-b.closing:
+				}
+			}
 		} else {
 			// GSSAPI is not part of kafka protocol, but is supported for authentication proposes.
 			// Don't support history for this kind of request as is only used for test GSSAPI authentication mechanism
@@ -403,6 +609,17 @@ func NewMockBrokerAddr(t TestReporter, brokerID int32, addr string) *MockBroker
 	return NewMockBrokerListener(t, brokerID, listener)
 }
 
+// NewMockBrokerTLS behaves like NewMockBroker but terminates TLS using config,
+// which must hold the certificate of the broker, and the client CAs to
+// authenticate clients with their certificates.
+func NewMockBrokerTLS(t TestReporter, brokerID int32, config *tls.Config) *MockBroker {
+	listener, err := tls.Listen("tcp", "localhost:0", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewMockBrokerListener(t, brokerID, listener)
+}
+
 // NewMockBrokerListener behaves like newMockBrokerAddr but accepts connections on the listener specified.
 func NewMockBrokerListener(t TestReporter, brokerID int32, listener net.Listener) *MockBroker {
 	var err error
@@ -0,0 +1,179 @@
+package sarama
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func openMockBrokerConnection(t *testing.T, mb *MockBroker) *Broker {
+	t.Helper()
+	conf := NewTestConfig()
+	conf.Version = V1_0_0_0
+	conf.Net.ReadTimeout = 200 * time.Millisecond
+	broker := NewBroker(mb.Addr())
+	if err := broker.Open(conf); err != nil {
+		t.Fatal(err)
+	}
+	return broker
+}
+
+func TestMockBrokerFaults(t *testing.T) {
+	testCases := []struct {
+		name  string
+		fault MockFault
+	}{
+		{"delay", MockFault{Delay: time.Second, Times: 1}},
+		{"drop", MockFault{Drop: true, Times: 1}},
+		{"drop mid-response", MockFault{Drop: true, DropAfter: 10, Times: 1}},
+		{"truncate", MockFault{Truncate: 3, Times: 1}},
+		{"corrupt", MockFault{Corrupt: true, Times: 1}},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			mb := NewMockBroker(t, 1)
+			defer mb.Close()
+			mb.SetHandlerByMap(map[string]MockResponse{
+				"MetadataRequest": NewMockMetadataResponse(t).
+					SetBroker(mb.Addr(), mb.BrokerID()).
+					SetLeader("my-topic", 0, mb.BrokerID()),
+			})
+			mb.SetFault("MetadataRequest", tc.fault)
+
+			broker := openMockBrokerConnection(t, mb)
+			if _, err := broker.GetMetadata(&MetadataRequest{Version: 1}); err == nil {
+				t.Error("expected the first request to fail")
+			}
+			safeClose(t, broker)
+
+			broker = openMockBrokerConnection(t, mb)
+			defer safeClose(t, broker)
+			res, err := broker.GetMetadata(&MetadataRequest{Version: 1})
+			if err != nil {
+				t.Fatal("expected the fault to apply once, got", err)
+			}
+			if len(res.Topics) != 1 {
+				t.Error("expected a valid metadata response, got", res)
+			}
+			if tc.fault.Delay == 0 && len(mb.History()) != 2 {
+				t.Error("expected both requests to be handled, got", len(mb.History()))
+			}
+		})
+	}
+}
+
+func TestMockBrokerThrottleFault(t *testing.T) {
+	mb := NewMockBroker(t, 1)
+	defer mb.Close()
+	mb.SetHandlerByMap(map[string]MockResponse{
+		"MetadataRequest": NewMockMetadataResponse(t).SetBroker(mb.Addr(), mb.BrokerID()),
+	})
+	mb.SetFault("MetadataRequest", MockFault{Throttle: 50 * time.Millisecond})
+
+	broker := openMockBrokerConnection(t, mb)
+	defer safeClose(t, broker)
+	res, err := broker.GetMetadata(&MetadataRequest{Version: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.ThrottleTimeMs != 50 {
+		t.Error("expected a throttle time of 50ms, got", res.ThrottleTimeMs)
+	}
+
+	mb.ClearFaults()
+	res, err = broker.GetMetadata(&MetadataRequest{Version: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.ThrottleTimeMs != 0 {
+		t.Error("expected no throttle time once cleared, got", res.ThrottleTimeMs)
+	}
+}
+
+func TestSetThrottleTime(t *testing.T) {
+	apiVersions := &ApiVersionsResponse{}
+	if !setThrottleTime(apiVersions, time.Second) || apiVersions.ThrottleTimeMs != 1000 {
+		t.Error("expected ThrottleTimeMs to be set, got", apiVersions.ThrottleTimeMs)
+	}
+	produce := &ProduceResponse{}
+	if !setThrottleTime(produce, time.Second) || produce.ThrottleTime != time.Second {
+		t.Error("expected ThrottleTime to be set, got", produce.ThrottleTime)
+	}
+	heartbeat := &HeartbeatResponse{}
+	if !setThrottleTime(heartbeat, time.Second) || heartbeat.ThrottleTime != 1000 {
+		t.Error("expected ThrottleTime to be set, got", heartbeat.ThrottleTime)
+	}
+	if setThrottleTime(&SaslHandshakeResponse{}, time.Second) {
+		t.Error("expected SaslHandshakeResponse to have no throttle time")
+	}
+}
+
+func TestMockQuotaDebt(t *testing.T) {
+	var debt mockQuotaDebt
+	quota := MockQuota{BytesPerSecond: 100, RequestsPerSecond: 4}
+	now := time.Now()
+	if throttle := debt.charge(quota, now, 1, 100); throttle != 0 {
+		t.Error("expected a connection within its quota window not to be throttled, got", throttle)
+	}
+	if throttle := debt.charge(quota, now, 1, 50); throttle != 500*time.Millisecond {
+		t.Error("expected the bytes over quota to be throttled for 500ms, got", throttle)
+	}
+	if throttle := debt.charge(quota, now.Add(2*time.Second), 4, 0); throttle != 0 {
+		t.Error("expected the debt to be paid back, got", throttle)
+	}
+	if throttle := debt.charge(quota, now.Add(2*time.Second), 2, 0); throttle != 500*time.Millisecond {
+		t.Error("expected the requests over quota to be throttled for 500ms, got", throttle)
+	}
+}
+
+func TestMockBrokerQuota(t *testing.T) {
+	for _, delay := range []bool{false, true} {
+		delay := delay
+		t.Run(fmt.Sprintf("DelayResponse=%v", delay), func(t *testing.T) {
+			mb := NewMockBroker(t, 1)
+			defer mb.Close()
+			mb.SetHandlerByMap(map[string]MockResponse{
+				"MetadataRequest": NewMockMetadataResponse(t).SetBroker(mb.Addr(), mb.BrokerID()),
+			})
+			mb.SetQuota(MockQuota{RequestsPerSecond: 2, DelayResponse: delay})
+
+			conf := NewTestConfig()
+			conf.Version = V1_0_0_0
+			broker := NewBroker(mb.Addr())
+			if err := broker.Open(conf); err != nil {
+				t.Fatal(err)
+			}
+			defer safeClose(t, broker)
+			for i := 0; i < 2; i++ {
+				res, err := broker.GetMetadata(&MetadataRequest{Version: 3})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if res.ThrottleTimeMs != 0 {
+					t.Errorf("expected request %d within quota, got a throttle time of %dms", i, res.ThrottleTimeMs)
+				}
+			}
+
+			start := time.Now()
+			res, err := broker.GetMetadata(&MetadataRequest{Version: 3})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.ThrottleTimeMs <= 0 || res.ThrottleTimeMs > 500 {
+				t.Error("expected the request over quota to be throttled for up to 500ms, got", res.ThrottleTimeMs)
+			}
+			if elapsed := time.Since(start); delay != (elapsed >= 400*time.Millisecond) {
+				t.Errorf("expected the response to be delayed only with DelayResponse, took %v", elapsed)
+			}
+
+			// the client waits for the throttle time before its next request
+			if _, err := broker.GetMetadata(&MetadataRequest{Version: 3}); err != nil {
+				t.Fatal(err)
+			}
+			if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+				t.Error("expected the next request to wait for the throttle time, took", elapsed)
+			}
+		})
+	}
+}
@@ -0,0 +1,725 @@
+package sarama
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MockGroupCoordinator is a MockResponse emulating the group coordinator of
+// a Kafka broker, so that consumer groups can be tested against MockBroker
+// without scripting each of their requests. It handles the
+// FindCoordinatorRequest, JoinGroupRequest, SyncGroupRequest,
+// HeartbeatRequest, LeaveGroupRequest, OffsetCommitRequest and
+// OffsetFetchRequest of any number of groups:
+//
+//	coordinator := NewMockGroupCoordinator(t, broker)
+//	broker.SetHandlerByMap(map[string]MockResponse{
+//		"FindCoordinatorRequest": coordinator,
+//		"JoinGroupRequest":       coordinator,
+//		"SyncGroupRequest":       coordinator,
+//		"HeartbeatRequest":       coordinator,
+//		"LeaveGroupRequest":      coordinator,
+//		"OffsetCommitRequest":    coordinator,
+//		"OffsetFetchRequest":     coordinator,
+//		...
+//	})
+//
+// Like a real coordinator, it holds the JoinGroup responses until all the
+// members of the group have rejoined (or the rebalance timeout expired), and
+// the SyncGroup responses of the followers until the leader sent the
+// assignments. Members which stop sending heartbeats are removed after their
+// session timeout, and TriggerRebalance makes all the members rejoin.
+type MockGroupCoordinator struct {
+	t      TestReporter
+	broker *MockBroker
+
+	lock     sync.Mutex
+	groups   map[string]*mockGroup
+	memberID int
+}
+
+type mockGroupState int
+
+const (
+	mockGroupEmpty mockGroupState = iota
+	mockGroupPreparingRebalance
+	mockGroupCompletingRebalance
+	mockGroupStable
+)
+
+type mockGroup struct {
+	id         string
+	state      mockGroupState
+	generation int32
+	protocol   string
+	leader     string
+	// members are in the order they joined the group, the first one being
+	// elected leader when the previous leader left.
+	members []*mockGroupMember
+	// pending are the IDs given to new members, which have to rejoin with
+	// them.
+	pending map[string]bool
+	offsets map[string]map[int32]*OffsetFetchResponseBlock
+}
+
+type mockGroupMember struct {
+	id               string
+	instanceID       *string
+	protocolType     string
+	protocols        []*GroupProtocol
+	sessionTimeout   time.Duration
+	rebalanceTimeout time.Duration
+	lastSeen         time.Time
+	generation       int32
+	assignment       []byte
+
+	// join and sync hold the responses of the member waiting for the
+	// rebalance, or for the assignments of the leader.
+	join *mockPendingResponse
+	sync *mockPendingResponse
+}
+
+// NewMockGroupCoordinator returns a MockGroupCoordinator with no group,
+// designating broker as the coordinator of all the groups.
+func NewMockGroupCoordinator(t TestReporter, broker *MockBroker) *MockGroupCoordinator {
+	return &MockGroupCoordinator{
+		t:      t,
+		broker: broker,
+		groups: make(map[string]*mockGroup),
+	}
+}
+
+func (c *MockGroupCoordinator) For(reqBody versionedDecoder) encoderWithHeader {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	switch req := reqBody.(type) {
+	case *FindCoordinatorRequest:
+		return &FindCoordinatorResponse{
+			Version:     req.version(),
+			Coordinator: &Broker{id: c.broker.BrokerID(), addr: c.broker.Addr()},
+		}
+	case *JoinGroupRequest:
+		return c.join(req)
+	case *SyncGroupRequest:
+		return c.sync(req)
+	case *HeartbeatRequest:
+		return c.heartbeat(req)
+	case *LeaveGroupRequest:
+		return c.leave(req)
+	case *OffsetCommitRequest:
+		return c.commit(req)
+	case *OffsetFetchRequest:
+		return c.fetchOffsets(req)
+	default:
+		c.t.Errorf("MockGroupCoordinator: unexpected request %T", reqBody)
+		return nil
+	}
+}
+
+// TriggerRebalance makes the members of group rejoin it, as when a member
+// subscribes to new topics.
+func (c *MockGroupCoordinator) TriggerRebalance(group string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if g := c.groups[group]; g != nil && len(g.members) > 0 {
+		c.prepareRebalance(g)
+	}
+}
+
+// RemoveMember removes a member from group, as when its session expires.
+func (c *MockGroupCoordinator) RemoveMember(group, memberID string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if g := c.groups[group]; g != nil {
+		if m := g.member(memberID); m != nil {
+			c.removeMember(g, m)
+		}
+	}
+}
+
+// Members returns the IDs of the current members of group, in the order they
+// joined it.
+func (c *MockGroupCoordinator) Members(group string) []string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	var ids []string
+	if g := c.groups[group]; g != nil {
+		for _, m := range g.members {
+			ids = append(ids, m.id)
+		}
+	}
+	return ids
+}
+
+// Generation returns the current generation of group, 0 if it never
+// completed a rebalance.
+func (c *MockGroupCoordinator) Generation(group string) int32 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if g := c.groups[group]; g != nil {
+		return g.generation
+	}
+	return 0
+}
+
+// Assignment returns the assignment of a member of group sent by the leader
+// in the last SyncGroupRequest, nil if there is none.
+func (c *MockGroupCoordinator) Assignment(group, memberID string) (*ConsumerGroupMemberAssignment, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	g := c.groups[group]
+	if g == nil {
+		return nil, nil
+	}
+	m := g.member(memberID)
+	if m == nil || len(m.assignment) == 0 {
+		return nil, nil
+	}
+	assignment := new(ConsumerGroupMemberAssignment)
+	if err := decode(m.assignment, assignment, nil); err != nil {
+		return nil, err
+	}
+	return assignment, nil
+}
+
+// SetOffset sets the committed offset of a partition for group.
+func (c *MockGroupCoordinator) SetOffset(group, topic string, partition int32, offset int64, metadata string) *MockGroupCoordinator {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.group(group).setOffset(topic, partition, offset, metadata)
+	return c
+}
+
+// Offset returns the offset of a partition committed by group, and whether
+// there is one.
+func (c *MockGroupCoordinator) Offset(group, topic string, partition int32) (int64, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if g := c.groups[group]; g != nil {
+		if block, ok := g.offsets[topic][partition]; ok {
+			return block.Offset, true
+		}
+	}
+	return 0, false
+}
+
+func (c *MockGroupCoordinator) group(id string) *mockGroup {
+	g := c.groups[id]
+	if g == nil {
+		g = &mockGroup{
+			id:      id,
+			pending: make(map[string]bool),
+			offsets: make(map[string]map[int32]*OffsetFetchResponseBlock),
+		}
+		c.groups[id] = g
+	}
+	return g
+}
+
+func (c *MockGroupCoordinator) join(req *JoinGroupRequest) encoderWithHeader {
+	res := &JoinGroupResponse{Version: req.Version}
+	g := c.group(req.GroupId)
+	c.expireMembers(g)
+
+	protocols := req.OrderedGroupProtocols
+	if len(protocols) == 0 {
+		names := make([]string, 0, len(req.GroupProtocols))
+		for name := range req.GroupProtocols {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			protocols = append(protocols, &GroupProtocol{Name: name, Metadata: req.GroupProtocols[name]})
+		}
+	}
+	if !g.accepts(req.ProtocolType, protocols) {
+		res.Err = ErrInconsistentGroupProtocol
+		return res
+	}
+
+	m := g.member(req.MemberId)
+	switch {
+	case m == nil && g.pending[req.MemberId]:
+		delete(g.pending, req.MemberId)
+		m = &mockGroupMember{id: req.MemberId}
+		g.members = append(g.members, m)
+	case req.MemberId != "" && m == nil:
+		res.Err = ErrUnknownMemberId
+		return res
+	case m == nil && req.GroupInstanceId != nil && g.staticMember(*req.GroupInstanceId) != nil:
+		// A static member restarted: it takes over the previous member.
+		m = g.staticMember(*req.GroupInstanceId)
+		m.id = c.newMemberID(req.GroupInstanceId)
+		if g.leader == "" || g.member(g.leader) == nil {
+			g.leader = m.id
+		}
+	case m == nil && req.Version >= 4 && req.GroupInstanceId == nil:
+		// The member has to rejoin with the ID it is given, so that it is
+		// not added twice if its request times out.
+		res.Err = ErrMemberIdRequired
+		res.MemberId = c.newMemberID(nil)
+		g.pending[res.MemberId] = true
+		return res
+	case m == nil:
+		m = &mockGroupMember{id: c.newMemberID(req.GroupInstanceId), instanceID: req.GroupInstanceId}
+		g.members = append(g.members, m)
+	}
+
+	changed := !sameGroupProtocols(m.protocols, protocols)
+	m.protocolType, m.protocols = req.ProtocolType, protocols
+	m.sessionTimeout = time.Duration(req.SessionTimeout) * time.Millisecond
+	m.rebalanceTimeout = time.Duration(req.RebalanceTimeout) * time.Millisecond
+	if req.Version == 0 {
+		m.rebalanceTimeout = m.sessionTimeout
+	}
+	m.lastSeen = time.Now()
+
+	// Rejoining with the same protocols does not change the group, unless
+	// the leader wants to compute new assignments.
+	if !changed && m.generation == g.generation && m.join == nil &&
+		(g.state == mockGroupCompletingRebalance || (g.state == mockGroupStable && m.id != g.leader)) {
+		return g.joinResponse(m, req.Version)
+	}
+
+	if g.state != mockGroupPreparingRebalance {
+		c.prepareRebalance(g)
+	}
+	if m.join != nil {
+		// The previous request of the member was abandoned.
+		m.join.resolve(&JoinGroupResponse{Version: m.join.version, Err: ErrRebalanceInProgress})
+	}
+	m.join = c.newPendingResponse(req.Version)
+	pending := m.join
+	c.completeRebalanceIfJoined(g)
+	return pending
+}
+
+func (c *MockGroupCoordinator) sync(req *SyncGroupRequest) encoderWithHeader {
+	res := &SyncGroupResponse{Version: req.Version}
+	g := c.group(req.GroupId)
+	c.expireMembers(g)
+
+	m := g.member(req.MemberId)
+	switch {
+	case m == nil:
+		res.Err = ErrUnknownMemberId
+		return res
+	case req.GenerationId != g.generation:
+		res.Err = ErrIllegalGeneration
+		return res
+	case g.state == mockGroupPreparingRebalance:
+		res.Err = ErrRebalanceInProgress
+		return res
+	case g.state == mockGroupStable:
+		m.lastSeen = time.Now()
+		res.MemberAssignment = m.assignment
+		return res
+	}
+
+	m.lastSeen = time.Now()
+	if m.id != g.leader {
+		if m.sync != nil {
+			m.sync.resolve(&SyncGroupResponse{Version: m.sync.version, Err: ErrRebalanceInProgress})
+		}
+		m.sync = c.newPendingResponse(req.Version)
+		return m.sync
+	}
+
+	assignments := make(map[string][]byte, len(req.GroupAssignments))
+	for _, a := range req.GroupAssignments {
+		assignments[a.MemberId] = a.Assignment
+	}
+	for _, member := range g.members {
+		member.assignment = assignments[member.id]
+		if member.sync != nil {
+			member.sync.resolve(&SyncGroupResponse{Version: member.sync.version, MemberAssignment: member.assignment})
+			member.sync = nil
+		}
+	}
+	g.state = mockGroupStable
+	res.MemberAssignment = m.assignment
+	return res
+}
+
+func (c *MockGroupCoordinator) heartbeat(req *HeartbeatRequest) encoderWithHeader {
+	res := &HeartbeatResponse{Version: req.Version}
+	g := c.group(req.GroupId)
+	c.expireMembers(g)
+
+	m := g.member(req.MemberId)
+	switch {
+	case m == nil:
+		res.Err = ErrUnknownMemberId
+	case g.state == mockGroupPreparingRebalance || g.state == mockGroupCompletingRebalance:
+		m.lastSeen = time.Now()
+		res.Err = ErrRebalanceInProgress
+	case req.GenerationId != g.generation:
+		res.Err = ErrIllegalGeneration
+	default:
+		m.lastSeen = time.Now()
+	}
+	return res
+}
+
+func (c *MockGroupCoordinator) leave(req *LeaveGroupRequest) encoderWithHeader {
+	res := &LeaveGroupResponse{Version: req.Version}
+	g := c.group(req.GroupId)
+
+	if req.Version < 3 {
+		m := g.member(req.MemberId)
+		if m == nil {
+			res.Err = ErrUnknownMemberId
+			return res
+		}
+		c.removeMember(g, m)
+		return res
+	}
+	for _, identity := range req.Members {
+		member := MemberResponse{MemberId: identity.MemberId, GroupInstanceId: identity.GroupInstanceId}
+		m := g.member(identity.MemberId)
+		if m == nil && identity.GroupInstanceId != nil {
+			m = g.staticMember(*identity.GroupInstanceId)
+		}
+		if m == nil {
+			member.Err = ErrUnknownMemberId
+		} else {
+			c.removeMember(g, m)
+		}
+		res.Members = append(res.Members, member)
+	}
+	return res
+}
+
+func (c *MockGroupCoordinator) commit(req *OffsetCommitRequest) encoderWithHeader {
+	res := &OffsetCommitResponse{Version: req.Version}
+	g := c.group(req.ConsumerGroup)
+	c.expireMembers(g)
+
+	var kerr KError
+	// Generation -1 and no member ID are the commits of consumers managing
+	// their partitions themselves.
+	if req.Version >= 1 && (req.ConsumerGroupGeneration >= 0 || req.ConsumerID != "") {
+		m := g.member(req.ConsumerID)
+		switch {
+		case m == nil:
+			kerr = ErrUnknownMemberId
+		case g.state == mockGroupPreparingRebalance:
+			kerr = ErrRebalanceInProgress
+		case req.ConsumerGroupGeneration != g.generation:
+			kerr = ErrIllegalGeneration
+		default:
+			m.lastSeen = time.Now()
+		}
+	}
+	for topic, blocks := range req.blocks {
+		for partition, block := range blocks {
+			if kerr == ErrNoError {
+				g.setOffset(topic, partition, block.offset, block.metadata)
+			}
+			res.AddError(topic, partition, kerr)
+		}
+	}
+	return res
+}
+
+func (c *MockGroupCoordinator) fetchOffsets(req *OffsetFetchRequest) encoderWithHeader {
+	res := &OffsetFetchResponse{Version: req.Version}
+	g := c.group(req.ConsumerGroup)
+
+	if req.partitions == nil {
+		for topic, blocks := range g.offsets {
+			for partition, block := range blocks {
+				b := *block
+				res.AddBlock(topic, partition, &b)
+			}
+		}
+		return res
+	}
+	for topic, partitions := range req.partitions {
+		for _, partition := range partitions {
+			if block, ok := g.offsets[topic][partition]; ok {
+				b := *block
+				res.AddBlock(topic, partition, &b)
+			} else {
+				res.AddBlock(topic, partition, &OffsetFetchResponseBlock{Offset: -1, LeaderEpoch: -1})
+			}
+		}
+	}
+	return res
+}
+
+func (c *MockGroupCoordinator) newMemberID(instanceID *string) string {
+	c.memberID++
+	if instanceID != nil {
+		return fmt.Sprintf("%s-%d", *instanceID, c.memberID)
+	}
+	return fmt.Sprintf("mock-member-%d", c.memberID)
+}
+
+// prepareRebalance makes the members of g rejoin it, waiting for them for
+// the longest of their rebalance timeouts.
+func (c *MockGroupCoordinator) prepareRebalance(g *mockGroup) {
+	g.state = mockGroupPreparingRebalance
+	timeout := time.Duration(0)
+	for _, m := range g.members {
+		if m.sync != nil {
+			m.sync.resolve(&SyncGroupResponse{Version: m.sync.version, Err: ErrRebalanceInProgress})
+			m.sync = nil
+		}
+		if m.rebalanceTimeout > timeout {
+			timeout = m.rebalanceTimeout
+		}
+	}
+
+	generation := g.generation
+	time.AfterFunc(timeout, func() {
+		c.lock.Lock()
+		defer c.lock.Unlock()
+		if g.state != mockGroupPreparingRebalance || g.generation != generation {
+			return
+		}
+		for _, m := range append([]*mockGroupMember(nil), g.members...) {
+			if m.join == nil {
+				g.remove(m)
+			}
+		}
+		c.completeRebalanceIfJoined(g)
+	})
+}
+
+// completeRebalanceIfJoined starts the next generation of g once all its
+// members rejoined it, and answers their JoinGroupRequests.
+func (c *MockGroupCoordinator) completeRebalanceIfJoined(g *mockGroup) {
+	if g.state != mockGroupPreparingRebalance {
+		return
+	}
+	for _, m := range g.members {
+		if m.join == nil {
+			return
+		}
+	}
+
+	g.generation++
+	if len(g.members) == 0 {
+		g.state = mockGroupEmpty
+		g.leader = ""
+		return
+	}
+	g.state = mockGroupCompletingRebalance
+	if g.member(g.leader) == nil {
+		g.leader = g.members[0].id
+	}
+	g.protocol = g.selectProtocol()
+	for _, m := range g.members {
+		m.generation = g.generation
+		m.assignment = nil
+		m.join.resolve(g.joinResponse(m, m.join.version))
+		m.join = nil
+	}
+
+	// Like the members, the leader has to sync within its session timeout.
+	leader, generation := g.member(g.leader), g.generation
+	time.AfterFunc(leader.sessionTimeout, func() {
+		c.lock.Lock()
+		defer c.lock.Unlock()
+		if g.state == mockGroupCompletingRebalance && g.generation == generation && g.member(leader.id) == leader {
+			c.removeMember(g, leader)
+		}
+	})
+}
+
+// removeMember removes m from g, which then rebalances.
+func (c *MockGroupCoordinator) removeMember(g *mockGroup, m *mockGroupMember) {
+	g.remove(m)
+	if m.join != nil {
+		m.join.resolve(&JoinGroupResponse{Version: m.join.version, Err: ErrUnknownMemberId})
+		m.join = nil
+	}
+	if m.sync != nil {
+		m.sync.resolve(&SyncGroupResponse{Version: m.sync.version, Err: ErrUnknownMemberId})
+		m.sync = nil
+	}
+	if g.state != mockGroupPreparingRebalance {
+		c.prepareRebalance(g)
+	}
+	c.completeRebalanceIfJoined(g)
+}
+
+// expireMembers removes the members of g whose session timed out.
+func (c *MockGroupCoordinator) expireMembers(g *mockGroup) {
+	if g.state == mockGroupPreparingRebalance {
+		return
+	}
+	now := time.Now()
+	for _, m := range append([]*mockGroupMember(nil), g.members...) {
+		if m.sessionTimeout > 0 && now.Sub(m.lastSeen) > m.sessionTimeout {
+			Logger.Printf("*** mockgroupcoordinator: session of %s in group %s expired", m.id, g.id)
+			c.removeMember(g, m)
+		}
+	}
+}
+
+func (c *MockGroupCoordinator) newPendingResponse(version int16) *mockPendingResponse {
+	return &mockPendingResponse{
+		version: version,
+		result:  make(chan encoderWithHeader, 1),
+		closing: c.broker.closing,
+	}
+}
+
+func (g *mockGroup) member(id string) *mockGroupMember {
+	for _, m := range g.members {
+		if m.id == id {
+			return m
+		}
+	}
+	return nil
+}
+
+func (g *mockGroup) staticMember(instanceID string) *mockGroupMember {
+	for _, m := range g.members {
+		if m.instanceID != nil && *m.instanceID == instanceID {
+			return m
+		}
+	}
+	return nil
+}
+
+func (g *mockGroup) remove(m *mockGroupMember) {
+	for i, member := range g.members {
+		if member == m {
+			g.members = append(g.members[:i], g.members[i+1:]...)
+			return
+		}
+	}
+}
+
+// accepts returns whether a member with protocols can join g, that is if
+// they have a protocol in common with all its members.
+func (g *mockGroup) accepts(protocolType string, protocols []*GroupProtocol) bool {
+	if len(protocols) == 0 {
+		return false
+	}
+	if len(g.members) == 0 {
+		return true
+	}
+	for _, p := range protocols {
+		supported := true
+		for _, m := range g.members {
+			if m.protocolType != protocolType || m.protocol(p.Name) == nil {
+				supported = false
+				break
+			}
+		}
+		if supported {
+			return true
+		}
+	}
+	return false
+}
+
+// selectProtocol returns the first protocol of the leader that all the
+// members support.
+func (g *mockGroup) selectProtocol() string {
+	for _, p := range g.member(g.leader).protocols {
+		supported := true
+		for _, m := range g.members {
+			if m.protocol(p.Name) == nil {
+				supported = false
+				break
+			}
+		}
+		if supported {
+			return p.Name
+		}
+	}
+	return ""
+}
+
+// joinResponse returns the JoinGroupResponse of m for the current
+// generation, which only lists the members for the leader.
+func (g *mockGroup) joinResponse(m *mockGroupMember, version int16) *JoinGroupResponse {
+	res := &JoinGroupResponse{
+		Version:       version,
+		GenerationId:  g.generation,
+		GroupProtocol: g.protocol,
+		LeaderId:      g.leader,
+		MemberId:      m.id,
+	}
+	if m.id == g.leader {
+		for _, member := range g.members {
+			res.Members = append(res.Members, GroupMember{
+				MemberId:        member.id,
+				GroupInstanceId: member.instanceID,
+				Metadata:        member.protocol(g.protocol).Metadata,
+			})
+		}
+	}
+	return res
+}
+
+func (g *mockGroup) setOffset(topic string, partition int32, offset int64, metadata string) {
+	partitions := g.offsets[topic]
+	if partitions == nil {
+		partitions = make(map[int32]*OffsetFetchResponseBlock)
+		g.offsets[topic] = partitions
+	}
+	partitions[partition] = &OffsetFetchResponseBlock{Offset: offset, LeaderEpoch: -1, Metadata: metadata}
+}
+
+func (m *mockGroupMember) protocol(name string) *GroupProtocol {
+	for _, p := range m.protocols {
+		if p.Name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+func sameGroupProtocols(a, b []*GroupProtocol) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Name != b[i].Name || string(a[i].Metadata) != string(b[i].Metadata) {
+			return false
+		}
+	}
+	return true
+}
+
+// mockPendingResponse is a response that is not known yet when the broker
+// handles the request, such as the JoinGroupResponse waiting for the other
+// members. MockBroker encodes responses without holding its lock, so the
+// connection waits for resolve while the other connections are served.
+type mockPendingResponse struct {
+	version int16
+	result  chan encoderWithHeader
+	closing chan none
+	// res is only set by the connection, once resolved.
+	res encoderWithHeader
+}
+
+func (p *mockPendingResponse) resolve(res encoderWithHeader) {
+	p.result <- res
+}
+
+func (p *mockPendingResponse) encode(pe packetEncoder) error {
+	if p.res == nil {
+		select {
+		case p.res = <-p.result:
+		case <-p.closing:
+			// The connection is closed along with the broker.
+			return io.EOF
+		}
+	}
+	return p.res.encode(pe)
+}
+
+func (p *mockPendingResponse) headerVersion() int16 {
+	return p.res.headerVersion()
+}
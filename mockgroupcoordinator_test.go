@@ -0,0 +1,222 @@
+package sarama
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+)
+
+type claimsHandler struct {
+	lock   sync.Mutex
+	claims map[string][]int32
+	setups int
+}
+
+func (h *claimsHandler) Setup(s ConsumerGroupSession) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.claims = s.Claims()
+	h.setups++
+	return nil
+}
+
+func (h *claimsHandler) Cleanup(s ConsumerGroupSession) error { return nil }
+
+func (h *claimsHandler) ConsumeClaim(sess ConsumerGroupSession, claim ConsumerGroupClaim) error {
+	for range claim.Messages() {
+	}
+	return nil
+}
+
+func (h *claimsHandler) partitions() ([]int32, int) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	partitions := append([]int32(nil), h.claims["my-topic"]...)
+	sort.Slice(partitions, func(i, j int) bool { return partitions[i] < partitions[j] })
+	return partitions, h.setups
+}
+
+func newMockGroupCoordinatorBroker(t *testing.T) (*MockBroker, *MockGroupCoordinator) {
+	broker := NewMockBroker(t, 0)
+	coordinator := NewMockGroupCoordinator(t, broker)
+	metadata := NewMockMetadataResponse(t).SetBroker(broker.Addr(), broker.BrokerID())
+	offsets := NewMockOffsetResponse(t)
+	for partition := int32(0); partition < 4; partition++ {
+		metadata.SetLeader("my-topic", partition, broker.BrokerID())
+		offsets.SetOffset("my-topic", partition, OffsetOldest, 0).
+			SetOffset("my-topic", partition, OffsetNewest, 0)
+	}
+	broker.SetHandlerByMap(map[string]MockResponse{
+		"ApiVersionsRequest":     NewMockApiVersionsResponse(t),
+		"MetadataRequest":        metadata,
+		"OffsetRequest":          offsets,
+		"FetchRequest":           NewMockFetchResponse(t, 1),
+		"FindCoordinatorRequest": coordinator,
+		"JoinGroupRequest":       coordinator,
+		"SyncGroupRequest":       coordinator,
+		"HeartbeatRequest":       coordinator,
+		"LeaveGroupRequest":      coordinator,
+		"OffsetCommitRequest":    coordinator,
+		"OffsetFetchRequest":     coordinator,
+	})
+	return broker, coordinator
+}
+
+func newMockGroupCoordinatorConfig(t *testing.T) *Config {
+	config := NewTestConfig()
+	config.ClientID = t.Name()
+	config.Version = V2_4_0_0
+	config.Consumer.Group.Rebalance.Timeout = time.Second
+	config.Consumer.Group.Rebalance.Retry.Backoff = 10 * time.Millisecond
+	config.Consumer.Group.Heartbeat.Interval = 50 * time.Millisecond
+	config.Consumer.Offsets.AutoCommit.Interval = 50 * time.Millisecond
+	config.Consumer.Fetch.Default = 1024
+	config.Consumer.MaxWaitTime = 50 * time.Millisecond
+	return config
+}
+
+func consumeMockGroup(t *testing.T, ctx context.Context, wg *sync.WaitGroup, group ConsumerGroup, h ConsumerGroupHandler) {
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for ctx.Err() == nil {
+			err := group.Consume(ctx, []string{"my-topic"}, h)
+			if errors.Is(err, ErrClosedConsumerGroup) {
+				return
+			}
+			if err != nil && ctx.Err() == nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+}
+
+func TestMockGroupCoordinatorRebalances(t *testing.T) {
+	broker, coordinator := newMockGroupCoordinatorBroker(t)
+	defer broker.Close()
+	config := newMockGroupCoordinatorConfig(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var wg sync.WaitGroup
+
+	group1, err := NewConsumerGroup([]string{broker.Addr()}, "my-group", config)
+	assert.NoError(t, err)
+	h1 := &claimsHandler{}
+	consumeMockGroup(t, ctx, &wg, group1, h1)
+	assert.Eventually(t, func() bool {
+		partitions, _ := h1.partitions()
+		return len(partitions) == 4
+	}, 5*time.Second, 10*time.Millisecond, "the first member should get all the partitions")
+	assert.Len(t, coordinator.Members("my-group"), 1)
+
+	group2, err := NewConsumerGroup([]string{broker.Addr()}, "my-group", config)
+	assert.NoError(t, err)
+	h2 := &claimsHandler{}
+	consumeMockGroup(t, ctx, &wg, group2, h2)
+	assert.Eventually(t, func() bool {
+		p1, _ := h1.partitions()
+		p2, _ := h2.partitions()
+		return len(p1) == 2 && len(p2) == 2
+	}, 5*time.Second, 10*time.Millisecond, "the members should share the partitions")
+	p1, _ := h1.partitions()
+	p2, _ := h2.partitions()
+	assert.ElementsMatch(t, []int32{0, 1, 2, 3}, append(p1, p2...))
+	assert.Len(t, coordinator.Members("my-group"), 2)
+
+	_, setups := h1.partitions()
+	coordinator.TriggerRebalance("my-group")
+	assert.Eventually(t, func() bool {
+		_, s := h1.partitions()
+		return s > setups
+	}, 5*time.Second, 10*time.Millisecond, "TriggerRebalance should start a new session")
+
+	assert.NoError(t, group2.Close())
+	assert.Eventually(t, func() bool {
+		partitions, _ := h1.partitions()
+		return len(partitions) == 4
+	}, 5*time.Second, 10*time.Millisecond, "the remaining member should get all the partitions")
+	assert.Len(t, coordinator.Members("my-group"), 1)
+
+	cancel()
+	wg.Wait()
+	assert.NoError(t, group1.Close())
+}
+
+func TestMockGroupCoordinatorOffsets(t *testing.T) {
+	broker, coordinator := newMockGroupCoordinatorBroker(t)
+	defer broker.Close()
+	coordinator.SetOffset("my-group", "my-topic", 0, 5, "meta")
+
+	config := NewTestConfig()
+	config.Version = V2_4_0_0
+	client, err := NewClient([]string{broker.Addr()}, config)
+	assert.NoError(t, err)
+	defer safeClose(t, client)
+	manager, err := NewOffsetManagerFromClient("my-group", client)
+	assert.NoError(t, err)
+	defer safeClose(t, manager)
+
+	pom, err := manager.ManagePartition("my-topic", 0)
+	assert.NoError(t, err)
+	defer safeClose(t, pom)
+	offset, metadata := pom.NextOffset()
+	assert.Equal(t, int64(5), offset)
+	assert.Equal(t, "meta", metadata)
+
+	pom.MarkOffset(7, "")
+	manager.Commit()
+	committed, ok := coordinator.Offset("my-group", "my-topic", 0)
+	assert.True(t, ok)
+	assert.Equal(t, int64(7), committed)
+
+	pom1, err := manager.ManagePartition("my-topic", 1)
+	assert.NoError(t, err)
+	defer safeClose(t, pom1)
+	offset, _ = pom1.NextOffset()
+	assert.Equal(t, config.Consumer.Offsets.Initial, offset)
+	_, ok = coordinator.Offset("my-group", "my-topic", 1)
+	assert.False(t, ok)
+}
+
+func TestMockGroupCoordinatorErrors(t *testing.T) {
+	broker := NewMockBroker(t, 0)
+	defer broker.Close()
+	coordinator := NewMockGroupCoordinator(t, broker)
+
+	join := &JoinGroupRequest{
+		Version:        4,
+		GroupId:        "my-group",
+		SessionTimeout: 10000,
+		ProtocolType:   "consumer",
+	}
+	join.AddGroupProtocol(RangeBalanceStrategyName, []byte{1})
+
+	res := coordinator.For(join).(*JoinGroupResponse)
+	assert.Equal(t, ErrMemberIdRequired, res.Err)
+	assert.NotEmpty(t, res.MemberId)
+	assert.Empty(t, coordinator.Members("my-group"))
+
+	join.MemberId = "unknown"
+	res = coordinator.For(join).(*JoinGroupResponse)
+	assert.Equal(t, ErrUnknownMemberId, res.Err)
+
+	heartbeat := coordinator.For(&HeartbeatRequest{Version: 3, GroupId: "my-group", MemberId: "unknown"}).(*HeartbeatResponse)
+	assert.Equal(t, ErrUnknownMemberId, heartbeat.Err)
+
+	sync := coordinator.For(&SyncGroupRequest{Version: 3, GroupId: "my-group", MemberId: "unknown"}).(*SyncGroupResponse)
+	assert.Equal(t, ErrUnknownMemberId, sync.Err)
+
+	commit := &OffsetCommitRequest{Version: 2, ConsumerGroup: "my-group", ConsumerGroupGeneration: 1, ConsumerID: "unknown"}
+	commit.AddBlock("my-topic", 0, 1, 0, "")
+	commitRes := coordinator.For(commit).(*OffsetCommitResponse)
+	assert.Equal(t, ErrUnknownMemberId, commitRes.Errors["my-topic"][0])
+	_, ok := coordinator.Offset("my-group", "my-topic", 0)
+	assert.False(t, ok)
+}
@@ -0,0 +1,176 @@
+package sarama
+
+import "time"
+
+// MockRecordBatch is a builder of v2 record batches for FetchResponse mocks,
+// with headers, compression and the producer fields of idempotent and
+// transactional batches. Control batches, such as the markers ending
+// transactions, are built by NewMockControlBatch.
+//
+// It only holds the content of the batch: RecordBatch builds a new
+// RecordBatch every time, so that the same MockRecordBatch can be returned
+// by concurrent responses.
+type MockRecordBatch struct {
+	firstOffset      int64
+	firstTimestamp   time.Time
+	logAppendTime    *time.Time
+	codec            CompressionCodec
+	compressionLevel int
+	leaderEpoch      int32
+	producerID       int64
+	producerEpoch    int16
+	firstSequence    int32
+	transactional    bool
+	control          bool
+	records          []*mockBatchRecord
+}
+
+type mockBatchRecord struct {
+	key, value Encoder
+	timestamp  time.Time
+	headers    []RecordHeader
+}
+
+// NewMockRecordBatch returns an empty batch starting at firstOffset, whose
+// records have consecutive offsets.
+func NewMockRecordBatch(firstOffset int64) *MockRecordBatch {
+	return &MockRecordBatch{
+		firstOffset:      firstOffset,
+		compressionLevel: CompressionLevelDefault,
+		producerID:       noProducerID,
+		producerEpoch:    noProducerEpoch,
+		firstSequence:    -1,
+	}
+}
+
+// NewMockControlBatch returns the control batch at offset ending the
+// transaction of producerID, committed or aborted according to recordType.
+func NewMockControlBatch(offset int64, producerID int64, producerEpoch int16, recordType ControlRecordType) *MockRecordBatch {
+	b := NewMockRecordBatch(offset).SetTransactional(producerID, producerEpoch)
+	b.control = true
+
+	crKey := &realEncoder{raw: make([]byte, 4)}
+	crValue := &realEncoder{raw: make([]byte, 6)}
+	cr := ControlRecord{Version: 0, Type: recordType}
+	cr.encode(crKey, crValue)
+	b.records = append(b.records, &mockBatchRecord{key: ByteEncoder(crKey.raw), value: ByteEncoder(crValue.raw)})
+	return b
+}
+
+// AddRecord appends a record with headers to the batch.
+func (b *MockRecordBatch) AddRecord(key, value Encoder, headers ...RecordHeader) *MockRecordBatch {
+	return b.AddRecordWithTimestamp(key, value, b.firstTimestamp, headers...)
+}
+
+// AddRecordWithTimestamp appends a record with headers created at timestamp
+// to the batch.
+func (b *MockRecordBatch) AddRecordWithTimestamp(key, value Encoder, timestamp time.Time, headers ...RecordHeader) *MockRecordBatch {
+	b.records = append(b.records, &mockBatchRecord{key: key, value: value, timestamp: timestamp, headers: headers})
+	return b
+}
+
+// SetTimestamp sets the timestamp of the first record of the batch, which
+// is also the timestamp of the records added by AddRecord.
+func (b *MockRecordBatch) SetTimestamp(timestamp time.Time) *MockRecordBatch {
+	b.firstTimestamp = timestamp
+	return b
+}
+
+// SetLogAppendTime makes the batch use the time it was appended to the log
+// instead of the creation time of its records.
+func (b *MockRecordBatch) SetLogAppendTime(timestamp time.Time) *MockRecordBatch {
+	b.logAppendTime = &timestamp
+	return b
+}
+
+// SetCompression compresses the records of the batch with codec.
+func (b *MockRecordBatch) SetCompression(codec CompressionCodec, level int) *MockRecordBatch {
+	b.codec = codec
+	b.compressionLevel = level
+	return b
+}
+
+// SetLeaderEpoch sets the epoch of the leader that appended the batch.
+func (b *MockRecordBatch) SetLeaderEpoch(epoch int32) *MockRecordBatch {
+	b.leaderEpoch = epoch
+	return b
+}
+
+// SetProducer sets the producer of an idempotent batch, and the sequence
+// number of its first record.
+func (b *MockRecordBatch) SetProducer(producerID int64, producerEpoch int16, firstSequence int32) *MockRecordBatch {
+	b.producerID = producerID
+	b.producerEpoch = producerEpoch
+	b.firstSequence = firstSequence
+	return b
+}
+
+// SetTransactional makes the batch part of a transaction of producerID.
+func (b *MockRecordBatch) SetTransactional(producerID int64, producerEpoch int16) *MockRecordBatch {
+	b.producerID = producerID
+	b.producerEpoch = producerEpoch
+	b.transactional = true
+	return b
+}
+
+// FirstOffset returns the offset of the first record of the batch.
+func (b *MockRecordBatch) FirstOffset() int64 {
+	return b.firstOffset
+}
+
+// LastOffset returns the offset of the last record of the batch.
+func (b *MockRecordBatch) LastOffset() int64 {
+	if len(b.records) == 0 {
+		return b.firstOffset
+	}
+	return b.firstOffset + int64(len(b.records)) - 1
+}
+
+// Bytes returns the batch encoded as it is in the log segments and the fetch
+// responses, for testing the tools reading raw record batches.
+func (b *MockRecordBatch) Bytes() ([]byte, error) {
+	return encode(b.RecordBatch(), nil)
+}
+
+// RecordBatch returns a new RecordBatch holding the records of the batch.
+func (b *MockRecordBatch) RecordBatch() *RecordBatch {
+	batch := &RecordBatch{
+		Version:              2,
+		FirstOffset:          b.firstOffset,
+		PartitionLeaderEpoch: b.leaderEpoch,
+		Codec:                b.codec,
+		CompressionLevel:     b.compressionLevel,
+		Control:              b.control,
+		FirstTimestamp:       b.firstTimestamp,
+		MaxTimestamp:         b.firstTimestamp,
+		ProducerID:           b.producerID,
+		ProducerEpoch:        b.producerEpoch,
+		FirstSequence:        b.firstSequence,
+		IsTransactional:      b.transactional,
+	}
+	if len(b.records) > 0 {
+		batch.LastOffsetDelta = int32(len(b.records) - 1)
+	}
+	for i, r := range b.records {
+		kb, vb := encodeKV(r.key, r.value)
+		record := &Record{
+			OffsetDelta:    int64(i),
+			TimestampDelta: r.timestamp.Sub(b.firstTimestamp),
+			Key:            kb,
+			Value:          vb,
+		}
+		for _, h := range r.headers {
+			h := h
+			record.Headers = append(record.Headers, &h)
+		}
+		if r.timestamp.After(batch.MaxTimestamp) {
+			batch.MaxTimestamp = r.timestamp
+		}
+		batch.addRecord(record)
+	}
+	if b.logAppendTime != nil {
+		batch.LogAppendTime = true
+		batch.MaxTimestamp = *b.logAppendTime
+	}
+	return batch
+}
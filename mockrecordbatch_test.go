@@ -0,0 +1,130 @@
+package sarama
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMockRecordBatchEncoding(t *testing.T) {
+	timestamp := time.Unix(1700000000, 0)
+	batch := NewMockRecordBatch(10).
+		SetTimestamp(timestamp).
+		SetProducer(7, 1, 100).
+		SetCompression(CompressionGZIP, CompressionLevelDefault).
+		AddRecord(StringEncoder("k1"), StringEncoder("v1"), RecordHeader{Key: []byte("h"), Value: []byte("1")}).
+		AddRecordWithTimestamp(nil, StringEncoder("v2"), timestamp.Add(time.Second))
+	if batch.LastOffset() != 11 {
+		t.Fatal("expected the last offset to be 11, got", batch.LastOffset())
+	}
+
+	encoded, err := encode(batch.RecordBatch(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := new(RecordBatch)
+	if err := decode(encoded, decoded, nil); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.FirstOffset != 10 || decoded.LastOffsetDelta != 1 || decoded.Codec != CompressionGZIP {
+		t.Errorf("unexpected batch %+v", decoded)
+	}
+	if decoded.ProducerID != 7 || decoded.ProducerEpoch != 1 || decoded.FirstSequence != 100 || decoded.IsTransactional {
+		t.Errorf("unexpected producer fields %+v", decoded)
+	}
+	if !decoded.MaxTimestamp.Equal(timestamp.Add(time.Second)) {
+		t.Error("expected the max timestamp to be the one of the last record, got", decoded.MaxTimestamp)
+	}
+	if len(decoded.Records) != 2 {
+		t.Fatal("expected 2 records, got", len(decoded.Records))
+	}
+	first := decoded.Records[0]
+	if string(first.Key) != "k1" || string(first.Value) != "v1" || len(first.Headers) != 1 || string(first.Headers[0].Key) != "h" {
+		t.Errorf("unexpected first record %+v", first)
+	}
+	if second := decoded.Records[1]; second.Key != nil || second.OffsetDelta != 1 || second.TimestampDelta != time.Second {
+		t.Errorf("unexpected second record %+v", second)
+	}
+}
+
+func TestMockControlBatch(t *testing.T) {
+	encoded, err := encode(NewMockControlBatch(5, 7, 2, ControlRecordCommit).RecordBatch(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := new(RecordBatch)
+	if err := decode(encoded, decoded, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !decoded.Control || !decoded.IsTransactional || decoded.ProducerID != 7 || decoded.ProducerEpoch != 2 {
+		t.Fatalf("unexpected control batch %+v", decoded)
+	}
+	records := newDefaultRecords(decoded)
+	record, err := records.getControlRecord()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.Type != ControlRecordCommit {
+		t.Error("expected a commit marker, got", record.Type)
+	}
+}
+
+func TestMockFetchResponseRecordBatches(t *testing.T) {
+	broker0 := NewMockBroker(t, 0)
+	defer broker0.Close()
+
+	header := RecordHeader{Key: []byte("trace"), Value: []byte("abc")}
+	broker0.SetHandlerByMap(map[string]MockResponse{
+		"MetadataRequest": NewMockMetadataResponse(t).
+			SetBroker(broker0.Addr(), broker0.BrokerID()).
+			SetLeader("my_topic", 0, broker0.BrokerID()),
+		"OffsetRequest": NewMockOffsetResponse(t).
+			SetOffset("my_topic", 0, OffsetOldest, 0).
+			SetOffset("my_topic", 0, OffsetNewest, 7),
+		"FetchRequest": NewMockFetchResponse(t, 10).
+			SetRecordBatch("my_topic", 0, NewMockRecordBatch(0).SetTransactional(7, 0).
+				AddRecord(nil, StringEncoder("committed"), header)).
+			SetRecordBatch("my_topic", 0, NewMockControlBatch(1, 7, 0, ControlRecordCommit)).
+			SetRecordBatch("my_topic", 0, NewMockRecordBatch(2).SetTransactional(8, 0).
+				AddRecord(nil, StringEncoder("aborted")).
+				AddRecord(nil, StringEncoder("aborted"))).
+			SetRecordBatch("my_topic", 0, NewMockControlBatch(4, 8, 0, ControlRecordAbort)).
+			SetRecordBatch("my_topic", 0, NewMockRecordBatch(5).SetCompression(CompressionSnappy, CompressionLevelDefault).
+				AddRecord(StringEncoder("key"), StringEncoder("compressed"))).
+			SetAbortedTransaction("my_topic", 0, 8, 2).
+			SetHighWaterMark("my_topic", 0, 6),
+	})
+
+	cfg := NewTestConfig()
+	cfg.Consumer.Return.Errors = true
+	cfg.Version = V0_11_0_0
+	cfg.Consumer.IsolationLevel = ReadCommitted
+	master, err := NewConsumer([]string{broker0.Addr()}, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer safeClose(t, master)
+	consumer, err := master.ConsumePartition("my_topic", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer safeClose(t, consumer)
+
+	select {
+	case message := <-consumer.Messages():
+		assertMessageOffset(t, message, 0)
+		assertMessageValue(t, message, StringEncoder("committed"))
+		if len(message.Headers) != 1 || string(message.Headers[0].Value) != "abc" {
+			t.Errorf("expected the header of the record, got %+v", message.Headers)
+		}
+	case err := <-consumer.Errors():
+		t.Fatal(err)
+	}
+	select {
+	case message := <-consumer.Messages():
+		assertMessageOffset(t, message, 5)
+		assertMessageKey(t, message, StringEncoder("key"))
+		assertMessageValue(t, message, StringEncoder("compressed"))
+	case err := <-consumer.Errors():
+		t.Fatal(err)
+	}
+}
@@ -132,6 +132,43 @@ func (m *MockDescribeGroupsResponse) For(reqBody versionedDecoder) encoderWithHe
 	return response
 }
 
+type MockConsumerGroupDescribeResponse struct {
+	groups map[string]*ConsumerGroupDescription
+	t      TestReporter
+}
+
+func NewMockConsumerGroupDescribeResponse(t TestReporter) *MockConsumerGroupDescribeResponse {
+	return &MockConsumerGroupDescribeResponse{
+		t:      t,
+		groups: make(map[string]*ConsumerGroupDescription),
+	}
+}
+
+func (m *MockConsumerGroupDescribeResponse) AddGroupDescription(groupID string, description *ConsumerGroupDescription) *MockConsumerGroupDescribeResponse {
+	m.groups[groupID] = description
+	return m
+}
+
+func (m *MockConsumerGroupDescribeResponse) For(reqBody versionedDecoder) encoderWithHeader {
+	request := reqBody.(*ConsumerGroupDescribeRequest)
+
+	response := &ConsumerGroupDescribeResponse{Version: request.version()}
+	for _, requestedGroup := range request.GroupIDs {
+		if group, ok := m.groups[requestedGroup]; ok {
+			response.Groups = append(response.Groups, group)
+		} else {
+			// Mimic real kafka - groups not using the consumer group
+			// protocol are not found
+			response.Groups = append(response.Groups, &ConsumerGroupDescription{
+				Err:     ErrGroupIDNotFound,
+				GroupID: requestedGroup,
+			})
+		}
+	}
+
+	return response
+}
+
 // MockMetadataResponse is a `MetadataResponse` builder.
 type MockMetadataResponse struct {
 	controllerID int32
@@ -291,20 +328,26 @@ func newMockMessage(key, msg Encoder) *mockMessage {
 
 // MockFetchResponse is a `FetchResponse` builder.
 type MockFetchResponse struct {
-	messages       map[string]map[int32]map[int64]*mockMessage
-	messagesLock   *sync.RWMutex
-	highWaterMarks map[string]map[int32]int64
-	t              TestReporter
-	batchSize      int
+	messages            map[string]map[int32]map[int64]*mockMessage
+	batches             map[string]map[int32][]*MockRecordBatch
+	abortedTransactions map[string]map[int32][]*AbortedTransaction
+	lastStableOffsets   map[string]map[int32]int64
+	messagesLock        *sync.RWMutex
+	highWaterMarks      map[string]map[int32]int64
+	t                   TestReporter
+	batchSize           int
 }
 
 func NewMockFetchResponse(t TestReporter, batchSize int) *MockFetchResponse {
 	return &MockFetchResponse{
-		messages:       make(map[string]map[int32]map[int64]*mockMessage),
-		messagesLock:   &sync.RWMutex{},
-		highWaterMarks: make(map[string]map[int32]int64),
-		t:              t,
-		batchSize:      batchSize,
+		messages:            make(map[string]map[int32]map[int64]*mockMessage),
+		batches:             make(map[string]map[int32][]*MockRecordBatch),
+		abortedTransactions: make(map[string]map[int32][]*AbortedTransaction),
+		lastStableOffsets:   make(map[string]map[int32]int64),
+		messagesLock:        &sync.RWMutex{},
+		highWaterMarks:      make(map[string]map[int32]int64),
+		t:                   t,
+		batchSize:           batchSize,
 	}
 }
 
@@ -329,6 +372,49 @@ func (mfr *MockFetchResponse) SetMessageWithKey(topic string, partition int32, o
 	return mfr
 }
 
+// SetRecordBatch appends batch to the records of a partition. The fetch
+// requests get up to batchSize of the batches from the fetch offset, instead
+// of the messages set by SetMessage.
+func (mfr *MockFetchResponse) SetRecordBatch(topic string, partition int32, batch *MockRecordBatch) *MockFetchResponse {
+	mfr.messagesLock.Lock()
+	defer mfr.messagesLock.Unlock()
+	partitions := mfr.batches[topic]
+	if partitions == nil {
+		partitions = make(map[int32][]*MockRecordBatch)
+		mfr.batches[topic] = partitions
+	}
+	partitions[partition] = append(partitions[partition], batch)
+	return mfr
+}
+
+// SetAbortedTransaction records that the transaction of producerID starting
+// at firstOffset was aborted, so that read_committed consumers skip it.
+func (mfr *MockFetchResponse) SetAbortedTransaction(topic string, partition int32, producerID int64, firstOffset int64) *MockFetchResponse {
+	mfr.messagesLock.Lock()
+	defer mfr.messagesLock.Unlock()
+	partitions := mfr.abortedTransactions[topic]
+	if partitions == nil {
+		partitions = make(map[int32][]*AbortedTransaction)
+		mfr.abortedTransactions[topic] = partitions
+	}
+	partitions[partition] = append(partitions[partition], &AbortedTransaction{ProducerID: producerID, FirstOffset: firstOffset})
+	return mfr
+}
+
+// SetLastStableOffset sets the offset of the first record of a partition
+// that is part of an open transaction.
+func (mfr *MockFetchResponse) SetLastStableOffset(topic string, partition int32, offset int64) *MockFetchResponse {
+	mfr.messagesLock.Lock()
+	defer mfr.messagesLock.Unlock()
+	partitions := mfr.lastStableOffsets[topic]
+	if partitions == nil {
+		partitions = make(map[int32]int64)
+		mfr.lastStableOffsets[topic] = partitions
+	}
+	partitions[partition] = offset
+	return mfr
+}
+
 func (mfr *MockFetchResponse) SetHighWaterMark(topic string, partition int32, offset int64) *MockFetchResponse {
 	partitions := mfr.highWaterMarks[topic]
 	if partitions == nil {
@@ -347,15 +433,19 @@ func (mfr *MockFetchResponse) For(reqBody versionedDecoder) encoderWithHeader {
 	for topic, partitions := range fetchRequest.blocks {
 		for partition, block := range partitions {
 			initialOffset := block.fetchOffset
-			offset := initialOffset
-			maxOffset := initialOffset + int64(mfr.getMessageCount(topic, partition))
-			for i := 0; i < mfr.batchSize && offset < maxOffset; {
-				msg := mfr.getMessage(topic, partition, offset)
-				if msg != nil {
-					res.AddMessage(topic, partition, msg.key, msg.msg, offset)
-					i++
+			if batches := mfr.getRecordBatches(topic, partition, initialOffset); len(batches) > 0 {
+				res.AddRecordBatches(topic, partition, batches...)
+			} else {
+				offset := initialOffset
+				maxOffset := initialOffset + int64(mfr.getMessageCount(topic, partition))
+				for i := 0; i < mfr.batchSize && offset < maxOffset; {
+					msg := mfr.getMessage(topic, partition, offset)
+					if msg != nil {
+						res.AddMessage(topic, partition, msg.key, msg.msg, offset)
+						i++
+					}
+					offset++
 				}
-				offset++
 			}
 			fb := res.GetBlock(topic, partition)
 			if fb == nil {
@@ -363,6 +453,18 @@ func (mfr *MockFetchResponse) For(reqBody versionedDecoder) encoderWithHeader {
 				fb = res.GetBlock(topic, partition)
 			}
 			fb.HighWaterMarkOffset = mfr.getHighWaterMark(topic, partition)
+			mfr.messagesLock.RLock()
+			fb.LastStableOffset = fb.HighWaterMarkOffset
+			if offset, ok := mfr.lastStableOffsets[topic][partition]; ok {
+				fb.LastStableOffset = offset
+			}
+			for _, aborted := range mfr.abortedTransactions[topic][partition] {
+				fb.AbortedTransactions = append(fb.AbortedTransactions, &AbortedTransaction{
+					ProducerID:  aborted.ProducerID,
+					FirstOffset: aborted.FirstOffset,
+				})
+			}
+			mfr.messagesLock.RUnlock()
 		}
 	}
 	return res
@@ -382,6 +484,23 @@ func (mfr *MockFetchResponse) getMessage(topic string, partition int32, offset i
 	return messages[offset]
 }
 
+// getRecordBatches returns up to batchSize batches of a partition holding
+// offset or later records.
+func (mfr *MockFetchResponse) getRecordBatches(topic string, partition int32, offset int64) []*RecordBatch {
+	mfr.messagesLock.RLock()
+	defer mfr.messagesLock.RUnlock()
+	var batches []*RecordBatch
+	for _, batch := range mfr.batches[topic][partition] {
+		if len(batches) >= mfr.batchSize {
+			break
+		}
+		if batch.LastOffset() >= offset {
+			batches = append(batches, batch.RecordBatch())
+		}
+	}
+	return batches
+}
+
 func (mfr *MockFetchResponse) getMessageCount(topic string, partition int32) int {
 	mfr.messagesLock.RLock()
 	defer mfr.messagesLock.RUnlock()
@@ -858,6 +977,12 @@ func (mr *MockDescribeConfigsResponse) For(reqBody versionedDecoder) encoderWith
 			if includeSource {
 				maxMessageBytes.Source = SourceDefault
 			}
+			if req.Version >= 3 {
+				maxMessageBytes.Type = ConfigTypeInt
+				if req.IncludeDocumentation {
+					maxMessageBytes.Documentation = "The largest record batch size allowed by Kafka (after compression if compression is enabled)."
+				}
+			}
 			if includeSynonyms {
 				maxMessageBytes.Synonyms = []*ConfigSynonym{
 					{
@@ -1378,6 +1503,7 @@ func (m *MockHeartbeatResponse) For(reqBody versionedDecoder) encoderWithHeader
 	req := reqBody.(*HeartbeatRequest)
 	resp := &HeartbeatResponse{
 		Version: req.version(),
+		Err:     m.Err,
 	}
 	return resp
 }
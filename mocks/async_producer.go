@@ -1,6 +1,7 @@
 package mocks
 
 import (
+	"context"
 	"errors"
 	"sync"
 
@@ -19,12 +20,15 @@ type AsyncProducer struct {
 	expectations    []*producerExpectation
 	closed          chan struct{}
 	input           chan *sarama.ProducerMessage
+	inputLock       sync.RWMutex // held by TrySend and SendWithContext while writing to input
+	inputClosed     bool
 	successes       chan *sarama.ProducerMessage
 	errors          chan *sarama.ProducerError
 	isTransactional bool
 	txnLock         sync.Mutex
 	txnStatus       sarama.ProducerTxnStatusFlag
 	lastOffset      int64
+	produced        producedMessages
 	*TopicConfig
 }
 
@@ -74,6 +78,7 @@ func NewAsyncProducer(t ErrorReporter, config *sarama.Config) *AsyncProducer {
 				partitioners[msg.Topic] = partitioner
 			}
 			mp.l.Lock()
+			mp.produced.record(msg)
 			if mp.expectations == nil || len(mp.expectations) == 0 {
 				mp.expectations = nil
 				mp.t.Errorf("No more expectation set on this mock producer to handle the input message.")
@@ -112,6 +117,7 @@ func NewAsyncProducer(t ErrorReporter, config *sarama.Config) *AsyncProducer {
 		if len(mp.expectations) > 0 {
 			mp.t.Errorf("Expected to exhaust all expectations, but %d are left.", len(mp.expectations))
 		}
+		mp.produced.verifyCounts(mp.t)
 		mp.l.Unlock()
 	}()
 
@@ -126,6 +132,9 @@ func NewAsyncProducer(t ErrorReporter, config *sarama.Config) *AsyncProducer {
 // By closing a mock producer, you also tell it that no more input will be provided, so it will
 // write an error to the test state if there's any remaining expectations.
 func (mp *AsyncProducer) AsyncClose() {
+	mp.inputLock.Lock()
+	defer mp.inputLock.Unlock()
+	mp.inputClosed = true
 	close(mp.input)
 }
 
@@ -147,6 +156,42 @@ func (mp *AsyncProducer) Input() chan<- *sarama.ProducerMessage {
 	return mp.input
 }
 
+// TrySend corresponds with the TrySend method of sarama's Producer implementation.
+// It writes msg to the Input channel if it would not block, and returns
+// sarama.ErrProducerQueueFull otherwise, or sarama.ErrShuttingDown once closed.
+func (mp *AsyncProducer) TrySend(msg *sarama.ProducerMessage) error {
+	mp.inputLock.RLock()
+	defer mp.inputLock.RUnlock()
+
+	if mp.inputClosed {
+		return sarama.ErrShuttingDown
+	}
+	select {
+	case mp.input <- msg:
+		return nil
+	default:
+		return sarama.ErrProducerQueueFull
+	}
+}
+
+// SendWithContext corresponds with the SendWithContext method of sarama's Producer
+// implementation. It writes msg to the Input channel, or returns the error of ctx once done,
+// or sarama.ErrShuttingDown once closed.
+func (mp *AsyncProducer) SendWithContext(ctx context.Context, msg *sarama.ProducerMessage) error {
+	mp.inputLock.RLock()
+	defer mp.inputLock.RUnlock()
+
+	if mp.inputClosed {
+		return sarama.ErrShuttingDown
+	}
+	select {
+	case mp.input <- msg:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 // Successes corresponds with the Successes method of sarama's Producer implementation.
 func (mp *AsyncProducer) Successes() <-chan *sarama.ProducerMessage {
 	return mp.successes
@@ -192,6 +237,34 @@ func (mp *AsyncProducer) TxnStatus() sarama.ProducerTxnStatusFlag {
 	return mp.txnStatus
 }
 
+func (mp *AsyncProducer) PrepareTxn() (sarama.PreparedTxnState, error) {
+	mp.txnLock.Lock()
+	defer mp.txnLock.Unlock()
+
+	mp.txnStatus = sarama.ProducerTxnFlagPreparedTransaction
+	return sarama.PreparedTxnState{}, nil
+}
+
+func (mp *AsyncProducer) CompleteTxn(state sarama.PreparedTxnState) error {
+	mp.txnLock.Lock()
+	defer mp.txnLock.Unlock()
+
+	mp.txnStatus = sarama.ProducerTxnFlagReady
+	return nil
+}
+
+func (mp *AsyncProducer) TxnState() sarama.TxnState {
+	mp.txnLock.Lock()
+	defer mp.txnLock.Unlock()
+
+	return sarama.TxnState{Status: mp.txnStatus}
+}
+
+// TxnEvents returns nil: the mock does not send transaction events.
+func (mp *AsyncProducer) TxnEvents() <-chan *sarama.TxnEvent {
+	return nil
+}
+
 func (mp *AsyncProducer) AddOffsetsToTxn(offsets map[string][]*sarama.PartitionOffsetMetadata, groupId string) error {
 	return nil
 }
@@ -270,3 +343,40 @@ func (mp *AsyncProducer) ExpectInputAndFail(err error) *AsyncProducer {
 
 	return mp
 }
+
+// ExpectInputMatchingAndSucceed sets an expectation on the mock producer that a message matching
+// m will be provided on the input channel. As expectations are consumed in order, a sequence of
+// them also asserts the order in which messages are produced. If the message does not match, the
+// differences are reported to the test state and the message is handled as ExpectInputAndSucceed.
+func (mp *AsyncProducer) ExpectInputMatchingAndSucceed(m *MessageMatcher) *AsyncProducer {
+	mp.ExpectInputWithMessageCheckerFunctionAndSucceed(m.Check)
+
+	return mp
+}
+
+// ExpectInputMatchingAndFail sets an expectation on the mock producer that a message matching
+// m will be provided on the input channel, and handles it as ExpectInputAndFail.
+func (mp *AsyncProducer) ExpectInputMatchingAndFail(m *MessageMatcher, err error) *AsyncProducer {
+	mp.ExpectInputWithMessageCheckerFunctionAndFail(m.Check, err)
+
+	return mp
+}
+
+// ExpectTopicCount sets an expectation on the mock producer that exactly count messages will be
+// provided on the input channel for topic. It is verified when the producer is closed.
+func (mp *AsyncProducer) ExpectTopicCount(topic string, count int) *AsyncProducer {
+	mp.l.Lock()
+	defer mp.l.Unlock()
+	mp.produced.expectCount(topic, count)
+
+	return mp
+}
+
+// Messages returns the last 1000 messages provided on the input channel, in the order the mock
+// producer handled them.
+func (mp *AsyncProducer) Messages() []*sarama.ProducerMessage {
+	mp.l.Lock()
+	defer mp.l.Unlock()
+
+	return mp.produced.last()
+}
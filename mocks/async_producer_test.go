@@ -1,6 +1,7 @@
 package mocks
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"regexp"
@@ -40,6 +41,12 @@ func TestMockAsyncProducerImplementsAsyncProducerInterface(t *testing.T) {
 	if _, ok := mp.(sarama.AsyncProducer); !ok {
 		t.Error("The mock producer should implement the sarama.Producer interface.")
 	}
+	if _, ok := mp.(sarama.TxnStateReporter); !ok {
+		t.Error("The mock producer should implement the sarama.TxnStateReporter interface.")
+	}
+	if _, ok := mp.(sarama.TwoPhaseCommitter); !ok {
+		t.Error("The mock producer should implement the sarama.TwoPhaseCommitter interface.")
+	}
 }
 
 func TestProducerReturnsExpectationsToChannels(t *testing.T) {
@@ -108,6 +115,24 @@ func TestProducerWithTooManyExpectations(t *testing.T) {
 	}
 }
 
+func TestProducerSendAfterClose(t *testing.T) {
+	mp := NewAsyncProducer(t, nil).ExpectInputAndSucceed()
+
+	if err := mp.TrySend(&sarama.ProducerMessage{Topic: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := mp.Close(); err != nil {
+		t.Error(err)
+	}
+
+	if err := mp.TrySend(&sarama.ProducerMessage{Topic: "test"}); !errors.Is(err, sarama.ErrShuttingDown) {
+		t.Errorf("Expected %v once closed, got %v", sarama.ErrShuttingDown, err)
+	}
+	if err := mp.SendWithContext(context.Background(), &sarama.ProducerMessage{Topic: "test"}); !errors.Is(err, sarama.ErrShuttingDown) {
+		t.Errorf("Expected %v once closed, got %v", sarama.ErrShuttingDown, err)
+	}
+}
+
 func TestProducerFailTxn(t *testing.T) {
 	config := NewTestConfig()
 	config.Producer.Transaction.ID = "test"
@@ -262,3 +287,36 @@ func TestProducerWithInvalidConfiguration(t *testing.T) {
 		t.Errorf("Unexpected error: %s", trm.errors[0])
 	}
 }
+
+func TestProducerWithMessageMatchers(t *testing.T) {
+	trm := newTestReporterMock()
+	config := NewTestConfig()
+	config.Producer.Partitioner = sarama.NewManualPartitioner
+	mp := NewAsyncProducer(trm, config).
+		ExpectInputMatchingAndSucceed(MatchMessage().Topic("a").Key("1").Partition(2)).
+		ExpectInputMatchingAndSucceed(MatchMessage().Topic("b").Header("trace", "abc")).
+		ExpectInputMatchingAndSucceed(MatchMessage().Topic("a").Key("2")).
+		ExpectTopicCount("a", 2).
+		ExpectTopicCount("b", 2)
+
+	mp.Input() <- &sarama.ProducerMessage{Topic: "a", Key: sarama.StringEncoder("1"), Partition: 2}
+	mp.Input() <- &sarama.ProducerMessage{Topic: "b", Headers: []sarama.RecordHeader{{Key: []byte("trace"), Value: []byte("abc")}}}
+	// Out of order.
+	mp.Input() <- &sarama.ProducerMessage{Topic: "b"}
+	if err := mp.Close(); err != nil {
+		t.Error(err)
+	}
+
+	if len(trm.errors) != 2 {
+		t.Fatal("Expected a mismatch and a wrong count, got", trm.errors)
+	}
+	if !strings.Contains(trm.errors[0], `topic: expected "a", got "b"`) {
+		t.Error("Expected the mismatch to be reported, got", trm.errors[0])
+	}
+	if trm.errors[1] != "Expected 2 messages to be produced to a, but got 1." {
+		t.Error("Expected the count of topic a to be reported, got", trm.errors[1])
+	}
+	if messages := mp.Messages(); len(messages) != 3 || messages[2].Topic != "b" {
+		t.Error("Expected the messages to be recorded in order, got", messages)
+	}
+}
@@ -67,6 +67,12 @@ func (c *Consumer) ConsumePartition(topic string, partition int32, offset int64)
 	return pc, nil
 }
 
+// ConsumeTopicPartition implements the ConsumeTopicPartition method from the
+// sarama.TopicPartitionConsumer interface, as ConsumePartition.
+func (c *Consumer) ConsumeTopicPartition(tp sarama.TopicPartitionID, offset int64) (sarama.PartitionConsumer, error) {
+	return c.ConsumePartition(tp.Topic, tp.Partition, offset)
+}
+
 // Topics returns a list of topics, as registered with SetTopicMetadata
 func (c *Consumer) Topics() ([]string, error) {
 	c.l.Lock()
@@ -163,6 +169,16 @@ func (c *Consumer) Resume(topicPartitions map[string][]int32) {
 	}
 }
 
+// PausePartitions implements TopicPartitionPauser.
+func (c *Consumer) PausePartitions(partitions []sarama.TopicPartitionID) {
+	c.Pause(sarama.TopicPartitionMap(partitions))
+}
+
+// ResumePartitions implements TopicPartitionPauser.
+func (c *Consumer) ResumePartitions(partitions []sarama.TopicPartitionID) {
+	c.Resume(sarama.TopicPartitionMap(partitions))
+}
+
 // PauseAll implements Consumer.
 func (c *Consumer) PauseAll() {
 	c.l.Lock()
@@ -229,6 +245,7 @@ func (c *Consumer) ExpectConsumePartition(topic string, partition int32, offset
 			messages:            make(chan *sarama.ConsumerMessage, c.config.ChannelBufferSize),
 			suppressedMessages:  make(chan *sarama.ConsumerMessage, c.config.ChannelBufferSize),
 			errors:              make(chan *sarama.ConsumerError, c.config.ChannelBufferSize),
+			watermarks:          make(chan *sarama.WatermarkEvent, c.config.ChannelBufferSize),
 		}
 	}
 
@@ -255,6 +272,7 @@ type PartitionConsumer struct {
 	suppressedMessages            chan *sarama.ConsumerMessage
 	suppressedHighWaterMarkOffset int64
 	errors                        chan *sarama.ConsumerError
+	watermarks                    chan *sarama.WatermarkEvent
 	singleClose                   sync.Once
 	consumed                      bool
 	errorsShouldBeDrained         bool
@@ -272,6 +290,7 @@ func (pc *PartitionConsumer) AsyncClose() {
 		close(pc.suppressedMessages)
 		close(pc.messages)
 		close(pc.errors)
+		close(pc.watermarks)
 	})
 }
 
@@ -342,6 +361,12 @@ func (pc *PartitionConsumer) Messages() <-chan *sarama.ConsumerMessage {
 	return pc.messages
 }
 
+// Watermarks implements the Watermarks method from the sarama.WatermarkReporter interface.
+// It provides the events yielded using YieldWatermarks.
+func (pc *PartitionConsumer) Watermarks() <-chan *sarama.WatermarkEvent {
+	return pc.watermarks
+}
+
 func (pc *PartitionConsumer) HighWaterMarkOffset() int64 {
 	return atomic.LoadInt64(&pc.highWaterMarkOffset)
 }
@@ -382,6 +407,19 @@ func (pc *PartitionConsumer) IsPaused() bool {
 // Expectation API
 ///////////////////////////////////////////////////
 
+// YieldWatermarks will yield an event on the Watermarks channel of this partition
+// consumer, for its topic and partition.
+func (pc *PartitionConsumer) YieldWatermarks(event *sarama.WatermarkEvent) *PartitionConsumer {
+	pc.l.Lock()
+	defer pc.l.Unlock()
+
+	event.Topic = pc.topic
+	event.Partition = pc.partition
+	pc.watermarks <- event
+
+	return pc
+}
+
 // YieldMessage will yield a messages Messages channel of this partition consumer
 // when it is consumed. By default, the mock consumer will not verify whether this
 // message was consumed from the Messages channel, because there are legitimate
@@ -0,0 +1,392 @@
+package mocks
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"github.com/IBM/sarama"
+)
+
+// ConsumerGroupSession implements sarama's ConsumerGroupSession interface for
+// testing purposes. It runs a ConsumerGroupHandler against the claims
+// registered using ExpectClaim, feeding it the messages yielded on them, and
+// records the offsets the handler marks and commits.
+//
+// Rebalance and Cancel end the session the way a rebalance and the
+// cancellation of the context passed to ConsumerGroup.Consume do.
+type ConsumerGroupSession struct {
+	l            sync.Mutex
+	t            ErrorReporter
+	config       *sarama.Config
+	memberID     string
+	generationID int32
+	ctx          context.Context
+	cancel       context.CancelFunc
+	claims       map[string]map[int32]*ConsumerGroupClaim
+	offsets      map[string]map[int32]MarkedOffset
+	marks        []MarkedOffset
+	commits      int
+}
+
+// MarkedOffset is an offset marked, or reset, by a ConsumerGroupHandler.
+type MarkedOffset struct {
+	Topic     string
+	Partition int32
+	Offset    int64
+	Metadata  string
+	// Reset is set for the offsets passed to ResetOffset.
+	Reset bool
+}
+
+// NewConsumerGroupSession returns a new mock ConsumerGroupSession instance.
+// The t argument should be the *testing.T instance of your test method. An
+// error will be written to it if an expectation is violated. The config
+// argument can be set to nil; if it is non-nil it is validated.
+func NewConsumerGroupSession(t ErrorReporter, config *sarama.Config) *ConsumerGroupSession {
+	if config == nil {
+		config = sarama.NewConfig()
+	}
+	if err := config.Validate(); err != nil {
+		t.Errorf("Invalid mock configuration provided: %s", err.Error())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &ConsumerGroupSession{
+		t:            t,
+		config:       config,
+		memberID:     "mock-member",
+		generationID: 1,
+		ctx:          ctx,
+		cancel:       cancel,
+		claims:       make(map[string]map[int32]*ConsumerGroupClaim),
+		offsets:      make(map[string]map[int32]MarkedOffset),
+	}
+}
+
+///////////////////////////////////////////////////
+// ConsumerGroupSession interface implementation
+///////////////////////////////////////////////////
+
+// Claims implements the Claims method from the sarama.ConsumerGroupSession interface.
+func (s *ConsumerGroupSession) Claims() map[string][]int32 {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	claims := make(map[string][]int32, len(s.claims))
+	for topic, partitions := range s.claims {
+		for partition := range partitions {
+			claims[topic] = append(claims[topic], partition)
+		}
+	}
+	return claims
+}
+
+// ClaimedPartitions implements the ClaimedPartitions method from the sarama.ClaimedPartitionLister interface.
+func (s *ConsumerGroupSession) ClaimedPartitions() []sarama.TopicPartitionID {
+	return sarama.TopicPartitionIDs(s.Claims())
+}
+
+// MemberID implements the MemberID method from the sarama.ConsumerGroupSession interface.
+func (s *ConsumerGroupSession) MemberID() string {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	return s.memberID
+}
+
+// GenerationID implements the GenerationID method from the sarama.ConsumerGroupSession interface.
+func (s *ConsumerGroupSession) GenerationID() int32 {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	return s.generationID
+}
+
+// MarkOffset implements the MarkOffset method from the sarama.ConsumerGroupSession interface.
+// Like sarama, it ignores the offsets lower than the one already marked.
+func (s *ConsumerGroupSession) MarkOffset(topic string, partition int32, offset int64, metadata string) {
+	s.mark(MarkedOffset{Topic: topic, Partition: partition, Offset: offset, Metadata: metadata})
+}
+
+// ResetOffset implements the ResetOffset method from the sarama.ConsumerGroupSession interface.
+func (s *ConsumerGroupSession) ResetOffset(topic string, partition int32, offset int64, metadata string) {
+	s.mark(MarkedOffset{Topic: topic, Partition: partition, Offset: offset, Metadata: metadata, Reset: true})
+}
+
+// MarkMessage implements the MarkMessage method from the sarama.ConsumerGroupSession interface.
+func (s *ConsumerGroupSession) MarkMessage(msg *sarama.ConsumerMessage, metadata string) {
+	s.MarkOffset(msg.Topic, msg.Partition, msg.Offset+1, metadata)
+}
+
+// Commit implements the Commit method from the sarama.ConsumerGroupSession interface.
+func (s *ConsumerGroupSession) Commit() {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	s.commits++
+}
+
+// Context implements the Context method from the sarama.ConsumerGroupSession interface.
+func (s *ConsumerGroupSession) Context() context.Context {
+	return s.ctx
+}
+
+func (s *ConsumerGroupSession) mark(marked MarkedOffset) {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	if s.claims[marked.Topic][marked.Partition] == nil {
+		s.t.Errorf("Offset %d marked for %s/%d, which is not claimed by the session.", marked.Offset, marked.Topic, marked.Partition)
+		return
+	}
+	s.marks = append(s.marks, marked)
+
+	if s.offsets[marked.Topic] == nil {
+		s.offsets[marked.Topic] = make(map[int32]MarkedOffset)
+	}
+	if current, ok := s.offsets[marked.Topic][marked.Partition]; ok && !marked.Reset && marked.Offset < current.Offset {
+		return
+	}
+	s.offsets[marked.Topic][marked.Partition] = marked
+}
+
+///////////////////////////////////////////////////
+// Harness API
+///////////////////////////////////////////////////
+
+// SetMemberID sets the member ID returned by MemberID.
+func (s *ConsumerGroupSession) SetMemberID(memberID string) *ConsumerGroupSession {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	s.memberID = memberID
+	return s
+}
+
+// SetGenerationID sets the generation ID returned by GenerationID.
+func (s *ConsumerGroupSession) SetGenerationID(generationID int32) *ConsumerGroupSession {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	s.generationID = generationID
+	return s
+}
+
+// ExpectClaim registers a claim of the session starting at initialOffset, on
+// which you can yield messages. The registered ConsumerGroupClaim is returned,
+// so you can yield messages using method chaining.
+func (s *ConsumerGroupSession) ExpectClaim(topic string, partition int32, initialOffset int64) *ConsumerGroupClaim {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	if s.claims[topic] == nil {
+		s.claims[topic] = make(map[int32]*ConsumerGroupClaim)
+	}
+	if s.claims[topic][partition] == nil {
+		highWaterMarkOffset := initialOffset
+		if initialOffset < 0 {
+			highWaterMarkOffset = 0
+		}
+		s.claims[topic][partition] = &ConsumerGroupClaim{
+			highWaterMarkOffset: highWaterMarkOffset,
+			t:                   s.t,
+			topic:               topic,
+			partition:           partition,
+			initialOffset:       initialOffset,
+			messages:            make(chan *sarama.ConsumerMessage, s.config.ChannelBufferSize),
+			watermarks:          make(chan *sarama.WatermarkEvent, s.config.ChannelBufferSize),
+		}
+	}
+	return s.claims[topic][partition]
+}
+
+// Run runs handler for the session like sarama does: it calls Setup, then
+// ConsumeClaim for each claim in its own goroutine, and Cleanup once they all
+// returned. As sarama, it ends the session when the first ConsumeClaim
+// returns. It returns the first error of the handler, which sarama reports on
+// the Errors channel of the group instead.
+//
+// ConsumeClaim only returns once the Messages channel of its claim is
+// closed, by CloseClaims or Rebalance, unless the handler watches the session
+// context.
+func (s *ConsumerGroupSession) Run(handler sarama.ConsumerGroupHandler) error {
+	if err := handler.Setup(s); err != nil {
+		return err
+	}
+
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+	s.l.Lock()
+	for _, partitions := range s.claims {
+		for _, claim := range partitions {
+			wg.Add(1)
+			go func(claim *ConsumerGroupClaim) {
+				defer wg.Done()
+				// As sarama, end the session as soon as one of the claims
+				// is done.
+				defer s.Rebalance()
+				if err := handler.ConsumeClaim(s, claim); err != nil {
+					errOnce.Do(func() { firstErr = err })
+				}
+			}(claim)
+		}
+	}
+	s.l.Unlock()
+	wg.Wait()
+
+	if err := handler.Cleanup(s); err != nil && firstErr == nil {
+		firstErr = err
+	}
+	return firstErr
+}
+
+// CloseClaims closes the Messages channels of the claims once the messages
+// yielded so far are consumed, ending ConsumeClaim without cancelling the
+// session context.
+func (s *ConsumerGroupSession) CloseClaims() {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	for _, partitions := range s.claims {
+		for _, claim := range partitions {
+			claim.close()
+		}
+	}
+}
+
+// Rebalance ends the session as a rebalance does: it cancels the session
+// context and closes the Messages channels of the claims.
+func (s *ConsumerGroupSession) Rebalance() {
+	s.cancel()
+	s.CloseClaims()
+}
+
+// Cancel cancels the session context, as when the context passed to
+// ConsumerGroup.Consume is cancelled, without closing the claims.
+func (s *ConsumerGroupSession) Cancel() {
+	s.cancel()
+}
+
+// MarkedOffset returns the offset of a partition marked by the handler, and
+// whether there is one.
+func (s *ConsumerGroupSession) MarkedOffset(topic string, partition int32) (MarkedOffset, bool) {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	marked, ok := s.offsets[topic][partition]
+	return marked, ok
+}
+
+// Marks returns the offsets marked and reset by the handler, in order.
+func (s *ConsumerGroupSession) Marks() []MarkedOffset {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	return append([]MarkedOffset(nil), s.marks...)
+}
+
+// Commits returns the number of calls to Commit.
+func (s *ConsumerGroupSession) Commits() int {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	return s.commits
+}
+
+///////////////////////////////////////////////////
+// ConsumerGroupClaim mock type
+///////////////////////////////////////////////////
+
+// ConsumerGroupClaim implements sarama's ConsumerGroupClaim interface for
+// testing purposes. It is registered using the ExpectClaim method of a
+// ConsumerGroupSession, and provides the messages yielded using YieldMessage.
+type ConsumerGroupClaim struct {
+	highWaterMarkOffset int64 // must be at the top of the struct because https://golang.org/pkg/sync/atomic/#pkg-note-BUG
+	l                   sync.Mutex
+	t                   ErrorReporter
+	topic               string
+	partition           int32
+	initialOffset       int64
+	messages            chan *sarama.ConsumerMessage
+	watermarks          chan *sarama.WatermarkEvent
+	closed              bool
+}
+
+// Topic implements the Topic method from the sarama.ConsumerGroupClaim interface.
+func (c *ConsumerGroupClaim) Topic() string {
+	return c.topic
+}
+
+// Partition implements the Partition method from the sarama.ConsumerGroupClaim interface.
+func (c *ConsumerGroupClaim) Partition() int32 {
+	return c.partition
+}
+
+// InitialOffset implements the InitialOffset method from the sarama.ConsumerGroupClaim interface.
+func (c *ConsumerGroupClaim) InitialOffset() int64 {
+	return c.initialOffset
+}
+
+// HighWaterMarkOffset implements the HighWaterMarkOffset method from the sarama.ConsumerGroupClaim interface.
+func (c *ConsumerGroupClaim) HighWaterMarkOffset() int64 {
+	return atomic.LoadInt64(&c.highWaterMarkOffset)
+}
+
+// Watermarks implements the Watermarks method from the sarama.WatermarkReporter interface.
+// It provides the events yielded using YieldWatermarks.
+func (c *ConsumerGroupClaim) Watermarks() <-chan *sarama.WatermarkEvent {
+	return c.watermarks
+}
+
+// Messages implements the Messages method from the sarama.ConsumerGroupClaim interface.
+func (c *ConsumerGroupClaim) Messages() <-chan *sarama.ConsumerMessage {
+	return c.messages
+}
+
+// YieldMessage will yield a message on the Messages channel of this claim,
+// with the next offset of the partition.
+func (c *ConsumerGroupClaim) YieldMessage(msg *sarama.ConsumerMessage) *ConsumerGroupClaim {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	if c.closed {
+		c.t.Errorf("Message yielded on %s/%d after the claim was closed.", c.topic, c.partition)
+		return c
+	}
+	msg.Topic = c.topic
+	msg.Partition = c.partition
+	msg.Offset = atomic.AddInt64(&c.highWaterMarkOffset, 1) - 1
+	c.messages <- msg
+	return c
+}
+
+// YieldWatermarks will yield an event on the Watermarks channel of this claim,
+// for its topic and partition.
+func (c *ConsumerGroupClaim) YieldWatermarks(event *sarama.WatermarkEvent) *ConsumerGroupClaim {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	if c.closed {
+		c.t.Errorf("Watermarks yielded on %s/%d after the claim was closed.", c.topic, c.partition)
+		return c
+	}
+	event.Topic = c.topic
+	event.Partition = c.partition
+	c.watermarks <- event
+	return c
+}
+
+func (c *ConsumerGroupClaim) close() {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	if !c.closed {
+		c.closed = true
+		close(c.messages)
+		close(c.watermarks)
+	}
+}
@@ -0,0 +1,135 @@
+package mocks
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/IBM/sarama"
+)
+
+type markingHandler struct {
+	setup, cleanup int
+	consumed       []string
+	failOn         string
+}
+
+func (h *markingHandler) Setup(sarama.ConsumerGroupSession) error {
+	h.setup++
+	return nil
+}
+
+func (h *markingHandler) Cleanup(sarama.ConsumerGroupSession) error {
+	h.cleanup++
+	return nil
+}
+
+func (h *markingHandler) ConsumeClaim(sess sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	for msg := range claim.Messages() {
+		if string(msg.Value) == h.failOn {
+			return errors.New("failed to process " + h.failOn)
+		}
+		h.consumed = append(h.consumed, string(msg.Value))
+		sess.MarkMessage(msg, "")
+	}
+	sess.Commit()
+	return nil
+}
+
+func TestMockConsumerGroupSessionImplementsInterfaces(t *testing.T) {
+	var s interface{} = &ConsumerGroupSession{}
+	if _, ok := s.(sarama.ConsumerGroupSession); !ok {
+		t.Error("The mock session should implement the sarama.ConsumerGroupSession interface.")
+	}
+	if _, ok := s.(sarama.ClaimedPartitionLister); !ok {
+		t.Error("The mock session should implement the sarama.ClaimedPartitionLister interface.")
+	}
+
+	var c interface{} = &ConsumerGroupClaim{}
+	if _, ok := c.(sarama.ConsumerGroupClaim); !ok {
+		t.Error("The mock claim should implement the sarama.ConsumerGroupClaim interface.")
+	}
+	if _, ok := c.(sarama.WatermarkReporter); !ok {
+		t.Error("The mock claim should implement the sarama.WatermarkReporter interface.")
+	}
+}
+
+func TestConsumerGroupSessionRunsHandler(t *testing.T) {
+	session := NewConsumerGroupSession(t, NewTestConfig())
+	session.ExpectClaim("test", 0, 10).
+		YieldMessage(&sarama.ConsumerMessage{Value: []byte("a")}).
+		YieldMessage(&sarama.ConsumerMessage{Value: []byte("b")})
+	session.CloseClaims()
+
+	handler := &markingHandler{}
+	if err := session.Run(handler); err != nil {
+		t.Fatal(err)
+	}
+	if handler.setup != 1 || handler.cleanup != 1 {
+		t.Errorf("Expected Setup and Cleanup to be called once, got %d and %d", handler.setup, handler.cleanup)
+	}
+	if len(handler.consumed) != 2 {
+		t.Error("Expected 2 messages to be consumed, got", handler.consumed)
+	}
+
+	marked, ok := session.MarkedOffset("test", 0)
+	if !ok || marked.Offset != 12 {
+		t.Error("Expected offset 12 to be marked, got", marked)
+	}
+	if marks := session.Marks(); len(marks) != 2 || marks[0].Offset != 11 {
+		t.Error("Expected offsets 11 and 12 to be marked, got", marks)
+	}
+	if session.Commits() != 1 {
+		t.Error("Expected 1 commit, got", session.Commits())
+	}
+	if session.Context().Err() == nil {
+		t.Error("Expected the session to end with the claims")
+	}
+}
+
+func TestConsumerGroupSessionReturnsHandlerErrors(t *testing.T) {
+	session := NewConsumerGroupSession(t, NewTestConfig())
+	session.ExpectClaim("test", 0, sarama.OffsetOldest).
+		YieldMessage(&sarama.ConsumerMessage{Value: []byte("a")}).
+		YieldMessage(&sarama.ConsumerMessage{Value: []byte("poison")})
+	session.ExpectClaim("test", 1, sarama.OffsetOldest)
+
+	// The second claim is only closed by the end of the session, which the
+	// failure of the first one triggers.
+	handler := &markingHandler{failOn: "poison"}
+	if err := session.Run(handler); err == nil {
+		t.Error("Expected the error of the handler")
+	}
+	if marked, _ := session.MarkedOffset("test", 0); marked.Offset != 1 {
+		t.Error("Expected the offset of the first message to be marked, got", marked)
+	}
+	if handler.cleanup != 1 {
+		t.Error("Expected Cleanup to be called after the error")
+	}
+}
+
+func TestConsumerGroupSessionOffsets(t *testing.T) {
+	trm := newTestReporterMock()
+	session := NewConsumerGroupSession(trm, NewTestConfig())
+	session.ExpectClaim("test", 0, 0)
+
+	session.MarkOffset("test", 0, 5, "five")
+	session.MarkOffset("test", 0, 3, "three")
+	if marked, _ := session.MarkedOffset("test", 0); marked.Offset != 5 || marked.Metadata != "five" {
+		t.Error("Expected lower offsets to be ignored, got", marked)
+	}
+	session.ResetOffset("test", 0, 2, "two")
+	if marked, _ := session.MarkedOffset("test", 0); marked.Offset != 2 || !marked.Reset {
+		t.Error("Expected the offset to be reset, got", marked)
+	}
+
+	session.MarkOffset("other", 0, 1, "")
+	if len(trm.errors) != 1 {
+		t.Error("Expected marking an unclaimed partition to fail, got", trm.errors)
+	}
+
+	session.Rebalance()
+	session.ExpectClaim("test", 0, 0).YieldMessage(&sarama.ConsumerMessage{})
+	if len(trm.errors) != 2 {
+		t.Error("Expected yielding on a closed claim to fail, got", trm.errors)
+	}
+}
@@ -14,11 +14,20 @@ func TestMockConsumerImplementsConsumerInterface(t *testing.T) {
 	if _, ok := c.(sarama.Consumer); !ok {
 		t.Error("The mock consumer should implement the sarama.Consumer interface.")
 	}
+	if _, ok := c.(sarama.TopicPartitionConsumer); !ok {
+		t.Error("The mock consumer should implement the sarama.TopicPartitionConsumer interface.")
+	}
+	if _, ok := c.(sarama.TopicPartitionPauser); !ok {
+		t.Error("The mock consumer should implement the sarama.TopicPartitionPauser interface.")
+	}
 
 	var pc interface{} = &PartitionConsumer{}
 	if _, ok := pc.(sarama.PartitionConsumer); !ok {
 		t.Error("The mock partitionconsumer should implement the sarama.PartitionConsumer interface.")
 	}
+	if _, ok := pc.(sarama.WatermarkReporter); !ok {
+		t.Error("The mock partitionconsumer should implement the sarama.WatermarkReporter interface.")
+	}
 }
 
 func TestConsumerHandlesExpectations(t *testing.T) {
@@ -0,0 +1,219 @@
+package mocks
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/IBM/sarama"
+)
+
+// MessageMatcher describes the message expected by an expectation of the
+// producer mocks. Only the fields set on it are checked, and its Check method,
+// which is a MessageChecker, reports all the fields that differ at once:
+//
+//	producer.ExpectInputWithMessageCheckerFunctionAndSucceed(
+//		mocks.MatchMessage().Topic("orders").Key("42").Header("trace", "abc").Check)
+//
+// The ExpectInputMatching and ExpectSendMessageMatching methods of the
+// producer mocks are shorthands for this.
+type MessageMatcher struct {
+	topic     *string
+	key       *[]byte
+	value     *[]byte
+	partition *int32
+	metadata  *interface{}
+	headers   []sarama.RecordHeader
+}
+
+// MatchMessage returns a MessageMatcher matching any message.
+func MatchMessage() *MessageMatcher {
+	return &MessageMatcher{}
+}
+
+// Topic sets the expected topic of the message.
+func (m *MessageMatcher) Topic(topic string) *MessageMatcher {
+	m.topic = &topic
+	return m
+}
+
+// Key sets the expected encoded key of the message.
+func (m *MessageMatcher) Key(key string) *MessageMatcher {
+	return m.KeyBytes([]byte(key))
+}
+
+// KeyBytes sets the expected encoded key of the message. A nil key matches
+// the messages without key.
+func (m *MessageMatcher) KeyBytes(key []byte) *MessageMatcher {
+	m.key = &key
+	return m
+}
+
+// Value sets the expected encoded value of the message.
+func (m *MessageMatcher) Value(value string) *MessageMatcher {
+	return m.ValueBytes([]byte(value))
+}
+
+// ValueBytes sets the expected encoded value of the message. A nil value
+// matches the messages without value, i.e. tombstones.
+func (m *MessageMatcher) ValueBytes(value []byte) *MessageMatcher {
+	m.value = &value
+	return m
+}
+
+// Partition sets the expected partition of the message, as assigned by the
+// partitioner of the mock producer.
+func (m *MessageMatcher) Partition(partition int32) *MessageMatcher {
+	m.partition = &partition
+	return m
+}
+
+// Metadata sets the expected metadata of the message, compared using
+// reflect.DeepEqual.
+func (m *MessageMatcher) Metadata(metadata interface{}) *MessageMatcher {
+	m.metadata = &metadata
+	return m
+}
+
+// Header adds a header the message is expected to have. The message may
+// have other headers.
+func (m *MessageMatcher) Header(key, value string) *MessageMatcher {
+	m.headers = append(m.headers, sarama.RecordHeader{Key: []byte(key), Value: []byte(value)})
+	return m
+}
+
+// Check is a MessageChecker returning an error listing the expected and
+// actual values of all the fields of msg that do not match.
+func (m *MessageMatcher) Check(msg *sarama.ProducerMessage) error {
+	var diff []string
+	mismatch := func(field string, expected, actual interface{}) {
+		diff = append(diff, fmt.Sprintf("%s: expected %v, got %v", field, expected, actual))
+	}
+
+	if m.topic != nil && *m.topic != msg.Topic {
+		mismatch("topic", quote(*m.topic), quote(msg.Topic))
+	}
+	if m.partition != nil && *m.partition != msg.Partition {
+		mismatch("partition", *m.partition, msg.Partition)
+	}
+	if m.key != nil {
+		key, err := encodeOrNil(msg.Key)
+		if err != nil {
+			return fmt.Errorf("Input message key encoding failed: %w", err)
+		}
+		if !bytesMatch(*m.key, key) {
+			mismatch("key", quoteBytes(*m.key), quoteBytes(key))
+		}
+	}
+	if m.value != nil {
+		value, err := encodeOrNil(msg.Value)
+		if err != nil {
+			return fmt.Errorf("Input message encoding failed: %w", err)
+		}
+		if !bytesMatch(*m.value, value) {
+			mismatch("value", quoteBytes(*m.value), quoteBytes(value))
+		}
+	}
+	for _, expected := range m.headers {
+		field := "header " + quote(string(expected.Key))
+		actual, ok := findHeader(msg.Headers, expected.Key)
+		switch {
+		case !ok:
+			mismatch(field, quoteBytes(expected.Value), "none")
+		case !bytes.Equal(expected.Value, actual.Value):
+			mismatch(field, quoteBytes(expected.Value), quoteBytes(actual.Value))
+		}
+	}
+	if m.metadata != nil && !reflect.DeepEqual(*m.metadata, msg.Metadata) {
+		mismatch("metadata", fmt.Sprintf("%#v", *m.metadata), fmt.Sprintf("%#v", msg.Metadata))
+	}
+
+	if len(diff) == 0 {
+		return nil
+	}
+	return fmt.Errorf("message to %s/%d does not match:\n\t%s", msg.Topic, msg.Partition, strings.Join(diff, "\n\t"))
+}
+
+func encodeOrNil(e sarama.Encoder) ([]byte, error) {
+	if e == nil {
+		return nil, nil
+	}
+	return e.Encode()
+}
+
+func bytesMatch(expected, actual []byte) bool {
+	if expected == nil || actual == nil {
+		return expected == nil && actual == nil
+	}
+	return bytes.Equal(expected, actual)
+}
+
+func findHeader(headers []sarama.RecordHeader, key []byte) (sarama.RecordHeader, bool) {
+	for _, h := range headers {
+		if bytes.Equal(h.Key, key) {
+			return h, true
+		}
+	}
+	return sarama.RecordHeader{}, false
+}
+
+func quote(s string) string {
+	return fmt.Sprintf("%q", s)
+}
+
+func quoteBytes(b []byte) string {
+	if b == nil {
+		return "nil"
+	}
+	return quote(string(b))
+}
+
+// maxProducedMessages is the number of messages a mock producer keeps for
+// its Messages method. The older ones are dropped, so that long running tests
+// do not keep every message in memory.
+const maxProducedMessages = 1000
+
+// producedMessages records the messages handled by a mock producer, to
+// verify the number of messages produced to each topic on close.
+type producedMessages struct {
+	messages       []*sarama.ProducerMessage // the last messages, up to twice maxProducedMessages
+	counts         map[string]int            // the number of messages by topic
+	expectedCounts map[string]int
+}
+
+func (p *producedMessages) record(msg *sarama.ProducerMessage) {
+	if p.counts == nil {
+		p.counts = make(map[string]int)
+	}
+	p.counts[msg.Topic]++
+
+	if len(p.messages) == 2*maxProducedMessages {
+		p.messages = append([]*sarama.ProducerMessage(nil), p.messages[maxProducedMessages:]...)
+	}
+	p.messages = append(p.messages, msg)
+}
+
+// last returns a copy of the last maxProducedMessages messages, in order.
+func (p *producedMessages) last() []*sarama.ProducerMessage {
+	messages := p.messages
+	if len(messages) > maxProducedMessages {
+		messages = messages[len(messages)-maxProducedMessages:]
+	}
+	return append([]*sarama.ProducerMessage(nil), messages...)
+}
+
+func (p *producedMessages) expectCount(topic string, count int) {
+	if p.expectedCounts == nil {
+		p.expectedCounts = make(map[string]int)
+	}
+	p.expectedCounts[topic] = count
+}
+
+func (p *producedMessages) verifyCounts(t ErrorReporter) {
+	for topic, expected := range p.expectedCounts {
+		if actual := p.counts[topic]; actual != expected {
+			t.Errorf("Expected %d messages to be produced to %s, but got %d.", expected, topic, actual)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package mocks
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/IBM/sarama"
+)
+
+func TestMessageMatcherMatches(t *testing.T) {
+	msg := &sarama.ProducerMessage{
+		Topic:     "orders",
+		Key:       sarama.StringEncoder("42"),
+		Value:     sarama.StringEncoder("created"),
+		Partition: 3,
+		Metadata:  map[string]int{"attempt": 1},
+		Headers: []sarama.RecordHeader{
+			{Key: []byte("trace"), Value: []byte("abc")},
+			{Key: []byte("source"), Value: []byte("api")},
+		},
+	}
+
+	m := MatchMessage().
+		Topic("orders").
+		Key("42").
+		Value("created").
+		Partition(3).
+		Metadata(map[string]int{"attempt": 1}).
+		Header("trace", "abc")
+	if err := m.Check(msg); err != nil {
+		t.Error("Expected the message to match, got", err)
+	}
+	if err := MatchMessage().Check(msg); err != nil {
+		t.Error("Expected an empty matcher to match any message, got", err)
+	}
+	if err := MatchMessage().KeyBytes(nil).Check(&sarama.ProducerMessage{Value: sarama.StringEncoder("")}); err != nil {
+		t.Error("Expected a nil key to match a message without key, got", err)
+	}
+}
+
+func TestMessageMatcherReportsAllDifferences(t *testing.T) {
+	msg := &sarama.ProducerMessage{
+		Topic:   "orders",
+		Key:     sarama.StringEncoder("41"),
+		Value:   sarama.StringEncoder("created"),
+		Headers: []sarama.RecordHeader{{Key: []byte("trace"), Value: []byte("xyz")}},
+	}
+
+	err := MatchMessage().
+		Topic("payments").
+		Key("42").
+		Value("created").
+		Header("trace", "abc").
+		Header("source", "api").
+		Check(msg)
+	if err == nil {
+		t.Fatal("Expected the message not to match")
+	}
+	for _, diff := range []string{
+		`topic: expected "payments", got "orders"`,
+		`key: expected "42", got "41"`,
+		`header "trace": expected "abc", got "xyz"`,
+		`header "source": expected "api", got none`,
+	} {
+		if !strings.Contains(err.Error(), diff) {
+			t.Errorf("Expected %q in the error, got %s", diff, err)
+		}
+	}
+	if strings.Contains(err.Error(), "value") {
+		t.Error("Expected the matching value not to be reported, got", err)
+	}
+}
+
+func TestProducedMessagesKeepsTheLastMessages(t *testing.T) {
+	var produced producedMessages
+	produced.expectCount("test", 3*maxProducedMessages)
+	for i := 0; i < 3*maxProducedMessages; i++ {
+		produced.record(&sarama.ProducerMessage{Topic: "test", Metadata: i})
+	}
+
+	if len(produced.messages) > 2*maxProducedMessages {
+		t.Errorf("Expected at most %d messages to be kept, got %d", 2*maxProducedMessages, len(produced.messages))
+	}
+	messages := produced.last()
+	if len(messages) != maxProducedMessages || messages[0].Metadata != 2*maxProducedMessages {
+		t.Errorf("Expected the last %d messages, got %d from %v", maxProducedMessages, len(messages), messages[0].Metadata)
+	}
+
+	trm := newTestReporterMock()
+	produced.verifyCounts(trm)
+	if len(trm.errors) != 0 {
+		t.Error("Expected the dropped messages to be counted, got", trm.errors)
+	}
+}
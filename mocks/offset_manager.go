@@ -0,0 +1,382 @@
+package mocks
+
+import (
+	"sync"
+
+	"github.com/IBM/sarama"
+)
+
+// OffsetManager implements sarama's OffsetManager interface for testing purposes.
+// Before you can manage the offsets of a partition, you have to register it using
+// ExpectManagePartition, and set expectations on the returned PartitionOffsetManager.
+// Commit and Close commit the offsets marked on the partition offset managers, which
+// can then be inspected using their CommittedOffset method.
+type OffsetManager struct {
+	l                 sync.Mutex
+	t                 ErrorReporter
+	config            *sarama.Config
+	partitionManagers map[string]map[int32]*PartitionOffsetManager
+	expectedCommits   int
+	commits           int
+	closed            bool
+	groupMetadata     sarama.ConsumerGroupMetadata
+}
+
+// NewOffsetManager returns a new mock OffsetManager instance. The t argument should
+// be the *testing.T instance of your test method. An error will be written to it if
+// an expectation is violated. The config argument can be set to nil; if it is
+// non-nil it is validated.
+func NewOffsetManager(t ErrorReporter, config *sarama.Config) *OffsetManager {
+	if config == nil {
+		config = sarama.NewConfig()
+	}
+	if err := config.Validate(); err != nil {
+		t.Errorf("Invalid mock configuration provided: %s", err.Error())
+	}
+
+	return &OffsetManager{
+		t:                 t,
+		config:            config,
+		partitionManagers: make(map[string]map[int32]*PartitionOffsetManager),
+		groupMetadata:     sarama.NewSimpleConsumerGroupMetadata(""),
+	}
+}
+
+///////////////////////////////////////////////////
+// OffsetManager interface implementation
+///////////////////////////////////////////////////
+
+// ManageTopicPartition implements the ManageTopicPartition method from the
+// sarama.TopicPartitionManager interface, as ManagePartition.
+func (om *OffsetManager) ManageTopicPartition(tp sarama.TopicPartitionID) (sarama.PartitionOffsetManager, error) {
+	return om.ManagePartition(tp.Topic, tp.Partition)
+}
+
+// ManagePartition implements the ManagePartition method from the sarama.OffsetManager interface.
+// Before you can manage a partition, you have to set expectations on it using
+// ExpectManagePartition. You can only manage a partition once per offset manager.
+func (om *OffsetManager) ManagePartition(topic string, partition int32) (sarama.PartitionOffsetManager, error) {
+	om.l.Lock()
+	defer om.l.Unlock()
+
+	if om.closed {
+		return nil, sarama.ErrClosedClient
+	}
+
+	pom := om.partitionManagers[topic][partition]
+	if pom == nil {
+		om.t.Errorf("No expectations set for %s/%d", topic, partition)
+		return nil, errOutOfExpectations
+	}
+	if pom.managed {
+		return nil, sarama.ConfigurationError("That topic/partition is already being managed")
+	}
+
+	pom.managed = true
+	return pom, nil
+}
+
+// Commit implements the Commit method from the sarama.OffsetManager interface. It commits
+// the offsets marked on the partition offset managers since the previous commit.
+func (om *OffsetManager) Commit() {
+	om.l.Lock()
+	defer om.l.Unlock()
+
+	om.commits++
+	if om.commits > om.expectedCommits {
+		om.t.Errorf("Unexpected call to Commit. Expected %d calls.", om.expectedCommits)
+	}
+	om.commitAll()
+}
+
+// Close implements the Close method from the sarama.OffsetManager interface. Like sarama,
+// it commits the offsets marked on the partition offset managers one last time. It will
+// verify whether the registered partitions were actually managed, and whether all the
+// expected commits happened.
+func (om *OffsetManager) Close() error {
+	om.l.Lock()
+	defer om.l.Unlock()
+
+	if om.closed {
+		return nil
+	}
+	om.closed = true
+
+	om.commitAll()
+	for _, partitions := range om.partitionManagers {
+		for _, pom := range partitions {
+			if !pom.managed {
+				om.t.Errorf("Expectations set on %s/%d, but no partition offset manager was started.", pom.topic, pom.partition)
+			}
+		}
+	}
+	if om.commits < om.expectedCommits {
+		om.t.Errorf("Expected %d calls to Commit, but got %d.", om.expectedCommits, om.commits)
+	}
+
+	return nil
+}
+
+func (om *OffsetManager) commitAll() {
+	for _, partitions := range om.partitionManagers {
+		for _, pom := range partitions {
+			pom.commit()
+		}
+	}
+}
+
+// GroupMetadata implements the GroupMetadata method from the sarama.OffsetManager interface.
+// It returns the membership last set using SetGroupMetadata, or the one of a simple consumer.
+func (om *OffsetManager) GroupMetadata() sarama.ConsumerGroupMetadata {
+	om.l.Lock()
+	defer om.l.Unlock()
+
+	return om.groupMetadata
+}
+
+// SetGroupMetadata implements the SetGroupMetadata method from the sarama.OffsetManager
+// interface. The mock has no group of its own, so it accepts any metadata.
+func (om *OffsetManager) SetGroupMetadata(metadata sarama.ConsumerGroupMetadata) error {
+	om.l.Lock()
+	defer om.l.Unlock()
+
+	om.groupMetadata = metadata
+	return nil
+}
+
+///////////////////////////////////////////////////
+// Expectation API
+///////////////////////////////////////////////////
+
+// ExpectManagePartition will register a topic/partition, so you can set expectations on it.
+// The offset and metadata are the ones committed for the partition, returned by NextOffset
+// until another offset is marked; use a negative offset if none was committed. The
+// registered PartitionOffsetManager will be returned, so you can set expectations on it
+// using method chaining. Once a topic/partition is registered, you are expected to manage
+// it using ManagePartition. If that doesn't happen, an error will be written to the error
+// reporter once the mock offset manager is closed.
+func (om *OffsetManager) ExpectManagePartition(topic string, partition int32, offset int64, metadata string) *PartitionOffsetManager {
+	om.l.Lock()
+	defer om.l.Unlock()
+
+	if om.partitionManagers[topic] == nil {
+		om.partitionManagers[topic] = make(map[int32]*PartitionOffsetManager)
+	}
+
+	if om.partitionManagers[topic][partition] == nil {
+		om.partitionManagers[topic][partition] = &PartitionOffsetManager{
+			t:                 om.t,
+			topic:             topic,
+			partition:         partition,
+			initialOffset:     om.config.Consumer.Offsets.Initial,
+			offset:            offset,
+			metadata:          metadata,
+			committedOffset:   offset,
+			committedMetadata: metadata,
+			errors:            make(chan *sarama.ConsumerError, om.config.ChannelBufferSize),
+		}
+	}
+
+	return om.partitionManagers[topic][partition]
+}
+
+// ExpectCommit sets an expectation on the offset manager that Commit will be called. Each
+// call to ExpectCommit expects one more call; calling Commit more often than expected, or
+// less often by the time the offset manager is closed, writes an error to the error reporter.
+func (om *OffsetManager) ExpectCommit() *OffsetManager {
+	om.l.Lock()
+	defer om.l.Unlock()
+
+	om.expectedCommits++
+	return om
+}
+
+///////////////////////////////////////////////////
+// PartitionOffsetManager mock type
+///////////////////////////////////////////////////
+
+// PartitionOffsetManager implements sarama's PartitionOffsetManager interface for testing
+// purposes. It is returned by the mock OffsetManager's ManagePartition method, but only if
+// it is registered first using the OffsetManager's ExpectManagePartition method. Every call
+// to MarkOffset and ResetOffset has to be expected, in order, using ExpectMarkOffset and
+// ExpectResetOffset.
+type PartitionOffsetManager struct {
+	l                 sync.Mutex
+	t                 ErrorReporter
+	topic             string
+	partition         int32
+	initialOffset     int64
+	offset            int64
+	metadata          string
+	committedOffset   int64
+	committedMetadata string
+	dirty             bool
+	expectations      []*offsetExpectation
+	managed           bool
+	errors            chan *sarama.ConsumerError
+	singleClose       sync.Once
+}
+
+type offsetExpectation struct {
+	offset   int64
+	metadata string
+	reset    bool
+}
+
+func (e *offsetExpectation) method() string {
+	if e.reset {
+		return "ResetOffset"
+	}
+	return "MarkOffset"
+}
+
+///////////////////////////////////////////////////
+// PartitionOffsetManager interface implementation
+///////////////////////////////////////////////////
+
+// NextOffset implements the NextOffset method from the sarama.PartitionOffsetManager interface.
+func (pom *PartitionOffsetManager) NextOffset() (int64, string) {
+	pom.l.Lock()
+	defer pom.l.Unlock()
+
+	if pom.offset >= 0 {
+		return pom.offset, pom.metadata
+	}
+	return pom.initialOffset, ""
+}
+
+// MarkOffset implements the MarkOffset method from the sarama.PartitionOffsetManager interface.
+// Like sarama, it ignores the offsets lower than the one already marked.
+func (pom *PartitionOffsetManager) MarkOffset(offset int64, metadata string) {
+	pom.l.Lock()
+	defer pom.l.Unlock()
+
+	pom.check(&offsetExpectation{offset: offset, metadata: metadata})
+	if offset > pom.offset {
+		pom.offset = offset
+		pom.metadata = metadata
+		pom.dirty = true
+	}
+}
+
+// ResetOffset implements the ResetOffset method from the sarama.PartitionOffsetManager interface.
+// Like sarama, it ignores the offsets higher than the one already marked.
+func (pom *PartitionOffsetManager) ResetOffset(offset int64, metadata string) {
+	pom.l.Lock()
+	defer pom.l.Unlock()
+
+	pom.check(&offsetExpectation{offset: offset, metadata: metadata, reset: true})
+	if offset <= pom.offset {
+		pom.offset = offset
+		pom.metadata = metadata
+		pom.dirty = true
+	}
+}
+
+// Errors implements the Errors method from the sarama.PartitionOffsetManager interface.
+func (pom *PartitionOffsetManager) Errors() <-chan *sarama.ConsumerError {
+	return pom.errors
+}
+
+// AsyncClose implements the AsyncClose method from the sarama.PartitionOffsetManager interface.
+func (pom *PartitionOffsetManager) AsyncClose() {
+	pom.singleClose.Do(func() {
+		close(pom.errors)
+	})
+}
+
+// Close implements the Close method from the sarama.PartitionOffsetManager interface. It will
+// verify whether all the expected calls to MarkOffset and ResetOffset happened, and return
+// the errors yielded using YieldError that were not consumed.
+func (pom *PartitionOffsetManager) Close() error {
+	pom.l.Lock()
+	for _, expectation := range pom.expectations {
+		pom.t.Errorf("Expected %s(%d, %q) on %s/%d, but it was not called.", expectation.method(), expectation.offset, expectation.metadata, pom.topic, pom.partition)
+	}
+	pom.expectations = nil
+	pom.l.Unlock()
+
+	pom.AsyncClose()
+
+	var errs sarama.ConsumerErrors
+	for err := range pom.errors {
+		errs = append(errs, err)
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func (pom *PartitionOffsetManager) check(actual *offsetExpectation) {
+	if len(pom.expectations) == 0 {
+		pom.t.Errorf("Unexpected call to %s(%d, %q) on %s/%d.", actual.method(), actual.offset, actual.metadata, pom.topic, pom.partition)
+		return
+	}
+
+	expected := pom.expectations[0]
+	pom.expectations = pom.expectations[1:]
+	if *expected != *actual {
+		pom.t.Errorf("Expected %s(%d, %q) on %s/%d, but got %s(%d, %q).",
+			expected.method(), expected.offset, expected.metadata, pom.topic, pom.partition,
+			actual.method(), actual.offset, actual.metadata)
+	}
+}
+
+func (pom *PartitionOffsetManager) commit() {
+	pom.l.Lock()
+	defer pom.l.Unlock()
+
+	if pom.dirty {
+		pom.committedOffset = pom.offset
+		pom.committedMetadata = pom.metadata
+		pom.dirty = false
+	}
+}
+
+///////////////////////////////////////////////////
+// Expectation API
+///////////////////////////////////////////////////
+
+// ExpectMarkOffset sets an expectation on the partition offset manager that MarkOffset will be
+// called with offset and metadata. Expectations on MarkOffset and ResetOffset are verified in
+// the order they are set.
+func (pom *PartitionOffsetManager) ExpectMarkOffset(offset int64, metadata string) *PartitionOffsetManager {
+	pom.l.Lock()
+	defer pom.l.Unlock()
+
+	pom.expectations = append(pom.expectations, &offsetExpectation{offset: offset, metadata: metadata})
+	return pom
+}
+
+// ExpectResetOffset sets an expectation on the partition offset manager that ResetOffset will
+// be called with offset and metadata. Expectations on MarkOffset and ResetOffset are verified in
+// the order they are set.
+func (pom *PartitionOffsetManager) ExpectResetOffset(offset int64, metadata string) *PartitionOffsetManager {
+	pom.l.Lock()
+	defer pom.l.Unlock()
+
+	pom.expectations = append(pom.expectations, &offsetExpectation{offset: offset, metadata: metadata, reset: true})
+	return pom
+}
+
+// YieldError will yield an error on the Errors channel of this partition offset manager, as
+// sarama does when it fails to commit its offset.
+func (pom *PartitionOffsetManager) YieldError(err error) *PartitionOffsetManager {
+	pom.errors <- &sarama.ConsumerError{
+		Topic:     pom.topic,
+		Partition: pom.partition,
+		Err:       err,
+	}
+
+	return pom
+}
+
+// CommittedOffset returns the offset and metadata committed for the partition, either the ones
+// passed to ExpectManagePartition or the last ones marked before a commit of the offset manager.
+func (pom *PartitionOffsetManager) CommittedOffset() (int64, string) {
+	pom.l.Lock()
+	defer pom.l.Unlock()
+
+	return pom.committedOffset, pom.committedMetadata
+}
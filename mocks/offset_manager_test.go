@@ -0,0 +1,140 @@
+package mocks
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/IBM/sarama"
+)
+
+func TestMockOffsetManagerImplementsInterfaces(t *testing.T) {
+	var om interface{} = &OffsetManager{}
+	if _, ok := om.(sarama.OffsetManager); !ok {
+		t.Error("The mock offset manager should implement the sarama.OffsetManager interface.")
+	}
+	if _, ok := om.(sarama.TopicPartitionManager); !ok {
+		t.Error("The mock offset manager should implement the sarama.TopicPartitionManager interface.")
+	}
+
+	var pom interface{} = &PartitionOffsetManager{}
+	if _, ok := pom.(sarama.PartitionOffsetManager); !ok {
+		t.Error("The mock partition offset manager should implement the sarama.PartitionOffsetManager interface.")
+	}
+}
+
+func TestOffsetManagerMarksAndCommits(t *testing.T) {
+	om := NewOffsetManager(t, NewTestConfig()).ExpectCommit()
+	expected := om.ExpectManagePartition("test", 0, 10, "ten").
+		ExpectMarkOffset(12, "twelve").
+		ExpectMarkOffset(11, "eleven").
+		ExpectResetOffset(5, "five")
+
+	pom, err := om.ManagePartition("test", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offset, metadata := pom.NextOffset(); offset != 10 || metadata != "ten" {
+		t.Errorf("Expected the committed offset to be returned, got %d %q", offset, metadata)
+	}
+
+	pom.MarkOffset(12, "twelve")
+	pom.MarkOffset(11, "eleven")
+	if offset, _ := pom.NextOffset(); offset != 12 {
+		t.Error("Expected lower offsets to be ignored, got", offset)
+	}
+	if offset, _ := expected.CommittedOffset(); offset != 10 {
+		t.Error("Expected the offset not to be committed before Commit, got", offset)
+	}
+	om.Commit()
+	if offset, metadata := expected.CommittedOffset(); offset != 12 || metadata != "twelve" {
+		t.Errorf("Expected the marked offset to be committed, got %d %q", offset, metadata)
+	}
+
+	pom.ResetOffset(5, "five")
+	if err := pom.Close(); err != nil {
+		t.Error(err)
+	}
+	if err := om.Close(); err != nil {
+		t.Error(err)
+	}
+	if offset, _ := expected.CommittedOffset(); offset != 5 {
+		t.Error("Expected the reset offset to be committed on close, got", offset)
+	}
+}
+
+func TestOffsetManagerUsesInitialOffset(t *testing.T) {
+	config := NewTestConfig()
+	config.Consumer.Offsets.Initial = sarama.OffsetOldest
+	om := NewOffsetManager(t, config)
+	om.ExpectManagePartition("test", 0, -1, "")
+
+	pom, err := om.ManagePartition("test", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offset, _ := pom.NextOffset(); offset != sarama.OffsetOldest {
+		t.Error("Expected the initial offset, got", offset)
+	}
+	if _, err := om.ManagePartition("test", 0); err == nil {
+		t.Error("Expected managing a partition twice to fail")
+	}
+
+	if err := pom.Close(); err != nil {
+		t.Error(err)
+	}
+	if err := om.Close(); err != nil {
+		t.Error(err)
+	}
+	if _, err := om.ManagePartition("test", 0); !errors.Is(err, sarama.ErrClosedClient) {
+		t.Error("Expected ErrClosedClient after close, got", err)
+	}
+}
+
+func TestOffsetManagerReportsViolatedExpectations(t *testing.T) {
+	trm := newTestReporterMock()
+	om := NewOffsetManager(trm, NewTestConfig()).ExpectCommit().ExpectCommit()
+	om.ExpectManagePartition("test", 0, 0, "").
+		ExpectMarkOffset(1, "").
+		ExpectMarkOffset(2, "")
+	om.ExpectManagePartition("test", 1, 0, "")
+
+	if _, err := om.ManagePartition("other", 0); err == nil {
+		t.Error("Expected managing an unexpected partition to fail")
+	}
+	pom, err := om.ManagePartition("test", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pom.ResetOffset(1, "")
+	om.Commit()
+
+	if err := pom.Close(); err != nil {
+		t.Error(err)
+	}
+	if err := om.Close(); err != nil {
+		t.Error(err)
+	}
+
+	// The unexpected partition, the ResetOffset in place of MarkOffset, the
+	// missing MarkOffset, the unmanaged partition and the missing Commit.
+	if len(trm.errors) != 5 {
+		t.Error("Expected 5 errors to be reported, got", trm.errors)
+	}
+}
+
+func TestPartitionOffsetManagerYieldsErrors(t *testing.T) {
+	om := NewOffsetManager(t, NewTestConfig())
+	om.ExpectManagePartition("test", 0, 0, "").YieldError(sarama.ErrOffsetMetadataTooLarge)
+
+	pom, err := om.ManagePartition("test", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var errs sarama.ConsumerErrors
+	if err := pom.Close(); !errors.As(err, &errs) || len(errs) != 1 || !errors.Is(errs[0].Err, sarama.ErrOffsetMetadataTooLarge) {
+		t.Error("Expected the yielded error to be returned on close, got", err)
+	}
+	if err := om.Close(); err != nil {
+		t.Error(err)
+	}
+}
@@ -16,6 +16,7 @@ type SyncProducer struct {
 	t            ErrorReporter
 	expectations []*producerExpectation
 	lastOffset   int64
+	produced     producedMessages
 
 	*TopicConfig
 	newPartitioner sarama.PartitionerConstructor
@@ -67,6 +68,7 @@ func (sp *SyncProducer) SendMessage(msg *sarama.ProducerMessage) (partition int3
 		return -1, -1, errors.New("attempt to send message when transaction is not started or is in ending state")
 	}
 
+	sp.produced.record(msg)
 	if len(sp.expectations) > 0 {
 		expectation := sp.expectations[0]
 		sp.expectations = sp.expectations[1:]
@@ -103,6 +105,9 @@ func (sp *SyncProducer) SendMessages(msgs []*sarama.ProducerMessage) error {
 	sp.l.Lock()
 	defer sp.l.Unlock()
 
+	for _, msg := range msgs {
+		sp.produced.record(msg)
+	}
 	if len(sp.expectations) >= len(msgs) {
 		expectations := sp.expectations[0:len(msgs)]
 		sp.expectations = sp.expectations[len(msgs):]
@@ -153,6 +158,7 @@ func (sp *SyncProducer) Close() error {
 	if len(sp.expectations) > 0 {
 		sp.t.Errorf("Expected to exhaust all expectations, but %d are left.", len(sp.expectations))
 	}
+	sp.produced.verifyCounts(sp.t)
 
 	return nil
 }
@@ -223,6 +229,42 @@ func (sp *SyncProducer) ExpectSendMessageAndFail(err error) *SyncProducer {
 	return sp
 }
 
+// ExpectSendMessageMatchingAndSucceed sets an expectation on the mock producer that SendMessage
+// will be called with a message matching m. As expectations are consumed in order, a sequence of
+// them also asserts the order in which messages are sent. If the message does not match, the
+// differences are reported to the test state and returned as the error of SendMessage.
+func (sp *SyncProducer) ExpectSendMessageMatchingAndSucceed(m *MessageMatcher) *SyncProducer {
+	sp.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(m.Check)
+
+	return sp
+}
+
+// ExpectSendMessageMatchingAndFail sets an expectation on the mock producer that SendMessage
+// will be called with a message matching m, and handles it as ExpectSendMessageAndFail.
+func (sp *SyncProducer) ExpectSendMessageMatchingAndFail(m *MessageMatcher, err error) *SyncProducer {
+	sp.ExpectSendMessageWithMessageCheckerFunctionAndFail(m.Check, err)
+
+	return sp
+}
+
+// ExpectTopicCount sets an expectation on the mock producer that exactly count messages will be
+// sent to topic. It is verified when the producer is closed.
+func (sp *SyncProducer) ExpectTopicCount(topic string, count int) *SyncProducer {
+	sp.l.Lock()
+	defer sp.l.Unlock()
+	sp.produced.expectCount(topic, count)
+
+	return sp
+}
+
+// Messages returns the last 1000 messages sent, in order.
+func (sp *SyncProducer) Messages() []*sarama.ProducerMessage {
+	sp.l.Lock()
+	defer sp.l.Unlock()
+
+	return sp.produced.last()
+}
+
 func (sp *SyncProducer) IsTransactional() bool {
 	return sp.isTransactional
 }
@@ -255,6 +297,34 @@ func (sp *SyncProducer) TxnStatus() sarama.ProducerTxnStatusFlag {
 	return sp.txnStatus
 }
 
+func (sp *SyncProducer) PrepareTxn() (sarama.PreparedTxnState, error) {
+	sp.txnLock.Lock()
+	defer sp.txnLock.Unlock()
+
+	sp.txnStatus = sarama.ProducerTxnFlagPreparedTransaction
+	return sarama.PreparedTxnState{}, nil
+}
+
+func (sp *SyncProducer) CompleteTxn(state sarama.PreparedTxnState) error {
+	sp.txnLock.Lock()
+	defer sp.txnLock.Unlock()
+
+	sp.txnStatus = sarama.ProducerTxnFlagReady
+	return nil
+}
+
+func (sp *SyncProducer) TxnState() sarama.TxnState {
+	sp.txnLock.Lock()
+	defer sp.txnLock.Unlock()
+
+	return sarama.TxnState{Status: sp.txnStatus}
+}
+
+// TxnEvents returns nil: the mock does not send transaction events.
+func (sp *SyncProducer) TxnEvents() <-chan *sarama.TxnEvent {
+	return nil
+}
+
 func (sp *SyncProducer) AddOffsetsToTxn(offsets map[string][]*sarama.PartitionOffsetMetadata, groupId string) error {
 	return nil
 }
@@ -13,6 +13,12 @@ func TestMockSyncProducerImplementsSyncProducerInterface(t *testing.T) {
 	if _, ok := mp.(sarama.SyncProducer); !ok {
 		t.Error("The mock async producer should implement the sarama.SyncProducer interface.")
 	}
+	if _, ok := mp.(sarama.TxnStateReporter); !ok {
+		t.Error("The mock sync producer should implement the sarama.TxnStateReporter interface.")
+	}
+	if _, ok := mp.(sarama.TwoPhaseCommitter); !ok {
+		t.Error("The mock sync producer should implement the sarama.TwoPhaseCommitter interface.")
+	}
 }
 
 func TestSyncProducerReturnsExpectationsToSendMessage(t *testing.T) {
@@ -368,3 +374,29 @@ func TestSyncProducerInvalidConfiguration(t *testing.T) {
 		t.Errorf("Unexpected error: %s", trm.errors[0])
 	}
 }
+
+func TestSyncProducerWithMessageMatchers(t *testing.T) {
+	trm := newTestReporterMock()
+	sp := NewSyncProducer(trm, nil).
+		ExpectSendMessageMatchingAndSucceed(MatchMessage().Topic("test").Value("first")).
+		ExpectSendMessageMatchingAndFail(MatchMessage().Topic("test").Value("second"), sarama.ErrOutOfBrokers).
+		ExpectTopicCount("test", 2)
+
+	if _, _, err := sp.SendMessage(&sarama.ProducerMessage{Topic: "test", Value: sarama.StringEncoder("first")}); err != nil {
+		t.Error("No error expected on first SendMessage call, found: ", err)
+	}
+	_, _, err := sp.SendMessage(&sarama.ProducerMessage{Topic: "test", Value: sarama.StringEncoder("third")})
+	if err == nil || !strings.Contains(err.Error(), `value: expected "second", got "third"`) {
+		t.Error("Expected the difference to be returned, found:", err)
+	}
+	if err := sp.Close(); err != nil {
+		t.Error(err)
+	}
+
+	if len(trm.errors) != 1 {
+		t.Error("Expected to report only the mismatch, got", trm.errors)
+	}
+	if messages := sp.Messages(); len(messages) != 2 {
+		t.Error("Expected 2 messages to be recorded, got", messages)
+	}
+}
@@ -0,0 +1,330 @@
+package sarama
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xdg-go/scram"
+)
+
+// MockSASLAuthenticator is a MockResponse emulating the SASL authentication of a
+// Kafka broker, so that the authentication and reauthentication of clients can be
+// tested against MockBroker. It handles the SaslHandshakeRequest and the
+// SaslAuthenticateRequest (SASL v1) of the PLAIN, SCRAM-SHA-256, SCRAM-SHA-512 and
+// OAUTHBEARER mechanisms:
+//
+//	auth := NewMockSASLAuthenticator(t).AddUser("alice", "secret")
+//	broker.SetHandlerByMap(map[string]MockResponse{
+//		"SaslHandshakeRequest":    auth,
+//		"SaslAuthenticateRequest": auth,
+//		...
+//	})
+//
+// The credentials are checked like a broker would, and FailNext scripts the
+// failure of the next authentications regardless of them. SetSessionLifetime makes
+// the clients reauthenticate. A single conversation is held at a time, so the
+// connections to the broker have to authenticate one after the other, as they do
+// when they are opened by a single client.
+type MockSASLAuthenticator struct {
+	t TestReporter
+
+	lock            sync.Mutex
+	mechanisms      []string
+	users           map[string]string
+	tokens          map[string]string
+	sessionLifetime time.Duration
+	failures        []KError
+	attempts        []MockSASLAttempt
+
+	// The state of the current conversation.
+	mechanism   string
+	scram       *scram.ServerConversation
+	oauthFailed bool
+}
+
+// MockSASLAttempt is an authentication attempt handled by MockSASLAuthenticator.
+type MockSASLAttempt struct {
+	Mechanism string
+	// Principal is the user, or the principal of the token, which
+	// authenticated. It is empty if the attempt failed before the user was
+	// known.
+	Principal string
+	Err       KError
+}
+
+// NewMockSASLAuthenticator returns an authenticator enabling all the mechanisms
+// it supports, without any user or token.
+func NewMockSASLAuthenticator(t TestReporter) *MockSASLAuthenticator {
+	return &MockSASLAuthenticator{
+		t: t,
+		mechanisms: []string{
+			SASLTypePlaintext,
+			SASLTypeSCRAMSHA256,
+			SASLTypeSCRAMSHA512,
+			SASLTypeOAuth,
+		},
+		users:  make(map[string]string),
+		tokens: make(map[string]string),
+	}
+}
+
+// SetMechanisms sets the mechanisms enabled on the broker, as returned by the
+// SaslHandshake responses. The first one is used by the clients which do not
+// send a handshake.
+func (a *MockSASLAuthenticator) SetMechanisms(mechanisms ...string) *MockSASLAuthenticator {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.mechanisms = mechanisms
+	return a
+}
+
+// AddUser adds a user allowed to authenticate using PLAIN or SCRAM.
+func (a *MockSASLAuthenticator) AddUser(user, password string) *MockSASLAuthenticator {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.users[user] = password
+	return a
+}
+
+// AddToken adds a token allowed to authenticate as principal using OAUTHBEARER.
+func (a *MockSASLAuthenticator) AddToken(token, principal string) *MockSASLAuthenticator {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.tokens[token] = principal
+	return a
+}
+
+// SetSessionLifetime sets the lifetime of the sessions of the clients, which
+// have to reauthenticate before it expires. It requires SaslAuthenticate v1,
+// sent by the clients from V2_2_0_0.
+func (a *MockSASLAuthenticator) SetSessionLifetime(lifetime time.Duration) *MockSASLAuthenticator {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.sessionLifetime = lifetime
+	return a
+}
+
+// FailNext makes the next authentications fail with errs, in order, whatever
+// the credentials sent. The following ones check the credentials again.
+func (a *MockSASLAuthenticator) FailNext(errs ...KError) *MockSASLAuthenticator {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.failures = append(a.failures, errs...)
+	return a
+}
+
+// Attempts returns the authentication attempts handled so far, in order.
+func (a *MockSASLAuthenticator) Attempts() []MockSASLAttempt {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return append([]MockSASLAttempt(nil), a.attempts...)
+}
+
+// Authentications returns the number of successful authentications of
+// principal, including the reauthentications.
+func (a *MockSASLAuthenticator) Authentications(principal string) int {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	n := 0
+	for _, attempt := range a.attempts {
+		if attempt.Principal == principal && attempt.Err == ErrNoError {
+			n++
+		}
+	}
+	return n
+}
+
+func (a *MockSASLAuthenticator) For(reqBody versionedDecoder) encoderWithHeader {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	switch req := reqBody.(type) {
+	case *SaslHandshakeRequest:
+		return a.handshake(req)
+	case *SaslAuthenticateRequest:
+		return a.authenticate(req)
+	}
+	a.t.Errorf("MockSASLAuthenticator does not handle %T", reqBody)
+	return nil
+}
+
+func (a *MockSASLAuthenticator) handshake(req *SaslHandshakeRequest) encoderWithHeader {
+	res := &SaslHandshakeResponse{Version: req.version(), EnabledMechanisms: a.mechanisms}
+	a.resetConversation()
+	if !a.enabled(req.Mechanism) {
+		res.Err = ErrUnsupportedSASLMechanism
+		a.attempts = append(a.attempts, MockSASLAttempt{Mechanism: req.Mechanism, Err: res.Err})
+		return res
+	}
+	a.mechanism = req.Mechanism
+	return res
+}
+
+func (a *MockSASLAuthenticator) authenticate(req *SaslAuthenticateRequest) encoderWithHeader {
+	res := &SaslAuthenticateResponse{Version: req.version()}
+	if a.mechanism == "" && len(a.mechanisms) > 0 {
+		a.mechanism = a.mechanisms[0]
+	}
+
+	switch a.mechanism {
+	case SASLTypePlaintext:
+		a.authenticatePlain(req, res)
+	case SASLTypeSCRAMSHA256, SASLTypeSCRAMSHA512:
+		a.authenticateSCRAM(req, res)
+	case SASLTypeOAuth:
+		a.authenticateOAuth(req, res)
+	default:
+		a.fail(res, "", ErrIllegalSASLState, "Unexpected SaslAuthenticate request without mechanism")
+	}
+	return res
+}
+
+func (a *MockSASLAuthenticator) authenticatePlain(req *SaslAuthenticateRequest, res *SaslAuthenticateResponse) {
+	defer a.resetConversation()
+
+	parts := bytes.Split(req.SaslAuthBytes, []byte{0})
+	if len(parts) != 3 {
+		a.fail(res, "", ErrSASLAuthenticationFailed, "Invalid SASL/PLAIN response")
+		return
+	}
+	user, password := string(parts[1]), string(parts[2])
+	if a.failScripted(res, user) {
+		return
+	}
+	if expected, ok := a.users[user]; !ok || expected != password {
+		a.fail(res, user, ErrSASLAuthenticationFailed, "Invalid username or password")
+		return
+	}
+	a.succeed(res, user)
+}
+
+func (a *MockSASLAuthenticator) authenticateSCRAM(req *SaslAuthenticateRequest, res *SaslAuthenticateResponse) {
+	if a.scram == nil {
+		hash := scram.SHA256
+		if a.mechanism == SASLTypeSCRAMSHA512 {
+			hash = scram.SHA512
+		}
+		server, err := hash.NewServer(func(user string) (scram.StoredCredentials, error) {
+			return a.scramCredentials(hash, user), nil
+		})
+		if err != nil {
+			a.t.Errorf("failed to create SCRAM server: %v", err)
+			a.fail(res, "", ErrSASLAuthenticationFailed, err.Error())
+			return
+		}
+		a.scram = server.NewConversation()
+
+		// A scripted failure ends the exchange at the first message.
+		if a.failScripted(res, "") {
+			a.resetConversation()
+			return
+		}
+	}
+
+	msg, err := a.scram.Step(string(req.SaslAuthBytes))
+	user := a.scram.Username()
+	if err != nil {
+		a.fail(res, user, ErrSASLAuthenticationFailed, "Authentication failed during authentication due to invalid credentials with SASL mechanism "+a.mechanism)
+		a.resetConversation()
+		return
+	}
+	res.SaslAuthBytes = []byte(msg)
+	if a.scram.Done() {
+		a.succeed(res, user)
+		a.resetConversation()
+	}
+}
+
+// scramCredentials returns the credentials of user, random ones for the
+// unknown users so that they fail like the others.
+func (a *MockSASLAuthenticator) scramCredentials(hash scram.HashGeneratorFcn, user string) scram.StoredCredentials {
+	password, ok := a.users[user]
+	if !ok {
+		password = hex.EncodeToString(randomBytes(16))
+	}
+	client, _ := hash.NewClient(user, password, "")
+	return client.GetStoredCredentials(scram.KeyFactors{Salt: string(randomBytes(16)), Iters: 4096})
+}
+
+func (a *MockSASLAuthenticator) authenticateOAuth(req *SaslAuthenticateRequest, res *SaslAuthenticateResponse) {
+	// Like Kafka, a failed token is answered by a challenge, and the
+	// failure is returned once the client aborts the exchange.
+	if a.oauthFailed {
+		a.fail(res, "", ErrSASLAuthenticationFailed, "Authentication failed: Invalid token")
+		a.resetConversation()
+		return
+	}
+
+	token := parseBearerToken(req.SaslAuthBytes)
+	principal, ok := a.tokens[token]
+	if a.failScripted(res, principal) {
+		a.resetConversation()
+		return
+	}
+	if !ok {
+		a.oauthFailed = true
+		res.SaslAuthBytes = []byte(`{"status":"invalid_token"}`)
+		return
+	}
+	a.succeed(res, principal)
+	a.resetConversation()
+}
+
+// parseBearerToken returns the token of an OAUTHBEARER initial client response.
+func parseBearerToken(msg []byte) string {
+	for _, kv := range strings.Split(string(msg), "\x01") {
+		if strings.HasPrefix(kv, "auth=Bearer ") {
+			return strings.TrimPrefix(kv, "auth=Bearer ")
+		}
+	}
+	return ""
+}
+
+func (a *MockSASLAuthenticator) failScripted(res *SaslAuthenticateResponse, principal string) bool {
+	if len(a.failures) == 0 {
+		return false
+	}
+	err := a.failures[0]
+	a.failures = a.failures[1:]
+	a.fail(res, principal, err, err.Error())
+	return true
+}
+
+func (a *MockSASLAuthenticator) fail(res *SaslAuthenticateResponse, principal string, err KError, message string) {
+	res.Err = err
+	res.ErrorMessage = &message
+	a.attempts = append(a.attempts, MockSASLAttempt{Mechanism: a.mechanism, Principal: principal, Err: err})
+}
+
+func (a *MockSASLAuthenticator) succeed(res *SaslAuthenticateResponse, principal string) {
+	if res.Version > 0 {
+		res.SessionLifetimeMs = int64(a.sessionLifetime / time.Millisecond)
+	}
+	a.attempts = append(a.attempts, MockSASLAttempt{Mechanism: a.mechanism, Principal: principal, Err: ErrNoError})
+}
+
+func (a *MockSASLAuthenticator) resetConversation() {
+	a.mechanism = ""
+	a.scram = nil
+	a.oauthFailed = false
+}
+
+func (a *MockSASLAuthenticator) enabled(mechanism string) bool {
+	for _, m := range a.mechanisms {
+		if m == mechanism {
+			return true
+		}
+	}
+	return false
+}
+
+func randomBytes(n int) []byte {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return b
+}
@@ -0,0 +1,215 @@
+package sarama
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/xdg-go/scram"
+)
+
+type scramTestClient struct {
+	hash scram.HashGeneratorFcn
+	conv *scram.ClientConversation
+}
+
+func (c *scramTestClient) Begin(user, password, authzID string) error {
+	client, err := c.hash.NewClient(user, password, authzID)
+	if err != nil {
+		return err
+	}
+	c.conv = client.NewConversation()
+	return nil
+}
+
+func (c *scramTestClient) Step(challenge string) (string, error) {
+	return c.conv.Step(challenge)
+}
+
+func (c *scramTestClient) Done() bool {
+	return c.conv.Done()
+}
+
+func newSASLTestBroker(t *testing.T, auth *MockSASLAuthenticator) *MockBroker {
+	broker := NewMockBroker(t, 0)
+	broker.SetHandlerByMap(map[string]MockResponse{
+		"SaslHandshakeRequest":    auth,
+		"SaslAuthenticateRequest": auth,
+		"MetadataRequest": NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()),
+	})
+	return broker
+}
+
+func newSASLTestConfig(mechanism SASLMechanism, user, password string) *Config {
+	config := NewTestConfig()
+	config.Version = V2_2_0_0
+	config.Metadata.Retry.Max = 0
+	config.Net.SASL.Enable = true
+	config.Net.SASL.Mechanism = mechanism
+	config.Net.SASL.User = user
+	config.Net.SASL.Password = password
+	switch mechanism {
+	case SASLTypeSCRAMSHA256:
+		config.Net.SASL.SCRAMClientGeneratorFunc = func() SCRAMClient { return &scramTestClient{hash: scram.SHA256} }
+	case SASLTypeSCRAMSHA512:
+		config.Net.SASL.SCRAMClientGeneratorFunc = func() SCRAMClient { return &scramTestClient{hash: scram.SHA512} }
+	case SASLTypeOAuth:
+		config.Net.SASL.TokenProvider = newTokenProvider(&AccessToken{Token: password}, nil)
+	}
+	return config
+}
+
+func TestMockSASLAuthenticatorMechanisms(t *testing.T) {
+	for _, mechanism := range []SASLMechanism{SASLTypePlaintext, SASLTypeSCRAMSHA256, SASLTypeSCRAMSHA512, SASLTypeOAuth} {
+		mechanism := mechanism
+		t.Run(string(mechanism), func(t *testing.T) {
+			auth := NewMockSASLAuthenticator(t).
+				AddUser("alice", "secret").
+				AddToken("secret", "alice")
+			broker := newSASLTestBroker(t, auth)
+			defer broker.Close()
+
+			client, err := NewClient([]string{broker.Addr()}, newSASLTestConfig(mechanism, "alice", "secret"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			safeClose(t, client)
+			if auth.Authentications("alice") == 0 {
+				t.Error("expected alice to be authenticated, got", auth.Attempts())
+			}
+
+			_, err = NewClient([]string{broker.Addr()}, newSASLTestConfig(mechanism, "alice", "wrong"))
+			if !errors.Is(err, ErrOutOfBrokers) {
+				t.Fatal("expected the authentication to fail, got", err)
+			}
+			attempts := auth.Attempts()
+			if last := attempts[len(attempts)-1]; last.Err != ErrSASLAuthenticationFailed || last.Mechanism != string(mechanism) {
+				t.Errorf("expected the last attempt to fail, got %+v", last)
+			}
+		})
+	}
+}
+
+func TestMockSASLAuthenticatorScriptedFailures(t *testing.T) {
+	auth := NewMockSASLAuthenticator(t).
+		AddUser("alice", "secret").
+		FailNext(ErrSASLAuthenticationFailed, ErrIllegalSASLState)
+	broker := newSASLTestBroker(t, auth)
+	defer broker.Close()
+
+	config := newSASLTestConfig(SASLTypeSCRAMSHA256, "alice", "secret")
+	for _, expected := range []KError{ErrSASLAuthenticationFailed, ErrIllegalSASLState} {
+		b := NewBroker(broker.Addr())
+		if err := b.Open(config); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := b.Connected(); !errors.Is(err, expected) {
+			t.Errorf("expected %v, got %v", expected, err)
+		}
+		_ = b.Close()
+	}
+
+	client, err := NewClient([]string{broker.Addr()}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	safeClose(t, client)
+}
+
+func TestMockSASLAuthenticatorUnsupportedMechanism(t *testing.T) {
+	auth := NewMockSASLAuthenticator(t).SetMechanisms(SASLTypeSCRAMSHA512).AddUser("alice", "secret")
+	broker := newSASLTestBroker(t, auth)
+	defer broker.Close()
+
+	b := NewBroker(broker.Addr())
+	if err := b.Open(newSASLTestConfig(SASLTypePlaintext, "alice", "secret")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Connected(); !errors.Is(err, ErrUnsupportedSASLMechanism) {
+		t.Error("expected ErrUnsupportedSASLMechanism, got", err)
+	}
+	_ = b.Close()
+}
+
+func TestMockSASLAuthenticatorReauthentication(t *testing.T) {
+	auth := NewMockSASLAuthenticator(t).
+		AddUser("alice", "secret").
+		SetSessionLifetime(100 * time.Millisecond)
+	broker := newSASLTestBroker(t, auth)
+	defer broker.Close()
+
+	b := NewBroker(broker.Addr())
+	if err := b.Open(newSASLTestConfig(SASLTypePlaintext, "alice", "secret")); err != nil {
+		t.Fatal(err)
+	}
+	defer safeClose(t, b)
+	if _, err := b.GetMetadata(&MetadataRequest{Version: 7}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(150 * time.Millisecond)
+	if _, err := b.GetMetadata(&MetadataRequest{Version: 7}); err != nil {
+		t.Fatal(err)
+	}
+	if n := auth.Authentications("alice"); n != 2 {
+		t.Error("expected the session to be reauthenticated, got", auth.Attempts())
+	}
+}
+
+func TestMockBrokerTLS(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		Subject:      pkix.Name{CommonName: "broker"},
+		SerialNumber: big.NewInt(1),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+
+	auth := NewMockSASLAuthenticator(t).AddUser("alice", "secret")
+	broker := NewMockBrokerTLS(t, 0, &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+		MinVersion:   tls.VersionTLS12,
+	})
+	defer broker.Close()
+	broker.SetHandlerByMap(map[string]MockResponse{
+		"SaslHandshakeRequest":    auth,
+		"SaslAuthenticateRequest": auth,
+		"MetadataRequest":         NewMockMetadataResponse(t).SetBroker(broker.Addr(), broker.BrokerID()),
+	})
+
+	config := newSASLTestConfig(SASLTypePlaintext, "alice", "secret")
+	config.Net.TLS.Enable = true
+	config.Net.TLS.Config = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	client, err := NewClient([]string{broker.Addr()}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	safeClose(t, client)
+	if auth.Authentications("alice") == 0 {
+		t.Error("expected alice to be authenticated over TLS")
+	}
+}
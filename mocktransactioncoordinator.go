@@ -0,0 +1,409 @@
+package sarama
+
+import (
+	"sort"
+	"sync"
+)
+
+// MockTransactionCoordinator is a MockResponse emulating the transaction
+// coordinator of a Kafka broker, so that transactional producers can be
+// tested against MockBroker. It handles the FindCoordinatorRequest,
+// InitProducerIDRequest, AddPartitionsToTxnRequest, AddOffsetsToTxnRequest,
+// TxnOffsetCommitRequest and EndTxnRequest of any number of transactional
+// IDs, and the ProduceRequest of a partition leader fencing the batches of
+// the older producer epochs:
+//
+//	coordinator := NewMockTransactionCoordinator(t, broker)
+//	broker.SetHandlerByMap(map[string]MockResponse{
+//		"FindCoordinatorRequest":    coordinator,
+//		"InitProducerIDRequest":     coordinator,
+//		"AddPartitionsToTxnRequest": coordinator,
+//		"AddOffsetsToTxnRequest":    coordinator,
+//		"TxnOffsetCommitRequest":    coordinator,
+//		"EndTxnRequest":             coordinator,
+//		"ProduceRequest":            coordinator,
+//		...
+//	})
+//
+// Each transactional ID goes through the states of a real transaction: the
+// producer epoch is bumped by every InitProducerIDRequest, which aborts the
+// ongoing transaction, and the requests of older epochs are fenced. Fence and
+// Expire emulate another producer taking over the transactional ID and the
+// timeout of a transaction, which FenceProducer also does against a cluster.
+// SetError scripts the errors of the next requests, such as
+// ErrNotCoordinatorForConsumer when the coordinator moved.
+type MockTransactionCoordinator struct {
+	t      TestReporter
+	broker *MockBroker
+
+	lock         sync.Mutex
+	coordinator  *MockBroker
+	producerID   int64
+	transactions map[string]*mockTransaction
+	offsets      map[string]map[string]map[int32]int64
+	errors       map[string][]KError
+}
+
+type mockTransaction struct {
+	producerID    int64
+	producerEpoch int16
+	ongoing       bool
+	partitions    map[string]map[int32]bool
+	offsets       map[string]map[string]map[int32]int64
+	committed     int
+	aborted       int
+}
+
+// NewMockTransactionCoordinator returns a MockTransactionCoordinator with no
+// transaction, designating broker as the coordinator of all the
+// transactional IDs.
+func NewMockTransactionCoordinator(t TestReporter, broker *MockBroker) *MockTransactionCoordinator {
+	return &MockTransactionCoordinator{
+		t:            t,
+		broker:       broker,
+		coordinator:  broker,
+		producerID:   1000,
+		transactions: make(map[string]*mockTransaction),
+		offsets:      make(map[string]map[string]map[int32]int64),
+		errors:       make(map[string][]KError),
+	}
+}
+
+func (c *MockTransactionCoordinator) For(reqBody versionedDecoder) encoderWithHeader {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	switch req := reqBody.(type) {
+	case *FindCoordinatorRequest:
+		res := &FindCoordinatorResponse{Version: req.version(), Err: c.nextError("FindCoordinatorRequest")}
+		if res.Err == ErrNoError {
+			res.Coordinator = &Broker{id: c.coordinator.BrokerID(), addr: c.coordinator.Addr()}
+		}
+		return res
+	case *InitProducerIDRequest:
+		return c.initProducerID(req)
+	case *AddPartitionsToTxnRequest:
+		return c.addPartitions(req)
+	case *AddOffsetsToTxnRequest:
+		return c.addOffsets(req)
+	case *TxnOffsetCommitRequest:
+		return c.commitOffsets(req)
+	case *EndTxnRequest:
+		return c.endTxn(req)
+	case *ProduceRequest:
+		return c.produce(req)
+	default:
+		c.t.Errorf("MockTransactionCoordinator: unexpected request %T", reqBody)
+		return nil
+	}
+}
+
+// SetError makes the coordinator fail the next len(errs) requests of type
+// request, such as "EndTxnRequest", with errs in order.
+func (c *MockTransactionCoordinator) SetError(request string, errs ...KError) *MockTransactionCoordinator {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.errors[request] = append(c.errors[request], errs...)
+	return c
+}
+
+// SetCoordinator makes the FindCoordinatorRequests return broker, as when
+// the transaction coordinator moved.
+func (c *MockTransactionCoordinator) SetCoordinator(broker *MockBroker) *MockTransactionCoordinator {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.coordinator = broker
+	return c
+}
+
+// Fence bumps the epoch of transactionalID as if another producer
+// initialized it, aborting the ongoing transaction. The requests of the
+// current producer are then fenced.
+func (c *MockTransactionCoordinator) Fence(transactionalID string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if txn := c.transactions[transactionalID]; txn != nil {
+		c.abort(txn)
+		txn.producerEpoch++
+	}
+}
+
+// Expire aborts the ongoing transaction of transactionalID and bumps its
+// epoch, as the coordinator does when the transaction times out.
+func (c *MockTransactionCoordinator) Expire(transactionalID string) {
+	c.Fence(transactionalID)
+}
+
+// ProducerID returns the producer ID and epoch of transactionalID, and
+// whether it was initialized.
+func (c *MockTransactionCoordinator) ProducerID(transactionalID string) (int64, int16, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	txn := c.transactions[transactionalID]
+	if txn == nil {
+		return noProducerID, noProducerEpoch, false
+	}
+	return txn.producerID, txn.producerEpoch, true
+}
+
+// Partitions returns the partitions added to the ongoing transaction of
+// transactionalID.
+func (c *MockTransactionCoordinator) Partitions(transactionalID string) map[string][]int32 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	partitions := make(map[string][]int32)
+	if txn := c.transactions[transactionalID]; txn != nil {
+		for topic, set := range txn.partitions {
+			for partition := range set {
+				partitions[topic] = append(partitions[topic], partition)
+			}
+			sort.Slice(partitions[topic], func(i, j int) bool { return partitions[topic][i] < partitions[topic][j] })
+		}
+	}
+	return partitions
+}
+
+// Transactions returns the number of transactions of transactionalID that
+// were committed and aborted.
+func (c *MockTransactionCoordinator) Transactions(transactionalID string) (committed, aborted int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if txn := c.transactions[transactionalID]; txn != nil {
+		return txn.committed, txn.aborted
+	}
+	return 0, 0
+}
+
+// Offset returns the offset of a partition for group committed by a
+// transaction, and whether there is one.
+func (c *MockTransactionCoordinator) Offset(group, topic string, partition int32) (int64, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	offset, ok := c.offsets[group][topic][partition]
+	return offset, ok
+}
+
+func (c *MockTransactionCoordinator) nextError(request string) KError {
+	errs := c.errors[request]
+	if len(errs) == 0 {
+		return ErrNoError
+	}
+	c.errors[request] = errs[1:]
+	return errs[0]
+}
+
+func (c *MockTransactionCoordinator) initProducerID(req *InitProducerIDRequest) encoderWithHeader {
+	res := &InitProducerIDResponse{Version: req.Version, Err: c.nextError("InitProducerIDRequest")}
+	if res.Err != ErrNoError {
+		return res
+	}
+
+	// Idempotent producers get a new producer ID every time.
+	if req.TransactionalID == nil {
+		c.producerID++
+		res.ProducerID = c.producerID
+		return res
+	}
+
+	txn := c.transactions[*req.TransactionalID]
+	if txn == nil {
+		c.producerID++
+		txn = &mockTransaction{producerID: c.producerID}
+		c.transactions[*req.TransactionalID] = txn
+	} else {
+		// A producer resuming after an error has to own the current epoch.
+		if req.Version >= 3 && req.ProducerID != noProducerID &&
+			(req.ProducerID != txn.producerID || req.ProducerEpoch != txn.producerEpoch) {
+			res.Err = fencedError(req.Version, 4)
+			return res
+		}
+		c.abort(txn)
+		txn.producerEpoch++
+	}
+	res.ProducerID, res.ProducerEpoch = txn.producerID, txn.producerEpoch
+	return res
+}
+
+func (c *MockTransactionCoordinator) addPartitions(req *AddPartitionsToTxnRequest) encoderWithHeader {
+	res := &AddPartitionsToTxnResponse{Version: req.Version, Errors: make(map[string][]*PartitionError)}
+	txn, kerr := c.transaction(req.TransactionalID, req.ProducerID, req.ProducerEpoch, req.Version, 2)
+	if kerr == ErrNoError {
+		kerr = c.nextError("AddPartitionsToTxnRequest")
+	}
+	for topic, partitions := range req.TopicPartitions {
+		for _, partition := range partitions {
+			if kerr == ErrNoError {
+				txn.addPartition(topic, partition)
+			}
+			res.Errors[topic] = append(res.Errors[topic], &PartitionError{Partition: partition, Err: kerr})
+		}
+	}
+	return res
+}
+
+func (c *MockTransactionCoordinator) addOffsets(req *AddOffsetsToTxnRequest) encoderWithHeader {
+	res := &AddOffsetsToTxnResponse{Version: req.Version}
+	txn, kerr := c.transaction(req.TransactionalID, req.ProducerID, req.ProducerEpoch, req.Version, 2)
+	if kerr == ErrNoError {
+		kerr = c.nextError("AddOffsetsToTxnRequest")
+	}
+	if kerr == ErrNoError {
+		// The offsets are written to a partition of __consumer_offsets.
+		txn.addPartition("__consumer_offsets", 0)
+		if txn.offsets[req.GroupID] == nil {
+			txn.offsets[req.GroupID] = make(map[string]map[int32]int64)
+		}
+	}
+	res.Err = kerr
+	return res
+}
+
+func (c *MockTransactionCoordinator) commitOffsets(req *TxnOffsetCommitRequest) encoderWithHeader {
+	res := &TxnOffsetCommitResponse{Version: req.Version, Topics: make(map[string][]*PartitionError)}
+	txn, kerr := c.transaction(req.TransactionalID, req.ProducerID, req.ProducerEpoch, req.Version, 3)
+	if kerr == ErrNoError {
+		kerr = c.nextError("TxnOffsetCommitRequest")
+	}
+	var offsets map[string]map[int32]int64
+	if kerr == ErrNoError {
+		if offsets = txn.offsets[req.GroupID]; offsets == nil {
+			// The group was not added to the transaction.
+			kerr = ErrInvalidTxnState
+		}
+	}
+	for topic, partitions := range req.Topics {
+		for _, p := range partitions {
+			if kerr == ErrNoError {
+				if offsets[topic] == nil {
+					offsets[topic] = make(map[int32]int64)
+				}
+				offsets[topic][p.Partition] = p.Offset
+			}
+			res.Topics[topic] = append(res.Topics[topic], &PartitionError{Partition: p.Partition, Err: kerr})
+		}
+	}
+	return res
+}
+
+func (c *MockTransactionCoordinator) endTxn(req *EndTxnRequest) encoderWithHeader {
+	res := &EndTxnResponse{Version: req.Version}
+	txn, kerr := c.transaction(req.TransactionalID, req.ProducerID, req.ProducerEpoch, req.Version, 2)
+	if kerr == ErrNoError {
+		kerr = c.nextError("EndTxnRequest")
+	}
+	if kerr == ErrNoError && !txn.ongoing {
+		kerr = ErrInvalidTxnState
+	}
+	if kerr != ErrNoError {
+		res.Err = kerr
+		return res
+	}
+
+	if !req.TransactionResult {
+		c.abort(txn)
+		return res
+	}
+	for group, topics := range txn.offsets {
+		if c.offsets[group] == nil {
+			c.offsets[group] = make(map[string]map[int32]int64)
+		}
+		for topic, partitions := range topics {
+			if c.offsets[group][topic] == nil {
+				c.offsets[group][topic] = make(map[int32]int64)
+			}
+			for partition, offset := range partitions {
+				c.offsets[group][topic][partition] = offset
+			}
+		}
+	}
+	txn.committed++
+	txn.reset()
+	return res
+}
+
+// produce fails the batches of the producer IDs of the transactional IDs
+// whose epoch is older than their current one, as the partition leaders do
+// once a zombie producer was fenced.
+func (c *MockTransactionCoordinator) produce(req *ProduceRequest) encoderWithHeader {
+	res := &ProduceResponse{Version: req.version()}
+	for topic, partitions := range req.records {
+		for partition, records := range partitions {
+			kerr := c.nextError("ProduceRequest")
+			if batch := records.RecordBatch; kerr == ErrNoError && batch != nil {
+				for _, txn := range c.transactions {
+					if txn.producerID == batch.ProducerID && batch.ProducerEpoch < txn.producerEpoch {
+						kerr = ErrInvalidProducerEpoch
+					}
+				}
+			}
+			res.AddTopicPartition(topic, partition, kerr)
+		}
+	}
+	return res
+}
+
+// transaction returns the transaction of transactionalID if the producer owns
+// it, and otherwise the error of a request of version, which supports
+// ErrProducerFenced from fencedVersion.
+func (c *MockTransactionCoordinator) transaction(transactionalID string, producerID int64, producerEpoch int16, version, fencedVersion int16) (*mockTransaction, KError) {
+	txn := c.transactions[transactionalID]
+	switch {
+	case txn == nil || txn.producerID != producerID:
+		return nil, ErrInvalidProducerIDMapping
+	case producerEpoch != txn.producerEpoch:
+		return nil, fencedError(version, fencedVersion)
+	}
+	return txn, ErrNoError
+}
+
+func (c *MockTransactionCoordinator) abort(txn *mockTransaction) {
+	if txn.ongoing {
+		txn.aborted++
+	}
+	txn.reset()
+}
+
+// fencedError returns ErrProducerFenced if version supports it, and
+// ErrInvalidProducerEpoch for the older versions.
+func fencedError(version, fencedVersion int16) KError {
+	if version >= fencedVersion {
+		return ErrProducerFenced
+	}
+	return ErrInvalidProducerEpoch
+}
+
+func (txn *mockTransaction) addPartition(topic string, partition int32) {
+	if txn.partitions == nil {
+		txn.partitions = make(map[string]map[int32]bool)
+		txn.offsets = make(map[string]map[string]map[int32]int64)
+	}
+	if txn.partitions[topic] == nil {
+		txn.partitions[topic] = make(map[int32]bool)
+	}
+	txn.partitions[topic][partition] = true
+	txn.ongoing = true
+}
+
+func (txn *mockTransaction) reset() {
+	txn.ongoing = false
+	txn.partitions = nil
+	txn.offsets = nil
+}
+
+// FenceProducer fences the producers of the transactional ID of conf, as a
+// new instance of the producer taking over the ID does, by initializing it
+// again. It lets applications test their handling of zombie producers and
+// of ErrProducerFenced, against a MockBroker with a MockTransactionCoordinator
+// or against a cluster.
+func FenceProducer(addrs []string, conf *Config) error {
+	if conf.Producer.Transaction.ID == "" {
+		return ConfigurationError("FenceProducer requires Producer.Transaction.ID")
+	}
+	client, err := NewClient(addrs, conf)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = client.Close() }()
+	_, err = newTransactionManager(conf, client)
+	return err
+}
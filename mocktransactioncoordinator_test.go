@@ -0,0 +1,196 @@
+package sarama
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newMockTransactionCoordinatorProducer(t *testing.T) (*MockBroker, *MockTransactionCoordinator, AsyncProducer) {
+	broker := NewMockBroker(t, 1)
+	coordinator := NewMockTransactionCoordinator(t, broker)
+	broker.SetHandlerByMap(map[string]MockResponse{
+		"ApiVersionsRequest": NewMockApiVersionsResponse(t),
+		"MetadataRequest": NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetController(broker.BrokerID()).
+			SetLeader("test-topic", 0, broker.BrokerID()),
+		"ProduceRequest":            coordinator,
+		"FindCoordinatorRequest":    coordinator,
+		"InitProducerIDRequest":     coordinator,
+		"AddPartitionsToTxnRequest": coordinator,
+		"AddOffsetsToTxnRequest":    coordinator,
+		"TxnOffsetCommitRequest":    coordinator,
+		"EndTxnRequest":             coordinator,
+	})
+
+	producer, err := NewAsyncProducer([]string{broker.Addr()}, newMockTransactionCoordinatorConfig())
+	require.NoError(t, err)
+	return broker, coordinator, producer
+}
+
+func newMockTransactionCoordinatorConfig() *Config {
+	config := NewTestConfig()
+	config.Version = V2_7_0_0
+	config.Producer.Idempotent = true
+	config.Producer.Transaction.ID = "test"
+	config.Producer.Transaction.Retry.Backoff = 0
+	config.Producer.RequiredAcks = WaitForAll
+	config.Producer.Return.Errors = false
+	config.Net.MaxOpenRequests = 1
+	return config
+}
+
+func TestMockTransactionCoordinatorCommit(t *testing.T) {
+	broker, coordinator, producer := newMockTransactionCoordinatorProducer(t)
+	defer broker.Close()
+	defer safeClose(t, producer)
+
+	pid, epoch, ok := coordinator.ProducerID("test")
+	require.True(t, ok)
+	require.Equal(t, int16(0), epoch)
+
+	require.NoError(t, producer.BeginTxn())
+	producer.Input() <- &ProducerMessage{Topic: "test-topic", Value: StringEncoder(TestMessage)}
+	require.NoError(t, producer.AddOffsetsToTxn(map[string][]*PartitionOffsetMetadata{
+		"source": {{Partition: 0, Offset: 42}},
+	}, "my-group"))
+
+	// The coordinator moves before the commit: the producer has to look it up
+	// again and retry.
+	coordinator.SetError("EndTxnRequest", ErrNotCoordinatorForConsumer)
+	require.NoError(t, producer.CommitTxn())
+
+	committed, aborted := coordinator.Transactions("test")
+	require.Equal(t, 1, committed)
+	require.Equal(t, 0, aborted)
+	offset, ok := coordinator.Offset("my-group", "source", 0)
+	require.True(t, ok)
+	require.Equal(t, int64(42), offset)
+	require.Empty(t, coordinator.Partitions("test"))
+
+	require.NoError(t, producer.BeginTxn())
+	producer.Input() <- &ProducerMessage{Topic: "test-topic", Value: StringEncoder(TestMessage)}
+	require.NoError(t, producer.AbortTxn())
+	committed, aborted = coordinator.Transactions("test")
+	require.Equal(t, 1, committed)
+	require.Equal(t, 1, aborted)
+
+	newPID, _, _ := coordinator.ProducerID("test")
+	require.Equal(t, pid, newPID)
+}
+
+func TestMockTransactionCoordinatorFence(t *testing.T) {
+	broker, coordinator, producer := newMockTransactionCoordinatorProducer(t)
+	defer broker.Close()
+	defer safeClose(t, producer)
+
+	require.NoError(t, producer.BeginTxn())
+	producer.Input() <- &ProducerMessage{Topic: "test-topic", Value: StringEncoder(TestMessage)}
+	require.NoError(t, producer.AddOffsetsToTxn(map[string][]*PartitionOffsetMetadata{
+		"source": {{Partition: 0, Offset: 42}},
+	}, "my-group"))
+
+	require.Eventually(t, func() bool {
+		return len(coordinator.Partitions("test")) > 0
+	}, time.Second, 10*time.Millisecond)
+	coordinator.Fence("test")
+	err := producer.CommitTxn()
+	require.True(t, errors.Is(err, ErrProducerFenced), "expected a fenced error, got %v", err)
+	require.NotZero(t, producer.TxnStatus()&ProducerTxnFlagFatalError)
+
+	_, epoch, _ := coordinator.ProducerID("test")
+	require.Equal(t, int16(1), epoch)
+	committed, aborted := coordinator.Transactions("test")
+	require.Equal(t, 0, committed)
+	require.Equal(t, 1, aborted)
+	_, ok := coordinator.Offset("my-group", "source", 0)
+	require.False(t, ok)
+}
+
+func TestMockTransactionCoordinatorZombieProducer(t *testing.T) {
+	broker, coordinator, zombie := newMockTransactionCoordinatorProducer(t)
+	defer broker.Close()
+	defer safeClose(t, zombie)
+
+	require.NoError(t, zombie.BeginTxn())
+	zombie.Input() <- &ProducerMessage{Topic: "test-topic", Value: StringEncoder(TestMessage)}
+	require.Eventually(t, func() bool {
+		return len(coordinator.Partitions("test")) > 0
+	}, time.Second, 10*time.Millisecond)
+
+	// a new instance of the producer takes over the transactional ID
+	config := newMockTransactionCoordinatorConfig()
+	config.Producer.Return.Errors = true
+	producer, err := NewAsyncProducer([]string{broker.Addr()}, config)
+	require.NoError(t, err)
+	defer safeClose(t, producer)
+	_, epoch, _ := coordinator.ProducerID("test")
+	require.Equal(t, int16(1), epoch)
+
+	err = zombie.CommitTxn()
+	require.True(t, errors.Is(err, ErrProducerFenced), "expected a fenced error, got %v", err)
+	committed, aborted := coordinator.Transactions("test")
+	require.Equal(t, 0, committed)
+	require.Equal(t, 1, aborted)
+
+	// the batches sent to the partitions already in the transaction of a
+	// fenced producer are fenced by their leader
+	require.NoError(t, producer.BeginTxn())
+	producer.Input() <- &ProducerMessage{Topic: "test-topic", Value: StringEncoder(TestMessage)}
+	require.Eventually(t, func() bool {
+		return len(coordinator.Partitions("test")) > 0
+	}, time.Second, 10*time.Millisecond)
+	require.NoError(t, FenceProducer([]string{broker.Addr()}, config))
+	producer.Input() <- &ProducerMessage{Topic: "test-topic", Value: StringEncoder(TestMessage)}
+	select {
+	case perr := <-producer.Errors():
+		require.True(t, errors.Is(perr.Err, ErrInvalidProducerEpoch), "expected a fenced batch, got %v", perr.Err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the fenced batch")
+	}
+}
+
+func TestFenceProducerRequiresTransactionalID(t *testing.T) {
+	config := NewTestConfig()
+	var confErr ConfigurationError
+	require.True(t, errors.As(FenceProducer([]string{"localhost:9092"}, config), &confErr))
+}
+
+func TestMockTransactionCoordinatorErrors(t *testing.T) {
+	broker := NewMockBroker(t, 1)
+	defer broker.Close()
+	coordinator := NewMockTransactionCoordinator(t, broker)
+
+	end := coordinator.For(&EndTxnRequest{Version: 2, TransactionalID: "test", ProducerID: 1}).(*EndTxnResponse)
+	require.Equal(t, ErrInvalidProducerIDMapping, end.Err)
+
+	id := "test"
+	init := coordinator.For(&InitProducerIDRequest{Version: 4, TransactionalID: &id, ProducerID: -1, ProducerEpoch: -1}).(*InitProducerIDResponse)
+	require.Equal(t, ErrNoError, init.Err)
+
+	end = coordinator.For(&EndTxnRequest{Version: 2, TransactionalID: id, ProducerID: init.ProducerID, ProducerEpoch: init.ProducerEpoch}).(*EndTxnResponse)
+	require.Equal(t, ErrInvalidTxnState, end.Err)
+
+	commit := coordinator.For(&TxnOffsetCommitRequest{
+		Version: 3, TransactionalID: id, GroupID: "my-group", ProducerID: init.ProducerID, ProducerEpoch: init.ProducerEpoch,
+		Topics: map[string][]*PartitionOffsetMetadata{"source": {{Partition: 0, Offset: 1}}},
+	}).(*TxnOffsetCommitResponse)
+	require.Equal(t, ErrInvalidTxnState, commit.Topics["source"][0].Err)
+
+	coordinator.Expire(id)
+	add := coordinator.For(&AddPartitionsToTxnRequest{
+		Version: 1, TransactionalID: id, ProducerID: init.ProducerID, ProducerEpoch: init.ProducerEpoch,
+		TopicPartitions: map[string][]int32{"test-topic": {0}},
+	}).(*AddPartitionsToTxnResponse)
+	require.Equal(t, ErrInvalidProducerEpoch, add.Errors["test-topic"][0].Err)
+
+	coordinator.SetError("InitProducerIDRequest", ErrConcurrentTransactions)
+	init = coordinator.For(&InitProducerIDRequest{Version: 4, TransactionalID: &id, ProducerID: init.ProducerID, ProducerEpoch: init.ProducerEpoch}).(*InitProducerIDResponse)
+	require.Equal(t, ErrConcurrentTransactions, init.Err)
+	init = coordinator.For(&InitProducerIDRequest{Version: 4, TransactionalID: &id, ProducerID: -1, ProducerEpoch: -1}).(*InitProducerIDResponse)
+	require.Equal(t, ErrNoError, init.Err)
+	require.Equal(t, int16(2), init.ProducerEpoch)
+}
@@ -2,6 +2,7 @@ package sarama
 
 import (
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -23,6 +24,35 @@ type OffsetManager interface {
 	// Commit commits the offsets. This method can be used if AutoCommit.Enable is
 	// set to false.
 	Commit()
+
+	// GroupMetadata returns the group membership the offsets are committed with.
+	GroupMetadata() ConsumerGroupMetadata
+
+	// SetGroupMetadata changes the group membership the offsets are committed
+	// with, for instance after the external group implementation of the consumer
+	// completed a rebalance. The offsets marked but not committed yet are
+	// committed with the new membership. It returns an error if metadata is not
+	// for the group of the OffsetManager.
+	SetGroupMetadata(metadata ConsumerGroupMetadata) error
+}
+
+// ConsumerGroupMetadata is the membership of a consumer in its group, which the
+// group coordinator checks the offset commits of the consumer against. The
+// offsets of a consumer which is not a member of the group, or of a group
+// managed outside of Kafka, are committed with the GenerationID
+// GroupGenerationUndefined and an empty MemberID, as NewSimpleConsumerGroupMetadata
+// returns.
+type ConsumerGroupMetadata struct {
+	GroupID         string
+	GenerationID    int32
+	MemberID        string
+	GroupInstanceID *string
+}
+
+// NewSimpleConsumerGroupMetadata returns the ConsumerGroupMetadata of a consumer
+// committing the offsets of group without being a member of it.
+func NewSimpleConsumerGroupMetadata(group string) ConsumerGroupMetadata {
+	return ConsumerGroupMetadata{GroupID: group, GenerationID: GroupGenerationUndefined}
 }
 
 type offsetManager struct {
@@ -32,9 +62,8 @@ type offsetManager struct {
 	ticker          *time.Ticker
 	sessionCanceler func()
 
-	memberID        string
-	groupInstanceId *string
-	generation      int32
+	metadata     ConsumerGroupMetadata
+	metadataLock sync.RWMutex
 
 	broker     *Broker
 	brokerLock sync.RWMutex
@@ -42,6 +71,11 @@ type offsetManager struct {
 	poms     map[string]map[int32]*partitionOffsetManager
 	pomsLock sync.RWMutex
 
+	// pending is the number of partitions marked since the last commit,
+	// which is triggered by flush once it reaches AutoCommit.MaxPending
+	pending int32 // accessed atomically
+	flush   chan none
+
 	closeOnce sync.Once
 	closing   chan none
 	closed    chan none
@@ -53,6 +87,24 @@ func NewOffsetManagerFromClient(group string, client Client) (OffsetManager, err
 	return newOffsetManagerFromClient(group, "", GroupGenerationUndefined, client, nil)
 }
 
+// NewOffsetManagerWithGroupMetadata creates a new OffsetManager from the given
+// client, which commits the offsets of metadata.GroupID with the membership in
+// metadata rather than as a simple consumer. This lets a group implemented
+// outside of Kafka, or a checkpointing framework, store its offsets in Kafka;
+// SetGroupMetadata updates the membership after a rebalance.
+// It is still necessary to call Close() on the underlying client when finished with the partition manager.
+func NewOffsetManagerWithGroupMetadata(metadata ConsumerGroupMetadata, client Client) (OffsetManager, error) {
+	if metadata.GroupID == "" {
+		return nil, ConfigurationError("ConsumerGroupMetadata.GroupID must not be empty")
+	}
+	om, err := newOffsetManagerFromClient(metadata.GroupID, metadata.MemberID, metadata.GenerationID, client, nil)
+	if err != nil {
+		return nil, err
+	}
+	om.metadata.GroupInstanceID = metadata.GroupInstanceID
+	return om, nil
+}
+
 func newOffsetManagerFromClient(group, memberID string, generation int32, client Client, sessionCanceler func()) (*offsetManager, error) {
 	// Check that we are not dealing with a closed Client before processing any other arguments
 	if client.Closed() {
@@ -67,14 +119,18 @@ func newOffsetManagerFromClient(group, memberID string, generation int32, client
 		poms:            make(map[string]map[int32]*partitionOffsetManager),
 		sessionCanceler: sessionCanceler,
 
-		memberID:   memberID,
-		generation: generation,
+		metadata: ConsumerGroupMetadata{
+			GroupID:      group,
+			GenerationID: generation,
+			MemberID:     memberID,
+		},
 
+		flush:   make(chan none, 1),
 		closing: make(chan none),
 		closed:  make(chan none),
 	}
 	if conf.Consumer.Group.InstanceId != "" {
-		om.groupInstanceId = &conf.Consumer.Group.InstanceId
+		om.metadata.GroupInstanceID = &conf.Consumer.Group.InstanceId
 	}
 	if conf.Consumer.Offsets.AutoCommit.Enable {
 		om.ticker = time.NewTicker(conf.Consumer.Offsets.AutoCommit.Interval)
@@ -84,6 +140,26 @@ func newOffsetManagerFromClient(group, memberID string, generation int32, client
 	return om, nil
 }
 
+func (om *offsetManager) GroupMetadata() ConsumerGroupMetadata {
+	om.metadataLock.RLock()
+	defer om.metadataLock.RUnlock()
+	return om.metadata
+}
+
+func (om *offsetManager) SetGroupMetadata(metadata ConsumerGroupMetadata) error {
+	if metadata.GroupID != om.group {
+		return ConfigurationError("ConsumerGroupMetadata.GroupID must be the group of the OffsetManager")
+	}
+	om.metadataLock.Lock()
+	defer om.metadataLock.Unlock()
+	om.metadata = metadata
+	return nil
+}
+
+func (om *offsetManager) ManageTopicPartition(tp TopicPartitionID) (PartitionOffsetManager, error) {
+	return om.ManagePartition(tp.Topic, tp.Partition)
+}
+
 func (om *offsetManager) ManagePartition(topic string, partition int32) (PartitionOffsetManager, error) {
 	pom, err := om.newPartitionOffsetManager(topic, partition)
 	if err != nil {
@@ -175,7 +251,7 @@ func (om *offsetManager) fetchInitialOffset(topic string, partition int32, retri
 	switch block.Err {
 	case ErrNoError:
 		return block.Offset, block.LeaderEpoch, block.Metadata, nil
-	case ErrNotCoordinatorForConsumer:
+	case ErrNotCoordinatorForConsumer, ErrConsumerCoordinatorNotAvailable:
 		if retries <= 0 {
 			return 0, 0, "", block.Err
 		}
@@ -230,6 +306,7 @@ func (om *offsetManager) releaseCoordinator(b *Broker) {
 	om.brokerLock.Lock()
 	if om.broker == b {
 		om.broker = nil
+		invalidateCoordinator(om.client, om.group)
 	}
 	om.brokerLock.Unlock()
 }
@@ -242,6 +319,9 @@ func (om *offsetManager) mainLoop() {
 		select {
 		case <-om.ticker.C:
 			om.Commit()
+		case <-om.flush:
+			om.Commit()
+			om.ticker.Reset(om.conf.Consumer.Offsets.AutoCommit.Interval)
 		case <-om.closing:
 			return
 		}
@@ -253,7 +333,23 @@ func (om *offsetManager) Commit() {
 	om.releasePOMs(false)
 }
 
+// marked counts a partition marked since the last commit, triggering the
+// commit once AutoCommit.MaxPending are.
+func (om *offsetManager) marked() {
+	maxPending := om.conf.Consumer.Offsets.AutoCommit.MaxPending
+	if !om.conf.Consumer.Offsets.AutoCommit.Enable || maxPending <= 0 {
+		return
+	}
+	if atomic.AddInt32(&om.pending, 1) == int32(maxPending) {
+		select {
+		case om.flush <- none{}:
+		default:
+		}
+	}
+}
+
 func (om *offsetManager) flushToBroker() {
+	atomic.StoreInt32(&om.pending, 0)
 	req := om.constructRequest()
 	if req == nil {
 		return
@@ -277,11 +373,12 @@ func (om *offsetManager) flushToBroker() {
 }
 
 func (om *offsetManager) constructRequest() *OffsetCommitRequest {
+	metadata := om.GroupMetadata()
 	r := &OffsetCommitRequest{
 		Version:                 1,
 		ConsumerGroup:           om.group,
-		ConsumerID:              om.memberID,
-		ConsumerGroupGeneration: om.generation,
+		ConsumerID:              metadata.MemberID,
+		ConsumerGroupGeneration: metadata.GenerationID,
 	}
 	// Version 1 adds timestamp and group membership information, as well as the commit timestamp.
 	//
@@ -305,7 +402,7 @@ func (om *offsetManager) constructRequest() *OffsetCommitRequest {
 	// version 7 adds a new field called groupInstanceId to indicate member identity across restarts.
 	if om.conf.Version.IsAtLeast(V2_3_0_0) {
 		r.Version = 7
-		r.GroupInstanceId = om.groupInstanceId
+		r.GroupInstanceId = metadata.GroupInstanceID
 	}
 
 	// commit timestamp was only briefly supported in V1 where we set it to
@@ -529,6 +626,11 @@ type partitionOffsetManager struct {
 	dirty    bool
 	done     bool
 
+	// committedOffset and committedMetadata are the last ones committed, or
+	// fetched, which are not committed again
+	committedOffset   int64
+	committedMetadata string
+
 	releaseOnce sync.Once
 	errors      chan *ConsumerError
 }
@@ -547,6 +649,9 @@ func (om *offsetManager) newPartitionOffsetManager(topic string, partition int32
 		errors:      make(chan *ConsumerError, om.conf.ChannelBufferSize),
 		offset:      offset,
 		metadata:    metadata,
+
+		committedOffset:   offset,
+		committedMetadata: metadata,
 	}, nil
 }
 
@@ -559,9 +664,7 @@ func (pom *partitionOffsetManager) MarkOffset(offset int64, metadata string) {
 	defer pom.lock.Unlock()
 
 	if offset > pom.offset {
-		pom.offset = offset
-		pom.metadata = metadata
-		pom.dirty = true
+		pom.mark(offset, metadata)
 	}
 }
 
@@ -570,9 +673,19 @@ func (pom *partitionOffsetManager) ResetOffset(offset int64, metadata string) {
 	defer pom.lock.Unlock()
 
 	if offset <= pom.offset {
-		pom.offset = offset
-		pom.metadata = metadata
-		pom.dirty = true
+		pom.mark(offset, metadata)
+	}
+}
+
+// mark sets the offset to commit, unless it is the one already committed.
+// pom.lock must be held by the caller.
+func (pom *partitionOffsetManager) mark(offset int64, metadata string) {
+	pom.offset = offset
+	pom.metadata = metadata
+	wasDirty := pom.dirty
+	pom.dirty = offset != pom.committedOffset || metadata != pom.committedMetadata
+	if pom.dirty && !wasDirty {
+		pom.parent.marked()
 	}
 }
 
@@ -580,6 +693,8 @@ func (pom *partitionOffsetManager) updateCommitted(offset int64, metadata string
 	pom.lock.Lock()
 	defer pom.lock.Unlock()
 
+	pom.committedOffset = offset
+	pom.committedMetadata = metadata
 	if pom.offset == offset && pom.metadata == metadata {
 		pom.dirty = false
 	}
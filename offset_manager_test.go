@@ -572,3 +572,199 @@ func TestAbortPartitionOffsetManager(t *testing.T) {
 	safeClose(t, om)
 	safeClose(t, testClient)
 }
+
+func TestPartitionOffsetManagerSkipsCommittedOffset(t *testing.T) {
+	config := NewTestConfig()
+	config.Consumer.Offsets.AutoCommit.Enable = false
+	om, testClient, broker, coordinator := initOffsetManagerWithBackoffFunc(t, 0, nil, config)
+	defer broker.Close()
+	defer coordinator.Close()
+	pom := initPartitionOffsetManager(t, om, coordinator, 5, "original_meta")
+
+	var commits int32
+	ocResponse := new(OffsetCommitResponse)
+	ocResponse.AddError("my_topic", 0, ErrNoError)
+	coordinator.setHandler(func(req *request) (res encoderWithHeader) {
+		atomic.AddInt32(&commits, 1)
+		return ocResponse
+	})
+
+	// resetting to the committed offset does not commit it again
+	pom.MarkOffset(10, "modified_meta")
+	pom.ResetOffset(5, "original_meta")
+	om.Commit()
+	if n := atomic.LoadInt32(&commits); n != 0 {
+		t.Errorf("expected no commit of the committed offset, got %d", n)
+	}
+
+	pom.ResetOffset(4, "original_meta")
+	om.Commit()
+	pom.ResetOffset(4, "original_meta")
+	om.Commit()
+	if n := atomic.LoadInt32(&commits); n != 1 {
+		t.Errorf("expected a single commit of the new offset, got %d", n)
+	}
+
+	safeClose(t, om)
+	safeClose(t, pom)
+	safeClose(t, testClient)
+}
+
+func TestOffsetManagerAutoCommitMaxPending(t *testing.T) {
+	broker := NewMockBroker(t, 1)
+	defer broker.Close()
+	coordinator := NewMockBroker(t, 2)
+	defer coordinator.Close()
+
+	seedMeta := new(MetadataResponse)
+	seedMeta.AddBroker(coordinator.Addr(), coordinator.BrokerID())
+	seedMeta.AddTopicPartition("my_topic", 0, 1, []int32{}, []int32{}, []int32{}, ErrNoError)
+	seedMeta.AddTopicPartition("my_topic", 1, 1, []int32{}, []int32{}, []int32{}, ErrNoError)
+	broker.Returns(seedMeta)
+
+	config := NewTestConfig()
+	config.Version = V0_9_0_0
+	config.Consumer.Offsets.AutoCommit.Interval = time.Hour
+	config.Consumer.Offsets.AutoCommit.MaxPending = 2
+	testClient, err := NewClient([]string{broker.Addr()}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer safeClose(t, testClient)
+
+	broker.Returns(&ConsumerMetadataResponse{
+		CoordinatorID:   coordinator.BrokerID(),
+		CoordinatorHost: "127.0.0.1",
+		CoordinatorPort: coordinator.Port(),
+	})
+	om, err := NewOffsetManagerFromClient("group", testClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	poms := make([]PartitionOffsetManager, 2)
+	for partition := range poms {
+		fetchResponse := new(OffsetFetchResponse)
+		fetchResponse.AddBlock("my_topic", int32(partition), &OffsetFetchResponseBlock{Err: ErrNoError, Offset: 5})
+		coordinator.Returns(fetchResponse)
+		if poms[partition], err = om.ManagePartition("my_topic", int32(partition)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ocResponse := new(OffsetCommitResponse)
+	ocResponse.AddError("my_topic", 0, ErrNoError)
+	ocResponse.AddError("my_topic", 1, ErrNoError)
+	committed := make(chan *OffsetCommitRequest, 1)
+	coordinator.setHandler(func(req *request) (res encoderWithHeader) {
+		committed <- req.body.(*OffsetCommitRequest)
+		return ocResponse
+	})
+
+	poms[0].MarkOffset(10, "")
+	select {
+	case <-committed:
+		t.Fatal("expected no commit before 2 partitions are marked")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	poms[0].MarkOffset(11, "")
+	poms[1].MarkOffset(20, "")
+	select {
+	case req := <-committed:
+		if len(req.blocks["my_topic"]) != 2 || req.blocks["my_topic"][0].offset != 11 || req.blocks["my_topic"][1].offset != 20 {
+			t.Errorf("expected a single commit of both partitions, got %+v", req.blocks["my_topic"])
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected a commit once 2 partitions are marked")
+	}
+
+	safeClose(t, om)
+	for _, pom := range poms {
+		safeClose(t, pom)
+	}
+}
+
+func TestOffsetManagerWithGroupMetadata(t *testing.T) {
+	broker := NewMockBroker(t, 1)
+	defer broker.Close()
+	coordinator := NewMockBroker(t, 2)
+	defer coordinator.Close()
+
+	seedMeta := new(MetadataResponse)
+	seedMeta.AddBroker(coordinator.Addr(), coordinator.BrokerID())
+	seedMeta.AddTopicPartition("my_topic", 0, 1, []int32{}, []int32{}, []int32{}, ErrNoError)
+	broker.Returns(seedMeta)
+
+	config := NewTestConfig()
+	config.Version = V0_9_0_0
+	config.Consumer.Offsets.AutoCommit.Enable = false
+	testClient, err := NewClient([]string{broker.Addr()}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer safeClose(t, testClient)
+
+	if _, err := NewOffsetManagerWithGroupMetadata(ConsumerGroupMetadata{}, testClient); err == nil {
+		t.Error("expected an error for a metadata without group")
+	}
+
+	broker.Returns(&ConsumerMetadataResponse{
+		CoordinatorID:   coordinator.BrokerID(),
+		CoordinatorHost: "127.0.0.1",
+		CoordinatorPort: coordinator.Port(),
+	})
+	metadata := ConsumerGroupMetadata{GroupID: "group", GenerationID: 3, MemberID: "member-1"}
+	om, err := NewOffsetManagerWithGroupMetadata(metadata, testClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if om.GroupMetadata() != metadata {
+		t.Errorf("expected group metadata %+v, got %+v", metadata, om.GroupMetadata())
+	}
+
+	fetchResponse := new(OffsetFetchResponse)
+	fetchResponse.AddBlock("my_topic", 0, &OffsetFetchResponseBlock{Err: ErrNoError, Offset: 5})
+	coordinator.Returns(fetchResponse)
+	pom, err := om.ManagePartition("my_topic", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ocResponse := new(OffsetCommitResponse)
+	ocResponse.AddError("my_topic", 0, ErrNoError)
+	committed := make(chan *OffsetCommitRequest, 1)
+	coordinator.setHandler(func(req *request) (res encoderWithHeader) {
+		committed <- req.body.(*OffsetCommitRequest)
+		return ocResponse
+	})
+	expectCommit := func(memberID string, generation int32) {
+		t.Helper()
+		select {
+		case req := <-committed:
+			if req.ConsumerID != memberID || req.ConsumerGroupGeneration != generation {
+				t.Errorf("expected a commit of %s in generation %d, got %s in generation %d",
+					memberID, generation, req.ConsumerID, req.ConsumerGroupGeneration)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("expected a commit")
+		}
+	}
+
+	pom.MarkOffset(10, "")
+	om.Commit()
+	expectCommit("member-1", 3)
+
+	if err := om.SetGroupMetadata(NewSimpleConsumerGroupMetadata("other")); err == nil {
+		t.Error("expected an error for the metadata of another group")
+	}
+	if err := om.SetGroupMetadata(NewSimpleConsumerGroupMetadata("group")); err != nil {
+		t.Fatal(err)
+	}
+	pom.MarkOffset(11, "")
+	om.Commit()
+	expectCommit("", GroupGenerationUndefined)
+
+	safeClose(t, om)
+	safeClose(t, pom)
+}
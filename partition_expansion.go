@@ -0,0 +1,73 @@
+package sarama
+
+import (
+	"fmt"
+	"time"
+)
+
+// PartitionExpansionPolicy is how the producers react to new partitions of a
+// topic, as set by Config.Producer.PartitionExpansion.Policy. Adding
+// partitions changes the partition of most keys with the hash partitioners,
+// so that the consumers may process the messages of a key out of order while
+// they catch up with the old partitions.
+type PartitionExpansionPolicy int
+
+const (
+	// PartitionExpansionImmediate partitions the messages among the new
+	// partitions as soon as the metadata of the producer knows them.
+	PartitionExpansionImmediate PartitionExpansionPolicy = iota
+	// PartitionExpansionDelay keeps partitioning the messages among the
+	// previous partitions for Config.Producer.PartitionExpansion.Delay after
+	// the producer notices the new ones, to let the consumers catch up.
+	PartitionExpansionDelay
+	// PartitionExpansionPin keeps partitioning the messages among the
+	// partitions of the topic when the producer first sent to it, until the
+	// producer is restarted.
+	PartitionExpansionPin
+)
+
+func (p PartitionExpansionPolicy) String() string {
+	switch p {
+	case PartitionExpansionImmediate:
+		return "Immediate"
+	case PartitionExpansionDelay:
+		return "Delay"
+	case PartitionExpansionPin:
+		return "Pin"
+	default:
+		return fmt.Sprintf("PartitionExpansionPolicy(%d)", int(p))
+	}
+}
+
+// partitionLimit returns the number of partitions of the topic the messages
+// may be partitioned among, out of the total known by the metadata, following
+// the expansion policy.
+func (tp *topicProducer) partitionLimit(total int32) int32 {
+	expansion := tp.parent.conf.Producer.PartitionExpansion
+	switch {
+	case expansion.Policy == PartitionExpansionImmediate || tp.partitionCount == 0 || total < tp.partitionCount:
+		// the partitions which disappeared can not be used anyway
+		tp.partitionCount = total
+		tp.expandAt = time.Time{}
+	case total == tp.partitionCount:
+		tp.expandAt = time.Time{}
+	case expansion.Policy == PartitionExpansionDelay:
+		if tp.expandAt.IsZero() {
+			tp.expandAt = time.Now().Add(expansion.Delay)
+			Logger.Printf("producer/topic/%s expanded from %d to %d partitions, using them in %s\n",
+				tp.topic, tp.partitionCount, total, expansion.Delay)
+		} else if !time.Now().Before(tp.expandAt) {
+			Logger.Printf("producer/topic/%s using its %d partitions\n", tp.topic, total)
+			tp.partitionCount = total
+			tp.expandAt = time.Time{}
+		}
+	case expansion.Policy == PartitionExpansionPin:
+		if tp.expandAt.IsZero() {
+			// only used to log the expansion once
+			tp.expandAt = time.Now()
+			Logger.Printf("producer/topic/%s expanded from %d to %d partitions, pinned to %d until restart\n",
+				tp.topic, tp.partitionCount, total, tp.partitionCount)
+		}
+	}
+	return tp.partitionCount
+}
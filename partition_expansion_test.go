@@ -0,0 +1,91 @@
+package sarama
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPartitionExpansionPolicies(t *testing.T) {
+	tests := []struct {
+		policy PartitionExpansionPolicy
+		// the limits for totals of 4, 6, 6 after the delay, then 3 partitions
+		expected [4]int32
+	}{
+		{PartitionExpansionImmediate, [4]int32{4, 6, 6, 3}},
+		{PartitionExpansionDelay, [4]int32{4, 4, 6, 3}},
+		{PartitionExpansionPin, [4]int32{4, 4, 4, 3}},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.policy.String(), func(t *testing.T) {
+			config := NewTestConfig()
+			config.Producer.PartitionExpansion.Policy = test.policy
+			config.Producer.PartitionExpansion.Delay = time.Hour
+			tp := &topicProducer{parent: &asyncProducer{conf: config}, topic: "my_topic"}
+
+			var limits [4]int32
+			limits[0] = tp.partitionLimit(4)
+			limits[1] = tp.partitionLimit(6)
+			if !tp.expandAt.IsZero() {
+				tp.expandAt = time.Now().Add(-time.Second)
+			}
+			limits[2] = tp.partitionLimit(6)
+			limits[3] = tp.partitionLimit(3)
+			if limits != test.expected {
+				t.Errorf("expected the limits %v, got %v", test.expected, limits)
+			}
+		})
+	}
+}
+
+func TestAsyncProducerPartitionExpansionPin(t *testing.T) {
+	seedBroker := NewMockBroker(t, 1)
+	defer seedBroker.Close()
+	leader := NewMockBroker(t, 2)
+	defer leader.Close()
+
+	metadataResponse := NewMockMetadataResponse(t).
+		SetBroker(leader.Addr(), leader.BrokerID()).
+		SetLeader("my_topic", 0, leader.BrokerID())
+	seedBroker.SetHandlerByMap(map[string]MockResponse{"MetadataRequest": metadataResponse})
+	leader.SetHandlerByMap(map[string]MockResponse{
+		"MetadataRequest": metadataResponse,
+		"ProduceRequest":  NewMockProduceResponse(t).SetError("my_topic", 0, ErrNoError).SetError("my_topic", 1, ErrNoError),
+	})
+
+	config := NewTestConfig()
+	config.Producer.Return.Successes = true
+	config.Producer.Partitioner = NewRoundRobinPartitioner
+	config.Producer.PartitionExpansion.Policy = PartitionExpansionPin
+	producer, err := NewAsyncProducer([]string{seedBroker.Addr()}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeProducer(t, producer)
+
+	produce := func() int32 {
+		t.Helper()
+		producer.Input() <- &ProducerMessage{Topic: "my_topic", Value: StringEncoder(TestMessage)}
+		select {
+		case msg := <-producer.Errors():
+			t.Fatal(msg.Err)
+		case msg := <-producer.Successes():
+			return msg.Partition
+		}
+		return -1
+	}
+	if partition := produce(); partition != 0 {
+		t.Fatalf("expected partition 0, got %d", partition)
+	}
+
+	metadataResponse.SetLeader("my_topic", 1, leader.BrokerID())
+	client := producer.(*asyncProducer).client
+	if err := client.RefreshMetadata("my_topic"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if partition := produce(); partition != 0 {
+			t.Errorf("expected the producer to stay pinned to partition 0, got %d", partition)
+		}
+	}
+}
@@ -213,11 +213,13 @@ func (r *ProduceRequest) headerVersion() int16 {
 }
 
 func (r *ProduceRequest) isValidVersion() bool {
-	return r.Version >= 0 && r.Version <= 7
+	return r.Version >= 0 && r.Version <= 8
 }
 
 func (r *ProduceRequest) requiredVersion() KafkaVersion {
 	switch r.Version {
+	case 8:
+		return V2_4_0_0
 	case 7:
 		return V2_1_0_0
 	case 6:
@@ -233,7 +235,7 @@ func (r *ProduceRequest) requiredVersion() KafkaVersion {
 	case 0:
 		return V0_8_2_0
 	default:
-		return V2_1_0_0
+		return V2_4_0_0
 	}
 }
 
@@ -9,23 +9,36 @@ import (
 // v1
 // v2 = v3 = v4
 // v5 = v6 = v7
-// Produce Response (Version: 7) => [responses] throttle_time_ms
+// Produce Response (Version: 8) => [responses] throttle_time_ms
 //   responses => topic [partition_responses]
 //     topic => STRING
-//     partition_responses => partition error_code base_offset log_append_time log_start_offset
+//     partition_responses => partition error_code base_offset log_append_time log_start_offset [record_errors] error_message
 //       partition => INT32
 //       error_code => INT16
 //       base_offset => INT64
 //       log_append_time => INT64
 //       log_start_offset => INT64
+//       record_errors => batch_index batch_index_error_message
+//         batch_index => INT32
+//         batch_index_error_message => NULLABLE_STRING
+//       error_message => NULLABLE_STRING
 //   throttle_time_ms => INT32
 
 // partition_responses in protocol
 type ProduceResponseBlock struct {
-	Err         KError    // v0, error_code
-	Offset      int64     // v0, base_offset
-	Timestamp   time.Time // v2, log_append_time, and the broker is configured with `LogAppendTime`
-	StartOffset int64     // v5, log_start_offset
+	Err          KError                        // v0, error_code
+	Offset       int64                         // v0, base_offset
+	Timestamp    time.Time                     // v2, log_append_time, and the broker is configured with `LogAppendTime`
+	StartOffset  int64                         // v5, log_start_offset
+	RecordErrors []*ProduceResponseRecordError // v8, record_errors
+	ErrorMessage *string                       // v8, error_message
+}
+
+// ProduceResponseRecordError is a record which caused its batch to be
+// rejected (KIP-467), in record_errors.
+type ProduceResponseRecordError struct {
+	BatchIndex             int32   // v8, batch_index, the index of the record in its batch
+	BatchIndexErrorMessage *string // v8, batch_index_error_message
 }
 
 func (b *ProduceResponseBlock) decode(pd packetDecoder, version int16) (err error) {
@@ -55,6 +68,29 @@ func (b *ProduceResponseBlock) decode(pd packetDecoder, version int16) (err erro
 		}
 	}
 
+	if version >= 8 {
+		numRecordErrors, err := pd.getArrayLength()
+		if err != nil {
+			return err
+		}
+		if numRecordErrors > 0 {
+			b.RecordErrors = make([]*ProduceResponseRecordError, numRecordErrors)
+			for i := range b.RecordErrors {
+				recordError := new(ProduceResponseRecordError)
+				if recordError.BatchIndex, err = pd.getInt32(); err != nil {
+					return err
+				}
+				if recordError.BatchIndexErrorMessage, err = pd.getNullableString(); err != nil {
+					return err
+				}
+				b.RecordErrors[i] = recordError
+			}
+		}
+		if b.ErrorMessage, err = pd.getNullableString(); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -76,6 +112,21 @@ func (b *ProduceResponseBlock) encode(pe packetEncoder, version int16) (err erro
 		pe.putInt64(b.StartOffset)
 	}
 
+	if version >= 8 {
+		if err := pe.putArrayLength(len(b.RecordErrors)); err != nil {
+			return err
+		}
+		for _, recordError := range b.RecordErrors {
+			pe.putInt32(recordError.BatchIndex)
+			if err := pe.putNullableString(recordError.BatchIndexErrorMessage); err != nil {
+				return err
+			}
+		}
+		if err := pe.putNullableString(b.ErrorMessage); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -176,11 +227,13 @@ func (r *ProduceResponse) headerVersion() int16 {
 }
 
 func (r *ProduceResponse) isValidVersion() bool {
-	return r.Version >= 0 && r.Version <= 7
+	return r.Version >= 0 && r.Version <= 8
 }
 
 func (r *ProduceResponse) requiredVersion() KafkaVersion {
 	switch r.Version {
+	case 8:
+		return V2_4_0_0
 	case 7:
 		return V2_1_0_0
 	case 6:
@@ -196,7 +249,7 @@ func (r *ProduceResponse) requiredVersion() KafkaVersion {
 	case 0:
 		return V0_8_2_0
 	default:
-		return V2_1_0_0
+		return V2_4_0_0
 	}
 }
 
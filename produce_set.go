@@ -10,6 +10,18 @@ type partitionSet struct {
 	msgs          []*ProducerMessage
 	recordsToSend Records
 	bufferBytes   int
+	// batchLimit is the smallest batchLimit of the messages, if any
+	batchLimit int
+}
+
+// exceedsBatchLimit returns whether adding msg to the set would exceed the
+// batchLimit of its messages or of msg.
+func (set *partitionSet) exceedsBatchLimit(msg *ProducerMessage) bool {
+	limit := set.batchLimit
+	if msg.batchLimit > 0 && (limit == 0 || msg.batchLimit < limit) {
+		limit = msg.batchLimit
+	}
+	return limit > 0 && len(set.msgs) >= limit
 }
 
 type produceSet struct {
@@ -20,6 +32,7 @@ type produceSet struct {
 
 	bufferBytes int
 	bufferCount int
+	splitCount  int // the messages of batches split because they were too large
 }
 
 func newProduceSet(parent *asyncProducer) *produceSet {
@@ -54,6 +67,12 @@ func (ps *produceSet) add(msg *ProducerMessage) error {
 	}
 	timestamp = timestamp.Truncate(time.Millisecond)
 
+	if ps.empty() && msg.hasSequence {
+		// the batches are sent with the producer ID and epoch the sequence
+		// numbers of their messages were generated for
+		ps.producerID, ps.producerEpoch = msg.producerID, msg.producerEpoch
+	}
+
 	partitions := ps.msgs[msg.Topic]
 	if partitions == nil {
 		partitions = make(map[int32]*partitionSet)
@@ -92,6 +111,12 @@ func (ps *produceSet) add(msg *ProducerMessage) error {
 
 	// Past this point we can't return an error, because we've already added the message to the set.
 	set.msgs = append(set.msgs, msg)
+	if msg.batchLimit > 0 {
+		if set.batchLimit == 0 || msg.batchLimit < set.batchLimit {
+			set.batchLimit = msg.batchLimit
+		}
+		ps.splitCount++
+	}
 
 	if ps.parent.conf.Version.IsAtLeast(V0_11_0_0) {
 		// We are being conservative here to avoid having to prep encode the record
@@ -150,6 +175,10 @@ func (ps *produceSet) buildRequest() *ProduceRequest {
 	if ps.parent.conf.Version.IsAtLeast(V2_1_0_0) {
 		req.Version = 7
 	}
+	// Version 8 returns the errors of the records rejecting their batch (KIP-467).
+	if ps.parent.conf.Version.IsAtLeast(V2_4_0_0) {
+		req.Version = 8
+	}
 
 	for topic, partitionSets := range ps.msgs {
 		for partition, set := range partitionSets {
@@ -217,6 +246,32 @@ func (ps *produceSet) buildRequest() *ProduceRequest {
 	return req
 }
 
+// updateQueueWaitTime records how long the oldest message of each batch
+// waited in its broker producer before being sent.
+func (ps *produceSet) updateQueueWaitTime() {
+	registry := ps.parent.metricsRegistry
+	now := time.Now()
+	for topic, partitionSets := range ps.msgs {
+		for partition, set := range partitionSets {
+			if len(set.msgs) == 0 || set.msgs[0].enqueued.IsZero() {
+				continue
+			}
+			waitTime := int64(now.Sub(set.msgs[0].enqueued) / time.Millisecond)
+			getOrRegisterHistogram("queue-wait-time-in-ms", registry).Update(waitTime)
+			getOrRegisterPartitionHistogram("queue-wait-time-in-ms", topic, partition, registry).Update(waitTime)
+		}
+	}
+}
+
+// sameProducer returns false if msg was sequenced for another producer ID or
+// epoch than the messages of the set, which must then be sent first.
+func (ps *produceSet) sameProducer(msg *ProducerMessage) bool {
+	if !msg.hasSequence || ps.producerID == noProducerID {
+		return true
+	}
+	return ps.producerID == msg.producerID && ps.producerEpoch == msg.producerEpoch
+}
+
 func (ps *produceSet) eachPartition(cb func(topic string, partition int32, pSet *partitionSet)) {
 	for topic, partitionSet := range ps.msgs {
 		for partition, set := range partitionSet {
@@ -235,6 +290,11 @@ func (ps *produceSet) dropPartition(topic string, partition int32) []*ProducerMe
 	}
 	ps.bufferBytes -= set.bufferBytes
 	ps.bufferCount -= len(set.msgs)
+	for _, msg := range set.msgs {
+		if msg.batchLimit > 0 {
+			ps.splitCount--
+		}
+	}
 	delete(ps.msgs[topic], partition)
 	return set.msgs
 }
@@ -256,6 +316,10 @@ func (ps *produceSet) wouldOverflow(msg *ProducerMessage) bool {
 	// Would we overflow simply in number of messages?
 	case ps.parent.conf.Producer.Flush.MaxMessages > 0 && ps.bufferCount >= ps.parent.conf.Producer.Flush.MaxMessages:
 		return true
+	// Would we overflow the batch of a partition split because it was too large?
+	case ps.msgs[msg.Topic] != nil && ps.msgs[msg.Topic][msg.Partition] != nil &&
+		ps.msgs[msg.Topic][msg.Partition].exceedsBatchLimit(msg):
+		return true
 	default:
 		return false
 	}
@@ -269,6 +333,9 @@ func (ps *produceSet) readyToFlush() bool {
 	// If all three config values are 0, we always flush as-fast-as-possible
 	case ps.parent.conf.Producer.Flush.Frequency == 0 && ps.parent.conf.Producer.Flush.Bytes == 0 && ps.parent.conf.Producer.Flush.Messages == 0:
 		return true
+	// If we hold the splits of a batch too large, which already waited for the triggers
+	case ps.splitCount > 0:
+		return true
 	// If we've passed the message trigger-point
 	case ps.parent.conf.Producer.Flush.Messages > 0 && ps.bufferCount >= ps.parent.conf.Producer.Flush.Messages:
 		return true
@@ -283,3 +350,121 @@ func (ps *produceSet) readyToFlush() bool {
 func (ps *produceSet) empty() bool {
 	return ps.bufferCount == 0
 }
+
+// pendingSet holds by partition the messages that did not fit in the buffer of
+// a brokerProducer, so that a partition whose batch is full does not hold up
+// the other partitions of the broker until the buffer is flushed.
+type pendingSet struct {
+	parent *asyncProducer
+	msgs   map[topicPartition][]*ProducerMessage
+	bytes  map[topicPartition]int
+	// order is the round-robin order in which the partitions are drained.
+	order []topicPartition
+	count int
+}
+
+func newPendingSet(parent *asyncProducer) *pendingSet {
+	return &pendingSet{
+		parent: parent,
+		msgs:   make(map[topicPartition][]*ProducerMessage),
+		bytes:  make(map[topicPartition]int),
+	}
+}
+
+func (ps *pendingSet) byteSize(msg *ProducerMessage) int {
+	if ps.parent.conf.Version.IsAtLeast(V0_11_0_0) {
+		return msg.ByteSize(2)
+	}
+	return msg.ByteSize(1)
+}
+
+// holds returns true if messages of the partition of msg are pending, in
+// which case msg must be queued behind them.
+func (ps *pendingSet) holds(msg *ProducerMessage) bool {
+	return len(ps.msgs[topicPartition{msg.Topic, msg.Partition}]) > 0
+}
+
+// full returns true if the partition of msg already has a full batch pending,
+// which caps the messages held for a single partition.
+func (ps *pendingSet) full(msg *ProducerMessage) bool {
+	tp := topicPartition{msg.Topic, msg.Partition}
+	return len(ps.msgs[tp]) > 0 && ps.bytes[tp]+ps.byteSize(msg) >= ps.parent.conf.Producer.MaxMessageBytes
+}
+
+func (ps *pendingSet) add(msg *ProducerMessage) {
+	tp := topicPartition{msg.Topic, msg.Partition}
+	if len(ps.msgs[tp]) == 0 {
+		ps.order = append(ps.order, tp)
+	}
+	ps.msgs[tp] = append(ps.msgs[tp], msg)
+	ps.bytes[tp] += ps.byteSize(msg)
+	ps.count++
+}
+
+// drain passes the pending messages to add, taking a message from each
+// partition in turn so that a hot partition cannot use up the space of the
+// others. A partition is skipped once add refuses its next message, and the
+// partitions skipped first are drained first next time.
+func (ps *pendingSet) drain(add func(msg *ProducerMessage) bool) {
+	var skipped []topicPartition
+	for len(ps.order) > 0 {
+		tp := ps.order[0]
+		ps.order = ps.order[1:]
+
+		if !add(ps.msgs[tp][0]) {
+			skipped = append(skipped, tp)
+			continue
+		}
+		ps.remove(tp, 1)
+		if len(ps.msgs[tp]) > 0 {
+			ps.order = append(ps.order, tp)
+		}
+	}
+	ps.order = skipped
+}
+
+// drop removes the pending messages of the partition.
+func (ps *pendingSet) drop(topic string, partition int32) []*ProducerMessage {
+	tp := topicPartition{topic, partition}
+	msgs := ps.msgs[tp]
+	if len(msgs) == 0 {
+		return nil
+	}
+	ps.remove(tp, len(msgs))
+	for i, other := range ps.order {
+		if other == tp {
+			ps.order = append(ps.order[:i], ps.order[i+1:]...)
+			break
+		}
+	}
+	return msgs
+}
+
+// dropAll removes all the pending messages, in the order of their partitions.
+func (ps *pendingSet) dropAll() []*ProducerMessage {
+	var msgs []*ProducerMessage
+	for _, tp := range ps.order {
+		msgs = append(msgs, ps.msgs[tp]...)
+	}
+	ps.msgs = make(map[topicPartition][]*ProducerMessage)
+	ps.bytes = make(map[topicPartition]int)
+	ps.order = nil
+	ps.count = 0
+	return msgs
+}
+
+func (ps *pendingSet) remove(tp topicPartition, n int) {
+	for _, msg := range ps.msgs[tp][:n] {
+		ps.bytes[tp] -= ps.byteSize(msg)
+	}
+	ps.msgs[tp] = ps.msgs[tp][n:]
+	ps.count -= n
+	if len(ps.msgs[tp]) == 0 {
+		delete(ps.msgs, tp)
+		delete(ps.bytes, tp)
+	}
+}
+
+func (ps *pendingSet) empty() bool {
+	return ps.count == 0
+}
@@ -425,3 +425,63 @@ func TestProduceSetConsistentTimestamps(t *testing.T) {
 		t.Errorf("Message timestamps do not match: %v, %v", time1, time2)
 	}
 }
+
+func TestPendingSetRoundRobinDrain(t *testing.T) {
+	parent, ps := makeProduceSet()
+	parent.conf.Producer.Flush.MaxMessages = 4
+	pending := newPendingSet(parent)
+
+	for i := 0; i < 6; i++ {
+		pending.add(&ProducerMessage{Topic: "hot", Partition: 0, Value: StringEncoder(TestMessage)})
+	}
+	pending.add(&ProducerMessage{Topic: "cold", Partition: 0, Value: StringEncoder(TestMessage)})
+	pending.add(&ProducerMessage{Topic: "cold", Partition: 1, Value: StringEncoder(TestMessage)})
+
+	drain := func(ps *produceSet) {
+		pending.drain(func(msg *ProducerMessage) bool {
+			if ps.wouldOverflow(msg) {
+				return false
+			}
+			safeAddMessage(t, ps, msg)
+			return true
+		})
+	}
+
+	drain(ps)
+	if ps.bufferCount != 4 {
+		t.Fatal("Expected 4 messages drained, got", ps.bufferCount)
+	}
+	for _, partition := range []int32{0, 1} {
+		if set := ps.msgs["cold"][partition]; set == nil || len(set.msgs) != 1 {
+			t.Error("Expected the cold partition", partition, "to be drained despite the hot one")
+		}
+	}
+	if !pending.holds(&ProducerMessage{Topic: "hot", Partition: 0}) || pending.count != 4 {
+		t.Error("Expected 4 messages of the hot partition to remain pending, got", pending.count)
+	}
+
+	ps = newProduceSet(parent)
+	drain(ps)
+	if ps.bufferCount != 4 || !pending.empty() {
+		t.Error("Expected the remaining messages to be drained, got", ps.bufferCount)
+	}
+}
+
+func TestPendingSetDrop(t *testing.T) {
+	parent, _ := makeProduceSet()
+	pending := newPendingSet(parent)
+
+	pending.add(&ProducerMessage{Topic: "a", Partition: 0})
+	pending.add(&ProducerMessage{Topic: "a", Partition: 0})
+	pending.add(&ProducerMessage{Topic: "b", Partition: 0})
+
+	if msgs := pending.drop("a", 0); len(msgs) != 2 {
+		t.Error("Expected 2 messages dropped, got", len(msgs))
+	}
+	if pending.holds(&ProducerMessage{Topic: "a", Partition: 0}) {
+		t.Error("Expected no message pending for the dropped partition")
+	}
+	if msgs := pending.dropAll(); len(msgs) != 1 || !pending.empty() {
+		t.Error("Expected the last message dropped, got", len(msgs))
+	}
+}
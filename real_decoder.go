@@ -16,11 +16,19 @@ var (
 	errInvalidBool            = PacketDecodingError{"invalid bool"}
 )
 
+// arrayElementSize is the estimated size of the elements of the arrays of structs
+// decoded, which the decoders usually allocate as pointers or small structs.
+const arrayElementSize = 8
+
 type realDecoder struct {
 	raw      []byte
 	off      int
 	stack    []pushDecoder
 	registry metrics.Registry
+
+	// allocated is the estimated number of bytes allocated for the arrays and
+	// the strings decoded so far, shared with the subsets of the decoder.
+	allocated *int64
 }
 
 // primitives
@@ -105,18 +113,32 @@ func (rd *realDecoder) getFloat64() (float64, error) {
 	return tmp, nil
 }
 
+// getArrayLength returns the length of an array, a null array being decoded
+// as an empty one.
 func (rd *realDecoder) getArrayLength() (int, error) {
+	n, err := rd.getNullableArrayLength()
+	if n == -1 && err == nil {
+		return 0, nil
+	}
+	return n, err
+}
+
+// getNullableArrayLength returns the length of an array, or -1 for a null array.
+func (rd *realDecoder) getNullableArrayLength() (int, error) {
 	if rd.remaining() < 4 {
 		rd.off = len(rd.raw)
 		return -1, ErrInsufficientData
 	}
 	tmp := int(int32(binary.BigEndian.Uint32(rd.raw[rd.off:])))
 	rd.off += 4
-	if tmp > rd.remaining() {
+	if tmp < -1 {
+		return -1, errInvalidArrayLength
+	} else if tmp > rd.remaining() {
 		rd.off = len(rd.raw)
 		return -1, ErrInsufficientData
-	} else if tmp > 2*math.MaxUint16 {
-		return -1, errInvalidArrayLength
+	}
+	if err := rd.checkArrayLength(tmp, arrayElementSize); err != nil {
+		return -1, err
 	}
 	return tmp, nil
 }
@@ -131,6 +153,14 @@ func (rd *realDecoder) getCompactArrayLength() (int, error) {
 		return 0, nil
 	}
 
+	if n-1 > uint64(rd.remaining()) {
+		rd.off = len(rd.raw)
+		return -1, ErrInsufficientData
+	}
+	if err := rd.checkArrayLength(int(n)-1, arrayElementSize); err != nil {
+		return -1, err
+	}
+
 	return int(n) - 1, nil
 }
 
@@ -222,6 +252,9 @@ func (rd *realDecoder) getStringLength() (int, error) {
 		rd.off = len(rd.raw)
 		return 0, ErrInsufficientData
 	}
+	if err := rd.checkStringLength(n); err != nil {
+		return 0, err
+	}
 
 	return n, nil
 }
@@ -258,6 +291,13 @@ func (rd *realDecoder) getCompactString() (string, error) {
 	if length < 0 {
 		return "", errInvalidByteSliceLength
 	}
+	if length > rd.remaining() {
+		rd.off = len(rd.raw)
+		return "", ErrInsufficientData
+	}
+	if err := rd.checkStringLength(length); err != nil {
+		return "", err
+	}
 	tmpStr := string(rd.raw[rd.off : rd.off+length])
 	rd.off += length
 	return tmpStr, nil
@@ -274,6 +314,13 @@ func (rd *realDecoder) getCompactNullableString() (*string, error) {
 	if length < 0 {
 		return nil, err
 	}
+	if length > rd.remaining() {
+		rd.off = len(rd.raw)
+		return nil, ErrInsufficientData
+	}
+	if err := rd.checkStringLength(length); err != nil {
+		return nil, err
+	}
 
 	tmpStr := string(rd.raw[rd.off : rd.off+length])
 	rd.off += length
@@ -290,7 +337,15 @@ func (rd *realDecoder) getCompactInt32Array() ([]int32, error) {
 		return nil, nil
 	}
 
+	if n-1 > uint64(rd.remaining()/4) {
+		rd.off = len(rd.raw)
+		return nil, ErrInsufficientData
+	}
+
 	arrayLength := int(n) - 1
+	if err := rd.checkArrayLength(arrayLength, 4); err != nil {
+		return nil, err
+	}
 
 	ret := make([]int32, arrayLength)
 
@@ -322,6 +377,10 @@ func (rd *realDecoder) getInt32Array() ([]int32, error) {
 		return nil, errInvalidArrayLength
 	}
 
+	if err := rd.checkArrayLength(n, 4); err != nil {
+		return nil, err
+	}
+
 	ret := make([]int32, n)
 	for i := range ret {
 		ret[i] = int32(binary.BigEndian.Uint32(rd.raw[rd.off:]))
@@ -351,6 +410,10 @@ func (rd *realDecoder) getInt64Array() ([]int64, error) {
 		return nil, errInvalidArrayLength
 	}
 
+	if err := rd.checkArrayLength(n, 8); err != nil {
+		return nil, err
+	}
+
 	ret := make([]int64, n)
 	for i := range ret {
 		ret[i] = int64(binary.BigEndian.Uint64(rd.raw[rd.off:]))
@@ -367,6 +430,11 @@ func (rd *realDecoder) getStringArray() ([]string, error) {
 	n := int(binary.BigEndian.Uint32(rd.raw[rd.off:]))
 	rd.off += 4
 
+	if rd.remaining() < 2*n {
+		rd.off = len(rd.raw)
+		return nil, ErrInsufficientData
+	}
+
 	if n == 0 {
 		return nil, nil
 	}
@@ -375,6 +443,10 @@ func (rd *realDecoder) getStringArray() ([]string, error) {
 		return nil, errInvalidArrayLength
 	}
 
+	if err := rd.checkArrayLength(n, 16); err != nil {
+		return nil, err
+	}
+
 	ret := make([]string, n)
 	for i := range ret {
 		str, err := rd.getString()
@@ -387,6 +459,47 @@ func (rd *realDecoder) getStringArray() ([]string, error) {
 	return ret, nil
 }
 
+// limits
+
+// checkArrayLength checks the length of an array of n elements of elementSize
+// bytes against MaxDecodeArrayLength and MaxDecodeAllocation, before it is
+// allocated.
+func (rd *realDecoder) checkArrayLength(n, elementSize int) error {
+	if n <= 0 {
+		return nil
+	}
+	if n > MaxDecodeArrayLength {
+		return DecodingLimitError{Limit: "array length", Length: int64(n), Max: int64(MaxDecodeArrayLength)}
+	}
+	return rd.allocate(int64(n) * int64(elementSize))
+}
+
+// checkStringLength checks the length of a string against MaxDecodeStringLength
+// and MaxDecodeAllocation, before it is copied.
+func (rd *realDecoder) checkStringLength(n int) error {
+	if n > MaxDecodeStringLength {
+		return DecodingLimitError{Limit: "string length", Length: int64(n), Max: int64(MaxDecodeStringLength)}
+	}
+	return rd.allocate(int64(n))
+}
+
+func (rd *realDecoder) allocate(size int64) error {
+	allocated := rd.allocation()
+	*allocated += size
+	if MaxDecodeAllocation > 0 && *allocated > MaxDecodeAllocation {
+		return DecodingLimitError{Limit: "allocation", Length: *allocated, Max: MaxDecodeAllocation}
+	}
+	return nil
+}
+
+// allocation returns the allocation counter of the decoder, shared with its subsets.
+func (rd *realDecoder) allocation() *int64 {
+	if rd.allocated == nil {
+		rd.allocated = new(int64)
+	}
+	return rd.allocated
+}
+
 // subsets
 
 func (rd *realDecoder) remaining() int {
@@ -398,7 +511,7 @@ func (rd *realDecoder) getSubset(length int) (packetDecoder, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &realDecoder{raw: buf}, nil
+	return &realDecoder{raw: buf, allocated: rd.allocation()}, nil
 }
 
 func (rd *realDecoder) getRawBytes(length int) ([]byte, error) {
@@ -419,7 +532,7 @@ func (rd *realDecoder) peek(offset, length int) (packetDecoder, error) {
 		return nil, ErrInsufficientData
 	}
 	off := rd.off + offset
-	return &realDecoder{raw: rd.raw[off : off+length]}, nil
+	return &realDecoder{raw: rd.raw[off : off+length], allocated: rd.allocation()}, nil
 }
 
 func (rd *realDecoder) peekInt8(offset int) (int8, error) {
@@ -0,0 +1,92 @@
+package sarama
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRealDecoderArrayLengths(t *testing.T) {
+	for _, tc := range []struct {
+		raw              []byte
+		length, nullable int
+		err              error
+	}{
+		{raw: []byte{0, 0, 0, 0}, length: 0, nullable: 0},
+		{raw: []byte{0, 0, 0, 1, 0}, length: 1, nullable: 1},
+		{raw: []byte{0xff, 0xff, 0xff, 0xff}, length: 0, nullable: -1},
+		{raw: []byte{0xff, 0xff, 0xff, 0xfe}, err: errInvalidArrayLength},
+		{raw: []byte{0x7f, 0xff, 0xff, 0xff}, err: ErrInsufficientData},
+		{raw: []byte{0, 0}, err: ErrInsufficientData},
+	} {
+		rd := &realDecoder{raw: tc.raw}
+		n, err := rd.getArrayLength()
+		if !errors.Is(err, tc.err) || (tc.err == nil && n != tc.length) {
+			t.Errorf("getArrayLength(%x): expected %d, %v, got %d, %v", tc.raw, tc.length, tc.err, n, err)
+		}
+		rd = &realDecoder{raw: tc.raw}
+		n, err = rd.getNullableArrayLength()
+		if !errors.Is(err, tc.err) || (tc.err == nil && n != tc.nullable) {
+			t.Errorf("getNullableArrayLength(%x): expected %d, %v, got %d, %v", tc.raw, tc.nullable, tc.err, n, err)
+		}
+	}
+}
+
+func TestRealDecoderRejectsOversizedCollections(t *testing.T) {
+	for name, get := range map[string]func(*realDecoder) error{
+		"string array":         func(rd *realDecoder) error { _, err := rd.getStringArray(); return err },
+		"int32 array":          func(rd *realDecoder) error { _, err := rd.getInt32Array(); return err },
+		"compact string":       func(rd *realDecoder) error { _, err := rd.getCompactString(); return err },
+		"compact int32 array":  func(rd *realDecoder) error { _, err := rd.getCompactInt32Array(); return err },
+		"compact array length": func(rd *realDecoder) error { _, err := rd.getCompactArrayLength(); return err },
+	} {
+		// A length of 0x7fffffff, or the equivalent uvarint, followed by a single byte.
+		raw := []byte{0x7f, 0xff, 0xff, 0xff, 0}
+		if name[:7] == "compact" {
+			raw = []byte{0xff, 0xff, 0xff, 0xff, 0x07, 0}
+		}
+		if err := get(&realDecoder{raw: raw}); !errors.Is(err, ErrInsufficientData) {
+			t.Errorf("%s: expected ErrInsufficientData, got %v", name, err)
+		}
+	}
+}
+
+func TestRealDecoderLimits(t *testing.T) {
+	defer func(arrays, strings int, allocation int64) {
+		MaxDecodeArrayLength, MaxDecodeStringLength, MaxDecodeAllocation = arrays, strings, allocation
+	}(MaxDecodeArrayLength, MaxDecodeStringLength, MaxDecodeAllocation)
+	MaxDecodeArrayLength, MaxDecodeStringLength, MaxDecodeAllocation = 2, 3, 20
+
+	var limitErr DecodingLimitError
+
+	rd := &realDecoder{raw: []byte{0, 0, 0, 3, 0, 0, 0}}
+	if _, err := rd.getArrayLength(); !errors.As(err, &limitErr) || limitErr.Limit != "array length" || limitErr.Length != 3 || limitErr.Max != 2 {
+		t.Error("expected the array length to exceed its limit, got", err)
+	}
+
+	rd = &realDecoder{raw: []byte{0, 4, 'a', 'b', 'c', 'd'}}
+	if _, err := rd.getString(); !errors.As(err, &limitErr) || limitErr.Limit != "string length" {
+		t.Error("expected the string length to exceed its limit, got", err)
+	}
+
+	// The strings of a subset count towards the allocation of the whole message.
+	rd = &realDecoder{raw: []byte{0, 0, 0, 2, 0, 3, 'a', 'b', 'c', 0, 3, 'a', 'b', 'c'}}
+	if _, err := rd.getArrayLength(); err != nil {
+		t.Fatal(err)
+	}
+	subset, err := rd.getSubset(5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := subset.getString(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rd.getString(); !errors.As(err, &limitErr) || limitErr.Limit != "allocation" || limitErr.Length != 22 || limitErr.Max != 20 {
+		t.Error("expected the allocation to exceed its limit, got", err)
+	}
+
+	MaxDecodeAllocation = 0
+	rd = &realDecoder{raw: []byte{0, 0, 0, 2, 0, 3, 'a', 'b', 'c', 0, 3, 'a', 'b', 'c'}}
+	if _, err := rd.getStringArray(); err != nil {
+		t.Error("expected the allocation not to be limited, got", err)
+	}
+}
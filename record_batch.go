@@ -49,7 +49,8 @@ type RecordBatch struct {
 	IsTransactional       bool
 
 	compressedRecords []byte
-	recordsLen        int // uncompressed records size
+	recordsLen        int   // uncompressed records size
+	crcErr            error // set when the CRC of a decoded batch does not match
 }
 
 func (b *RecordBatch) LastOffset() int64 {
@@ -99,7 +100,15 @@ func (b *RecordBatch) encode(pe packetEncoder) error {
 	return pe.pop()
 }
 
-func (b *RecordBatch) decode(pd packetDecoder) (err error) {
+func (b *RecordBatch) decode(pd packetDecoder) error {
+	return b.decodeBatch(pd, false)
+}
+
+// decodeBatch decodes the batch. If keepCorrupt is set, a batch whose CRC
+// does not match is kept with the mismatch in crcErr rather than failing, as
+// it is framed by its length, which the CRC does not cover, so the batches
+// following it can still be decoded.
+func (b *RecordBatch) decodeBatch(pd packetDecoder, keepCorrupt bool) (err error) {
 	if b.FirstOffset, err = pd.getInt64(); err != nil {
 		return err
 	}
@@ -177,16 +186,28 @@ func (b *RecordBatch) decode(pd packetDecoder) (err error) {
 	}
 
 	if err = pd.pop(); err != nil {
-		return err
+		if !keepCorrupt {
+			return err
+		}
+		b.crcErr = err
 	}
 
 	recBuffer, err = decompress(b.Codec, recBuffer)
 	if err != nil {
+		if b.crcErr != nil {
+			b.Records = nil
+			return nil
+		}
 		return err
 	}
 
 	b.recordsLen = len(recBuffer)
 	err = decode(recBuffer, recordsArray(b.Records), nil)
+	if err != nil && b.crcErr != nil {
+		// the records of a corrupt batch may not be decodable
+		b.Records = nil
+		return nil
+	}
 	if errors.Is(err, ErrInsufficientData) {
 		b.PartialTrailingRecord = true
 		b.Records = nil
@@ -1,7 +1,9 @@
 package sarama
 
 import (
+	"errors"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -251,3 +253,17 @@ func TestRecordBatchDecoding(t *testing.T) {
 		}
 	}
 }
+
+func TestRecordBatchDecodingCorrupt(t *testing.T) {
+	tc := recordBatchTestCases()[0]
+	encoded := make([]byte, len(tc.encoded))
+	copy(encoded, tc.encoded)
+	// the last byte of the first timestamp, covered by the CRC
+	encoded[34]++
+
+	var crcErr PacketDecodingError
+	err := decode(encoded, &RecordBatch{}, nil)
+	if !errors.As(err, &crcErr) || !strings.Contains(crcErr.Info, "CRC didn't match") {
+		t.Error("expected the CRC mismatch to fail the decoding, got", err)
+	}
+}
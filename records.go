@@ -1,6 +1,9 @@
 package sarama
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+)
 
 const (
 	unknownRecords = iota
@@ -80,6 +83,12 @@ func (r *Records) setTypeFromMagic(pd packetDecoder) error {
 }
 
 func (r *Records) decode(pd packetDecoder) error {
+	return r.decodeRecords(pd, false)
+}
+
+// decodeRecords decodes the records, keeping a record batch whose CRC does
+// not match if keepCorrupt is set, see RecordBatch.decodeBatch.
+func (r *Records) decodeRecords(pd packetDecoder, keepCorrupt bool) error {
 	if r.recordsType == unknownRecords {
 		if err := r.setTypeFromMagic(pd); err != nil {
 			return err
@@ -92,11 +101,35 @@ func (r *Records) decode(pd packetDecoder) error {
 		return r.MsgSet.decode(pd)
 	case defaultRecords:
 		r.RecordBatch = &RecordBatch{}
-		return r.RecordBatch.decode(pd)
+		return r.RecordBatch.decodeBatch(pd, keepCorrupt)
 	}
 	return fmt.Errorf("unknown records type: %v", r.recordsType)
 }
 
+// DecodeRecords decodes the record batches, or the legacy message sets, laid
+// end to end in data, such as the content of a log segment or the records of
+// a partition in a fetch response. A batch truncated at the end of data is
+// returned with its PartialTrailingRecord, or PartialTrailingMessage, set.
+func DecodeRecords(data []byte) ([]*Records, error) {
+	pd := &realDecoder{raw: data}
+	var set []*Records
+	for pd.remaining() > 0 {
+		records := &Records{}
+		if err := records.decode(pd); err != nil {
+			if errors.Is(err, ErrInsufficientData) && len(set) > 0 {
+				// too short for the header of another batch
+				break
+			}
+			return nil, err
+		}
+		set = append(set, records)
+		if partial, _ := records.isPartial(); partial {
+			break
+		}
+	}
+	return set, nil
+}
+
 func (r *Records) numRecords() (int, error) {
 	if r.recordsType == unknownRecords {
 		if empty, err := r.setTypeFromFields(); err != nil || empty {
@@ -207,12 +240,5 @@ func (r *Records) getControlRecord() (ControlRecord, error) {
 		return ControlRecord{}, fmt.Errorf("cannot get control record, record batch is empty")
 	}
 
-	firstRecord := r.RecordBatch.Records[0]
-	controlRecord := ControlRecord{}
-	err := controlRecord.decode(&realDecoder{raw: firstRecord.Key}, &realDecoder{raw: firstRecord.Value})
-	if err != nil {
-		return ControlRecord{}, err
-	}
-
-	return controlRecord, nil
+	return DecodeControlRecord(r.RecordBatch.Records[0])
 }
@@ -157,3 +157,48 @@ func TestDefaultRecords(t *testing.T) {
 		t.Errorf("RecordBatch record offset is invalid")
 	}
 }
+
+func TestDecodeRecords(t *testing.T) {
+	legacy := append([]byte{
+		0, 0, 0, 0, 0, 0, 0, 5, // offset
+		0, 0, 0, byte(len(emptyV1Message)),
+	}, emptyV1Message...)
+	set, err := DecodeRecords(legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(set) != 1 || set[0].MsgSet == nil || len(set[0].MsgSet.Messages) != 1 || set[0].MsgSet.Messages[0].Offset != 5 {
+		t.Fatalf("unexpected legacy records %+v", set)
+	}
+
+	var data []byte
+	for _, batch := range []*MockRecordBatch{
+		NewMockRecordBatch(0).AddRecord(StringEncoder("a"), StringEncoder("1")),
+		NewMockRecordBatch(1).AddRecord(StringEncoder("b"), StringEncoder("2")).AddRecord(nil, StringEncoder("3")),
+	} {
+		b, err := batch.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data = append(data, b...)
+	}
+	set, err = DecodeRecords(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(set) != 2 || set[0].RecordBatch.FirstOffset != 0 || len(set[1].RecordBatch.Records) != 2 {
+		t.Fatalf("unexpected batches %+v", set)
+	}
+
+	// a trailing piece too short for a batch header is ignored, a longer one
+	// is a partial batch
+	if set, err = DecodeRecords(append(data, 0, 0, 0)); err != nil || len(set) != 2 {
+		t.Errorf("expected 2 batches, got %d: %v", len(set), err)
+	}
+	if set, err = DecodeRecords(data[:len(data)-5]); err != nil || len(set) != 2 || !set[1].RecordBatch.PartialTrailingRecord {
+		t.Errorf("expected a partial second batch, got %d: %v", len(set), err)
+	}
+	if _, err = DecodeRecords([]byte{0, 0, 0}); err == nil {
+		t.Error("expected an error for data too short for a batch")
+	}
+}
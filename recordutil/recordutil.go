@@ -0,0 +1,202 @@
+/*
+Package recordutil parses raw record batches, such as the content of a log
+segment dumped from a broker or the records of a fetch response, into
+structured records, for building debugging and audit tools.
+
+Parse returns the batches of the data with their transactional metadata, and
+the records of each batch with their absolute offsets, timestamps, headers and
+sequence numbers:
+
+	batches, err := recordutil.Parse(data)
+	if err != nil {
+		...
+	}
+	for _, batch := range batches {
+		for _, record := range batch.Records {
+			fmt.Println(record.Offset, record.Timestamp, string(record.Key))
+		}
+	}
+
+The v2 record batches of Kafka 0.11 and later are parsed, as well as the
+message sets of the older versions, each of whose top level messages is
+returned as a batch of its own.
+
+NOTE: this package currently does not fall under the API stability
+guarantee of Sarama as it is still considered experimental.
+*/
+package recordutil
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// Batch is a record batch, or a top level message of a legacy message set.
+type Batch struct {
+	// BaseOffset and LastOffset are the offsets of the first and last records
+	// of the batch, whose records may have been removed by compaction.
+	BaseOffset, LastOffset int64
+	// Magic is the version of the format of the batch: 2 for the record
+	// batches, 0 or 1 for the legacy messages.
+	Magic                int8
+	PartitionLeaderEpoch int32 // -1 for the legacy messages
+	Codec                sarama.CompressionCodec
+	// LogAppendTime is set when the timestamps are the times the broker
+	// appended the batch to the log, rather than the times it was produced.
+	LogAppendTime bool
+	// FirstTimestamp and MaxTimestamp are the first and latest timestamps of
+	// the records. They are zero for the version 0 messages.
+	FirstTimestamp, MaxTimestamp time.Time
+
+	// ProducerID, ProducerEpoch and BaseSequence are those of the idempotent
+	// and transactional producers, -1 otherwise.
+	ProducerID    int64
+	ProducerEpoch int16
+	BaseSequence  int32
+	Transactional bool
+	// Control is set for the batches holding a control record, such as the
+	// markers ending a transaction.
+	Control bool
+	// Partial is set for a batch truncated at the end of the data, whose
+	// records are not returned.
+	Partial bool
+
+	Records []*Record
+}
+
+// Record is a record of a batch.
+type Record struct {
+	Offset    int64
+	Timestamp time.Time
+	Key       []byte
+	Value     []byte
+	Headers   []*sarama.RecordHeader
+	// Sequence is the sequence number of the record for the idempotent and
+	// transactional producers, -1 otherwise.
+	Sequence int32
+	// Control is the control record of the records of control batches.
+	Control *sarama.ControlRecord
+}
+
+// Parse parses the record batches, or legacy message sets, laid end to end in
+// data. A batch truncated at the end of data is returned with Partial set.
+func Parse(data []byte) ([]*Batch, error) {
+	set, err := sarama.DecodeRecords(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var batches []*Batch
+	for _, records := range set {
+		switch {
+		case records.RecordBatch != nil:
+			batch, err := fromRecordBatch(records.RecordBatch)
+			if err != nil {
+				return nil, err
+			}
+			batches = append(batches, batch)
+		case records.MsgSet != nil:
+			for _, block := range records.MsgSet.Messages {
+				batches = append(batches, fromMessageBlock(block))
+			}
+			if records.MsgSet.PartialTrailingMessage {
+				batches = append(batches, &Batch{PartitionLeaderEpoch: -1, ProducerID: -1, ProducerEpoch: -1, BaseSequence: -1, Partial: true})
+			}
+		}
+	}
+	return batches, nil
+}
+
+func fromRecordBatch(rb *sarama.RecordBatch) (*Batch, error) {
+	batch := &Batch{
+		BaseOffset:           rb.FirstOffset,
+		LastOffset:           rb.LastOffset(),
+		Magic:                rb.Version,
+		PartitionLeaderEpoch: rb.PartitionLeaderEpoch,
+		Codec:                rb.Codec,
+		LogAppendTime:        rb.LogAppendTime,
+		FirstTimestamp:       rb.FirstTimestamp,
+		MaxTimestamp:         rb.MaxTimestamp,
+		ProducerID:           rb.ProducerID,
+		ProducerEpoch:        rb.ProducerEpoch,
+		BaseSequence:         rb.FirstSequence,
+		Transactional:        rb.IsTransactional,
+		Control:              rb.Control,
+		Partial:              rb.PartialTrailingRecord,
+	}
+	for _, r := range rb.Records {
+		record := &Record{
+			Offset:    rb.FirstOffset + r.OffsetDelta,
+			Timestamp: rb.FirstTimestamp.Add(r.TimestampDelta),
+			Key:       r.Key,
+			Value:     r.Value,
+			Headers:   r.Headers,
+			Sequence:  -1,
+		}
+		if rb.LogAppendTime {
+			record.Timestamp = rb.MaxTimestamp
+		}
+		if rb.FirstSequence >= 0 {
+			record.Sequence = rb.FirstSequence + int32(r.OffsetDelta)
+		}
+		if rb.Control {
+			cr, err := sarama.DecodeControlRecord(r)
+			if err != nil {
+				return nil, fmt.Errorf("recordutil: control record at offset %d: %w", record.Offset, err)
+			}
+			record.Control = &cr
+		}
+		batch.Records = append(batch.Records, record)
+	}
+	return batch, nil
+}
+
+// fromMessageBlock returns a legacy message as a batch, holding the messages
+// it wraps when it is compressed.
+func fromMessageBlock(block *sarama.MessageBlock) *Batch {
+	msg := block.Msg
+	batch := &Batch{
+		BaseOffset:           block.Offset,
+		LastOffset:           block.Offset,
+		Magic:                msg.Version,
+		PartitionLeaderEpoch: -1,
+		Codec:                msg.Codec,
+		LogAppendTime:        msg.LogAppendTime,
+		FirstTimestamp:       msg.Timestamp,
+		MaxTimestamp:         msg.Timestamp,
+		ProducerID:           -1,
+		ProducerEpoch:        -1,
+		BaseSequence:         -1,
+	}
+
+	inner := block.Messages()
+	var base int64
+	if msg.Set != nil && msg.Version >= 1 && len(inner) > 0 {
+		// the offsets of the messages wrapped by a v1 message are relative,
+		// the wrapper having the offset of the last one
+		base = block.Offset - inner[len(inner)-1].Offset
+	}
+	for i, b := range inner {
+		record := &Record{
+			Offset:    base + b.Offset,
+			Timestamp: b.Msg.Timestamp,
+			Key:       b.Msg.Key,
+			Value:     b.Msg.Value,
+			Sequence:  -1,
+		}
+		if msg.LogAppendTime {
+			record.Timestamp = msg.Timestamp
+		}
+		if i == 0 {
+			batch.BaseOffset = record.Offset
+			batch.FirstTimestamp = record.Timestamp
+		}
+		if record.Timestamp.After(batch.MaxTimestamp) {
+			batch.MaxTimestamp = record.Timestamp
+		}
+		batch.Records = append(batch.Records, record)
+	}
+	return batch
+}
@@ -0,0 +1,84 @@
+package recordutil
+
+import (
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+func encodeBatches(t *testing.T, batches ...*sarama.MockRecordBatch) []byte {
+	t.Helper()
+	var data []byte
+	for _, batch := range batches {
+		b, err := batch.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data = append(data, b...)
+	}
+	return data
+}
+
+func TestParse(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	data := encodeBatches(t,
+		sarama.NewMockRecordBatch(10).
+			SetTimestamp(start).
+			SetTransactional(7, 1).
+			SetProducer(7, 1, 20).
+			AddRecordWithTimestamp(sarama.StringEncoder("k1"), sarama.StringEncoder("v1"), start,
+				sarama.RecordHeader{Key: []byte("h"), Value: []byte("1")}).
+			AddRecordWithTimestamp(nil, sarama.StringEncoder("v2"), start.Add(time.Second)),
+		sarama.NewMockControlBatch(12, 7, 1, sarama.ControlRecordCommit),
+	)
+
+	batches, err := Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(batches) != 2 {
+		t.Fatalf("expected 2 batches, got %d", len(batches))
+	}
+
+	batch := batches[0]
+	if batch.BaseOffset != 10 || batch.LastOffset != 11 || batch.Magic != 2 || !batch.Transactional || batch.Control {
+		t.Errorf("unexpected batch %+v", batch)
+	}
+	if batch.ProducerID != 7 || batch.ProducerEpoch != 1 || batch.BaseSequence != 20 {
+		t.Errorf("unexpected producer of batch %+v", batch)
+	}
+	if len(batch.Records) != 2 {
+		t.Fatalf("expected 2 records, got %d", len(batch.Records))
+	}
+	first, second := batch.Records[0], batch.Records[1]
+	if first.Offset != 10 || string(first.Key) != "k1" || string(first.Value) != "v1" || first.Sequence != 20 || !first.Timestamp.Equal(start) {
+		t.Errorf("unexpected first record %+v", first)
+	}
+	if len(first.Headers) != 1 || string(first.Headers[0].Key) != "h" || string(first.Headers[0].Value) != "1" {
+		t.Errorf("unexpected headers %+v", first.Headers)
+	}
+	if second.Offset != 11 || second.Key != nil || second.Sequence != 21 || !second.Timestamp.Equal(start.Add(time.Second)) {
+		t.Errorf("unexpected second record %+v", second)
+	}
+
+	marker := batches[1]
+	if !marker.Control || len(marker.Records) != 1 || marker.Records[0].Control == nil || marker.Records[0].Control.Type != sarama.ControlRecordCommit {
+		t.Errorf("unexpected control batch %+v", marker)
+	}
+}
+
+func TestParsePartialBatch(t *testing.T) {
+	data := encodeBatches(t,
+		sarama.NewMockRecordBatch(0).AddRecord(nil, sarama.StringEncoder("v1")),
+		sarama.NewMockRecordBatch(1).AddRecord(nil, sarama.StringEncoder("v2")),
+	)
+
+	batches, err := Parse(data[:len(data)-3])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(batches) != 2 || batches[0].Partial || !batches[1].Partial || batches[1].Records != nil {
+		t.Errorf("expected a partial second batch, got %+v", batches)
+	}
+}
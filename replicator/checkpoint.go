@@ -0,0 +1,182 @@
+package replicator
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// Checkpoint records that the message of a source partition at SourceOffset
+// was replicated to TargetOffset of the target partition of the same number.
+type Checkpoint struct {
+	SourceTopic     string `json:"-"`
+	SourcePartition int32  `json:"-"`
+	SourceOffset    int64  `json:"source_offset"`
+	TargetTopic     string `json:"target_topic"`
+	TargetOffset    int64  `json:"target_offset"`
+}
+
+// key returns the key of the checkpoint record of the replication name.
+func (c Checkpoint) key(name string) string {
+	return name + "/" + c.SourceTopic + "/" + strconv.Itoa(int(c.SourcePartition))
+}
+
+// parseCheckpoint decodes a checkpoint record, returning false if it belongs
+// to another replication than name.
+func parseCheckpoint(name string, key, value []byte) (Checkpoint, bool, error) {
+	var c Checkpoint
+	prefix := name + "/"
+	if !strings.HasPrefix(string(key), prefix) {
+		return c, false, nil
+	}
+	rest := strings.TrimPrefix(string(key), prefix)
+	i := strings.LastIndexByte(rest, '/')
+	if i < 0 {
+		return c, false, fmt.Errorf("replicator: invalid checkpoint key %q", key)
+	}
+	partition, err := strconv.ParseInt(rest[i+1:], 10, 32)
+	if err != nil {
+		return c, false, fmt.Errorf("replicator: invalid checkpoint key %q", key)
+	}
+	if err := json.Unmarshal(value, &c); err != nil {
+		return c, false, fmt.Errorf("replicator: invalid checkpoint %q: %w", key, err)
+	}
+	c.SourceTopic, c.SourcePartition = rest[:i], int32(partition)
+	return c, true, nil
+}
+
+type topicPartition struct {
+	topic     string
+	partition int32
+}
+
+// Checkpoints holds the checkpoints of a replication, to resume it and to
+// translate the offsets of the source partitions into offsets of the target
+// ones. It is safe for concurrent use.
+type Checkpoints struct {
+	lock sync.RWMutex
+	// The checkpoints of each source partition, in increasing order of
+	// source offsets.
+	checkpoints map[topicPartition][]Checkpoint
+}
+
+func newCheckpoints() *Checkpoints {
+	return &Checkpoints{checkpoints: make(map[topicPartition][]Checkpoint)}
+}
+
+func (c *Checkpoints) add(checkpoint Checkpoint) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	tp := topicPartition{checkpoint.SourceTopic, checkpoint.SourcePartition}
+	history := c.checkpoints[tp]
+	i := sort.Search(len(history), func(i int) bool { return history[i].SourceOffset >= checkpoint.SourceOffset })
+	if i < len(history) && history[i].SourceOffset == checkpoint.SourceOffset {
+		history[i] = checkpoint
+		return
+	}
+	history = append(history, Checkpoint{})
+	copy(history[i+1:], history[i:])
+	history[i] = checkpoint
+	c.checkpoints[tp] = history
+}
+
+// Latest returns the latest checkpoint of a source partition.
+func (c *Checkpoints) Latest(topic string, partition int32) (Checkpoint, bool) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	history := c.checkpoints[topicPartition{topic, partition}]
+	if len(history) == 0 {
+		return Checkpoint{}, false
+	}
+	return history[len(history)-1], true
+}
+
+// Translate translates the offset of the next message to consume from a
+// source partition, such as the offset committed by a consumer group, into
+// the offset to consume from in the target cluster. As only the checkpointed
+// offsets are known, the target offset may be before the exact translation,
+// so that consumers failing over to the target cluster may receive duplicates
+// but never miss a message. It returns false if no checkpoint of the
+// partition precedes sourceOffset.
+func (c *Checkpoints) Translate(topic string, partition int32, sourceOffset int64) (targetTopic string, targetOffset int64, ok bool) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	history := c.checkpoints[topicPartition{topic, partition}]
+	i := sort.Search(len(history), func(i int) bool { return history[i].SourceOffset >= sourceOffset })
+	if i == 0 {
+		return "", 0, false
+	}
+	checkpoint := history[i-1]
+	return checkpoint.TargetTopic, checkpoint.TargetOffset + 1, true
+}
+
+// LoadCheckpoints reads the checkpoints of the replication name from the
+// checkpoint topic of the target cluster. The checkpoints of a replication
+// using exactly-once delivery must be read by a client whose configuration
+// sets Consumer.IsolationLevel to sarama.ReadCommitted.
+func LoadCheckpoints(client sarama.Client, checkpointTopic, name string) (*Checkpoints, error) {
+	checkpoints := newCheckpoints()
+
+	oldest, err := client.GetOffset(checkpointTopic, checkpointPartition, sarama.OffsetOldest)
+	if err != nil {
+		return nil, err
+	}
+	newest, err := client.GetOffset(checkpointTopic, checkpointPartition, sarama.OffsetNewest)
+	if err != nil {
+		return nil, err
+	}
+	if newest <= oldest {
+		return checkpoints, nil
+	}
+
+	consumer, err := sarama.NewConsumerFromClient(client)
+	if err != nil {
+		return nil, err
+	}
+	defer consumer.Close()
+	pc, err := consumer.ConsumePartition(checkpointTopic, checkpointPartition, oldest)
+	if err != nil {
+		return nil, err
+	}
+	defer pc.AsyncClose()
+
+	// The last offsets of the topic may hold transaction markers or aborted
+	// checkpoints, which are not returned, so the checkpoints have all been
+	// read once no message is returned for a while. The first one may take up
+	// to Net.ReadTimeout.
+	idle := 2 * client.Config().Consumer.MaxWaitTime
+	timer := time.NewTimer(client.Config().Net.ReadTimeout)
+	defer timer.Stop()
+	for {
+		select {
+		case msg := <-pc.Messages():
+			checkpoint, ok, err := parseCheckpoint(name, msg.Key, msg.Value)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				checkpoints.add(checkpoint)
+			}
+			if msg.Offset >= newest-1 {
+				return checkpoints, nil
+			}
+			if !timer.Stop() {
+				<-timer.C
+			}
+			timer.Reset(idle)
+		case err := <-pc.Errors():
+			return nil, err
+		case <-timer.C:
+			return checkpoints, nil
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package replicator
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// OffsetTranslator translates the offset of the next message to consume from
+// a source partition, such as the offset committed by a consumer group, into
+// the topic and offset to consume from in the target cluster. The partition
+// keeps its number. It returns false if the offset can not be translated.
+type OffsetTranslator interface {
+	TranslateOffset(topic string, partition int32, sourceOffset int64) (targetTopic string, targetOffset int64, ok bool, err error)
+}
+
+// TranslateOffset implements OffsetTranslator using Translate.
+func (c *Checkpoints) TranslateOffset(topic string, partition int32, sourceOffset int64) (string, int64, bool, error) {
+	targetTopic, targetOffset, ok := c.Translate(topic, partition, sourceOffset)
+	return targetTopic, targetOffset, ok, nil
+}
+
+// TimestampTranslator is an OffsetTranslator for the topics replicated
+// without checkpoints, or before the first one. It reads the timestamp of the
+// source message at the offset, and looks up the first message of the target
+// partition with the same or a later timestamp, which is exact as long as the
+// timestamps of the partition are increasing and were kept by the
+// replication. The offsets past the last message of a source partition are
+// translated into the offset past the last message of the target one.
+type TimestampTranslator struct {
+	// Source and Target are the clients of the source and target clusters.
+	Source, Target sarama.Client
+	// Rename returns the name of the target topic of a source topic
+	// (default Identity).
+	Rename RenameFunc
+}
+
+// TranslateOffset implements OffsetTranslator. It returns false for the
+// messages without timestamps, produced before Kafka 0.10.
+func (t *TimestampTranslator) TranslateOffset(topic string, partition int32, sourceOffset int64) (string, int64, bool, error) {
+	targetTopic := topic
+	if t.Rename != nil {
+		targetTopic = t.Rename(topic)
+	}
+
+	newest, err := t.Source.GetOffset(topic, partition, sarama.OffsetNewest)
+	if err != nil {
+		return "", 0, false, err
+	}
+	if sourceOffset >= newest {
+		targetOffset, err := t.Target.GetOffset(targetTopic, partition, sarama.OffsetNewest)
+		if err != nil {
+			return "", 0, false, err
+		}
+		return targetTopic, targetOffset, true, nil
+	}
+
+	timestamp, err := t.timestamp(topic, partition, sourceOffset)
+	if err != nil || timestamp.IsZero() {
+		return "", 0, false, err
+	}
+	targetOffset, err := t.Target.GetOffset(targetTopic, partition, timestamp.UnixNano()/int64(time.Millisecond))
+	if err != nil {
+		return "", 0, false, err
+	}
+	if targetOffset < 0 {
+		// no target message is as recent as the source one
+		if targetOffset, err = t.Target.GetOffset(targetTopic, partition, sarama.OffsetNewest); err != nil {
+			return "", 0, false, err
+		}
+	}
+	return targetTopic, targetOffset, true, nil
+}
+
+// timestamp returns the timestamp of the source message at offset, or of the
+// first one after it if it was deleted by the compaction of the topic.
+func (t *TimestampTranslator) timestamp(topic string, partition int32, offset int64) (time.Time, error) {
+	consumer, err := sarama.NewConsumerFromClient(t.Source)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer consumer.Close()
+	pc, err := consumer.ConsumePartition(topic, partition, offset)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer pc.AsyncClose()
+
+	timer := time.NewTimer(t.Source.Config().Net.ReadTimeout)
+	defer timer.Stop()
+	select {
+	case msg := <-pc.Messages():
+		return msg.Timestamp, nil
+	case err := <-pc.Errors():
+		return time.Time{}, err
+	case <-timer.C:
+		return time.Time{}, fmt.Errorf("replicator: timed out reading the message of %s/%d at offset %d", topic, partition, offset)
+	}
+}
+
+// TranslateGroupOffsets returns the offsets committed by group in the source
+// cluster, translated by translator into offsets of the target cluster, by
+// target topic and partition. The partitions without committed offsets, or
+// whose offsets can not be translated, are left out.
+func TranslateGroupOffsets(source sarama.ClusterAdmin, group string, translator OffsetTranslator) (map[string]map[int32]int64, error) {
+	response, err := source.ListConsumerGroupOffsets(group, nil)
+	if err != nil {
+		return nil, err
+	}
+	if !errors.Is(response.Err, sarama.ErrNoError) {
+		return nil, response.Err
+	}
+
+	offsets := make(map[string]map[int32]int64)
+	for topic, blocks := range response.Blocks {
+		for partition, block := range blocks {
+			if !errors.Is(block.Err, sarama.ErrNoError) {
+				return nil, fmt.Errorf("replicator: offset of %s/%d: %w", topic, partition, block.Err)
+			}
+			if block.Offset < 0 {
+				continue
+			}
+			targetTopic, targetOffset, ok, err := translator.TranslateOffset(topic, partition, block.Offset)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				continue
+			}
+			if offsets[targetTopic] == nil {
+				offsets[targetTopic] = make(map[int32]int64)
+			}
+			offsets[targetTopic][partition] = targetOffset
+		}
+	}
+	return offsets, nil
+}
+
+// CommitGroupOffsets commits the offsets, by topic and partition, of group in
+// the target cluster, overwriting the ones committed before, so that the group
+// resumes from them when it fails over to the target cluster. The group must
+// not have any member yet, as the coordinator rejects the commits of non
+// members while the group is active.
+func CommitGroupOffsets(target sarama.Client, group string, offsets map[string]map[int32]int64) error {
+	if len(offsets) == 0 {
+		return nil
+	}
+	coordinator, err := target.Coordinator(group)
+	if err != nil {
+		return err
+	}
+
+	request := &sarama.OffsetCommitRequest{
+		Version:                 1,
+		ConsumerGroup:           group,
+		ConsumerGroupGeneration: sarama.GroupGenerationUndefined,
+	}
+	timestamp := sarama.ReceiveTime
+	if target.Config().Version.IsAtLeast(sarama.V0_9_0_0) {
+		// version 2 uses the retention time of the broker
+		request.Version = 2
+		request.RetentionTime = -1
+		timestamp = 0
+	}
+	for topic, partitions := range offsets {
+		for partition, offset := range partitions {
+			request.AddBlock(topic, partition, offset, timestamp, "")
+		}
+	}
+
+	response, err := coordinator.CommitOffset(request)
+	if err != nil {
+		return err
+	}
+	for topic, partitions := range response.Errors {
+		for partition, kerr := range partitions {
+			if !errors.Is(kerr, sarama.ErrNoError) {
+				return fmt.Errorf("replicator: commit of %s/%d: %w", topic, partition, kerr)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,459 @@
+/*
+Package replicator replicates topics from a source Kafka cluster to a target
+one, in the manner of MirrorMaker, for lightweight cross-cluster replication
+jobs written in Go.
+
+The messages of each source partition are produced, with their keys, values,
+headers and timestamps, to the partition of the same number of the target
+topic, named after the source one by Config.Rename. The target topics must
+exist, with at least as many partitions as the source ones.
+
+The progress of the replication is recorded in a checkpoint topic of the
+target cluster, from which it resumes when restarted. The checkpoints map the
+offsets of the source partitions to the ones of the target partitions, so that
+the consumers failing over to the target cluster can translate their committed
+offsets using Checkpoints.Translate. The offsets of the topics replicated by
+other means can be translated by the timestamps of their messages with a
+TimestampTranslator. TranslateGroupOffsets and CommitGroupOffsets translate all
+the offsets of a consumer group and commit them in the target cluster, as in a
+failover runbook:
+
+	offsets, err := replicator.TranslateGroupOffsets(sourceAdmin, "billing", checkpoints)
+	if err != nil {
+		...
+	}
+	err = replicator.CommitGroupOffsets(targetClient, "billing", offsets)
+
+	r, err := replicator.New(sourceAddrs, targetAddrs, &replicator.Config{
+		Topics: []string{"orders"},
+		Rename: replicator.Prefix("east."),
+	})
+	if err != nil {
+		...
+	}
+	defer r.Close()
+	err = r.Run(ctx)
+
+The messages are replicated in batches. By default, a batch is produced before
+its checkpoints, so that messages are replicated at least once. With
+Config.ExactlyOnce, a batch and its checkpoints are produced in a transaction of
+the target cluster, so that each message is replicated exactly once for the
+consumers reading committed messages. Any failure stops Run, which resumes from
+the last checkpoints once called again on a new Replicator.
+
+NOTE: this package currently does not fall under the API stability
+guarantee of Sarama as it is still considered experimental.
+*/
+package replicator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// checkpointPartition is the partition of the checkpoint topic the
+// checkpoints are written to, so that the last one of each source partition
+// is the one read last.
+const checkpointPartition = 0
+
+// RenameFunc returns the name of the target topic of a source topic.
+type RenameFunc func(topic string) string
+
+// Identity keeps the names of the topics.
+func Identity(topic string) string {
+	return topic
+}
+
+// Prefix prefixes the names of the topics, usually with the alias of the
+// source cluster, such as "east.".
+func Prefix(prefix string) RenameFunc {
+	return func(topic string) string {
+		return prefix + topic
+	}
+}
+
+// Rules renames the topics of the map, keeping the names of the others.
+func Rules(rules map[string]string) RenameFunc {
+	return func(topic string) string {
+		if renamed, ok := rules[topic]; ok {
+			return renamed
+		}
+		return topic
+	}
+}
+
+// Config is the configuration of a Replicator.
+type Config struct {
+	// Name identifies the replication in the checkpoint topic, which may be
+	// shared by several replications. Defaults to "replicator".
+	Name string
+	// Source and Target are the configurations of the clients of the source
+	// and the target clusters. They default to sarama.NewConfig(), and are
+	// copied by New, which sets the options the replication requires.
+	Source, Target *sarama.Config
+	// Topics are the source topics to replicate.
+	Topics []string
+	// Rename returns the name of the target topic of a source topic.
+	// Defaults to Identity.
+	Rename RenameFunc
+	// CheckpointTopic is the topic of the target cluster where the
+	// checkpoints are written. It should be compacted. Defaults to
+	// "replicator.checkpoints".
+	CheckpointTopic string
+	// InitialOffset is the offset the replication of the source partitions
+	// without checkpoint starts from, sarama.OffsetOldest or
+	// sarama.OffsetNewest. Defaults to sarama.OffsetOldest.
+	InitialOffset int64
+	// ExactlyOnce produces the batches and their checkpoints in
+	// transactions. It requires Target.Version to be at least V0_11_0_0, and
+	// Target.Producer.Transaction.ID defaults to "replicator-" + Name.
+	ExactlyOnce bool
+	// BatchSize is the maximum number of messages of a batch. Defaults to 500.
+	BatchSize int
+	// FlushInterval is the maximum time a message waits for its batch to be
+	// full. Defaults to one second.
+	FlushInterval time.Duration
+}
+
+func (c *Config) setDefaults() {
+	if c.Name == "" {
+		c.Name = "replicator"
+	}
+	if c.Source == nil {
+		c.Source = sarama.NewConfig()
+	}
+	if c.Target == nil {
+		c.Target = sarama.NewConfig()
+	}
+	if c.Rename == nil {
+		c.Rename = Identity
+	}
+	if c.CheckpointTopic == "" {
+		c.CheckpointTopic = "replicator.checkpoints"
+	}
+	if c.InitialOffset == 0 {
+		c.InitialOffset = sarama.OffsetOldest
+	}
+	if c.BatchSize == 0 {
+		c.BatchSize = 500
+	}
+	if c.FlushInterval == 0 {
+		c.FlushInterval = time.Second
+	}
+}
+
+// validate checks the configuration, once the defaults have been applied.
+func (c *Config) validate() error {
+	switch {
+	case len(c.Topics) == 0:
+		return sarama.ConfigurationError("replicator: no topic to replicate")
+	case c.InitialOffset != sarama.OffsetOldest && c.InitialOffset != sarama.OffsetNewest:
+		return sarama.ConfigurationError("replicator: InitialOffset must be OffsetOldest or OffsetNewest")
+	case c.BatchSize < 0:
+		return sarama.ConfigurationError("replicator: BatchSize must be > 0")
+	case c.FlushInterval < 0:
+		return sarama.ConfigurationError("replicator: FlushInterval must be > 0")
+	case c.ExactlyOnce && !c.Target.Version.IsAtLeast(sarama.V0_11_0_0):
+		return sarama.ConfigurationError("replicator: ExactlyOnce requires Target.Version >= V0_11_0_0")
+	}
+	for _, topic := range c.Topics {
+		if c.Rename(topic) == c.CheckpointTopic {
+			return sarama.ConfigurationError("replicator: topic " + topic + " would be replicated to the checkpoint topic")
+		}
+	}
+	return nil
+}
+
+// Replicator replicates topics from a source cluster to a target cluster.
+type Replicator struct {
+	conf        Config
+	source      sarama.Client
+	target      sarama.Client
+	consumer    sarama.Consumer
+	producer    sarama.AsyncProducer
+	checkpoints *Checkpoints
+
+	closeOnce sync.Once
+}
+
+// New returns a Replicator from the source cluster of sourceAddrs to the target
+// cluster of targetAddrs, loading the checkpoints of the replication.
+func New(sourceAddrs, targetAddrs []string, config *Config) (*Replicator, error) {
+	conf := *config
+	conf.setDefaults()
+	if err := conf.validate(); err != nil {
+		return nil, err
+	}
+
+	source := *conf.Source
+	source.Consumer.Return.Errors = true
+	conf.Source = &source
+
+	target := *conf.Target
+	target.Producer.Return.Successes = true
+	target.Producer.Return.Errors = true
+	target.Producer.Partitioner = sarama.NewManualPartitioner
+	target.Consumer.Return.Errors = true
+	if conf.ExactlyOnce {
+		if target.Producer.Transaction.ID == "" {
+			target.Producer.Transaction.ID = "replicator-" + conf.Name
+		}
+		target.Producer.Idempotent = true
+		target.Producer.RequiredAcks = sarama.WaitForAll
+		target.Net.MaxOpenRequests = 1
+		target.Consumer.IsolationLevel = sarama.ReadCommitted
+	}
+	conf.Target = &target
+
+	r := &Replicator{conf: conf}
+	var err error
+	if r.source, err = sarama.NewClient(sourceAddrs, conf.Source); err != nil {
+		return nil, err
+	}
+	if r.target, err = sarama.NewClient(targetAddrs, conf.Target); err != nil {
+		_ = r.Close()
+		return nil, err
+	}
+	// Creating the transactional producer aborts the ongoing transaction of
+	// a previous replicator, before the checkpoints are loaded.
+	if r.producer, err = sarama.NewAsyncProducerFromClient(r.target); err != nil {
+		_ = r.Close()
+		return nil, err
+	}
+	if r.checkpoints, err = LoadCheckpoints(r.target, conf.CheckpointTopic, conf.Name); err != nil {
+		_ = r.Close()
+		return nil, err
+	}
+	if r.consumer, err = sarama.NewConsumerFromClient(r.source); err != nil {
+		_ = r.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// Checkpoints returns the checkpoints of the replication, which are updated
+// as it progresses.
+func (r *Replicator) Checkpoints() *Checkpoints {
+	return r.checkpoints
+}
+
+// Run replicates the topics until ctx is done, returning nil, or the
+// replication fails. A Replicator is not meant to run again once Run returned
+// an error: a new one resumes the replication from its checkpoints.
+func (r *Replicator) Run(ctx context.Context) error {
+	messages := make(chan *sarama.ConsumerMessage, r.conf.BatchSize)
+	errs := make(chan error, 1)
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	defer func() {
+		close(done)
+		wg.Wait()
+	}()
+
+	for _, topic := range r.conf.Topics {
+		partitions, err := r.source.Partitions(topic)
+		if err != nil {
+			return err
+		}
+		for _, partition := range partitions {
+			offset := r.conf.InitialOffset
+			if checkpoint, ok := r.checkpoints.Latest(topic, partition); ok {
+				offset = checkpoint.SourceOffset + 1
+			}
+			pc, err := r.consumer.ConsumePartition(topic, partition, offset)
+			if err != nil {
+				return err
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer pc.AsyncClose()
+				for {
+					select {
+					case msg := <-pc.Messages():
+						select {
+						case messages <- msg:
+						case <-done:
+							return
+						}
+					case err := <-pc.Errors():
+						select {
+						case errs <- err:
+						default:
+						}
+						return
+					case <-done:
+						return
+					}
+				}
+			}()
+		}
+	}
+
+	for {
+		batch, err := r.collect(ctx, messages, errs)
+		if err != nil {
+			return err
+		}
+		if len(batch) > 0 {
+			if err := r.replicate(batch); err != nil {
+				return err
+			}
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+	}
+}
+
+// collect returns the next batch of messages, once it is full, it has waited
+// for FlushInterval, or ctx is done.
+func (r *Replicator) collect(ctx context.Context, messages <-chan *sarama.ConsumerMessage, errs <-chan error) ([]*sarama.ConsumerMessage, error) {
+	var batch []*sarama.ConsumerMessage
+	var flush <-chan time.Time
+	for len(batch) < r.conf.BatchSize {
+		select {
+		case msg := <-messages:
+			if batch == nil {
+				timer := time.NewTimer(r.conf.FlushInterval)
+				defer timer.Stop()
+				flush = timer.C
+			}
+			batch = append(batch, msg)
+		case err := <-errs:
+			return nil, err
+		case <-flush:
+			return batch, nil
+		case <-ctx.Done():
+			return batch, nil
+		}
+	}
+	return batch, nil
+}
+
+// replicate produces a batch of messages and their checkpoints.
+func (r *Replicator) replicate(batch []*sarama.ConsumerMessage) (err error) {
+	if r.conf.ExactlyOnce {
+		if err := r.producer.BeginTxn(); err != nil {
+			return err
+		}
+		defer func() {
+			if err != nil {
+				if abortErr := r.producer.AbortTxn(); abortErr != nil {
+					err = fmt.Errorf("%w (failed to abort the transaction: %v)", err, abortErr)
+				}
+			}
+		}()
+	}
+
+	for _, msg := range batch {
+		replicated := &sarama.ProducerMessage{
+			Topic:     r.conf.Rename(msg.Topic),
+			Partition: msg.Partition,
+			Timestamp: msg.Timestamp,
+			Metadata:  msg,
+		}
+		if msg.Key != nil {
+			replicated.Key = sarama.ByteEncoder(msg.Key)
+		}
+		if msg.Value != nil {
+			replicated.Value = sarama.ByteEncoder(msg.Value)
+		}
+		for _, h := range msg.Headers {
+			replicated.Headers = append(replicated.Headers, *h)
+		}
+		r.producer.Input() <- replicated
+	}
+
+	latest := make(map[topicPartition]Checkpoint)
+	for _, produced := range r.await(len(batch), &err) {
+		source := produced.Metadata.(*sarama.ConsumerMessage)
+		tp := topicPartition{source.Topic, source.Partition}
+		if checkpoint, ok := latest[tp]; ok && checkpoint.SourceOffset > source.Offset {
+			continue
+		}
+		latest[tp] = Checkpoint{
+			SourceTopic:     source.Topic,
+			SourcePartition: source.Partition,
+			SourceOffset:    source.Offset,
+			TargetTopic:     produced.Topic,
+			TargetOffset:    produced.Offset,
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, checkpoint := range latest {
+		value, err := json.Marshal(checkpoint)
+		if err != nil {
+			return err
+		}
+		r.producer.Input() <- &sarama.ProducerMessage{
+			Topic:     r.conf.CheckpointTopic,
+			Partition: checkpointPartition,
+			Key:       sarama.StringEncoder(checkpoint.key(r.conf.Name)),
+			Value:     sarama.ByteEncoder(value),
+		}
+	}
+	if r.await(len(latest), &err); err != nil {
+		return err
+	}
+
+	if r.conf.ExactlyOnce {
+		if err := r.producer.CommitTxn(); err != nil {
+			return err
+		}
+	}
+	for _, checkpoint := range latest {
+		r.checkpoints.add(checkpoint)
+	}
+	return nil
+}
+
+// await waits for the result of n messages, returning the ones produced and
+// setting err to the first error.
+func (r *Replicator) await(n int, err *error) []*sarama.ProducerMessage {
+	produced := make([]*sarama.ProducerMessage, 0, n)
+	for i := 0; i < n; i++ {
+		select {
+		case msg := <-r.producer.Successes():
+			produced = append(produced, msg)
+		case pErr := <-r.producer.Errors():
+			if *err == nil {
+				*err = pErr
+			}
+		}
+	}
+	return produced
+}
+
+// Close closes the clients of the replicator.
+func (r *Replicator) Close() error {
+	var errs []error
+	r.closeOnce.Do(func() {
+		if r.consumer != nil {
+			errs = append(errs, r.consumer.Close())
+		}
+		if r.producer != nil {
+			errs = append(errs, r.producer.Close())
+		}
+		if r.target != nil && !r.target.Closed() {
+			errs = append(errs, r.target.Close())
+		}
+		if r.source != nil && !r.source.Closed() {
+			errs = append(errs, r.source.Close())
+		}
+	})
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
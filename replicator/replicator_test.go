@@ -0,0 +1,364 @@
+package replicator
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+func TestRename(t *testing.T) {
+	if Identity("orders") != "orders" {
+		t.Error("expected Identity to keep the name")
+	}
+	if Prefix("east.")("orders") != "east.orders" {
+		t.Error("expected Prefix to prefix the name")
+	}
+	rules := Rules(map[string]string{"orders": "orders-copy"})
+	if rules("orders") != "orders-copy" || rules("payments") != "payments" {
+		t.Error("expected Rules to rename the topics of the map only")
+	}
+}
+
+func TestCheckpointEncoding(t *testing.T) {
+	checkpoint := Checkpoint{SourceTopic: "a/b", SourcePartition: 3, SourceOffset: 42, TargetTopic: "east.a/b", TargetOffset: 7}
+	value, err := json.Marshal(checkpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, ok, err := parseCheckpoint("job", []byte(checkpoint.key("job")), value)
+	if err != nil || !ok || decoded != checkpoint {
+		t.Errorf("expected %+v, got %+v %v %v", checkpoint, decoded, ok, err)
+	}
+	if _, ok, err := parseCheckpoint("other", []byte(checkpoint.key("job")), value); ok || err != nil {
+		t.Error("expected the checkpoints of other replications to be ignored, got", ok, err)
+	}
+	if _, _, err := parseCheckpoint("job", []byte("job/a"), value); err == nil {
+		t.Error("expected an invalid key to be rejected")
+	}
+}
+
+func TestCheckpointsTranslate(t *testing.T) {
+	checkpoints := newCheckpoints()
+	for _, c := range []Checkpoint{
+		{SourceTopic: "orders", SourceOffset: 20, TargetTopic: "east.orders", TargetOffset: 120},
+		{SourceTopic: "orders", SourceOffset: 10, TargetTopic: "east.orders", TargetOffset: 110},
+		{SourceTopic: "orders", SourceOffset: 30, TargetTopic: "east.orders", TargetOffset: 125},
+	} {
+		checkpoints.add(c)
+	}
+
+	if latest, ok := checkpoints.Latest("orders", 0); !ok || latest.SourceOffset != 30 {
+		t.Error("expected the latest checkpoint to be the one of offset 30, got", latest, ok)
+	}
+	if _, ok := checkpoints.Latest("orders", 1); ok {
+		t.Error("expected no checkpoint for partition 1")
+	}
+
+	for sourceOffset, expected := range map[int64]int64{11: 111, 20: 111, 21: 121, 25: 121, 31: 126, 1000: 126} {
+		topic, offset, ok := checkpoints.Translate("orders", 0, sourceOffset)
+		if !ok || topic != "east.orders" || offset != expected {
+			t.Errorf("expected offset %d to be translated to %d, got %s %d %v", sourceOffset, expected, topic, offset, ok)
+		}
+	}
+	if _, _, ok := checkpoints.Translate("orders", 0, 10); ok {
+		t.Error("expected no translation before the first checkpoint")
+	}
+}
+
+func TestConfigValidation(t *testing.T) {
+	for name, config := range map[string]*Config{
+		"no topic":              {},
+		"initial offset":        {Topics: []string{"orders"}, InitialOffset: 42},
+		"checkpoint topic":      {Topics: []string{"replicator.checkpoints"}},
+		"exactly once on 0.10.": {Topics: []string{"orders"}, ExactlyOnce: true, Target: &sarama.Config{Version: sarama.V0_10_2_0}},
+	} {
+		if _, err := New([]string{"localhost:0"}, []string{"localhost:0"}, config); err == nil {
+			t.Errorf("%s: expected the configuration to be rejected", name)
+		}
+	}
+}
+
+type clusters struct {
+	source, target *sarama.MockBroker
+	config         *Config
+}
+
+// newClusters returns a source cluster holding three messages in my_topic,
+// and a target cluster whose checkpoint topic holds the checkpoints.
+func newClusters(t *testing.T, exactlyOnce bool, checkpoints ...Checkpoint) *clusters {
+	c := &clusters{
+		source: sarama.NewMockBroker(t, 1),
+		target: sarama.NewMockBroker(t, 2),
+	}
+
+	fetch := sarama.NewMockFetchResponse(t, 1)
+	for i := 0; i < 3; i++ {
+		fetch.SetMessageWithKey("my_topic", 0, int64(i), sarama.StringEncoder("key"), sarama.StringEncoder("value"))
+	}
+	c.source.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(c.source.Addr(), c.source.BrokerID()).
+			SetLeader("my_topic", 0, c.source.BrokerID()),
+		"OffsetRequest": sarama.NewMockOffsetResponse(t).
+			SetOffset("my_topic", 0, sarama.OffsetOldest, 0).
+			SetOffset("my_topic", 0, sarama.OffsetNewest, 3),
+		"FetchRequest": fetch,
+	})
+
+	checkpointFetch := sarama.NewMockFetchResponse(t, 1)
+	for i, checkpoint := range checkpoints {
+		value, _ := json.Marshal(checkpoint)
+		checkpointFetch.SetMessageWithKey("replicator.checkpoints", 0, int64(i),
+			sarama.StringEncoder(checkpoint.key("replicator")), sarama.ByteEncoder(value))
+	}
+	handlers := map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(c.target.Addr(), c.target.BrokerID()).
+			SetController(c.target.BrokerID()).
+			SetLeader("backup.my_topic", 0, c.target.BrokerID()).
+			SetLeader("replicator.checkpoints", 0, c.target.BrokerID()),
+		"OffsetRequest": sarama.NewMockOffsetResponse(t).
+			SetOffset("replicator.checkpoints", 0, sarama.OffsetOldest, 0).
+			SetOffset("replicator.checkpoints", 0, sarama.OffsetNewest, int64(len(checkpoints))),
+		"FetchRequest":   checkpointFetch,
+		"ProduceRequest": sarama.NewMockProduceResponse(t),
+	}
+
+	c.config = &Config{
+		Source:        sarama.NewConfig(),
+		Target:        sarama.NewConfig(),
+		Topics:        []string{"my_topic"},
+		Rename:        Prefix("backup."),
+		FlushInterval: 10 * time.Millisecond,
+		ExactlyOnce:   exactlyOnce,
+	}
+	for _, config := range []*sarama.Config{c.config.Source, c.config.Target} {
+		config.Metadata.Retry.Max = 0
+		config.Consumer.MaxWaitTime = 50 * time.Millisecond
+	}
+	if exactlyOnce {
+		c.config.Target.Version = sarama.V2_7_0_0
+		c.config.Target.Producer.Transaction.Retry.Backoff = 0
+		coordinator := sarama.NewMockTransactionCoordinator(t, c.target)
+		handlers["ApiVersionsRequest"] = sarama.NewMockApiVersionsResponse(t)
+		for _, request := range []string{"FindCoordinatorRequest", "InitProducerIDRequest", "AddPartitionsToTxnRequest", "EndTxnRequest"} {
+			handlers[request] = coordinator
+		}
+	}
+	c.target.SetHandlerByMap(handlers)
+	return c
+}
+
+func (c *clusters) close() {
+	c.source.Close()
+	c.target.Close()
+}
+
+// produced returns the number of produce requests received by the target.
+func (c *clusters) produced() int {
+	n := 0
+	for _, rr := range c.target.History() {
+		if _, ok := interface{}(rr.Request).(*sarama.ProduceRequest); ok {
+			n++
+		}
+	}
+	return n
+}
+
+// run runs the replicator until it checkpointed the source offset 2.
+func run(t *testing.T, r *Replicator) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- r.Run(ctx) }()
+
+	deadline := time.After(5 * time.Second)
+	for {
+		if checkpoint, ok := r.Checkpoints().Latest("my_topic", 0); ok && checkpoint.SourceOffset == 2 {
+			break
+		}
+		select {
+		case err := <-done:
+			t.Fatal("replication stopped:", err)
+		case <-deadline:
+			t.Fatal("timed out waiting for the replication")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+}
+
+func TestReplicator(t *testing.T) {
+	for _, exactlyOnce := range []bool{false, true} {
+		c := newClusters(t, exactlyOnce)
+		r, err := New([]string{c.source.Addr()}, []string{c.target.Addr()}, c.config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		run(t, r)
+
+		checkpoint, _ := r.Checkpoints().Latest("my_topic", 0)
+		if checkpoint.TargetTopic != "backup.my_topic" {
+			t.Error("expected the messages to be replicated to backup.my_topic, got", checkpoint)
+		}
+		if c.produced() < 2 {
+			t.Error("expected the messages and their checkpoints to be produced")
+		}
+		if err := r.Close(); err != nil {
+			t.Error(err)
+		}
+		c.close()
+	}
+}
+
+func TestReplicatorResumesFromCheckpoints(t *testing.T) {
+	c := newClusters(t, false, Checkpoint{
+		SourceTopic:  "my_topic",
+		SourceOffset: 1,
+		TargetTopic:  "backup.my_topic",
+		TargetOffset: 41,
+	})
+	defer c.close()
+
+	r, err := New([]string{c.source.Addr()}, []string{c.target.Addr()}, c.config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if topic, offset, ok := r.Checkpoints().Translate("my_topic", 0, 2); !ok || topic != "backup.my_topic" || offset != 42 {
+		t.Error("expected the checkpoints to be loaded, got", topic, offset, ok)
+	}
+
+	run(t, r)
+	// The message of offset 2 and its checkpoint only.
+	if n := c.produced(); n != 2 {
+		t.Errorf("expected the replication to resume from offset 2, got %d produce requests", n)
+	}
+}
+
+func TestTimestampTranslator(t *testing.T) {
+	source := sarama.NewMockBroker(t, 1)
+	defer source.Close()
+	target := sarama.NewMockBroker(t, 2)
+	defer target.Close()
+
+	start := time.Unix(1700000000, 0)
+	ms := func(d time.Duration) int64 { return start.Add(d).UnixNano() / int64(time.Millisecond) }
+	batch := sarama.NewMockRecordBatch(0).SetTimestamp(start)
+	for i := 0; i < 3; i++ {
+		batch.AddRecordWithTimestamp(nil, sarama.StringEncoder("value"), start.Add(time.Duration(i)*time.Second))
+	}
+	source.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(source.Addr(), source.BrokerID()).
+			SetLeader("my_topic", 0, source.BrokerID()),
+		"OffsetRequest": sarama.NewMockOffsetResponse(t).
+			SetOffset("my_topic", 0, sarama.OffsetOldest, 0).
+			SetOffset("my_topic", 0, sarama.OffsetNewest, 3),
+		"FetchRequest": sarama.NewMockFetchResponse(t, 1).
+			SetRecordBatch("my_topic", 0, batch).
+			SetHighWaterMark("my_topic", 0, 3),
+	})
+	target.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(target.Addr(), target.BrokerID()).
+			SetLeader("backup.my_topic", 0, target.BrokerID()),
+		"OffsetRequest": sarama.NewMockOffsetResponse(t).
+			SetOffset("backup.my_topic", 0, ms(time.Second), 7).
+			SetOffset("backup.my_topic", 0, ms(2*time.Second), -1).
+			SetOffset("backup.my_topic", 0, sarama.OffsetNewest, 10),
+	})
+
+	config := sarama.NewConfig()
+	config.Version = sarama.V2_1_0_0
+	config.Consumer.MaxWaitTime = 50 * time.Millisecond
+	sourceClient, err := sarama.NewClient([]string{source.Addr()}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sourceClient.Close()
+	targetClient, err := sarama.NewClient([]string{target.Addr()}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer targetClient.Close()
+
+	translator := &TimestampTranslator{Source: sourceClient, Target: targetClient, Rename: Prefix("backup.")}
+	for sourceOffset, expected := range map[int64]int64{1: 7, 2: 10, 3: 10} {
+		topic, offset, ok, err := translator.TranslateOffset("my_topic", 0, sourceOffset)
+		if err != nil || !ok || topic != "backup.my_topic" || offset != expected {
+			t.Errorf("expected offset %d to be translated to %d, got %s %d %v %v", sourceOffset, expected, topic, offset, ok, err)
+		}
+	}
+}
+
+func TestTranslateAndCommitGroupOffsets(t *testing.T) {
+	source := sarama.NewMockBroker(t, 1)
+	defer source.Close()
+	target := sarama.NewMockBroker(t, 2)
+	defer target.Close()
+
+	source.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(source.Addr(), source.BrokerID()).
+			SetController(source.BrokerID()),
+		"FindCoordinatorRequest": sarama.NewMockFindCoordinatorResponse(t).
+			SetCoordinator(sarama.CoordinatorGroup, "my_group", source),
+		"OffsetFetchRequest": sarama.NewMockOffsetFetchResponse(t).
+			SetOffset("my_group", "my_topic", 0, 25, "", sarama.ErrNoError).
+			SetOffset("my_group", "my_topic", 1, 5, "", sarama.ErrNoError).
+			SetOffset("my_group", "my_topic", 2, -1, "", sarama.ErrNoError).
+			SetError(sarama.ErrNoError),
+	})
+	target.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(target.Addr(), target.BrokerID()),
+		"FindCoordinatorRequest": sarama.NewMockFindCoordinatorResponse(t).
+			SetCoordinator(sarama.CoordinatorGroup, "my_group", target),
+		"OffsetCommitRequest": sarama.NewMockOffsetCommitResponse(t),
+	})
+
+	config := sarama.NewConfig()
+	config.Version = sarama.V2_1_0_0
+	admin, err := sarama.NewClusterAdmin([]string{source.Addr()}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer admin.Close()
+
+	checkpoints := newCheckpoints()
+	checkpoints.add(Checkpoint{SourceTopic: "my_topic", SourceOffset: 20, TargetTopic: "backup.my_topic", TargetOffset: 120})
+	offsets, err := TranslateGroupOffsets(admin, "my_group", checkpoints)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// partition 1 precedes the first checkpoint and partition 2 has no offset
+	if len(offsets) != 1 || len(offsets["backup.my_topic"]) != 1 || offsets["backup.my_topic"][0] != 121 {
+		t.Fatal("expected the offset of partition 0 only to be translated, got", offsets)
+	}
+
+	client, err := sarama.NewClient([]string{target.Addr()}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if err := CommitGroupOffsets(client, "my_group", offsets); err != nil {
+		t.Fatal(err)
+	}
+	committed := false
+	for _, rr := range target.History() {
+		if request, ok := interface{}(rr.Request).(*sarama.OffsetCommitRequest); ok {
+			committed = request.ConsumerGroup == "my_group"
+		}
+	}
+	if !committed {
+		t.Error("expected the offsets of my_group to be committed to the target cluster")
+	}
+}
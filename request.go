@@ -228,6 +228,15 @@ func allocateBody(key, version int16) protocolBody {
 		// 66: ListTransactionsRequest
 		// 67: AllocateProducerIdsRequest
 		// 68: ConsumerGroupHeartbeatRequest
+	case 69:
+		return &ConsumerGroupDescribeRequest{Version: version}
+		// 70: ControllerRegistrationRequest
+		// 71: GetTelemetrySubscriptionsRequest
+		// 72: PushTelemetryRequest
+		// 73: AssignReplicasToDirsRequest
+		// 74: ListClientMetricsResourcesRequest
+	case 75:
+		return &DescribeTopicPartitionsRequest{Version: version}
 	}
 	return nil
 }
@@ -0,0 +1,78 @@
+package sarama
+
+import "sync"
+
+// requestPriority is the class of a request among the requests waiting to be
+// sent on a broker connection. The requests keeping the membership of a
+// consumer group, its offsets and the metadata up to date are sent before the
+// produce and fetch requests waiting for the connection, so that a heavy
+// produce load does not delay the heartbeats of a member past the session
+// timeout of its group, making the coordinator rebalance the group.
+type requestPriority int
+
+const (
+	priorityLow requestPriority = iota
+	priorityNormal
+	priorityHigh
+
+	numRequestPriorities
+)
+
+func priorityOf(rb protocolBody) requestPriority {
+	switch rb.(type) {
+	case *HeartbeatRequest, *JoinGroupRequest, *SyncGroupRequest, *LeaveGroupRequest,
+		*OffsetCommitRequest, *FindCoordinatorRequest, *MetadataRequest:
+		return priorityHigh
+	case *ProduceRequest, *FetchRequest:
+		return priorityLow
+	default:
+		return priorityNormal
+	}
+}
+
+// priorityLock is the lock of a broker connection. It is handed over to the
+// waiters of the highest priority first, in the order they arrived among the
+// waiters of the same priority. It may be unlocked by another goroutine than
+// the one which locked it, as a sync.Mutex.
+type priorityLock struct {
+	mu      sync.Mutex
+	locked  bool
+	waiters [numRequestPriorities][]chan none
+}
+
+// Lock locks l with the normal priority.
+func (l *priorityLock) Lock() {
+	l.lockWithPriority(priorityNormal)
+}
+
+func (l *priorityLock) lockWithPriority(priority requestPriority) {
+	l.mu.Lock()
+	if !l.locked {
+		l.locked = true
+		l.mu.Unlock()
+		return
+	}
+	handover := make(chan none)
+	l.waiters[priority] = append(l.waiters[priority], handover)
+	l.mu.Unlock()
+
+	// the lock stays locked when handed over
+	<-handover
+}
+
+func (l *priorityLock) Unlock() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.locked {
+		panic("sarama: unlock of an unlocked priorityLock")
+	}
+	for priority := numRequestPriorities - 1; priority >= 0; priority-- {
+		if waiters := l.waiters[priority]; len(waiters) > 0 {
+			l.waiters[priority] = waiters[1:]
+			close(waiters[0])
+			return
+		}
+	}
+	l.locked = false
+}
@@ -0,0 +1,68 @@
+package sarama
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPriorityLockHandsOverByPriority(t *testing.T) {
+	var l priorityLock
+	l.Lock()
+
+	order := make(chan requestPriority, 3)
+	waitFor := func(n int) {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); ; {
+			l.mu.Lock()
+			waiting := 0
+			for _, waiters := range l.waiters {
+				waiting += len(waiters)
+			}
+			l.mu.Unlock()
+			if waiting == n {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("expected %d waiters, got %d", n, waiting)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	for i, priority := range []requestPriority{priorityLow, priorityNormal, priorityHigh} {
+		go func(priority requestPriority) {
+			l.lockWithPriority(priority)
+			order <- priority
+			l.Unlock()
+		}(priority)
+		waitFor(i + 1)
+	}
+
+	l.Unlock()
+	for _, expected := range []requestPriority{priorityHigh, priorityNormal, priorityLow} {
+		if priority := <-order; priority != expected {
+			t.Errorf("expected the lock to go to priority %d, got %d", expected, priority)
+		}
+	}
+
+	// the lock is free again
+	l.lockWithPriority(priorityLow)
+	l.Unlock()
+}
+
+func TestPriorityOfRequests(t *testing.T) {
+	for _, test := range []struct {
+		request  protocolBody
+		priority requestPriority
+	}{
+		{&HeartbeatRequest{}, priorityHigh},
+		{&OffsetCommitRequest{}, priorityHigh},
+		{&MetadataRequest{}, priorityHigh},
+		{&ProduceRequest{}, priorityLow},
+		{&FetchRequest{}, priorityLow},
+		{&OffsetRequest{}, priorityNormal},
+	} {
+		if priority := priorityOf(test.request); priority != test.priority {
+			t.Errorf("expected priority %d for %T, got %d", test.priority, test.request, priority)
+		}
+	}
+}
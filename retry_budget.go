@@ -0,0 +1,91 @@
+package sarama
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+// anyBrokerID is the key of the retry budget shared by the retries which are
+// not bound to a broker, such as the metadata and admin retries.
+const anyBrokerID int32 = -1
+
+// retryBudget implements Config.RetryBudget: it allows up to max retries per
+// broker every interval. A nil retryBudget allows every retry.
+type retryBudget struct {
+	max      int
+	interval time.Duration
+	registry metrics.Registry
+
+	lock    sync.Mutex
+	buckets map[int32]*retryBucket
+}
+
+type retryBucket struct {
+	available int
+	refilled  time.Time
+}
+
+func newRetryBudget(conf *Config) *retryBudget {
+	if conf.RetryBudget.Max <= 0 {
+		return nil
+	}
+	return &retryBudget{
+		max:      conf.RetryBudget.Max,
+		interval: conf.RetryBudget.Interval,
+		registry: conf.MetricRegistry,
+		buckets:  make(map[int32]*retryBucket),
+	}
+}
+
+// allow consumes a retry of the budget of the given broker, returning false if
+// it is exhausted.
+func (b *retryBudget) allow(brokerID int32) bool {
+	if b == nil {
+		return true
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	now := time.Now()
+	bucket := b.buckets[brokerID]
+	if bucket == nil || now.Sub(bucket.refilled) >= b.interval {
+		bucket = &retryBucket{available: b.max, refilled: now}
+		b.buckets[brokerID] = bucket
+	}
+
+	allowed := bucket.available > 0
+	if allowed {
+		bucket.available--
+	} else {
+		metrics.GetOrRegisterMeter("retry-budget-exhausted-rate", b.registry).Mark(1)
+	}
+	if brokerID != anyBrokerID {
+		metrics.GetOrRegisterGauge(fmt.Sprintf("retry-budget-available-for-broker-%d", brokerID), b.registry).Update(int64(bucket.available))
+		if !allowed {
+			metrics.GetOrRegisterMeter(fmt.Sprintf("retry-budget-exhausted-rate-for-broker-%d", brokerID), b.registry).Mark(1)
+		}
+	}
+	return allowed
+}
+
+// replenishment returns how long a retry rejected by the budget must wait
+// for the budget to be replenished.
+func (b *retryBudget) replenishment() time.Duration {
+	if b == nil {
+		return 0
+	}
+	return b.interval
+}
+
+// retryBudgetOf returns the retry budget of a client, which is shared by the
+// producers, consumers and admin clients using it.
+func retryBudgetOf(client Client) *retryBudget {
+	if c, ok := client.(interface{ retryBudget() *retryBudget }); ok {
+		return c.retryBudget()
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package sarama
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+func TestRetryBudget(t *testing.T) {
+	conf := NewTestConfig()
+	conf.RetryBudget.Max = 2
+	conf.RetryBudget.Interval = 50 * time.Millisecond
+	budget := newRetryBudget(conf)
+
+	for i := 0; i < 2; i++ {
+		if !budget.allow(1) {
+			t.Fatal("expected retry", i, "to be allowed")
+		}
+	}
+	if budget.allow(1) {
+		t.Error("expected the budget of broker 1 to be exhausted")
+	}
+	if !budget.allow(2) {
+		t.Error("expected the budget of broker 2 to be independent of broker 1")
+	}
+	if !budget.allow(anyBrokerID) {
+		t.Error("expected the budget of the retries not bound to a broker to be independent")
+	}
+
+	if exhausted := metrics.GetOrRegisterMeter("retry-budget-exhausted-rate-for-broker-1", conf.MetricRegistry).Count(); exhausted != 1 {
+		t.Error("expected 1 exhausted retry for broker 1, got", exhausted)
+	}
+	if available := metrics.GetOrRegisterGauge("retry-budget-available-for-broker-2", conf.MetricRegistry).Value(); available != 1 {
+		t.Error("expected 1 available retry for broker 2, got", available)
+	}
+
+	time.Sleep(budget.replenishment())
+	if !budget.allow(1) {
+		t.Error("expected the budget of broker 1 to be replenished")
+	}
+}
+
+func TestRetryBudgetDisabled(t *testing.T) {
+	budget := newRetryBudget(NewTestConfig())
+	if budget != nil {
+		t.Fatal("expected no retry budget by default")
+	}
+	for i := 0; i < 100; i++ {
+		if !budget.allow(1) {
+			t.Fatal("expected every retry to be allowed without a retry budget")
+		}
+	}
+}
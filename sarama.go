@@ -47,6 +47,10 @@ Broker related metrics:
 	|                                                         |            | https://kafka.apache.org/protocol.html#protocol_api_keys      |                                        |
 	| protocol-requests-rate-<api-key>-for-broker-<broker-id> | meter      | Number of packets sent to the brokers by api-key for a given  |
 	|                                                         |            | broker                                                        |
+	| retry-budget-exhausted-rate                             | meter      | Retries/second rejected by the retry budget for all brokers   |
+	| retry-budget-exhausted-rate-for-broker-<broker-id>      | meter      | Retries/second rejected by the retry budget for a given       |
+	|                                                         |            | broker                                                        |
+	| retry-budget-available-for-broker-<broker-id>           | gauge      | The retries left in the retry budget of a given broker        |
 	+---------------------------------------------------------+------------+---------------------------------------------------------------+
 
 Note that we do not gather specific metrics for seed brokers but they are part of the "all brokers" metrics.
@@ -64,6 +68,12 @@ Producer related metrics:
 	| records-per-request-for-topic-<topic>     | histogram  | Distribution of the number of records sent per request for a given topic             |
 	| compression-ratio                         | histogram  | Distribution of the compression ratio times 100 of record batches for all topics     |
 	| compression-ratio-for-topic-<topic>       | histogram  | Distribution of the compression ratio times 100 of record batches for a given topic  |
+	| queue-wait-time-in-ms                     | histogram  | Distribution of the time in ms the oldest record of a batch waited before being sent |
+	| queue-wait-time-in-ms-for-partition-<tp>  | histogram  | Same as queue-wait-time-in-ms for a given partition, <tp> being <topic>-<partition>  |
+	| txn-commit-rate                           | meter      | Transactions/second committed                                                        |
+	| txn-abort-rate                            | meter      | Transactions/second aborted                                                          |
+	| txn-error-rate                            | meter      | Transitions/second of the transactional producer to an error state                   |
+	| txn-coordinator-error-rate                | meter      | Failed requests/second to the transaction and group coordinators, before retrying    |
 	+-------------------------------------------+------------+--------------------------------------------------------------------------------------+
 
 Consumer related metrics:
@@ -76,6 +86,7 @@ Consumer related metrics:
 	| consumer-fetch-rate-for-broker-<broker>   | meter      | Fetch requests/second sent to a given broker                                         |
 	| consumer-fetch-rate-for-topic-<topic>     | meter      | Fetch requests/second sent for a given topic                                         |
 	| consumer-fetch-response-size              | histogram  | Distribution of the fetch response size in bytes                                     |
+	| consumer-corrupt-batch-rate               | meter      | Record batches/second whose CRC does not match, see Consumer.CRC                     |
 	| consumer-group-join-total-<GroupID>       | counter    | Total count of consumer group join attempts                                          |
 	| consumer-group-join-failed-<GroupID>      | counter    | Total count of consumer group join failures                                          |
 	| consumer-group-sync-total-<GroupID>       | counter    | Total count of consumer group sync attempts                                          |
@@ -87,6 +98,7 @@ package sarama
 import (
 	"io"
 	"log"
+	"math"
 )
 
 var (
@@ -111,6 +123,21 @@ var (
 	// the size of responses they send. In particular, they can send arbitrarily large fetch responses to consumers
 	// (see https://issues.apache.org/jira/browse/KAFKA-2063).
 	MaxResponseSize int32 = 100 * 1024 * 1024
+
+	// MaxDecodeArrayLength is the maximum number of elements of any array Sarama will decode from a response
+	// (or, for MockBroker, a request). A longer array is rejected with a DecodingLimitError rather than
+	// allocated, protecting the process from corrupt or malicious length prefixes.
+	MaxDecodeArrayLength = 2 * math.MaxUint16
+
+	// MaxDecodeStringLength is the maximum length (in bytes) of any string Sarama will decode. A longer string
+	// is rejected with a DecodingLimitError. It does not apply to byte fields, such as the keys and values of
+	// records, which are not copied when decoded.
+	MaxDecodeStringLength = 1024 * 1024
+
+	// MaxDecodeAllocation is the maximum number of bytes Sarama will allocate for the arrays and the strings of
+	// a single decoded response, as estimated from their length prefixes before allocating them. A response
+	// requiring more is rejected with a DecodingLimitError. Set it to 0 to disable the limit.
+	MaxDecodeAllocation int64 = 4 * 100 * 1024 * 1024
 )
 
 // StdLogger is used to log error messages.
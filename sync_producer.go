@@ -17,6 +17,10 @@ type SyncProducer interface {
 	// SendMessage produces a given message, and returns only when it either has
 	// succeeded or failed to produce. It will return the partition and the offset
 	// of the produced message, or an error if the message failed to produce.
+	// The retries of the message keep its partition, unless it disappeared from
+	// the metadata of its topic. When the message failed after being retried,
+	// the error is the *ProducerError holding the failed attempts, such as
+	// those sent to a former leader of the partition.
 	SendMessage(msg *ProducerMessage) (partition int32, offset int64, err error)
 
 	// SendMessages produces a given set of messages, and returns only when all
@@ -115,6 +119,9 @@ func (sp *syncProducer) SendMessage(msg *ProducerMessage) (partition int32, offs
 	sp.producer.Input() <- msg
 
 	if pErr := <-expectation; pErr != nil {
+		if len(pErr.Attempts) > 0 {
+			return -1, -1, pErr
+		}
 		return -1, -1, pErr.Err
 	}
 
@@ -195,3 +202,19 @@ func (sp *syncProducer) AddMessageToTxn(msg *ConsumerMessage, groupId string, me
 func (p *syncProducer) TxnStatus() ProducerTxnStatusFlag {
 	return p.producer.TxnStatus()
 }
+
+func (sp *syncProducer) PrepareTxn() (PreparedTxnState, error) {
+	return sp.producer.PrepareTxn()
+}
+
+func (sp *syncProducer) CompleteTxn(state PreparedTxnState) error {
+	return sp.producer.CompleteTxn(state)
+}
+
+func (p *syncProducer) TxnState() TxnState {
+	return p.producer.TxnState()
+}
+
+func (p *syncProducer) TxnEvents() <-chan *TxnEvent {
+	return p.producer.TxnEvents()
+}
@@ -3,10 +3,16 @@ package sarama
 import (
 	"errors"
 	"log"
+	"reflect"
 	"sync"
 	"testing"
 )
 
+var (
+	_ TxnStateReporter  = (*syncProducer)(nil)
+	_ TwoPhaseCommitter = (*syncProducer)(nil)
+)
+
 func TestSyncProducer(t *testing.T) {
 	seedBroker := NewMockBroker(t, 1)
 	leader := NewMockBroker(t, 2)
@@ -328,6 +334,115 @@ func TestSyncProducerRecoveryWithRetriesDisabled(t *testing.T) {
 	safeClose(t, producer)
 }
 
+func TestSyncProducerRetryKeepsPartition(t *testing.T) {
+	seedBroker := NewMockBroker(t, 1)
+	leader1 := NewMockBroker(t, 2)
+	defer leader1.Close()
+	leader2 := NewMockBroker(t, 3)
+	defer leader2.Close()
+
+	seedBroker.SetHandlerByMap(map[string]MockResponse{
+		"MetadataRequest": NewMockMetadataResponse(t).
+			SetBroker(leader1.Addr(), leader1.BrokerID()).
+			SetLeader("my_topic", 0, leader1.BrokerID()).
+			SetLeader("my_topic", 1, leader1.BrokerID()),
+	})
+	// the leadership of both partitions moves to leader2
+	leader1.SetHandlerByMap(map[string]MockResponse{
+		"ProduceRequest": NewMockProduceResponse(t).
+			SetError("my_topic", 0, ErrNotLeaderForPartition).
+			SetError("my_topic", 1, ErrNotLeaderForPartition),
+		"MetadataRequest": NewMockMetadataResponse(t).
+			SetBroker(leader2.Addr(), leader2.BrokerID()).
+			SetLeader("my_topic", 0, leader2.BrokerID()).
+			SetLeader("my_topic", 1, leader2.BrokerID()),
+	})
+	leader2.SetHandlerByMap(map[string]MockResponse{
+		"ProduceRequest": NewMockProduceResponse(t).
+			SetError("my_topic", 0, ErrNotLeaderForPartition),
+		"MetadataRequest": NewMockMetadataResponse(t).
+			SetBroker(leader2.Addr(), leader2.BrokerID()).
+			SetLeader("my_topic", 0, leader2.BrokerID()).
+			SetLeader("my_topic", 1, leader2.BrokerID()),
+	})
+
+	config := NewTestConfig()
+	config.Producer.Partitioner = NewRoundRobinPartitioner
+	config.Producer.Retry.Max = 1
+	config.Producer.Retry.Backoff = 0
+	config.Producer.Return.Successes = true
+	producer, err := NewSyncProducer([]string{seedBroker.Addr()}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer safeClose(t, producer)
+	seedBroker.Close()
+
+	// the round robin partitioner would choose partition 1 if run again
+	partition, _, err := producer.SendMessage(&ProducerMessage{Topic: "my_topic", Value: StringEncoder(TestMessage)})
+	var pErr *ProducerError
+	if !errors.As(err, &pErr) || !errors.Is(err, ErrNotLeaderForPartition) {
+		t.Fatalf("expected a ProducerError for %v, got %v", ErrNotLeaderForPartition, err)
+	}
+	if partition != -1 || pErr.Msg.Partition != 0 {
+		t.Errorf("expected the message to stay on partition 0, got %d", pErr.Msg.Partition)
+	}
+	expected := []ProduceAttempt{{Broker: leader1.BrokerID(), Partition: 0, Err: ErrNotLeaderForPartition}}
+	if !reflect.DeepEqual(pErr.Attempts, expected) {
+		t.Errorf("expected the attempts %+v, got %+v", expected, pErr.Attempts)
+	}
+
+	// the next message goes to partition 1, now led by leader2
+	partition, _, err = producer.SendMessage(&ProducerMessage{Topic: "my_topic", Value: StringEncoder(TestMessage)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if partition != 1 {
+		t.Errorf("expected partition 1, got %d", partition)
+	}
+}
+
+func TestSyncProducerRetryDisappearedPartition(t *testing.T) {
+	broker := NewMockBroker(t, 1)
+	defer broker.Close()
+
+	// the topic is created again with a single partition
+	broker.SetHandlerByMap(map[string]MockResponse{
+		"MetadataRequest": NewMockSequence(
+			NewMockMetadataResponse(t).
+				SetBroker(broker.Addr(), broker.BrokerID()).
+				SetLeader("my_topic", 0, broker.BrokerID()).
+				SetLeader("my_topic", 1, broker.BrokerID()),
+			NewMockMetadataResponse(t).
+				SetBroker(broker.Addr(), broker.BrokerID()).
+				SetLeader("my_topic", 0, broker.BrokerID()),
+		),
+		"ProduceRequest": NewMockProduceResponse(t).
+			SetError("my_topic", 1, ErrUnknownTopicOrPartition),
+	})
+
+	config := NewTestConfig()
+	config.Producer.Partitioner = NewRoundRobinPartitioner
+	config.Producer.Retry.Backoff = 0
+	config.Producer.Return.Successes = true
+	producer, err := NewSyncProducer([]string{broker.Addr()}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer safeClose(t, producer)
+
+	// the second message is retried on partition 0 rather than failing
+	for i, expected := range []int32{0, 0} {
+		partition, _, err := producer.SendMessage(&ProducerMessage{Topic: "my_topic", Value: StringEncoder(TestMessage)})
+		if err != nil {
+			t.Fatal(i, err)
+		}
+		if partition != expected {
+			t.Errorf("expected message %d on partition %d, got %d", i, expected, partition)
+		}
+	}
+}
+
 // This example shows the basic usage pattern of the SyncProducer.
 func ExampleSyncProducer() {
 	producer, err := NewSyncProducer([]string{"localhost:9092"}, nil)
@@ -0,0 +1,115 @@
+package sarama
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// handshakeTLS performs the TLS handshake of conn with the broker, for
+// Net.TLS.Debug, logging the negotiated parameters to DebugLogger or the
+// diagnostics of the failure to Logger.
+func (b *Broker) handshakeTLS(conn *tls.Conn, cfg *tls.Config, timeout time.Duration) error {
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	err := conn.HandshakeContext(ctx)
+	state := conn.ConnectionState()
+	if err != nil {
+		Logger.Printf("broker/%d TLS handshake with %s failed: err=%q server_name=%q offered_versions=%s alpn=%q client_certificate=%t peer_chain=%s hint=%q\n",
+			b.id, b.addr, err, cfg.ServerName, tlsVersionRange(cfg), cfg.NextProtos,
+			len(cfg.Certificates) > 0 || cfg.GetClientCertificate != nil,
+			tlsChainSummary(tlsFailedChain(err, state)), tlsHandshakeHint(err))
+		return err
+	}
+	DebugLogger.Printf("broker/%d TLS handshake with %s: version=%s cipher=%s alpn=%q server_name=%q resumed=%t peer_chain=%s\n",
+		b.id, b.addr, tlsVersionName(state.Version), tls.CipherSuiteName(state.CipherSuite),
+		state.NegotiatedProtocol, state.ServerName, state.DidResume, tlsChainSummary(state.PeerCertificates))
+	return nil
+}
+
+func tlsVersionName(version uint16) string {
+	switch version {
+	case tls.VersionTLS10:
+		return "TLS1.0"
+	case tls.VersionTLS11:
+		return "TLS1.1"
+	case tls.VersionTLS12:
+		return "TLS1.2"
+	case tls.VersionTLS13:
+		return "TLS1.3"
+	case 0:
+		return "default"
+	}
+	return fmt.Sprintf("0x%04x", version)
+}
+
+// tlsVersionRange returns the TLS versions offered by the client with cfg.
+func tlsVersionRange(cfg *tls.Config) string {
+	return tlsVersionName(cfg.MinVersion) + "-" + tlsVersionName(cfg.MaxVersion)
+}
+
+// tlsFailedChain returns the certificates presented by the broker during a
+// failed handshake, from the verification error if it has them.
+func tlsFailedChain(err error, state tls.ConnectionState) []*x509.Certificate {
+	if len(state.PeerCertificates) > 0 {
+		return state.PeerCertificates
+	}
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostname x509.HostnameError
+	var invalid x509.CertificateInvalidError
+	switch {
+	case errors.As(err, &unknownAuthority) && unknownAuthority.Cert != nil:
+		return []*x509.Certificate{unknownAuthority.Cert}
+	case errors.As(err, &hostname) && hostname.Certificate != nil:
+		return []*x509.Certificate{hostname.Certificate}
+	case errors.As(err, &invalid) && invalid.Cert != nil:
+		return []*x509.Certificate{invalid.Cert}
+	}
+	return nil
+}
+
+// tlsChainSummary summarizes the subject, issuer, validity and names of each
+// certificate of chain.
+func tlsChainSummary(chain []*x509.Certificate) string {
+	summaries := make([]string, len(chain))
+	for i, cert := range chain {
+		summaries[i] = fmt.Sprintf("{subject=%q issuer=%q not_before=%s not_after=%s dns=%q}",
+			cert.Subject.String(), cert.Issuer.String(),
+			cert.NotBefore.UTC().Format(time.RFC3339), cert.NotAfter.UTC().Format(time.RFC3339), cert.DNSNames)
+	}
+	return "[" + strings.Join(summaries, " ") + "]"
+}
+
+// tlsHandshakeHint returns the likely misconfiguration behind a failed
+// handshake, if it is a common one.
+func tlsHandshakeHint(err error) string {
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostname x509.HostnameError
+	var invalid x509.CertificateInvalidError
+	var recordHeader tls.RecordHeaderError
+	switch {
+	case errors.As(err, &recordHeader):
+		return "the listener of the broker does not use TLS"
+	case errors.As(err, &unknownAuthority):
+		return "the CA of the broker is not in Net.TLS.Config.RootCAs"
+	case errors.As(err, &hostname):
+		return "the certificate of the broker does not match its address, see Net.TLS.ServerName"
+	case errors.As(err, &invalid) && invalid.Reason == x509.Expired:
+		return "the certificate of the broker is expired or not valid yet"
+	case strings.Contains(err.Error(), "handshake failure"):
+		return "the broker rejected the offered versions, cipher suites or client certificate"
+	case strings.Contains(err.Error(), "protocol version"):
+		return "the broker does not support the offered TLS versions"
+	case strings.Contains(err.Error(), "certificate required"), strings.Contains(err.Error(), "bad certificate"):
+		return "the broker requires a client certificate it trusts"
+	}
+	return ""
+}
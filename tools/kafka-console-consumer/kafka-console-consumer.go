@@ -1,6 +1,9 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
@@ -10,21 +13,31 @@ import (
 	"strings"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/IBM/sarama"
 	"github.com/IBM/sarama/tools/tls"
 )
 
 var (
-	brokerList    = flag.String("brokers", os.Getenv("KAFKA_PEERS"), "The comma separated list of brokers in the Kafka cluster")
-	topic         = flag.String("topic", "", "REQUIRED: the topic to consume")
-	partitions    = flag.String("partitions", "all", "The partitions to consume, can be 'all' or comma-separated numbers")
-	offset        = flag.String("offset", "newest", "The offset to start with. Can be `oldest`, `newest`")
-	verbose       = flag.Bool("verbose", false, "Whether to turn on sarama logging")
-	tlsEnabled    = flag.Bool("tls-enabled", false, "Whether to enable TLS")
-	tlsSkipVerify = flag.Bool("tls-skip-verify", false, "Whether skip TLS server cert verification")
-	tlsClientCert = flag.String("tls-client-cert", "", "Client cert for client authentication (use with -tls-enabled and -tls-client-key)")
-	tlsClientKey  = flag.String("tls-client-key", "", "Client key for client authentication (use with tls-enabled and -tls-client-cert)")
+	brokerList     = flag.String("brokers", os.Getenv("KAFKA_PEERS"), "The comma separated list of brokers in the Kafka cluster")
+	topic          = flag.String("topic", "", "REQUIRED: the topic to consume")
+	partitions     = flag.String("partitions", "all", "The partitions to consume, can be 'all' or comma-separated numbers")
+	offset         = flag.String("offset", "newest", "The offset to start with. Can be `oldest`, `newest`, or an actual offset")
+	fromTimestamp  = flag.String("from-timestamp", "", "Start with the first message at or after this time, as RFC 3339 or milliseconds since the epoch (instead of -offset)")
+	endOffset      = flag.Int64("end-offset", -1, "Stop consuming each partition before this offset, if set")
+	exitCaughtUp   = flag.Bool("exit-when-caught-up", false, "Exit once every partition has been consumed up to its high watermark at startup")
+	group          = flag.String("group", "", "Consume as a member of this consumer group, committing the offsets, instead of consuming -partitions")
+	format         = flag.String("format", "text", "The output format of the messages: `text` or `json` (one object per line)")
+	printKey       = flag.Bool("print-key", true, "Whether to print the key of the messages (text format)")
+	printHeaders   = flag.Bool("print-headers", false, "Whether to print the headers of the messages (text format)")
+	printTimestamp = flag.Bool("print-timestamp", false, "Whether to print the timestamp of the messages (text format)")
+	version        = flag.String("version", "", "The Kafka version of the cluster (defaults to the sarama default version)")
+	verbose        = flag.Bool("verbose", false, "Whether to turn on sarama logging")
+	tlsEnabled     = flag.Bool("tls-enabled", false, "Whether to enable TLS")
+	tlsSkipVerify  = flag.Bool("tls-skip-verify", false, "Whether skip TLS server cert verification")
+	tlsClientCert  = flag.String("tls-client-cert", "", "Client cert for client authentication (use with -tls-enabled and -tls-client-key)")
+	tlsClientKey   = flag.String("tls-client-key", "", "Client key for client authentication (use with tls-enabled and -tls-client-cert)")
 
 	bufferSize = flag.Int("buffer-size", 256, "The buffer size of the message channel.")
 
@@ -42,21 +55,26 @@ func main() {
 		printUsageErrorAndExit("-topic is required")
 	}
 
-	if *verbose {
-		sarama.Logger = logger
+	if *format != "text" && *format != "json" {
+		printUsageErrorAndExit("-format should be `text` or `json`")
 	}
 
-	var initialOffset int64
-	switch *offset {
-	case "oldest":
-		initialOffset = sarama.OffsetOldest
-	case "newest":
-		initialOffset = sarama.OffsetNewest
-	default:
-		printUsageErrorAndExit("-offset should be `oldest` or `newest`")
+	if *group != "" && (*exitCaughtUp || *fromTimestamp != "" || *endOffset >= 0 || *partitions != "all") {
+		printUsageErrorAndExit("-group can't be combined with -partitions, -from-timestamp, -end-offset or -exit-when-caught-up")
+	}
+
+	if *verbose {
+		sarama.Logger = logger
 	}
 
 	config := sarama.NewConfig()
+	if *version != "" {
+		kafkaVersion, err := sarama.ParseKafkaVersion(*version)
+		if err != nil {
+			printUsageErrorAndExit("Unknown -version: %s", *version)
+		}
+		config.Version = kafkaVersion
+	}
 	if *tlsEnabled {
 		tlsConfig, err := tls.NewConfig(*tlsClientCert, *tlsClientKey)
 		if err != nil {
@@ -68,20 +86,10 @@ func main() {
 		config.Net.TLS.Config.InsecureSkipVerify = *tlsSkipVerify
 	}
 
-	c, err := sarama.NewConsumer(strings.Split(*brokerList, ","), config)
-	if err != nil {
-		printErrorAndExit(69, "Failed to start consumer: %s", err)
-	}
-
-	partitionList, err := getPartitions(c)
-	if err != nil {
-		printErrorAndExit(69, "Failed to get the list of partitions: %s", err)
-	}
-
 	var (
 		messages = make(chan *sarama.ConsumerMessage, *bufferSize)
 		closing  = make(chan struct{})
-		wg       sync.WaitGroup
+		printed  = make(chan struct{})
 	)
 
 	go func() {
@@ -92,43 +100,267 @@ func main() {
 		close(closing)
 	}()
 
+	go func() {
+		defer close(printed)
+		for msg := range messages {
+			printMessage(msg)
+		}
+	}()
+
+	if *group != "" {
+		consumeGroup(config, messages, closing)
+	} else {
+		consumePartitions(config, messages, closing)
+	}
+	close(messages)
+	<-printed
+}
+
+// consumePartitions consumes -partitions of -topic, sending their messages
+// to messages until closing is closed or, with -end-offset or
+// -exit-when-caught-up, every partition is done.
+func consumePartitions(config *sarama.Config, messages chan<- *sarama.ConsumerMessage, closing <-chan struct{}) {
+	client, err := sarama.NewClient(strings.Split(*brokerList, ","), config)
+	if err != nil {
+		printErrorAndExit(69, "Failed to create client: %s", err)
+	}
+	c, err := sarama.NewConsumerFromClient(client)
+	if err != nil {
+		printErrorAndExit(69, "Failed to start consumer: %s", err)
+	}
+
+	partitionList, err := getPartitions(c)
+	if err != nil {
+		printErrorAndExit(69, "Failed to get the list of partitions: %s", err)
+	}
+
+	var wg sync.WaitGroup
 	for _, partition := range partitionList {
+		initialOffset := getInitialOffset(client, partition)
+		stopOffset := *endOffset
+		if *exitCaughtUp {
+			highWaterMark, err := client.GetOffset(*topic, partition, sarama.OffsetNewest)
+			if err != nil {
+				printErrorAndExit(69, "Failed to get the high watermark of partition %d: %s", partition, err)
+			}
+			if stopOffset < 0 || highWaterMark < stopOffset {
+				stopOffset = highWaterMark
+			}
+		}
+		if stopOffset >= 0 && !before(client, partition, initialOffset, stopOffset) {
+			continue
+		}
+
 		pc, err := c.ConsumePartition(*topic, partition, initialOffset)
 		if err != nil {
 			printErrorAndExit(69, "Failed to start consumer for partition %d: %s", partition, err)
 		}
 
+		done := make(chan struct{})
 		go func(pc sarama.PartitionConsumer) {
-			<-closing
+			select {
+			case <-closing:
+			case <-done:
+			}
 			pc.AsyncClose()
 		}(pc)
 
 		wg.Add(1)
 		go func(pc sarama.PartitionConsumer) {
 			defer wg.Done()
+			stopped := false
 			for message := range pc.Messages() {
+				if stopped {
+					continue
+				}
 				messages <- message
+				if stopOffset >= 0 && message.Offset+1 >= stopOffset {
+					stopped = true
+					close(done)
+				}
 			}
 		}(pc)
 	}
 
+	wg.Wait()
+	logger.Println("Done consuming topic", *topic)
+
+	if err := c.Close(); err != nil {
+		logger.Println("Failed to close consumer: ", err)
+	}
+	if err := client.Close(); err != nil {
+		logger.Println("Failed to close client: ", err)
+	}
+}
+
+// before returns whether the initial offset of partition, possibly
+// OffsetOldest or OffsetNewest, is before stopOffset.
+func before(client sarama.Client, partition int32, initialOffset, stopOffset int64) bool {
+	if initialOffset < 0 {
+		var err error
+		if initialOffset, err = client.GetOffset(*topic, partition, initialOffset); err != nil {
+			printErrorAndExit(69, "Failed to get the offsets of partition %d: %s", partition, err)
+		}
+	}
+	return initialOffset < stopOffset
+}
+
+func getInitialOffset(client sarama.Client, partition int32) int64 {
+	if *fromTimestamp != "" {
+		ts, err := parseTimestamp(*fromTimestamp)
+		if err != nil {
+			printUsageErrorAndExit("-from-timestamp should be RFC 3339 or milliseconds since the epoch: %s", err)
+		}
+		offset, err := client.GetOffset(*topic, partition, ts.UnixNano()/int64(time.Millisecond))
+		if err != nil {
+			printErrorAndExit(69, "Failed to get the offset of partition %d at %s: %s", partition, ts, err)
+		}
+		if offset < 0 {
+			// No message at or after the timestamp, wait for the next one.
+			return sarama.OffsetNewest
+		}
+		return offset
+	}
+
+	switch *offset {
+	case "oldest":
+		return sarama.OffsetOldest
+	case "newest":
+		return sarama.OffsetNewest
+	default:
+		o, err := strconv.ParseInt(*offset, 10, 64)
+		if err != nil || o < 0 {
+			printUsageErrorAndExit("-offset should be `oldest`, `newest`, or an actual offset")
+		}
+		return o
+	}
+}
+
+func parseTimestamp(s string) (time.Time, error) {
+	if ms, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(0, ms*int64(time.Millisecond)), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// consumeGroup consumes -topic as a member of -group, sending the messages
+// to messages and marking them as consumed, until closing is closed.
+func consumeGroup(config *sarama.Config, messages chan<- *sarama.ConsumerMessage, closing <-chan struct{}) {
+	switch *offset {
+	case "oldest":
+		config.Consumer.Offsets.Initial = sarama.OffsetOldest
+	case "newest":
+		config.Consumer.Offsets.Initial = sarama.OffsetNewest
+	default:
+		printUsageErrorAndExit("-offset should be `oldest` or `newest` with -group")
+	}
+	config.Consumer.Return.Errors = true
+
+	cg, err := sarama.NewConsumerGroup(strings.Split(*brokerList, ","), *group, config)
+	if err != nil {
+		printErrorAndExit(69, "Failed to start consumer group: %s", err)
+	}
 	go func() {
-		for msg := range messages {
-			fmt.Printf("Partition:\t%d\n", msg.Partition)
-			fmt.Printf("Offset:\t%d\n", msg.Offset)
-			fmt.Printf("Key:\t%s\n", string(msg.Key))
-			fmt.Printf("Value:\t%s\n", string(msg.Value))
-			fmt.Println()
+		for err := range cg.Errors() {
+			logger.Println("Consumer group error:", err)
 		}
 	}()
 
-	wg.Wait()
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-closing
+		cancel()
+	}()
+
+	handler := groupHandler{messages}
+	for ctx.Err() == nil {
+		if err := cg.Consume(ctx, []string{*topic}, handler); err != nil {
+			if errors.Is(err, sarama.ErrClosedConsumerGroup) {
+				break
+			}
+			logger.Println("Failed to consume:", err)
+			time.Sleep(time.Second)
+		}
+	}
 	logger.Println("Done consuming topic", *topic)
-	close(messages)
 
-	if err := c.Close(); err != nil {
-		logger.Println("Failed to close consumer: ", err)
+	if err := cg.Close(); err != nil {
+		logger.Println("Failed to close consumer group: ", err)
+	}
+}
+
+type groupHandler struct {
+	messages chan<- *sarama.ConsumerMessage
+}
+
+func (h groupHandler) Setup(sarama.ConsumerGroupSession) error   { return nil }
+func (h groupHandler) Cleanup(sarama.ConsumerGroupSession) error { return nil }
+
+func (h groupHandler) ConsumeClaim(sess sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	for msg := range claim.Messages() {
+		h.messages <- msg
+		sess.MarkMessage(msg, "")
+	}
+	return nil
+}
+
+type jsonMessage struct {
+	Topic     string            `json:"topic"`
+	Partition int32             `json:"partition"`
+	Offset    int64             `json:"offset"`
+	Timestamp time.Time         `json:"timestamp"`
+	Key       *string           `json:"key"`
+	Value     *string           `json:"value"`
+	Headers   map[string]string `json:"headers,omitempty"`
+}
+
+func printMessage(msg *sarama.ConsumerMessage) {
+	if *format == "json" {
+		m := jsonMessage{
+			Topic:     msg.Topic,
+			Partition: msg.Partition,
+			Offset:    msg.Offset,
+			Timestamp: msg.Timestamp,
+			Key:       nullableString(msg.Key),
+			Value:     nullableString(msg.Value),
+		}
+		for _, h := range msg.Headers {
+			if m.Headers == nil {
+				m.Headers = make(map[string]string)
+			}
+			m.Headers[string(h.Key)] = string(h.Value)
+		}
+		b, err := json.Marshal(m)
+		if err != nil {
+			printErrorAndExit(69, "Failed to format message: %s", err)
+		}
+		fmt.Println(string(b))
+		return
+	}
+
+	fmt.Printf("Partition:\t%d\n", msg.Partition)
+	fmt.Printf("Offset:\t%d\n", msg.Offset)
+	if *printTimestamp {
+		fmt.Printf("Timestamp:\t%s\n", msg.Timestamp.Format(time.RFC3339Nano))
+	}
+	if *printKey {
+		fmt.Printf("Key:\t%s\n", string(msg.Key))
+	}
+	if *printHeaders {
+		for _, h := range msg.Headers {
+			fmt.Printf("Header:\t%s=%s\n", string(h.Key), string(h.Value))
+		}
+	}
+	fmt.Printf("Value:\t%s\n", string(msg.Value))
+	fmt.Println()
+}
+
+func nullableString(b []byte) *string {
+	if b == nil {
+		return nil
 	}
+	s := string(b)
+	return &s
 }
 
 func getPartitions(c sarama.Consumer) ([]int32, error) {
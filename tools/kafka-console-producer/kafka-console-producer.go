@@ -1,12 +1,15 @@
 package main
 
 import (
+	"bufio"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/rcrowley/go-metrics"
 
@@ -29,6 +32,15 @@ var (
 	tlsSkipVerify = flag.Bool("tls-skip-verify", false, "Whether skip TLS server cert verification")
 	tlsClientCert = flag.String("tls-client-cert", "", "Client cert for client authentication (use with -tls-enabled and -tls-client-key)")
 	tlsClientKey  = flag.String("tls-client-key", "", "Client key for client authentication (use with tls-enabled and -tls-client-cert)")
+	timestamp     = flag.String("timestamp", "", "The timestamp of the messages, as RFC 3339 or milliseconds since the epoch. Defaults to the current time")
+
+	lines               = flag.Bool("lines", false, "Produce each line of stdin as a separate message, rather than the whole of stdin as a single one")
+	parseKey            = flag.Bool("parse-key", false, "Parse the key of each line, separated from the value by -key-separator (use with -lines)")
+	keySeparator        = flag.String("key-separator", "\\t", "The separator between the key and the value of a line")
+	parseHeaders        = flag.Bool("parse-headers", false, "Parse the headers at the start of each line, separated from the key or value by -headers-delimiter (use with -lines)")
+	headersDelimiter    = flag.String("headers-delimiter", "\\t", "The separator between the headers and the rest of a line")
+	headersSeparator    = flag.String("headers-separator", ",", "The separator between two headers of a line")
+	headersKeySeparator = flag.String("headers-key-separator", ":", "The separator between the key and the value of a header of a line")
 
 	logger = log.New(os.Stderr, "", log.LstdFlags)
 )
@@ -83,22 +95,25 @@ func main() {
 		printUsageErrorAndExit(fmt.Sprintf("Partitioner %s not supported.", *partitioner))
 	}
 
-	message := &sarama.ProducerMessage{Topic: *topic, Partition: int32(*partition)}
+	if (*parseKey || *parseHeaders) && !*lines {
+		printUsageErrorAndExit("-parse-key and -parse-headers require -lines")
+	}
+	if *lines && (*value != "" || !stdinAvailable()) {
+		printUsageErrorAndExit("-lines requires the messages on stdin, and no -value")
+	}
+
+	template := &sarama.ProducerMessage{Topic: *topic, Partition: int32(*partition)}
 
 	if *key != "" {
-		message.Key = sarama.StringEncoder(*key)
+		template.Key = sarama.StringEncoder(*key)
 	}
 
-	if *value != "" {
-		message.Value = sarama.StringEncoder(*value)
-	} else if stdinAvailable() {
-		bytes, err := io.ReadAll(os.Stdin)
+	if *timestamp != "" {
+		ts, err := parseTimestamp(*timestamp)
 		if err != nil {
-			printErrorAndExit(66, "Failed to read data from the standard input: %s", err)
+			printUsageErrorAndExit("-timestamp should be RFC 3339 or milliseconds since the epoch")
 		}
-		message.Value = sarama.ByteEncoder(bytes)
-	} else {
-		printUsageErrorAndExit("-value is required, or you have to provide the value on stdin")
+		template.Timestamp = ts
 	}
 
 	if *headers != "" {
@@ -116,10 +131,27 @@ func main() {
 		}
 
 		if len(hdrs) != 0 {
-			message.Headers = hdrs
+			template.Headers = hdrs
 		}
 	}
 
+	var message *sarama.ProducerMessage
+	if *value != "" {
+		message = newMessage(template)
+		message.Value = sarama.StringEncoder(*value)
+	} else if *lines {
+		// Each line is produced below.
+	} else if stdinAvailable() {
+		bytes, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			printErrorAndExit(66, "Failed to read data from the standard input: %s", err)
+		}
+		message = newMessage(template)
+		message.Value = sarama.ByteEncoder(bytes)
+	} else {
+		printUsageErrorAndExit("-value is required, or you have to provide the value on stdin")
+	}
+
 	producer, err := sarama.NewSyncProducer(strings.Split(*brokerList, ","), config)
 	if err != nil {
 		printErrorAndExit(69, "Failed to open Kafka producer: %s", err)
@@ -130,17 +162,105 @@ func main() {
 		}
 	}()
 
-	partition, offset, err := producer.SendMessage(message)
-	if err != nil {
-		printErrorAndExit(69, "Failed to produce message: %s", err)
-	} else if !*silent {
-		fmt.Printf("topic=%s\tpartition=%d\toffset=%d\n", *topic, partition, offset)
+	if *lines {
+		produceLines(producer, template)
+	} else {
+		partition, offset, err := producer.SendMessage(message)
+		if err != nil {
+			printErrorAndExit(69, "Failed to produce message: %s", err)
+		} else if !*silent {
+			fmt.Printf("topic=%s\tpartition=%d\toffset=%d\n", *topic, partition, offset)
+		}
 	}
 	if *showMetrics {
 		metrics.WriteOnce(config.MetricRegistry, os.Stderr)
 	}
 }
 
+// produceLines produces each line of stdin, reporting the partition and
+// offset, or the error, of each of them. It exits with an error if any line
+// could not be produced.
+func produceLines(producer sarama.SyncProducer, template *sarama.ProducerMessage) {
+	keySep := unescapeSeparator("key-separator", *keySeparator)
+	headersDelim := unescapeSeparator("headers-delimiter", *headersDelimiter)
+	headersSep := unescapeSeparator("headers-separator", *headersSeparator)
+	headersKeySep := unescapeSeparator("headers-key-separator", *headersKeySeparator)
+
+	failed := 0
+	scanner := bufio.NewScanner(os.Stdin)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64<<20)
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		message := newMessage(template)
+
+		if *parseHeaders {
+			parts := strings.SplitN(line, headersDelim, 2)
+			if len(parts) != 2 {
+				printErrorAndExit(65, "Line %d has no headers delimiter", n)
+			}
+			for _, h := range strings.Split(parts[0], headersSep) {
+				if h == "" {
+					continue
+				}
+				header := strings.SplitN(h, headersKeySep, 2)
+				if len(header) != 2 {
+					printErrorAndExit(65, "Line %d has a header without key separator: %q", n, h)
+				}
+				message.Headers = append(message.Headers, sarama.RecordHeader{
+					Key:   []byte(header[0]),
+					Value: []byte(header[1]),
+				})
+			}
+			line = parts[1]
+		}
+		if *parseKey {
+			parts := strings.SplitN(line, keySep, 2)
+			if len(parts) != 2 {
+				printErrorAndExit(65, "Line %d has no key separator", n)
+			}
+			message.Key = sarama.StringEncoder(parts[0])
+			line = parts[1]
+		}
+		message.Value = sarama.StringEncoder(line)
+
+		partition, offset, err := producer.SendMessage(message)
+		if err != nil {
+			failed++
+			logger.Printf("Failed to produce line %d: %s\n", n, err)
+		} else if !*silent {
+			fmt.Printf("line=%d\ttopic=%s\tpartition=%d\toffset=%d\n", n, *topic, partition, offset)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		printErrorAndExit(66, "Failed to read data from the standard input: %s", err)
+	}
+	if failed > 0 {
+		printErrorAndExit(69, "Failed to produce %d lines", failed)
+	}
+}
+
+// newMessage returns a copy of template, with its own headers.
+func newMessage(template *sarama.ProducerMessage) *sarama.ProducerMessage {
+	message := *template
+	message.Headers = append([]sarama.RecordHeader(nil), template.Headers...)
+	return &message
+}
+
+func unescapeSeparator(name, s string) string {
+	unescaped, err := strconv.Unquote(`"` + s + `"`)
+	if err != nil || unescaped == "" {
+		printUsageErrorAndExit(fmt.Sprintf("Invalid -%s: %q", name, s))
+	}
+	return unescaped
+}
+
+func parseTimestamp(s string) (time.Time, error) {
+	if ms, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(0, ms*int64(time.Millisecond)), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
 func printErrorAndExit(code int, format string, values ...interface{}) {
 	fmt.Fprintf(os.Stderr, "ERROR: %s\n", fmt.Sprintf(format, values...))
 	fmt.Fprintln(os.Stderr)
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/tools/tls"
+)
+
+var (
+	brokerList         = flag.String("brokers", os.Getenv("KAFKA_PEERS"), "The comma separated list of brokers to produce the probes to. You can also set the KAFKA_PEERS environment variable")
+	consumerBrokerList = flag.String("consumer-brokers", "", "The comma separated list of brokers to consume the probes from, if not -brokers (e.g. the target cluster of a mirror)")
+	topic              = flag.String("topic", "", "REQUIRED: the topic to produce the probes to")
+	consumerTopic      = flag.String("consumer-topic", "", "The topic to consume the probes from, if not -topic")
+	partitions         = flag.String("partitions", "all", "The partitions to probe, can be 'all' or comma-separated numbers")
+	interval           = flag.Duration("interval", time.Second, "The interval between two probes of each partition")
+	printInterval      = flag.Duration("print-interval", 10*time.Second, "The interval between two reports")
+	timeout            = flag.Duration("timeout", 30*time.Second, "The time after which a probe that was not consumed back is counted as lost")
+	requiredAcks       = flag.String("required-acks", "all", "The acks required for the probes (all, none, leader)")
+	version            = flag.String("version", "1.0.0", "The Kafka version of the clusters")
+	verbose            = flag.Bool("verbose", false, "Whether to turn on sarama logging")
+	tlsEnabled         = flag.Bool("tls-enabled", false, "Whether to enable TLS")
+	tlsSkipVerify      = flag.Bool("tls-skip-verify", false, "Whether skip TLS server cert verification")
+	tlsClientCert      = flag.String("tls-client-cert", "", "Client cert for client authentication (use with -tls-enabled and -tls-client-key)")
+	tlsClientKey       = flag.String("tls-client-key", "", "Client key for client authentication (use with tls-enabled and -tls-client-cert)")
+
+	logger = log.New(os.Stderr, "", log.LstdFlags)
+)
+
+// probeSize is the size of a probe: the run ID, so that several instances can
+// share a topic, the sequence number and the send time.
+const probeSize = 24
+
+func main() {
+	flag.Parse()
+
+	if *brokerList == "" {
+		printUsageErrorAndExit("You have to provide -brokers as a comma-separated list, or set the KAFKA_PEERS environment variable.")
+	}
+	if *topic == "" {
+		printUsageErrorAndExit("-topic is required")
+	}
+	if *consumerBrokerList == "" {
+		*consumerBrokerList = *brokerList
+	}
+	if *consumerTopic == "" {
+		*consumerTopic = *topic
+	}
+	if *interval <= 0 || *printInterval <= 0 || *timeout <= 0 {
+		printUsageErrorAndExit("-interval, -print-interval and -timeout must be greater than 0")
+	}
+
+	if *verbose {
+		sarama.Logger = logger
+	}
+
+	kafkaVersion, err := sarama.ParseKafkaVersion(*version)
+	if err != nil {
+		printUsageErrorAndExit("Unknown -version: %s", *version)
+	}
+
+	config := sarama.NewConfig()
+	config.Version = kafkaVersion
+	config.ClientID = "kafka-e2e-latency"
+	config.Producer.Partitioner = sarama.NewManualPartitioner
+	config.Producer.Return.Successes = true
+	switch *requiredAcks {
+	case "all":
+		config.Producer.RequiredAcks = sarama.WaitForAll
+	case "none":
+		config.Producer.RequiredAcks = sarama.NoResponse
+	case "leader":
+		config.Producer.RequiredAcks = sarama.WaitForLocal
+	default:
+		printUsageErrorAndExit("-required-acks should be `all`, `none` or `leader`")
+	}
+	if *tlsEnabled {
+		tlsConfig, err := tls.NewConfig(*tlsClientCert, *tlsClientKey)
+		if err != nil {
+			printErrorAndExit(69, "Failed to create TLS config: %s", err)
+		}
+
+		config.Net.TLS.Enable = true
+		config.Net.TLS.Config = tlsConfig
+		config.Net.TLS.Config.InsecureSkipVerify = *tlsSkipVerify
+	}
+
+	consumer, err := sarama.NewConsumer(strings.Split(*consumerBrokerList, ","), config)
+	if err != nil {
+		printErrorAndExit(69, "Failed to start consumer: %s", err)
+	}
+	producer, err := sarama.NewAsyncProducer(strings.Split(*brokerList, ","), config)
+	if err != nil {
+		printErrorAndExit(69, "Failed to start producer: %s", err)
+	}
+
+	partitionList, err := getPartitions(consumer)
+	if err != nil {
+		printErrorAndExit(69, "Failed to get the list of partitions: %s", err)
+	}
+
+	var runID [8]byte
+	if _, err := rand.Read(runID[:]); err != nil {
+		printErrorAndExit(69, "Failed to generate the run ID: %s", err)
+	}
+	t := newTracker(runID)
+
+	var (
+		partitionConsumers []sarama.PartitionConsumer
+		wg                 sync.WaitGroup
+	)
+	for _, partition := range partitionList {
+		// The probes are produced to the same partitions they are consumed
+		// from, which also holds for a mirror preserving partitioning.
+		pc, err := consumer.ConsumePartition(*consumerTopic, partition, sarama.OffsetNewest)
+		if err != nil {
+			printErrorAndExit(69, "Failed to start consumer for partition %d: %s", partition, err)
+		}
+		partitionConsumers = append(partitionConsumers, pc)
+
+		wg.Add(2)
+		go func(pc sarama.PartitionConsumer) {
+			defer wg.Done()
+			for msg := range pc.Messages() {
+				t.received(msg.Value, time.Now())
+			}
+		}(pc)
+		go func(pc sarama.PartitionConsumer) {
+			defer wg.Done()
+			for err := range pc.Errors() {
+				logger.Println("Failed to consume:", err)
+			}
+		}(pc)
+	}
+
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for range producer.Successes() {
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for err := range producer.Errors() {
+			t.failed(err.Msg)
+			logger.Println("Failed to produce probe:", err)
+		}
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+
+	probeTicker := time.NewTicker(*interval)
+	defer probeTicker.Stop()
+	printTicker := time.NewTicker(*printInterval)
+	defer printTicker.Stop()
+
+	var seq uint64
+	for {
+		select {
+		case now := <-probeTicker.C:
+			for _, partition := range partitionList {
+				producer.Input() <- t.probe(seq, partition, now)
+				seq++
+			}
+		case <-printTicker.C:
+			t.expire(time.Now().Add(-*timeout))
+			fmt.Println(t.report(false))
+		case <-signals:
+			logger.Println("Initiating shutdown...")
+			producer.AsyncClose()
+			for _, pc := range partitionConsumers {
+				pc.AsyncClose()
+			}
+			wg.Wait()
+			fmt.Println(t.report(true))
+			if err := consumer.Close(); err != nil {
+				logger.Println("Failed to close consumer:", err)
+			}
+			return
+		}
+	}
+}
+
+type probe struct {
+	partition int32
+	sentAt    time.Time
+}
+
+// tracker matches the probes consumed back with those produced.
+type tracker struct {
+	runID [8]byte
+
+	lock     sync.Mutex
+	pending  map[uint64]probe
+	interval metrics.Histogram
+	total    metrics.Histogram
+	// counts holds the counts of the current interval, then of the whole run.
+	counts [2]probeCounts
+}
+
+type probeCounts struct {
+	sent, received, lost, failed int64
+}
+
+func newTracker(runID [8]byte) *tracker {
+	return &tracker{
+		runID:    runID,
+		pending:  make(map[uint64]probe),
+		interval: metrics.NewHistogram(metrics.NewUniformSample(1 << 16)),
+		total:    metrics.NewHistogram(metrics.NewUniformSample(1 << 20)),
+	}
+}
+
+func (t *tracker) count(f func(c *probeCounts)) {
+	for i := range t.counts {
+		f(&t.counts[i])
+	}
+}
+
+// probe returns the probe seq of partition, sent at now.
+func (t *tracker) probe(seq uint64, partition int32, now time.Time) *sarama.ProducerMessage {
+	value := make([]byte, probeSize)
+	copy(value, t.runID[:])
+	binary.BigEndian.PutUint64(value[8:16], seq)
+	binary.BigEndian.PutUint64(value[16:24], uint64(now.UnixNano()))
+
+	t.lock.Lock()
+	t.pending[seq] = probe{partition, now}
+	t.count(func(c *probeCounts) { c.sent++ })
+	t.lock.Unlock()
+
+	return &sarama.ProducerMessage{
+		Topic:     *topic,
+		Partition: partition,
+		Value:     sarama.ByteEncoder(value),
+		Metadata:  seq,
+	}
+}
+
+// received records a probe read back at receivedAt, ignoring any other
+// message of the topic and the probes already counted as lost.
+func (t *tracker) received(value []byte, receivedAt time.Time) {
+	if len(value) != probeSize || string(value[:8]) != string(t.runID[:]) {
+		return
+	}
+	seq := binary.BigEndian.Uint64(value[8:16])
+	sentAt := time.Unix(0, int64(binary.BigEndian.Uint64(value[16:24])))
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if _, ok := t.pending[seq]; !ok {
+		return
+	}
+	delete(t.pending, seq)
+	latency := receivedAt.Sub(sentAt).Microseconds()
+	t.interval.Update(latency)
+	t.total.Update(latency)
+	t.count(func(c *probeCounts) { c.received++ })
+}
+
+// failed records a probe that could not be produced.
+func (t *tracker) failed(msg *sarama.ProducerMessage) {
+	seq, _ := msg.Metadata.(uint64)
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if _, ok := t.pending[seq]; ok {
+		delete(t.pending, seq)
+		t.count(func(c *probeCounts) { c.failed++ })
+	}
+}
+
+// expire counts the probes sent before deadline that were not read back as
+// lost.
+func (t *tracker) expire(deadline time.Time) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for seq, p := range t.pending {
+		if p.sentAt.Before(deadline) {
+			logger.Printf("Probe %d sent to partition %d at %s was lost\n", seq, p.partition, p.sentAt.Format(time.RFC3339))
+			delete(t.pending, seq)
+			t.count(func(c *probeCounts) { c.lost++ })
+		}
+	}
+}
+
+// report returns the stats of the current interval, and resets them, or of
+// the whole run if total is set.
+func (t *tracker) report(total bool) string {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	h, c, prefix := t.interval, &t.counts[0], ""
+	if total {
+		h, c, prefix = t.total, &t.counts[1], "total: "
+	}
+	s := h.Snapshot()
+	p := s.Percentiles([]float64{0.5, 0.95, 0.99, 0.999})
+	report := fmt.Sprintf("%s%d probes sent, %d received, %d lost, %d failed, %d pending, "+
+		"%.1f ms 50th, %.1f ms 95th, %.1f ms 99th, %.1f ms 99.9th, %.1f ms max",
+		prefix, c.sent, c.received, c.lost, c.failed, len(t.pending),
+		p[0]/1000, p[1]/1000, p[2]/1000, p[3]/1000, float64(s.Max())/1000)
+
+	if !total {
+		t.interval.Clear()
+		*c = probeCounts{}
+	}
+	return report
+}
+
+func getPartitions(c sarama.Consumer) ([]int32, error) {
+	if *partitions == "all" {
+		return c.Partitions(*consumerTopic)
+	}
+
+	tmp := strings.Split(*partitions, ",")
+	var pList []int32
+	for i := range tmp {
+		val, err := strconv.ParseInt(tmp[i], 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		pList = append(pList, int32(val))
+	}
+
+	return pList, nil
+}
+
+func printErrorAndExit(code int, format string, values ...interface{}) {
+	fmt.Fprintf(os.Stderr, "ERROR: %s\n", fmt.Sprintf(format, values...))
+	fmt.Fprintln(os.Stderr)
+	os.Exit(code)
+}
+
+func printUsageErrorAndExit(format string, values ...interface{}) {
+	fmt.Fprintf(os.Stderr, "ERROR: %s\n", fmt.Sprintf(format, values...))
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Available command line options:")
+	flag.PrintDefaults()
+	os.Exit(64)
+}
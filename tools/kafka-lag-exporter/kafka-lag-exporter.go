@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/tools/tls"
+)
+
+var (
+	brokerList    = flag.String("brokers", os.Getenv("KAFKA_PEERS"), "The comma separated list of brokers in the Kafka cluster. You can also set the KAFKA_PEERS environment variable")
+	listenAddr    = flag.String("listen", ":9308", "The address to serve the Prometheus metrics on, at /metrics")
+	interval      = flag.Duration("interval", 30*time.Second, "The interval between two computations of the lag")
+	groupFilter   = flag.String("group-filter", "", "A regular expression the consumer groups must match to be exported (all by default)")
+	version       = flag.String("version", "1.0.0", "The Kafka version of the cluster")
+	verbose       = flag.Bool("verbose", false, "Whether to turn on sarama logging")
+	tlsEnabled    = flag.Bool("tls-enabled", false, "Whether to enable TLS")
+	tlsSkipVerify = flag.Bool("tls-skip-verify", false, "Whether skip TLS server cert verification")
+	tlsClientCert = flag.String("tls-client-cert", "", "Client cert for client authentication (use with -tls-enabled and -tls-client-key)")
+	tlsClientKey  = flag.String("tls-client-key", "", "Client key for client authentication (use with tls-enabled and -tls-client-cert)")
+
+	logger = log.New(os.Stderr, "", log.LstdFlags)
+)
+
+func main() {
+	flag.Parse()
+
+	if *brokerList == "" {
+		printUsageErrorAndExit("You have to provide -brokers as a comma-separated list, or set the KAFKA_PEERS environment variable.")
+	}
+	if *interval <= 0 {
+		printUsageErrorAndExit("-interval must be greater than 0")
+	}
+	var filter *regexp.Regexp
+	if *groupFilter != "" {
+		var err error
+		if filter, err = regexp.Compile(*groupFilter); err != nil {
+			printUsageErrorAndExit("Invalid -group-filter: %s", err)
+		}
+	}
+
+	if *verbose {
+		sarama.Logger = logger
+	}
+
+	config := sarama.NewConfig()
+	config.ClientID = "kafka-lag-exporter"
+	kafkaVersion, err := sarama.ParseKafkaVersion(*version)
+	if err != nil {
+		printUsageErrorAndExit("Unknown -version: %s", *version)
+	}
+	config.Version = kafkaVersion
+	if *tlsEnabled {
+		tlsConfig, err := tls.NewConfig(*tlsClientCert, *tlsClientKey)
+		if err != nil {
+			printErrorAndExit(69, "Failed to create TLS config: %s", err)
+		}
+
+		config.Net.TLS.Enable = true
+		config.Net.TLS.Config = tlsConfig
+		config.Net.TLS.Config.InsecureSkipVerify = *tlsSkipVerify
+	}
+
+	client, err := sarama.NewClient(strings.Split(*brokerList, ","), config)
+	if err != nil {
+		printErrorAndExit(69, "Failed to create client: %s", err)
+	}
+	admin, err := sarama.NewClusterAdminFromClient(client)
+	if err != nil {
+		printErrorAndExit(69, "Failed to create cluster admin: %s", err)
+	}
+	defer admin.Close()
+
+	e := &exporter{client: client, admin: admin, filter: filter}
+	go func() {
+		for {
+			e.collect()
+			time.Sleep(*interval)
+		}
+	}()
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", e)
+	server := &http.Server{
+		Addr:              *listenAddr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	logger.Printf("Serving the consumer group lag on http://%s/metrics\n", *listenAddr)
+	if err := server.ListenAndServe(); err != nil {
+		printErrorAndExit(69, "Failed to serve metrics: %s", err)
+	}
+}
+
+type topicPartition struct {
+	topic     string
+	partition int32
+}
+
+// exporter periodically computes the lag of the consumer groups, and serves
+// the last results in the Prometheus text format.
+type exporter struct {
+	client sarama.Client
+	admin  sarama.ClusterAdmin
+	filter *regexp.Regexp
+
+	lock    sync.RWMutex
+	metrics []byte
+}
+
+// collect computes the lag of every consumer group from its committed
+// offsets and the high watermarks of the partitions it consumes.
+func (e *exporter) collect() {
+	start := time.Now()
+	var buf bytes.Buffer
+	failed := 0
+
+	committed, err := e.committedOffsets()
+	if err != nil {
+		logger.Println("Failed to fetch the committed offsets:", err)
+		failed = 1
+	}
+	partitions := make(map[topicPartition]bool)
+	for _, offsets := range committed {
+		for tp := range offsets {
+			partitions[tp] = true
+		}
+	}
+	highWatermarks, err := e.highWatermarks(partitions)
+	if err != nil {
+		logger.Println("Failed to fetch the high watermarks:", err)
+		failed = 1
+	}
+
+	writeHeader(&buf, "kafka_topic_partition_high_watermark", "The offset of the next message appended to the partition.")
+	for _, tp := range sortedPartitions(highWatermarks) {
+		fmt.Fprintf(&buf, "kafka_topic_partition_high_watermark{topic=%q,partition=\"%d\"} %d\n",
+			tp.topic, tp.partition, highWatermarks[tp])
+	}
+
+	groups := make([]string, 0, len(committed))
+	for group := range committed {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+
+	writeHeader(&buf, "kafka_consumergroup_current_offset", "The offset committed by the consumer group.")
+	for _, group := range groups {
+		offsets := committed[group]
+		for _, tp := range sortedPartitions(offsets) {
+			fmt.Fprintf(&buf, "kafka_consumergroup_current_offset{group=%q,topic=%q,partition=\"%d\"} %d\n",
+				group, tp.topic, tp.partition, offsets[tp])
+		}
+	}
+
+	writeHeader(&buf, "kafka_consumergroup_lag", "The number of messages the consumer group is behind the high watermark.")
+	lagSums := make(map[string]map[string]int64)
+	for _, group := range groups {
+		offsets := committed[group]
+		lagSums[group] = make(map[string]int64)
+		for _, tp := range sortedPartitions(offsets) {
+			hwm, ok := highWatermarks[tp]
+			if !ok {
+				continue
+			}
+			lag := hwm - offsets[tp]
+			if lag < 0 {
+				lag = 0
+			}
+			lagSums[group][tp.topic] += lag
+			fmt.Fprintf(&buf, "kafka_consumergroup_lag{group=%q,topic=%q,partition=\"%d\"} %d\n",
+				group, tp.topic, tp.partition, lag)
+		}
+	}
+
+	writeHeader(&buf, "kafka_consumergroup_lag_sum", "The lag of the consumer group summed over the partitions of the topic.")
+	for _, group := range groups {
+		topics := make([]string, 0, len(lagSums[group]))
+		for topic := range lagSums[group] {
+			topics = append(topics, topic)
+		}
+		sort.Strings(topics)
+		for _, topic := range topics {
+			fmt.Fprintf(&buf, "kafka_consumergroup_lag_sum{group=%q,topic=%q} %d\n", group, topic, lagSums[group][topic])
+		}
+	}
+
+	writeHeader(&buf, "kafka_lag_exporter_last_collection_failed", "Whether the last computation of the lag failed (1) or not (0).")
+	fmt.Fprintf(&buf, "kafka_lag_exporter_last_collection_failed %d\n", failed)
+	writeHeader(&buf, "kafka_lag_exporter_collection_duration_seconds", "The duration of the last computation of the lag.")
+	fmt.Fprintf(&buf, "kafka_lag_exporter_collection_duration_seconds %f\n", time.Since(start).Seconds())
+
+	e.lock.Lock()
+	e.metrics = buf.Bytes()
+	e.lock.Unlock()
+}
+
+// committedOffsets returns the offsets committed by each consumer group
+// matching the filter.
+func (e *exporter) committedOffsets() (map[string]map[topicPartition]int64, error) {
+	groups, err := e.admin.ListConsumerGroups()
+	if err != nil {
+		return nil, err
+	}
+	committed := make(map[string]map[topicPartition]int64)
+	for group := range groups {
+		if e.filter != nil && !e.filter.MatchString(group) {
+			continue
+		}
+		response, err := e.admin.ListConsumerGroupOffsets(group, nil)
+		if err != nil {
+			return committed, fmt.Errorf("group %s: %w", group, err)
+		}
+		offsets := make(map[topicPartition]int64)
+		for topic, blocks := range response.Blocks {
+			for partition, block := range blocks {
+				if block.Err == sarama.ErrNoError && block.Offset >= 0 {
+					offsets[topicPartition{topic, partition}] = block.Offset
+				}
+			}
+		}
+		committed[group] = offsets
+	}
+	return committed, nil
+}
+
+// highWatermarks returns the high watermarks of partitions, with a single
+// ListOffsets request per leader.
+func (e *exporter) highWatermarks(partitions map[topicPartition]bool) (map[topicPartition]int64, error) {
+	requests := make(map[*sarama.Broker]*sarama.OffsetRequest)
+	var firstErr error
+	for tp := range partitions {
+		broker, err := e.client.Leader(tp.topic, tp.partition)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		request, ok := requests[broker]
+		if !ok {
+			request = &sarama.OffsetRequest{}
+			if e.client.Config().Version.IsAtLeast(sarama.V0_10_1_0) {
+				request.Version = 1
+			}
+			requests[broker] = request
+		}
+		request.AddBlock(tp.topic, tp.partition, sarama.OffsetNewest, 1)
+	}
+
+	highWatermarks := make(map[topicPartition]int64)
+	for broker, request := range requests {
+		response, err := broker.GetAvailableOffsets(request)
+		if err != nil {
+			_ = broker.Close()
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		for topic, blocks := range response.Blocks {
+			for partition, block := range blocks {
+				if block.Err == sarama.ErrNoError && len(block.Offsets) == 1 {
+					highWatermarks[topicPartition{topic, partition}] = block.Offsets[0]
+				}
+			}
+		}
+	}
+	return highWatermarks, firstErr
+}
+
+func (e *exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e.lock.RLock()
+	metrics := e.metrics
+	e.lock.RUnlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	_, _ = w.Write(metrics)
+}
+
+func writeHeader(buf *bytes.Buffer, name, help string) {
+	fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+}
+
+func sortedPartitions(m map[topicPartition]int64) []topicPartition {
+	partitions := make([]topicPartition, 0, len(m))
+	for tp := range m {
+		partitions = append(partitions, tp)
+	}
+	sort.Slice(partitions, func(i, j int) bool {
+		if partitions[i].topic != partitions[j].topic {
+			return partitions[i].topic < partitions[j].topic
+		}
+		return partitions[i].partition < partitions[j].partition
+	})
+	return partitions
+}
+
+func printErrorAndExit(code int, format string, values ...interface{}) {
+	fmt.Fprintf(os.Stderr, "ERROR: %s\n", fmt.Sprintf(format, values...))
+	fmt.Fprintln(os.Stderr)
+	os.Exit(code)
+}
+
+func printUsageErrorAndExit(format string, values ...interface{}) {
+	fmt.Fprintf(os.Stderr, "ERROR: %s\n", fmt.Sprintf(format, values...))
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Available command line options:")
+	flag.PrintDefaults()
+	os.Exit(64)
+}
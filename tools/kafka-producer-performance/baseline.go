@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+)
+
+// Regression is a metric of the run that got worse than its -baseline by
+// more than the allowed threshold.
+type Regression struct {
+	Metric    string  `json:"metric"`
+	Baseline  float64 `json:"baseline"`
+	Current   float64 `json:"current"`
+	ChangePct float64 `json:"change_pct"`
+}
+
+// loadBaselineSummary reads the summary of a previous run from path, which
+// holds either the output of -output-format=json or just its summary line.
+func loadBaselineSummary(path string) *Summary {
+	in, err := os.Open(path)
+	if err != nil {
+		printErrorAndExit(69, "Failed to open baseline: %v", err)
+	}
+	defer in.Close()
+
+	var summary *Summary
+	s := bufio.NewScanner(in)
+	s.Buffer(make([]byte, 0, 64*1024), maxMessageFileLine)
+	for s.Scan() {
+		if len(s.Bytes()) == 0 {
+			continue
+		}
+		var line struct {
+			Type string `json:"type"`
+			Summary
+		}
+		if err := json.Unmarshal(s.Bytes(), &line); err != nil {
+			printErrorAndExit(69, "Failed to parse baseline %s: %v", path, err)
+		}
+		if line.Type == "summary" || line.Type == "" {
+			summary = &line.Summary
+		}
+	}
+	if err := s.Err(); err != nil {
+		printErrorAndExit(69, "Failed to read baseline %s: %v", path, err)
+	}
+	if summary == nil {
+		printErrorAndExit(69, "No summary found in baseline %s", path)
+	}
+	return summary
+}
+
+// findRegressions compares current to baseline, returning the p99 latency
+// increases greater than maxLatencyPct percent and the throughput decreases
+// greater than maxThroughputPct percent.
+func findRegressions(baseline, current *Summary, maxLatencyPct, maxThroughputPct float64) []Regression {
+	var regressions []Regression
+	check := func(metric string, base, cur, maxPct float64, higherIsBetter bool) {
+		if base == 0 {
+			return
+		}
+		change := (cur - base) / base * 100
+		if (higherIsBetter && -change > maxPct) || (!higherIsBetter && change > maxPct) {
+			regressions = append(regressions, Regression{metric, base, cur, change})
+		}
+	}
+	check("records_per_sec", baseline.RecordsPerSec, current.RecordsPerSec, maxThroughputPct, true)
+	check("p99_ms", baseline.RequestLatency.P99, current.RequestLatency.P99, maxLatencyPct, false)
+	if baseline.Verification != nil && current.Verification != nil {
+		check("e2e_p99_ms", baseline.Verification.Latency.P99, current.Verification.Latency.P99, maxLatencyPct, false)
+	}
+	return regressions
+}
+
+// warnParameterChanges logs the parameters of the run that differ from the
+// baseline, since the comparison is only meaningful between similar runs.
+func warnParameterChanges(baseline, current *Summary) {
+	ignored := map[string]bool{
+		"baseline":                  true,
+		"max-latency-regression":    true,
+		"max-throughput-regression": true,
+		"output-format":             true,
+		"print-interval":            true,
+	}
+	var names []string
+	for name, value := range current.Parameters {
+		if base, ok := baseline.Parameters[name]; ok && base != value && !ignored[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		log.Printf("Warning: -%s=%s differs from the baseline -%s=%s\n",
+			name, current.Parameters[name], name, baseline.Parameters[name])
+	}
+}
+
+func formatRegression(r Regression) string {
+	return fmt.Sprintf("%s regressed by %+.1f%% (%.2f -> %.2f)", r.Metric, r.ChangePct, r.Baseline, r.Current)
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"log"
+	gosync "sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+
+	"github.com/IBM/sarama"
+)
+
+// Chaos injects faults during a run to validate the resilience settings of
+// the client: it closes broker connections of the producers, pauses the
+// sending of messages and pauses the verification consumer. It measures how
+// long the partitions led by a closed broker take to deliver again.
+type Chaos struct {
+	// KillInterval is the mean interval between two closed connections.
+	KillInterval time.Duration
+	// PauseInterval is the mean interval between two pauses of PauseDuration
+	// of the sending.
+	PauseInterval time.Duration
+	PauseDuration time.Duration
+	// ConsumerPauseInterval is the mean interval between two pauses of
+	// ConsumerPauseDuration of the verification consumer.
+	ConsumerPauseInterval time.Duration
+	ConsumerPauseDuration time.Duration
+
+	rand     *lockedRand
+	topics   []string
+	recovery metrics.Histogram
+
+	lock     gosync.Mutex
+	clients  []sarama.Client
+	consumer sarama.Consumer
+	resume   chan struct{}
+	// killed holds the partitions led by a closed broker that have not
+	// delivered a message since.
+	killed       map[topicPartition]time.Time
+	killedCount  int32
+	kills        int64
+	pauses       int64
+	pausedFor    time.Duration
+	consumerHalt int64
+}
+
+// NewChaos returns a Chaos injecting faults in the runs producing to topics.
+func NewChaos(topics []string, seed int64) *Chaos {
+	return &Chaos{
+		rand:     &lockedRand{rand: newRand(seed)},
+		topics:   topics,
+		recovery: metrics.NewHistogram(metrics.NewUniformSample(1 << 16)),
+		killed:   make(map[topicPartition]time.Time),
+	}
+}
+
+// AddClient registers the client of a producer, whose connections are then
+// closed every KillInterval. Chaos closes it on Close.
+func (c *Chaos) AddClient(client sarama.Client) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.clients = append(c.clients, client)
+}
+
+// SetConsumer registers the verification consumer, paused every
+// ConsumerPauseInterval.
+func (c *Chaos) SetConsumer(consumer sarama.Consumer) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.consumer = consumer
+}
+
+// Run injects the faults until ctx is done.
+func (c *Chaos) Run(ctx context.Context) {
+	if c.KillInterval > 0 {
+		go c.every(ctx, c.KillInterval, c.kill)
+	}
+	if c.PauseInterval > 0 {
+		go c.every(ctx, c.PauseInterval, func() { c.pause(ctx) })
+	}
+	if c.ConsumerPauseInterval > 0 {
+		go c.every(ctx, c.ConsumerPauseInterval, func() { c.pauseConsumer(ctx) })
+	}
+}
+
+// every calls f at random intervals averaging interval, until ctx is done.
+func (c *Chaos) every(ctx context.Context, interval time.Duration, f func()) {
+	for {
+		// Jitter the intervals so that the faults don't line up with the
+		// periodic work of the client, such as the metadata refreshes.
+		timer := time.NewTimer(interval/2 + time.Duration(c.rand.Intn(int(interval))))
+		select {
+		case <-timer.C:
+			f()
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// kill closes the connection to a random broker of a random producer,
+// which the producer has to re-establish.
+func (c *Chaos) kill() {
+	c.lock.Lock()
+	if len(c.clients) == 0 {
+		c.lock.Unlock()
+		return
+	}
+	client := c.clients[c.rand.Intn(len(c.clients))]
+	c.lock.Unlock()
+
+	var connected []*sarama.Broker
+	for _, b := range client.Brokers() {
+		if ok, _ := b.Connected(); ok {
+			connected = append(connected, b)
+		}
+	}
+	if len(connected) == 0 {
+		return
+	}
+	broker := connected[c.rand.Intn(len(connected))]
+
+	// Look the leaders up before taking the lock, since it may refresh the
+	// metadata while Delivered waits for it.
+	var led []topicPartition
+	for _, topic := range c.topics {
+		partitions, err := client.Partitions(topic)
+		if err != nil {
+			continue
+		}
+		for _, partition := range partitions {
+			leader, err := client.Leader(topic, partition)
+			if err == nil && leader.ID() == broker.ID() {
+				led = append(led, topicPartition{topic, partition})
+			}
+		}
+	}
+
+	now := time.Now()
+	c.lock.Lock()
+	for _, tp := range led {
+		if _, ok := c.killed[tp]; !ok {
+			c.killed[tp] = now
+		}
+	}
+	atomic.StoreInt32(&c.killedCount, int32(len(c.killed)))
+	c.kills++
+	c.lock.Unlock()
+
+	log.Printf("Chaos: closing the connection to broker %d (%s), leading %d partitions\n", broker.ID(), broker.Addr(), len(led))
+	_ = broker.Close()
+}
+
+// pause stops the sending for PauseDuration.
+func (c *Chaos) pause(ctx context.Context) {
+	c.lock.Lock()
+	c.resume = make(chan struct{})
+	c.pauses++
+	c.lock.Unlock()
+	log.Printf("Chaos: pausing the sending for %s\n", c.PauseDuration)
+
+	start := time.Now()
+	timer := time.NewTimer(c.PauseDuration)
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+		timer.Stop()
+	}
+
+	c.lock.Lock()
+	close(c.resume)
+	c.resume = nil
+	c.pausedFor += time.Since(start)
+	c.lock.Unlock()
+}
+
+// pauseConsumer stops the fetching of the verification consumer for
+// ConsumerPauseDuration.
+func (c *Chaos) pauseConsumer(ctx context.Context) {
+	c.lock.Lock()
+	consumer := c.consumer
+	if consumer != nil {
+		c.consumerHalt++
+	}
+	c.lock.Unlock()
+	if consumer == nil {
+		return
+	}
+	log.Printf("Chaos: pausing the verification consumer for %s\n", c.ConsumerPauseDuration)
+
+	consumer.PauseAll()
+	timer := time.NewTimer(c.ConsumerPauseDuration)
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+		timer.Stop()
+	}
+	consumer.ResumeAll()
+}
+
+// Wait blocks while the sending is paused, or until ctx is done.
+func (c *Chaos) Wait(ctx context.Context) {
+	c.lock.Lock()
+	resume := c.resume
+	c.lock.Unlock()
+	if resume == nil {
+		return
+	}
+	select {
+	case <-resume:
+	case <-ctx.Done():
+	}
+}
+
+// Delivered records that message has been acknowledged, ending the recovery
+// of its partition if its leader was closed.
+func (c *Chaos) Delivered(message *sarama.ProducerMessage) {
+	if atomic.LoadInt32(&c.killedCount) == 0 {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	tp := topicPartition{message.Topic, message.Partition}
+	if killedAt, ok := c.killed[tp]; ok {
+		c.recovery.Update(time.Since(killedAt).Microseconds())
+		delete(c.killed, tp)
+		atomic.StoreInt32(&c.killedCount, int32(len(c.killed)))
+	}
+}
+
+// Close closes the clients of the producers.
+func (c *Chaos) Close() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, client := range c.clients {
+		_ = client.Close()
+	}
+	c.clients = nil
+}
+
+// ChaosStats holds the faults injected during a run, and how the partitions
+// recovered from the closed connections.
+type ChaosStats struct {
+	ConnectionKills int64   `json:"connection_kills"`
+	Recovered       int64   `json:"recovered"`
+	Unrecovered     int64   `json:"unrecovered"`
+	Recovery        Latency `json:"recovery"`
+	SendPauses      int64   `json:"send_pauses"`
+	PausedSec       float64 `json:"paused_sec"`
+	ConsumerPauses  int64   `json:"consumer_pauses"`
+}
+
+// Stats returns the faults injected so far.
+func (c *Chaos) Stats() *ChaosStats {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	recovery := c.recovery.Snapshot()
+	return &ChaosStats{
+		ConnectionKills: c.kills,
+		Recovered:       recovery.Count(),
+		Unrecovered:     int64(len(c.killed)),
+		Recovery:        newLatency(recovery, 1000),
+		SendPauses:      c.pauses,
+		PausedSec:       c.pausedFor.Seconds(),
+		ConsumerPauses:  c.consumerHalt,
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/IBM/sarama"
+)
+
+// paddingHeaderKey is the key of the synthetic header added by -header-bytes.
+const paddingHeaderKey = "perf-padding"
+
+// syntheticHeaderPrefix prefixes the index of the headers added by
+// -header-count.
+const syntheticHeaderPrefix = "perf-header-"
+
+// HeaderGenerator computes the record headers of the generated messages.
+type HeaderGenerator struct {
+	// Static are the headers attached to every message.
+	Static []sarama.RecordHeader
+	// Count is the number of synthetic headers, perf-header-0 to
+	// perf-header-<Count-1>, attached to every message with random values of
+	// Size bytes.
+	Count, Size int
+	// PaddingBytes is the size of the random value of an extra header, if
+	// set.
+	PaddingBytes int
+}
+
+// headerFlags collects the values of a repeatable flag.
+type headerFlags []string
+
+func (f *headerFlags) String() string {
+	return strings.Join(*f, " ")
+}
+
+func (f *headerFlags) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// parseHeader parses a key=value header, whose value may hold any character.
+func parseHeader(flag, header string) sarama.RecordHeader {
+	kv := strings.SplitN(header, "=", 2)
+	if len(kv) != 2 || kv[0] == "" {
+		printUsageErrorAndExit(fmt.Sprintf("%s entries must be key=value, got: %q", flag, header))
+	}
+	return sarama.RecordHeader{Key: []byte(kv[0]), Value: []byte(kv[1])}
+}
+
+// parseHeaders parses a comma separated list of key=value headers.
+func parseHeaders(spec string) []sarama.RecordHeader {
+	if spec == "" {
+		return nil
+	}
+	var headers []sarama.RecordHeader
+	for _, header := range strings.Split(spec, ",") {
+		headers = append(headers, parseHeader("-headers", header))
+	}
+	return headers
+}
+
+func parseHeaderGenerator(spec string, repeated []string, count, size, paddingBytes int) *HeaderGenerator {
+	if paddingBytes < 0 {
+		printUsageErrorAndExit("-header-bytes must not be negative")
+	}
+	if count < 0 || size < 0 {
+		printUsageErrorAndExit("-header-count and -header-size must not be negative")
+	}
+	if size > 0 && count == 0 {
+		printUsageErrorAndExit("-header-size requires -header-count")
+	}
+	headers := parseHeaders(spec)
+	for _, header := range repeated {
+		headers = append(headers, parseHeader("-header", header))
+	}
+	if len(headers) == 0 && count == 0 && paddingBytes == 0 {
+		return nil
+	}
+	return &HeaderGenerator{Static: headers, Count: count, Size: size, PaddingBytes: paddingBytes}
+}
+
+// Next returns the headers of the next message.
+func (g *HeaderGenerator) Next() []sarama.RecordHeader {
+	if g.Count == 0 && g.PaddingBytes == 0 {
+		return g.Static
+	}
+	headers := make([]sarama.RecordHeader, len(g.Static), len(g.Static)+g.Count+1)
+	copy(headers, g.Static)
+	values := make([]byte, g.Count*g.Size+g.PaddingBytes)
+	if _, err := rand.Read(values); err != nil {
+		printErrorAndExit(69, "Failed to generate message headers: %s", err)
+	}
+	for i := 0; i < g.Count; i++ {
+		headers = append(headers, sarama.RecordHeader{
+			Key:   []byte(syntheticHeaderPrefix + strconv.Itoa(i)),
+			Value: values[i*g.Size : (i+1)*g.Size : (i+1)*g.Size],
+		})
+	}
+	if g.PaddingBytes == 0 {
+		return headers
+	}
+	return append(headers, sarama.RecordHeader{Key: []byte(paddingHeaderKey), Value: values[g.Count*g.Size:]})
+}
+
+// nextHeaders returns the next headers of g, or nil if g is nil.
+func nextHeaders(g *HeaderGenerator) []sarama.RecordHeader {
+	if g == nil {
+		return nil
+	}
+	return g.Next()
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	mathrand "math/rand"
+	"os"
+	gosync "sync"
+	"sync/atomic"
+
+	"github.com/IBM/sarama"
+)
+
+// KeyGenerator computes the keys of the generated messages. It is shared by
+// all the generating routines and must be safe for concurrent use.
+type KeyGenerator interface {
+	// Next returns the key of the next message, or nil for keyless messages.
+	Next() sarama.Encoder
+}
+
+func parseKeyGenerator(mode string, size, cardinality int, file string, seed int64) KeyGenerator {
+	if cardinality < 0 {
+		printUsageErrorAndExit("-key-cardinality must not be negative")
+	}
+	switch mode {
+	case "none":
+		return nil
+	case "random":
+		if size <= 0 && cardinality == 0 {
+			printUsageErrorAndExit("-key-mode=random requires -key-size or -key-cardinality")
+		}
+		return &RandomKeyGenerator{
+			Size:        size,
+			Cardinality: cardinality,
+			rand:        newRand(seed),
+		}
+	case "sequential":
+		return &SequentialKeyGenerator{Size: size, Cardinality: cardinality}
+	case "round-robin-from-file":
+		if file == "" {
+			printUsageErrorAndExit("-key-mode=round-robin-from-file requires -key-file")
+		}
+		return newFileKeyGenerator(file, cardinality)
+	default:
+		printUsageErrorAndExit(fmt.Sprintf("Unknown -key-mode: %s", mode))
+	}
+	panic("should not happen")
+}
+
+// defaultKeyMode returns the -key-mode implied by the other key flags when it
+// is not given, so that -key-file or -key-size alone generate keyed messages.
+func defaultKeyMode(size int, file string) string {
+	switch {
+	case file != "":
+		return "round-robin-from-file"
+	case size > 0:
+		return "random"
+	default:
+		return "none"
+	}
+}
+
+// formatKey renders n as a decimal key, zero-padded to size bytes.
+func formatKey(n uint64, size int) sarama.Encoder {
+	return sarama.StringEncoder(fmt.Sprintf("%0*d", size, n))
+}
+
+// RandomKeyGenerator picks keys at random: either Size random bytes, or one
+// of Cardinality distinct keys when Cardinality is set.
+type RandomKeyGenerator struct {
+	Size        int
+	Cardinality int
+
+	lock gosync.Mutex
+	rand *mathrand.Rand
+}
+
+func (g *RandomKeyGenerator) Next() sarama.Encoder {
+	if g.Cardinality > 0 {
+		g.lock.Lock()
+		n := g.rand.Int63n(int64(g.Cardinality))
+		g.lock.Unlock()
+		return formatKey(uint64(n), g.Size)
+	}
+	key := make([]byte, g.Size)
+	g.lock.Lock()
+	_, _ = g.rand.Read(key)
+	g.lock.Unlock()
+	return sarama.ByteEncoder(key)
+}
+
+// SequentialKeyGenerator hands out increasing keys, wrapping around after
+// Cardinality keys when it is set.
+type SequentialKeyGenerator struct {
+	Size        int
+	Cardinality int
+
+	next uint64
+}
+
+func (g *SequentialKeyGenerator) Next() sarama.Encoder {
+	n := atomic.AddUint64(&g.next, 1) - 1
+	if g.Cardinality > 0 {
+		n %= uint64(g.Cardinality)
+	}
+	return formatKey(n, g.Size)
+}
+
+// FileKeyGenerator cycles through the keys read from a file, one per line.
+type FileKeyGenerator struct {
+	keys [][]byte
+	next uint64
+}
+
+func newFileKeyGenerator(file string, cardinality int) *FileKeyGenerator {
+	in, err := os.Open(file)
+	if err != nil {
+		printErrorAndExit(69, "Failed to open key file: %v", err)
+	}
+	defer in.Close()
+
+	g := &FileKeyGenerator{}
+	r := bufio.NewScanner(in)
+	for r.Scan() && (cardinality == 0 || len(g.keys) < cardinality) {
+		if b := r.Bytes(); len(b) != 0 {
+			g.keys = append(g.keys, append([]byte(nil), b...))
+		}
+	}
+	if err = r.Err(); err != nil {
+		printErrorAndExit(69, "Failed to scan key file: %v", err)
+	}
+	if len(g.keys) == 0 {
+		printErrorAndExit(69, "No keys found in key file: %s", file)
+	}
+	return g
+}
+
+func (g *FileKeyGenerator) Next() sarama.Encoder {
+	n := atomic.AddUint64(&g.next, 1) - 1
+	return sarama.ByteEncoder(g.keys[n%uint64(len(g.keys))])
+}
+
+// nextKey returns the next key of g, or nil if g is nil.
+func nextKey(g KeyGenerator) sarama.Encoder {
+	if g == nil {
+		return nil
+	}
+	return g.Next()
+}
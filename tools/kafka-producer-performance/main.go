@@ -1,23 +1,24 @@
 package main
 
 import (
-	"bufio"
 	"context"
 	"crypto/rand"
 	"crypto/x509"
 	"encoding/base64"
 	"encoding/hex"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"os/signal"
 	"strings"
 	gosync "sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
-	"github.com/rcrowley/go-metrics"
-
 	"github.com/IBM/sarama"
 	"github.com/IBM/sarama/tools/tls"
 )
@@ -38,15 +39,89 @@ var (
 		0,
 		"(OR -message-file) The approximate size (in bytes) of each message to produce to -topic.",
 	)
+	messageSizeDist = flag.String(
+		"message-size-dist",
+		"fixed",
+		"The distribution of the sizes of the generated messages: fixed (-message-size), "+
+			"uniform:min-max or lognormal:mean-stddev (in bytes).",
+	)
+	seed = flag.Int64(
+		"seed",
+		0,
+		"The seed of the generated payloads, sizes and keys, making them reproducible across runs "+
+			"(0 for a random seed). With -verify, the payload contents are verified too.",
+	)
+	payloadCompressibility = flag.Int(
+		"payload-compressibility",
+		0,
+		"The percentage (0-100) of each generated message made of repeated rather than random bytes.",
+	)
 	messageFile = flag.String(
 		"message-file",
 		"",
-		"(OR -message-size) The file holding the payload of messages, one message per line.",
+		"(OR -message-size) The file, directory or glob of files holding the payload of messages, one message per line. Gzip files are decompressed.",
 	)
 	messageDecoder = flag.String(
 		"message-decoder",
 		"raw",
-		"The decoder for the message lines in the -message-file (raw, hex, base64).",
+		"The decoder for the message lines in the -message-file (raw, hex, base64, jsonl). "+
+			"jsonl lines are objects with a value and an optional key, headers and partition (with -partitioner=manual).",
+	)
+	keyMode = flag.String(
+		"key-mode",
+		"none",
+		"How the message keys are generated (none, random, sequential, round-robin-from-file). "+
+			"Defaults to round-robin-from-file with -key-file, and to random with -key-size.",
+	)
+	keySize = flag.Int(
+		"key-size",
+		0,
+		"The size (in bytes) of the random keys, or the zero-padded width of the other generated keys.",
+	)
+	keyCardinality = flag.Int(
+		"key-cardinality",
+		0,
+		"The number of distinct keys to generate (0 for no limit).",
+	)
+	keyFile = flag.String(
+		"key-file",
+		"",
+		"The file holding the keys for -key-mode=round-robin-from-file, one key per line.",
+	)
+	headers = flag.String(
+		"headers",
+		"",
+		"A comma separated list of key=value record headers to attach to every message (requires -version >= 0.11.0.0).",
+	)
+	headerBytes = flag.Int(
+		"header-bytes",
+		0,
+		"The size (in bytes) of the random value of an extra header attached to every message.",
+	)
+	headerCount = flag.Int(
+		"header-count",
+		0,
+		"The number of synthetic headers, perf-header-<n>, attached to every message.",
+	)
+	headerSize = flag.Int(
+		"header-size",
+		0,
+		"The size (in bytes) of the random values of the -header-count synthetic headers.",
+	)
+	idempotent = flag.Bool(
+		"idempotent",
+		false,
+		"Use an idempotent producer (forces -required-acks=-1 and -max-open-requests=1, requires -version >= 0.11.0.0).",
+	)
+	transactionalID = flag.String(
+		"transactional-id",
+		"",
+		"The transactional ID of the producer, sending the messages in transactions (implies -idempotent).",
+	)
+	messagesPerTransaction = flag.Int(
+		"messages-per-transaction",
+		1000,
+		"The number of messages sent in each transaction when -transactional-id is set.",
 	)
 	brokers = flag.String(
 		"brokers",
@@ -81,17 +156,23 @@ var (
 	topic = flag.String(
 		"topic",
 		"",
-		"REQUIRED: The topic to run the performance test on.",
+		"REQUIRED (OR -topics): The topic to run the performance test on.",
+	)
+	topics = flag.String(
+		"topics",
+		"",
+		"(OR -topic) A comma separated list of topics to run the performance test on, "+
+			"each with an optional relative weight (e.g. topicA:3,topicB:1).",
 	)
 	partition = flag.Int(
 		"partition",
 		-1,
-		"The partition of -topic to run the performance test on.",
+		"The partition of -topic (or of each of -topics) to run the performance test on.",
 	)
 	throughput = flag.Int(
 		"throughput",
 		0,
-		"The maximum number of messages to send per second (0 for no limit).",
+		"The maximum number of messages to send per second, per producer with -producers (0 for no limit).",
 	)
 	maxOpenRequests = flag.Int(
 		"max-open-requests",
@@ -103,10 +184,10 @@ var (
 		1000000,
 		"The max permitted size of a message.",
 	)
-	requiredAcks = flag.Int(
+	requiredAcks = flag.String(
 		"required-acks",
-		1,
-		"The required number of acks needed from the broker (-1: all, 0: none, 1: local).",
+		"1",
+		"The required number of acks needed from the broker (all or -1, none or 0, leader or 1).",
 	)
 	timeout = flag.Duration(
 		"timeout",
@@ -158,6 +239,11 @@ var (
 		1,
 		"The number of routines to send the messages from (-sync only).",
 	)
+	producers = flag.Int(
+		"producers",
+		1,
+		"The number of independent producers, each with its own connections, to send the messages from (async only).",
+	)
 	version = flag.String(
 		"version",
 		"0.8.2.0",
@@ -168,8 +254,117 @@ var (
 		false,
 		"Turn on sarama logging to stderr",
 	)
+	verify = flag.Bool(
+		"verify",
+		false,
+		"Consume the produced messages back and report end-to-end latency, loss and reordering "+
+			"(embeds a sequence number and send timestamp in the first 16 bytes of each message).",
+	)
+	verifyTimeout = flag.Duration(
+		"verify-timeout",
+		30*time.Second,
+		"How long to wait for the next message to be read back before giving up on -verify.",
+	)
+	warmup = flag.Duration(
+		"warmup",
+		0,
+		"The duration during which messages are sent but excluded from the reported metrics.",
+	)
+	maxErrors = flag.Int(
+		"max-errors",
+		0,
+		"The number of send errors to tolerate before aborting the run (0 aborts on the first one).",
+	)
+	producerRetries = flag.Int(
+		"producer-retries",
+		3,
+		"The number of times the producer retries sending a message.",
+	)
+	retryBackoff = flag.Duration(
+		"retry-backoff",
+		100*time.Millisecond,
+		"How long the producer waits before retrying to send a message.",
+	)
+	drainTimeout = flag.Duration(
+		"drain-timeout",
+		10*time.Second,
+		"How long to wait for in-flight messages when interrupted, before printing the final report.",
+	)
+	printInterval = flag.Duration(
+		"print-interval",
+		5*time.Second,
+		"How often to print the metrics during the run.",
+	)
+	perPartition = flag.Bool(
+		"per-partition",
+		false,
+		"Also print the send rate and error count of each partition.",
+	)
+	runtimeStats = flag.Bool(
+		"runtime-stats",
+		false,
+		"Also print the Go runtime stats (goroutines, heap, GC pauses) of the tool.",
+	)
+	pprofAddr = flag.String(
+		"pprof-addr",
+		"",
+		"The address to serve net/http/pprof on (e.g. localhost:6060), if set.",
+	)
+	chaosKillInterval = flag.Duration(
+		"chaos-kill-interval",
+		0,
+		"If set, the mean interval between two closings of a random broker connection of the producers, to measure how they recover.",
+	)
+	chaosPauseInterval = flag.Duration(
+		"chaos-pause-interval",
+		0,
+		"If set, the mean interval between two pauses of -chaos-pause-duration of the sending of messages.",
+	)
+	chaosPauseDuration = flag.Duration(
+		"chaos-pause-duration",
+		time.Second,
+		"The duration of the pauses of the sending of messages (with -chaos-pause-interval).",
+	)
+	chaosConsumerPauseInterval = flag.Duration(
+		"chaos-consumer-pause-interval",
+		0,
+		"If set, the mean interval between two pauses of -chaos-consumer-pause-duration of the verification consumer (with -verify).",
+	)
+	chaosConsumerPauseDuration = flag.Duration(
+		"chaos-consumer-pause-duration",
+		5*time.Second,
+		"The duration of the pauses of the verification consumer (with -chaos-consumer-pause-interval).",
+	)
+	outputFormat = flag.String(
+		"output-format",
+		"text",
+		"The format of the periodic stats and of the final summary (text, json, csv).",
+	)
+	baselineFile = flag.String(
+		"baseline",
+		"",
+		"The -output-format=json output of a previous run to compare this run to, exiting with 1 on regressions.",
+	)
+	maxLatencyRegression = flag.Float64(
+		"max-latency-regression",
+		10,
+		"The percentage by which the p99 latencies may exceed the -baseline.",
+	)
+	maxThroughputRegression = flag.Float64(
+		"max-throughput-regression",
+		10,
+		"The percentage by which the throughput may fall below the -baseline.",
+	)
 )
 
+// headerList holds the values of the repeatable -header flag.
+var headerList headerFlags
+
+func init() {
+	flag.Var(&headerList, "header",
+		"A key=value record header to attach to every message, whose value may hold commas (repeatable, requires -version >= 0.11.0.0).")
+}
+
 type DecoderFunc func(text []byte) (message []byte, err error)
 
 func parseMessageDecoder(scheme string) DecoderFunc {
@@ -216,6 +411,20 @@ func parseCompression(scheme string) sarama.CompressionCodec {
 	panic("should not happen")
 }
 
+func parseRequiredAcks(acks string) sarama.RequiredAcks {
+	switch acks {
+	case "all", "-1":
+		return sarama.WaitForAll
+	case "none", "0":
+		return sarama.NoResponse
+	case "leader", "1":
+		return sarama.WaitForLocal
+	default:
+		printUsageErrorAndExit(fmt.Sprintf("Unknown -required-acks: %s", acks))
+	}
+	panic("should not happen")
+}
+
 func parsePartitioner(scheme string, partition int) sarama.PartitionerConstructor {
 	if partition < 0 && scheme == "manual" {
 		printUsageErrorAndExit("-partition must not be -1 for -partitioning=manual")
@@ -244,7 +453,7 @@ func parseVersion(version string) sarama.KafkaVersion {
 }
 
 type MessageGenerator interface {
-	Generate(topic string, partition, messageLoad int) <-chan *sarama.ProducerMessage
+	Generate(topics *WeightedTopics, partition, messageLoad int) <-chan *sarama.ProducerMessage
 }
 
 func makeMessageChan(messageLoad int) chan *sarama.ProducerMessage {
@@ -256,64 +465,36 @@ func makeMessageChan(messageLoad int) chan *sarama.ProducerMessage {
 }
 
 type RandomMessageGenerator struct {
-	MessageSize int
+	Sizes           SizeDistribution
+	Compressibility int
+	KeyGenerator    KeyGenerator
+	HeaderGenerator *HeaderGenerator
+	// Seed makes the payloads reproducible when set: each call to Generate
+	// reads them from its own deterministic stream.
+	Seed int64
+
+	streams uint64
 }
 
-func (g *RandomMessageGenerator) Generate(topic string, partition, messageLoad int) <-chan *sarama.ProducerMessage {
+func (g *RandomMessageGenerator) Generate(topics *WeightedTopics, partition, messageLoad int) <-chan *sarama.ProducerMessage {
 	messages := makeMessageChan(messageLoad)
+	var rnd io.Reader = rand.Reader
+	if g.Seed != 0 {
+		rnd = newSeededReader(g.Seed, atomic.AddUint64(&g.streams, 1))
+	}
 	go func() {
 		log.Printf("RandomMessageGenerator is generating %d messages\n", messageLoad)
 		for i := 0; i < messageLoad; i++ {
-			payload := make([]byte, g.MessageSize)
-			if _, err := rand.Read(payload); err != nil {
+			payload := make([]byte, g.Sizes.Next())
+			if err := fillPayload(payload, g.Compressibility, rnd); err != nil {
 				printErrorAndExit(69, "Failed to generate message payload: %s", err)
 			}
 			messages <- &sarama.ProducerMessage{
-				Topic:     topic,
+				Topic:     topics.Next(),
 				Partition: int32(partition),
+				Key:       nextKey(g.KeyGenerator),
 				Value:     sarama.ByteEncoder(payload),
-			}
-		}
-		close(messages)
-	}()
-	return messages
-}
-
-type FileMessageGenerator struct {
-	MessageFile string
-	DecoderFunc DecoderFunc
-}
-
-func (g *FileMessageGenerator) Generate(topic string, partition, messageLoad int) <-chan *sarama.ProducerMessage {
-	messages := makeMessageChan(messageLoad)
-	in, err := os.Open(g.MessageFile)
-	if err != nil {
-		printErrorAndExit(69, "Failed to open message file: %v", err)
-	}
-	defer in.Close()
-	r := bufio.NewScanner(in)
-
-	records := make([][]byte, 0, 64)
-	for r.Scan() {
-		if b := r.Bytes(); len(b) != 0 {
-			text, err := g.DecoderFunc(b)
-			if err != nil {
-				printErrorAndExit(69, "Failed to decode message data: %s", string(text))
-			}
-			records = append(records, text)
-		}
-	}
-	if err = r.Err(); err != nil {
-		printErrorAndExit(69, "Failed to scan message file: %v", err)
-	}
-
-	log.Printf("FileMessageGenerator is generating %d messages from %d records\n", messageLoad, len(records))
-	go func() {
-		for i := 0; i < messageLoad; i++ {
-			messages <- &sarama.ProducerMessage{
-				Topic:     topic,
-				Partition: int32(partition),
-				Value:     sarama.ByteEncoder(records[i%len(records)]),
+				Headers:   nextHeaders(g.HeaderGenerator),
 			}
 		}
 		close(messages)
@@ -327,21 +508,60 @@ func main() {
 	if *brokers == "" {
 		printUsageErrorAndExit("-brokers is required")
 	}
-	if *topic == "" {
-		printUsageErrorAndExit("-topic is required")
+	if (*topic == "") == (*topics == "") {
+		printUsageErrorAndExit("exactly one of -topic or -topics must be set")
 	}
 	if *messageLoad <= 0 {
 		printUsageErrorAndExit("-message-load must be greater than 0")
 	}
-	if *messageSize <= 0 && *messageFile == "" {
-		printUsageErrorAndExit("one of -message-size or -message-file must be set")
+	if *messageSize <= 0 && *messageFile == "" && *messageSizeDist == "fixed" {
+		printUsageErrorAndExit("one of -message-size, -message-size-dist or -message-file must be set")
+	}
+	if *payloadCompressibility < 0 || *payloadCompressibility > 100 {
+		printUsageErrorAndExit("-payload-compressibility must be between 0 and 100")
 	}
 	if *routines < 1 || *routines > *messageLoad {
 		printUsageErrorAndExit("-routines must be greater than 0 and less than or equal to -message-load")
 	}
+	if *producers < 1 || *producers > *messageLoad {
+		printUsageErrorAndExit("-producers must be greater than 0 and less than or equal to -message-load")
+	}
+	if *sync && *producers > 1 {
+		printUsageErrorAndExit("-producers greater than 1 is not supported with -sync, use -routines")
+	}
 	if *securityProtocol != "PLAINTEXT" && *securityProtocol != "SSL" {
 		printUsageErrorAndExit(fmt.Sprintf("-security-protocol %q is not supported", *securityProtocol))
 	}
+	if *maxErrors < 0 {
+		printUsageErrorAndExit("-max-errors must not be negative")
+	}
+	if *printInterval <= 0 {
+		printUsageErrorAndExit("-print-interval must be greater than 0")
+	}
+	if *chaosKillInterval < 0 || *chaosPauseInterval < 0 || *chaosConsumerPauseInterval < 0 {
+		printUsageErrorAndExit("the -chaos intervals must not be negative")
+	}
+	if *chaosPauseInterval > 0 && *chaosPauseDuration <= 0 {
+		printUsageErrorAndExit("-chaos-pause-duration must be greater than 0")
+	}
+	if *chaosConsumerPauseInterval > 0 && (*chaosConsumerPauseDuration <= 0 || !*verify) {
+		printUsageErrorAndExit("-chaos-consumer-pause-interval requires -verify and a -chaos-consumer-pause-duration greater than 0")
+	}
+	if *verify && *messageSize > 0 && *messageSize < verificationHeaderSize {
+		printUsageErrorAndExit(fmt.Sprintf("-message-size must be at least %d bytes with -verify", verificationHeaderSize))
+	}
+	if *transactionalID != "" {
+		if *messagesPerTransaction < 1 {
+			printUsageErrorAndExit("-messages-per-transaction must be greater than 0")
+		}
+		if *sync && *routines > 1 {
+			printUsageErrorAndExit("-transactional-id does not support -routines greater than 1")
+		}
+		if *producers > 1 {
+			printUsageErrorAndExit("-transactional-id does not support -producers greater than 1")
+		}
+		*idempotent = true
+	}
 	if *verbose {
 		sarama.Logger = log.New(os.Stderr, "", log.LstdFlags)
 	}
@@ -350,7 +570,7 @@ func main() {
 
 	config.Net.MaxOpenRequests = *maxOpenRequests
 	config.Producer.MaxMessageBytes = *maxMessageBytes
-	config.Producer.RequiredAcks = sarama.RequiredAcks(*requiredAcks)
+	config.Producer.RequiredAcks = parseRequiredAcks(*requiredAcks)
 	config.Producer.Timeout = *timeout
 	config.Producer.Partitioner = parsePartitioner(*partitioner, *partition)
 	config.Producer.Compression = parseCompression(*compression)
@@ -359,10 +579,30 @@ func main() {
 	config.Producer.Flush.Messages = *flushMessages
 	config.Producer.Flush.MaxMessages = *flushMaxMessages
 	config.Producer.Return.Successes = true
+	config.Producer.Retry.Max = *producerRetries
+	config.Producer.Retry.Backoff = *retryBackoff
 	config.ClientID = *clientID
 	config.ChannelBufferSize = *channelBufferSize
 	config.Version = parseVersion(*version)
 
+	if *idempotent {
+		if !config.Version.IsAtLeast(sarama.V0_11_0_0) {
+			printUsageErrorAndExit("-idempotent and -transactional-id require -version >= 0.11.0.0")
+		}
+		explicit := make(map[string]bool)
+		flag.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+		if explicit["required-acks"] && config.Producer.RequiredAcks != sarama.WaitForAll {
+			log.Printf("Overriding -required-acks=%s with all for the idempotent producer\n", *requiredAcks)
+		}
+		if explicit["max-open-requests"] && config.Net.MaxOpenRequests != 1 {
+			log.Printf("Overriding -max-open-requests=%d with 1 for the idempotent producer\n", *maxOpenRequests)
+		}
+		config.Producer.Idempotent = true
+		config.Producer.RequiredAcks = sarama.WaitForAll
+		config.Net.MaxOpenRequests = 1
+		config.Producer.Transaction.ID = *transactionalID
+	}
+
 	if *securityProtocol == "SSL" {
 		tlsConfig, err := tls.NewConfig(*tlsClientCert, *tlsClientKey)
 		if err != nil {
@@ -391,16 +631,45 @@ func main() {
 		printErrorAndExit(69, "Invalid configuration: %s", err)
 	}
 
+	reporter := newReporter(*outputFormat, os.Stdout)
+
+	var baselineSummary *Summary
+	if *baselineFile != "" {
+		baselineSummary = loadBaselineSummary(*baselineFile)
+	}
+
+	if *pprofAddr != "" {
+		servePprof(*pprofAddr)
+	}
+	var runtimeSampler *RuntimeSampler
+	if *runtimeStats {
+		runtimeSampler = &RuntimeSampler{}
+	}
+
+	var partitionTracker *PartitionTracker
+	if *perPartition {
+		partitionTracker = NewPartitionTracker()
+	}
+
 	// Print out metrics periodically.
 	done := make(chan struct{})
 	ctx, cancel := context.WithCancel(context.Background())
 	go func(ctx context.Context) {
 		defer close(done)
-		t := time.Tick(5 * time.Second)
+		t := time.NewTicker(*printInterval)
+		defer t.Stop()
 		for {
 			select {
-			case <-t:
-				printMetrics(os.Stdout, config.MetricRegistry)
+			case <-t.C:
+				if stats := collectStats(config.MetricRegistry); stats != nil {
+					if runtimeSampler != nil {
+						stats.Runtime = runtimeSampler.Sample()
+					}
+					reporter.ReportStats(stats)
+				}
+				if partitionTracker != nil {
+					reporter.ReportPartitions(partitionTracker.Interval())
+				}
 			case <-ctx.Done():
 				return
 			}
@@ -409,166 +678,525 @@ func main() {
 
 	brokers := strings.Split(*brokers, ",")
 
+	var weightedTopics *WeightedTopics
+	if *topics != "" {
+		weightedTopics = parseTopics(*topics)
+	} else {
+		weightedTopics = singleTopic(*topic)
+	}
+
+	mode := *keyMode
+	keyModeSet := false
+	flag.Visit(func(f *flag.Flag) { keyModeSet = keyModeSet || f.Name == "key-mode" })
+	if !keyModeSet {
+		mode = defaultKeyMode(*keySize, *keyFile)
+	}
+	keyGenerator := parseKeyGenerator(mode, *keySize, *keyCardinality, *keyFile, *seed)
+	headerGenerator := parseHeaderGenerator(*headers, headerList, *headerCount, *headerSize, *headerBytes)
+	if headerGenerator != nil && !config.Version.IsAtLeast(sarama.V0_11_0_0) {
+		printUsageErrorAndExit("-header, -headers, -header-count and -header-bytes require -version >= 0.11.0.0")
+	}
+
 	var messageGenerator MessageGenerator
 	if *messageFile != "" {
-		messageGenerator = &FileMessageGenerator{*messageFile, parseMessageDecoder(*messageDecoder)}
+		messageGenerator = &FileMessageGenerator{
+			expandMessageFiles(*messageFile),
+			parseRecordDecoder(*messageDecoder),
+			keyGenerator,
+			headerGenerator,
+		}
 	} else {
-		messageGenerator = &RandomMessageGenerator{*messageSize}
+		sizes := parseSizeDistribution(*messageSizeDist, *messageSize, *seed)
+		averageMessageSize = sizes.Mean()
+		messageGenerator = &RandomMessageGenerator{
+			Sizes:           sizes,
+			Compressibility: *payloadCompressibility,
+			KeyGenerator:    keyGenerator,
+			HeaderGenerator: headerGenerator,
+			Seed:            *seed,
+		}
+	}
+
+	var verifier *Verifier
+	var sequencer *Sequencer
+	if *verify {
+		sequencer = &Sequencer{}
+		if *messageFile == "" {
+			// The payload contents only depend on the seed with generated
+			// payloads, the verifier can't check those of -message-file.
+			sequencer.Seed = *seed
+			sequencer.Compressibility = *payloadCompressibility
+		}
+
+		verifierConfig := sarama.NewConfig()
+		verifierConfig.Net = config.Net
+		verifierConfig.ClientID = config.ClientID + "-verifier"
+		verifierConfig.ChannelBufferSize = config.ChannelBufferSize
+		verifierConfig.Version = config.Version
+
+		var err error
+		verifier, err = NewVerifier(brokers, verifierConfig, weightedTopics.Names, *partition, sequencer)
+		if err != nil {
+			printErrorAndExit(69, "Failed to start verification consumer: %s", err)
+		}
+	}
+
+	var chaos *Chaos
+	if *chaosKillInterval > 0 || *chaosPauseInterval > 0 || *chaosConsumerPauseInterval > 0 {
+		chaos = NewChaos(weightedTopics.Names, *seed)
+		chaos.KillInterval = *chaosKillInterval
+		chaos.PauseInterval = *chaosPauseInterval
+		chaos.PauseDuration = *chaosPauseDuration
+		chaos.ConsumerPauseInterval = *chaosConsumerPauseInterval
+		chaos.ConsumerPauseDuration = *chaosConsumerPauseDuration
+		if verifier != nil {
+			chaos.SetConsumer(verifier.consumer)
+		}
 	}
 
+	var transactor *Transactor
+	if *transactionalID != "" {
+		transactor = NewTransactor(*messagesPerTransaction)
+	}
+
+	start := time.Now()
+	var warmupDone *time.Timer
+	if *warmup > 0 {
+		warmupDone = time.AfterFunc(*warmup, func() {
+			resetMetrics(config.MetricRegistry)
+			if verifier != nil {
+				verifier.ResetLatency()
+			}
+			if partitionTracker != nil {
+				partitionTracker.Reset()
+			}
+			log.Printf("Warmup of %s complete, metrics have been reset\n", *warmup)
+		})
+		start = start.Add(*warmup)
+	}
+
+	// Stop generating messages on the first SIGINT or SIGTERM, the next one
+	// kills the process.
+	runCtx, stopRun := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	interrupted := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-signals:
+			signal.Stop(signals)
+			log.Printf("Received %s, waiting for in-flight messages\n", sig)
+			interrupted <- sig
+			stopRun()
+		case <-runCtx.Done():
+		}
+	}()
+
+	run := &producerRun{
+		ctx:          runCtx,
+		stop:         stopRun,
+		maxErrors:    *maxErrors,
+		errorTypes:   make(map[string]int64),
+		topics:       weightedTopics,
+		partition:    *partition,
+		messageLoad:  *messageLoad,
+		generator:    messageGenerator,
+		config:       config,
+		brokers:      brokers,
+		throughput:   *throughput,
+		sequencer:    sequencer,
+		transactor:   transactor,
+		partitions:   partitionTracker,
+		chaos:        chaos,
+		drainTimeout: *drainTimeout,
+	}
+	if chaos != nil {
+		chaos.Run(runCtx)
+	}
 	if *sync {
-		runSyncProducer(*topic, *partition, *messageLoad, *routines, messageGenerator,
-			config, brokers, *throughput)
+		run.runSyncProducer(*routines)
 	} else {
-		runAsyncProducer(*topic, *partition, *messageLoad, messageGenerator,
-			config, brokers, *throughput)
+		run.runAsyncProducers(*producers)
 	}
+	stopRun()
 
 	cancel()
 	<-done
+
+	if warmupDone != nil && warmupDone.Stop() {
+		log.Printf("The run ended before the %s warmup, the metrics include it\n", *warmup)
+		start = start.Add(-*warmup)
+	}
+
+	summary := newSummary(collectStats(config.MetricRegistry), time.Since(start))
+	if runtimeSampler != nil {
+		summary.Runtime = runtimeSampler.Sample()
+	}
+	if len(weightedTopics.Names) > 1 {
+		summary.Topics = collectTopicRecords(config.MetricRegistry, weightedTopics.Names)
+	}
+	summary.Sent = atomic.LoadInt64(&run.delivered)
+	summary.Errors = atomic.LoadInt64(&run.failed)
+	summary.Unacknowledged = run.unacknowledged()
+	summary.ErrorTypes = run.errorTypeCounts()
+	summary.Aborted = atomic.LoadInt32(&run.aborted) == 1
+	var sig os.Signal
+	select {
+	case sig = <-interrupted:
+		summary.Interrupted = true
+	default:
+	}
+	if transactor != nil {
+		summary.Transactions = transactor.Stats()
+	}
+	if partitionTracker != nil {
+		summary.Partitions = partitionTracker.Total()
+	}
+	if chaos != nil {
+		summary.Chaos = chaos.Stats()
+		chaos.Close()
+	}
+	if verifier != nil {
+		verifier.Wait(sequencer.Count(), *verifyTimeout)
+		verifier.Close()
+		summary.Verification = verifier.Stats(sequencer.Count())
+	}
+	if baselineSummary != nil {
+		warnParameterChanges(baselineSummary, summary)
+		summary.Regressions = findRegressions(baselineSummary, summary, *maxLatencyRegression, *maxThroughputRegression)
+	}
+	reporter.ReportSummary(summary)
+
+	if sig != nil {
+		// Exit like a process killed by sig would.
+		os.Exit(128 + int(sig.(syscall.Signal)))
+	}
+	if summary.Aborted {
+		os.Exit(69)
+	}
+	if len(summary.Regressions) > 0 {
+		os.Exit(1)
+	}
+}
+
+// producerRun holds the parameters of a run and counts its messages.
+type producerRun struct {
+	ctx          context.Context
+	stop         context.CancelFunc
+	maxErrors    int
+	topics       *WeightedTopics
+	partition    int
+	messageLoad  int
+	generator    MessageGenerator
+	config       *sarama.Config
+	brokers      []string
+	throughput   int
+	sequencer    *Sequencer
+	transactor   *Transactor
+	partitions   *PartitionTracker
+	chaos        *Chaos
+	drainTimeout time.Duration
+
+	attempted int64
+	delivered int64
+	failed    int64
+	aborted   int32
+
+	errorsLock gosync.Mutex
+	errorTypes map[string]int64
 }
 
-func runAsyncProducer(topic string, partition, messageLoad int, messageGenerator MessageGenerator,
-	config *sarama.Config, brokers []string, throughput int) {
-	producer, err := sarama.NewAsyncProducer(brokers, config)
+// prepare readies message to be handed to the producer.
+func (r *producerRun) prepare(message *sarama.ProducerMessage) {
+	if r.sequencer != nil {
+		r.sequencer.Stamp(message)
+	}
+}
+
+// newClient returns the client of a new producer, whose connections the
+// chaos monkey may close.
+func (r *producerRun) newClient() sarama.Client {
+	client, err := sarama.NewClient(r.brokers, r.config)
 	if err != nil {
 		printErrorAndExit(69, "Failed to create producer: %s", err)
 	}
-	defer func() {
-		// Print final metrics.
-		printMetrics(os.Stdout, config.MetricRegistry)
-		if err := producer.Close(); err != nil {
-			printErrorAndExit(69, "Failed to close producer: %s", err)
+	r.chaos.AddClient(client)
+	return client
+}
+
+// sent records that a message has been handed to the producer.
+func (r *producerRun) sent() {
+	if r.transactor != nil {
+		r.transactor.Sent()
+	}
+}
+
+// success records that message has been acknowledged.
+func (r *producerRun) success(message *sarama.ProducerMessage) {
+	atomic.AddInt64(&r.delivered, 1)
+	if r.partitions != nil {
+		r.partitions.Success(message.Topic, message.Partition)
+	}
+	if r.chaos != nil {
+		r.chaos.Delivered(message)
+	}
+}
+
+// failure records that message could not be sent, aborting the run once
+// there are more than -max-errors failures.
+func (r *producerRun) failure(message *sarama.ProducerMessage, err error) {
+	failed := atomic.AddInt64(&r.failed, 1)
+	if r.partitions != nil {
+		r.partitions.Failure(message.Topic, message.Partition)
+	}
+
+	var producerErr *sarama.ProducerError
+	if errors.As(err, &producerErr) {
+		err = producerErr.Err
+	}
+	r.errorsLock.Lock()
+	r.errorTypes[err.Error()]++
+	r.errorsLock.Unlock()
+
+	if failed > int64(r.maxErrors) && r.ctx.Err() == nil && atomic.CompareAndSwapInt32(&r.aborted, 0, 1) {
+		log.Printf("Aborting the run after %d errors, the last one being: %s\n", failed, err)
+		r.stop()
+	}
+}
+
+// errorTypeCounts returns the number of failures by error.
+func (r *producerRun) errorTypeCounts() map[string]int64 {
+	r.errorsLock.Lock()
+	defer r.errorsLock.Unlock()
+
+	counts := make(map[string]int64, len(r.errorTypes))
+	for errorType, count := range r.errorTypes {
+		counts[errorType] = count
+	}
+	return counts
+}
+
+// drained waits for done, giving up after -drain-timeout once the run has
+// been interrupted.
+func (r *producerRun) drained(done <-chan struct{}) bool {
+	select {
+	case <-done:
+		return true
+	case <-r.ctx.Done():
+	}
+	timer := time.NewTimer(r.drainTimeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return true
+	case <-timer.C:
+		log.Printf("Gave up waiting for in-flight messages after %s\n", r.drainTimeout)
+		return false
+	}
+}
+
+// unacknowledged returns the number of messages handed to the producer for
+// which neither a success nor an error was received.
+func (r *producerRun) unacknowledged() int64 {
+	return atomic.LoadInt64(&r.attempted) - atomic.LoadInt64(&r.delivered) - atomic.LoadInt64(&r.failed)
+}
+
+// runAsyncProducers splits the messages among n independent async producers.
+// They share the metrics registry, so their stats are aggregated.
+func (r *producerRun) runAsyncProducers(n int) {
+	var wg gosync.WaitGroup
+	for i := 0; i < n; i++ {
+		messageLoad := r.messageLoad / n
+		if i == n-1 {
+			messageLoad += r.messageLoad % n
 		}
-	}()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.runAsyncProducer(messageLoad)
+		}()
+	}
+	wg.Wait()
+}
+
+func (r *producerRun) runAsyncProducer(messageLoad int) {
+	var producer sarama.AsyncProducer
+	var err error
+	if r.chaos != nil {
+		producer, err = sarama.NewAsyncProducerFromClient(r.newClient())
+	} else {
+		producer, err = sarama.NewAsyncProducer(r.brokers, r.config)
+	}
+	if err != nil {
+		printErrorAndExit(69, "Failed to create producer: %s", err)
+	}
+	if r.transactor != nil {
+		r.transactor.Begin(producer)
+	}
 
-	messages := messageGenerator.Generate(topic, partition, messageLoad)
+	messages := r.generator.Generate(r.topics, r.partition, messageLoad)
 
 	messagesDone := make(chan struct{})
 	go func() {
-		for i := 0; i < messageLoad; i++ {
+		defer close(messagesDone)
+		successes, errors := producer.Successes(), producer.Errors()
+		for successes != nil || errors != nil {
 			select {
-			case <-producer.Successes():
-			case err = <-producer.Errors():
-				printErrorAndExit(69, "%s", err)
+			case message, ok := <-successes:
+				if !ok {
+					successes = nil
+					continue
+				}
+				r.success(message)
+			case err, ok := <-errors:
+				if !ok {
+					errors = nil
+					continue
+				}
+				r.failure(err.Msg, err)
 			}
 		}
-		messagesDone <- struct{}{}
 	}()
 
-	if throughput > 0 {
-		ticker := time.NewTicker(time.Second)
-		var idx int = 0
-		for message := range messages {
-			producer.Input() <- message
-			if (idx+1)%throughput == 0 {
-				<-ticker.C
+	var ticker *time.Ticker
+	if r.throughput > 0 {
+		ticker = time.NewTicker(time.Second)
+		defer ticker.Stop()
+	}
+	var idx int = 0
+produce:
+	for {
+		select {
+		case <-r.ctx.Done():
+			break produce
+		case message, ok := <-messages:
+			if !ok {
+				break produce
+			}
+			r.prepare(message)
+			if r.chaos != nil {
+				r.chaos.Wait(r.ctx)
+			}
+			select {
+			case producer.Input() <- message:
+			case <-r.ctx.Done():
+				break produce
+			}
+			atomic.AddInt64(&r.attempted, 1)
+			r.sent()
+			if ticker != nil && (idx+1)%r.throughput == 0 {
+				select {
+				case <-ticker.C:
+				case <-r.ctx.Done():
+					break produce
+				}
 			}
 			idx++
 		}
-		ticker.Stop()
-	} else {
-		for message := range messages {
-			producer.Input() <- message
-		}
 	}
 
-	<-messagesDone
-	close(messagesDone)
+	go func() {
+		if r.transactor != nil {
+			r.transactor.Finish()
+		}
+		producer.AsyncClose()
+	}()
+	r.drained(messagesDone)
 }
 
-func runSyncProducer(topic string, partition, messageLoad, routines int, messageGenerator MessageGenerator,
-	config *sarama.Config, brokers []string, throughput int) {
-	producer, err := sarama.NewSyncProducer(brokers, config)
+func (r *producerRun) runSyncProducer(routines int) {
+	var producer sarama.SyncProducer
+	var err error
+	if r.chaos != nil {
+		producer, err = sarama.NewSyncProducerFromClient(r.newClient())
+	} else {
+		producer, err = sarama.NewSyncProducer(r.brokers, r.config)
+	}
 	if err != nil {
 		printErrorAndExit(69, "Failed to create producer: %s", err)
 	}
-	defer func() {
-		// Print final metrics.
-		printMetrics(os.Stdout, config.MetricRegistry)
-		if err := producer.Close(); err != nil {
-			printErrorAndExit(69, "Failed to close producer: %s", err)
-		}
-	}()
+	if r.transactor != nil {
+		r.transactor.Begin(producer)
+	}
 
 	messages := make([]<-chan *sarama.ProducerMessage, routines)
 	for i := 0; i < routines; i++ {
 		if i == routines-1 {
-			messages[i] = messageGenerator.Generate(topic, partition, messageLoad/routines+messageLoad%routines)
+			messages[i] = r.generator.Generate(r.topics, r.partition, r.messageLoad/routines+r.messageLoad%routines)
 		} else {
-			messages[i] = messageGenerator.Generate(topic, partition, messageLoad/routines)
+			messages[i] = r.generator.Generate(r.topics, r.partition, r.messageLoad/routines)
 		}
 	}
 
+	send := func(message *sarama.ProducerMessage) {
+		r.prepare(message)
+		if r.chaos != nil {
+			r.chaos.Wait(r.ctx)
+		}
+		atomic.AddInt64(&r.attempted, 1)
+		_, _, err := producer.SendMessage(message)
+		// counted whatever the outcome, as the async producer does once the
+		// message is handed over, but not before so that a transaction is not
+		// committed ahead of its last message
+		r.sent()
+		if err != nil {
+			r.failure(message, err)
+			return
+		}
+		r.success(message)
+	}
+
 	var wg gosync.WaitGroup
-	if throughput > 0 {
-		for _, messages := range messages {
-			messages := messages
-			wg.Add(1)
-			go func() {
-				ticker := time.NewTicker(time.Second)
-				for message := range messages {
-					for i := 0; i < throughput; i++ {
-						_, _, err = producer.SendMessage(message)
-						if err != nil {
-							printErrorAndExit(69, "Failed to send message: %s", err)
-						}
+	for _, messages := range messages {
+		messages := messages
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var ticker *time.Ticker
+			if r.throughput > 0 {
+				ticker = time.NewTicker(time.Second)
+				defer ticker.Stop()
+			}
+			idx := 0
+			for {
+				var message *sarama.ProducerMessage
+				var ok bool
+				select {
+				case <-r.ctx.Done():
+					return
+				case message, ok = <-messages:
+					if !ok {
+						return
 					}
-					<-ticker.C
 				}
-				ticker.Stop()
-				wg.Done()
-			}()
-		}
-	} else {
-		for _, messages := range messages {
-			messages := messages
-			wg.Add(1)
-			go func() {
-				for message := range messages {
-					_, _, err = producer.SendMessage(message)
-					if err != nil {
-						printErrorAndExit(69, "Failed to send message: %s", err)
+				// every generated message is sent once, as prepare rewrites it
+				send(message)
+				if ticker != nil && (idx+1)%r.throughput == 0 {
+					select {
+					case <-ticker.C:
+					case <-r.ctx.Done():
+						return
 					}
 				}
-				wg.Done()
-			}()
-		}
+				idx++
+			}
+		}()
 	}
-	wg.Wait()
-}
-
-func printMetrics(w io.Writer, r metrics.Registry) {
-	recordSendRateMetric := r.Get("record-send-rate")
-	requestLatencyMetric := r.Get("request-latency-in-ms")
-	outgoingByteRateMetric := r.Get("outgoing-byte-rate")
-	requestsInFlightMetric := r.Get("requests-in-flight")
 
-	if recordSendRateMetric == nil || requestLatencyMetric == nil || outgoingByteRateMetric == nil ||
-		requestsInFlightMetric == nil {
+	routinesDone := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(routinesDone)
+	}()
+	if !r.drained(routinesDone) {
 		return
 	}
-	recordSendRate := recordSendRateMetric.(metrics.Meter).Snapshot()
-	requestLatency := requestLatencyMetric.(metrics.Histogram).Snapshot()
-	requestLatencyPercentiles := requestLatency.Percentiles([]float64{0.5, 0.75, 0.95, 0.99, 0.999})
-	outgoingByteRate := outgoingByteRateMetric.(metrics.Meter).Snapshot()
-	requestsInFlight := requestsInFlightMetric.(metrics.Counter).Count()
-	fmt.Fprintf(w, "%d records sent, %.1f records/sec (%.2f MiB/sec ingress, %.2f MiB/sec egress), "+
-		"%.1f ms avg latency, %.1f ms stddev, %.1f ms 50th, %.1f ms 75th, "+
-		"%.1f ms 95th, %.1f ms 99th, %.1f ms 99.9th, %d total req. in flight\n",
-		recordSendRate.Count(),
-		recordSendRate.RateMean(),
-		recordSendRate.RateMean()*float64(*messageSize)/1024/1024,
-		outgoingByteRate.RateMean()/1024/1024,
-		requestLatency.Mean(),
-		requestLatency.StdDev(),
-		requestLatencyPercentiles[0],
-		requestLatencyPercentiles[1],
-		requestLatencyPercentiles[2],
-		requestLatencyPercentiles[3],
-		requestLatencyPercentiles[4],
-		requestsInFlight,
-	)
+	if r.transactor != nil {
+		r.transactor.Finish()
+	}
+	if err := producer.Close(); err != nil {
+		printErrorAndExit(69, "Failed to close producer: %s", err)
+	}
 }
 
 func printUsageErrorAndExit(message string) {
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/IBM/sarama"
+)
+
+// maxMessageFileLine bounds the length of a single line of a -message-file.
+const maxMessageFileLine = 64 << 20
+
+// fileRecord is a single record read from a -message-file. Only the value is
+// always set; the other fields are only provided by the jsonl decoder.
+type fileRecord struct {
+	key       []byte
+	value     []byte
+	headers   []sarama.RecordHeader
+	partition *int32
+}
+
+// RecordDecoder decodes a single line of a -message-file.
+type RecordDecoder func(line []byte) (*fileRecord, error)
+
+// parseRecordDecoder returns the decoder for -message-decoder: jsonl, or one
+// of the value only decoders of parseMessageDecoder.
+func parseRecordDecoder(scheme string) RecordDecoder {
+	if scheme == "jsonl" {
+		return decodeJSONRecord
+	}
+	decode := parseMessageDecoder(scheme)
+	return func(line []byte) (*fileRecord, error) {
+		value, err := decode(line)
+		if err != nil {
+			return nil, err
+		}
+		return &fileRecord{value: value}, nil
+	}
+}
+
+// jsonRecord is the format of a line of a jsonl -message-file, e.g.
+// {"key": "k", "value": "v", "headers": {"h": "1"}, "partition": 3}.
+type jsonRecord struct {
+	Key       *string           `json:"key"`
+	Value     string            `json:"value"`
+	Headers   map[string]string `json:"headers"`
+	Partition *int32            `json:"partition"`
+}
+
+func decodeJSONRecord(line []byte) (*fileRecord, error) {
+	var j jsonRecord
+	if err := json.Unmarshal(line, &j); err != nil {
+		return nil, err
+	}
+	record := &fileRecord{value: []byte(j.Value), partition: j.Partition}
+	if j.Key != nil {
+		record.key = []byte(*j.Key)
+	}
+	keys := make([]string, 0, len(j.Headers))
+	for k := range j.Headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		record.headers = append(record.headers, sarama.RecordHeader{Key: []byte(k), Value: []byte(j.Headers[k])})
+	}
+	return record, nil
+}
+
+// expandMessageFiles returns the files matching -message-file, which is
+// either a directory, whose regular files are all used, or a glob pattern.
+func expandMessageFiles(pattern string) []string {
+	if info, err := os.Stat(pattern); err == nil && info.IsDir() {
+		entries, err := os.ReadDir(pattern)
+		if err != nil {
+			printErrorAndExit(69, "Failed to list message files: %v", err)
+		}
+		var files []string
+		for _, entry := range entries {
+			if entry.Type().IsRegular() {
+				files = append(files, filepath.Join(pattern, entry.Name()))
+			}
+		}
+		if len(files) == 0 {
+			printUsageErrorAndExit(fmt.Sprintf("-message-file directory %s holds no files", pattern))
+		}
+		return files
+	}
+
+	files, err := filepath.Glob(pattern)
+	if err != nil {
+		printUsageErrorAndExit(fmt.Sprintf("Invalid -message-file pattern %q: %v", pattern, err))
+	}
+	if len(files) == 0 {
+		printUsageErrorAndExit(fmt.Sprintf("-message-file %s matches no files", pattern))
+	}
+	sort.Strings(files)
+	return files
+}
+
+// openMessageFile opens name, transparently decompressing gzip files.
+func openMessageFile(name string) (io.Reader, io.Closer) {
+	in, err := os.Open(name)
+	if err != nil {
+		printErrorAndExit(69, "Failed to open message file: %v", err)
+	}
+	r := bufio.NewReader(in)
+	if magic, _ := r.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			printErrorAndExit(69, "Failed to decompress message file %s: %v", name, err)
+		}
+		return gz, in
+	}
+	return r, in
+}
+
+// FileMessageGenerator produces the records of Files, cycling over them
+// until messageLoad messages have been generated. The files are streamed
+// rather than loaded in memory.
+type FileMessageGenerator struct {
+	Files           []string
+	Decoder         RecordDecoder
+	KeyGenerator    KeyGenerator
+	HeaderGenerator *HeaderGenerator
+}
+
+func (g *FileMessageGenerator) Generate(topics *WeightedTopics, partition, messageLoad int) <-chan *sarama.ProducerMessage {
+	messages := makeMessageChan(messageLoad)
+	go func() {
+		log.Printf("FileMessageGenerator is generating %d messages from %d files\n", messageLoad, len(g.Files))
+		generated := 0
+		for generated < messageLoad {
+			pass := generated
+			for _, name := range g.Files {
+				generated = g.generateFile(messages, name, topics, partition, generated, messageLoad)
+			}
+			if generated == pass {
+				printErrorAndExit(69, "No records found in message files")
+			}
+		}
+		close(messages)
+	}()
+	return messages
+}
+
+// generateFile generates the records of the file name, stopping early once
+// messageLoad messages have been generated, and returns the new count of
+// generated messages.
+func (g *FileMessageGenerator) generateFile(
+	messages chan<- *sarama.ProducerMessage,
+	name string,
+	topics *WeightedTopics,
+	partition, generated, messageLoad int,
+) int {
+	r, c := openMessageFile(name)
+	defer c.Close()
+
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 0, 64*1024), maxMessageFileLine)
+	for generated < messageLoad && s.Scan() {
+		b := s.Bytes()
+		if len(b) == 0 {
+			continue
+		}
+		record, err := g.Decoder(b)
+		if err != nil {
+			printErrorAndExit(69, "Failed to decode message data in %s: %v", name, err)
+		}
+		// The scanner reuses its buffer for the next line.
+		if len(record.value) > 0 && &record.value[0] == &b[0] {
+			record.value = append([]byte(nil), record.value...)
+		}
+
+		msg := &sarama.ProducerMessage{
+			Topic:     topics.Next(),
+			Partition: int32(partition),
+			Key:       nextKey(g.KeyGenerator),
+			Value:     sarama.ByteEncoder(record.value),
+			Headers:   nextHeaders(g.HeaderGenerator),
+		}
+		if record.key != nil {
+			msg.Key = sarama.ByteEncoder(record.key)
+		}
+		if len(record.headers) > 0 {
+			headers := make([]sarama.RecordHeader, 0, len(msg.Headers)+len(record.headers))
+			msg.Headers = append(append(headers, msg.Headers...), record.headers...)
+		}
+		if record.partition != nil {
+			msg.Partition = *record.partition
+		}
+		messages <- msg
+		generated++
+	}
+	if err := s.Err(); err != nil {
+		printErrorAndExit(69, "Failed to scan message file %s: %v", name, err)
+	}
+	return generated
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"sort"
+	gosync "sync"
+	"time"
+)
+
+// PartitionStats holds the records sent to, and the errors returned for, a
+// single partition.
+type PartitionStats struct {
+	Topic         string  `json:"topic"`
+	Partition     int32   `json:"partition"`
+	Records       int64   `json:"records"`
+	RecordsPerSec float64 `json:"records_per_sec"`
+	Errors        int64   `json:"errors"`
+}
+
+type partitionCounts struct {
+	records int64
+	errors  int64
+}
+
+// PartitionTracker counts the records sent to, and the errors returned for,
+// each partition so that -per-partition can report any skew. It is safe for
+// concurrent use.
+type PartitionTracker struct {
+	lock  gosync.Mutex
+	since time.Time
+	start time.Time
+	total map[topicPartition]*partitionCounts
+	last  map[topicPartition]partitionCounts
+}
+
+// NewPartitionTracker returns an empty PartitionTracker.
+func NewPartitionTracker() *PartitionTracker {
+	now := time.Now()
+	return &PartitionTracker{
+		since: now,
+		start: now,
+		total: make(map[topicPartition]*partitionCounts),
+		last:  make(map[topicPartition]partitionCounts),
+	}
+}
+
+func (t *PartitionTracker) counts(topic string, partition int32) *partitionCounts {
+	tp := topicPartition{topic, partition}
+	c := t.total[tp]
+	if c == nil {
+		c = &partitionCounts{}
+		t.total[tp] = c
+	}
+	return c
+}
+
+// Success records a record sent to partition of topic.
+func (t *PartitionTracker) Success(topic string, partition int32) {
+	t.lock.Lock()
+	t.counts(topic, partition).records++
+	t.lock.Unlock()
+}
+
+// Failure records an error returned for partition of topic.
+func (t *PartitionTracker) Failure(topic string, partition int32) {
+	t.lock.Lock()
+	t.counts(topic, partition).errors++
+	t.lock.Unlock()
+}
+
+// Reset forgets everything recorded so far.
+func (t *PartitionTracker) Reset() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.since = time.Now()
+	t.start = t.since
+	t.total = make(map[topicPartition]*partitionCounts)
+	t.last = make(map[topicPartition]partitionCounts)
+}
+
+// Interval returns the records sent and errors returned since the previous
+// call, with the rates over that interval.
+func (t *PartitionTracker) Interval() []PartitionStats {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	now := time.Now()
+	elapsed := now.Sub(t.since).Seconds()
+	t.since = now
+
+	stats := make([]PartitionStats, 0, len(t.total))
+	for tp, c := range t.total {
+		last := t.last[tp]
+		stats = append(stats, PartitionStats{
+			Topic:         tp.topic,
+			Partition:     tp.partition,
+			Records:       c.records - last.records,
+			RecordsPerSec: float64(c.records-last.records) / elapsed,
+			Errors:        c.errors - last.errors,
+		})
+		t.last[tp] = *c
+	}
+	sortPartitionStats(stats)
+	return stats
+}
+
+// Total returns the records sent and errors returned since the start of the
+// run, with the rates over the whole run.
+func (t *PartitionTracker) Total() []PartitionStats {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	elapsed := time.Since(t.start).Seconds()
+	stats := make([]PartitionStats, 0, len(t.total))
+	for tp, c := range t.total {
+		stats = append(stats, PartitionStats{
+			Topic:         tp.topic,
+			Partition:     tp.partition,
+			Records:       c.records,
+			RecordsPerSec: float64(c.records) / elapsed,
+			Errors:        c.errors,
+		})
+	}
+	sortPartitionStats(stats)
+	return stats
+}
+
+func sortPartitionStats(stats []PartitionStats) {
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Topic != stats[j].Topic {
+			return stats[i].Topic < stats[j].Topic
+		}
+		return stats[i].Partition < stats[j].Partition
+	})
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	mathrand "math/rand"
+	"strconv"
+	"strings"
+	gosync "sync"
+	"time"
+)
+
+// SizeDistribution draws the sizes of the generated payloads. It is shared by
+// all the generating routines and must be safe for concurrent use.
+type SizeDistribution interface {
+	// Next returns the size of the next payload.
+	Next() int
+	// Mean returns the expected payload size.
+	Mean() float64
+}
+
+// parseSizeDistribution parses fixed, uniform:min-max or lognormal:mean-stddev,
+// fixed being -message-size.
+func parseSizeDistribution(spec string, size int, seed int64) SizeDistribution {
+	name, args := spec, ""
+	if i := strings.Index(spec, ":"); i >= 0 {
+		name, args = spec[:i], spec[i+1:]
+	}
+	rnd := &lockedRand{rand: newRand(seed)}
+	switch name {
+	case "fixed":
+		if args != "" {
+			printUsageErrorAndExit("-message-size-dist=fixed takes no parameters, use -message-size")
+		}
+		return fixedSize(size)
+	case "uniform":
+		lo, hi := parseSizeRange(spec, args)
+		if lo > hi {
+			printUsageErrorAndExit(fmt.Sprintf("-message-size-dist min must not be greater than max, got: %q", spec))
+		}
+		return &uniformSize{min: lo, max: hi, rand: rnd}
+	case "lognormal":
+		mean, stddev := parseSizeRange(spec, args)
+		if mean == 0 {
+			printUsageErrorAndExit(fmt.Sprintf("-message-size-dist mean must be greater than 0, got: %q", spec))
+		}
+		return newLognormalSize(float64(mean), float64(stddev), rnd)
+	default:
+		printUsageErrorAndExit(fmt.Sprintf("Unknown -message-size-dist: %s", spec))
+	}
+	panic("should not happen")
+}
+
+func parseSizeRange(spec, args string) (int, int) {
+	parts := strings.Split(args, "-")
+	if len(parts) != 2 {
+		printUsageErrorAndExit(fmt.Sprintf("-message-size-dist parameters must be two sizes separated by '-', got: %q", spec))
+	}
+	a, errA := strconv.Atoi(parts[0])
+	b, errB := strconv.Atoi(parts[1])
+	if errA != nil || errB != nil || a < 0 || b < 0 {
+		printUsageErrorAndExit(fmt.Sprintf("-message-size-dist sizes must be non-negative integers, got: %q", spec))
+	}
+	return a, b
+}
+
+// newRand returns a math/rand generator seeded with -seed, or with the current
+// time if it isn't set.
+func newRand(seed int64) *mathrand.Rand {
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return mathrand.New(mathrand.NewSource(seed))
+}
+
+// lockedRand serializes the access to a math/rand source.
+type lockedRand struct {
+	lock gosync.Mutex
+	rand *mathrand.Rand
+}
+
+func (r *lockedRand) Intn(n int) int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.rand.Intn(n)
+}
+
+func (r *lockedRand) NormFloat64() float64 {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.rand.NormFloat64()
+}
+
+type fixedSize int
+
+func (s fixedSize) Next() int     { return int(s) }
+func (s fixedSize) Mean() float64 { return float64(s) }
+
+type uniformSize struct {
+	min, max int
+	rand     *lockedRand
+}
+
+func (s *uniformSize) Next() int     { return s.min + s.rand.Intn(s.max-s.min+1) }
+func (s *uniformSize) Mean() float64 { return float64(s.min+s.max) / 2 }
+
+// lognormalSize draws sizes from the log-normal distribution with the given
+// mean and standard deviation, which models real payload sizes far better
+// than a uniform distribution.
+type lognormalSize struct {
+	mean      float64
+	mu, sigma float64
+	rand      *lockedRand
+}
+
+func newLognormalSize(mean, stddev float64, rnd *lockedRand) *lognormalSize {
+	variance := stddev * stddev
+	return &lognormalSize{
+		mean:  mean,
+		mu:    math.Log(mean * mean / math.Sqrt(variance+mean*mean)),
+		sigma: math.Sqrt(math.Log(1 + variance/(mean*mean))),
+		rand:  rnd,
+	}
+}
+
+func (s *lognormalSize) Next() int {
+	return int(math.Round(math.Exp(s.mu + s.sigma*s.rand.NormFloat64())))
+}
+
+func (s *lognormalSize) Mean() float64 { return s.mean }
+
+// fillPayload fills payload with bytes read from rnd, except for its last
+// compressibility percent which repeat the same byte so that compression
+// codecs have something to work with.
+func fillPayload(payload []byte, compressibility int, rnd io.Reader) error {
+	random := len(payload) * (100 - compressibility) / 100
+	if _, err := io.ReadFull(rnd, payload[:random]); err != nil {
+		return err
+	}
+	for i := random; i < len(payload); i++ {
+		payload[i] = 'x'
+	}
+	return nil
+}
+
+// seededReader is a fast deterministic stream of bytes (splitmix64), used in
+// place of crypto/rand with -seed so that payloads are reproducible.
+type seededReader struct {
+	state uint64
+}
+
+// newSeededReader returns the stream of bytes identified by seed and stream.
+func newSeededReader(seed int64, stream uint64) *seededReader {
+	return &seededReader{state: uint64(seed) ^ stream*0xd1b54a32d192ed03}
+}
+
+func (r *seededReader) Read(p []byte) (int, error) {
+	var b [8]byte
+	for i := 0; i < len(p); i += len(b) {
+		r.state += 0x9e3779b97f4a7c15
+		z := r.state
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		binary.LittleEndian.PutUint64(b[:], z^(z>>31))
+		copy(p[i:], b[:])
+	}
+	return len(p), nil
+}
@@ -0,0 +1,509 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	gosync "sync"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+// Latency holds the distribution of a latency histogram, in milliseconds.
+type Latency struct {
+	Avg    float64 `json:"avg_ms"`
+	StdDev float64 `json:"stddev_ms"`
+	P50    float64 `json:"p50_ms"`
+	P75    float64 `json:"p75_ms"`
+	P95    float64 `json:"p95_ms"`
+	P99    float64 `json:"p99_ms"`
+	P999   float64 `json:"p999_ms"`
+}
+
+func newLatency(h metrics.Histogram, scale float64) Latency {
+	percentiles := h.Percentiles([]float64{0.5, 0.75, 0.95, 0.99, 0.999})
+	return Latency{
+		Avg:    h.Mean() / scale,
+		StdDev: h.StdDev() / scale,
+		P50:    percentiles[0] / scale,
+		P75:    percentiles[1] / scale,
+		P95:    percentiles[2] / scale,
+		P99:    percentiles[3] / scale,
+		P999:   percentiles[4] / scale,
+	}
+}
+
+// Stats is a snapshot of the producer metrics.
+type Stats struct {
+	Records          int64   `json:"records"`
+	RecordsPerSec    float64 `json:"records_per_sec"`
+	IngressMiBPerSec float64 `json:"ingress_mib_per_sec"`
+	EgressMiBPerSec  float64 `json:"egress_mib_per_sec"`
+	RequestLatency   Latency `json:"request_latency"`
+	RequestsInFlight int64   `json:"requests_in_flight"`
+	// Runtime is only set with -runtime-stats.
+	Runtime *RuntimeStats `json:"runtime,omitempty"`
+}
+
+// metricsBaseline holds the meter counts at the end of the -warmup period,
+// since go-metrics meters can't be reset in place.
+type metricsBaseline struct {
+	lock          gosync.Mutex
+	at            time.Time
+	records       int64
+	outgoingBytes int64
+}
+
+var baseline metricsBaseline
+
+// resetMetrics excludes everything recorded so far in r from the stats:
+// histograms are cleared and meter rates are computed from this point on.
+func resetMetrics(r metrics.Registry) {
+	baseline.lock.Lock()
+	defer baseline.lock.Unlock()
+
+	r.Each(func(name string, i interface{}) {
+		if h, ok := i.(metrics.Histogram); ok {
+			h.Clear()
+		}
+	})
+	baseline.at = time.Now()
+	if m, ok := r.Get("record-send-rate").(metrics.Meter); ok {
+		baseline.records = m.Count()
+	}
+	if m, ok := r.Get("outgoing-byte-rate").(metrics.Meter); ok {
+		baseline.outgoingBytes = m.Count()
+	}
+}
+
+// meterRate returns the count and mean rate of m since the baseline, if any.
+func meterRate(m metrics.Meter, base int64) (int64, float64) {
+	if baseline.at.IsZero() {
+		return m.Count(), m.RateMean()
+	}
+	count := m.Count() - base
+	return count, float64(count) / time.Since(baseline.at).Seconds()
+}
+
+// averageMessageSize is the expected size of the generated messages, used to
+// estimate the ingress throughput.
+var averageMessageSize float64
+
+// collectStats returns a snapshot of the producer metrics held by r, or nil
+// if the producer hasn't registered them yet.
+func collectStats(r metrics.Registry) *Stats {
+	recordSendRateMetric := r.Get("record-send-rate")
+	requestLatencyMetric := r.Get("request-latency-in-ms")
+	outgoingByteRateMetric := r.Get("outgoing-byte-rate")
+	requestsInFlightMetric := r.Get("requests-in-flight")
+
+	if recordSendRateMetric == nil || requestLatencyMetric == nil || outgoingByteRateMetric == nil ||
+		requestsInFlightMetric == nil {
+		return nil
+	}
+	recordSendRate := recordSendRateMetric.(metrics.Meter).Snapshot()
+	requestLatency := requestLatencyMetric.(metrics.Histogram).Snapshot()
+	outgoingByteRate := outgoingByteRateMetric.(metrics.Meter).Snapshot()
+	requestsInFlight := requestsInFlightMetric.(metrics.Counter).Count()
+
+	baseline.lock.Lock()
+	defer baseline.lock.Unlock()
+	records, recordRate := meterRate(recordSendRate, baseline.records)
+	_, outgoingByteRateMean := meterRate(outgoingByteRate, baseline.outgoingBytes)
+	return &Stats{
+		Records:          records,
+		RecordsPerSec:    recordRate,
+		IngressMiBPerSec: recordRate * averageMessageSize / 1024 / 1024,
+		EgressMiBPerSec:  outgoingByteRateMean / 1024 / 1024,
+		RequestLatency:   newLatency(requestLatency, 1),
+		RequestsInFlight: requestsInFlight,
+	}
+}
+
+// collectTopicRecords returns the number of records sent to each of topics,
+// including those sent during the -warmup.
+func collectTopicRecords(r metrics.Registry, topics []string) map[string]int64 {
+	records := make(map[string]int64, len(topics))
+	for _, topic := range topics {
+		name := "record-send-rate-for-topic-" + strings.Replace(topic, ".", "_", -1)
+		if m, ok := r.Get(name).(metrics.Meter); ok {
+			records[topic] = m.Count()
+		}
+	}
+	return records
+}
+
+// Summary is the final record of a run.
+type Summary struct {
+	Stats
+	ElapsedSec     float64            `json:"elapsed_sec"`
+	Sent           int64              `json:"sent"`
+	Errors         int64              `json:"errors"`
+	ErrorTypes     map[string]int64   `json:"error_types,omitempty"`
+	Unacknowledged int64              `json:"unacknowledged"`
+	Interrupted    bool               `json:"interrupted"`
+	Aborted        bool               `json:"aborted"`
+	Verification   *VerificationStats `json:"verification,omitempty"`
+	Transactions   *TransactionStats  `json:"transactions,omitempty"`
+	Topics         map[string]int64   `json:"topics,omitempty"`
+	Partitions     []PartitionStats   `json:"partitions,omitempty"`
+	Chaos          *ChaosStats        `json:"chaos,omitempty"`
+	Parameters     map[string]string  `json:"parameters"`
+	Regressions    []Regression       `json:"regressions,omitempty"`
+}
+
+func newSummary(stats *Stats, elapsed time.Duration) *Summary {
+	summary := &Summary{
+		ElapsedSec: elapsed.Seconds(),
+		Parameters: make(map[string]string),
+	}
+	if stats != nil {
+		summary.Stats = *stats
+	}
+	flag.VisitAll(func(f *flag.Flag) {
+		summary.Parameters[f.Name] = f.Value.String()
+	})
+	return summary
+}
+
+// Reporter writes periodic stats and the final summary of a run.
+type Reporter interface {
+	ReportStats(stats *Stats)
+	ReportPartitions(partitions []PartitionStats)
+	ReportSummary(summary *Summary)
+}
+
+func newReporter(format string, w io.Writer) Reporter {
+	switch format {
+	case "text":
+		return &textReporter{w: w}
+	case "json":
+		return &jsonReporter{enc: json.NewEncoder(w)}
+	case "csv":
+		return &csvReporter{w: csv.NewWriter(w)}
+	default:
+		printUsageErrorAndExit(fmt.Sprintf("Unknown -output-format: %s", format))
+	}
+	panic("should not happen")
+}
+
+type textReporter struct {
+	w io.Writer
+}
+
+func (r *textReporter) ReportStats(stats *Stats) {
+	fmt.Fprintf(r.w, "%d records sent, %.1f records/sec (%.2f MiB/sec ingress, %.2f MiB/sec egress), "+
+		"%.1f ms avg latency, %.1f ms stddev, %.1f ms 50th, %.1f ms 75th, "+
+		"%.1f ms 95th, %.1f ms 99th, %.1f ms 99.9th, %d total req. in flight\n",
+		stats.Records,
+		stats.RecordsPerSec,
+		stats.IngressMiBPerSec,
+		stats.EgressMiBPerSec,
+		stats.RequestLatency.Avg,
+		stats.RequestLatency.StdDev,
+		stats.RequestLatency.P50,
+		stats.RequestLatency.P75,
+		stats.RequestLatency.P95,
+		stats.RequestLatency.P99,
+		stats.RequestLatency.P999,
+		stats.RequestsInFlight,
+	)
+	if rt := stats.Runtime; rt != nil {
+		fmt.Fprintf(r.w, "%d goroutines, %.2f MiB heap alloc, %.2f MiB heap sys, %d GCs, "+
+			"%.2f ms total GC pause, %.2f ms max GC pause, %.4f GC CPU fraction\n",
+			rt.Goroutines,
+			rt.HeapAllocMiB,
+			rt.HeapSysMiB,
+			rt.GCs,
+			rt.GCPauseMs,
+			rt.GCPauseMaxMs,
+			rt.GCCPUFraction,
+		)
+	}
+}
+
+func (r *textReporter) ReportPartitions(partitions []PartitionStats) {
+	for _, p := range partitions {
+		fmt.Fprintf(r.w, "  %s/%d: %d records sent, %.1f records/sec, %d errors\n",
+			p.Topic, p.Partition, p.Records, p.RecordsPerSec, p.Errors)
+	}
+}
+
+func (r *textReporter) ReportSummary(summary *Summary) {
+	r.ReportStats(&summary.Stats)
+	fmt.Fprintf(r.w, "%d records sent in %.1f sec, %d errors\n",
+		summary.Sent, summary.ElapsedSec, summary.Errors)
+	for _, errorType := range sortedKeys(summary.ErrorTypes) {
+		fmt.Fprintf(r.w, "  %d x %s\n", summary.ErrorTypes[errorType], errorType)
+	}
+	if summary.Interrupted {
+		fmt.Fprintf(r.w, "run interrupted, %d records not acknowledged\n", summary.Unacknowledged)
+	}
+	if summary.Aborted {
+		fmt.Fprintf(r.w, "run aborted after too many errors, %d records not acknowledged\n", summary.Unacknowledged)
+	}
+	for _, topic := range sortedKeys(summary.Topics) {
+		fmt.Fprintf(r.w, "%d records sent to %s\n", summary.Topics[topic], topic)
+	}
+	if v := summary.Verification; v != nil {
+		fmt.Fprintf(r.w, "%d/%d records verified, %d lost, %d duplicated, %d reordered, %d malformed, %d corrupted, %d consumer errors, "+
+			"%.1f ms avg e2e latency, %.1f ms stddev, %.1f ms 50th, %.1f ms 75th, "+
+			"%.1f ms 95th, %.1f ms 99th, %.1f ms 99.9th\n",
+			v.Received,
+			summary.Sent,
+			v.Lost,
+			v.Duplicates,
+			v.Reordered,
+			v.Malformed,
+			v.Corrupted,
+			v.ConsumerErrors,
+			v.Latency.Avg,
+			v.Latency.StdDev,
+			v.Latency.P50,
+			v.Latency.P75,
+			v.Latency.P95,
+			v.Latency.P99,
+			v.Latency.P999,
+		)
+	}
+	if t := summary.Transactions; t != nil {
+		fmt.Fprintf(r.w, "%d transactions committed, %.1f ms avg commit latency, %.1f ms stddev, %.1f ms 50th, "+
+			"%.1f ms 75th, %.1f ms 95th, %.1f ms 99th, %.1f ms 99.9th\n",
+			t.Commits,
+			t.CommitLatency.Avg,
+			t.CommitLatency.StdDev,
+			t.CommitLatency.P50,
+			t.CommitLatency.P75,
+			t.CommitLatency.P95,
+			t.CommitLatency.P99,
+			t.CommitLatency.P999,
+		)
+	}
+	if c := summary.Chaos; c != nil {
+		fmt.Fprintf(r.w, "%d connections closed, %d partitions recovered, %d not recovered, "+
+			"%.1f ms avg recovery, %.1f ms 50th, %.1f ms 99th, %d send pauses (%.1f sec), %d consumer pauses\n",
+			c.ConnectionKills,
+			c.Recovered,
+			c.Unrecovered,
+			c.Recovery.Avg,
+			c.Recovery.P50,
+			c.Recovery.P99,
+			c.SendPauses,
+			c.PausedSec,
+			c.ConsumerPauses,
+		)
+	}
+	r.ReportPartitions(summary.Partitions)
+	for _, regression := range summary.Regressions {
+		fmt.Fprintln(r.w, formatRegression(regression))
+	}
+}
+
+type jsonReporter struct {
+	enc *json.Encoder
+}
+
+func (r *jsonReporter) ReportStats(stats *Stats) {
+	r.encode(struct {
+		Type string `json:"type"`
+		*Stats
+	}{"stats", stats})
+}
+
+func (r *jsonReporter) ReportPartitions(partitions []PartitionStats) {
+	for i := range partitions {
+		r.encode(struct {
+			Type string `json:"type"`
+			*PartitionStats
+		}{"partition", &partitions[i]})
+	}
+}
+
+func (r *jsonReporter) ReportSummary(summary *Summary) {
+	r.encode(struct {
+		Type string `json:"type"`
+		*Summary
+	}{"summary", summary})
+}
+
+func (r *jsonReporter) encode(v interface{}) {
+	if err := r.enc.Encode(v); err != nil {
+		printErrorAndExit(69, "Failed to write report: %s", err)
+	}
+}
+
+// csvColumns are the columns written by the csv reporter. Rows leave the
+// columns that don't apply to them empty.
+var csvColumns = []string{
+	"type", "topic", "partition",
+	"records", "records_per_sec", "ingress_mib_per_sec", "egress_mib_per_sec",
+	"avg_ms", "stddev_ms", "p50_ms", "p75_ms", "p95_ms", "p99_ms", "p999_ms", "requests_in_flight",
+	"goroutines", "heap_alloc_mib", "heap_sys_mib", "gcs", "gc_pause_ms", "gc_pause_max_ms", "gc_cpu_fraction",
+	"elapsed_sec", "sent", "errors", "error_types", "unacknowledged", "interrupted", "aborted",
+	"verified", "lost", "duplicated", "reordered", "malformed", "corrupted", "consumer_errors",
+	"e2e_avg_ms", "e2e_stddev_ms", "e2e_p50_ms", "e2e_p75_ms", "e2e_p95_ms", "e2e_p99_ms", "e2e_p999_ms",
+	"transactions", "commit_avg_ms", "commit_stddev_ms", "commit_p50_ms", "commit_p75_ms", "commit_p95_ms",
+	"commit_p99_ms", "commit_p999_ms",
+	"connection_kills", "recovered", "unrecovered", "recovery_avg_ms", "recovery_stddev_ms", "recovery_p50_ms",
+	"recovery_p75_ms", "recovery_p95_ms", "recovery_p99_ms", "recovery_p999_ms", "send_pauses", "paused_sec",
+	"consumer_pauses",
+	"parameters", "regressions",
+}
+
+// csvRow maps csvColumns to their values.
+type csvRow map[string]string
+
+func (row csvRow) setLatency(prefix string, l Latency) {
+	row[prefix+"avg_ms"] = formatFloat(l.Avg)
+	row[prefix+"stddev_ms"] = formatFloat(l.StdDev)
+	row[prefix+"p50_ms"] = formatFloat(l.P50)
+	row[prefix+"p75_ms"] = formatFloat(l.P75)
+	row[prefix+"p95_ms"] = formatFloat(l.P95)
+	row[prefix+"p99_ms"] = formatFloat(l.P99)
+	row[prefix+"p999_ms"] = formatFloat(l.P999)
+}
+
+type csvReporter struct {
+	w             *csv.Writer
+	headerWritten bool
+}
+
+func (r *csvReporter) ReportStats(stats *Stats) {
+	r.write(r.statsRow("stats", stats))
+}
+
+func (r *csvReporter) ReportPartitions(partitions []PartitionStats) {
+	for _, p := range partitions {
+		r.write(r.partitionRow(p))
+	}
+}
+
+func (r *csvReporter) ReportSummary(summary *Summary) {
+	row := r.statsRow("summary", &summary.Stats)
+	row["elapsed_sec"] = formatFloat(summary.ElapsedSec)
+	row["sent"] = strconv.FormatInt(summary.Sent, 10)
+	row["errors"] = strconv.FormatInt(summary.Errors, 10)
+	row["unacknowledged"] = strconv.FormatInt(summary.Unacknowledged, 10)
+	row["interrupted"] = strconv.FormatBool(summary.Interrupted)
+	row["aborted"] = strconv.FormatBool(summary.Aborted)
+	errorTypes := make([]string, 0, len(summary.ErrorTypes))
+	for _, errorType := range sortedKeys(summary.ErrorTypes) {
+		errorTypes = append(errorTypes, fmt.Sprintf("%s=%d", errorType, summary.ErrorTypes[errorType]))
+	}
+	row["error_types"] = strings.Join(errorTypes, "; ")
+	if v := summary.Verification; v != nil {
+		row["verified"] = strconv.FormatInt(v.Received, 10)
+		row["lost"] = strconv.FormatInt(v.Lost, 10)
+		row["duplicated"] = strconv.FormatInt(v.Duplicates, 10)
+		row["reordered"] = strconv.FormatInt(v.Reordered, 10)
+		row["malformed"] = strconv.FormatInt(v.Malformed, 10)
+		row["corrupted"] = strconv.FormatInt(v.Corrupted, 10)
+		row["consumer_errors"] = strconv.FormatInt(v.ConsumerErrors, 10)
+		row.setLatency("e2e_", v.Latency)
+	}
+	if t := summary.Transactions; t != nil {
+		row["transactions"] = strconv.FormatInt(t.Commits, 10)
+		row.setLatency("commit_", t.CommitLatency)
+	}
+	if c := summary.Chaos; c != nil {
+		row["connection_kills"] = strconv.FormatInt(c.ConnectionKills, 10)
+		row["recovered"] = strconv.FormatInt(c.Recovered, 10)
+		row["unrecovered"] = strconv.FormatInt(c.Unrecovered, 10)
+		row.setLatency("recovery_", c.Recovery)
+		row["send_pauses"] = strconv.FormatInt(c.SendPauses, 10)
+		row["paused_sec"] = formatFloat(c.PausedSec)
+		row["consumer_pauses"] = strconv.FormatInt(c.ConsumerPauses, 10)
+	}
+
+	names := make([]string, 0, len(summary.Parameters))
+	for name := range summary.Parameters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parameters := make([]string, len(names))
+	for i, name := range names {
+		parameters[i] = name + "=" + summary.Parameters[name]
+	}
+	row["parameters"] = strings.Join(parameters, " ")
+	regressions := make([]string, len(summary.Regressions))
+	for i, regression := range summary.Regressions {
+		regressions[i] = formatRegression(regression)
+	}
+	row["regressions"] = strings.Join(regressions, "; ")
+	r.write(row)
+
+	for _, p := range summary.Partitions {
+		r.write(r.partitionRow(p))
+	}
+}
+
+func (r *csvReporter) statsRow(kind string, stats *Stats) csvRow {
+	row := csvRow{
+		"type":                kind,
+		"records":             strconv.FormatInt(stats.Records, 10),
+		"records_per_sec":     formatFloat(stats.RecordsPerSec),
+		"ingress_mib_per_sec": formatFloat(stats.IngressMiBPerSec),
+		"egress_mib_per_sec":  formatFloat(stats.EgressMiBPerSec),
+		"requests_in_flight":  strconv.FormatInt(stats.RequestsInFlight, 10),
+	}
+	row.setLatency("", stats.RequestLatency)
+	if rt := stats.Runtime; rt != nil {
+		row["goroutines"] = strconv.Itoa(rt.Goroutines)
+		row["heap_alloc_mib"] = formatFloat(rt.HeapAllocMiB)
+		row["heap_sys_mib"] = formatFloat(rt.HeapSysMiB)
+		row["gcs"] = strconv.FormatUint(uint64(rt.GCs), 10)
+		row["gc_pause_ms"] = formatFloat(rt.GCPauseMs)
+		row["gc_pause_max_ms"] = formatFloat(rt.GCPauseMaxMs)
+		row["gc_cpu_fraction"] = strconv.FormatFloat(rt.GCCPUFraction, 'f', 6, 64)
+	}
+	return row
+}
+
+func (r *csvReporter) partitionRow(p PartitionStats) csvRow {
+	return csvRow{
+		"type":            "partition",
+		"topic":           p.Topic,
+		"partition":       strconv.FormatInt(int64(p.Partition), 10),
+		"records":         strconv.FormatInt(p.Records, 10),
+		"records_per_sec": formatFloat(p.RecordsPerSec),
+		"errors":          strconv.FormatInt(p.Errors, 10),
+	}
+}
+
+func (r *csvReporter) write(row csvRow) {
+	if !r.headerWritten {
+		r.headerWritten = true
+		r.writeRecord(csvColumns)
+	}
+	record := make([]string, len(csvColumns))
+	for i, column := range csvColumns {
+		record[i] = row[column]
+	}
+	r.writeRecord(record)
+}
+
+func (r *csvReporter) writeRecord(record []string) {
+	if err := r.w.Write(record); err != nil {
+		printErrorAndExit(69, "Failed to write report: %s", err)
+	}
+	r.w.Flush()
+}
+
+func sortedKeys(m map[string]int64) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', 3, 64)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	gosync "sync"
+	"time"
+)
+
+// RuntimeStats is a snapshot of the Go runtime statistics of the tool, since
+// client-side CPU and GC are often the bottleneck of a run.
+type RuntimeStats struct {
+	Goroutines    int     `json:"goroutines"`
+	HeapAllocMiB  float64 `json:"heap_alloc_mib"`
+	HeapSysMiB    float64 `json:"heap_sys_mib"`
+	GCs           uint32  `json:"gcs"`
+	GCPauseMs     float64 `json:"gc_pause_ms"`
+	GCPauseMaxMs  float64 `json:"gc_pause_max_ms"`
+	GCCPUFraction float64 `json:"gc_cpu_fraction"`
+}
+
+// RuntimeSampler computes the RuntimeStats of each interval between two
+// calls to Sample.
+type RuntimeSampler struct {
+	lock   gosync.Mutex
+	lastGC uint32
+}
+
+// Sample returns the current heap and goroutines, and the garbage collections
+// that happened since the previous call.
+func (s *RuntimeSampler) Sample() *RuntimeStats {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+
+	stats := &RuntimeStats{
+		Goroutines:    runtime.NumGoroutine(),
+		HeapAllocMiB:  float64(m.HeapAlloc) / 1024 / 1024,
+		HeapSysMiB:    float64(m.HeapSys) / 1024 / 1024,
+		GCs:           m.NumGC - s.lastGC,
+		GCCPUFraction: m.GCCPUFraction,
+	}
+	// PauseNs is a circular buffer holding the most recent pauses only.
+	gcs := stats.GCs
+	if gcs > uint32(len(m.PauseNs)) {
+		gcs = uint32(len(m.PauseNs))
+	}
+	for i := uint32(0); i < gcs; i++ {
+		pause := float64(m.PauseNs[(m.NumGC-i+255)%256]) / float64(time.Millisecond)
+		stats.GCPauseMs += pause
+		if pause > stats.GCPauseMaxMs {
+			stats.GCPauseMaxMs = pause
+		}
+	}
+	s.lastGC = m.NumGC
+	return stats
+}
+
+// servePprof exposes the net/http/pprof handlers on addr.
+func servePprof(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		log.Printf("Serving pprof on http://%s/debug/pprof/\n", addr)
+		if err := server.ListenAndServe(); err != nil {
+			printErrorAndExit(69, "Failed to serve pprof: %s", err)
+		}
+	}()
+}
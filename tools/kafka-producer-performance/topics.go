@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	gosync "sync"
+)
+
+// WeightedTopics distributes the generated messages over several topics in
+// proportion to their weights, using a smooth weighted round-robin so that
+// the distribution is even over short intervals too. It is safe for
+// concurrent use.
+type WeightedTopics struct {
+	Names   []string
+	Weights []int
+
+	lock    gosync.Mutex
+	current []int
+	total   int
+}
+
+// parseTopics parses a comma separated list of topic[:weight] entries.
+func parseTopics(spec string) *WeightedTopics {
+	t := &WeightedTopics{}
+	seen := make(map[string]bool)
+	for _, entry := range strings.Split(spec, ",") {
+		name, weight := entry, 1
+		if i := strings.LastIndex(entry, ":"); i >= 0 {
+			name = entry[:i]
+			w, err := strconv.Atoi(entry[i+1:])
+			if err != nil || w < 1 {
+				printUsageErrorAndExit(fmt.Sprintf("-topics weights must be positive integers, got: %q", entry))
+			}
+			weight = w
+		}
+		if name == "" {
+			printUsageErrorAndExit(fmt.Sprintf("-topics entries must be topic[:weight], got: %q", entry))
+		}
+		if seen[name] {
+			printUsageErrorAndExit(fmt.Sprintf("-topics lists %s more than once", name))
+		}
+		seen[name] = true
+		t.Names = append(t.Names, name)
+		t.Weights = append(t.Weights, weight)
+	}
+	t.init()
+	return t
+}
+
+// singleTopic returns a WeightedTopics sending every message to topic.
+func singleTopic(topic string) *WeightedTopics {
+	t := &WeightedTopics{Names: []string{topic}, Weights: []int{1}}
+	t.init()
+	return t
+}
+
+func (t *WeightedTopics) init() {
+	t.current = make([]int, len(t.Names))
+	for _, w := range t.Weights {
+		t.total += w
+	}
+}
+
+// Next returns the topic of the next message.
+func (t *WeightedTopics) Next() string {
+	if len(t.Names) == 1 {
+		return t.Names[0]
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	best := 0
+	for i, w := range t.Weights {
+		t.current[i] += w
+		if t.current[i] > t.current[best] {
+			best = i
+		}
+	}
+	t.current[best] -= t.total
+	return t.Names[best]
+}
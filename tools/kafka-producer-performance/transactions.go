@@ -0,0 +1,103 @@
+package main
+
+import (
+	gosync "sync"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+// transactionalProducer is the subset of the sarama producers API used to
+// drive transactions.
+type transactionalProducer interface {
+	BeginTxn() error
+	CommitTxn() error
+}
+
+// Transactor wraps the messages sent by a transactional producer into
+// transactions of MessagesPerTransaction messages each.
+type Transactor struct {
+	MessagesPerTransaction int
+
+	lock          gosync.Mutex
+	producer      transactionalProducer
+	pending       int
+	commits       int64
+	commitLatency metrics.Histogram
+}
+
+// NewTransactor returns a Transactor committing every messagesPerTransaction
+// messages.
+func NewTransactor(messagesPerTransaction int) *Transactor {
+	return &Transactor{
+		MessagesPerTransaction: messagesPerTransaction,
+		commitLatency:          metrics.NewHistogram(metrics.NewUniformSample(1 << 16)),
+	}
+}
+
+// Begin starts the first transaction of producer.
+func (t *Transactor) Begin(producer transactionalProducer) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.producer = producer
+	if err := producer.BeginTxn(); err != nil {
+		printErrorAndExit(69, "Failed to begin transaction: %s", err)
+	}
+}
+
+// Sent records that a message has been handed to the producer, committing
+// the current transaction and beginning the next one when it is full.
+func (t *Transactor) Sent() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.pending++
+	if t.pending < t.MessagesPerTransaction {
+		return
+	}
+	t.commit()
+	if err := t.producer.BeginTxn(); err != nil {
+		printErrorAndExit(69, "Failed to begin transaction: %s", err)
+	}
+}
+
+// Finish commits the last transaction.
+func (t *Transactor) Finish() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.commit()
+}
+
+func (t *Transactor) commit() {
+	start := time.Now()
+	if err := t.producer.CommitTxn(); err != nil {
+		printErrorAndExit(69, "Failed to commit transaction: %s", err)
+	}
+	// The last transaction may be empty, in which case nothing was sent to
+	// the coordinator.
+	if t.pending > 0 {
+		t.commitLatency.Update(time.Since(start).Microseconds())
+		t.commits++
+	}
+	t.pending = 0
+}
+
+// TransactionStats holds the number of committed transactions and the time
+// spent committing them.
+type TransactionStats struct {
+	Commits       int64   `json:"commits"`
+	CommitLatency Latency `json:"commit_latency"`
+}
+
+// Stats returns the transactions committed so far.
+func (t *Transactor) Stats() *TransactionStats {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	return &TransactionStats{
+		Commits:       t.commits,
+		CommitLatency: newLatency(t.commitLatency.Snapshot(), 1000),
+	}
+}
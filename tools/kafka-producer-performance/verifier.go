@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	gosync "sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+
+	"github.com/IBM/sarama"
+)
+
+// verificationHeaderSize is the number of bytes reserved at the start of each
+// payload for the sequence number and send timestamp when -verify is set.
+const verificationHeaderSize = 16
+
+// Sequencer hands out the sequence numbers embedded in verified payloads.
+type Sequencer struct {
+	// Seed, if set, makes the payload following the header of each message
+	// a function of its sequence number, which the Verifier checks.
+	Seed            int64
+	Compressibility int
+
+	next uint64
+}
+
+// Stamp stamps msg with the next sequence number.
+func (s *Sequencer) Stamp(msg *sarama.ProducerMessage) {
+	seq := s.Next()
+	stampMessage(msg, seq)
+	if s.Seed != 0 {
+		payload := msg.Value.(sarama.ByteEncoder)
+		s.fillBody(payload[verificationHeaderSize:], seq)
+	}
+}
+
+// fillBody fills body with the content expected for seq.
+func (s *Sequencer) fillBody(body []byte, seq uint64) {
+	_ = fillPayload(body, s.Compressibility, newSeededReader(s.Seed, seq))
+}
+
+// Next returns the next sequence number.
+func (s *Sequencer) Next() uint64 {
+	return atomic.AddUint64(&s.next, 1) - 1
+}
+
+// Count returns the number of sequence numbers handed out so far.
+func (s *Sequencer) Count() uint64 {
+	return atomic.LoadUint64(&s.next)
+}
+
+// stampMessage replaces the value of msg with a copy whose first
+// verificationHeaderSize bytes hold seq and the current time, so that the
+// Verifier can compute the end-to-end latency once it reads it back.
+func stampMessage(msg *sarama.ProducerMessage, seq uint64) {
+	var value []byte
+	if msg.Value != nil {
+		value, _ = msg.Value.Encode()
+	}
+	size := len(value)
+	if size < verificationHeaderSize {
+		size = verificationHeaderSize
+	}
+	payload := make([]byte, size)
+	copy(payload, value)
+	binary.BigEndian.PutUint64(payload[0:8], seq)
+	binary.BigEndian.PutUint64(payload[8:16], uint64(time.Now().UnixNano()))
+	msg.Value = sarama.ByteEncoder(payload)
+}
+
+// Verifier consumes the messages produced during the run and reports the
+// end-to-end latency along with any lost, duplicated or reordered messages.
+type Verifier struct {
+	consumer   sarama.Consumer
+	partitions []sarama.PartitionConsumer
+	latency    metrics.Histogram
+	sequencer  *Sequencer
+
+	lock       gosync.Mutex
+	seen       map[uint64]struct{}
+	lastSeq    map[topicPartition]uint64
+	received   int64
+	duplicates int64
+	reordered  int64
+	malformed  int64
+	corrupted  int64
+	errors     int64
+	progress   chan struct{}
+
+	wg gosync.WaitGroup
+}
+
+type topicPartition struct {
+	topic     string
+	partition int32
+}
+
+// NewVerifier starts consuming from the newest offset of every partition of
+// topics (or only of partition, if it isn't -1). It must be created before
+// the first message is produced, and checks the payload contents when the
+// sequencer stamping the messages has a Seed.
+func NewVerifier(brokers []string, config *sarama.Config, topics []string, partition int, sequencer *Sequencer) (*Verifier, error) {
+	consumer, err := sarama.NewConsumer(brokers, config)
+	if err != nil {
+		return nil, err
+	}
+
+	v := &Verifier{
+		consumer:  consumer,
+		latency:   metrics.NewHistogram(metrics.NewUniformSample(1 << 20)),
+		sequencer: sequencer,
+		seen:      make(map[uint64]struct{}),
+		lastSeq:   make(map[topicPartition]uint64),
+		progress:  make(chan struct{}, 1),
+	}
+	for _, topic := range topics {
+		partitions := []int32{int32(partition)}
+		if partition < 0 {
+			if partitions, err = consumer.Partitions(topic); err != nil {
+				v.Close()
+				return nil, err
+			}
+		}
+		for _, p := range partitions {
+			pc, err := consumer.ConsumePartition(topic, p, sarama.OffsetNewest)
+			if err != nil {
+				v.Close()
+				return nil, err
+			}
+			v.partitions = append(v.partitions, pc)
+			v.wg.Add(2)
+			go v.consumeMessages(pc)
+			go v.consumeErrors(pc)
+		}
+	}
+	return v, nil
+}
+
+func (v *Verifier) consumeMessages(pc sarama.PartitionConsumer) {
+	defer v.wg.Done()
+	for msg := range pc.Messages() {
+		v.record(msg, time.Now())
+	}
+}
+
+func (v *Verifier) consumeErrors(pc sarama.PartitionConsumer) {
+	defer v.wg.Done()
+	for range pc.Errors() {
+		atomic.AddInt64(&v.errors, 1)
+	}
+}
+
+func (v *Verifier) record(msg *sarama.ConsumerMessage, receivedAt time.Time) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if len(msg.Value) < verificationHeaderSize {
+		v.malformed++
+		return
+	}
+	seq := binary.BigEndian.Uint64(msg.Value[0:8])
+	sentAt := time.Unix(0, int64(binary.BigEndian.Uint64(msg.Value[8:16])))
+
+	if _, ok := v.seen[seq]; ok {
+		v.duplicates++
+		return
+	}
+	v.seen[seq] = struct{}{}
+	v.received++
+	v.latency.Update(receivedAt.Sub(sentAt).Microseconds())
+	if v.sequencer != nil && v.sequencer.Seed != 0 {
+		expected := make([]byte, len(msg.Value)-verificationHeaderSize)
+		v.sequencer.fillBody(expected, seq)
+		if !bytes.Equal(expected, msg.Value[verificationHeaderSize:]) {
+			v.corrupted++
+		}
+	}
+
+	tp := topicPartition{msg.Topic, msg.Partition}
+	if last, ok := v.lastSeq[tp]; ok && seq < last {
+		v.reordered++
+	} else {
+		v.lastSeq[tp] = seq
+	}
+
+	select {
+	case v.progress <- struct{}{}:
+	default:
+	}
+}
+
+// Wait blocks until expected distinct messages have been read back, or until
+// no new message has arrived for timeout.
+func (v *Verifier) Wait(expected uint64, timeout time.Duration) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		v.lock.Lock()
+		received := uint64(v.received)
+		v.lock.Unlock()
+		if received >= expected {
+			return
+		}
+
+		select {
+		case <-v.progress:
+			if !timer.Stop() {
+				<-timer.C
+			}
+			timer.Reset(timeout)
+		case <-timer.C:
+			return
+		}
+	}
+}
+
+// ResetLatency discards the end-to-end latencies recorded so far.
+func (v *Verifier) ResetLatency() {
+	v.latency.Clear()
+}
+
+// Close stops all the partition consumers.
+func (v *Verifier) Close() {
+	for _, pc := range v.partitions {
+		pc.AsyncClose()
+	}
+	v.wg.Wait()
+	_ = v.consumer.Close()
+}
+
+// VerificationStats holds the results of the end-to-end verification.
+type VerificationStats struct {
+	Received       int64   `json:"received"`
+	Lost           int64   `json:"lost"`
+	Duplicates     int64   `json:"duplicates"`
+	Reordered      int64   `json:"reordered"`
+	Malformed      int64   `json:"malformed"`
+	Corrupted      int64   `json:"corrupted"`
+	ConsumerErrors int64   `json:"consumer_errors"`
+	Latency        Latency `json:"e2e_latency"`
+}
+
+// Stats returns the verification results, given the number of messages that
+// were sent.
+func (v *Verifier) Stats(sent uint64) *VerificationStats {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	lost := int64(sent) - v.received
+	if lost < 0 {
+		lost = 0
+	}
+	return &VerificationStats{
+		Received:       v.received,
+		Lost:           lost,
+		Duplicates:     v.duplicates,
+		Reordered:      v.reordered,
+		Malformed:      v.malformed,
+		Corrupted:      v.corrupted,
+		ConsumerErrors: atomic.LoadInt64(&v.errors),
+		Latency:        newLatency(v.latency.Snapshot(), 1000),
+	}
+}
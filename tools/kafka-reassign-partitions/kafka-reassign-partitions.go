@@ -0,0 +1,437 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/tools/tls"
+)
+
+// A command is one of the steps of the reassignment workflow.
+type command struct {
+	name  string
+	usage string
+	// flags registers the options of the command, returning the function
+	// running it once they are parsed.
+	flags func(fs *flag.FlagSet) func(admin sarama.ClusterAdmin)
+}
+
+var commands = []command{
+	{name: "generate", usage: "Compute a balanced reassignment plan of topics", flags: generateFlags},
+	{name: "execute", usage: "Start the reassignments of a plan", flags: executeFlags},
+	{name: "verify", usage: "Report, or wait for, the progress of the reassignments of a plan", flags: verifyFlags},
+}
+
+var (
+	commandFlags  *flag.FlagSet
+	brokerList    *string
+	version       *string
+	verbose       *bool
+	tlsEnabled    *bool
+	tlsSkipVerify *bool
+	tlsClientCert *string
+	tlsClientKey  *string
+
+	logger = log.New(os.Stderr, "", log.LstdFlags)
+)
+
+// commonFlags registers the flags shared by all the commands.
+func commonFlags(fs *flag.FlagSet) {
+	brokerList = fs.String("brokers", os.Getenv("KAFKA_PEERS"), "The comma separated list of brokers in the Kafka cluster. You can also set the KAFKA_PEERS environment variable")
+	version = fs.String("version", "2.4.0", "The Kafka version of the cluster, at least 2.4.0 to execute and verify reassignments")
+	verbose = fs.Bool("verbose", false, "Whether to turn on sarama logging")
+	tlsEnabled = fs.Bool("tls-enabled", false, "Whether to enable TLS")
+	tlsSkipVerify = fs.Bool("tls-skip-verify", false, "Whether skip TLS server cert verification")
+	tlsClientCert = fs.String("tls-client-cert", "", "Client cert for client authentication (use with -tls-enabled and -tls-client-key)")
+	tlsClientKey = fs.String("tls-client-key", "", "Client key for client authentication (use with tls-enabled and -tls-client-cert)")
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		printCommandsAndExit()
+	}
+	var cmd *command
+	for i := range commands {
+		if commands[i].name == os.Args[1] {
+			cmd = &commands[i]
+		}
+	}
+	if cmd == nil {
+		printCommandsAndExit()
+	}
+
+	commandFlags = flag.NewFlagSet(cmd.name, flag.ExitOnError)
+	commonFlags(commandFlags)
+	run := cmd.flags(commandFlags)
+	_ = commandFlags.Parse(os.Args[2:])
+
+	if *brokerList == "" {
+		printUsageErrorAndExit("no -brokers specified. Alternatively, set the KAFKA_PEERS environment variable")
+	}
+	if *verbose {
+		sarama.Logger = logger
+	}
+
+	config := sarama.NewConfig()
+	config.ClientID = "kafka-reassign-partitions"
+	kafkaVersion, err := sarama.ParseKafkaVersion(*version)
+	if err != nil {
+		printUsageErrorAndExit(fmt.Sprintf("Unknown -version: %s", *version))
+	}
+	config.Version = kafkaVersion
+	if *tlsEnabled {
+		tlsConfig, err := tls.NewConfig(*tlsClientCert, *tlsClientKey)
+		if err != nil {
+			printErrorAndExit(69, "Failed to create TLS config: %s", err)
+		}
+
+		config.Net.TLS.Enable = true
+		config.Net.TLS.Config = tlsConfig
+		config.Net.TLS.Config.InsecureSkipVerify = *tlsSkipVerify
+	}
+
+	admin, err := sarama.NewClusterAdmin(strings.Split(*brokerList, ","), config)
+	if err != nil {
+		printErrorAndExit(69, "Failed to create cluster admin: %s", err)
+	}
+	defer func() {
+		if err := admin.Close(); err != nil {
+			logger.Println("Failed to close cluster admin cleanly:", err)
+		}
+	}()
+
+	run(admin)
+}
+
+func generateFlags(fs *flag.FlagSet) func(admin sarama.ClusterAdmin) {
+	topics := fs.String("topic", "", "REQUIRED: the comma separated list of topics to reassign")
+	targetBrokers := fs.String("target-brokers", "", "The comma separated list of broker IDs to assign the partitions to (all the brokers by default)")
+	rackAware := fs.Bool("rack-aware", true, "Whether to spread the replicas of each partition over distinct racks")
+	minimizeMovement := fs.Bool("minimize-movement", false, "Whether to keep the current replicas that fit in a balanced assignment, rather than assigning all of them anew")
+	currentFile := fs.String("current", "", "If set, the file to write the current assignment to, to roll back the reassignment")
+	return func(admin sarama.ClusterAdmin) {
+		if *topics == "" {
+			printUsageErrorAndExit("no -topic specified")
+		}
+
+		brokers, _, err := admin.DescribeCluster()
+		if err != nil {
+			printErrorAndExit(69, "Failed to describe cluster: %s", err)
+		}
+		var ids []int32
+		racks := make(map[int32]string)
+		withRack := 0
+		for _, b := range brokers {
+			ids = append(ids, b.ID())
+			if b.Rack() != "" {
+				racks[b.ID()] = b.Rack()
+				withRack++
+			}
+		}
+		if *targetBrokers != "" {
+			ids, err = parseIDs(*targetBrokers)
+			if err != nil {
+				printUsageErrorAndExit(fmt.Sprintf("Invalid -target-brokers: %s", err))
+			}
+			for _, id := range ids {
+				if !brokerExists(brokers, id) {
+					printErrorAndExit(69, "Broker %d is not part of the cluster", id)
+				}
+			}
+		}
+		if !*rackAware {
+			racks = nil
+		} else if withRack > 0 && withRack < len(brokers) {
+			printErrorAndExit(69, "Only some brokers have a rack, use -rack-aware=false to ignore the racks")
+		}
+
+		current := currentAssignment(admin, strings.Split(*topics, ","))
+		if *currentFile != "" {
+			writePlan(*currentFile, current)
+		}
+		planned, err := newPlanner(ids, racks, *minimizeMovement).plan(current)
+		if err != nil {
+			printErrorAndExit(69, "Failed to compute the reassignment plan: %s", err)
+		}
+
+		moved := 0
+		for i := range planned {
+			for _, id := range planned[i].Replicas {
+				if !contains(current[i].Replicas, id) {
+					moved++
+				}
+			}
+		}
+		logger.Printf("The plan moves %d replicas of %d partitions\n", moved, len(planned))
+		printJSON(reassignmentPlan{Version: 1, Partitions: planned})
+	}
+}
+
+func executeFlags(fs *flag.FlagSet) func(admin sarama.ClusterAdmin) {
+	planFile := fs.String("plan", "", "REQUIRED: the file of the reassignment plan, as written by generate")
+	return func(admin sarama.ClusterAdmin) {
+		if *planFile == "" {
+			printUsageErrorAndExit("no -plan specified")
+		}
+		plan := readPlan(*planFile)
+
+		byTopic := planByTopic(plan)
+		for _, topic := range sortedTopics(byTopic) {
+			current := currentAssignment(admin, []string{topic})
+			status, err := admin.ListPartitionReassignments(topic, partitionIDs(current))
+			if err != nil {
+				printErrorAndExit(69, "Failed to list the reassignments of %s: %s", topic, err)
+			}
+			if len(status[topic]) > 0 {
+				printErrorAndExit(69, "Topic %s already has reassignments in progress", topic)
+			}
+
+			// Reassigning a partition to its current replicas does not move
+			// anything, so the whole topic can be sent at once.
+			assignment := make([][]int32, len(current))
+			changed := 0
+			for _, a := range current {
+				if int(a.Partition) >= len(assignment) {
+					printErrorAndExit(69, "Topic %s has non-contiguous partitions", topic)
+				}
+				assignment[a.Partition] = a.Replicas
+			}
+			for _, a := range byTopic[topic] {
+				if int(a.Partition) >= len(assignment) || a.Partition < 0 {
+					printErrorAndExit(69, "Topic %s has no partition %d", topic, a.Partition)
+				}
+				if !sameReplicas(assignment[a.Partition], a.Replicas) {
+					assignment[a.Partition] = a.Replicas
+					changed++
+				}
+			}
+			if changed == 0 {
+				fmt.Printf("%s: already assigned\n", topic)
+				continue
+			}
+			if err := admin.AlterPartitionReassignments(topic, assignment); err != nil {
+				printErrorAndExit(69, "Failed to reassign %s: %s", topic, err)
+			}
+			fmt.Printf("%s: started the reassignment of %d partitions\n", topic, changed)
+		}
+	}
+}
+
+func verifyFlags(fs *flag.FlagSet) func(admin sarama.ClusterAdmin) {
+	planFile := fs.String("plan", "", "REQUIRED: the file of the reassignment plan, as written by generate")
+	wait := fs.Bool("wait", false, "Whether to wait for the reassignments to complete, reporting their progress")
+	interval := fs.Duration("interval", 10*time.Second, "The interval between two checks of the progress (with -wait)")
+	return func(admin sarama.ClusterAdmin) {
+		if *planFile == "" {
+			printUsageErrorAndExit("no -plan specified")
+		}
+		if *interval <= 0 {
+			printUsageErrorAndExit("-interval must be greater than 0")
+		}
+		byTopic := planByTopic(readPlan(*planFile))
+
+		for {
+			inProgress, mismatched := verify(admin, byTopic)
+			if inProgress == 0 {
+				if mismatched > 0 {
+					printErrorAndExit(69, "%d partitions are not assigned as planned", mismatched)
+				}
+				fmt.Println("All the reassignments are complete")
+				return
+			}
+			if !*wait {
+				os.Exit(1)
+			}
+			time.Sleep(*interval)
+		}
+	}
+}
+
+// verify prints the state of each partition of plan, returning how many
+// are still being reassigned, and how many are not assigned as planned
+// without being reassigned.
+func verify(admin sarama.ClusterAdmin, plan map[string][]partitionAssignment) (inProgress, mismatched int) {
+	for _, topic := range sortedTopics(plan) {
+		status, err := admin.ListPartitionReassignments(topic, partitionIDs(plan[topic]))
+		if err != nil {
+			printErrorAndExit(69, "Failed to list the reassignments of %s: %s", topic, err)
+		}
+		current := make(map[int32][]int32)
+		for _, a := range currentAssignment(admin, []string{topic}) {
+			current[a.Partition] = a.Replicas
+		}
+
+		for _, a := range plan[topic] {
+			if s, ok := status[topic][a.Partition]; ok {
+				inProgress++
+				fmt.Printf("%s-%d: in progress, adding %s, removing %s\n",
+					topic, a.Partition, formatIDs(s.AddingReplicas), formatIDs(s.RemovingReplicas))
+			} else if sameReplicas(current[a.Partition], a.Replicas) {
+				fmt.Printf("%s-%d: complete\n", topic, a.Partition)
+			} else {
+				mismatched++
+				fmt.Printf("%s-%d: not in progress, but assigned to %s instead of %s\n",
+					topic, a.Partition, formatIDs(current[a.Partition]), formatIDs(a.Replicas))
+			}
+		}
+	}
+	return inProgress, mismatched
+}
+
+// currentAssignment returns the replicas of the partitions of topics, sorted
+// by topic and partition.
+func currentAssignment(admin sarama.ClusterAdmin, topics []string) []partitionAssignment {
+	metadata, err := admin.DescribeTopics(topics)
+	if err != nil {
+		printErrorAndExit(69, "Failed to describe topics: %s", err)
+	}
+	var assignments []partitionAssignment
+	for _, topic := range metadata {
+		if topic.Err != sarama.ErrNoError {
+			printErrorAndExit(69, "Failed to describe topic %s: %s", topic.Name, topic.Err)
+		}
+		for _, p := range topic.Partitions {
+			assignments = append(assignments, partitionAssignment{
+				Topic:     topic.Name,
+				Partition: p.ID,
+				Replicas:  append([]int32(nil), p.Replicas...),
+			})
+		}
+	}
+	sort.Slice(assignments, func(i, j int) bool {
+		if assignments[i].Topic != assignments[j].Topic {
+			return assignments[i].Topic < assignments[j].Topic
+		}
+		return assignments[i].Partition < assignments[j].Partition
+	})
+	return assignments
+}
+
+func planByTopic(plan *reassignmentPlan) map[string][]partitionAssignment {
+	byTopic := make(map[string][]partitionAssignment)
+	for _, a := range plan.Partitions {
+		byTopic[a.Topic] = append(byTopic[a.Topic], a)
+	}
+	return byTopic
+}
+
+func sortedTopics(byTopic map[string][]partitionAssignment) []string {
+	topics := make([]string, 0, len(byTopic))
+	for topic := range byTopic {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	return topics
+}
+
+func partitionIDs(assignments []partitionAssignment) []int32 {
+	ids := make([]int32, len(assignments))
+	for i, a := range assignments {
+		ids[i] = a.Partition
+	}
+	return ids
+}
+
+func readPlan(path string) *reassignmentPlan {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		printErrorAndExit(66, "Failed to read plan: %s", err)
+	}
+	var plan reassignmentPlan
+	if err := json.Unmarshal(data, &plan); err != nil {
+		printErrorAndExit(65, "Failed to parse plan %s: %s", path, err)
+	}
+	if len(plan.Partitions) == 0 {
+		printErrorAndExit(65, "Plan %s has no partitions", path)
+	}
+	for _, a := range plan.Partitions {
+		if a.Topic == "" || len(a.Replicas) == 0 {
+			printErrorAndExit(65, "Plan %s has a partition without topic or replicas", path)
+		}
+	}
+	return &plan
+}
+
+func writePlan(path string, assignments []partitionAssignment) {
+	data, err := json.MarshalIndent(reassignmentPlan{Version: 1, Partitions: assignments}, "", "  ")
+	if err != nil {
+		printErrorAndExit(69, "Failed to encode plan: %s", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		printErrorAndExit(73, "Failed to write %s: %s", path, err)
+	}
+}
+
+func brokerExists(brokers []*sarama.Broker, id int32) bool {
+	for _, b := range brokers {
+		if b.ID() == id {
+			return true
+		}
+	}
+	return false
+}
+
+func parseIDs(s string) ([]int32, error) {
+	var ids []int32
+	for _, part := range strings.Split(s, ",") {
+		id, err := strconv.ParseInt(strings.TrimSpace(part), 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		if contains(ids, int32(id)) {
+			return nil, fmt.Errorf("duplicate broker %d", id)
+		}
+		ids = append(ids, int32(id))
+	}
+	return ids, nil
+}
+
+func formatIDs(ids []int32) string {
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = fmt.Sprint(id)
+	}
+	return "[" + strings.Join(parts, ",") + "]"
+}
+
+func printJSON(v interface{}) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		printErrorAndExit(69, "Failed to write output: %s", err)
+	}
+}
+
+func printCommandsAndExit() {
+	fmt.Fprintln(os.Stderr, "Usage: kafka-reassign-partitions <command> [options]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Available commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s%s\n", cmd.name, cmd.usage)
+	}
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Run kafka-reassign-partitions <command> -help for the options of a command.")
+	os.Exit(64)
+}
+
+func printErrorAndExit(code int, format string, values ...interface{}) {
+	fmt.Fprintf(os.Stderr, "ERROR: %s\n", fmt.Sprintf(format, values...))
+	fmt.Fprintln(os.Stderr)
+	os.Exit(code)
+}
+
+func printUsageErrorAndExit(message string) {
+	fmt.Fprintln(os.Stderr, "ERROR:", message)
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintf(os.Stderr, "Available command line options of %s:\n", commandFlags.Name())
+	commandFlags.PrintDefaults()
+	os.Exit(64)
+}
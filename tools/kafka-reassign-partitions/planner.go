@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// partitionAssignment is the replicas of a partition, the first one being
+// its preferred leader. It follows the format of the reassignment files of
+// kafka-reassign-partitions.sh.
+type partitionAssignment struct {
+	Topic     string  `json:"topic"`
+	Partition int32   `json:"partition"`
+	Replicas  []int32 `json:"replicas"`
+}
+
+type reassignmentPlan struct {
+	Version    int                   `json:"version"`
+	Partitions []partitionAssignment `json:"partitions"`
+}
+
+// planner computes balanced assignments of partitions over a set of brokers:
+// each broker gets as many replicas, and as many preferred leaderships, as
+// possible, and the replicas of a partition are spread over distinct racks
+// when the brokers have some.
+type planner struct {
+	brokers []int32
+	racks   map[int32]string
+	// minimizeMovement keeps the current replicas of the partitions that
+	// fit in the balanced assignment, instead of assigning all of them anew.
+	minimizeMovement bool
+
+	replicas map[int32]int
+	leaders  map[int32]int
+}
+
+func newPlanner(brokers []int32, racks map[int32]string, minimizeMovement bool) *planner {
+	sorted := append([]int32(nil), brokers...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return &planner{
+		brokers:          sorted,
+		racks:            racks,
+		minimizeMovement: minimizeMovement,
+		replicas:         make(map[int32]int),
+		leaders:          make(map[int32]int),
+	}
+}
+
+// plan returns the new assignment of current, which must be sorted by topic
+// and partition.
+func (p *planner) plan(current []partitionAssignment) ([]partitionAssignment, error) {
+	total := 0
+	for _, a := range current {
+		if len(a.Replicas) > len(p.brokers) {
+			return nil, fmt.Errorf("%s-%d has %d replicas, but there are only %d brokers",
+				a.Topic, a.Partition, len(a.Replicas), len(p.brokers))
+		}
+		total += len(a.Replicas)
+	}
+	maxReplicas := (total + len(p.brokers) - 1) / len(p.brokers)
+	maxLeaders := (len(current) + len(p.brokers) - 1) / len(p.brokers)
+
+	planned := make([]partitionAssignment, len(current))
+	for i, a := range current {
+		planned[i] = partitionAssignment{Topic: a.Topic, Partition: a.Partition}
+	}
+
+	// Keep the current replicas first, so that they are not taken by the
+	// partitions that have to move anyway.
+	if p.minimizeMovement {
+		for i, a := range current {
+			for _, id := range a.Replicas {
+				if p.isTarget(id) && p.replicas[id] < maxReplicas && !p.usesRack(planned[i].Replicas, id) {
+					planned[i].Replicas = append(planned[i].Replicas, id)
+					p.replicas[id]++
+				}
+			}
+		}
+	}
+
+	for i, a := range current {
+		for len(planned[i].Replicas) < len(a.Replicas) {
+			id := p.leastLoaded(planned[i].Replicas)
+			planned[i].Replicas = append(planned[i].Replicas, id)
+			p.replicas[id]++
+		}
+	}
+
+	for i := range planned {
+		p.electLeader(planned[i].Replicas, maxLeaders)
+	}
+	return planned, nil
+}
+
+func (p *planner) isTarget(id int32) bool {
+	for _, b := range p.brokers {
+		if b == id {
+			return true
+		}
+	}
+	return false
+}
+
+// usesRack returns whether one of replicas is on the rack of id.
+func (p *planner) usesRack(replicas []int32, id int32) bool {
+	rack := p.racks[id]
+	for _, r := range replicas {
+		if r == id || (rack != "" && p.racks[r] == rack) {
+			return true
+		}
+	}
+	return false
+}
+
+// leastLoaded returns the broker with the fewest replicas that is not already
+// one of replicas, preferring the racks replicas are not on yet.
+func (p *planner) leastLoaded(replicas []int32) int32 {
+	best := int32(-1)
+	bestNewRack := false
+	for _, id := range p.brokers {
+		if contains(replicas, id) {
+			continue
+		}
+		newRack := !p.usesRack(replicas, id)
+		switch {
+		case best < 0,
+			newRack && !bestNewRack,
+			newRack == bestNewRack && p.replicas[id] < p.replicas[best]:
+			best, bestNewRack = id, newRack
+		}
+	}
+	return best
+}
+
+// electLeader moves the preferred leader to the front of replicas: the
+// current one if it is not leading too many partitions yet, otherwise the
+// replica leading the fewest.
+func (p *planner) electLeader(replicas []int32, maxLeaders int) {
+	if len(replicas) == 0 {
+		return
+	}
+	leader := 0
+	if p.leaders[replicas[0]] >= maxLeaders {
+		for i, id := range replicas {
+			if p.leaders[id] < p.leaders[replicas[leader]] {
+				leader = i
+			}
+		}
+	}
+	id := replicas[leader]
+	copy(replicas[1:leader+1], replicas[:leader])
+	replicas[0] = id
+	p.leaders[id]++
+}
+
+func contains(ids []int32, id int32) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
+func sameReplicas(a, b []int32) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
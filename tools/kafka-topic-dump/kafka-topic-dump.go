@@ -0,0 +1,514 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/tools/tls"
+)
+
+// A command is one of the directions of the tool.
+type command struct {
+	name  string
+	usage string
+	// flags registers the options of the command, returning the function
+	// running it once they are parsed.
+	flags func(fs *flag.FlagSet) func(config *sarama.Config)
+}
+
+var commands = []command{
+	{name: "dump", usage: "Dump a range of the messages of a topic to a file", flags: dumpFlags},
+	{name: "replay", usage: "Produce the messages of a dump to a topic", flags: replayFlags},
+}
+
+var (
+	commandFlags  *flag.FlagSet
+	brokerList    *string
+	version       *string
+	verbose       *bool
+	tlsEnabled    *bool
+	tlsSkipVerify *bool
+	tlsClientCert *string
+	tlsClientKey  *string
+
+	logger = log.New(os.Stderr, "", log.LstdFlags)
+)
+
+// maxRecordLine bounds the size of a line of a dump.
+const maxRecordLine = 64 << 20
+
+// record is a message of a dump, one per line of JSON. Keys, values and
+// headers are base64 encoded, so that any message can be dumped.
+type record struct {
+	Topic     string   `json:"topic"`
+	Partition int32    `json:"partition"`
+	Offset    int64    `json:"offset"`
+	Timestamp int64    `json:"timestamp"`
+	Key       []byte   `json:"key"`
+	Value     []byte   `json:"value"`
+	Headers   []header `json:"headers,omitempty"`
+}
+
+type header struct {
+	Key   []byte `json:"key"`
+	Value []byte `json:"value"`
+}
+
+// commonFlags registers the flags shared by all the commands.
+func commonFlags(fs *flag.FlagSet) {
+	brokerList = fs.String("brokers", os.Getenv("KAFKA_PEERS"), "The comma separated list of brokers in the Kafka cluster. You can also set the KAFKA_PEERS environment variable")
+	version = fs.String("version", "1.0.0", "The Kafka version of the cluster")
+	verbose = fs.Bool("verbose", false, "Whether to turn on sarama logging")
+	tlsEnabled = fs.Bool("tls-enabled", false, "Whether to enable TLS")
+	tlsSkipVerify = fs.Bool("tls-skip-verify", false, "Whether skip TLS server cert verification")
+	tlsClientCert = fs.String("tls-client-cert", "", "Client cert for client authentication (use with -tls-enabled and -tls-client-key)")
+	tlsClientKey = fs.String("tls-client-key", "", "Client key for client authentication (use with tls-enabled and -tls-client-cert)")
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		printCommandsAndExit()
+	}
+	var cmd *command
+	for i := range commands {
+		if commands[i].name == os.Args[1] {
+			cmd = &commands[i]
+		}
+	}
+	if cmd == nil {
+		printCommandsAndExit()
+	}
+
+	commandFlags = flag.NewFlagSet(cmd.name, flag.ExitOnError)
+	commonFlags(commandFlags)
+	run := cmd.flags(commandFlags)
+	_ = commandFlags.Parse(os.Args[2:])
+
+	if *brokerList == "" {
+		printUsageErrorAndExit("no -brokers specified. Alternatively, set the KAFKA_PEERS environment variable")
+	}
+	if *verbose {
+		sarama.Logger = logger
+	}
+
+	config := sarama.NewConfig()
+	config.ClientID = "kafka-topic-dump"
+	kafkaVersion, err := sarama.ParseKafkaVersion(*version)
+	if err != nil {
+		printUsageErrorAndExit(fmt.Sprintf("Unknown -version: %s", *version))
+	}
+	config.Version = kafkaVersion
+	if *tlsEnabled {
+		tlsConfig, err := tls.NewConfig(*tlsClientCert, *tlsClientKey)
+		if err != nil {
+			printErrorAndExit(69, "Failed to create TLS config: %s", err)
+		}
+
+		config.Net.TLS.Enable = true
+		config.Net.TLS.Config = tlsConfig
+		config.Net.TLS.Config.InsecureSkipVerify = *tlsSkipVerify
+	}
+
+	run(config)
+}
+
+func dumpFlags(fs *flag.FlagSet) func(config *sarama.Config) {
+	topic := fs.String("topic", "", "REQUIRED: the topic to dump")
+	partitions := fs.String("partitions", "all", "The partitions to dump, as a comma separated list or `all`")
+	startOffset := fs.String("start-offset", "oldest", "The first offset to dump: `oldest`, `newest` or an offset")
+	endOffset := fs.Int64("end-offset", -1, "If set, the offset to stop dumping each partition before")
+	fromTime := fs.String("from-time", "", "If set, dump from the first message at or after this time (RFC 3339 or milliseconds since the epoch), instead of -start-offset")
+	toTime := fs.String("to-time", "", "If set, stop dumping each partition at the first message at or after this time (RFC 3339 or milliseconds since the epoch)")
+	outputFile := fs.String("output", "-", "The file to write the dump to, gzipped if it ends with .gz, or - for stdout")
+	idleTimeout := fs.Duration("idle-timeout", 10*time.Second, "How long to wait for the next message of a partition before considering it dumped, when the last offsets of the range are compacted or transaction markers")
+	return func(config *sarama.Config) {
+		if *topic == "" {
+			printUsageErrorAndExit("no -topic specified")
+		}
+		var from, to time.Time
+		var err error
+		if *fromTime != "" {
+			if from, err = parseTime(*fromTime); err != nil {
+				printUsageErrorAndExit("-from-time should be RFC 3339 or milliseconds since the epoch")
+			}
+		}
+		if *toTime != "" {
+			if to, err = parseTime(*toTime); err != nil {
+				printUsageErrorAndExit("-to-time should be RFC 3339 or milliseconds since the epoch")
+			}
+		}
+
+		client, err := sarama.NewClient(strings.Split(*brokerList, ","), config)
+		if err != nil {
+			printErrorAndExit(69, "Failed to create client: %s", err)
+		}
+		defer client.Close()
+		consumer, err := sarama.NewConsumerFromClient(client)
+		if err != nil {
+			printErrorAndExit(69, "Failed to start consumer: %s", err)
+		}
+		defer consumer.Close()
+
+		ids, err := getPartitions(client, *topic, *partitions)
+		if err != nil {
+			printErrorAndExit(69, "Failed to get the partitions of %s: %s", *topic, err)
+		}
+
+		w, closeOutput := createOutput(*outputFile)
+		enc := json.NewEncoder(w)
+
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+
+		total := 0
+		for _, partition := range ids {
+			start, end := dumpRange(client, *topic, partition, *startOffset, *endOffset, from, to)
+			n, interrupted := dumpPartition(consumer, enc, *topic, partition, start, end, *idleTimeout, signals)
+			logger.Printf("Dumped %d messages of %s-%d from offset %d\n", n, *topic, partition, start)
+			total += n
+			if interrupted {
+				logger.Println("Interrupted")
+				break
+			}
+		}
+		closeOutput()
+		logger.Printf("Dumped %d messages\n", total)
+	}
+}
+
+// dumpRange returns the offsets to dump a partition from and before.
+func dumpRange(client sarama.Client, topic string, partition int32, startOffset string, endOffset int64, from, to time.Time) (int64, int64) {
+	highWaterMark, err := client.GetOffset(topic, partition, sarama.OffsetNewest)
+	if err != nil {
+		printErrorAndExit(69, "Failed to get the high watermark of %s-%d: %s", topic, partition, err)
+	}
+
+	var start int64
+	switch {
+	case !from.IsZero():
+		start = offsetForTime(client, topic, partition, from, highWaterMark)
+	case startOffset == "oldest":
+		if start, err = client.GetOffset(topic, partition, sarama.OffsetOldest); err != nil {
+			printErrorAndExit(69, "Failed to get the oldest offset of %s-%d: %s", topic, partition, err)
+		}
+	case startOffset == "newest":
+		start = highWaterMark
+	default:
+		if start, err = strconv.ParseInt(startOffset, 10, 64); err != nil || start < 0 {
+			printUsageErrorAndExit("-start-offset should be `oldest`, `newest` or an offset")
+		}
+	}
+
+	end := highWaterMark
+	if endOffset >= 0 && endOffset < end {
+		end = endOffset
+	}
+	if !to.IsZero() {
+		if offset := offsetForTime(client, topic, partition, to, highWaterMark); offset < end {
+			end = offset
+		}
+	}
+	return start, end
+}
+
+// offsetForTime returns the offset of the first message of the partition at
+// or after t, or highWaterMark if there is none.
+func offsetForTime(client sarama.Client, topic string, partition int32, t time.Time, highWaterMark int64) int64 {
+	offset, err := client.GetOffset(topic, partition, t.UnixNano()/int64(time.Millisecond))
+	if err != nil {
+		printErrorAndExit(69, "Failed to get the offset of %s-%d at %s: %s", topic, partition, t, err)
+	}
+	if offset < 0 {
+		return highWaterMark
+	}
+	return offset
+}
+
+// dumpPartition writes the messages of the partition from start and before
+// end. It returns how many it wrote, and whether it was interrupted.
+func dumpPartition(consumer sarama.Consumer, enc *json.Encoder, topic string, partition int32, start, end int64, idleTimeout time.Duration, signals chan os.Signal) (int, bool) {
+	if start >= end {
+		return 0, false
+	}
+	pc, err := consumer.ConsumePartition(topic, partition, start)
+	if err != nil {
+		printErrorAndExit(69, "Failed to consume %s-%d: %s", topic, partition, err)
+	}
+	defer pc.AsyncClose()
+
+	n := 0
+	timer := time.NewTimer(idleTimeout)
+	defer timer.Stop()
+	for {
+		select {
+		case msg := <-pc.Messages():
+			if msg.Offset >= end {
+				return n, false
+			}
+			r := record{
+				Topic:     msg.Topic,
+				Partition: msg.Partition,
+				Offset:    msg.Offset,
+				Timestamp: msg.Timestamp.UnixNano() / int64(time.Millisecond),
+				Key:       msg.Key,
+				Value:     msg.Value,
+			}
+			for _, h := range msg.Headers {
+				r.Headers = append(r.Headers, header{Key: h.Key, Value: h.Value})
+			}
+			if err := enc.Encode(r); err != nil {
+				printErrorAndExit(74, "Failed to write the dump: %s", err)
+			}
+			n++
+			if msg.Offset+1 >= end {
+				return n, false
+			}
+			if !timer.Stop() {
+				<-timer.C
+			}
+			timer.Reset(idleTimeout)
+		case err := <-pc.Errors():
+			printErrorAndExit(69, "Failed to consume %s-%d: %s", topic, partition, err)
+		case <-timer.C:
+			logger.Printf("No message of %s-%d for %s, stopping before offset %d\n", topic, partition, idleTimeout, end)
+			return n, false
+		case <-signals:
+			return n, true
+		}
+	}
+}
+
+func replayFlags(fs *flag.FlagSet) func(config *sarama.Config) {
+	inputFile := fs.String("input", "-", "The dump to replay, gzipped if it ends with .gz, or - for stdin")
+	topic := fs.String("topic", "", "The topic to produce to (the topic of each message by default)")
+	preservePartitions := fs.Bool("preserve-partitions", true, "Whether to produce each message to its original partition, rather than partitioning by key")
+	preserveTimestamps := fs.Bool("preserve-timestamps", true, "Whether to produce each message with its original timestamp, rather than the current time")
+	rate := fs.Int("rate", 0, "If set, the maximum number of messages to produce per second")
+	return func(config *sarama.Config) {
+		if *rate < 0 {
+			printUsageErrorAndExit("-rate must not be negative")
+		}
+		config.Producer.Return.Successes = true
+		config.Producer.RequiredAcks = sarama.WaitForAll
+		// Keep the order of the messages of each partition across retries.
+		config.Net.MaxOpenRequests = 1
+		if *preservePartitions {
+			config.Producer.Partitioner = sarama.NewManualPartitioner
+		} else {
+			config.Producer.Partitioner = sarama.NewHashPartitioner
+		}
+
+		r, closeInput := openInput(*inputFile)
+		defer closeInput()
+
+		producer, err := sarama.NewAsyncProducer(strings.Split(*brokerList, ","), config)
+		if err != nil {
+			printErrorAndExit(69, "Failed to open Kafka producer: %s", err)
+		}
+
+		var (
+			wg       sync.WaitGroup
+			lock     sync.Mutex
+			produced int
+			failed   int
+		)
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for range producer.Successes() {
+				lock.Lock()
+				produced++
+				lock.Unlock()
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for err := range producer.Errors() {
+				logger.Println("Failed to produce message:", err)
+				lock.Lock()
+				failed++
+				lock.Unlock()
+			}
+		}()
+
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+		var ticker *time.Ticker
+		if *rate > 0 {
+			ticker = time.NewTicker(time.Second / time.Duration(*rate))
+			defer ticker.Stop()
+		}
+
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 0, 64*1024), maxRecordLine)
+	replay:
+		for line := 1; scanner.Scan(); line++ {
+			var rec record
+			if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+				printErrorAndExit(65, "Failed to parse line %d of the dump: %s", line, err)
+			}
+			msg := &sarama.ProducerMessage{Topic: rec.Topic, Partition: rec.Partition}
+			if *topic != "" {
+				msg.Topic = *topic
+			}
+			if rec.Key != nil {
+				msg.Key = sarama.ByteEncoder(rec.Key)
+			}
+			if rec.Value != nil {
+				msg.Value = sarama.ByteEncoder(rec.Value)
+			}
+			if *preserveTimestamps {
+				msg.Timestamp = time.Unix(0, rec.Timestamp*int64(time.Millisecond))
+			}
+			for _, h := range rec.Headers {
+				msg.Headers = append(msg.Headers, sarama.RecordHeader{Key: h.Key, Value: h.Value})
+			}
+
+			if ticker != nil {
+				select {
+				case <-ticker.C:
+				case <-signals:
+					break replay
+				}
+			}
+			select {
+			case producer.Input() <- msg:
+			case <-signals:
+				break replay
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			logger.Println("Failed to read the dump:", err)
+			failed++
+		}
+
+		producer.AsyncClose()
+		wg.Wait()
+		logger.Printf("Replayed %d messages, %d failed\n", produced, failed)
+		if failed > 0 {
+			os.Exit(1)
+		}
+	}
+}
+
+func getPartitions(client sarama.Client, topic, partitions string) ([]int32, error) {
+	if partitions == "all" {
+		return client.Partitions(topic)
+	}
+
+	var ids []int32
+	for _, part := range strings.Split(partitions, ",") {
+		id, err := strconv.ParseInt(part, 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, int32(id))
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
+}
+
+// createOutput returns the writer of the dump, and the function flushing and
+// closing it.
+func createOutput(path string) (io.Writer, func()) {
+	var f *os.File
+	if path == "-" {
+		f = os.Stdout
+	} else {
+		var err error
+		if f, err = os.Create(path); err != nil {
+			printErrorAndExit(73, "Failed to create %s: %s", path, err)
+		}
+	}
+	buffered := bufio.NewWriter(f)
+	var gz *gzip.Writer
+	var w io.Writer = buffered
+	if strings.HasSuffix(path, ".gz") {
+		gz = gzip.NewWriter(buffered)
+		w = gz
+	}
+	return w, func() {
+		var errs []error
+		if gz != nil {
+			errs = append(errs, gz.Close())
+		}
+		errs = append(errs, buffered.Flush())
+		if f != os.Stdout {
+			errs = append(errs, f.Close())
+		}
+		for _, err := range errs {
+			if err != nil {
+				printErrorAndExit(74, "Failed to write the dump: %s", err)
+			}
+		}
+	}
+}
+
+// openInput returns the reader of the dump, and the function closing it.
+func openInput(path string) (io.Reader, func()) {
+	if path == "-" {
+		return os.Stdin, func() {}
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		printErrorAndExit(66, "Failed to open %s: %s", path, err)
+	}
+	if !strings.HasSuffix(path, ".gz") {
+		return f, func() { _ = f.Close() }
+	}
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		printErrorAndExit(65, "Failed to read %s: %s", path, err)
+	}
+	return gz, func() {
+		_ = gz.Close()
+		_ = f.Close()
+	}
+}
+
+func parseTime(s string) (time.Time, error) {
+	if ms, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(0, ms*int64(time.Millisecond)), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+func printCommandsAndExit() {
+	fmt.Fprintln(os.Stderr, "Usage: kafka-topic-dump <command> [options]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Available commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-8s%s\n", cmd.name, cmd.usage)
+	}
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Run kafka-topic-dump <command> -help for the options of a command.")
+	os.Exit(64)
+}
+
+func printErrorAndExit(code int, format string, values ...interface{}) {
+	fmt.Fprintf(os.Stderr, "ERROR: %s\n", fmt.Sprintf(format, values...))
+	fmt.Fprintln(os.Stderr)
+	os.Exit(code)
+}
+
+func printUsageErrorAndExit(message string) {
+	fmt.Fprintln(os.Stderr, "ERROR:", message)
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintf(os.Stderr, "Available command line options of %s:\n", commandFlags.Name())
+	commandFlags.PrintDefaults()
+	os.Exit(64)
+}
@@ -0,0 +1,513 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/tools/tls"
+)
+
+// A command is one of the subcommands of the tool.
+type command struct {
+	name  string
+	usage string
+	// flags registers the options of the command, returning the function
+	// running it once they are parsed.
+	flags func(fs *flag.FlagSet) func(admin sarama.ClusterAdmin)
+}
+
+var commands = []command{
+	{name: "list", usage: "List the topics of the cluster", flags: listFlags},
+	{name: "describe", usage: "Describe the partitions of topics", flags: describeFlags},
+	{name: "create", usage: "Create a topic", flags: createFlags},
+	{name: "alter", usage: "Increase the number of partitions of a topic", flags: alterFlags},
+	{name: "delete", usage: "Delete topics", flags: deleteFlags},
+	{name: "describe-configs", usage: "Describe the configs of a topic or broker", flags: describeConfigsFlags},
+	{name: "alter-configs", usage: "Set, delete, append to or subtract from the configs of a topic or broker", flags: alterConfigsFlags},
+}
+
+var (
+	commandFlags  *flag.FlagSet
+	brokerList    *string
+	version       *string
+	output        *string
+	verbose       *bool
+	tlsEnabled    *bool
+	tlsSkipVerify *bool
+	tlsClientCert *string
+	tlsClientKey  *string
+
+	logger = log.New(os.Stderr, "", log.LstdFlags)
+)
+
+// commonFlags registers the flags shared by all the commands.
+func commonFlags(fs *flag.FlagSet) {
+	brokerList = fs.String("brokers", os.Getenv("KAFKA_PEERS"), "The comma separated list of brokers in the Kafka cluster. You can also set the KAFKA_PEERS environment variable")
+	version = fs.String("version", "2.4.0", "The Kafka version of the cluster")
+	output = fs.String("output", "text", "The output format: `text` or `json`")
+	verbose = fs.Bool("verbose", false, "Whether to turn on sarama logging")
+	tlsEnabled = fs.Bool("tls-enabled", false, "Whether to enable TLS")
+	tlsSkipVerify = fs.Bool("tls-skip-verify", false, "Whether skip TLS server cert verification")
+	tlsClientCert = fs.String("tls-client-cert", "", "Client cert for client authentication (use with -tls-enabled and -tls-client-key)")
+	tlsClientKey = fs.String("tls-client-key", "", "Client key for client authentication (use with tls-enabled and -tls-client-cert)")
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		printCommandsAndExit()
+	}
+	var cmd *command
+	for i := range commands {
+		if commands[i].name == os.Args[1] {
+			cmd = &commands[i]
+		}
+	}
+	if cmd == nil {
+		printCommandsAndExit()
+	}
+
+	commandFlags = flag.NewFlagSet(cmd.name, flag.ExitOnError)
+	commonFlags(commandFlags)
+	run := cmd.flags(commandFlags)
+	_ = commandFlags.Parse(os.Args[2:])
+
+	if *brokerList == "" {
+		printUsageErrorAndExit("no -brokers specified. Alternatively, set the KAFKA_PEERS environment variable")
+	}
+	if *output != "text" && *output != "json" {
+		printUsageErrorAndExit("-output should be `text` or `json`")
+	}
+	if *verbose {
+		sarama.Logger = logger
+	}
+
+	config := sarama.NewConfig()
+	config.ClientID = "kafka-topics"
+	kafkaVersion, err := sarama.ParseKafkaVersion(*version)
+	if err != nil {
+		printUsageErrorAndExit(fmt.Sprintf("Unknown -version: %s", *version))
+	}
+	config.Version = kafkaVersion
+	if *tlsEnabled {
+		tlsConfig, err := tls.NewConfig(*tlsClientCert, *tlsClientKey)
+		if err != nil {
+			printErrorAndExit(69, "Failed to create TLS config: %s", err)
+		}
+
+		config.Net.TLS.Enable = true
+		config.Net.TLS.Config = tlsConfig
+		config.Net.TLS.Config.InsecureSkipVerify = *tlsSkipVerify
+	}
+
+	admin, err := sarama.NewClusterAdmin(strings.Split(*brokerList, ","), config)
+	if err != nil {
+		printErrorAndExit(69, "Failed to create cluster admin: %s", err)
+	}
+	defer func() {
+		if err := admin.Close(); err != nil {
+			logger.Println("Failed to close cluster admin cleanly:", err)
+		}
+	}()
+
+	run(admin)
+}
+
+type topicSummary struct {
+	Name              string            `json:"name"`
+	Partitions        int32             `json:"partitions"`
+	ReplicationFactor int16             `json:"replication_factor"`
+	Configs           map[string]string `json:"configs,omitempty"`
+}
+
+func listFlags(fs *flag.FlagSet) func(admin sarama.ClusterAdmin) {
+	return func(admin sarama.ClusterAdmin) {
+		topics, err := admin.ListTopics()
+		if err != nil {
+			printErrorAndExit(69, "Failed to list topics: %s", err)
+		}
+		summaries := make([]topicSummary, 0, len(topics))
+		for name, detail := range topics {
+			summary := topicSummary{
+				Name:              name,
+				Partitions:        detail.NumPartitions,
+				ReplicationFactor: detail.ReplicationFactor,
+			}
+			for key, value := range detail.ConfigEntries {
+				if summary.Configs == nil {
+					summary.Configs = make(map[string]string)
+				}
+				if value != nil {
+					summary.Configs[key] = *value
+				}
+			}
+			summaries = append(summaries, summary)
+		}
+		sort.Slice(summaries, func(i, j int) bool { return summaries[i].Name < summaries[j].Name })
+
+		if *output == "json" {
+			printJSON(summaries)
+			return
+		}
+		for _, summary := range summaries {
+			fmt.Println(summary.Name)
+		}
+	}
+}
+
+type partitionDescription struct {
+	Partition       int32   `json:"partition"`
+	Leader          int32   `json:"leader"`
+	Replicas        []int32 `json:"replicas"`
+	Isr             []int32 `json:"isr"`
+	OfflineReplicas []int32 `json:"offline_replicas,omitempty"`
+	Error           string  `json:"error,omitempty"`
+}
+
+type topicDescription struct {
+	Name       string                 `json:"name"`
+	Internal   bool                   `json:"internal"`
+	Error      string                 `json:"error,omitempty"`
+	Partitions []partitionDescription `json:"partitions"`
+}
+
+func describeFlags(fs *flag.FlagSet) func(admin sarama.ClusterAdmin) {
+	topics := fs.String("topic", "", "REQUIRED: the comma separated list of topics to describe")
+	return func(admin sarama.ClusterAdmin) {
+		if *topics == "" {
+			printUsageErrorAndExit("no -topic specified")
+		}
+		metadata, err := admin.DescribeTopics(strings.Split(*topics, ","))
+		if err != nil {
+			printErrorAndExit(69, "Failed to describe topics: %s", err)
+		}
+
+		descriptions := make([]topicDescription, 0, len(metadata))
+		for _, topic := range metadata {
+			description := topicDescription{Name: topic.Name, Internal: topic.IsInternal}
+			if topic.Err != sarama.ErrNoError {
+				description.Error = topic.Err.Error()
+			}
+			for _, p := range topic.Partitions {
+				partition := partitionDescription{
+					Partition:       p.ID,
+					Leader:          p.Leader,
+					Replicas:        p.Replicas,
+					Isr:             p.Isr,
+					OfflineReplicas: p.OfflineReplicas,
+				}
+				if p.Err != sarama.ErrNoError {
+					partition.Error = p.Err.Error()
+				}
+				description.Partitions = append(description.Partitions, partition)
+			}
+			sort.Slice(description.Partitions, func(i, j int) bool {
+				return description.Partitions[i].Partition < description.Partitions[j].Partition
+			})
+			descriptions = append(descriptions, description)
+		}
+
+		if *output == "json" {
+			printJSON(descriptions)
+			return
+		}
+		failed := false
+		for _, topic := range descriptions {
+			if topic.Error != "" {
+				fmt.Printf("Topic: %s\tError: %s\n", topic.Name, topic.Error)
+				failed = true
+				continue
+			}
+			fmt.Printf("Topic: %s\tPartitionCount: %d\tInternal: %t\n", topic.Name, len(topic.Partitions), topic.Internal)
+			for _, p := range topic.Partitions {
+				fmt.Printf("\tTopic: %s\tPartition: %d\tLeader: %d\tReplicas: %s\tIsr: %s",
+					topic.Name, p.Partition, p.Leader, formatIDs(p.Replicas), formatIDs(p.Isr))
+				if len(p.OfflineReplicas) > 0 {
+					fmt.Printf("\tOffline: %s", formatIDs(p.OfflineReplicas))
+				}
+				if p.Error != "" {
+					fmt.Printf("\tError: %s", p.Error)
+				}
+				fmt.Println()
+			}
+		}
+		if failed {
+			os.Exit(69)
+		}
+	}
+}
+
+func createFlags(fs *flag.FlagSet) func(admin sarama.ClusterAdmin) {
+	topic := fs.String("topic", "", "REQUIRED: the topic to create")
+	partitions := fs.Int("partitions", -1, "The number of partitions of the topic (-1 for the broker default)")
+	replicationFactor := fs.Int("replication-factor", -1, "The replication factor of the topic (-1 for the broker default)")
+	validateOnly := fs.Bool("validate-only", false, "Only validate the request, without creating the topic")
+	var configs keyValues
+	fs.Var(&configs, "config", "A config of the topic as key=value, can be repeated")
+	return func(admin sarama.ClusterAdmin) {
+		if *topic == "" {
+			printUsageErrorAndExit("no -topic specified")
+		}
+		detail := &sarama.TopicDetail{
+			NumPartitions:     int32(*partitions),
+			ReplicationFactor: int16(*replicationFactor),
+			ConfigEntries:     make(map[string]*string),
+		}
+		for _, kv := range configs {
+			value := kv.value
+			detail.ConfigEntries[kv.key] = &value
+		}
+		if err := admin.CreateTopic(*topic, detail, *validateOnly); err != nil {
+			printErrorAndExit(69, "Failed to create topic %s: %s", *topic, err)
+		}
+		printResult("create", *topic, *validateOnly)
+	}
+}
+
+func alterFlags(fs *flag.FlagSet) func(admin sarama.ClusterAdmin) {
+	topic := fs.String("topic", "", "REQUIRED: the topic to alter")
+	partitions := fs.Int("partitions", 0, "REQUIRED: the new number of partitions of the topic")
+	validateOnly := fs.Bool("validate-only", false, "Only validate the request, without altering the topic")
+	return func(admin sarama.ClusterAdmin) {
+		if *topic == "" {
+			printUsageErrorAndExit("no -topic specified")
+		}
+		if *partitions <= 0 {
+			printUsageErrorAndExit("-partitions must be greater than 0")
+		}
+		if err := admin.CreatePartitions(*topic, int32(*partitions), nil, *validateOnly); err != nil {
+			printErrorAndExit(69, "Failed to alter topic %s: %s", *topic, err)
+		}
+		printResult("alter", *topic, *validateOnly)
+	}
+}
+
+func deleteFlags(fs *flag.FlagSet) func(admin sarama.ClusterAdmin) {
+	topics := fs.String("topic", "", "REQUIRED: the comma separated list of topics to delete")
+	return func(admin sarama.ClusterAdmin) {
+		if *topics == "" {
+			printUsageErrorAndExit("no -topic specified")
+		}
+		for _, topic := range strings.Split(*topics, ",") {
+			if err := admin.DeleteTopic(topic); err != nil {
+				printErrorAndExit(69, "Failed to delete topic %s: %s", topic, err)
+			}
+			printResult("delete", topic, false)
+		}
+	}
+}
+
+// configResource returns the resource designated by the -topic or -broker
+// flags of the config commands.
+func configResource(topic, broker string) (sarama.ConfigResourceType, string) {
+	switch {
+	case topic != "" && broker == "":
+		return sarama.TopicResource, topic
+	case topic == "" && broker != "":
+		return sarama.BrokerResource, broker
+	default:
+		printUsageErrorAndExit("exactly one of -topic or -broker must be set")
+	}
+	panic("should not happen")
+}
+
+type configDescription struct {
+	Name      string `json:"name"`
+	Value     string `json:"value"`
+	Source    string `json:"source"`
+	Default   bool   `json:"default"`
+	ReadOnly  bool   `json:"read_only"`
+	Sensitive bool   `json:"sensitive"`
+}
+
+func describeConfigsFlags(fs *flag.FlagSet) func(admin sarama.ClusterAdmin) {
+	topic := fs.String("topic", "", "The topic to describe the configs of")
+	broker := fs.String("broker", "", "The ID of the broker to describe the configs of")
+	all := fs.Bool("all", false, "Also describe the configs left to their default value")
+	return func(admin sarama.ClusterAdmin) {
+		resourceType, name := configResource(*topic, *broker)
+		entries, err := admin.DescribeConfig(sarama.ConfigResource{Type: resourceType, Name: name})
+		if err != nil {
+			printErrorAndExit(69, "Failed to describe configs of %s: %s", name, err)
+		}
+
+		descriptions := make([]configDescription, 0, len(entries))
+		for _, entry := range entries {
+			if entry.Default && !*all {
+				continue
+			}
+			descriptions = append(descriptions, configDescription{
+				Name:      entry.Name,
+				Value:     entry.Value,
+				Source:    entry.Source.String(),
+				Default:   entry.Default,
+				ReadOnly:  entry.ReadOnly,
+				Sensitive: entry.Sensitive,
+			})
+		}
+		sort.Slice(descriptions, func(i, j int) bool { return descriptions[i].Name < descriptions[j].Name })
+
+		if *output == "json" {
+			printJSON(descriptions)
+			return
+		}
+		for _, d := range descriptions {
+			value := d.Value
+			if d.Sensitive {
+				value = "<sensitive>"
+			}
+			fmt.Printf("%s=%s\t(%s)\n", d.Name, value, d.Source)
+		}
+	}
+}
+
+func alterConfigsFlags(fs *flag.FlagSet) func(admin sarama.ClusterAdmin) {
+	topic := fs.String("topic", "", "The topic to alter the configs of")
+	broker := fs.String("broker", "", "The ID of the broker to alter the configs of")
+	validateOnly := fs.Bool("validate-only", false, "Only validate the request, without altering the configs")
+	nonIncremental := fs.Bool("non-incremental", false,
+		"Replace all the configs with the -set ones, for brokers older than 2.3.0 which don't support incremental alterations")
+	var set, appendTo, subtract keyValues
+	var del names
+	fs.Var(&set, "set", "A config to set as key=value, can be repeated")
+	fs.Var(&del, "delete", "A config to reset to its default value, can be repeated")
+	fs.Var(&appendTo, "append", "A value to append to a list config as key=value, can be repeated")
+	fs.Var(&subtract, "subtract", "A value to remove from a list config as key=value, can be repeated")
+	return func(admin sarama.ClusterAdmin) {
+		resourceType, name := configResource(*topic, *broker)
+		if len(set)+len(del)+len(appendTo)+len(subtract) == 0 {
+			printUsageErrorAndExit("at least one of -set, -delete, -append or -subtract must be set")
+		}
+
+		if *nonIncremental {
+			if len(del)+len(appendTo)+len(subtract) > 0 {
+				printUsageErrorAndExit("-non-incremental only supports -set")
+			}
+			entries := make(map[string]*string, len(set))
+			for _, kv := range set {
+				value := kv.value
+				entries[kv.key] = &value
+			}
+			if err := admin.AlterConfig(resourceType, name, entries, *validateOnly); err != nil {
+				printErrorAndExit(69, "Failed to alter configs of %s: %s", name, err)
+			}
+			printResult("alter-configs", name, *validateOnly)
+			return
+		}
+
+		entries := make(map[string]sarama.IncrementalAlterConfigsEntry)
+		add := func(kvs keyValues, op sarama.IncrementalAlterConfigsOperation) {
+			for _, kv := range kvs {
+				value := kv.value
+				entries[kv.key] = sarama.IncrementalAlterConfigsEntry{Operation: op, Value: &value}
+			}
+		}
+		add(set, sarama.IncrementalAlterConfigsOperationSet)
+		add(appendTo, sarama.IncrementalAlterConfigsOperationAppend)
+		add(subtract, sarama.IncrementalAlterConfigsOperationSubtract)
+		for _, key := range del {
+			entries[key] = sarama.IncrementalAlterConfigsEntry{Operation: sarama.IncrementalAlterConfigsOperationDelete}
+		}
+		if err := admin.IncrementalAlterConfig(resourceType, name, entries, *validateOnly); err != nil {
+			printErrorAndExit(69, "Failed to alter configs of %s: %s", name, err)
+		}
+		printResult("alter-configs", name, *validateOnly)
+	}
+}
+
+type keyValue struct {
+	key, value string
+}
+
+// keyValues is a repeatable flag of key=value pairs.
+type keyValues []keyValue
+
+func (kvs *keyValues) String() string {
+	parts := make([]string, len(*kvs))
+	for i, kv := range *kvs {
+		parts[i] = kv.key + "=" + kv.value
+	}
+	return strings.Join(parts, ",")
+}
+
+func (kvs *keyValues) Set(s string) error {
+	parts := strings.SplitN(s, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return fmt.Errorf("expected key=value, got %q", s)
+	}
+	*kvs = append(*kvs, keyValue{parts[0], parts[1]})
+	return nil
+}
+
+// names is a repeatable flag of names.
+type names []string
+
+func (n *names) String() string { return strings.Join(*n, ",") }
+
+func (n *names) Set(s string) error {
+	*n = append(*n, s)
+	return nil
+}
+
+func formatIDs(ids []int32) string {
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = fmt.Sprint(id)
+	}
+	return strings.Join(parts, ",")
+}
+
+func printResult(operation, name string, validateOnly bool) {
+	if *output == "json" {
+		printJSON(struct {
+			Operation    string `json:"operation"`
+			Name         string `json:"name"`
+			ValidateOnly bool   `json:"validate_only"`
+		}{operation, name, validateOnly})
+		return
+	}
+	if validateOnly {
+		fmt.Printf("%s %s: validated\n", operation, name)
+	} else {
+		fmt.Printf("%s %s: done\n", operation, name)
+	}
+}
+
+func printJSON(v interface{}) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		printErrorAndExit(69, "Failed to write output: %s", err)
+	}
+}
+
+func printCommandsAndExit() {
+	fmt.Fprintln(os.Stderr, "Usage: kafka-topics <command> [options]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Available commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-18s%s\n", cmd.name, cmd.usage)
+	}
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Run kafka-topics <command> -help for the options of a command.")
+	os.Exit(64)
+}
+
+func printErrorAndExit(code int, format string, values ...interface{}) {
+	fmt.Fprintf(os.Stderr, "ERROR: %s\n", fmt.Sprintf(format, values...))
+	fmt.Fprintln(os.Stderr)
+	os.Exit(code)
+}
+
+func printUsageErrorAndExit(message string) {
+	fmt.Fprintln(os.Stderr, "ERROR:", message)
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintf(os.Stderr, "Available command line options of %s:\n", commandFlags.Name())
+	commandFlags.PrintDefaults()
+	os.Exit(64)
+}
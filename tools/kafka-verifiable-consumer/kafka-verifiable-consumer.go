@@ -0,0 +1,326 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/tools/tls"
+)
+
+// The flags follow the names of the VerifiableConsumer of the Kafka system
+// tests, which also accept the -- prefix.
+var (
+	brokerList         = flag.String("bootstrap-server", os.Getenv("KAFKA_PEERS"), "REQUIRED: the comma separated list of brokers in the Kafka cluster. You can also set the KAFKA_PEERS environment variable")
+	topic              = flag.String("topic", "", "REQUIRED: the topic to consume")
+	groupID            = flag.String("group-id", "", "REQUIRED: the consumer group to join")
+	groupInstanceID    = flag.String("group-instance-id", "", "The static membership ID of the consumer, if any")
+	maxMessages        = flag.Int("max-messages", -1, "The number of messages to consume, or -1 to consume until killed")
+	sessionTimeout     = flag.Duration("session-timeout", 30*time.Second, "The consumer group session timeout")
+	enableAutocommit   = flag.Bool("enable-autocommit", false, "Whether to commit the offsets periodically rather than after each batch of messages")
+	resetPolicy        = flag.String("reset-policy", "earliest", "Where to start without a committed offset: `earliest` or `latest`")
+	assignmentStrategy = flag.String("assignment-strategy", "range", "The partition assignment strategy (range, roundrobin, sticky)")
+	version            = flag.String("version", "2.3.0", "The Kafka version of the cluster")
+	verify             = flag.Bool("verify", false, "Whether to check the sequence headers of kafka-verifiable-producer -verify, reporting the lost, duplicate and reordered messages")
+	verbose            = flag.Bool("verbose", false, "Whether to turn on sarama logging to stderr")
+	tlsEnabled         = flag.Bool("tls-enabled", false, "Whether to enable TLS")
+	tlsSkipVerify      = flag.Bool("tls-skip-verify", false, "Whether skip TLS server cert verification")
+	tlsClientCert      = flag.String("tls-client-cert", "", "Client cert for client authentication (use with -tls-enabled and -tls-client-key)")
+	tlsClientKey       = flag.String("tls-client-key", "", "Client key for client authentication (use with tls-enabled and -tls-client-cert)")
+
+	logger = log.New(os.Stderr, "", log.LstdFlags)
+)
+
+// maxBatchSize bounds the number of messages reported by a single
+// records_consumed event.
+const maxBatchSize = 500
+
+func main() {
+	flag.Parse()
+
+	if *brokerList == "" {
+		printUsageErrorAndExit("no -bootstrap-server specified. Alternatively, set the KAFKA_PEERS environment variable")
+	}
+	if *topic == "" {
+		printUsageErrorAndExit("no -topic specified")
+	}
+	if *groupID == "" {
+		printUsageErrorAndExit("no -group-id specified")
+	}
+
+	if *verbose {
+		sarama.Logger = logger
+	}
+
+	config := sarama.NewConfig()
+	config.ClientID = "kafka-verifiable-consumer"
+	kafkaVersion, err := sarama.ParseKafkaVersion(*version)
+	if err != nil {
+		printUsageErrorAndExit(fmt.Sprintf("Unknown -version: %s", *version))
+	}
+	config.Version = kafkaVersion
+	config.Consumer.Group.Session.Timeout = *sessionTimeout
+	config.Consumer.Group.InstanceId = *groupInstanceID
+	config.Consumer.Offsets.AutoCommit.Enable = *enableAutocommit
+	config.Consumer.Return.Errors = true
+	switch *resetPolicy {
+	case "earliest":
+		config.Consumer.Offsets.Initial = sarama.OffsetOldest
+	case "latest":
+		config.Consumer.Offsets.Initial = sarama.OffsetNewest
+	default:
+		printUsageErrorAndExit("-reset-policy should be `earliest` or `latest`")
+	}
+	switch *assignmentStrategy {
+	case "range":
+		config.Consumer.Group.Rebalance.GroupStrategies = []sarama.BalanceStrategy{sarama.NewBalanceStrategyRange()}
+	case "roundrobin":
+		config.Consumer.Group.Rebalance.GroupStrategies = []sarama.BalanceStrategy{sarama.NewBalanceStrategyRoundRobin()}
+	case "sticky":
+		config.Consumer.Group.Rebalance.GroupStrategies = []sarama.BalanceStrategy{sarama.NewBalanceStrategySticky()}
+	default:
+		printUsageErrorAndExit(fmt.Sprintf("Unknown -assignment-strategy: %s", *assignmentStrategy))
+	}
+	if *tlsEnabled {
+		tlsConfig, err := tls.NewConfig(*tlsClientCert, *tlsClientKey)
+		if err != nil {
+			printErrorAndExit(69, "Failed to create TLS config: %s", err)
+		}
+
+		config.Net.TLS.Enable = true
+		config.Net.TLS.Config = tlsConfig
+		config.Net.TLS.Config.InsecureSkipVerify = *tlsSkipVerify
+	}
+
+	group, err := sarama.NewConsumerGroup(strings.Split(*brokerList, ","), *groupID, config)
+	if err != nil {
+		printErrorAndExit(69, "Failed to start consumer group: %s", err)
+	}
+
+	events := &eventPrinter{enc: json.NewEncoder(os.Stdout)}
+	events.print("startup_complete", nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	handler := &handler{events: events, cancel: cancel}
+	if *verify {
+		handler.checker = sarama.NewSequenceChecker()
+	}
+
+	go func() {
+		for err := range group.Errors() {
+			logger.Println("Consumer group error:", err)
+		}
+	}()
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+		select {
+		case <-signals:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	for ctx.Err() == nil {
+		if err := group.Consume(ctx, []string{*topic}, handler); err != nil {
+			if errors.Is(err, sarama.ErrClosedConsumerGroup) {
+				break
+			}
+			logger.Println("Failed to consume:", err)
+			time.Sleep(time.Second)
+		}
+	}
+
+	if err := group.Close(); err != nil {
+		logger.Println("Failed to close consumer group cleanly:", err)
+	}
+	if handler.checker != nil {
+		stats := handler.checker.Stats()
+		events.print("tool_data", map[string]interface{}{
+			"verified":   stats.Messages,
+			"missing":    stats.Missing,
+			"duplicates": stats.Duplicates,
+			"reordered":  stats.Reordered,
+		})
+	}
+	events.print("shutdown_complete", nil)
+}
+
+type topicPartition struct {
+	Topic     string `json:"topic"`
+	Partition int32  `json:"partition"`
+}
+
+func sessionPartitions(sess sarama.ConsumerGroupSession) []topicPartition {
+	var partitions []topicPartition
+	for topic, ids := range sess.Claims() {
+		for _, id := range ids {
+			partitions = append(partitions, topicPartition{topic, id})
+		}
+	}
+	sort.Slice(partitions, func(i, j int) bool {
+		if partitions[i].Topic != partitions[j].Topic {
+			return partitions[i].Topic < partitions[j].Topic
+		}
+		return partitions[i].Partition < partitions[j].Partition
+	})
+	return partitions
+}
+
+// handler reports the assignments, consumed messages and commits of the
+// consumer group sessions as events.
+type handler struct {
+	events  *eventPrinter
+	cancel  context.CancelFunc
+	checker *sarama.SequenceChecker
+
+	lock     sync.Mutex
+	consumed int
+}
+
+func (h *handler) Setup(sess sarama.ConsumerGroupSession) error {
+	h.events.print("partitions_assigned", map[string]interface{}{"partitions": sessionPartitions(sess)})
+	return nil
+}
+
+func (h *handler) Cleanup(sess sarama.ConsumerGroupSession) error {
+	h.events.print("partitions_revoked", map[string]interface{}{"partitions": sessionPartitions(sess)})
+	return nil
+}
+
+func (h *handler) ConsumeClaim(sess sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	for msg := range claim.Messages() {
+		// Report the messages already buffered along with the first one.
+		batch := []*sarama.ConsumerMessage{msg}
+	fill:
+		for len(batch) < maxBatchSize {
+			select {
+			case next, ok := <-claim.Messages():
+				if !ok {
+					break fill
+				}
+				batch = append(batch, next)
+			default:
+				break fill
+			}
+		}
+		if !h.consume(sess, batch) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// consume reports batch, then commits it unless auto-commit is enabled. It
+// returns false once -max-messages have been consumed.
+func (h *handler) consume(sess sarama.ConsumerGroupSession, batch []*sarama.ConsumerMessage) bool {
+	h.lock.Lock()
+	remaining := len(batch)
+	if *maxMessages >= 0 && h.consumed+remaining > *maxMessages {
+		remaining = *maxMessages - h.consumed
+	}
+	h.consumed += remaining
+	done := *maxMessages >= 0 && h.consumed >= *maxMessages
+	h.lock.Unlock()
+
+	if remaining > 0 {
+		batch = batch[:remaining]
+		if h.checker != nil {
+			h.verify(batch)
+		}
+		first, last := batch[0], batch[len(batch)-1]
+		h.events.print("records_consumed", map[string]interface{}{
+			"count": len(batch),
+			"partitions": []map[string]interface{}{{
+				"topic":     first.Topic,
+				"partition": first.Partition,
+				"count":     len(batch),
+				"minOffset": first.Offset,
+				"maxOffset": last.Offset,
+			}},
+		})
+		sess.MarkMessage(last, "")
+		if !*enableAutocommit {
+			sess.Commit()
+			h.events.print("offsets_committed", map[string]interface{}{
+				"success": true,
+				"offsets": []map[string]interface{}{{
+					"topic":     last.Topic,
+					"partition": last.Partition,
+					"offset":    last.Offset + 1,
+				}},
+			})
+		}
+	}
+
+	if done {
+		h.cancel()
+	}
+	return !done
+}
+
+// verify reports the messages of batch out of sequence.
+func (h *handler) verify(batch []*sarama.ConsumerMessage) {
+	for _, msg := range batch {
+		var seqErr *sarama.SequenceError
+		if err := h.checker.Check(msg); errors.As(err, &seqErr) {
+			h.events.print("sequence_error", map[string]interface{}{
+				"kind":      seqErr.Kind.String(),
+				"topic":     seqErr.Topic,
+				"partition": seqErr.Partition,
+				"offset":    seqErr.Offset,
+				"producer":  seqErr.Producer,
+				"sequence":  seqErr.Sequence,
+				"expected":  seqErr.Expected,
+			})
+		}
+	}
+}
+
+// eventPrinter writes the JSON events expected by the Kafka system tests, one
+// per line, each with its name and timestamp.
+type eventPrinter struct {
+	lock sync.Mutex
+	enc  *json.Encoder
+}
+
+func (p *eventPrinter) print(name string, fields map[string]interface{}) {
+	event := map[string]interface{}{
+		"name":      name,
+		"timestamp": time.Now().UnixNano() / int64(time.Millisecond),
+	}
+	for k, v := range fields {
+		event[k] = v
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if err := p.enc.Encode(event); err != nil {
+		logger.Println("Failed to write event:", err)
+	}
+}
+
+func printErrorAndExit(code int, format string, values ...interface{}) {
+	fmt.Fprintf(os.Stderr, "ERROR: %s\n", fmt.Sprintf(format, values...))
+	fmt.Fprintln(os.Stderr)
+	os.Exit(code)
+}
+
+func printUsageErrorAndExit(message string) {
+	fmt.Fprintln(os.Stderr, "ERROR:", message)
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Available command line options:")
+	flag.PrintDefaults()
+	os.Exit(64)
+}
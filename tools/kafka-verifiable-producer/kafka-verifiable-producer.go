@@ -0,0 +1,266 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/tools/tls"
+)
+
+// The flags follow the names of the VerifiableProducer of the Kafka system
+// tests, which also accept the -- prefix.
+var (
+	brokerList      = flag.String("bootstrap-server", os.Getenv("KAFKA_PEERS"), "REQUIRED: the comma separated list of brokers in the Kafka cluster. You can also set the KAFKA_PEERS environment variable")
+	topic           = flag.String("topic", "", "REQUIRED: the topic to produce to")
+	maxMessages     = flag.Int("max-messages", -1, "The number of messages to produce, or -1 to produce until killed")
+	throughput      = flag.Int("throughput", -1, "The maximum number of messages to produce per second, or -1 for no limit")
+	acks            = flag.Int("acks", -1, "The acks required for each message (-1: all, 0: none, 1: leader)")
+	valuePrefix     = flag.String("value-prefix", "", "A prefix of the values, which are then prefix.N instead of N")
+	repeatingKeys   = flag.Int("repeating-keys", 0, "If set, the keys cycle from 0 to this number minus 1, otherwise messages have no key")
+	createTime      = flag.Int64("message-create-time", -1, "The create time of the first message in milliseconds since the epoch, or -1 for the current time")
+	version         = flag.String("version", "1.0.0", "The Kafka version of the cluster")
+	transactionalID = flag.String("transactional-id", "", "If set, produce in transactions of -transaction-size messages with this transactional ID")
+	transactionSize = flag.Int("transaction-size", 100, "The number of messages per transaction (with -transactional-id)")
+	verify          = flag.Bool("verify", false, "Whether to stamp the messages with sequence headers, for kafka-verifiable-consumer -verify to check")
+	verbose         = flag.Bool("verbose", false, "Whether to turn on sarama logging to stderr")
+	tlsEnabled      = flag.Bool("tls-enabled", false, "Whether to enable TLS")
+	tlsSkipVerify   = flag.Bool("tls-skip-verify", false, "Whether skip TLS server cert verification")
+	tlsClientCert   = flag.String("tls-client-cert", "", "Client cert for client authentication (use with -tls-enabled and -tls-client-key)")
+	tlsClientKey    = flag.String("tls-client-key", "", "Client key for client authentication (use with tls-enabled and -tls-client-cert)")
+
+	logger = log.New(os.Stderr, "", log.LstdFlags)
+)
+
+func main() {
+	flag.Parse()
+
+	if *brokerList == "" {
+		printUsageErrorAndExit("no -bootstrap-server specified. Alternatively, set the KAFKA_PEERS environment variable")
+	}
+	if *topic == "" {
+		printUsageErrorAndExit("no -topic specified")
+	}
+	if *transactionalID != "" && *transactionSize < 1 {
+		printUsageErrorAndExit("-transaction-size must be greater than 0")
+	}
+
+	if *verbose {
+		sarama.Logger = logger
+	}
+
+	config := sarama.NewConfig()
+	config.ClientID = "kafka-verifiable-producer"
+	config.Producer.Return.Successes = true
+	config.Producer.Partitioner = sarama.NewHashPartitioner
+	kafkaVersion, err := sarama.ParseKafkaVersion(*version)
+	if err != nil {
+		printUsageErrorAndExit(fmt.Sprintf("Unknown -version: %s", *version))
+	}
+	config.Version = kafkaVersion
+	switch *acks {
+	case -1, 0, 1:
+		config.Producer.RequiredAcks = sarama.RequiredAcks(*acks)
+	default:
+		printUsageErrorAndExit("-acks should be -1, 0 or 1")
+	}
+	if *transactionalID != "" {
+		config.Producer.Idempotent = true
+		config.Producer.RequiredAcks = sarama.WaitForAll
+		config.Producer.Transaction.ID = *transactionalID
+		config.Net.MaxOpenRequests = 1
+	}
+	config.Producer.Verification.Enable = *verify
+	if *tlsEnabled {
+		tlsConfig, err := tls.NewConfig(*tlsClientCert, *tlsClientKey)
+		if err != nil {
+			printErrorAndExit(69, "Failed to create TLS config: %s", err)
+		}
+
+		config.Net.TLS.Enable = true
+		config.Net.TLS.Config = tlsConfig
+		config.Net.TLS.Config.InsecureSkipVerify = *tlsSkipVerify
+	}
+
+	producer, err := sarama.NewAsyncProducer(strings.Split(*brokerList, ","), config)
+	if err != nil {
+		printErrorAndExit(69, "Failed to open Kafka producer: %s", err)
+	}
+
+	events := &eventPrinter{enc: json.NewEncoder(os.Stdout)}
+	events.print("startup_complete", nil)
+
+	var (
+		wg     sync.WaitGroup
+		lock   sync.Mutex
+		acked  int64
+		failed int64
+	)
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for msg := range producer.Successes() {
+			lock.Lock()
+			acked++
+			lock.Unlock()
+			events.print("producer_send_success", map[string]interface{}{
+				"topic":     msg.Topic,
+				"partition": msg.Partition,
+				"offset":    msg.Offset,
+				"key":       encoderString(msg.Key),
+				"value":     encoderString(msg.Value),
+			})
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for err := range producer.Errors() {
+			lock.Lock()
+			failed++
+			lock.Unlock()
+			events.print("producer_send_error", map[string]interface{}{
+				"exception": fmt.Sprintf("%T", err.Err),
+				"message":   err.Err.Error(),
+				"topic":     err.Msg.Topic,
+				"key":       encoderString(err.Msg.Key),
+				"value":     encoderString(err.Msg.Value),
+			})
+		}
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+
+	var ticker *time.Ticker
+	if *throughput > 0 {
+		ticker = time.NewTicker(time.Second / time.Duration(*throughput))
+		defer ticker.Stop()
+	}
+	if *transactionalID != "" {
+		if err := producer.BeginTxn(); err != nil {
+			printErrorAndExit(69, "Failed to begin transaction: %s", err)
+		}
+	}
+
+	start := time.Now()
+	sent := 0
+produce:
+	for *maxMessages < 0 || sent < *maxMessages {
+		if ticker != nil {
+			select {
+			case <-ticker.C:
+			case <-signals:
+				break produce
+			}
+		}
+		msg := &sarama.ProducerMessage{Topic: *topic, Value: sarama.StringEncoder(messageValue(sent))}
+		if *repeatingKeys > 0 {
+			msg.Key = sarama.StringEncoder(strconv.Itoa(sent % *repeatingKeys))
+		}
+		if *createTime >= 0 {
+			msg.Timestamp = time.Unix(0, (*createTime+int64(sent))*int64(time.Millisecond))
+		}
+		select {
+		case producer.Input() <- msg:
+			sent++
+		case <-signals:
+			break produce
+		}
+		if *transactionalID != "" && sent%*transactionSize == 0 {
+			commitTxn(producer)
+		}
+	}
+	if *transactionalID != "" && sent%*transactionSize != 0 {
+		commitTxn(producer)
+	}
+
+	producer.AsyncClose()
+	wg.Wait()
+
+	elapsed := time.Since(start).Seconds()
+	lock.Lock()
+	events.print("tool_data", map[string]interface{}{
+		"sent":              sent,
+		"acked":             acked,
+		"failed":            failed,
+		"target_throughput": *throughput,
+		"avg_throughput":    float64(sent) / elapsed,
+	})
+	lock.Unlock()
+	events.print("shutdown_complete", nil)
+}
+
+// messageValue returns the value of the nth message.
+func messageValue(n int) string {
+	if *valuePrefix != "" {
+		return *valuePrefix + "." + strconv.Itoa(n)
+	}
+	return strconv.Itoa(n)
+}
+
+func commitTxn(producer sarama.AsyncProducer) {
+	if err := producer.CommitTxn(); err != nil {
+		printErrorAndExit(69, "Failed to commit transaction: %s", err)
+	}
+	if err := producer.BeginTxn(); err != nil {
+		printErrorAndExit(69, "Failed to begin transaction: %s", err)
+	}
+}
+
+// encoderString returns the content of e, or nil for a nil encoder.
+func encoderString(e sarama.Encoder) interface{} {
+	if e == nil {
+		return nil
+	}
+	b, err := e.Encode()
+	if err != nil {
+		return nil
+	}
+	return string(b)
+}
+
+// eventPrinter writes the JSON events expected by the Kafka system tests, one
+// per line, each with its name and timestamp.
+type eventPrinter struct {
+	lock sync.Mutex
+	enc  *json.Encoder
+}
+
+func (p *eventPrinter) print(name string, fields map[string]interface{}) {
+	event := map[string]interface{}{
+		"name":      name,
+		"timestamp": time.Now().UnixNano() / int64(time.Millisecond),
+	}
+	for k, v := range fields {
+		event[k] = v
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if err := p.enc.Encode(event); err != nil {
+		logger.Println("Failed to write event:", err)
+	}
+}
+
+func printErrorAndExit(code int, format string, values ...interface{}) {
+	fmt.Fprintf(os.Stderr, "ERROR: %s\n", fmt.Sprintf(format, values...))
+	fmt.Fprintln(os.Stderr)
+	os.Exit(code)
+}
+
+func printUsageErrorAndExit(message string) {
+	fmt.Fprintln(os.Stderr, "ERROR:", message)
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Available command line options:")
+	flag.PrintDefaults()
+	os.Exit(64)
+}
@@ -0,0 +1,96 @@
+package sarama
+
+import (
+	"sort"
+	"strconv"
+)
+
+// TopicPartitionID identifies a partition of a topic. It is comparable, so it
+// can be used as a map key.
+//
+// It is not named TopicPartition, which is the partition count and assignment
+// of a CreatePartitionsRequest.
+type TopicPartitionID struct {
+	Topic     string
+	Partition int32
+}
+
+func (tp TopicPartitionID) String() string {
+	return tp.Topic + "/" + strconv.Itoa(int(tp.Partition))
+}
+
+// The interfaces below are implemented by the types of this package, and of
+// the mocks package, taking or returning TopicPartitionIDs. They are separate
+// from Consumer, ConsumerGroup, ConsumerGroupSession and OffsetManager so that
+// the existing implementations of those keep compiling.
+
+// TopicPartitionConsumer is implemented by the Consumer returned by
+// NewConsumer.
+type TopicPartitionConsumer interface {
+	// ConsumeTopicPartition is Consumer.ConsumePartition for the partition tp.
+	ConsumeTopicPartition(tp TopicPartitionID, offset int64) (PartitionConsumer, error)
+}
+
+// TopicPartitionPauser is implemented by the Consumer returned by NewConsumer
+// and the ConsumerGroup returned by NewConsumerGroup.
+type TopicPartitionPauser interface {
+	// PausePartitions is Pause for the given partitions.
+	PausePartitions(partitions []TopicPartitionID)
+
+	// ResumePartitions is Resume for the given partitions.
+	ResumePartitions(partitions []TopicPartitionID)
+}
+
+// ClaimedPartitionLister is implemented by the ConsumerGroupSession of the
+// ConsumerGroup returned by NewConsumerGroup.
+type ClaimedPartitionLister interface {
+	// ClaimedPartitions returns the claimed partitions, sorted by topic and
+	// partition.
+	ClaimedPartitions() []TopicPartitionID
+}
+
+// TopicPartitionManager is implemented by the OffsetManager returned by
+// NewOffsetManagerFromClient.
+type TopicPartitionManager interface {
+	// ManageTopicPartition is OffsetManager.ManagePartition for the partition
+	// tp.
+	ManageTopicPartition(tp TopicPartitionID) (PartitionOffsetManager, error)
+}
+
+// TopicPartitionIDs returns the partitions of topicPartitions, the form of
+// the partitions taken by methods such as Consumer.Pause, sorted by topic and
+// partition.
+func TopicPartitionIDs(topicPartitions map[string][]int32) []TopicPartitionID {
+	ids := make([]TopicPartitionID, 0, len(topicPartitions))
+	for topic, partitions := range topicPartitions {
+		for _, partition := range partitions {
+			ids = append(ids, TopicPartitionID{Topic: topic, Partition: partition})
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if ids[i].Topic != ids[j].Topic {
+			return ids[i].Topic < ids[j].Topic
+		}
+		return ids[i].Partition < ids[j].Partition
+	})
+	return ids
+}
+
+// TopicPartitionMap returns the partitions of ids by topic, the form of the
+// partitions taken by methods such as Consumer.Pause, with the partitions of
+// each topic sorted and without duplicates.
+func TopicPartitionMap(ids []TopicPartitionID) map[string][]int32 {
+	seen := make(map[TopicPartitionID]none, len(ids))
+	topicPartitions := make(map[string][]int32)
+	for _, id := range ids {
+		if _, ok := seen[id]; ok {
+			continue
+		}
+		seen[id] = none{}
+		topicPartitions[id.Topic] = append(topicPartitions[id.Topic], id.Partition)
+	}
+	for _, partitions := range topicPartitions {
+		sort.Slice(partitions, func(i, j int) bool { return partitions[i] < partitions[j] })
+	}
+	return topicPartitions
+}
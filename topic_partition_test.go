@@ -0,0 +1,31 @@
+package sarama
+
+import (
+	"reflect"
+	"testing"
+)
+
+var (
+	_ TopicPartitionConsumer = (*consumer)(nil)
+	_ TopicPartitionPauser   = (*consumer)(nil)
+	_ TopicPartitionPauser   = (*consumerGroup)(nil)
+	_ ClaimedPartitionLister = (*consumerGroupSession)(nil)
+	_ TopicPartitionManager  = (*offsetManager)(nil)
+)
+
+func TestTopicPartitionIDAdapters(t *testing.T) {
+	ids := TopicPartitionIDs(map[string][]int32{"b": {1, 0}, "a": {2}})
+	expected := []TopicPartitionID{{"a", 2}, {"b", 0}, {"b", 1}}
+	if !reflect.DeepEqual(ids, expected) {
+		t.Errorf("expected %v, got %v", expected, ids)
+	}
+
+	topicPartitions := TopicPartitionMap(append(ids, TopicPartitionID{"b", 0}))
+	if expected := map[string][]int32{"a": {2}, "b": {0, 1}}; !reflect.DeepEqual(topicPartitions, expected) {
+		t.Errorf("expected %v, got %v", expected, topicPartitions)
+	}
+
+	if s := (TopicPartitionID{"a", 2}).String(); s != "a/2" {
+		t.Errorf("expected a/2, got %s", s)
+	}
+}
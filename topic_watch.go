@@ -0,0 +1,225 @@
+package sarama
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+// TopicEventType is the type of change reported by a TopicEvent.
+type TopicEventType int
+
+const (
+	// TopicPartitionCountChanged reports that the number of partitions of a
+	// topic changed, as when partitions are added to it, or that the topic was
+	// not in the metadata of the client before.
+	TopicPartitionCountChanged TopicEventType = iota
+	// TopicLeaderChanged reports that a partition has a new leader, or a new
+	// leader epoch after a re-election of the same broker. A leader of -1
+	// means that the partition has no leader available.
+	TopicLeaderChanged
+	// TopicISRChanged reports that the in-sync replicas of a partition changed.
+	// It is never reported when Metadata.DropReplicaDetails is set.
+	TopicISRChanged
+)
+
+func (t TopicEventType) String() string {
+	switch t {
+	case TopicPartitionCountChanged:
+		return "PartitionCountChanged"
+	case TopicLeaderChanged:
+		return "LeaderChanged"
+	case TopicISRChanged:
+		return "ISRChanged"
+	default:
+		return "Unknown"
+	}
+}
+
+// TopicWatcher is implemented by the clients able to report the changes of the
+// metadata of a topic, as the Client returned by NewClient does. It is
+// separate from Client so that the existing implementations of Client keep
+// compiling.
+type TopicWatcher interface {
+	// WatchTopic returns a channel of the changes of the partition count, and
+	// of the leaders and in-sync replicas of the partitions of the given
+	// topic, as seen by the metadata updates of the client, until ctx is done
+	// or the client is closed, when the channel is closed. The topic is added
+	// to the topics refreshed in the background every
+	// Metadata.RefreshFrequency. The events are queued, so the channel does
+	// not need to be read promptly, and the events queued for the same change
+	// and partition are merged into one.
+	WatchTopic(ctx context.Context, topic string) (<-chan *TopicEvent, error)
+}
+
+// TopicEvent is a change of the metadata of a topic, as returned by
+// TopicWatcher.WatchTopic.
+type TopicEvent struct {
+	Type  TopicEventType
+	Topic string
+	// PreviousPartitionCount and PartitionCount are the number of partitions
+	// of the topic before and after the metadata update.
+	PreviousPartitionCount, PartitionCount int
+	// Partition is the partition whose leader or in-sync replicas changed, and
+	// Previous and Current its metadata before and after the change. They are
+	// only set for the TopicLeaderChanged and TopicISRChanged events, and
+	// Partition is -1 otherwise.
+	Partition         int32
+	Previous, Current *PartitionMetadata
+}
+
+// topicEvents returns the changes from the previous to the current partition
+// metadata of a topic. The partitions which were added are only reported by
+// the change of the partition count.
+func topicEvents(topic string, previous, current map[int32]cachedPartition) []*TopicEvent {
+	var events []*TopicEvent
+	if len(previous) != len(current) || previous == nil {
+		events = append(events, &TopicEvent{
+			Type:                   TopicPartitionCountChanged,
+			Topic:                  topic,
+			PreviousPartitionCount: len(previous),
+			PartitionCount:         len(current),
+			Partition:              -1,
+		})
+	}
+
+	ids := make([]int32, 0, len(current))
+	for id := range current {
+		if _, ok := previous[id]; ok {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	for _, id := range ids {
+		before, after := previous[id], current[id]
+		event := func(t TopicEventType) *TopicEvent {
+			return &TopicEvent{
+				Type:                   t,
+				Topic:                  topic,
+				PreviousPartitionCount: len(previous),
+				PartitionCount:         len(current),
+				Partition:              id,
+				Previous:               before.metadata(id),
+				Current:                after.metadata(id),
+			}
+		}
+		if before.leader != after.leader || before.leaderEpoch != after.leaderEpoch {
+			events = append(events, event(TopicLeaderChanged))
+		}
+		if !sameReplicas(before.isr(), after.isr()) {
+			events = append(events, event(TopicISRChanged))
+		}
+	}
+	return events
+}
+
+// sameReplicas reports whether a and b hold the same broker IDs, in any order.
+func sameReplicas(a, b []int32) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	counts := make(map[int32]int, len(a))
+	for _, id := range a {
+		counts[id]++
+	}
+	for _, id := range b {
+		if counts[id] == 0 {
+			return false
+		}
+		counts[id]--
+	}
+	return true
+}
+
+// topicWatch queues the events of a topic for a caller of WatchTopic, so
+// that slow callers never block the metadata updates. The events not read yet
+// are merged by type and partition, so that the queue is bounded by the number
+// of partitions of the topic however slow the caller is.
+type topicWatch struct {
+	topic  string
+	events chan *TopicEvent
+	wake   chan none
+	done   chan none // closed when the client is closed
+
+	lock    sync.Mutex
+	pending []*TopicEvent
+}
+
+func newTopicWatch(topic string) *topicWatch {
+	return &topicWatch{
+		topic:  topic,
+		events: make(chan *TopicEvent),
+		wake:   make(chan none, 1),
+		done:   make(chan none),
+	}
+}
+
+func (w *topicWatch) notify(events []*TopicEvent) {
+	w.lock.Lock()
+	for _, event := range events {
+		w.queue(event)
+	}
+	w.lock.Unlock()
+
+	select {
+	case w.wake <- none{}:
+	default:
+	}
+}
+
+// queue adds event to the pending events, or merges it into the pending event
+// of the same type and partition, which keeps its place in the queue and the
+// metadata from before the first of the changes. The caller must hold w.lock.
+func (w *topicWatch) queue(event *TopicEvent) {
+	for i, queued := range w.pending {
+		if queued.Type == event.Type && queued.Partition == event.Partition {
+			merged := *event
+			merged.PreviousPartitionCount = queued.PreviousPartitionCount
+			merged.Previous = queued.Previous
+			w.pending[i] = &merged
+			return
+		}
+	}
+	w.pending = append(w.pending, event)
+}
+
+// next removes and returns the first pending event, or nil if there is none.
+func (w *topicWatch) next() *TopicEvent {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if len(w.pending) == 0 {
+		return nil
+	}
+	event := w.pending[0]
+	w.pending[0] = nil
+	w.pending = w.pending[1:]
+	return event
+}
+
+// run forwards the events queued until ctx is done or the client is closed.
+func (w *topicWatch) run(ctx context.Context) {
+	defer close(w.events)
+	for {
+		// events are taken one at a time, so that the ones queued while the
+		// caller is slow can still be merged
+		if event := w.next(); event != nil {
+			select {
+			case w.events <- event:
+			case <-ctx.Done():
+				return
+			case <-w.done:
+				return
+			}
+			continue
+		}
+
+		select {
+		case <-w.wake:
+		case <-ctx.Done():
+			return
+		case <-w.done:
+			return
+		}
+	}
+}
@@ -34,6 +34,9 @@ const (
 	// ProducerTxnFlagFatalError when producer encounter an fatal error
 	// Must Close an recreate it.
 	ProducerTxnFlagFatalError
+	// ProducerTxnFlagPreparedTransaction when the transaction is prepared
+	// for a two-phase commit. Must call CompleteTxn in this case.
+	ProducerTxnFlagPreparedTransaction
 )
 
 func (s ProducerTxnStatusFlag) String() string {
@@ -68,9 +71,37 @@ func (s ProducerTxnStatusFlag) String() string {
 	if s&ProducerTxnFlagFatalError != 0 {
 		status = append(status, "ProducerTxnStateFatalError")
 	}
+	if s&ProducerTxnFlagPreparedTransaction != 0 {
+		status = append(status, "ProducerTxnStatePreparedTransaction")
+	}
 	return strings.Join(status, "|")
 }
 
+// TwoPhaseCommitter is implemented by the AsyncProducer and SyncProducer of
+// this package, and of the mocks package, able to commit their transactions
+// in two phases. It is separate from AsyncProducer and SyncProducer so that
+// their existing implementations keep compiling.
+type TwoPhaseCommitter interface {
+	// PrepareTxn prepares current transaction for a two-phase commit, with
+	// Producer.Transaction.TwoPhaseCommit: once its messages and offsets are
+	// delivered, it returns the state to store in the external transaction
+	// coordinator.
+	PrepareTxn() (PreparedTxnState, error)
+
+	// CompleteTxn commits the prepared transaction when it matches the state
+	// stored in the external transaction coordinator, and aborts it otherwise.
+	CompleteTxn(state PreparedTxnState) error
+}
+
+// PreparedTxnState identifies a transaction prepared for a two-phase commit
+// by PrepareTxn. It is to be stored by the external transaction coordinator,
+// and passed to CompleteTxn, which commits the prepared transaction when it
+// matches it and aborts it otherwise.
+type PreparedTxnState struct {
+	ProducerID    int64
+	ProducerEpoch int16
+}
+
 // transactionManager keeps the state necessary to ensure idempotent production
 type transactionManager struct {
 	producerID         int64
@@ -81,6 +112,13 @@ type transactionManager struct {
 	transactionTimeout time.Duration
 	client             Client
 
+	// when the transactions are committed in two phases (KIP-939).
+	twoPhaseCommit bool
+	// keep the prepared transaction when initializing the producer id.
+	keepPreparedTxn bool
+	// the transaction prepared for the two-phase commit, if any.
+	preparedTxn *PreparedTxnState
+
 	// when kafka cluster is at least 2.5.0.
 	// used to recover when producer failed.
 	coordinatorSupportsBumpingEpoch bool
@@ -101,6 +139,17 @@ type transactionManager struct {
 
 	// Offsets to add to transaction.
 	offsetsInCurrentTxn map[string]topicPartitionOffsets
+
+	// Ensure that state() reads the partitions and offsets of the current
+	// transaction without waiting for the coordinator requests, which hold
+	// the other locks: they are updated with stateLock held too.
+	stateLock                sync.Mutex
+	lastCoordinatorError     error
+	lastCoordinatorErrorTime time.Time
+
+	// Observe the transitions and coordinator errors, see observeTxn.
+	onTransition       func(from, to ProducerTxnStatusFlag, err error)
+	onCoordinatorError func(err error)
 }
 
 const (
@@ -116,16 +165,22 @@ var producerTxnTransitions = map[ProducerTxnStatusFlag][]ProducerTxnStatusFlag{
 	ProducerTxnFlagUninitialized: {
 		ProducerTxnFlagReady,
 		ProducerTxnFlagInError,
+		// When keeping a prepared transaction
+		ProducerTxnFlagPreparedTransaction,
 	},
 	// When we need are initializing
 	ProducerTxnFlagInitializing: {
 		ProducerTxnFlagInitializing,
 		ProducerTxnFlagReady,
 		ProducerTxnFlagInError,
+		// When keeping a prepared transaction
+		ProducerTxnFlagPreparedTransaction,
 	},
 	// When we have initialized transactional producer
 	ProducerTxnFlagReady: {
 		ProducerTxnFlagInTransaction,
+		// When refreshing the producer ID of an idle producer
+		ProducerTxnFlagInitializing,
 	},
 	// When beginTxn has been called
 	ProducerTxnFlagInTransaction: {
@@ -133,6 +188,15 @@ var producerTxnTransitions = map[ProducerTxnStatusFlag][]ProducerTxnStatusFlag{
 		ProducerTxnFlagEndTransaction,
 		// When got an error
 		ProducerTxnFlagInError,
+		// When calling PrepareTxn
+		ProducerTxnFlagPreparedTransaction,
+	},
+	// When the transaction is prepared for a two-phase commit
+	ProducerTxnFlagPreparedTransaction: {
+		// When calling CompleteTxn, commit or abort
+		ProducerTxnFlagEndTransaction,
+		// When got an error
+		ProducerTxnFlagInError,
 	},
 	ProducerTxnFlagEndTransaction: {
 		// When epoch bump
@@ -207,14 +271,15 @@ func (t *transactionManager) currentTxnStatus() ProducerTxnStatusFlag {
 // Try to transition to a valid status and return an error otherwise.
 func (t *transactionManager) transitionTo(target ProducerTxnStatusFlag, err error) error {
 	t.statusLock.Lock()
-	defer t.statusLock.Unlock()
 
 	if !t.isTransitionValid(target) {
+		t.statusLock.Unlock()
 		return ErrTransitionNotAllowed
 	}
 
 	if target&ProducerTxnFlagInError != 0 {
 		if err == nil {
+			t.statusLock.Unlock()
 			return ErrCannotTransitionNilError
 		}
 		t.lastError = err
@@ -224,17 +289,23 @@ func (t *transactionManager) transitionTo(target ProducerTxnStatusFlag, err erro
 
 	DebugLogger.Printf("txnmgr/transition [%s] transition from %s to %s\n", t.transactionalID, t.status, target)
 
+	from := t.status
 	t.status = target
+	t.statusLock.Unlock()
+
+	if t.onTransition != nil {
+		t.onTransition(from, target, err)
+	}
 	return err
 }
 
-func (t *transactionManager) getAndIncrementSequenceNumber(topic string, partition int32) (int32, int16) {
+func (t *transactionManager) getAndIncrementSequenceNumber(topic string, partition int32) (int32, int64, int16) {
 	key := fmt.Sprintf("%s-%d", topic, partition)
 	t.mutex.Lock()
 	defer t.mutex.Unlock()
 	sequence := t.sequenceNumbers[key]
 	t.sequenceNumbers[key] = sequence + 1
-	return sequence, t.producerEpoch
+	return sequence, t.producerID, t.producerEpoch
 }
 
 func (t *transactionManager) bumpEpoch() {
@@ -280,6 +351,9 @@ func (t *transactionManager) addOffsetsToTxn(offsetsToAdd map[string][]*Partitio
 		return t.lastError
 	}
 
+	t.stateLock.Lock()
+	defer t.stateLock.Unlock()
+
 	if _, ok := t.offsetsInCurrentTxn[groupId]; !ok {
 		t.offsetsInCurrentTxn[groupId] = topicPartitionOffsets{}
 	}
@@ -307,6 +381,7 @@ func (t *transactionManager) publishOffsetsToTxn(offsets topicPartitionOffsets,
 			backoff := t.computeBackoff(attemptsRemaining)
 			Logger.Printf("txnmgr/add-offset-to-txn [%s] retrying after %dms... (%d attempts remaining) (%s)\n",
 				t.transactionalID, backoff/time.Millisecond, attemptsRemaining, err)
+			t.coordinatorError(err)
 			time.Sleep(backoff)
 			attemptsRemaining--
 		}
@@ -332,9 +407,9 @@ func (t *transactionManager) publishOffsetsToTxn(offsets topicPartitionOffsets,
 		}
 		response, err := coordinator.AddOffsetsToTxn(request)
 		if err != nil {
-			// If an error occurred try to refresh current transaction coordinator.
+			// If an error occurred look up the transaction coordinator again.
 			_ = coordinator.Close()
-			_ = t.client.RefreshTransactionCoordinator(t.transactionalID)
+			invalidateTransactionCoordinator(t.client, t.transactionalID)
 			return true, err
 		}
 		if response == nil {
@@ -352,7 +427,7 @@ func (t *transactionManager) publishOffsetsToTxn(offsets topicPartitionOffsets,
 			fallthrough
 		case ErrNotCoordinatorForConsumer:
 			_ = coordinator.Close()
-			_ = t.client.RefreshTransactionCoordinator(t.transactionalID)
+			invalidateTransactionCoordinator(t.client, t.transactionalID)
 			fallthrough
 		case ErrOffsetsLoadInProgress:
 			fallthrough
@@ -390,6 +465,7 @@ func (t *transactionManager) publishOffsetsToTxn(offsets topicPartitionOffsets,
 			backoff := t.computeBackoff(attemptsRemaining)
 			Logger.Printf("txnmgr/txn-offset-commit [%s] retrying after %dms... (%d attempts remaining) (%s)\n",
 				t.transactionalID, backoff/time.Millisecond, attemptsRemaining, err)
+			t.coordinatorError(err)
 			time.Sleep(backoff)
 			attemptsRemaining--
 		}
@@ -417,7 +493,7 @@ func (t *transactionManager) publishOffsetsToTxn(offsets topicPartitionOffsets,
 		responses, err := consumerGroupCoordinator.TxnOffsetCommit(request)
 		if err != nil {
 			_ = consumerGroupCoordinator.Close()
-			_ = t.client.RefreshCoordinator(groupId)
+			invalidateCoordinator(t.client, groupId)
 			return resultOffsets, true, err
 		}
 
@@ -439,7 +515,7 @@ func (t *transactionManager) publishOffsetsToTxn(offsets topicPartitionOffsets,
 					fallthrough
 				case ErrNotCoordinatorForConsumer:
 					_ = consumerGroupCoordinator.Close()
-					_ = t.client.RefreshCoordinator(groupId)
+					invalidateCoordinator(t.client, groupId)
 					fallthrough
 				case ErrUnknownTopicOrPartition:
 					fallthrough
@@ -492,6 +568,12 @@ func (t *transactionManager) initProducerId() (int64, int16, error) {
 			// to resume after an INVALID_PRODUCER_EPOCH error
 			req.Version = 3
 		}
+		if t.twoPhaseCommit {
+			// Version 6 adds the support for two-phase commit (KIP-939).
+			req.Version = 6
+			req.Enable2Pc = true
+			req.KeepPreparedTxn = t.keepPreparedTxn
+		}
 		isEpochBump = t.producerID != noProducerID && t.producerEpoch != noProducerEpoch
 		t.coordinatorSupportsBumpingEpoch = true
 		req.ProducerID = t.producerID
@@ -529,6 +611,7 @@ func (t *transactionManager) initProducerId() (int64, int16, error) {
 			backoff := t.computeBackoff(attemptsRemaining)
 			Logger.Printf("txnmgr/init-producer-id [%s] retrying after %dms... (%d attempts remaining) (%s)\n",
 				t.transactionalID, backoff/time.Millisecond, attemptsRemaining, err)
+			t.coordinatorError(err)
 			time.Sleep(backoff)
 			attemptsRemaining--
 		}
@@ -549,7 +632,7 @@ func (t *transactionManager) initProducerId() (int64, int16, error) {
 		if err != nil {
 			if t.isTransactional() {
 				_ = coordinator.Close()
-				_ = t.client.RefreshTransactionCoordinator(t.transactionalID)
+				invalidateTransactionCoordinator(t.client, t.transactionalID)
 			}
 			return -1, -1, true, err
 		}
@@ -560,6 +643,22 @@ func (t *transactionManager) initProducerId() (int64, int16, error) {
 			if isEpochBump {
 				t.sequenceNumbers = make(map[string]int32)
 			}
+			if req.KeepPreparedTxn {
+				// the prepared transaction is only kept when starting.
+				t.keepPreparedTxn = false
+				if response.OngoingTxnProducerID != noProducerID {
+					t.preparedTxn = &PreparedTxnState{
+						ProducerID:    response.OngoingTxnProducerID,
+						ProducerEpoch: response.OngoingTxnProducerEpoch,
+					}
+					DebugLogger.Printf("txnmgr/init-producer-id [%s] kept the prepared transaction %+v\n",
+						t.transactionalID, *t.preparedTxn)
+					if err := t.transitionTo(ProducerTxnFlagPreparedTransaction, nil); err != nil {
+						return -1, -1, true, err
+					}
+					return response.ProducerID, response.ProducerEpoch, false, nil
+				}
+			}
 			err := t.transitionTo(ProducerTxnFlagReady, nil)
 			if err != nil {
 				return -1, -1, true, err
@@ -574,7 +673,7 @@ func (t *transactionManager) initProducerId() (int64, int16, error) {
 		case ErrNotCoordinatorForConsumer:
 			if t.isTransactional() {
 				_ = coordinator.Close()
-				_ = t.client.RefreshTransactionCoordinator(t.transactionalID)
+				invalidateTransactionCoordinator(t.client, t.transactionalID)
 			}
 		// Fatal errors
 		default:
@@ -607,11 +706,16 @@ func (t *transactionManager) completeTransaction() error {
 		}
 	}
 
+	t.statusLock.Lock()
 	t.lastError = nil
+	t.statusLock.Unlock()
 	t.epochBumpRequired = false
+	t.preparedTxn = nil
+	t.stateLock.Lock()
 	t.partitionsInCurrentTxn = topicPartitionSet{}
 	t.pendingPartitionsInCurrentTxn = topicPartitionSet{}
 	t.offsetsInCurrentTxn = map[string]topicPartitionOffsets{}
+	t.stateLock.Unlock()
 
 	return nil
 }
@@ -629,6 +733,7 @@ func (t *transactionManager) endTxn(commit bool) error {
 			backoff := t.computeBackoff(attemptsRemaining)
 			Logger.Printf("txnmgr/endtxn [%s] retrying after %dms... (%d attempts remaining) (%s)\n",
 				t.transactionalID, backoff/time.Millisecond, attemptsRemaining, err)
+			t.coordinatorError(err)
 			time.Sleep(backoff)
 			attemptsRemaining--
 		}
@@ -656,7 +761,7 @@ func (t *transactionManager) endTxn(commit bool) error {
 		if err != nil {
 			// Always retry on network error
 			_ = coordinator.Close()
-			_ = t.client.RefreshTransactionCoordinator(t.transactionalID)
+			invalidateTransactionCoordinator(t.client, t.transactionalID)
 			return true, err
 		}
 		if response == nil {
@@ -673,7 +778,7 @@ func (t *transactionManager) endTxn(commit bool) error {
 			fallthrough
 		case ErrNotCoordinatorForConsumer:
 			_ = coordinator.Close()
-			_ = t.client.RefreshTransactionCoordinator(t.transactionalID)
+			invalidateTransactionCoordinator(t.client, t.transactionalID)
 			fallthrough
 		case ErrOffsetsLoadInProgress:
 			fallthrough
@@ -704,21 +809,17 @@ func (t *transactionManager) finishTransaction(commit bool) error {
 		return t.lastError
 	}
 
-	// if no records has been sent don't do anything.
-	if len(t.partitionsInCurrentTxn) == 0 {
+	// if no records has been sent don't do anything,
+	// unless a transaction was kept prepared when starting.
+	if len(t.partitionsInCurrentTxn) == 0 && t.preparedTxn == nil {
 		return t.completeTransaction()
 	}
 
 	epochBump := t.epochBumpRequired
 	// If we're aborting the transaction, so there should be no need to add offsets.
-	if commit && len(t.offsetsInCurrentTxn) > 0 {
-		for group, offsets := range t.offsetsInCurrentTxn {
-			newOffsets, err := t.publishOffsetsToTxn(offsets, group)
-			if err != nil {
-				t.offsetsInCurrentTxn[group] = newOffsets
-				return err
-			}
-			delete(t.offsetsInCurrentTxn, group)
+	if commit {
+		if err := t.publishOffsets(); err != nil {
+			return err
 		}
 	}
 
@@ -739,6 +840,51 @@ func (t *transactionManager) finishTransaction(commit bool) error {
 	return t.initializeTransactions()
 }
 
+// publish the offsets added to the current transaction for each group.
+func (t *transactionManager) publishOffsets() error {
+	for group, offsets := range t.offsetsInCurrentTxn {
+		newOffsets, err := t.publishOffsetsToTxn(offsets, group)
+		t.stateLock.Lock()
+		if err != nil {
+			t.offsetsInCurrentTxn[group] = newOffsets
+			t.stateLock.Unlock()
+			return err
+		}
+		delete(t.offsetsInCurrentTxn, group)
+		t.stateLock.Unlock()
+	}
+	return nil
+}
+
+// publish the associated offsets of the transaction marked as prepared, for
+// the two-phase commit, which is ready to be committed once they are.
+func (t *transactionManager) prepareTransaction() (PreparedTxnState, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.currentTxnStatus()&ProducerTxnFlagInError != 0 {
+		return PreparedTxnState{}, t.lastError
+	}
+	if t.currentTxnStatus()&ProducerTxnFlagPreparedTransaction == 0 {
+		return PreparedTxnState{}, ErrTransactionNotReady
+	}
+
+	if err := t.publishOffsets(); err != nil {
+		return PreparedTxnState{}, err
+	}
+
+	t.preparedTxn = &PreparedTxnState{ProducerID: t.producerID, ProducerEpoch: t.producerEpoch}
+	return *t.preparedTxn, nil
+}
+
+// return true when the prepared transaction matches state, and so is to be
+// committed.
+func (t *transactionManager) isPreparedTxn(state PreparedTxnState) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.preparedTxn != nil && *t.preparedTxn == state
+}
+
 // called before sending any transactional record
 // won't do anything if current topic-partition is already added to transaction.
 func (t *transactionManager) maybeAddPartitionToCurrentTxn(topic string, partition int32) {
@@ -755,7 +901,9 @@ func (t *transactionManager) maybeAddPartitionToCurrentTxn(topic string, partiti
 		return
 	}
 
+	t.stateLock.Lock()
 	t.pendingPartitionsInCurrentTxn[tp] = struct{}{}
+	t.stateLock.Unlock()
 }
 
 // Makes a request to kafka to add a list of partitions ot the current transaction.
@@ -777,7 +925,9 @@ func (t *transactionManager) publishTxnPartitions() error {
 	// aborted anyway. In this case, we must be able to continue sending the batches which are in
 	// retry for partitions that were successfully added.
 	removeAllPartitionsOnFatalOrAbortedError := func() {
+		t.stateLock.Lock()
 		t.pendingPartitionsInCurrentTxn = topicPartitionSet{}
+		t.stateLock.Unlock()
 	}
 
 	// We only want to reduce the backoff when retrying the first AddPartition which errored out due to a
@@ -806,6 +956,7 @@ func (t *transactionManager) publishTxnPartitions() error {
 			}
 			backoff := computeBackoff(attemptsRemaining)
 			Logger.Printf("txnmgr/add-partition-to-txn retrying after %dms... (%d attempts remaining) (%s)\n", backoff/time.Millisecond, attemptsRemaining, err)
+			t.coordinatorError(err)
 			time.Sleep(backoff)
 			attemptsRemaining--
 		}
@@ -832,7 +983,7 @@ func (t *transactionManager) publishTxnPartitions() error {
 		addPartResponse, err := coordinator.AddPartitionsToTxn(request)
 		if err != nil {
 			_ = coordinator.Close()
-			_ = t.client.RefreshTransactionCoordinator(t.transactionalID)
+			invalidateTransactionCoordinator(t.client, t.transactionalID)
 			return true, err
 		}
 
@@ -848,14 +999,16 @@ func (t *transactionManager) publishTxnPartitions() error {
 				switch response.Err {
 				case ErrNoError:
 					// Mark partition as added to transaction
+					t.stateLock.Lock()
 					t.partitionsInCurrentTxn[tp] = struct{}{}
 					delete(t.pendingPartitionsInCurrentTxn, tp)
+					t.stateLock.Unlock()
 					continue
 				case ErrConsumerCoordinatorNotAvailable:
 					fallthrough
 				case ErrNotCoordinatorForConsumer:
 					_ = coordinator.Close()
-					_ = t.client.RefreshTransactionCoordinator(t.transactionalID)
+					invalidateTransactionCoordinator(t.client, t.transactionalID)
 					fallthrough
 				case ErrUnknownTopicOrPartition:
 					fallthrough
@@ -909,6 +1062,8 @@ func newTransactionManager(conf *Config, client Client) (*transactionManager, er
 	if conf.Producer.Idempotent {
 		txnmgr.transactionalID = conf.Producer.Transaction.ID
 		txnmgr.transactionTimeout = conf.Producer.Transaction.Timeout
+		txnmgr.twoPhaseCommit = conf.Producer.Transaction.TwoPhaseCommit
+		txnmgr.keepPreparedTxn = conf.Producer.Transaction.KeepPreparedTxn
 		txnmgr.sequenceNumbers = make(map[string]int32)
 		txnmgr.mutex = sync.Mutex{}
 
@@ -929,3 +1084,27 @@ func (t *transactionManager) initializeTransactions() (err error) {
 	t.producerID, t.producerEpoch, err = t.initProducerId()
 	return
 }
+
+// refreshProducerID re-inits the producer-id and producer-epoch of an idle
+// producer, outside of any transaction, so that the brokers keep its state.
+// The sequence numbers are reset as the producer-id or epoch changes.
+func (t *transactionManager) refreshProducerID() error {
+	if err := t.transitionTo(ProducerTxnFlagInitializing, nil); err != nil {
+		return err
+	}
+
+	pid, epoch, err := t.initProducerId()
+	if err != nil {
+		if t.currentTxnStatus() == ProducerTxnFlagInitializing {
+			// keep the current producer-id, which may still be valid
+			_ = t.transitionTo(ProducerTxnFlagReady, nil)
+		}
+		return err
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.producerID, t.producerEpoch = pid, epoch
+	t.sequenceNumbers = make(map[string]int32)
+	return nil
+}
@@ -0,0 +1,195 @@
+package sarama
+
+import (
+	"errors"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+// TxnStateReporter is implemented by the AsyncProducer and SyncProducer of
+// this package, and of the mocks package, able to report the state of their
+// transaction. It is separate from AsyncProducer and SyncProducer so that
+// their existing implementations keep compiling.
+type TxnStateReporter interface {
+	// TxnState returns a snapshot of the state of the current transaction,
+	// without waiting for the requests to the coordinators in progress.
+	TxnState() TxnState
+
+	// TxnEvents is the channel of the transitions of the transaction state
+	// when Producer.Transaction.Events is enabled, nil otherwise. It is closed
+	// when the producer shuts down.
+	TxnEvents() <-chan *TxnEvent
+}
+
+// TxnState is a snapshot of the state of a transactional producer, returned
+// by TxnStateReporter.TxnState to see why a transaction does not complete.
+type TxnState struct {
+	TransactionalID string
+	Status          ProducerTxnStatusFlag
+	// Partitions are the partitions added to the current transaction, and
+	// PendingPartitions the ones to add before sending their records.
+	Partitions        map[string][]int32
+	PendingPartitions map[string][]int32
+	// PendingOffsets are the offsets to commit with the current transaction,
+	// by consumer group.
+	PendingOffsets map[string]map[string][]*PartitionOffsetMetadata
+	// LastError is the error which set the producer in error, if any.
+	LastError error
+	// LastCoordinatorError is the last error of a request to the transaction
+	// or group coordinators, retried or not, and LastCoordinatorErrorTime
+	// when it occurred.
+	LastCoordinatorError     error
+	LastCoordinatorErrorTime time.Time
+}
+
+// TxnEventType is the type of a TxnEvent.
+type TxnEventType int
+
+const (
+	// TxnEventTransition is any transition not described by the other types.
+	TxnEventTransition TxnEventType = iota
+	// TxnEventBegin is the start of a transaction by BeginTxn.
+	TxnEventBegin
+	// TxnEventCommit and TxnEventAbort are the completion of a transaction.
+	TxnEventCommit
+	TxnEventAbort
+	// TxnEventFenced is the fencing of the producer by another producer
+	// with the same transactional ID.
+	TxnEventFenced
+	// TxnEventError is the transition to any other error state.
+	TxnEventError
+)
+
+func (t TxnEventType) String() string {
+	switch t {
+	case TxnEventBegin:
+		return "Begin"
+	case TxnEventCommit:
+		return "Commit"
+	case TxnEventAbort:
+		return "Abort"
+	case TxnEventFenced:
+		return "Fenced"
+	case TxnEventError:
+		return "Error"
+	default:
+		return "Transition"
+	}
+}
+
+// TxnEvent is a transition of the state of a transactional producer, sent
+// to the TxnEvents channel of its TxnStateReporter with
+// Producer.Transaction.Events.
+type TxnEvent struct {
+	Type     TxnEventType
+	From, To ProducerTxnStatusFlag
+	// Err is the error of the transitions to an error state.
+	Err  error
+	Time time.Time
+}
+
+func newTxnEvent(from, to ProducerTxnStatusFlag, err error) *TxnEvent {
+	event := &TxnEvent{From: from, To: to, Err: err, Time: time.Now()}
+	switch {
+	case errors.Is(err, ErrProducerFenced) || errors.Is(err, ErrInvalidProducerEpoch):
+		event.Type = TxnEventFenced
+	case to&ProducerTxnFlagInError != 0:
+		event.Type = TxnEventError
+	case to&ProducerTxnFlagInTransaction != 0:
+		event.Type = TxnEventBegin
+	case from&ProducerTxnFlagCommittingTransaction != 0 && to&(ProducerTxnFlagReady|ProducerTxnFlagInitializing) != 0:
+		event.Type = TxnEventCommit
+	case from&ProducerTxnFlagAbortingTransaction != 0 && to&(ProducerTxnFlagReady|ProducerTxnFlagInitializing) != 0:
+		event.Type = TxnEventAbort
+	}
+	return event
+}
+
+// state returns a snapshot of the state of the transaction manager, without
+// waiting for the requests in progress.
+func (t *transactionManager) state() TxnState {
+	t.statusLock.RLock()
+	state := TxnState{
+		TransactionalID: t.transactionalID,
+		Status:          t.status,
+		LastError:       t.lastError,
+	}
+	t.statusLock.RUnlock()
+
+	t.stateLock.Lock()
+	defer t.stateLock.Unlock()
+	state.Partitions = t.partitionsInCurrentTxn.mapToRequest()
+	state.PendingPartitions = t.pendingPartitionsInCurrentTxn.mapToRequest()
+	state.PendingOffsets = make(map[string]map[string][]*PartitionOffsetMetadata, len(t.offsetsInCurrentTxn))
+	for group, offsets := range t.offsetsInCurrentTxn {
+		state.PendingOffsets[group] = offsets.mapToRequest()
+	}
+	state.LastCoordinatorError = t.lastCoordinatorError
+	state.LastCoordinatorErrorTime = t.lastCoordinatorErrorTime
+	return state
+}
+
+// coordinatorError records an error of a request to a coordinator.
+func (t *transactionManager) coordinatorError(err error) {
+	t.stateLock.Lock()
+	t.lastCoordinatorError = err
+	t.lastCoordinatorErrorTime = time.Now()
+	t.stateLock.Unlock()
+
+	if t.onCoordinatorError != nil {
+		t.onCoordinatorError(err)
+	}
+}
+
+// observeTxn updates the transaction metrics and sends the transaction
+// events of the transaction manager, which may be replaced on epoch
+// exhaustion.
+func (p *asyncProducer) observeTxn(txnmgr *transactionManager) {
+	if !txnmgr.isTransactional() {
+		return
+	}
+	coordinatorErrors := metrics.GetOrRegisterMeter("txn-coordinator-error-rate", p.metricsRegistry)
+	txnmgr.onCoordinatorError = func(error) {
+		coordinatorErrors.Mark(1)
+	}
+	txnmgr.onTransition = func(from, to ProducerTxnStatusFlag, err error) {
+		event := newTxnEvent(from, to, err)
+		switch event.Type {
+		case TxnEventCommit:
+			metrics.GetOrRegisterMeter("txn-commit-rate", p.metricsRegistry).Mark(1)
+		case TxnEventAbort:
+			metrics.GetOrRegisterMeter("txn-abort-rate", p.metricsRegistry).Mark(1)
+		case TxnEventFenced, TxnEventError:
+			metrics.GetOrRegisterMeter("txn-error-rate", p.metricsRegistry).Mark(1)
+		}
+		p.sendTxnEvent(event)
+	}
+}
+
+// sendTxnEvent sends the event to the TxnEvents channel, unless it is full
+// or closed.
+func (p *asyncProducer) sendTxnEvent(event *TxnEvent) {
+	if p.txnEvents == nil {
+		return
+	}
+	p.txnEventsLock.Lock()
+	defer p.txnEventsLock.Unlock()
+	if p.txnEventsClosed {
+		return
+	}
+	select {
+	case p.txnEvents <- event:
+	default:
+		DebugLogger.Printf("producer/txnmgr [%s] dropped the %s event from %s to %s, the TxnEvents channel is full\n",
+			p.conf.Producer.Transaction.ID, event.Type, event.From, event.To)
+	}
+}
+
+func (p *asyncProducer) TxnState() TxnState {
+	return p.txnmgr.state()
+}
+
+func (p *asyncProducer) TxnEvents() <-chan *TxnEvent {
+	return p.txnEvents
+}
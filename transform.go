@@ -0,0 +1,158 @@
+package sarama
+
+import "fmt"
+
+// MessageTransform transforms the payload of the messages symmetrically: Produce
+// is applied to the messages produced, and Consume undoes it on the messages
+// consumed, so that cross-cutting payload concerns, such as compression,
+// encryption, annotation or validation, are implemented in one place.
+//
+// The transforms of Config.Transforms form a chain: Produce is called in the
+// order of the chain, after the producer interceptors, and Consume in the
+// reverse order, before the consumer interceptors. A chain compressing then
+// encrypting the messages produced thus decrypts then decompresses the messages
+// consumed.
+type MessageTransform interface {
+	// Produce transforms the payload of a message before it is produced. An
+	// error fails the message with a TransformError.
+	Produce(*MessagePayload) error
+
+	// Consume transforms the payload of a consumed message before it is
+	// returned. An error drops the message, and is returned as a ConsumerError
+	// wrapping a TransformError.
+	Consume(*MessagePayload) error
+}
+
+// MessagePayload is the payload of a message seen by a MessageTransform, which
+// may modify it in place.
+type MessagePayload struct {
+	Topic string
+	// Partition is the partition of a consumed message. The partition of a
+	// produced message is not known yet when it is transformed, and is -1.
+	Partition int32
+	Key       []byte
+	Value     []byte
+	Headers   []RecordHeader
+}
+
+// MessageTransformFuncs is an adapter allowing functions to be used as a
+// MessageTransform. A nil function leaves the payload as is.
+type MessageTransformFuncs struct {
+	ProduceFunc func(*MessagePayload) error
+	ConsumeFunc func(*MessagePayload) error
+}
+
+// Produce calls ProduceFunc.
+func (f MessageTransformFuncs) Produce(p *MessagePayload) error {
+	if f.ProduceFunc == nil {
+		return nil
+	}
+	return f.ProduceFunc(p)
+}
+
+// Consume calls ConsumeFunc.
+func (f MessageTransformFuncs) Consume(p *MessagePayload) error {
+	if f.ConsumeFunc == nil {
+		return nil
+	}
+	return f.ConsumeFunc(p)
+}
+
+// TransformError is the error returned when a MessageTransform fails.
+type TransformError struct {
+	// Index is the index of the transform in Config.Transforms.
+	Index int
+	// Offset is the offset of the consumed message, or -1 for a produced message.
+	Offset int64
+	Err    error
+}
+
+func (err TransformError) Error() string {
+	if err.Offset < 0 {
+		return fmt.Sprintf("kafka: message transform %d failed: %v", err.Index, err.Err)
+	}
+	return fmt.Sprintf("kafka: message transform %d failed at offset %d: %v", err.Index, err.Offset, err.Err)
+}
+
+func (err TransformError) Unwrap() error {
+	return err.Err
+}
+
+// applyTransforms applies the Produce transforms of the chain to the message,
+// replacing its key, value and headers with the transformed ones.
+func (msg *ProducerMessage) applyTransforms(transforms []MessageTransform) error {
+	if len(transforms) == 0 {
+		return nil
+	}
+
+	payload := &MessagePayload{Topic: msg.Topic, Partition: -1, Headers: msg.Headers}
+	var err error
+	if msg.Key != nil {
+		if payload.Key, err = msg.Key.Encode(); err != nil {
+			return err
+		}
+	}
+	if msg.Value != nil {
+		if payload.Value, err = msg.Value.Encode(); err != nil {
+			return err
+		}
+	}
+
+	for i, transform := range transforms {
+		if err := safelyTransform(transform.Produce, payload); err != nil {
+			return TransformError{Index: i, Offset: -1, Err: err}
+		}
+	}
+
+	msg.Key, msg.Value = nil, nil
+	if payload.Key != nil {
+		msg.Key = ByteEncoder(payload.Key)
+	}
+	if payload.Value != nil {
+		msg.Value = ByteEncoder(payload.Value)
+	}
+	msg.Headers = payload.Headers
+	return nil
+}
+
+// applyTransforms applies the Consume transforms of the chain, in reverse
+// order, to the message, replacing its key, value and headers with the
+// transformed ones.
+func (msg *ConsumerMessage) applyTransforms(transforms []MessageTransform) error {
+	if len(transforms) == 0 {
+		return nil
+	}
+
+	payload := &MessagePayload{Topic: msg.Topic, Partition: msg.Partition, Key: msg.Key, Value: msg.Value}
+	if msg.Headers != nil {
+		payload.Headers = make([]RecordHeader, len(msg.Headers))
+		for i, h := range msg.Headers {
+			payload.Headers[i] = *h
+		}
+	}
+
+	for i := len(transforms) - 1; i >= 0; i-- {
+		if err := safelyTransform(transforms[i].Consume, payload); err != nil {
+			return TransformError{Index: i, Offset: msg.Offset, Err: err}
+		}
+	}
+
+	msg.Key, msg.Value = payload.Key, payload.Value
+	msg.Headers = nil
+	if payload.Headers != nil {
+		msg.Headers = make([]*RecordHeader, len(payload.Headers))
+		for i := range payload.Headers {
+			msg.Headers[i] = &payload.Headers[i]
+		}
+	}
+	return nil
+}
+
+func safelyTransform(transform func(*MessagePayload) error, payload *MessagePayload) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return transform(payload)
+}
@@ -0,0 +1,243 @@
+package sarama
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// xorTransform "encrypts" the values of the messages, and records the order in
+// which the transforms are applied.
+func xorTransform(name string, key byte, calls *[]string) MessageTransform {
+	xor := func(p *MessagePayload) {
+		for i := range p.Value {
+			p.Value[i] ^= key
+		}
+	}
+	return MessageTransformFuncs{
+		ProduceFunc: func(p *MessagePayload) error {
+			*calls = append(*calls, "produce "+name)
+			xor(p)
+			p.Headers = append(p.Headers, RecordHeader{Key: []byte("transform"), Value: []byte(name)})
+			return nil
+		},
+		ConsumeFunc: func(p *MessagePayload) error {
+			*calls = append(*calls, "consume "+name)
+			if len(p.Headers) == 0 || string(p.Headers[len(p.Headers)-1].Value) != name {
+				return errors.New("missing transform header")
+			}
+			p.Headers = p.Headers[:len(p.Headers)-1]
+			xor(p)
+			return nil
+		},
+	}
+}
+
+func TestMessageTransformsRoundTrip(t *testing.T) {
+	var calls []string
+	transforms := []MessageTransform{xorTransform("a", 0x0f, &calls), xorTransform("b", 0xf0, &calls)}
+
+	produced := &ProducerMessage{Topic: "my_topic", Key: StringEncoder("key"), Value: StringEncoder("value")}
+	if err := produced.applyTransforms(transforms); err != nil {
+		t.Fatal(err)
+	}
+	value, _ := produced.Value.Encode()
+	if bytes.Equal(value, []byte("value")) || len(produced.Headers) != 2 {
+		t.Fatalf("expected the message to be transformed, got %q %v", value, produced.Headers)
+	}
+
+	key, _ := produced.Key.Encode()
+	consumed := &ConsumerMessage{Topic: "my_topic", Key: key, Value: value}
+	for i := range produced.Headers {
+		consumed.Headers = append(consumed.Headers, &produced.Headers[i])
+	}
+	if err := consumed.applyTransforms(transforms); err != nil {
+		t.Fatal(err)
+	}
+	if string(consumed.Key) != "key" || string(consumed.Value) != "value" || len(consumed.Headers) != 0 {
+		t.Errorf("expected the transforms to be undone, got %q %q %v", consumed.Key, consumed.Value, consumed.Headers)
+	}
+
+	expected := []string{"produce a", "produce b", "consume b", "consume a"}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Errorf("expected the transforms to be applied in order %v, got %v", expected, calls)
+	}
+}
+
+func TestMessageTransformsErrors(t *testing.T) {
+	failure := errors.New("failure")
+	transforms := []MessageTransform{
+		MessageTransformFuncs{},
+		MessageTransformFuncs{
+			ProduceFunc: func(*MessagePayload) error { return failure },
+			ConsumeFunc: func(*MessagePayload) error { panic("boom") },
+		},
+	}
+
+	err := (&ProducerMessage{Value: StringEncoder("value")}).applyTransforms(transforms)
+	var transformErr TransformError
+	if !errors.As(err, &transformErr) || transformErr.Index != 1 || transformErr.Offset != -1 || !errors.Is(err, failure) {
+		t.Error("expected the produce transform to fail, got", err)
+	}
+
+	err = (&ConsumerMessage{Offset: 42, Value: []byte("value")}).applyTransforms(transforms)
+	if !errors.As(err, &transformErr) || transformErr.Index != 1 || transformErr.Offset != 42 {
+		t.Error("expected the panic of the consume transform to be recovered, got", err)
+	}
+}
+
+func TestAsyncProducerTransforms(t *testing.T) {
+	seedBroker := NewMockBroker(t, 1)
+	defer seedBroker.Close()
+	leader := NewMockBroker(t, 2)
+	defer leader.Close()
+	metadataLeader := new(MetadataResponse)
+	metadataLeader.AddBroker(leader.Addr(), leader.BrokerID())
+	metadataLeader.AddTopicPartition("my_topic", 0, leader.BrokerID(), nil, nil, nil, ErrNoError)
+	seedBroker.Returns(metadataLeader)
+	prodSuccess := new(ProduceResponse)
+	prodSuccess.AddTopicPartition("my_topic", 0, ErrNoError)
+	leader.Returns(prodSuccess)
+
+	config := NewTestConfig()
+	config.Producer.Return.Successes = true
+	config.Transforms = []MessageTransform{MessageTransformFuncs{
+		ProduceFunc: func(p *MessagePayload) error {
+			if string(p.Value) == "invalid" {
+				return errors.New("invalid message")
+			}
+			p.Value = bytes.ToUpper(p.Value)
+			return nil
+		},
+	}}
+	producer, err := NewAsyncProducer([]string{seedBroker.Addr()}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	producer.Input() <- &ProducerMessage{Topic: "my_topic", Value: StringEncoder("invalid")}
+	if pErr := <-producer.Errors(); !errors.As(pErr.Err, &TransformError{}) {
+		t.Error("expected a TransformError, got", pErr.Err)
+	}
+
+	producer.Input() <- &ProducerMessage{Topic: "my_topic", Value: StringEncoder("valid")}
+	select {
+	case pErr := <-producer.Errors():
+		t.Error(pErr.Err)
+	case msg := <-producer.Successes():
+		if value, _ := msg.Value.Encode(); string(value) != "VALID" {
+			t.Errorf("expected the transformed value to be produced, got %q", value)
+		}
+	}
+	closeProducer(t, producer)
+}
+
+func TestConsumerTransforms(t *testing.T) {
+	broker0 := NewMockBroker(t, 0)
+	defer broker0.Close()
+	mockFetchResponse := NewMockFetchResponse(t, 1)
+	for i := 0; i < 3; i++ {
+		mockFetchResponse.SetMessage("my_topic", 0, int64(i), StringEncoder("value"))
+	}
+	broker0.SetHandlerByMap(map[string]MockResponse{
+		"MetadataRequest": NewMockMetadataResponse(t).
+			SetBroker(broker0.Addr(), broker0.BrokerID()).
+			SetLeader("my_topic", 0, broker0.BrokerID()),
+		"OffsetRequest": NewMockOffsetResponse(t).
+			SetOffset("my_topic", 0, OffsetOldest, 0).
+			SetOffset("my_topic", 0, OffsetNewest, 3),
+		"FetchRequest": mockFetchResponse,
+	})
+
+	config := NewTestConfig()
+	config.Consumer.Return.Errors = true
+	config.Consumer.Interceptors = []ConsumerInterceptor{&appendInterceptor{}}
+	config.Transforms = []MessageTransform{MessageTransformFuncs{
+		ConsumeFunc: func(p *MessagePayload) error {
+			if p.Topic != "my_topic" || p.Partition != 0 {
+				t.Errorf("unexpected payload of %s/%d", p.Topic, p.Partition)
+			}
+			p.Value = bytes.ToUpper(p.Value)
+			return nil
+		},
+	}, MessageTransformFuncs{
+		ConsumeFunc: func(p *MessagePayload) error {
+			if string(p.Value) == "value" && len(p.Headers) == 0 && p.Key == nil {
+				return nil
+			}
+			return errors.New("unexpected payload")
+		},
+	}}
+	master, err := NewConsumer([]string{broker0.Addr()}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	consumer, err := master.ConsumePartition("my_topic", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The transforms are applied in reverse order, before the interceptors.
+	for i := 0; i < 3; i++ {
+		select {
+		case msg := <-consumer.Messages():
+			if expected := "VALUE" + string(rune('0'+i)); string(msg.Value) != expected {
+				t.Errorf("expected %q, got %q", expected, msg.Value)
+			}
+		case err := <-consumer.Errors():
+			t.Error(err)
+		}
+	}
+	safeClose(t, consumer)
+	safeClose(t, master)
+}
+
+func TestConsumerTransformErrors(t *testing.T) {
+	broker0 := NewMockBroker(t, 0)
+	defer broker0.Close()
+	mockFetchResponse := NewMockFetchResponse(t, 1)
+	for i := 0; i < 3; i++ {
+		mockFetchResponse.SetMessage("my_topic", 0, int64(i), StringEncoder("value"))
+	}
+	broker0.SetHandlerByMap(map[string]MockResponse{
+		"MetadataRequest": NewMockMetadataResponse(t).
+			SetBroker(broker0.Addr(), broker0.BrokerID()).
+			SetLeader("my_topic", 0, broker0.BrokerID()),
+		"OffsetRequest": NewMockOffsetResponse(t).
+			SetOffset("my_topic", 0, OffsetOldest, 0).
+			SetOffset("my_topic", 0, OffsetNewest, 3),
+		"FetchRequest": mockFetchResponse,
+	})
+
+	config := NewTestConfig()
+	config.Consumer.Return.Errors = true
+	config.Transforms = []MessageTransform{MessageTransformFuncs{
+		ConsumeFunc: func(p *MessagePayload) error {
+			return errors.New("corrupt message")
+		},
+	}}
+	master, err := NewConsumer([]string{broker0.Addr()}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	consumer, err := master.ConsumePartition("my_topic", 0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The messages failing to be transformed are dropped.
+	for i := int64(1); i < 3; i++ {
+		select {
+		case msg := <-consumer.Messages():
+			t.Error("expected the message to be dropped, got offset", msg.Offset)
+		case err := <-consumer.Errors():
+			var transformErr TransformError
+			if !errors.As(err, &transformErr) || transformErr.Offset != i {
+				t.Errorf("expected the transform of offset %d to fail, got %v", i, err)
+			}
+		}
+	}
+	safeClose(t, consumer)
+	safeClose(t, master)
+}
@@ -0,0 +1,226 @@
+package sarama
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// SequenceHeader is the header of the sequence numbers of the messages
+// produced with Producer.Verification enabled. Its value is the ID of the
+// producer and the sequence number of the message in its partition, separated
+// by a colon, such as "3f2a9c1e07b4d568:42".
+const SequenceHeader = "sarama-seq"
+
+// newVerificationID returns a random producer ID for the sequence headers.
+func newVerificationID() string {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		Logger.Println("producer/verification failed to generate an ID:", err)
+	}
+	return hex.EncodeToString(id)
+}
+
+// stampSequence adds the sequence header of the producer id to msg.
+func stampSequence(msg *ProducerMessage, id string, sequence int64) {
+	msg.Headers = append(msg.Headers, RecordHeader{
+		Key:   []byte(SequenceHeader),
+		Value: []byte(id + ":" + strconv.FormatInt(sequence, 10)),
+	})
+}
+
+// ParseSequence returns the producer ID and the sequence number of the
+// SequenceHeader of headers, and false if it has none.
+func ParseSequence(headers []*RecordHeader) (producer string, sequence int64, ok bool) {
+	for _, header := range headers {
+		if header == nil || string(header.Key) != SequenceHeader {
+			continue
+		}
+		value := string(header.Value)
+		sep := strings.LastIndexByte(value, ':')
+		if sep < 0 {
+			return "", 0, false
+		}
+		sequence, err := strconv.ParseInt(value[sep+1:], 10, 64)
+		if err != nil {
+			return "", 0, false
+		}
+		return value[:sep], sequence, true
+	}
+	return "", 0, false
+}
+
+// SequenceErrorKind is the kind of anomaly reported by a SequenceError.
+type SequenceErrorKind int
+
+const (
+	// SequenceGap is reported for a message whose sequence number is past the
+	// next expected one: the messages in between were lost, or are reordered.
+	SequenceGap SequenceErrorKind = iota
+	// SequenceDuplicate is reported for a message whose sequence number was
+	// already consumed.
+	SequenceDuplicate
+	// SequenceReordered is reported for a message consumed after messages of
+	// higher sequence numbers, which reported it as missing.
+	SequenceReordered
+)
+
+func (k SequenceErrorKind) String() string {
+	switch k {
+	case SequenceGap:
+		return "gap"
+	case SequenceDuplicate:
+		return "duplicate"
+	case SequenceReordered:
+		return "reordered"
+	}
+	return fmt.Sprintf("SequenceErrorKind(%d)", int(k))
+}
+
+// SequenceError is the error returned by SequenceChecker.Check for a message
+// out of sequence.
+type SequenceError struct {
+	Kind      SequenceErrorKind
+	Topic     string
+	Partition int32
+	Offset    int64
+	// Producer is the ID of the producer of the message.
+	Producer string
+	// Sequence is the sequence number of the message, and Expected the next
+	// one expected from the producer.
+	Sequence, Expected int64
+}
+
+func (e *SequenceError) Error() string {
+	switch e.Kind {
+	case SequenceGap:
+		return fmt.Sprintf("kafka: %d messages of producer %s missing before offset %d of %s/%d (expected sequence %d, got %d)",
+			e.Sequence-e.Expected, e.Producer, e.Offset, e.Topic, e.Partition, e.Expected, e.Sequence)
+	default:
+		return fmt.Sprintf("kafka: %s message of producer %s at offset %d of %s/%d (expected sequence %d, got %d)",
+			e.Kind, e.Producer, e.Offset, e.Topic, e.Partition, e.Expected, e.Sequence)
+	}
+}
+
+// SequenceStats are the counts of the messages checked by a SequenceChecker.
+type SequenceStats struct {
+	// Messages is the number of messages with a sequence header checked.
+	Messages int64
+	// Missing is the number of messages reported missing by the gaps and not
+	// consumed since.
+	Missing int64
+	// Duplicates and Reordered are the numbers of duplicate and reordered
+	// messages.
+	Duplicates, Reordered int64
+}
+
+type sequenceKey struct {
+	topic     string
+	partition int32
+	producer  string
+}
+
+// sequenceState is the sequence of a producer in a partition: the next
+// sequence number expected, and the ranges of the missing ones before it.
+type sequenceState struct {
+	next    int64
+	missing [][2]int64 // [first, last] ranges in increasing order
+}
+
+// SequenceChecker checks the sequence numbers stamped by the producers with
+// Producer.Verification enabled on the messages consumed, detecting their
+// loss, duplication or reordering. The first message of each producer and
+// partition starts its sequence, so that the consumption may start at any
+// offset. It is safe for concurrent use.
+type SequenceChecker struct {
+	lock   sync.Mutex
+	states map[sequenceKey]*sequenceState
+	stats  SequenceStats
+}
+
+// NewSequenceChecker returns a new SequenceChecker.
+func NewSequenceChecker() *SequenceChecker {
+	return &SequenceChecker{states: make(map[sequenceKey]*sequenceState)}
+}
+
+// Check checks the sequence number of msg, returning a *SequenceError if it is
+// not the next one expected from its producer in its partition. The messages
+// without a sequence header are ignored.
+func (c *SequenceChecker) Check(msg *ConsumerMessage) error {
+	producer, sequence, ok := ParseSequence(msg.Headers)
+	if !ok {
+		return nil
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.stats.Messages++
+
+	key := sequenceKey{msg.Topic, msg.Partition, producer}
+	state := c.states[key]
+	if state == nil {
+		c.states[key] = &sequenceState{next: sequence + 1}
+		return nil
+	}
+
+	seqErr := &SequenceError{
+		Topic:     msg.Topic,
+		Partition: msg.Partition,
+		Offset:    msg.Offset,
+		Producer:  producer,
+		Sequence:  sequence,
+		Expected:  state.next,
+	}
+	switch {
+	case sequence == state.next:
+		state.next++
+		return nil
+	case sequence > state.next:
+		seqErr.Kind = SequenceGap
+		state.missing = append(state.missing, [2]int64{state.next, sequence - 1})
+		c.stats.Missing += sequence - state.next
+		state.next = sequence + 1
+	case state.found(sequence):
+		seqErr.Kind = SequenceReordered
+		c.stats.Missing--
+		c.stats.Reordered++
+	default:
+		seqErr.Kind = SequenceDuplicate
+		c.stats.Duplicates++
+	}
+	return seqErr
+}
+
+// Stats returns the counts of the messages checked so far.
+func (c *SequenceChecker) Stats() SequenceStats {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.stats
+}
+
+// found removes sequence from the missing ranges, returning false if it was
+// not missing.
+func (s *sequenceState) found(sequence int64) bool {
+	for i, r := range s.missing {
+		if sequence < r[0] || sequence > r[1] {
+			continue
+		}
+		switch {
+		case r[0] == r[1]:
+			s.missing = append(s.missing[:i], s.missing[i+1:]...)
+		case sequence == r[0]:
+			s.missing[i][0]++
+		case sequence == r[1]:
+			s.missing[i][1]--
+		default:
+			s.missing = append(s.missing[:i+1], s.missing[i:]...)
+			s.missing[i] = [2]int64{r[0], sequence - 1}
+			s.missing[i+1] = [2]int64{sequence + 1, r[1]}
+		}
+		return true
+	}
+	return false
+}
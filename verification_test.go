@@ -0,0 +1,116 @@
+package sarama
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+)
+
+func sequenced(producer string, sequence int64, offset int64) *ConsumerMessage {
+	return &ConsumerMessage{
+		Topic:     "my_topic",
+		Partition: 0,
+		Offset:    offset,
+		Headers: []*RecordHeader{{
+			Key:   []byte(SequenceHeader),
+			Value: []byte(producer + ":" + strconv.FormatInt(sequence, 10)),
+		}},
+	}
+}
+
+func TestParseSequence(t *testing.T) {
+	producer, sequence, ok := ParseSequence(sequenced("a:b", 42, 0).Headers)
+	if !ok || producer != "a:b" || sequence != 42 {
+		t.Errorf("unexpected sequence %q %d %v", producer, sequence, ok)
+	}
+	if _, _, ok := ParseSequence([]*RecordHeader{{Key: []byte(SequenceHeader), Value: []byte("42")}}); ok {
+		t.Error("expected a sequence without a producer to be invalid")
+	}
+	if _, _, ok := ParseSequence(nil); ok {
+		t.Error("expected no sequence")
+	}
+}
+
+func TestSequenceChecker(t *testing.T) {
+	checker := NewSequenceChecker()
+	expectKind := func(msg *ConsumerMessage, kind SequenceErrorKind) {
+		t.Helper()
+		var seqErr *SequenceError
+		if err := checker.Check(msg); !errors.As(err, &seqErr) || seqErr.Kind != kind {
+			t.Errorf("expected a %s error, got %v", kind, err)
+		}
+	}
+
+	// the first message starts the sequence
+	for i, sequence := range []int64{5, 6, 7} {
+		if err := checker.Check(sequenced("a", sequence, int64(i))); err != nil {
+			t.Error(err)
+		}
+	}
+	// the sequences of the producers are independent
+	if err := checker.Check(sequenced("b", 0, 3)); err != nil {
+		t.Error(err)
+	}
+	if err := checker.Check(&ConsumerMessage{Topic: "my_topic", Offset: 4}); err != nil {
+		t.Error(err)
+	}
+
+	expectKind(sequenced("a", 11, 5), SequenceGap) // 8, 9 and 10 missing
+	expectKind(sequenced("a", 9, 6), SequenceReordered)
+	expectKind(sequenced("a", 9, 7), SequenceDuplicate)
+	expectKind(sequenced("a", 6, 8), SequenceDuplicate)
+	if err := checker.Check(sequenced("a", 12, 9)); err != nil {
+		t.Error(err)
+	}
+
+	expected := SequenceStats{Messages: 9, Missing: 2, Duplicates: 2, Reordered: 1}
+	if stats := checker.Stats(); stats != expected {
+		t.Errorf("expected stats %+v, got %+v", expected, stats)
+	}
+}
+
+func TestAsyncProducerVerification(t *testing.T) {
+	seedBroker := NewMockBroker(t, 1)
+	defer seedBroker.Close()
+	leader := NewMockBroker(t, 2)
+	defer leader.Close()
+
+	metadataLeader := new(MetadataResponse)
+	metadataLeader.Version = 4
+	metadataLeader.AddBroker(leader.Addr(), leader.BrokerID())
+	metadataLeader.AddTopicPartition("my_topic", 0, leader.BrokerID(), nil, nil, nil, ErrNoError)
+	seedBroker.Returns(metadataLeader)
+
+	config := NewTestConfig()
+	config.Version = V0_11_0_0
+	config.Producer.Flush.Messages = 3
+	config.Producer.Return.Successes = true
+	config.Producer.Verification.Enable = true
+	config.Producer.Verification.ID = "verifier"
+	producer, err := NewAsyncProducer([]string{seedBroker.Addr()}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeProducer(t, producer)
+
+	for i := 0; i < 3; i++ {
+		producer.Input() <- &ProducerMessage{Topic: "my_topic", Value: StringEncoder(TestMessage)}
+	}
+
+	prodSuccess := new(ProduceResponse)
+	prodSuccess.Version = 3
+	prodSuccess.AddTopicPartition("my_topic", 0, ErrNoError)
+	leader.Returns(prodSuccess)
+
+	for i := 0; i < 3; i++ {
+		select {
+		case msg := <-producer.Errors():
+			t.Fatal(msg.Err)
+		case msg := <-producer.Successes():
+			if len(msg.Headers) != 1 || string(msg.Headers[0].Key) != SequenceHeader ||
+				string(msg.Headers[0].Value) != "verifier:"+strconv.Itoa(i) {
+				t.Errorf("unexpected headers %v of message %d", msg.Headers, i)
+			}
+		}
+	}
+}